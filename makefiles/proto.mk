@@ -4,6 +4,7 @@
 # Configuration
 PROTO_DIR := microservices-demo/protos
 PROTO_FILE := $(PROTO_DIR)/demo.proto
+SHARED_PROTO_FILE := $(PROTO_DIR)/coveragecontrol.proto
 
 # Services that need proto generation
 SERVICES_WITH_PROTOS := productcatalogservice checkoutservice emailservice \
@@ -12,11 +13,11 @@ SERVICES_WITH_PROTOS := productcatalogservice checkoutservice emailservice \
 
 ##@ Proto Generation
 
-.PHONY: proto-all proto-clean proto-go proto-python
+.PHONY: proto-all proto-clean proto-go proto-python proto-shared
 
 proto-all: proto-go proto-python ## Generate all protobuf code
 
-proto-go: $(SERVICES_WITH_PROTOS:%=microservices-demo/src/%/genproto/demo_pb.go) ## Generate Go protobuf code
+proto-go: $(SERVICES_WITH_PROTOS:%=microservices-demo/src/%/genproto/demo_pb.go) proto-shared ## Generate Go protobuf code
 
 proto-python: test-framework/generated/demo_pb2.py ## Generate Python protobuf code (handled by test.mk)
 
@@ -31,10 +32,21 @@ microservices-demo/src/%/genproto/demo_pb.go: $(PROTO_FILE)
 		       ../../protos/demo.proto
 	@echo "✓ Generated proto code for $*"
 
+proto-shared: $(SHARED_PROTO_FILE) ## Generate Go protobuf code for the shared module
+	@echo "Generating Go proto code for shared..."
+	@mkdir -p microservices-demo/src/shared/genproto
+	@cd microservices-demo/src/shared && \
+		protoc --proto_path=../../protos \
+		       --go_out=./genproto --go_opt=paths=source_relative \
+		       --go-grpc_out=./genproto --go-grpc_opt=paths=source_relative \
+		       ../../protos/coveragecontrol.proto
+	@echo "✓ Generated proto code for shared"
+
 proto-clean: ## Clean all generated protobuf code
 	@echo "Cleaning generated proto code..."
 	@for svc in $(SERVICES_WITH_PROTOS); do \
 		rm -rf microservices-demo/src/$$svc/genproto; \
 	done
+	@rm -rf microservices-demo/src/shared/genproto
 	@rm -rf test-framework/generated
 	@echo "✓ Proto code cleaned"
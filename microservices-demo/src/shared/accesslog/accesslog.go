@@ -0,0 +1,262 @@
+// Package accesslog provides gRPC server interceptors that write one access
+// log entry per call, with the method, peer, latency and status code, and
+// for a sampled share of the calls of chosen methods the request and
+// response payloads, with card numbers, emails and other PII redacted by a
+// shared.Redactor.
+//
+// Which methods are logged, and how often with payloads, is set by rules,
+// read from ACCESS_LOG_RULES unless WithRules is given:
+//
+//	ACCESS_LOG_RULES="/hipstershop.CheckoutService/PlaceOrder=1,/hipstershop.PaymentService/*=0.1,/hipstershop.ProductCatalogService/*=off"
+//
+// Each rule is a full method name, a "/package.Service/*" wildcard or "*",
+// followed by the fraction of calls to log with payloads or "off" to skip
+// the methods entirely. The most specific rule wins. Methods without a rule
+// are logged without payloads, and health checks are off unless a rule
+// names them.
+//
+// Usage:
+//
+//	srv := grpcserver.New(grpcserver.WithUnaryInterceptors(
+//	    accesslog.UnaryServerInterceptor(log, accesslog.WithRedactedFields("phone"))))
+package accesslog
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"math/rand/v2"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+
+	shared "github.com/GoogleCloudPlatform/microservices-demo/src/shared"
+)
+
+// EnvRules is the environment variable holding the default rules.
+const EnvRules = "ACCESS_LOG_RULES"
+
+// MaxPayloadBytes bounds each logged payload; longer ones are truncated.
+const MaxPayloadBytes = 4096
+
+// Rule controls the access log of the methods matching Method.
+type Rule struct {
+	// Method is a full method name, "/package.Service/*" or "*".
+	Method string
+	// Off skips the matching methods.
+	Off bool
+	// PayloadSampleRate is the fraction, from 0 to 1, of calls logged with
+	// their request and response.
+	PayloadSampleRate float64
+}
+
+// defaultRules keep health probes out of the access log.
+var defaultRules = []Rule{{Method: "/grpc.health.v1.Health/*", Off: true}}
+
+// ParseRules parses the ACCESS_LOG_RULES format: comma-separated
+// method=rate or method=off entries.
+func ParseRules(s string) ([]Rule, error) {
+	var rules []Rule
+	for _, entry := range strings.Split(s, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		method, value, ok := strings.Cut(entry, "=")
+		method, value = strings.TrimSpace(method), strings.TrimSpace(value)
+		if !ok || method == "" {
+			return nil, fmt.Errorf("accesslog: rule %q: want method=rate or method=off", entry)
+		}
+		if method != "*" && !strings.HasPrefix(method, "/") {
+			return nil, fmt.Errorf("accesslog: rule %q: method must be a full method name, /package.Service/* or *", entry)
+		}
+		r := Rule{Method: method}
+		if value == "off" {
+			r.Off = true
+		} else {
+			rate, err := strconv.ParseFloat(value, 64)
+			if err != nil || rate < 0 || rate > 1 {
+				return nil, fmt.Errorf("accesslog: rule %q: rate must be between 0 and 1", entry)
+			}
+			r.PayloadSampleRate = rate
+		}
+		rules = append(rules, r)
+	}
+	return rules, nil
+}
+
+// Option configures the interceptors.
+type Option func(*config)
+
+type config struct {
+	rules    []Rule
+	redactor *shared.Redactor
+	fields   []string
+	sample   func() float64
+}
+
+// WithRules replaces the rules read from ACCESS_LOG_RULES.
+func WithRules(rules ...Rule) Option {
+	return func(c *config) { c.rules = append(defaultRules[:len(defaultRules):len(defaultRules)], rules...) }
+}
+
+// WithRedactedFields adds proto field names to the redactor's keys
+// (DefaultRedactedFields unless WithRedactor is given).
+func WithRedactedFields(names ...string) Option {
+	return func(c *config) { c.fields = append(c.fields, names...) }
+}
+
+// WithRedactor replaces shared.DefaultRedactor() for payloads, e.g. to add
+// matchers for identifiers specific to a service.
+func WithRedactor(r *shared.Redactor) Option {
+	return func(c *config) { c.redactor = r }
+}
+
+func newConfig(log *slog.Logger, opts []Option) config {
+	c := config{redactor: shared.DefaultRedactor(), sample: rand.Float64}
+	c.rules = defaultRules
+	if s := os.Getenv(EnvRules); s != "" {
+		rules, err := ParseRules(s)
+		if err != nil {
+			log.Warn("ignoring invalid access log rules", "error", err)
+		} else {
+			c.rules = append(defaultRules[:len(defaultRules):len(defaultRules)], rules...)
+		}
+	}
+	for _, opt := range opts {
+		opt(&c)
+	}
+	c.redactor = c.redactor.WithKeys(c.fields...)
+	return c
+}
+
+// rule returns the most specific rule matching method: an exact name, then
+// the service wildcard, then "*". Later rules win among equals.
+func (c config) rule(method string) Rule {
+	service := method[:strings.LastIndexByte(method, '/')+1] + "*"
+	best, rank := Rule{}, 0
+	for _, r := range c.rules {
+		var rk int
+		switch r.Method {
+		case method:
+			rk = 3
+		case service:
+			rk = 2
+		case "*":
+			rk = 1
+		}
+		if rk > 0 && rk >= rank {
+			best, rank = r, rk
+		}
+	}
+	return best
+}
+
+// UnaryServerInterceptor logs each completed call to log: at info, or at
+// warn if it failed.
+func UnaryServerInterceptor(log *slog.Logger, opts ...Option) grpc.UnaryServerInterceptor {
+	c := newConfig(log, opts)
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		r := c.rule(info.FullMethod)
+		if r.Off {
+			return handler(ctx, req)
+		}
+		start := time.Now()
+		resp, err := handler(ctx, req)
+		attrs := callAttrs(ctx, info.FullMethod, start, err)
+		if r.PayloadSampleRate > 0 && c.sample() < r.PayloadSampleRate {
+			attrs = append(attrs, slog.String("request", c.payload(req)))
+			if err == nil {
+				attrs = append(attrs, slog.String("response", c.payload(resp)))
+			}
+		}
+		logCall(ctx, log, err, attrs)
+		return resp, err
+	}
+}
+
+// StreamServerInterceptor logs each completed stream with the number of
+// messages received and sent. Stream payloads are never logged.
+func StreamServerInterceptor(log *slog.Logger, opts ...Option) grpc.StreamServerInterceptor {
+	c := newConfig(log, opts)
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if c.rule(info.FullMethod).Off {
+			return handler(srv, ss)
+		}
+		start := time.Now()
+		cs := &countingStream{ServerStream: ss}
+		err := handler(srv, cs)
+		attrs := append(callAttrs(ss.Context(), info.FullMethod, start, err),
+			slog.Int("received", cs.received), slog.Int("sent", cs.sent))
+		logCall(ss.Context(), log, err, attrs)
+		return err
+	}
+}
+
+type countingStream struct {
+	grpc.ServerStream
+	received, sent int
+}
+
+func (s *countingStream) RecvMsg(m any) error {
+	err := s.ServerStream.RecvMsg(m)
+	if err == nil {
+		s.received++
+	}
+	return err
+}
+
+func (s *countingStream) SendMsg(m any) error {
+	err := s.ServerStream.SendMsg(m)
+	if err == nil {
+		s.sent++
+	}
+	return err
+}
+
+func callAttrs(ctx context.Context, method string, start time.Time, err error) []slog.Attr {
+	attrs := []slog.Attr{
+		slog.String("method", method),
+		slog.String("code", status.Code(err).String()),
+		slog.Duration("duration", time.Since(start)),
+	}
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		attrs = append(attrs, slog.String("peer", p.Addr.String()))
+	}
+	if err != nil {
+		attrs = append(attrs, slog.String("error", status.Convert(err).Message()))
+	}
+	return attrs
+}
+
+func logCall(ctx context.Context, log *slog.Logger, err error, attrs []slog.Attr) {
+	level := slog.LevelInfo
+	if err != nil {
+		level = slog.LevelWarn
+	}
+	log.LogAttrs(ctx, level, "grpc access", attrs...)
+}
+
+// payload renders v as redacted JSON. Values that are not protos are only
+// named by type, since their fields cannot be redacted.
+func (c config) payload(v any) string {
+	m, ok := v.(proto.Message)
+	if !ok {
+		return fmt.Sprintf("<%T>", v)
+	}
+	b, err := protojson.Marshal(c.redactor.Proto(m))
+	if err != nil {
+		return fmt.Sprintf("<%T: %v>", v, err)
+	}
+	if len(b) > MaxPayloadBytes {
+		return string(b[:MaxPayloadBytes]) + "...(truncated)"
+	}
+	return string(b)
+}
@@ -0,0 +1,177 @@
+package accesslog
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net"
+	"strings"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// testMessages mirror the PaymentService messages of demo.proto.
+var testMessages struct {
+	card, request, response protoreflect.MessageDescriptor
+}
+
+func init() {
+	opt := descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum()
+	field := func(name string, num int32, typ descriptorpb.FieldDescriptorProto_Type) *descriptorpb.FieldDescriptorProto {
+		return &descriptorpb.FieldDescriptorProto{Name: proto.String(name), Number: proto.Int32(num), Type: typ.Enum(), Label: opt}
+	}
+	card := field("credit_card", 3, descriptorpb.FieldDescriptorProto_TYPE_MESSAGE)
+	card.TypeName = proto.String(".accesslogtest.CreditCardInfo")
+	fd, err := protodesc.NewFile(&descriptorpb.FileDescriptorProto{
+		Name: proto.String("accesslog_test.proto"), Package: proto.String("accesslogtest"), Syntax: proto.String("proto3"),
+		MessageType: []*descriptorpb.DescriptorProto{
+			{Name: proto.String("CreditCardInfo"), Field: []*descriptorpb.FieldDescriptorProto{
+				field("credit_card_number", 1, descriptorpb.FieldDescriptorProto_TYPE_STRING),
+				field("credit_card_cvv", 2, descriptorpb.FieldDescriptorProto_TYPE_INT32),
+			}},
+			{Name: proto.String("ChargeRequest"), Field: []*descriptorpb.FieldDescriptorProto{
+				field("currency_code", 1, descriptorpb.FieldDescriptorProto_TYPE_STRING),
+				field("units", 2, descriptorpb.FieldDescriptorProto_TYPE_INT64),
+				card,
+			}},
+			{Name: proto.String("ChargeResponse"), Field: []*descriptorpb.FieldDescriptorProto{
+				field("transaction_id", 1, descriptorpb.FieldDescriptorProto_TYPE_STRING),
+			}},
+		},
+	}, nil)
+	if err != nil {
+		panic(err)
+	}
+	testMessages.card = fd.Messages().ByName("CreditCardInfo")
+	testMessages.request = fd.Messages().ByName("ChargeRequest")
+	testMessages.response = fd.Messages().ByName("ChargeResponse")
+}
+
+// newMessage builds a dynamic message of desc with the given field values.
+func newMessage(desc protoreflect.MessageDescriptor, values map[string]any) *dynamicpb.Message {
+	m := dynamicpb.NewMessage(desc)
+	for name, v := range values {
+		m.Set(desc.Fields().ByName(protoreflect.Name(name)), protoreflect.ValueOf(v))
+	}
+	return m
+}
+
+func TestParseRules(t *testing.T) {
+	rules, err := ParseRules("/a.S/M=0.5, /a.S/*=off ,*=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Rule{{Method: "/a.S/M", PayloadSampleRate: 0.5}, {Method: "/a.S/*", Off: true}, {Method: "*", PayloadSampleRate: 1}}
+	if len(rules) != len(want) {
+		t.Fatalf("got %+v, want %+v", rules, want)
+	}
+	for i := range want {
+		if rules[i] != want[i] {
+			t.Errorf("rule %d = %+v, want %+v", i, rules[i], want[i])
+		}
+	}
+	for _, bad := range []string{"/a.S/M", "/a.S/M=2", "a.S/M=1", "/a.S/M=x"} {
+		if _, err := ParseRules(bad); err == nil {
+			t.Errorf("ParseRules(%q) succeeded", bad)
+		}
+	}
+}
+
+func TestRuleSpecificity(t *testing.T) {
+	t.Setenv(EnvRules, "")
+	rules, _ := ParseRules("*=0.1,/a.S/*=off,/a.S/M=1")
+	c := newConfig(slog.Default(), []Option{WithRules(rules...)})
+	for method, want := range map[string]Rule{
+		"/a.S/M":                   {Method: "/a.S/M", PayloadSampleRate: 1},
+		"/a.S/N":                   {Method: "/a.S/*", Off: true},
+		"/b.T/M":                   {Method: "*", PayloadSampleRate: 0.1},
+		"/grpc.health.v1.Health/W": {Method: "/grpc.health.v1.Health/*", Off: true},
+	} {
+		if got := c.rule(method); got != want {
+			t.Errorf("rule(%s) = %+v, want %+v", method, got, want)
+		}
+	}
+}
+
+func TestUnaryInterceptorRedactsPayloads(t *testing.T) {
+	t.Setenv(EnvRules, "/hipstershop.PaymentService/Charge=1")
+	var buf bytes.Buffer
+	log := slog.New(slog.NewJSONHandler(&buf, nil))
+	ic := UnaryServerInterceptor(log)
+
+	ctx := peer.NewContext(context.Background(), &peer.Peer{Addr: &net.TCPAddr{IP: net.IPv4(10, 0, 0, 7), Port: 4242}})
+	card := newMessage(testMessages.card, map[string]any{"credit_card_number": "4432-8015-6152-0454", "credit_card_cvv": int32(672)})
+	req := newMessage(testMessages.request, map[string]any{"currency_code": "USD", "units": int64(12), "credit_card": protoreflect.Message(card)})
+	info := &grpc.UnaryServerInfo{FullMethod: "/hipstershop.PaymentService/Charge"}
+	_, err := ic(ctx, req, info, func(context.Context, any) (any, error) {
+		return newMessage(testMessages.response, map[string]any{"transaction_id": "tx for jane@example.com"}), nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var entry map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+		t.Fatalf("decoding %q: %v", buf.String(), err)
+	}
+	if entry["method"] != info.FullMethod || entry["code"] != "OK" || entry["peer"] != "10.0.0.7:4242" {
+		t.Errorf("entry = %v", entry)
+	}
+	request, _ := entry["request"].(string)
+	response, _ := entry["response"].(string)
+	for _, leak := range []string{"4432", "672", "jane@example.com"} {
+		if strings.Contains(request+response, leak) {
+			t.Errorf("payloads leak %q: %s %s", leak, request, response)
+		}
+	}
+	var logged map[string]any
+	if err := json.Unmarshal([]byte(request), &logged); err != nil || logged["units"] != "12" || !strings.Contains(response, Redacted) {
+		t.Errorf("payloads not logged as expected: %v", entry)
+	}
+	if card.Get(testMessages.card.Fields().ByName("credit_card_number")).String() != "4432-8015-6152-0454" {
+		t.Error("Redact modified the request")
+	}
+}
+
+func TestUnaryInterceptorSkipsPayloadsAndOffMethods(t *testing.T) {
+	t.Setenv(EnvRules, "/hipstershop.CartService/*=off")
+	var buf bytes.Buffer
+	ic := UnaryServerInterceptor(slog.New(slog.NewJSONHandler(&buf, nil)))
+	fail := func(context.Context, any) (any, error) { return nil, status.Error(codes.NotFound, "no cart") }
+
+	ic(context.Background(), dynamicpb.NewMessage(testMessages.request), &grpc.UnaryServerInfo{FullMethod: "/hipstershop.CartService/GetCart"}, fail)
+	if buf.Len() != 0 {
+		t.Errorf("off method logged: %s", buf.String())
+	}
+
+	ic(context.Background(), dynamicpb.NewMessage(testMessages.request), &grpc.UnaryServerInfo{FullMethod: "/hipstershop.ShippingService/GetQuote"}, fail)
+	var entry map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+		t.Fatalf("decoding %q: %v", buf.String(), err)
+	}
+	if entry["level"] != "WARN" || entry["code"] != "NotFound" || entry["request"] != nil {
+		t.Errorf("entry = %v, want a warning without payloads", entry)
+	}
+}
+
+func TestScrubString(t *testing.T) {
+	for in, want := range map[string]string{
+		"card 4432 8015 6152 0454 declined": "card " + Redacted + "0454 declined",
+		"order 1234567890123 shipped":       "order 1234567890123 shipped",
+		"mail a.b+c@shop.example.org now":   "mail " + Redacted + " now",
+	} {
+		if got := ScrubString(in); got != want {
+			t.Errorf("ScrubString(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
@@ -0,0 +1,32 @@
+package accesslog
+
+import (
+	"google.golang.org/protobuf/proto"
+
+	shared "github.com/GoogleCloudPlatform/microservices-demo/src/shared"
+)
+
+// Redacted replaces the values removed from logged payloads.
+const Redacted = shared.Redacted
+
+// DefaultRedactedFields are the proto field names whose values are never
+// logged; see shared.DefaultRedactedKeys.
+var DefaultRedactedFields = shared.DefaultRedactedKeys
+
+// Redact returns a copy of m in which the fields named in fields are
+// replaced by Redacted, or zeroed if they are not strings, and the card
+// numbers, email addresses and phone numbers found in any other string are
+// masked. m itself is not modified.
+func Redact(m proto.Message, fields map[string]bool) proto.Message {
+	keys := make([]string, 0, len(fields))
+	for f, ok := range fields {
+		if ok {
+			keys = append(keys, f)
+		}
+	}
+	return shared.NewRedactor(shared.WithRedactedKeys(keys...)).Proto(m)
+}
+
+// ScrubString masks the card numbers, keeping their last four digits, the
+// email addresses and the phone numbers in s.
+func ScrubString(s string) string { return shared.Redact(s) }
@@ -0,0 +1,343 @@
+package shared
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/GoogleCloudPlatform/microservices-demo/src/shared/metrics"
+)
+
+var (
+	adaptiveLimitGauge = metrics.NewGaugeVec("adaptive_limit",
+		"Current concurrency limit of an adaptive limiter.", "limiter")
+	adaptiveInflightGauge = metrics.NewGaugeVec("adaptive_inflight",
+		"Requests currently admitted by an adaptive limiter.", "limiter")
+	adaptiveShedTotal = metrics.NewCounterVec("adaptive_shed_total",
+		"Requests rejected by an adaptive limiter because it was at its limit.", "limiter")
+)
+
+// ErrLimitExceeded is returned by AdaptiveLimiter.Acquire when the limiter is
+// at its current concurrency limit.
+var ErrLimitExceeded = errors.New("adaptive limit exceeded")
+
+// AdaptiveLimiterConfig tunes an AdaptiveLimiter. Load it with
+// AdaptiveLimiterConfigFromEnv or fill it in directly.
+type AdaptiveLimiterConfig struct {
+	InitialLimit int `env:"ADAPTIVE_LIMIT_INITIAL" default:"20"`
+	MinLimit     int `env:"ADAPTIVE_LIMIT_MIN" default:"5"`
+	MaxLimit     int `env:"ADAPTIVE_LIMIT_MAX" default:"500"`
+	// Algorithm is "gradient" (the default) or "aimd".
+	Algorithm string `env:"ADAPTIVE_LIMIT_ALGORITHM" default:"gradient"`
+	// Smoothing is how far each gradient update moves the limit toward its
+	// target, in (0, 1].
+	Smoothing float64 `env:"ADAPTIVE_LIMIT_SMOOTHING" default:"0.2"`
+	// Tolerance is how much the latency may exceed its long-term average
+	// before the gradient limit shrinks.
+	Tolerance float64 `env:"ADAPTIVE_LIMIT_TOLERANCE" default:"1.5"`
+	// LatencyThreshold is the AIMD latency above which the limit shrinks.
+	LatencyThreshold time.Duration `env:"ADAPTIVE_LIMIT_LATENCY_THRESHOLD" default:"250ms"`
+	// BackoffRatio multiplies the limit on an overload signal (a dropped
+	// request, or AIMD latency above the threshold).
+	BackoffRatio float64 `env:"ADAPTIVE_LIMIT_BACKOFF_RATIO" default:"0.9"`
+}
+
+// AdaptiveLimiterConfigFromEnv loads an AdaptiveLimiterConfig with LoadConfig.
+func AdaptiveLimiterConfigFromEnv() (AdaptiveLimiterConfig, error) {
+	var cfg AdaptiveLimiterConfig
+	err := LoadConfig(&cfg)
+	return cfg, err
+}
+
+// AdaptiveLimiter bounds the number of requests in flight with a limit that
+// follows observed latency, so an overloaded service sheds the excess quickly
+// instead of queueing it until everything times out.
+//
+// The gradient algorithm compares each request's latency with a long-term
+// average: while they match, the limit grows by roughly its square root (the
+// tolerated queue); when latency rises, the limit shrinks in proportion. AIMD
+// adds one when latency is under LatencyThreshold and multiplies by
+// BackoffRatio otherwise. Both back off when requests are dropped
+// (DeadlineExceeded, ResourceExhausted, Unavailable, HTTP 503/504).
+//
+// Usage:
+//
+//	cfg, err := shared.AdaptiveLimiterConfigFromEnv()
+//	...
+//	lim, err := shared.NewAdaptiveLimiter("checkout", cfg)
+//	srv := grpcserver.New(grpcserver.WithUnaryInterceptors(lim.UnaryServerInterceptor()))
+type AdaptiveLimiter struct {
+	name string
+	cfg  AdaptiveLimiterConfig
+	now  func() time.Time
+
+	mu       sync.Mutex
+	limit    float64
+	inflight int
+	longRTT  float64 // EWMA of latency in seconds; 0 until the first sample
+}
+
+// longRTTWindow is the number of samples the long-term latency average spans.
+const longRTTWindow = 600
+
+// NewAdaptiveLimiter returns a limiter named name (used as the metrics label).
+func NewAdaptiveLimiter(name string, cfg AdaptiveLimiterConfig) (*AdaptiveLimiter, error) {
+	if cfg.MinLimit < 1 {
+		cfg.MinLimit = 1
+	}
+	if cfg.MaxLimit < cfg.MinLimit {
+		return nil, fmt.Errorf("adaptive limit: MaxLimit %d below MinLimit %d", cfg.MaxLimit, cfg.MinLimit)
+	}
+	if cfg.InitialLimit < cfg.MinLimit || cfg.InitialLimit > cfg.MaxLimit {
+		cfg.InitialLimit = cfg.MinLimit
+	}
+	switch cfg.Algorithm {
+	case "":
+		cfg.Algorithm = "gradient"
+	case "gradient", "aimd":
+	default:
+		return nil, fmt.Errorf("adaptive limit: unknown algorithm %q", cfg.Algorithm)
+	}
+	if cfg.Smoothing <= 0 || cfg.Smoothing > 1 {
+		cfg.Smoothing = 0.2
+	}
+	if cfg.Tolerance < 1 {
+		cfg.Tolerance = 1.5
+	}
+	if cfg.BackoffRatio <= 0 || cfg.BackoffRatio >= 1 {
+		cfg.BackoffRatio = 0.9
+	}
+	l := &AdaptiveLimiter{name: name, cfg: cfg, now: time.Now, limit: float64(cfg.InitialLimit)}
+	adaptiveLimitGauge.WithLabelValues(name).Set(l.limit)
+	return l, nil
+}
+
+// Limit returns the current concurrency limit.
+func (l *AdaptiveLimiter) Limit() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return int(l.limit)
+}
+
+// Inflight returns the number of admitted requests not yet done.
+func (l *AdaptiveLimiter) Inflight() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.inflight
+}
+
+// Acquire admits a request if fewer than Limit are in flight. The caller
+// must call done exactly once with the request's outcome: nil records a
+// latency sample, an overload error (see IsOverload) backs the limit off, and
+// any other error releases the slot without affecting the limit.
+func (l *AdaptiveLimiter) Acquire() (done func(err error), err error) {
+	l.mu.Lock()
+	if l.inflight >= int(l.limit) {
+		l.mu.Unlock()
+		adaptiveShedTotal.WithLabelValues(l.name).Inc()
+		return nil, ErrLimitExceeded
+	}
+	l.inflight++
+	inflight := l.inflight
+	l.mu.Unlock()
+	adaptiveInflightGauge.WithLabelValues(l.name).Set(float64(inflight))
+
+	start := l.now()
+	var once sync.Once
+	return func(err error) {
+		once.Do(func() { l.release(l.now().Sub(start), err) })
+	}, nil
+}
+
+// IsOverload reports whether err signals that the request was dropped
+// because the service or its dependencies are saturated.
+func IsOverload(err error) bool {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	switch status.Code(err) {
+	case codes.DeadlineExceeded, codes.ResourceExhausted, codes.Unavailable:
+		return true
+	}
+	return false
+}
+
+// release returns a slot and updates the limit from the outcome.
+func (l *AdaptiveLimiter) release(rtt time.Duration, err error) {
+	l.mu.Lock()
+	inflight := l.inflight // including this request
+	l.inflight--
+	switch {
+	case err == nil:
+		l.sample(rtt.Seconds(), inflight)
+	case IsOverload(err):
+		l.setLimit(l.limit * l.cfg.BackoffRatio)
+	}
+	limit, now := l.limit, l.inflight
+	l.mu.Unlock()
+	adaptiveLimitGauge.WithLabelValues(l.name).Set(limit)
+	adaptiveInflightGauge.WithLabelValues(l.name).Set(float64(now))
+}
+
+// sample updates the limit from a successful request's latency. l.mu must
+// be held.
+func (l *AdaptiveLimiter) sample(rtt float64, inflight int) {
+	// A limiter far from its limit has no evidence the limit is too low.
+	appLimited := float64(inflight) < l.limit/2
+
+	if l.cfg.Algorithm == "aimd" {
+		switch {
+		case rtt > l.cfg.LatencyThreshold.Seconds():
+			l.setLimit(l.limit * l.cfg.BackoffRatio)
+		case !appLimited:
+			l.setLimit(l.limit + 1)
+		}
+		return
+	}
+
+	if l.longRTT == 0 {
+		l.longRTT = rtt
+	} else {
+		l.longRTT += (rtt - l.longRTT) / longRTTWindow
+	}
+	// After a sustained latency drop, let the average catch up quickly
+	// rather than pinning the limit at its maximum.
+	if rtt > 0 && l.longRTT/rtt > 2 {
+		l.longRTT *= 0.95
+	}
+	if appLimited || rtt <= 0 {
+		return
+	}
+	gradient := math.Max(0.5, math.Min(1, l.cfg.Tolerance*l.longRTT/rtt))
+	target := l.limit*gradient + math.Sqrt(l.limit)
+	l.setLimit(l.limit*(1-l.cfg.Smoothing) + target*l.cfg.Smoothing)
+}
+
+func (l *AdaptiveLimiter) setLimit(v float64) {
+	l.limit = math.Max(float64(l.cfg.MinLimit), math.Min(float64(l.cfg.MaxLimit), v))
+}
+
+// UnaryServerInterceptor sheds calls over the limit with ResourceExhausted.
+// Health checks are always admitted.
+func (l *AdaptiveLimiter) UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		if strings.HasPrefix(info.FullMethod, "/grpc.health.v1.Health/") {
+			return handler(ctx, req)
+		}
+		done, err := l.Acquire()
+		if err != nil {
+			return nil, status.Errorf(codes.ResourceExhausted, "server overloaded: %v", err)
+		}
+		// A panicking handler still releases its slot, without a sample.
+		outcome := errOutcomeIgnored
+		defer func() { done(outcome) }()
+		resp, err := handler(ctx, req)
+		outcome = err
+		return resp, err
+	}
+}
+
+// StreamServerInterceptor sheds streams over the limit. A stream holds its
+// slot until it ends, and its duration is not used as a latency sample.
+func (l *AdaptiveLimiter) StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if strings.HasPrefix(info.FullMethod, "/grpc.health.v1.Health/") {
+			return handler(srv, ss)
+		}
+		done, err := l.Acquire()
+		if err != nil {
+			return status.Errorf(codes.ResourceExhausted, "server overloaded: %v", err)
+		}
+		outcome := errOutcomeIgnored
+		defer func() { done(outcome) }()
+		err = handler(srv, ss)
+		if IsOverload(err) {
+			outcome = err
+		}
+		return err
+	}
+}
+
+// errOutcomeIgnored releases a slot without sampling its latency, for
+// streams, panics and failures that say nothing about load.
+var errOutcomeIgnored = errors.New("stream ended")
+
+// errHTTPOverload marks an HTTP 503 or 504 response as an overload signal.
+var errHTTPOverload = status.Error(codes.Unavailable, "upstream overloaded")
+
+// Middleware sheds HTTP requests over the limit with 503 Service Unavailable
+// and a Retry-After hint.
+func (l *AdaptiveLimiter) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		done, err := l.Acquire()
+		if err != nil {
+			w.Header().Set("Retry-After", "1")
+			http.Error(w, "server overloaded", http.StatusServiceUnavailable)
+			return
+		}
+		sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+		defer func() {
+			switch {
+			case sw.status == http.StatusServiceUnavailable || sw.status == http.StatusGatewayTimeout:
+				done(errHTTPOverload)
+			case sw.status >= 500:
+				done(errOutcomeIgnored)
+			default:
+				done(nil)
+			}
+		}()
+		next.ServeHTTP(sw, r)
+	})
+}
+
+// statusWriter records the status code and body size written through it.
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+	wrote  bool
+	bytes  int64
+}
+
+func (w *statusWriter) WriteHeader(code int) {
+	if !w.wrote {
+		w.status, w.wrote = code, true
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *statusWriter) Write(b []byte) (int, error) {
+	w.wrote = true
+	n, err := w.ResponseWriter.Write(b)
+	w.bytes += int64(n)
+	return n, err
+}
+
+func (w *statusWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Hijack hands over the connection, recording it as 101 Switching
+// Protocols, since hijacking handlers such as websocket upgrades write their
+// response themselves.
+func (w *statusWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, brw, err := http.NewResponseController(w.ResponseWriter).Hijack()
+	if err == nil && !w.wrote {
+		w.status, w.wrote = http.StatusSwitchingProtocols, true
+	}
+	return conn, brw, err
+}
+
+func (w *statusWriter) Unwrap() http.ResponseWriter { return w.ResponseWriter }
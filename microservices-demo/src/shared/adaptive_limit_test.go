@@ -0,0 +1,159 @@
+package shared
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func newTestAdaptiveLimiter(t *testing.T, cfg AdaptiveLimiterConfig) *AdaptiveLimiter {
+	t.Helper()
+	l, err := NewAdaptiveLimiter(t.Name(), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return l
+}
+
+func TestAdaptiveLimiterSheds(t *testing.T) {
+	l := newTestAdaptiveLimiter(t, AdaptiveLimiterConfig{InitialLimit: 2, MinLimit: 2, MaxLimit: 2})
+	d1, err1 := l.Acquire()
+	d2, err2 := l.Acquire()
+	if err1 != nil || err2 != nil {
+		t.Fatalf("Acquire under limit: %v, %v", err1, err2)
+	}
+	if _, err := l.Acquire(); !errors.Is(err, ErrLimitExceeded) {
+		t.Fatalf("Acquire at limit = %v, want ErrLimitExceeded", err)
+	}
+	d1(nil)
+	if _, err := l.Acquire(); err != nil {
+		t.Errorf("Acquire after release = %v", err)
+	}
+	d2(nil)
+}
+
+// drive runs n requests at full concurrency with the given latency.
+func drive(l *AdaptiveLimiter, clock *time.Time, n int, rtt time.Duration) {
+	for range n {
+		var dones []func(error)
+		for {
+			done, err := l.Acquire()
+			if err != nil {
+				break
+			}
+			dones = append(dones, done)
+		}
+		*clock = clock.Add(rtt)
+		for _, done := range dones {
+			done(nil)
+		}
+	}
+}
+
+func TestAdaptiveLimiterGradient(t *testing.T) {
+	l := newTestAdaptiveLimiter(t, AdaptiveLimiterConfig{InitialLimit: 10, MinLimit: 5, MaxLimit: 1000})
+	now := time.Unix(0, 0)
+	l.now = func() time.Time { return now }
+
+	drive(l, &now, 20, 10*time.Millisecond)
+	grown := l.Limit()
+	if grown <= 10 {
+		t.Fatalf("limit = %d after steady latency, want growth above 10", grown)
+	}
+	drive(l, &now, 1, 100*time.Millisecond)
+	if got := l.Limit(); got >= grown {
+		t.Errorf("limit = %d after latency rose, want below %d", got, grown)
+	}
+}
+
+func TestAdaptiveLimiterAIMD(t *testing.T) {
+	l := newTestAdaptiveLimiter(t, AdaptiveLimiterConfig{
+		Algorithm: "aimd", InitialLimit: 10, MinLimit: 1, MaxLimit: 100, LatencyThreshold: 50 * time.Millisecond,
+	})
+	now := time.Unix(0, 0)
+	l.now = func() time.Time { return now }
+
+	drive(l, &now, 1, 10*time.Millisecond)
+	if got := l.Limit(); got <= 10 {
+		t.Errorf("limit = %d after fast requests, want above 10", got)
+	}
+	before := l.Limit()
+	drive(l, &now, 3, 200*time.Millisecond)
+	if got := l.Limit(); got >= before {
+		t.Errorf("limit = %d after slow requests, want below %d", got, before)
+	}
+}
+
+func TestAdaptiveLimiterBacksOffOnDrops(t *testing.T) {
+	l := newTestAdaptiveLimiter(t, AdaptiveLimiterConfig{InitialLimit: 50, MinLimit: 1, MaxLimit: 100})
+	for range 5 {
+		done, _ := l.Acquire()
+		done(status.Error(codes.DeadlineExceeded, "slow"))
+	}
+	if got := l.Limit(); got >= 50 {
+		t.Errorf("limit = %d after drops, want below 50", got)
+	}
+	before := l.Limit()
+	done, _ := l.Acquire()
+	done(status.Error(codes.NotFound, "no such product"))
+	if got := l.Limit(); got != before {
+		t.Errorf("limit changed from %d to %d on a client error", before, got)
+	}
+}
+
+func TestAdaptiveLimiterInterceptorAndMiddleware(t *testing.T) {
+	l := newTestAdaptiveLimiter(t, AdaptiveLimiterConfig{InitialLimit: 1, MinLimit: 1, MaxLimit: 1})
+	hold, _ := l.Acquire()
+	defer hold(nil)
+
+	intercept := l.UnaryServerInterceptor()
+	ok := func(ctx context.Context, req any) (any, error) { return "ok", nil }
+	_, err := intercept(context.Background(), nil, &grpc.UnaryServerInfo{FullMethod: "/hipstershop.CartService/AddItem"}, ok)
+	if status.Code(err) != codes.ResourceExhausted {
+		t.Errorf("interceptor at limit = %v, want ResourceExhausted", err)
+	}
+	if _, err := intercept(context.Background(), nil, &grpc.UnaryServerInfo{FullMethod: "/grpc.health.v1.Health/Check"}, ok); err != nil {
+		t.Errorf("health check shed: %v", err)
+	}
+
+	rec := httptest.NewRecorder()
+	l.Middleware(http.NotFoundHandler()).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	if rec.Code != http.StatusServiceUnavailable || rec.Header().Get("Retry-After") == "" {
+		t.Errorf("middleware at limit = %d, Retry-After %q", rec.Code, rec.Header().Get("Retry-After"))
+	}
+}
+
+func TestAdaptiveLimiterReleasesOnPanic(t *testing.T) {
+	l := newTestAdaptiveLimiter(t, AdaptiveLimiterConfig{InitialLimit: 1, MinLimit: 1, MaxLimit: 10})
+	before := l.Limit()
+	mustPanic := func(name string, call func()) {
+		t.Helper()
+		defer func() {
+			if recover() == nil {
+				t.Errorf("%s: panic swallowed", name)
+			}
+		}()
+		call()
+	}
+	mustPanic("unary", func() {
+		l.UnaryServerInterceptor()(context.Background(), nil, &grpc.UnaryServerInfo{FullMethod: "/hipstershop.CartService/AddItem"},
+			func(context.Context, any) (any, error) { panic("boom") })
+	})
+	mustPanic("stream", func() {
+		l.StreamServerInterceptor()(nil, nil, &grpc.StreamServerInfo{FullMethod: "/hipstershop.CartService/Watch"},
+			func(any, grpc.ServerStream) error { panic("boom") })
+	})
+	if got := l.Inflight(); got != 0 {
+		t.Errorf("inflight after panics = %d, want 0", got)
+	}
+	if got := l.Limit(); got != before {
+		t.Errorf("limit changed from %d to %d after panics", before, got)
+	}
+}
@@ -0,0 +1,146 @@
+package shared
+
+import (
+	"crypto/subtle"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"os"
+	"strings"
+
+	"github.com/GoogleCloudPlatform/microservices-demo/src/shared/buildinfo"
+	"github.com/GoogleCloudPlatform/microservices-demo/src/shared/health"
+	"github.com/GoogleCloudPlatform/microservices-demo/src/shared/logging"
+	"github.com/GoogleCloudPlatform/microservices-demo/src/shared/metrics"
+)
+
+// DefaultAdminAddr is the listen address used when ADMIN_ADDR is not set.
+const DefaultAdminAddr = ":8090"
+
+// AdminOption configures StartAdminServer.
+type AdminOption func(*adminConfig)
+
+type adminConfig struct {
+	health   *health.Registry
+	token    string
+	handlers []adminHandler
+}
+
+type adminHandler struct {
+	pattern string
+	handler http.Handler
+}
+
+// WithAdminHealth serves the registry's probes at /healthz, /readyz and
+// /startupz. Without it the probes always report healthy.
+func WithAdminHealth(r *health.Registry) AdminOption {
+	return func(c *adminConfig) { c.health = r }
+}
+
+// WithAdminToken overrides ADMIN_TOKEN.
+func WithAdminToken(token string) AdminOption {
+	return func(c *adminConfig) { c.token = token }
+}
+
+// WithAdminHandler mounts an additional handler on the admin server.
+func WithAdminHandler(pattern string, h http.Handler) AdminOption {
+	return func(c *adminConfig) { c.handlers = append(c.handlers, adminHandler{pattern, h}) }
+}
+
+// StartAdminServer serves the operational endpoints every service exposes on
+// one internal port:
+//
+//	/debug/pprof/          runtime profiles
+//	/debug/profiles/dump   write profiles to PPROFDIR (see ProfileHandler)
+//	/metrics               Prometheus metrics
+//	/healthz /readyz /startupz
+//	/coverage/             dump, clear and status (see CoverageHandler)
+//	/admin/loglevel        read or change the log level
+//	/version /buildinfo    version, commit, build date and Go version as JSON
+//
+// addr overrides ADMIN_ADDR, which defaults to DefaultAdminAddr. When a token
+// is configured through ADMIN_TOKEN or WithAdminToken, every endpoint except
+// the probes requires "Authorization: Bearer <token>"; the probes stay open
+// so the kubelet can reach them.
+//
+// The listener is bound before StartAdminServer returns, so a port conflict
+// is reported immediately; requests are served on a background goroutine.
+//
+// Usage:
+//
+//	hr := health.New()
+//	srv, err := shared.StartAdminServer("", shared.WithAdminHealth(hr))
+//	if err != nil {
+//	    log.Fatal(err)
+//	}
+//	sm.Register("admin-server", srv.Shutdown)
+func StartAdminServer(addr string, opts ...AdminOption) (*http.Server, error) {
+	if addr == "" {
+		addr = os.Getenv("ADMIN_ADDR")
+	}
+	if addr == "" {
+		addr = DefaultAdminAddr
+	}
+	c := adminConfig{health: health.New(), token: os.Getenv("ADMIN_TOKEN")}
+	for _, opt := range opts {
+		opt(&c)
+	}
+
+	lis, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	srv := &http.Server{Handler: adminMux(c)}
+	go func() {
+		if err := srv.Serve(lis); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Printf("Admin: Server stopped: %v", err)
+		}
+	}()
+	log.Printf("Admin: Serving admin endpoints on %s", lis.Addr())
+	return srv, nil
+}
+
+// adminMux builds the admin route table.
+func adminMux(c adminConfig) http.Handler {
+	private := http.NewServeMux()
+	private.HandleFunc("/debug/pprof/", pprof.Index)
+	private.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	private.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	private.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	private.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	private.Handle("/metrics", metrics.Handler())
+	private.Handle("/coverage/", CoverageHandler())
+	private.Handle("/debug/profiles/dump", ProfileHandler())
+	private.Handle("/admin/loglevel", logging.LevelHandler())
+	private.Handle("/buildinfo", buildinfo.Handler())
+	private.Handle("/version", buildinfo.Handler())
+	for _, h := range c.handlers {
+		private.Handle(h.pattern, h.handler)
+	}
+
+	mux := http.NewServeMux()
+	probes := c.health.Handler()
+	for _, p := range []string{"/healthz", "/readyz", "/startupz"} {
+		mux.Handle(p, probes)
+	}
+	mux.Handle("/", adminAuth(c.token, private))
+	return mux
+}
+
+// adminAuth requires the bearer token when one is configured.
+func adminAuth(token string, next http.Handler) http.Handler {
+	if token == "" {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="admin"`)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,70 @@
+package shared
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/GoogleCloudPlatform/microservices-demo/src/shared/health"
+)
+
+func TestAdminMux(t *testing.T) {
+	hr := health.New()
+	hr.Register("db", func(context.Context) error { return errors.New("down") })
+	mux := adminMux(adminConfig{health: hr})
+
+	tests := []struct {
+		path string
+		want int
+	}{
+		{"/healthz", http.StatusOK},
+		{"/readyz", http.StatusServiceUnavailable},
+		{"/metrics", http.StatusOK},
+		{"/buildinfo", http.StatusOK},
+		{"/version", http.StatusOK},
+		{"/debug/pprof/", http.StatusOK},
+		{"/coverage/status", http.StatusOK},
+		{"/admin/loglevel", http.StatusOK},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.path, nil))
+		if rec.Code != tt.want {
+			t.Errorf("GET %s = %d, want %d", tt.path, rec.Code, tt.want)
+		}
+	}
+}
+
+func TestAdminMuxAuth(t *testing.T) {
+	mux := adminMux(adminConfig{health: health.New(), token: "s3cret"})
+
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("probe without token = %d, want 200", rec.Code)
+	}
+
+	rec = httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("/metrics without token = %d, want 401", rec.Code)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	req.Header.Set("Authorization", "Bearer s3cret")
+	rec = httptest.NewRecorder()
+	mux.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Errorf("/metrics with token = %d, want 200", rec.Code)
+	}
+}
+
+func TestStartAdminServer(t *testing.T) {
+	srv, err := StartAdminServer("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv.Close()
+}
@@ -0,0 +1,278 @@
+// Package apikey authenticates service-to-service calls with static API keys,
+// for environments where mTLS or OIDC is more machinery than the threat
+// model needs. Callers send their key in the x-api-key header or metadata;
+// servers check it against a keyring read from package secrets, apply the
+// calling client's rate limit, and store the client's name in the context.
+//
+// The keyring is a secret (default "api-keys") with one key per line:
+//
+//	# client        key                               rate[:burst]
+//	frontend        4f0c9b2e8d1a7f36c5e2b9d04a8f1e7c  200:400
+//	loadgenerator   9d2e7a1c4b8f3e6d0a5c9b2f7e1d4a8c  20
+//	frontend        b7e3a9d1c5f2084e6a9d3c7b1f5e2a8d
+//
+// Listing a second key for a client is how keys are rotated: both are
+// accepted until the old line is removed, and the client's rate limit is
+// shared by all its keys. A client's limit is taken from the first of its
+// lines that has one, defaulting to Config.Rate. Changes to the secret are
+// picked up as the secrets store re-reads it.
+//
+//	keys, err := apikey.New(ctx, cfg)
+//	srv := grpcserver.New(grpcserver.WithUnaryInterceptors(keys.UnaryServerInterceptor()))
+//
+//	conn, err := grpcclient.Dial(ctx, addr, grpcclient.WithDialOptions(
+//	    grpc.WithPerRPCCredentials(apikey.Credentials(key))))
+package apikey
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	shared "github.com/GoogleCloudPlatform/microservices-demo/src/shared"
+	"github.com/GoogleCloudPlatform/microservices-demo/src/shared/metrics"
+	"github.com/GoogleCloudPlatform/microservices-demo/src/shared/ratelimit"
+	"github.com/GoogleCloudPlatform/microservices-demo/src/shared/secrets"
+)
+
+// Header carries the API key on HTTP requests; gRPC uses its lower-case
+// form as metadata.
+const Header = "X-Api-Key"
+
+const metadataKey = "x-api-key"
+
+// MinKeyLength is the shortest key the keyring accepts, so a placeholder
+// left in a manifest cannot become a working key.
+const MinKeyLength = 16
+
+var (
+	// ErrMissing is returned when a request carries no key.
+	ErrMissing = errors.New("apikey: missing API key")
+	// ErrInvalid is returned for a key not in the keyring.
+	ErrInvalid = errors.New("apikey: invalid API key")
+)
+
+var requestsTotal = metrics.NewCounterVec("apikey_requests_total",
+	"Requests checked by API key, by client and result (ok, missing, invalid, limited).", "client", "result")
+
+// Config configures a Keyring.
+type Config struct {
+	// Secret names the keyring secret.
+	Secret string `env:"API_KEYS_SECRET" default:"api-keys"`
+	// Rate and Burst are the per-client limit for keyring lines without
+	// one (0 = unlimited).
+	Rate  float64 `env:"API_KEYS_RPS"`
+	Burst int     `env:"API_KEYS_BURST"`
+}
+
+// ConfigFromEnv loads a Config from the environment.
+func ConfigFromEnv() (Config, error) {
+	var c Config
+	err := shared.LoadConfig(&c)
+	return c, err
+}
+
+// Option configures New.
+type Option func(*Keyring)
+
+// WithStore reads the keyring from s instead of secrets.Default().
+func WithStore(s *secrets.Store) Option {
+	return func(k *Keyring) { k.store = s }
+}
+
+// WithClock sets the clock that refills the rate limits.
+func WithClock(c shared.Clock) Option {
+	return func(k *Keyring) { k.clock = c }
+}
+
+// Keyring validates API keys.
+type Keyring struct {
+	cfg   Config
+	store *secrets.Store
+	clock shared.Clock
+
+	mu  sync.Mutex
+	raw string // the secret as last parsed
+	// keys maps the SHA-256 of each key to its client. Looking up hashes
+	// keeps the map's timing from revealing anything about valid keys.
+	keys    map[[32]byte]string
+	clients map[string]clientLimit
+}
+
+// clientLimit is a client's rate limit; bucket is nil when unlimited.
+type clientLimit struct {
+	limit  ratelimit.Limit
+	bucket *ratelimit.Bucket
+}
+
+// New returns a Keyring, failing if the keyring secret cannot be read or
+// parsed.
+func New(ctx context.Context, cfg Config, opts ...Option) (*Keyring, error) {
+	if cfg.Secret == "" {
+		cfg.Secret = "api-keys"
+	}
+	k := &Keyring{cfg: cfg, clock: shared.RealClock()}
+	for _, opt := range opts {
+		opt(k)
+	}
+	if k.store == nil {
+		k.store = secrets.Default()
+	}
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if err := k.load(ctx); err != nil {
+		return nil, err
+	}
+	return k, nil
+}
+
+// Authenticate returns the client owning key, or ErrMissing, ErrInvalid or
+// a *LimitError.
+func (k *Keyring) Authenticate(ctx context.Context, key string) (string, error) {
+	if key == "" {
+		requestsTotal.WithLabelValues("", "missing").Inc()
+		return "", ErrMissing
+	}
+	k.mu.Lock()
+	if err := k.load(ctx); err != nil {
+		slog.WarnContext(ctx, "API keyring refresh failed, using previous keys", "secret", k.cfg.Secret, "error", err)
+	}
+	client, ok := k.keys[sha256.Sum256([]byte(key))]
+	bucket := k.clients[client].bucket
+	k.mu.Unlock()
+	if !ok {
+		requestsTotal.WithLabelValues("", "invalid").Inc()
+		return "", ErrInvalid
+	}
+	if bucket != nil {
+		if ok, wait := bucket.Allow(); !ok {
+			requestsTotal.WithLabelValues(client, "limited").Inc()
+			return client, &LimitError{Client: client, RetryAfter: wait}
+		}
+	}
+	requestsTotal.WithLabelValues(client, "ok").Inc()
+	return client, nil
+}
+
+// LimitError reports a client over its rate limit.
+type LimitError struct {
+	Client     string
+	RetryAfter time.Duration
+}
+
+func (e *LimitError) Error() string {
+	return fmt.Sprintf("apikey: rate limit exceeded for %s", e.Client)
+}
+
+// load re-parses the keyring if the secret changed. k.mu must be held.
+func (k *Keyring) load(ctx context.Context) error {
+	v, err := k.store.Get(ctx, k.cfg.Secret)
+	if err != nil {
+		return fmt.Errorf("apikey: reading keyring: %w", err)
+	}
+	raw := v.Reveal()
+	if k.keys != nil && raw == k.raw {
+		return nil
+	}
+	keys, limits, err := parseKeyring(raw)
+	if err != nil {
+		if k.keys != nil {
+			k.raw = raw // warn once per broken revision, keeping the old keys
+		}
+		return err
+	}
+	clients := make(map[string]clientLimit)
+	for _, client := range keys {
+		if _, done := clients[client]; done {
+			continue
+		}
+		lim, ok := limits[client]
+		if !ok {
+			lim = ratelimit.Limit{Rate: k.cfg.Rate, Burst: k.cfg.Burst}
+		}
+		cl := clientLimit{limit: lim}
+		// Keep the bucket of a client whose limit did not change, so
+		// rotating a key does not refill it.
+		if old, ok := k.clients[client]; ok && old.limit == lim {
+			cl = old
+		} else if !lim.Unlimited() {
+			cl.bucket = ratelimit.NewBucket(lim, k.clock)
+		}
+		clients[client] = cl
+	}
+	k.raw, k.keys, k.clients = raw, keys, clients
+	return nil
+}
+
+// parseKeyring parses the keyring secret into the clients of key hashes and
+// the limits given on their lines.
+func parseKeyring(raw string) (map[[32]byte]string, map[string]ratelimit.Limit, error) {
+	keys := make(map[[32]byte]string)
+	limits := make(map[string]ratelimit.Limit)
+	sc := bufio.NewScanner(strings.NewReader(raw))
+	for n := 1; sc.Scan(); n++ {
+		line, _, _ := strings.Cut(sc.Text(), "#")
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) < 2 || len(fields) > 3 {
+			return nil, nil, fmt.Errorf("apikey: keyring line %d: want client, key and optional rate[:burst]", n)
+		}
+		client, key := fields[0], fields[1]
+		if len(key) < MinKeyLength {
+			return nil, nil, fmt.Errorf("apikey: keyring line %d: key for %s shorter than %d characters", n, client, MinKeyLength)
+		}
+		sum := sha256.Sum256([]byte(key))
+		if other, dup := keys[sum]; dup && other != client {
+			return nil, nil, fmt.Errorf("apikey: keyring line %d: key for %s already belongs to %s", n, client, other)
+		}
+		keys[sum] = client
+		if len(fields) == 3 {
+			lim, err := parseLimit(fields[2])
+			if err != nil {
+				return nil, nil, fmt.Errorf("apikey: keyring line %d: %w", n, err)
+			}
+			if _, set := limits[client]; !set {
+				limits[client] = lim
+			}
+		}
+	}
+	return keys, limits, nil
+}
+
+func parseLimit(s string) (ratelimit.Limit, error) {
+	rateStr, burstStr, _ := strings.Cut(s, ":")
+	rate, err := strconv.ParseFloat(rateStr, 64)
+	if err != nil || rate < 0 {
+		return ratelimit.Limit{}, fmt.Errorf("rate %q: want a number of requests per second", rateStr)
+	}
+	burst := int(rate)
+	if burstStr != "" {
+		if burst, err = strconv.Atoi(burstStr); err != nil {
+			return ratelimit.Limit{}, fmt.Errorf("burst %q: %w", burstStr, err)
+		}
+	}
+	return ratelimit.Limit{Rate: rate, Burst: burst}, nil
+}
+
+type ctxKey struct{}
+
+// NewContext returns a copy of ctx carrying the authenticated client.
+func NewContext(ctx context.Context, client string) context.Context {
+	return context.WithValue(ctx, ctxKey{}, client)
+}
+
+// ClientFromContext returns the client authenticated by the interceptors or
+// middleware.
+func ClientFromContext(ctx context.Context) (string, bool) {
+	c, ok := ctx.Value(ctxKey{}).(string)
+	return c, ok
+}
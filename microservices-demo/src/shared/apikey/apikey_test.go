@@ -0,0 +1,167 @@
+package apikey
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	shared "github.com/GoogleCloudPlatform/microservices-demo/src/shared"
+	"github.com/GoogleCloudPlatform/microservices-demo/src/shared/secrets"
+)
+
+const (
+	frontendKey = "4f0c9b2e8d1a7f36c5e2b9d04a8f1e7c"
+	rotatedKey  = "b7e3a9d1c5f2084e6a9d3c7b1f5e2a8d"
+	loadgenKey  = "9d2e7a1c4b8f3e6d0a5c9b2f7e1d4a8c"
+)
+
+func newTestKeyring(t *testing.T, keyring string) (*Keyring, secrets.MapBackend, *shared.FakeClock) {
+	t.Helper()
+	backend := secrets.MapBackend{"api-keys": keyring}
+	clock := shared.NewFakeClock(time.Unix(0, 0))
+	// A zero TTL re-reads the secret on every request.
+	k, err := New(context.Background(), Config{}, WithStore(secrets.New([]secrets.Backend{backend}, secrets.WithTTL(0))), WithClock(clock))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return k, backend, clock
+}
+
+func TestAuthenticate(t *testing.T) {
+	k, _, _ := newTestKeyring(t, "# client key limit\nfrontend "+frontendKey+"\nloadgenerator "+loadgenKey+" 1:2 # slow\n")
+	ctx := context.Background()
+	if client, err := k.Authenticate(ctx, frontendKey); err != nil || client != "frontend" {
+		t.Errorf("Authenticate(frontend key) = %q, %v", client, err)
+	}
+	if _, err := k.Authenticate(ctx, ""); !errors.Is(err, ErrMissing) {
+		t.Errorf("Authenticate without a key = %v, want ErrMissing", err)
+	}
+	if _, err := k.Authenticate(ctx, "not-a-key-but-long-enough"); !errors.Is(err, ErrInvalid) {
+		t.Errorf("Authenticate with a wrong key = %v, want ErrInvalid", err)
+	}
+	for range 2 {
+		if _, err := k.Authenticate(ctx, loadgenKey); err != nil {
+			t.Fatalf("Authenticate within burst: %v", err)
+		}
+	}
+	var limited *LimitError
+	if _, err := k.Authenticate(ctx, loadgenKey); !errors.As(err, &limited) || limited.Client != "loadgenerator" || limited.RetryAfter != time.Second {
+		t.Errorf("Authenticate over the limit = %v, want a LimitError retrying in 1s", err)
+	}
+}
+
+func TestRotation(t *testing.T) {
+	k, backend, clock := newTestKeyring(t, "frontend "+frontendKey+" 1:1\n")
+	ctx := context.Background()
+	if _, err := k.Authenticate(ctx, frontendKey); err != nil {
+		t.Fatal(err)
+	}
+
+	// Adding the new key keeps the old one working and the budget shared.
+	backend["api-keys"] = "frontend " + frontendKey + " 1:1\nfrontend " + rotatedKey + "\n"
+	var limited *LimitError
+	if _, err := k.Authenticate(ctx, rotatedKey); !errors.As(err, &limited) {
+		t.Errorf("new key right after the old one = %v, want the shared limit hit", err)
+	}
+	clock.Advance(time.Second)
+	if client, err := k.Authenticate(ctx, rotatedKey); err != nil || client != "frontend" {
+		t.Errorf("Authenticate(new key) = %q, %v", client, err)
+	}
+
+	backend["api-keys"] = "frontend " + rotatedKey + "\n"
+	if _, err := k.Authenticate(ctx, frontendKey); !errors.Is(err, ErrInvalid) {
+		t.Errorf("retired key = %v, want ErrInvalid", err)
+	}
+
+	// A broken revision keeps the previous keys.
+	backend["api-keys"] = "frontend short\n"
+	if _, err := k.Authenticate(ctx, rotatedKey); err != nil {
+		t.Errorf("Authenticate after a broken update = %v", err)
+	}
+}
+
+func TestNewRejectsBadKeyring(t *testing.T) {
+	for _, keyring := range []string{
+		"frontend changeme",
+		"frontend",
+		"frontend " + frontendKey + " fast",
+		"frontend " + frontendKey + "\ncheckout " + frontendKey,
+	} {
+		store := secrets.New([]secrets.Backend{secrets.MapBackend{"api-keys": keyring}})
+		if _, err := New(context.Background(), Config{}, WithStore(store)); err == nil {
+			t.Errorf("New accepted keyring %q", keyring)
+		}
+	}
+	if _, err := New(context.Background(), Config{}, WithStore(secrets.New(nil))); !errors.Is(err, secrets.ErrNotFound) {
+		t.Errorf("New without the secret = %v, want ErrNotFound", err)
+	}
+}
+
+func TestUnaryServerInterceptor(t *testing.T) {
+	k, _, _ := newTestKeyring(t, "frontend "+frontendKey+" 1:1\n")
+	intercept := k.UnaryServerInterceptor()
+	info := &grpc.UnaryServerInfo{FullMethod: "/hipstershop.CartService/GetCart"}
+	handler := func(ctx context.Context, req any) (any, error) {
+		client, _ := ClientFromContext(ctx)
+		return client, nil
+	}
+	call := func(md metadata.MD, info *grpc.UnaryServerInfo) (any, error) {
+		return intercept(metadata.NewIncomingContext(context.Background(), md), nil, info, handler)
+	}
+
+	if got, err := call(metadata.Pairs("x-api-key", frontendKey), info); err != nil || got != "frontend" {
+		t.Errorf("call with a key = %v, %v", got, err)
+	}
+	if _, err := call(metadata.Pairs("x-api-key", frontendKey), info); status.Code(err) != codes.ResourceExhausted {
+		t.Errorf("call over the limit = %v, want ResourceExhausted", err)
+	}
+	if _, err := call(nil, info); status.Code(err) != codes.Unauthenticated {
+		t.Errorf("call without a key = %v, want Unauthenticated", err)
+	}
+	if _, err := call(nil, &grpc.UnaryServerInfo{FullMethod: "/grpc.health.v1.Health/Check"}); err != nil {
+		t.Errorf("health check without a key = %v", err)
+	}
+}
+
+func TestMiddlewareAndTransport(t *testing.T) {
+	k, _, _ := newTestKeyring(t, "frontend "+frontendKey+"\n")
+	srv := httptest.NewServer(k.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		client, _ := ClientFromContext(r.Context())
+		w.Write([]byte(client))
+	})))
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("request without a key = %d, want 401", resp.StatusCode)
+	}
+
+	client := &http.Client{Transport: Transport(secrets.NewValue(frontendKey), nil)}
+	resp, err = client.Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("request with a key = %d, want 200", resp.StatusCode)
+	}
+}
+
+func TestCredentials(t *testing.T) {
+	md, err := Credentials(secrets.NewValue(frontendKey)).GetRequestMetadata(context.Background())
+	if err != nil || md["x-api-key"] != frontendKey {
+		t.Errorf("GetRequestMetadata = %v, %v", md, err)
+	}
+}
@@ -0,0 +1,157 @@
+package apikey
+
+import (
+	"context"
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"github.com/GoogleCloudPlatform/microservices-demo/src/shared/secrets"
+)
+
+// healthPrefix is always exempt so probes keep working without keys.
+const healthPrefix = "/grpc.health.v1.Health/"
+
+// exempt reports whether method needs no key. Entries ending in / match
+// every method of a service.
+func exempt(method string, public []string) bool {
+	if strings.HasPrefix(method, healthPrefix) {
+		return true
+	}
+	for _, p := range public {
+		if p == method || (strings.HasSuffix(p, "/") && strings.HasPrefix(method, p)) {
+			return true
+		}
+	}
+	return false
+}
+
+// retryAfterSeconds rounds d up to whole seconds, at least 1.
+func retryAfterSeconds(d time.Duration) int {
+	return max(1, int(math.Ceil(d.Seconds())))
+}
+
+// authenticate checks the key in incoming gRPC metadata.
+func (k *Keyring) authenticate(ctx context.Context) (context.Context, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	var key string
+	if v := md.Get(metadataKey); len(v) > 0 {
+		key = v[0]
+	}
+	client, err := k.Authenticate(ctx, key)
+	var limited *LimitError
+	switch {
+	case errors.As(err, &limited):
+		secs := retryAfterSeconds(limited.RetryAfter)
+		grpc.SetHeader(ctx, metadata.Pairs("retry-after", strconv.Itoa(secs)))
+		return nil, status.Errorf(codes.ResourceExhausted, "rate limit exceeded for %s, retry after %ds", client, secs)
+	case err != nil:
+		return nil, status.Error(codes.Unauthenticated, err.Error())
+	}
+	return NewContext(ctx, client), nil
+}
+
+// UnaryServerInterceptor rejects calls without a valid key with
+// Unauthenticated, and calls over their client's rate limit with
+// ResourceExhausted. The gRPC health service and the given public methods
+// (full method names, or "/pkg.Service/" for a whole service) are exempt.
+func (k *Keyring) UnaryServerInterceptor(public ...string) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		if exempt(info.FullMethod, public) {
+			return handler(ctx, req)
+		}
+		ctx, err := k.authenticate(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+// StreamServerInterceptor is the streaming variant of UnaryServerInterceptor.
+// Only stream creation counts against the rate limit.
+func (k *Keyring) StreamServerInterceptor(public ...string) grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if exempt(info.FullMethod, public) {
+			return handler(srv, ss)
+		}
+		ctx, err := k.authenticate(ss.Context())
+		if err != nil {
+			return err
+		}
+		return handler(srv, &serverStream{ServerStream: ss, ctx: ctx})
+	}
+}
+
+type serverStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *serverStream) Context() context.Context { return s.ctx }
+
+// Middleware rejects HTTP requests without a valid key with 401, and those
+// over their client's rate limit with 429 and a Retry-After hint.
+func (k *Keyring) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		client, err := k.Authenticate(r.Context(), r.Header.Get(Header))
+		var limited *LimitError
+		switch {
+		case errors.As(err, &limited):
+			w.Header().Set("Retry-After", strconv.Itoa(retryAfterSeconds(limited.RetryAfter)))
+			http.Error(w, "rate limit exceeded", http.StatusTooManyRequests)
+			return
+		case errors.Is(err, ErrMissing):
+			http.Error(w, "missing API key", http.StatusUnauthorized)
+			return
+		case err != nil:
+			http.Error(w, "invalid API key", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(NewContext(r.Context(), client)))
+	})
+}
+
+// Credentials sends key with every call, for grpc.WithPerRPCCredentials.
+// Like the rest of this package it does not require transport security;
+// the key is only as private as the network it crosses.
+func Credentials(key secrets.Value) credentials.PerRPCCredentials {
+	return perRPC{key}
+}
+
+type perRPC struct{ key secrets.Value }
+
+func (c perRPC) GetRequestMetadata(context.Context, ...string) (map[string]string, error) {
+	return map[string]string{metadataKey: c.key.Reveal()}, nil
+}
+
+func (perRPC) RequireTransportSecurity() bool { return false }
+
+// Transport sends key with every request made through base (nil uses
+// http.DefaultTransport).
+func Transport(key secrets.Value, base http.RoundTripper) http.RoundTripper {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return &transport{key: key, base: base}
+}
+
+type transport struct {
+	key  secrets.Value
+	base http.RoundTripper
+}
+
+func (t *transport) RoundTrip(r *http.Request) (*http.Response, error) {
+	r = r.Clone(r.Context())
+	r.Header.Set(Header, t.key.Reveal())
+	return t.base.RoundTrip(r)
+}
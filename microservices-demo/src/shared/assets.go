@@ -0,0 +1,195 @@
+package shared
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+)
+
+// assetHashLen is the number of hex digits of the content hash put in
+// fingerprinted asset names.
+const assetHashLen = 10
+
+// assetMinCompressBytes is the size below which assets are not gzipped at
+// load time, since the saving would not be worth the extra header.
+const assetMinCompressBytes = 512
+
+// AssetOption configures AssetHandler.
+type AssetOption func(*assetConfig)
+
+type assetConfig struct {
+	prefix string
+}
+
+// WithAssetPrefix sets the URL path the handler is mounted at. The default
+// is "/static/".
+func WithAssetPrefix(prefix string) AssetOption {
+	return func(c *assetConfig) { c.prefix = "/" + strings.Trim(prefix, "/") + "/" }
+}
+
+// Assets serves a tree of static files under fingerprinted URLs, such as
+// /static/styles/cart.3f2a9c1b0d.css, which embed a hash of the content
+// and so can be cached by browsers and CDNs for a year: a changed file gets
+// a new URL. The plain URL keeps working, but must be revalidated.
+//
+// Each file is also served precompressed: from a .br or .gz file next to
+// it, as produced by a build step, or else gzipped once at load time.
+type Assets struct {
+	prefix string
+	// byName maps a file's path in the tree to it.
+	byName map[string]*asset
+	// byURL maps fingerprinted paths, relative to the prefix, to files.
+	byURL map[string]*asset
+}
+
+type asset struct {
+	url         string
+	hash        string
+	contentType string
+	// encodings holds the identity body under "" and precompressed
+	// variants under their Content-Encoding.
+	encodings map[string][]byte
+}
+
+// AssetHandler loads every file of fsys, usually an embed.FS, and returns
+// an http.Handler serving them.
+//
+// Usage:
+//
+//	//go:embed static
+//	var static embed.FS
+//
+//	sub, _ := fs.Sub(static, "static")
+//	assets, err := shared.AssetHandler(sub)
+//	...
+//	mux.Handle("/static/", assets)
+//	templates := template.Must(template.New("").Funcs(assets.FuncMap()).ParseFS(...))
+//
+// and in templates:
+//
+//	<link rel="stylesheet" href="{{ asset "styles/cart.css" }}">
+func AssetHandler(fsys fs.FS, opts ...AssetOption) (*Assets, error) {
+	cfg := assetConfig{prefix: "/static/"}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	a := &Assets{prefix: cfg.prefix, byName: make(map[string]*asset), byURL: make(map[string]*asset)}
+	err := fs.WalkDir(fsys, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		if ext := path.Ext(name); ext == ".gz" || ext == ".br" {
+			if _, err := fs.Stat(fsys, strings.TrimSuffix(name, ext)); err == nil {
+				// A precompressed variant, loaded with its original.
+				return nil
+			}
+		}
+		return a.load(fsys, name)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("assets: %w", err)
+	}
+	return a, nil
+}
+
+func (a *Assets) load(fsys fs.FS, name string) error {
+	body, err := fs.ReadFile(fsys, name)
+	if err != nil {
+		return err
+	}
+	sum := sha256.Sum256(body)
+	as := &asset{
+		hash:        hex.EncodeToString(sum[:])[:assetHashLen],
+		contentType: mime.TypeByExtension(path.Ext(name)),
+		encodings:   map[string][]byte{"": body},
+	}
+	if as.contentType == "" {
+		as.contentType = http.DetectContentType(body)
+	}
+	for enc, ext := range map[string]string{"br": ".br", "gzip": ".gz"} {
+		if b, err := fs.ReadFile(fsys, name+ext); err == nil {
+			as.encodings[enc] = b
+		}
+	}
+	if _, ok := as.encodings["gzip"]; !ok && len(body) >= assetMinCompressBytes && compressible(as.contentType) {
+		var buf bytes.Buffer
+		zw, _ := gzip.NewWriterLevel(&buf, gzip.BestCompression)
+		zw.Write(body)
+		zw.Close()
+		if buf.Len() < len(body) {
+			as.encodings["gzip"] = buf.Bytes()
+		}
+	}
+	ext := path.Ext(name)
+	as.url = strings.TrimSuffix(name, ext) + "." + as.hash + ext
+	a.byName[name] = as
+	a.byURL[as.url] = as
+	return nil
+}
+
+// Path returns the fingerprinted URL of the file at name, such as
+// "styles/cart.css". Unknown names are returned under the prefix as they
+// are, so a typo shows up as a 404 rather than a broken template.
+func (a *Assets) Path(name string) string {
+	name = strings.TrimPrefix(name, "/")
+	if as, ok := a.byName[name]; ok {
+		return a.prefix + as.url
+	}
+	return a.prefix + name
+}
+
+// FuncMap returns the "asset" template function, which is Path.
+func (a *Assets) FuncMap() template.FuncMap {
+	return template.FuncMap{"asset": a.Path}
+}
+
+// ServeHTTP serves the file named by the request path below the prefix.
+func (a *Assets) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	name := strings.TrimPrefix(r.URL.Path, a.prefix)
+	h := w.Header()
+	as, ok := a.byURL[name]
+	if ok {
+		h.Set("Cache-Control", "public, max-age=31536000, immutable")
+	} else if as, ok = a.byName[name]; ok {
+		h.Set("Cache-Control", "no-cache")
+	} else {
+		http.NotFound(w, r)
+		return
+	}
+
+	enc := ""
+	if r.Header.Get("Range") == "" {
+		for _, e := range []string{"br", "gzip"} {
+			if _, ok := as.encodings[e]; ok && acceptsEncoding(r, e) {
+				enc = e
+				break
+			}
+		}
+	}
+	if len(as.encodings) > 1 {
+		h.Add("Vary", "Accept-Encoding")
+	}
+	h.Set("Content-Type", as.contentType)
+	h.Set("X-Content-Type-Options", "nosniff")
+	etag := `"` + as.hash + `"`
+	if enc != "" {
+		h.Set("Content-Encoding", enc)
+		etag = `"` + as.hash + "-" + enc + `"`
+	}
+	h.Set("ETag", etag)
+	http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(as.encodings[enc]))
+}
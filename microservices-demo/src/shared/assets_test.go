@@ -0,0 +1,91 @@
+package shared
+
+import (
+	"bytes"
+	"compress/gzip"
+	"html/template"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestAssetHandler(t *testing.T) {
+	css := strings.Repeat("body { color: red; }\n", 100)
+	assets, err := AssetHandler(fstest.MapFS{
+		"styles/cart.css":     {Data: []byte(css)},
+		"scripts/app.js":      {Data: []byte("console.log(1)")},
+		"scripts/app.js.br":   {Data: []byte("fake brotli")},
+		"images/logo.png":     {Data: []byte("\x89PNG\r\n\x1a\n" + strings.Repeat("x", 1000))},
+		"standalone/notes.gz": {Data: []byte("not a variant")},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var out strings.Builder
+	tmpl := template.Must(template.New("").Funcs(assets.FuncMap()).Parse(`{{ asset "styles/cart.css" }}`))
+	if err := tmpl.Execute(&out, nil); err != nil {
+		t.Fatal(err)
+	}
+	url := out.String()
+	if !strings.HasPrefix(url, "/static/styles/cart.") || !strings.HasSuffix(url, ".css") || len(url) != len("/static/styles/cart.css")+assetHashLen+1 {
+		t.Fatalf("asset URL = %q", url)
+	}
+	if got := assets.Path("missing.css"); got != "/static/missing.css" {
+		t.Errorf("Path(missing) = %q", got)
+	}
+
+	get := func(path, accept string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		if accept != "" {
+			req.Header.Set("Accept-Encoding", accept)
+		}
+		rec := httptest.NewRecorder()
+		assets.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := get(url, "gzip, br")
+	if rec.Code != http.StatusOK || rec.Header().Get("Content-Encoding") != "gzip" ||
+		!strings.Contains(rec.Header().Get("Cache-Control"), "immutable") || rec.Header().Get("Content-Type") != "text/css; charset=utf-8" {
+		t.Fatalf("fingerprinted css: %d %v", rec.Code, rec.Header())
+	}
+	zr, err := gzip.NewReader(rec.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body, _ := io.ReadAll(zr); string(body) != css {
+		t.Error("gzipped body differs from the file")
+	}
+
+	if rec := get(url, ""); rec.Header().Get("Content-Encoding") != "" || rec.Body.String() != css {
+		t.Errorf("identity css: %v", rec.Header())
+	}
+	if rec := get("/static/styles/cart.css", ""); rec.Code != http.StatusOK || rec.Header().Get("Cache-Control") != "no-cache" {
+		t.Errorf("plain URL: %d %v", rec.Code, rec.Header())
+	}
+	if rec := get(assets.Path("scripts/app.js"), "br"); rec.Header().Get("Content-Encoding") != "br" || rec.Body.String() != "fake brotli" {
+		t.Errorf("precompressed br: %v %q", rec.Header(), rec.Body.String())
+	}
+	if rec := get(assets.Path("images/logo.png"), "gzip"); rec.Header().Get("Content-Encoding") != "" || !bytes.HasPrefix(rec.Body.Bytes(), []byte("\x89PNG")) {
+		t.Errorf("png compressed: %v", rec.Header())
+	}
+	if rec := get(assets.Path("standalone/notes.gz"), ""); rec.Code != http.StatusOK {
+		t.Errorf(".gz without an original not served: %d", rec.Code)
+	}
+	if rec := get("/static/scripts/app.js.br", ""); rec.Code != http.StatusNotFound {
+		t.Errorf("variant served directly: %d", rec.Code)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, url, nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	req.Header.Set("If-None-Match", rec.Header().Get("ETag"))
+	rec = httptest.NewRecorder()
+	assets.ServeHTTP(rec, req)
+	if rec.Code != http.StatusNotModified {
+		t.Errorf("revalidation: status %d, want 304", rec.Code)
+	}
+}
@@ -0,0 +1,157 @@
+// Package audit records who did what: an actor performing an action on a
+// resource, and whether it succeeded. Audit events are kept apart from the
+// application log, in a dedicated Sink (a file, a stdout stream or an
+// eventbus topic), so they can be retained and access-controlled on their
+// own terms and are never dropped by log sampling or level changes.
+//
+// Usage:
+//
+//	sink, err := audit.NewFileSink("/var/log/audit/checkout.jsonl")
+//	al := audit.New(sink, audit.WithService("checkoutservice"))
+//	defer al.Close()
+//
+//	srv := grpcserver.New(grpcserver.WithUnaryInterceptors(
+//	    audit.UnaryServerInterceptor(al)))
+//
+//	al.Record(ctx, audit.Event{Action: "order.refund", Resource: "order/" + id, Result: audit.Success})
+//
+// The actor, request ID and trace ID are taken from ctx when the event does
+// not set them, so entries can be joined with logs and traces.
+package audit
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/GoogleCloudPlatform/microservices-demo/src/shared/auth"
+	"github.com/GoogleCloudPlatform/microservices-demo/src/shared/metrics"
+	"github.com/GoogleCloudPlatform/microservices-demo/src/shared/requestid"
+)
+
+// Anonymous is the actor of events from unauthenticated callers.
+const Anonymous = "anonymous"
+
+var (
+	eventsTotal = metrics.NewCounterVec("audit_events_total",
+		"Audit events recorded, by action and result.", "action", "result")
+	writeErrorsTotal = metrics.NewCounterVec("audit_write_errors_total",
+		"Audit events the sink failed to store, by action.", "action")
+)
+
+// Result is the outcome of an audited action.
+type Result string
+
+const (
+	Success Result = "success"
+	Failure Result = "failure"
+	// Denied marks an action refused for lack of authentication or
+	// permission.
+	Denied Result = "denied"
+)
+
+// ResultOf classifies err: nil is Success, Unauthenticated and
+// PermissionDenied are Denied, anything else is Failure.
+func ResultOf(err error) Result {
+	switch status.Code(err) {
+	case codes.OK:
+		return Success
+	case codes.Unauthenticated, codes.PermissionDenied:
+		return Denied
+	default:
+		return Failure
+	}
+}
+
+// Event is one audit entry.
+type Event struct {
+	Time time.Time `json:"time"`
+	// Service is the service that performed the action.
+	Service string `json:"service,omitempty"`
+	// Actor is who performed it: the authenticated subject, or Anonymous.
+	Actor string `json:"actor"`
+	// Action names what was done, e.g. a gRPC method or "cart.empty".
+	Action string `json:"action"`
+	// Resource identifies what it was done to, e.g. "cart/<user id>".
+	Resource string `json:"resource,omitempty"`
+	Result   Result `json:"result"`
+	// Reason explains a failure or denial, e.g. a gRPC status code.
+	Reason    string            `json:"reason,omitempty"`
+	RequestID string            `json:"requestId,omitempty"`
+	TraceID   string            `json:"traceId,omitempty"`
+	Details   map[string]string `json:"details,omitempty"`
+}
+
+// Sink stores audit events. Implementations must be safe for concurrent
+// use.
+type Sink interface {
+	Write(ctx context.Context, e Event) error
+	Close() error
+}
+
+// Logger records audit events to a Sink.
+type Logger struct {
+	sink    Sink
+	service string
+	now     func() time.Time
+}
+
+// Option configures New.
+type Option func(*Logger)
+
+// WithService sets the Service of every event.
+func WithService(name string) Option {
+	return func(l *Logger) { l.service = name }
+}
+
+// New returns a Logger writing to sink.
+func New(sink Sink, opts ...Option) *Logger {
+	l := &Logger{sink: sink, now: time.Now}
+	for _, opt := range opts {
+		opt(l)
+	}
+	return l
+}
+
+// Record completes e from ctx and writes it. A sink failure is logged and
+// counted rather than returned: the action has already happened, and
+// failing the request now would not undo it.
+func (l *Logger) Record(ctx context.Context, e Event) {
+	if e.Time.IsZero() {
+		e.Time = l.now().UTC()
+	}
+	if e.Service == "" {
+		e.Service = l.service
+	}
+	if e.Actor == "" {
+		e.Actor = Anonymous
+		if user, ok := auth.UserFromContext(ctx); ok {
+			e.Actor = user
+		}
+	}
+	if e.Result == "" {
+		e.Result = Success
+	}
+	if e.RequestID == "" {
+		e.RequestID = requestid.FromContext(ctx)
+	}
+	if e.TraceID == "" {
+		if sc := trace.SpanContextFromContext(ctx); sc.HasTraceID() {
+			e.TraceID = sc.TraceID().String()
+		}
+	}
+	eventsTotal.WithLabelValues(e.Action, string(e.Result)).Inc()
+	// The caller may be gone, but the entry must still be stored.
+	if err := l.sink.Write(context.WithoutCancel(ctx), e); err != nil {
+		writeErrorsTotal.WithLabelValues(e.Action).Inc()
+		slog.ErrorContext(ctx, "audit: writing event failed",
+			"action", e.Action, "actor", e.Actor, "resource", e.Resource, "error", err)
+	}
+}
+
+// Close closes the sink.
+func (l *Logger) Close() error { return l.sink.Close() }
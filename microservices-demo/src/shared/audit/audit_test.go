@@ -0,0 +1,150 @@
+package audit
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/GoogleCloudPlatform/microservices-demo/src/shared/auth"
+	"github.com/GoogleCloudPlatform/microservices-demo/src/shared/eventbus"
+	"github.com/GoogleCloudPlatform/microservices-demo/src/shared/requestid"
+)
+
+// memorySink keeps events for inspection.
+type memorySink struct{ events []Event }
+
+func (s *memorySink) Write(_ context.Context, e Event) error {
+	s.events = append(s.events, e)
+	return nil
+}
+
+func (s *memorySink) Close() error { return nil }
+
+type addItemRequest struct{ userID string }
+
+func (r addItemRequest) GetUserId() string { return r.userID }
+
+func TestRecordFillsFromContext(t *testing.T) {
+	var buf bytes.Buffer
+	l := New(NewWriterSink(&buf), WithService("cartservice"))
+	ctx := auth.NewContext(context.Background(), &auth.Claims{Subject: "alice"})
+	ctx = requestid.NewContext(ctx, "req-1")
+	l.Record(ctx, Event{Action: "cart.empty", Resource: "cart/alice"})
+	l.Record(context.Background(), Event{Action: "cart.empty", Result: Failure})
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("got %d lines, want 2: %q", len(lines), buf.String())
+	}
+	var e Event
+	if err := json.Unmarshal([]byte(lines[0]), &e); err != nil {
+		t.Fatal(err)
+	}
+	if e.Actor != "alice" || e.Service != "cartservice" || e.RequestID != "req-1" || e.Result != Success || e.Time.IsZero() {
+		t.Errorf("first event = %+v", e)
+	}
+	if err := json.Unmarshal([]byte(lines[1]), &e); err != nil {
+		t.Fatal(err)
+	}
+	if e.Actor != Anonymous || e.Result != Failure {
+		t.Errorf("second event: actor %q, result %q; want %q, failure", e.Actor, e.Result, Anonymous)
+	}
+}
+
+func TestFileSinkAppends(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	for i := 0; i < 2; i++ {
+		sink, err := NewFileSink(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		New(sink).Record(context.Background(), Event{Action: "order.place"})
+		if err := sink.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := bytes.Count(b, []byte("\n")); n != 2 {
+		t.Errorf("file has %d lines, want 2", n)
+	}
+}
+
+func TestEventBusSink(t *testing.T) {
+	bus := eventbus.NewMemory()
+	defer bus.Close()
+	New(NewEventBusSink(bus, "")).Record(context.Background(), Event{Actor: "bob", Action: "payment.charge"})
+
+	msgs := bus.Messages(DefaultTopic)
+	if len(msgs) != 1 {
+		t.Fatalf("published %d messages, want 1", len(msgs))
+	}
+	var e Event
+	if err := eventbus.Decode(msgs[0], &e); err != nil {
+		t.Fatal(err)
+	}
+	if e.Actor != "bob" || e.Action != "payment.charge" || string(msgs[0].Key) != "bob" {
+		t.Errorf("event = %+v, key %q", e, msgs[0].Key)
+	}
+}
+
+func TestMultiSinkJoinsErrors(t *testing.T) {
+	failing := NewWriterSink(errWriter{})
+	var mem memorySink
+	err := MultiSink(failing, &mem).Write(context.Background(), Event{Action: "x"})
+	if err == nil || len(mem.events) != 1 {
+		t.Errorf("Write = %v with %d events stored; want an error and 1 event", err, len(mem.events))
+	}
+}
+
+type errWriter struct{}
+
+func (errWriter) Write([]byte) (int, error) { return 0, errors.New("disk full") }
+
+func TestMutating(t *testing.T) {
+	for method, want := range map[string]bool{
+		"/hipstershop.CartService/AddItem":        true,
+		"/hipstershop.CartService/EmptyCart":      true,
+		"/hipstershop.CheckoutService/PlaceOrder": true,
+		"/hipstershop.CartService/GetCart":        false,
+		"/hipstershop.CurrencyService/Convert":    false,
+	} {
+		if got := Mutating(method); got != want {
+			t.Errorf("Mutating(%q) = %v, want %v", method, got, want)
+		}
+	}
+}
+
+func TestUnaryServerInterceptor(t *testing.T) {
+	var mem memorySink
+	intercept := UnaryServerInterceptor(New(&mem))
+	call := func(method string, err error) {
+		intercept(context.Background(), addItemRequest{"u1"}, &grpc.UnaryServerInfo{FullMethod: method},
+			func(context.Context, any) (any, error) { return nil, err })
+	}
+
+	call("/hipstershop.CartService/GetCart", nil)
+	call("/hipstershop.CartService/AddItem", nil)
+	call("/hipstershop.CartService/EmptyCart", status.Error(codes.PermissionDenied, "no"))
+
+	if len(mem.events) != 2 {
+		t.Fatalf("recorded %d events, want 2 (reads are not audited)", len(mem.events))
+	}
+	if e := mem.events[0]; e.Action != "/hipstershop.CartService/AddItem" || e.Resource != "user/u1" || e.Result != Success {
+		t.Errorf("AddItem event = %+v", e)
+	}
+	if e := mem.events[1]; e.Result != Denied || e.Reason != "PermissionDenied" {
+		t.Errorf("EmptyCart event: result %q, reason %q; want denied, PermissionDenied", e.Result, e.Reason)
+	}
+}
@@ -0,0 +1,91 @@
+package audit
+
+import (
+	"context"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+)
+
+// mutatingVerbs are the method name prefixes Mutating treats as changing
+// state, e.g. AddItem, EmptyCart, PlaceOrder, Charge and ShipOrder.
+var mutatingVerbs = []string{
+	"Add", "Cancel", "Charge", "Create", "Delete", "Empty", "Place",
+	"Put", "Refund", "Remove", "Send", "Set", "Ship", "Update",
+}
+
+// Mutating reports whether the gRPC method named by fullMethod
+// ("/pkg.Service/Method") changes state, judged by the verb it starts with.
+func Mutating(fullMethod string) bool {
+	name := fullMethod[strings.LastIndexByte(fullMethod, '/')+1:]
+	for _, v := range mutatingVerbs {
+		if strings.HasPrefix(name, v) {
+			return true
+		}
+	}
+	return false
+}
+
+type interceptorConfig struct {
+	methods  map[string]bool
+	resource func(ctx context.Context, method string, req any) string
+}
+
+// InterceptorOption configures UnaryServerInterceptor.
+type InterceptorOption func(*interceptorConfig)
+
+// WithMethods audits exactly the given full method names instead of those
+// Mutating selects.
+func WithMethods(methods ...string) InterceptorOption {
+	return func(c *interceptorConfig) {
+		if c.methods == nil {
+			c.methods = make(map[string]bool)
+		}
+		for _, m := range methods {
+			c.methods[m] = true
+		}
+	}
+}
+
+// WithResource derives an event's Resource from the request. By default it
+// is "user/<id>" for requests with a user_id field, and empty otherwise.
+func WithResource(fn func(ctx context.Context, method string, req any) string) InterceptorOption {
+	return func(c *interceptorConfig) { c.resource = fn }
+}
+
+// defaultResource names the user a request acts on, since most boutique
+// mutations are on a user's cart or orders.
+func defaultResource(_ context.Context, _ string, req any) string {
+	if r, ok := req.(interface{ GetUserId() string }); ok && r.GetUserId() != "" {
+		return "user/" + r.GetUserId()
+	}
+	return ""
+}
+
+// UnaryServerInterceptor records an event for each call to a mutating
+// method, after the handler returns. The action is the full method name and
+// the result follows ResultOf; failures carry the status code as the reason.
+// Place it after the auth interceptor so the actor is known.
+func UnaryServerInterceptor(l *Logger, opts ...InterceptorOption) grpc.UnaryServerInterceptor {
+	cfg := interceptorConfig{resource: defaultResource}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		if cfg.methods != nil && !cfg.methods[info.FullMethod] || cfg.methods == nil && !Mutating(info.FullMethod) {
+			return handler(ctx, req)
+		}
+		resp, err := handler(ctx, req)
+		e := Event{
+			Action:   info.FullMethod,
+			Resource: cfg.resource(ctx, info.FullMethod, req),
+			Result:   ResultOf(err),
+		}
+		if err != nil {
+			e.Reason = status.Code(err).String()
+		}
+		l.Record(ctx, e)
+		return resp, err
+	}
+}
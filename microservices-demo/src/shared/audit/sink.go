@@ -0,0 +1,115 @@
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+
+	"github.com/GoogleCloudPlatform/microservices-demo/src/shared/eventbus"
+)
+
+// DefaultTopic is the eventbus topic NewEventBusSink publishes to when none
+// is given.
+const DefaultTopic = "audit.events"
+
+// writerSink writes one JSON object per line.
+type writerSink struct {
+	mu     sync.Mutex
+	w      io.Writer
+	closer io.Closer
+}
+
+// NewWriterSink writes events to w as JSON lines, for example os.Stdout in
+// a container whose log agent routes the stream to the audit store. Close
+// does not close w.
+func NewWriterSink(w io.Writer) Sink {
+	return &writerSink{w: w}
+}
+
+// NewFileSink appends events as JSON lines to the file at path, creating it
+// with mode 0600 if needed.
+func NewFileSink(path string) (Sink, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("audit: %w", err)
+	}
+	return &writerSink{w: f, closer: f}, nil
+}
+
+func (s *writerSink) Write(_ context.Context, e Event) error {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	b = append(b, '\n')
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// A single write keeps lines whole when several processes share a file.
+	_, err = s.w.Write(b)
+	return err
+}
+
+func (s *writerSink) Close() error {
+	if s.closer == nil {
+		return nil
+	}
+	return s.closer.Close()
+}
+
+// eventBusSink publishes events as JSON messages.
+type eventBusSink struct {
+	pub   eventbus.Publisher
+	topic string
+}
+
+// NewEventBusSink publishes each event to topic (DefaultTopic if empty),
+// keyed by actor so one actor's events stay in order. Close does not close
+// pub, which is usually shared.
+func NewEventBusSink(pub eventbus.Publisher, topic string) Sink {
+	if topic == "" {
+		topic = DefaultTopic
+	}
+	return &eventBusSink{pub: pub, topic: topic}
+}
+
+func (s *eventBusSink) Write(ctx context.Context, e Event) error {
+	m, err := eventbus.NewMessage(eventbus.JSON, e)
+	if err != nil {
+		return err
+	}
+	m.Key = []byte(e.Actor)
+	return s.pub.Publish(ctx, s.topic, m)
+}
+
+func (s *eventBusSink) Close() error { return nil }
+
+// multiSink writes to several sinks.
+type multiSink []Sink
+
+// MultiSink writes every event to each of sinks, returning their errors
+// joined.
+func MultiSink(sinks ...Sink) Sink { return multiSink(sinks) }
+
+func (m multiSink) Write(ctx context.Context, e Event) error {
+	var errs []error
+	for _, s := range m {
+		if err := s.Write(ctx, e); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (m multiSink) Close() error {
+	var errs []error
+	for _, s := range m {
+		if err := s.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
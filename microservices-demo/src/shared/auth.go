@@ -0,0 +1,14 @@
+package shared
+
+import (
+	"context"
+
+	"github.com/GoogleCloudPlatform/microservices-demo/src/shared/auth"
+)
+
+// UserFromContext returns the subject of the bearer token verified by the auth
+// interceptors or middleware, so handlers need not import the auth package
+// just to identify the caller.
+func UserFromContext(ctx context.Context) (string, bool) {
+	return auth.UserFromContext(ctx)
+}
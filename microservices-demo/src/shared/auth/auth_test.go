@@ -0,0 +1,195 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+type testIdP struct {
+	srv   *httptest.Server
+	rsa   *rsa.PrivateKey
+	ec    *ecdsa.PrivateKey
+	hits  int
+	extra []map[string]string
+}
+
+func newTestIdP(t *testing.T) *testIdP {
+	t.Helper()
+	idp := &testIdP{}
+	idp.rsa, _ = rsa.GenerateKey(rand.Reader, 2048)
+	idp.ec, _ = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	b64 := func(b []byte) string { return base64.RawURLEncoding.EncodeToString(b) }
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `{"jwks_uri":%q}`, idp.srv.URL+"/jwks")
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		idp.hits++
+		keys := []map[string]string{
+			{"kty": "RSA", "kid": "rsa1", "use": "sig", "n": b64(idp.rsa.N.Bytes()), "e": b64(big.NewInt(int64(idp.rsa.E)).Bytes())},
+			{"kty": "EC", "kid": "ec1", "crv": "P-256", "x": b64(idp.ec.X.FillBytes(make([]byte, 32))), "y": b64(idp.ec.Y.FillBytes(make([]byte, 32)))},
+		}
+		json.NewEncoder(w).Encode(map[string]any{"keys": append(keys, idp.extra...)})
+	})
+	idp.srv = httptest.NewServer(mux)
+	t.Cleanup(idp.srv.Close)
+	return idp
+}
+
+func (idp *testIdP) sign(t *testing.T, alg, kid string, claims map[string]any) string {
+	t.Helper()
+	enc := func(v any) string {
+		b, _ := json.Marshal(v)
+		return base64.RawURLEncoding.EncodeToString(b)
+	}
+	signed := enc(map[string]string{"alg": alg, "kid": kid, "typ": "JWT"}) + "." + enc(claims)
+	digest := sha256.Sum256([]byte(signed))
+	var sig []byte
+	switch alg {
+	case "RS256":
+		sig, _ = rsa.SignPKCS1v15(rand.Reader, idp.rsa, crypto.SHA256, digest[:])
+	case "ES256":
+		r, s, _ := ecdsa.Sign(rand.Reader, idp.ec, digest[:])
+		sig = append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func (idp *testIdP) claims(sub string) map[string]any {
+	return map[string]any{
+		"iss": idp.srv.URL,
+		"aud": []string{"checkoutservice"},
+		"sub": sub,
+		"exp": time.Now().Add(time.Hour).Unix(),
+	}
+}
+
+func newTestVerifier(t *testing.T, idp *testIdP) *Verifier {
+	t.Helper()
+	v, err := NewVerifier(context.Background(), Config{Issuer: idp.srv.URL, Audience: "checkoutservice"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return v
+}
+
+func TestVerify(t *testing.T) {
+	idp := newTestIdP(t)
+	v := newTestVerifier(t, idp)
+
+	for _, alg := range []string{"RS256", "ES256"} {
+		kid := map[string]string{"RS256": "rsa1", "ES256": "ec1"}[alg]
+		c, err := v.Verify(context.Background(), idp.sign(t, alg, kid, idp.claims("user-1")))
+		if err != nil {
+			t.Errorf("%s: Verify: %v", alg, err)
+			continue
+		}
+		if c.Subject != "user-1" {
+			t.Errorf("%s: Subject = %q", alg, c.Subject)
+		}
+	}
+	if idp.hits != 1 {
+		t.Errorf("JWKS fetched %d times, want 1 (cached)", idp.hits)
+	}
+}
+
+func TestVerifyRejects(t *testing.T) {
+	idp := newTestIdP(t)
+	v := newTestVerifier(t, idp)
+
+	expired := idp.claims("u")
+	expired["exp"] = time.Now().Add(-time.Hour).Unix()
+	wrongAud := idp.claims("u")
+	wrongAud["aud"] = "frontend"
+	good := idp.sign(t, "RS256", "rsa1", idp.claims("u"))
+
+	tests := []struct {
+		name  string
+		token string
+		want  error
+	}{
+		{"expired", idp.sign(t, "RS256", "rsa1", expired), ErrExpired},
+		{"audience", idp.sign(t, "RS256", "rsa1", wrongAud), ErrInvalidClaim},
+		{"tampered", good[:len(good)-4] + "AAAA", ErrSignature},
+		{"unknown kid", idp.sign(t, "RS256", "nope", idp.claims("u")), ErrSignature},
+		{"alg none", idp.sign(t, "none", "rsa1", idp.claims("u")), ErrSignature},
+		{"garbage", "not-a-jwt", ErrMalformed},
+	}
+	for _, tt := range tests {
+		if _, err := v.Verify(context.Background(), tt.token); !errors.Is(err, tt.want) {
+			t.Errorf("%s: Verify = %v, want %v", tt.name, err, tt.want)
+		}
+	}
+}
+
+func TestUnaryServerInterceptor(t *testing.T) {
+	idp := newTestIdP(t)
+	v := newTestVerifier(t, idp)
+	intercept := v.UnaryServerInterceptor("/hipstershop.ProductCatalogService/")
+	var user string
+	handler := func(ctx context.Context, req any) (any, error) {
+		user, _ = UserFromContext(ctx)
+		return nil, nil
+	}
+
+	ctx := metadata.NewIncomingContext(context.Background(),
+		metadata.Pairs("authorization", "Bearer "+idp.sign(t, "RS256", "rsa1", idp.claims("alice"))))
+	if _, err := intercept(ctx, nil, &grpc.UnaryServerInfo{FullMethod: "/hipstershop.CheckoutService/PlaceOrder"}, handler); err != nil {
+		t.Fatal(err)
+	}
+	if user != "alice" {
+		t.Errorf("UserFromContext = %q, want alice", user)
+	}
+
+	_, err := intercept(context.Background(), nil, &grpc.UnaryServerInfo{FullMethod: "/hipstershop.CheckoutService/PlaceOrder"}, handler)
+	if status.Code(err) != codes.Unauthenticated {
+		t.Errorf("call without token = %v, want Unauthenticated", err)
+	}
+	for _, m := range []string{"/hipstershop.ProductCatalogService/ListProducts", "/grpc.health.v1.Health/Check"} {
+		if _, err := intercept(context.Background(), nil, &grpc.UnaryServerInfo{FullMethod: m}, handler); err != nil {
+			t.Errorf("%s without token = %v, want exempt", m, err)
+		}
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	idp := newTestIdP(t)
+	v := newTestVerifier(t, idp)
+	h := v.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, _ := UserFromContext(r.Context())
+		fmt.Fprint(w, user)
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "/cart", nil)
+	req.Header.Set("Authorization", "Bearer "+idp.sign(t, "ES256", "ec1", idp.claims("bob")))
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK || rec.Body.String() != "bob" {
+		t.Errorf("with token = %d %q", rec.Code, rec.Body)
+	}
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/cart", nil))
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("without token = %d, want 401", rec.Code)
+	}
+}
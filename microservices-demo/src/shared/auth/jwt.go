@@ -0,0 +1,178 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+)
+
+// Errors returned by Verify. They are wrapped with details.
+var (
+	ErrMalformed    = errors.New("auth: malformed token")
+	ErrSignature    = errors.New("auth: invalid token signature")
+	ErrExpired      = errors.New("auth: token expired")
+	ErrInvalidClaim = errors.New("auth: invalid token claims")
+)
+
+// Claims are the verified claims of a token.
+type Claims struct {
+	Subject   string    `json:"sub"`
+	Issuer    string    `json:"iss"`
+	Audience  Audience  `json:"aud"`
+	ExpiresAt time.Time `json:"-"`
+	IssuedAt  time.Time `json:"-"`
+	NotBefore time.Time `json:"-"`
+	Email     string    `json:"email,omitempty"`
+	Scope     string    `json:"scope,omitempty"`
+	// Raw holds every claim, including ones without a field above.
+	Raw map[string]any `json:"-"`
+}
+
+// HasScope reports whether the space-separated scope claim contains s.
+func (c *Claims) HasScope(s string) bool {
+	for _, f := range strings.Fields(c.Scope) {
+		if f == s {
+			return true
+		}
+	}
+	return false
+}
+
+// Audience is the aud claim, which may be a single string or a list.
+type Audience []string
+
+// UnmarshalJSON accepts both forms.
+func (a *Audience) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err == nil {
+		*a = Audience{s}
+		return nil
+	}
+	var l []string
+	if err := json.Unmarshal(b, &l); err != nil {
+		return err
+	}
+	*a = l
+	return nil
+}
+
+// Contains reports whether aud is one of the audiences.
+func (a Audience) Contains(aud string) bool {
+	for _, x := range a {
+		if x == aud {
+			return true
+		}
+	}
+	return false
+}
+
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+}
+
+// parsed is a token split into its parts, before signature verification.
+type parsed struct {
+	header    jwtHeader
+	claims    Claims
+	signed    string
+	signature []byte
+}
+
+func parse(token string) (*parsed, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("%w: expected 3 segments", ErrMalformed)
+	}
+	var p parsed
+	if err := decodeSegment(parts[0], &p.header); err != nil {
+		return nil, fmt.Errorf("%w: header: %v", ErrMalformed, err)
+	}
+	if err := decodeSegment(parts[1], &p.claims); err != nil {
+		return nil, fmt.Errorf("%w: claims: %v", ErrMalformed, err)
+	}
+	if err := decodeSegment(parts[1], &p.claims.Raw); err != nil {
+		return nil, fmt.Errorf("%w: claims: %v", ErrMalformed, err)
+	}
+	p.claims.ExpiresAt = numericDate(p.claims.Raw["exp"])
+	p.claims.IssuedAt = numericDate(p.claims.Raw["iat"])
+	p.claims.NotBefore = numericDate(p.claims.Raw["nbf"])
+
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("%w: signature: %v", ErrMalformed, err)
+	}
+	p.signed = parts[0] + "." + parts[1]
+	p.signature = sig
+	return &p, nil
+}
+
+func decodeSegment(seg string, v any) error {
+	b, err := base64.RawURLEncoding.DecodeString(seg)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
+
+// numericDate converts a JSON NumericDate to a time; missing is zero.
+func numericDate(v any) time.Time {
+	f, ok := v.(float64)
+	if !ok {
+		return time.Time{}
+	}
+	sec := int64(f)
+	return time.Unix(sec, int64((f-float64(sec))*1e9))
+}
+
+// algorithms maps JWS algorithm names to hashes. Only asymmetric
+// algorithms are accepted, so a leaked JWKS can never mint tokens and "none"
+// is rejected outright.
+var algorithms = map[string]crypto.Hash{
+	"RS256": crypto.SHA256, "RS384": crypto.SHA384, "RS512": crypto.SHA512,
+	"ES256": crypto.SHA256, "ES384": crypto.SHA384, "ES512": crypto.SHA512,
+}
+
+// verifySignature checks p's signature with key.
+func verifySignature(p *parsed, key crypto.PublicKey) error {
+	hash, ok := algorithms[p.header.Alg]
+	if !ok {
+		return fmt.Errorf("%w: unsupported algorithm %q", ErrSignature, p.header.Alg)
+	}
+	h := hash.New()
+	h.Write([]byte(p.signed))
+	digest := h.Sum(nil)
+
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		if !strings.HasPrefix(p.header.Alg, "RS") {
+			return fmt.Errorf("%w: %s token with RSA key", ErrSignature, p.header.Alg)
+		}
+		if err := rsa.VerifyPKCS1v15(k, hash, digest, p.signature); err != nil {
+			return ErrSignature
+		}
+	case *ecdsa.PublicKey:
+		if !strings.HasPrefix(p.header.Alg, "ES") {
+			return fmt.Errorf("%w: %s token with EC key", ErrSignature, p.header.Alg)
+		}
+		size := (k.Curve.Params().BitSize + 7) / 8
+		if len(p.signature) != 2*size {
+			return ErrSignature
+		}
+		r := new(big.Int).SetBytes(p.signature[:size])
+		s := new(big.Int).SetBytes(p.signature[size:])
+		if !ecdsa.Verify(k, digest, r, s) {
+			return ErrSignature
+		}
+	default:
+		return fmt.Errorf("%w: unsupported key type %T", ErrSignature, key)
+	}
+	return nil
+}
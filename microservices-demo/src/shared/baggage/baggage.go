@@ -0,0 +1,245 @@
+// Package baggage carries a small set of request attributes, such as the
+// user, session, experiment bucket and tenant, across HTTP and gRPC hops, so
+// services stop inventing their own header names for them.
+//
+// Values travel in the W3C baggage header (https://www.w3.org/TR/baggage/),
+// the one the OpenTelemetry propagator installed by package tracing already
+// understands, so they also flow through services that only use otelgrpc or
+// otelhttp. Entries logged with a logging.New logger get a field per value,
+// and the interceptors and middleware add them to the current span.
+//
+// Usage, at the edge:
+//
+//	ctx = baggage.Set(ctx, baggage.UserID, userID)
+//	ctx = baggage.Set(ctx, baggage.SessionID, sessionID)
+//
+// and further down the call chain:
+//
+//	if baggage.Get(ctx, baggage.ExperimentBucket) == "treatment" { ... }
+//
+// Baggage is visible to every downstream service and to anything that can
+// read request headers; do not put secrets or personal data beyond opaque
+// IDs in it.
+package baggage
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"go.opentelemetry.io/otel/attribute"
+	otelbaggage "go.opentelemetry.io/otel/baggage"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// Header is the HTTP header, and gRPC metadata key, carrying baggage.
+const Header = "baggage"
+
+// Key names a baggage member. The well-known keys follow the OpenTelemetry
+// semantic conventions, so they double as span attribute names.
+type Key string
+
+// Well-known keys.
+const (
+	UserID           Key = "user.id"
+	SessionID        Key = "session.id"
+	ExperimentBucket Key = "experiment.bucket"
+	Tenant           Key = "tenant.id"
+)
+
+// Known lists the well-known keys, in the order they are logged.
+var Known = []Key{UserID, SessionID, ExperimentBucket, Tenant}
+
+// maxValueLen bounds a single value so one caller cannot push every later
+// request past the header size limits.
+const maxValueLen = 256
+
+// LogField returns the field name the key is logged under, e.g. user_id.
+func (k Key) LogField() string {
+	return strings.ReplaceAll(string(k), ".", "_")
+}
+
+var propagator = propagation.Baggage{}
+
+// Set returns a copy of ctx with key set to value, replacing any previous
+// value. An empty value removes the key. Values longer than 256 bytes, and
+// keys that are not valid baggage keys, leave ctx unchanged.
+func Set(ctx context.Context, key Key, value string) context.Context {
+	bag := otelbaggage.FromContext(ctx)
+	if value == "" {
+		return otelbaggage.ContextWithBaggage(ctx, bag.DeleteMember(string(key)))
+	}
+	if len(value) > maxValueLen {
+		return ctx
+	}
+	m, err := otelbaggage.NewMemberRaw(string(key), value)
+	if err != nil {
+		return ctx
+	}
+	bag, err = bag.SetMember(m)
+	if err != nil {
+		return ctx
+	}
+	return otelbaggage.ContextWithBaggage(ctx, bag)
+}
+
+// Get returns the value of key in ctx, or "" if it is not set.
+func Get(ctx context.Context, key Key) string {
+	return otelbaggage.FromContext(ctx).Member(string(key)).Value()
+}
+
+// LogAttrs returns a log attribute for each well-known key set in ctx.
+func LogAttrs(ctx context.Context) []slog.Attr {
+	bag := otelbaggage.FromContext(ctx)
+	if bag.Len() == 0 {
+		return nil
+	}
+	var attrs []slog.Attr
+	for _, k := range Known {
+		if v := bag.Member(string(k)).Value(); v != "" {
+			attrs = append(attrs, slog.String(k.LogField(), v))
+		}
+	}
+	return attrs
+}
+
+// SpanAttrs returns a span attribute for each well-known key set in ctx.
+func SpanAttrs(ctx context.Context) []attribute.KeyValue {
+	bag := otelbaggage.FromContext(ctx)
+	if bag.Len() == 0 {
+		return nil
+	}
+	var attrs []attribute.KeyValue
+	for _, k := range Known {
+		if v := bag.Member(string(k)).Value(); v != "" {
+			attrs = append(attrs, attribute.String(string(k), v))
+		}
+	}
+	return attrs
+}
+
+// annotate adds the well-known values to the span in ctx, if it records.
+func annotate(ctx context.Context) {
+	span := trace.SpanFromContext(ctx)
+	if !span.IsRecording() {
+		return
+	}
+	if attrs := SpanAttrs(ctx); len(attrs) > 0 {
+		span.SetAttributes(attrs...)
+	}
+}
+
+// Middleware stores the baggage of incoming requests in their context. It
+// should run inside the tracing middleware so the values reach its span.
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := propagator.Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+		annotate(ctx)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// Transport returns an http.RoundTripper that sends the baggage of outgoing
+// requests' contexts, unless the request already has a baggage header. A
+// nil base uses http.DefaultTransport.
+func Transport(base http.RoundTripper) http.RoundTripper {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return roundTripper{base}
+}
+
+type roundTripper struct{ base http.RoundTripper }
+
+func (t roundTripper) RoundTrip(r *http.Request) (*http.Response, error) {
+	if otelbaggage.FromContext(r.Context()).Len() > 0 && r.Header.Get(Header) == "" {
+		r = r.Clone(r.Context())
+		propagator.Inject(r.Context(), propagation.HeaderCarrier(r.Header))
+	}
+	return t.base.RoundTrip(r)
+}
+
+// metadataCarrier adapts gRPC metadata to propagation.TextMapCarrier.
+type metadataCarrier metadata.MD
+
+func (c metadataCarrier) Get(key string) string {
+	if v := metadata.MD(c).Get(key); len(v) > 0 {
+		return v[0]
+	}
+	return ""
+}
+
+func (c metadataCarrier) Set(key, value string) { metadata.MD(c).Set(key, value) }
+
+func (c metadataCarrier) Keys() []string {
+	keys := make([]string, 0, len(c))
+	for k := range c {
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+// incoming stores the baggage of an incoming RPC in its context.
+func incoming(ctx context.Context) context.Context {
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		ctx = propagator.Extract(ctx, metadataCarrier(md))
+	}
+	annotate(ctx)
+	return ctx
+}
+
+// outgoing attaches the context's baggage to outgoing metadata unless the
+// caller already set a baggage entry.
+func outgoing(ctx context.Context) context.Context {
+	if otelbaggage.FromContext(ctx).Len() == 0 {
+		return ctx
+	}
+	md, _ := metadata.FromOutgoingContext(ctx)
+	if len(md.Get(Header)) > 0 {
+		return ctx
+	}
+	md = md.Copy()
+	propagator.Inject(ctx, metadataCarrier(md))
+	return metadata.NewOutgoingContext(ctx, md)
+}
+
+// UnaryServerInterceptor stores the caller's baggage in the handler's
+// context.
+func UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		return handler(incoming(ctx), req)
+	}
+}
+
+// StreamServerInterceptor stores the caller's baggage in the stream's
+// context.
+func StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		return handler(srv, &serverStream{ServerStream: ss, ctx: incoming(ss.Context())})
+	}
+}
+
+type serverStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *serverStream) Context() context.Context { return s.ctx }
+
+// UnaryClientInterceptor forwards the context's baggage.
+func UnaryClientInterceptor() grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		return invoker(outgoing(ctx), method, req, reply, cc, opts...)
+	}
+}
+
+// StreamClientInterceptor forwards the context's baggage.
+func StreamClientInterceptor() grpc.StreamClientInterceptor {
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		return streamer(outgoing(ctx), desc, cc, method, opts...)
+	}
+}
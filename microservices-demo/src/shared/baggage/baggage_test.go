@@ -0,0 +1,87 @@
+package baggage
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+func TestSetGet(t *testing.T) {
+	ctx := Set(context.Background(), UserID, "usr_1")
+	ctx = Set(ctx, ExperimentBucket, "new checkout")
+	if got := Get(ctx, UserID); got != "usr_1" {
+		t.Errorf("Get(UserID) = %q, want usr_1", got)
+	}
+	if got := Get(ctx, ExperimentBucket); got != "new checkout" {
+		t.Errorf("Get(ExperimentBucket) = %q, want %q", got, "new checkout")
+	}
+	if got := Get(Set(ctx, UserID, ""), UserID); got != "" {
+		t.Errorf("Get after removing = %q, want empty", got)
+	}
+	if got := Get(Set(ctx, UserID, strings.Repeat("x", maxValueLen+1)), UserID); got != "usr_1" {
+		t.Errorf("oversized value replaced the previous one, got %q", got)
+	}
+}
+
+func TestLogAttrs(t *testing.T) {
+	ctx := Set(context.Background(), Tenant, "acme")
+	ctx = Set(ctx, UserID, "usr_1")
+	ctx = Set(ctx, "other", "ignored")
+	attrs := LogAttrs(ctx)
+	if len(attrs) != 2 || attrs[0].Key != "user_id" || attrs[1].Key != "tenant_id" || attrs[1].Value.String() != "acme" {
+		t.Errorf("LogAttrs = %v, want [user_id=usr_1 tenant_id=acme]", attrs)
+	}
+}
+
+func TestHTTPRoundTrip(t *testing.T) {
+	var got string
+	srv := httptest.NewServer(Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = Get(r.Context(), SessionID)
+	})))
+	defer srv.Close()
+
+	client := &http.Client{Transport: Transport(nil)}
+	req, _ := http.NewRequestWithContext(Set(context.Background(), SessionID, "s=1;2"), http.MethodGet, srv.URL, nil)
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if got != "s=1;2" {
+		t.Errorf("server saw session %q, want %q", got, "s=1;2")
+	}
+}
+
+func TestGRPCRoundTrip(t *testing.T) {
+	ctx := Set(context.Background(), UserID, "usr_1")
+	var md metadata.MD
+	UnaryClientInterceptor()(ctx, "/svc/M", nil, nil, nil, func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+		md, _ = metadata.FromOutgoingContext(ctx)
+		return nil
+	})
+	if len(md.Get(Header)) != 1 {
+		t.Fatalf("outgoing metadata = %v, want one %s entry", md, Header)
+	}
+
+	var got string
+	UnaryServerInterceptor()(metadata.NewIncomingContext(context.Background(), md), nil, &grpc.UnaryServerInfo{}, func(ctx context.Context, req any) (any, error) {
+		got = Get(ctx, UserID)
+		return nil, nil
+	})
+	if got != "usr_1" {
+		t.Errorf("server saw user %q, want usr_1", got)
+	}
+}
+
+func TestOutgoingKeepsCallerHeader(t *testing.T) {
+	ctx := metadata.AppendToOutgoingContext(Set(context.Background(), UserID, "usr_1"), Header, "user.id=explicit")
+	md, _ := metadata.FromOutgoingContext(outgoing(ctx))
+	if v := md.Get(Header); len(v) != 1 || v[0] != "user.id=explicit" {
+		t.Errorf("baggage = %q, want the caller's", v)
+	}
+}
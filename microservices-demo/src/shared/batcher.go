@@ -0,0 +1,275 @@
+package shared
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/GoogleCloudPlatform/microservices-demo/src/shared/metrics"
+)
+
+var (
+	batchesTotal = metrics.NewCounterVec("batcher_batches_total",
+		"Batched downstream calls, by batcher and trigger (size, wait).", "batcher", "trigger")
+	batchSize = metrics.NewHistogramVec("batcher_batch_size",
+		"Distinct keys per batched downstream call, by batcher.",
+		[]float64{1, 2, 5, 10, 20, 50, 100, 200, 500}, "batcher")
+)
+
+// Batcher defaults.
+const (
+	DefaultBatchMaxSize = 100
+	DefaultBatchWait    = 2 * time.Millisecond
+)
+
+// ErrBatchNoResult is returned for a key the batch function left out of its
+// results, such as an unknown product ID.
+var ErrBatchNoResult = errors.New("batcher: no result for key")
+
+// BatchFunc loads many keys in one downstream call. Keys it has no value for
+// may be left out of the result.
+type BatchFunc[K comparable, V any] func(ctx context.Context, keys []K) (map[K]V, error)
+
+type batchConfig struct {
+	name    string
+	maxSize int
+	wait    time.Duration
+	clock   Clock
+}
+
+// BatchOption configures a Batcher.
+type BatchOption func(*batchConfig)
+
+// WithBatchName sets the batcher label on the metrics (default "default").
+func WithBatchName(name string) BatchOption {
+	return func(c *batchConfig) { c.name = name }
+}
+
+// WithBatchMaxSize dispatches a batch as soon as it holds n distinct keys.
+func WithBatchMaxSize(n int) BatchOption {
+	return func(c *batchConfig) {
+		if n > 0 {
+			c.maxSize = n
+		}
+	}
+}
+
+// WithBatchWait sets how long a batch collects keys after its first one
+// before it is dispatched. Longer waits make bigger batches and slower
+// calls.
+func WithBatchWait(d time.Duration) BatchOption {
+	return func(c *batchConfig) { c.wait = d }
+}
+
+// WithBatchClock sets the clock timing the waits, for tests.
+func WithBatchClock(clk Clock) BatchOption {
+	return func(c *batchConfig) { c.clock = clk }
+}
+
+// Batcher coalesces the loads of concurrent callers into batched downstream
+// calls, dataloader-style: keys are collected until DefaultBatchMaxSize of
+// them are pending or DefaultBatchWait has passed since the first, then
+// loaded with one call. Each key appears once per call however many callers
+// asked for it.
+//
+// The call runs with the values, such as the trace, of the context of the
+// batch's first caller, and is canceled once every caller waiting on it has
+// given up; a caller giving up only stops its own wait.
+//
+//	products := shared.NewBatcher(func(ctx context.Context, ids []string) (map[string]*pb.Product, error) {
+//	    resp, err := catalog.GetProducts(ctx, &pb.GetProductsRequest{Ids: ids})
+//	    ...
+//	}, shared.WithBatchName("products"))
+//
+//	p, err := products.Load(ctx, id)
+type Batcher[K comparable, V any] struct {
+	fn  BatchFunc[K, V]
+	cfg batchConfig
+
+	mu      sync.Mutex
+	pending *batch[K, V]
+}
+
+// batch is one downstream call, collecting keys until it is dispatched.
+type batch[K comparable, V any] struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	full   chan struct{} // closed when maxSize keys are pending
+	done   chan struct{} // closed when results are in
+
+	// Guarded by the Batcher's mu.
+	keys       []K
+	waiters    map[K]int
+	total      int // waiters of all keys
+	dispatched bool
+
+	results map[K]V
+	err     error
+}
+
+// NewBatcher returns a Batcher loading keys with fn.
+func NewBatcher[K comparable, V any](fn BatchFunc[K, V], opts ...BatchOption) *Batcher[K, V] {
+	cfg := batchConfig{name: "default", maxSize: DefaultBatchMaxSize, wait: DefaultBatchWait}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	cfg.clock = clockOrReal(cfg.clock)
+	return &Batcher[K, V]{fn: fn, cfg: cfg}
+}
+
+// BatchFuture is the pending result of one key.
+type BatchFuture[K comparable, V any] struct {
+	b         *Batcher[K, V]
+	batch     *batch[K, V]
+	key       K
+	abandoned bool
+}
+
+// Load returns the value of key, waiting for its batch to complete or ctx
+// to be done.
+func (b *Batcher[K, V]) Load(ctx context.Context, key K) (V, error) {
+	return b.Submit(ctx, key).Wait(ctx)
+}
+
+// LoadMany submits every key before waiting, so they share batches, and
+// returns the values found. The error is the first one met, other than
+// ErrBatchNoResult.
+func (b *Batcher[K, V]) LoadMany(ctx context.Context, keys []K) (map[K]V, error) {
+	futures := make([]*BatchFuture[K, V], len(keys))
+	for i, k := range keys {
+		futures[i] = b.Submit(ctx, k)
+	}
+	out := make(map[K]V, len(keys))
+	var firstErr error
+	for _, f := range futures {
+		v, err := f.Wait(ctx)
+		switch {
+		case err == nil:
+			out[f.key] = v
+		case !errors.Is(err, ErrBatchNoResult) && firstErr == nil:
+			firstErr = err
+		}
+	}
+	return out, firstErr
+}
+
+// Submit adds key to the pending batch without waiting. ctx contributes its
+// values to the call if it starts a new batch.
+func (b *Batcher[K, V]) Submit(ctx context.Context, key K) *BatchFuture[K, V] {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	bt := b.pending
+	if bt == nil {
+		bt = b.newBatch(ctx)
+	}
+	if _, ok := bt.waiters[key]; !ok {
+		bt.keys = append(bt.keys, key)
+	}
+	bt.waiters[key]++
+	bt.total++
+	if len(bt.keys) >= b.cfg.maxSize {
+		b.pending = nil
+		close(bt.full)
+	}
+	return &BatchFuture[K, V]{b: b, batch: bt, key: key}
+}
+
+// newBatch starts a batch and its dispatch timer. b.mu must be held.
+func (b *Batcher[K, V]) newBatch(ctx context.Context) *batch[K, V] {
+	callCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+	bt := &batch[K, V]{
+		ctx:     callCtx,
+		cancel:  cancel,
+		full:    make(chan struct{}),
+		done:    make(chan struct{}),
+		waiters: make(map[K]int),
+	}
+	b.pending = bt
+	timer := b.cfg.clock.NewTimer(b.cfg.wait)
+	go func() {
+		trigger := "size"
+		select {
+		case <-bt.full:
+			timer.Stop()
+		case <-timer.C():
+			trigger = "wait"
+		}
+		b.dispatch(bt, trigger)
+	}()
+	return bt
+}
+
+// dispatch loads the keys of bt that callers still wait for.
+func (b *Batcher[K, V]) dispatch(bt *batch[K, V], trigger string) {
+	b.mu.Lock()
+	if b.pending == bt {
+		b.pending = nil
+	}
+	bt.dispatched = true
+	keys := make([]K, 0, len(bt.keys))
+	for _, k := range bt.keys {
+		if bt.waiters[k] > 0 {
+			keys = append(keys, k)
+		}
+	}
+	b.mu.Unlock()
+	defer bt.cancel()
+	defer close(bt.done)
+	if len(keys) == 0 {
+		bt.err = context.Canceled
+		return
+	}
+	batchesTotal.WithLabelValues(b.cfg.name, trigger).Inc()
+	batchSize.WithLabelValues(b.cfg.name).Observe(float64(len(keys)))
+	bt.results, bt.err = b.call(bt.ctx, keys)
+}
+
+// call runs the batch function, turning a panic into an error so it
+// cannot leave the batch's callers waiting.
+func (b *Batcher[K, V]) call(ctx context.Context, keys []K) (results map[K]V, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("batcher %s: batch function panicked: %v", b.cfg.name, r)
+		}
+	}()
+	return b.fn(ctx, keys)
+}
+
+// Wait returns the value of the future's key, waiting for its batch to
+// complete or ctx to be done.
+func (f *BatchFuture[K, V]) Wait(ctx context.Context) (V, error) {
+	var zero V
+	select {
+	case <-f.batch.done:
+	case <-ctx.Done():
+		f.abandon()
+		return zero, ctx.Err()
+	}
+	if f.batch.err != nil {
+		return zero, f.batch.err
+	}
+	v, ok := f.batch.results[f.key]
+	if !ok {
+		return zero, ErrBatchNoResult
+	}
+	return v, nil
+}
+
+// abandon withdraws the future from its batch, canceling the call when no
+// caller is left.
+func (f *BatchFuture[K, V]) abandon() {
+	f.b.mu.Lock()
+	defer f.b.mu.Unlock()
+	if f.abandoned {
+		return
+	}
+	f.abandoned = true
+	bt := f.batch
+	bt.waiters[f.key]--
+	bt.total--
+	if bt.total == 0 && bt.dispatched {
+		bt.cancel()
+	}
+}
@@ -0,0 +1,133 @@
+package shared
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+// recordingBatch returns a BatchFunc that records its calls and maps each
+// key k to "v"+k, leaving out "missing".
+func recordingBatch() (BatchFunc[string, string], func() [][]string) {
+	var mu sync.Mutex
+	var calls [][]string
+	fn := func(_ context.Context, keys []string) (map[string]string, error) {
+		mu.Lock()
+		calls = append(calls, slices.Clone(keys))
+		mu.Unlock()
+		out := make(map[string]string)
+		for _, k := range keys {
+			if k != "missing" {
+				out[k] = "v" + k
+			}
+		}
+		return out, nil
+	}
+	return fn, func() [][]string {
+		mu.Lock()
+		defer mu.Unlock()
+		return calls
+	}
+}
+
+func TestBatcherCoalescesConcurrentLoads(t *testing.T) {
+	fn, calls := recordingBatch()
+	b := NewBatcher(fn, WithBatchWait(20*time.Millisecond))
+	var wg sync.WaitGroup
+	for i := range 30 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			key := fmt.Sprint(i % 10)
+			if v, err := b.Load(context.Background(), key); err != nil || v != "v"+key {
+				t.Errorf("Load(%s) = %q, %v", key, v, err)
+			}
+		}()
+	}
+	wg.Wait()
+	got := calls()
+	if len(got) != 1 || len(got[0]) != 10 {
+		t.Errorf("calls = %v, want one call with the 10 distinct keys", got)
+	}
+	if _, err := b.Load(context.Background(), "missing"); !errors.Is(err, ErrBatchNoResult) {
+		t.Errorf("Load of a key left out = %v, want ErrBatchNoResult", err)
+	}
+}
+
+func TestBatcherDispatchesFullBatches(t *testing.T) {
+	fn, calls := recordingBatch()
+	clock := NewFakeClock(time.Unix(0, 0))
+	b := NewBatcher(fn, WithBatchMaxSize(3), WithBatchWait(time.Hour), WithBatchClock(clock))
+	got, err := b.LoadMany(context.Background(), []string{"a", "b", "a", "c", "d", "e", "f"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 6 || got["f"] != "vf" {
+		t.Errorf("LoadMany = %v", got)
+	}
+	if c := calls(); len(c) != 2 || len(c[0]) != 3 || len(c[1]) != 3 {
+		t.Errorf("calls = %v, want two full batches", c)
+	}
+
+	// A batch that never fills goes out on the timer.
+	done := make(chan error, 1)
+	go func() {
+		_, err := b.Load(context.Background(), "g")
+		done <- err
+	}()
+	for len(calls()) == 2 {
+		clock.Advance(time.Hour)
+		time.Sleep(time.Millisecond)
+	}
+	if err := <-done; err != nil {
+		t.Error(err)
+	}
+}
+
+func TestBatcherCancellation(t *testing.T) {
+	started := make(chan struct{})
+	callErr := make(chan error, 1)
+	b := NewBatcher(func(ctx context.Context, keys []string) (map[string]string, error) {
+		close(started)
+		<-ctx.Done()
+		callErr <- ctx.Err()
+		return nil, ctx.Err()
+	}, WithBatchWait(time.Millisecond))
+
+	ctx1, cancel1 := context.WithCancel(context.Background())
+	ctx2, cancel2 := context.WithCancel(context.Background())
+	f1, f2 := b.Submit(ctx1, "a"), b.Submit(ctx2, "b")
+	<-started
+
+	// One caller leaving does not cancel the call for the other.
+	cancel1()
+	if _, err := f1.Wait(ctx1); !errors.Is(err, context.Canceled) {
+		t.Errorf("Wait after cancel = %v", err)
+	}
+	select {
+	case <-callErr:
+		t.Fatal("call canceled while a caller still waits")
+	case <-time.After(20 * time.Millisecond):
+	}
+	cancel2()
+	f2.Wait(ctx2)
+	select {
+	case err := <-callErr:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("call context error = %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("call not canceled after every caller left")
+	}
+}
+
+func TestBatcherSurvivesPanics(t *testing.T) {
+	b := NewBatcher(func(context.Context, []int) (map[int]int, error) { panic("boom") }, WithBatchWait(time.Millisecond))
+	if _, err := b.Load(context.Background(), 1); err == nil {
+		t.Error("Load succeeded although the batch function panicked")
+	}
+}
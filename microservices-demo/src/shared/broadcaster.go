@@ -0,0 +1,225 @@
+package shared
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/GoogleCloudPlatform/microservices-demo/src/shared/metrics"
+)
+
+var (
+	broadcasterSubscribers = metrics.NewGaugeVec("broadcaster_subscribers",
+		"Current subscribers of a broadcaster.", "broadcaster")
+	broadcasterPublished = metrics.NewCounterVec("broadcaster_published_total",
+		"Values published to a broadcaster.", "broadcaster")
+	broadcasterDropped = metrics.NewCounterVec("broadcaster_dropped_total",
+		"Deliveries lost to slow subscribers, by policy (drop_oldest, close).", "broadcaster", "policy")
+)
+
+// ErrSlowSubscriber is a Subscription's Err after CloseSlowSubscriber ended
+// it for falling behind.
+var ErrSlowSubscriber = errors.New("broadcaster: subscriber too slow")
+
+// ErrBroadcasterClosed is a Subscription's Err after its Broadcaster was
+// closed.
+var ErrBroadcasterClosed = errors.New("broadcaster: closed")
+
+// SlowSubscriberPolicy decides what Publish does for a subscriber whose
+// buffer is full.
+type SlowSubscriberPolicy int
+
+const (
+	// DropOldest discards the subscriber's oldest buffered value to make
+	// room, so it keeps receiving the latest state.
+	DropOldest SlowSubscriberPolicy = iota
+	// CloseSlowSubscriber ends the subscription, so the subscriber can
+	// resynchronize from scratch, such as a websocket client reloading its
+	// cart.
+	CloseSlowSubscriber
+)
+
+func (p SlowSubscriberPolicy) String() string {
+	if p == CloseSlowSubscriber {
+		return "close"
+	}
+	return "drop_oldest"
+}
+
+// DefaultSubscriberBuffer is how many values a subscriber may lag behind
+// unless overridden with WithSubscriberBuffer.
+const DefaultSubscriberBuffer = 16
+
+type broadcasterConfig struct {
+	name   string
+	buffer int
+	policy SlowSubscriberPolicy
+}
+
+// BroadcasterOption configures a Broadcaster.
+type BroadcasterOption func(*broadcasterConfig)
+
+// WithBroadcasterName sets the broadcaster label on metrics (default
+// "default").
+func WithBroadcasterName(name string) BroadcasterOption {
+	return func(c *broadcasterConfig) { c.name = name }
+}
+
+// WithSubscriberBuffer sets each subscriber's buffer (default
+// DefaultSubscriberBuffer).
+func WithSubscriberBuffer(n int) BroadcasterOption {
+	return func(c *broadcasterConfig) { c.buffer = n }
+}
+
+// WithSlowSubscriberPolicy sets what happens to subscribers whose buffer is
+// full (default DropOldest).
+func WithSlowSubscriberPolicy(p SlowSubscriberPolicy) BroadcasterOption {
+	return func(c *broadcasterConfig) { c.policy = p }
+}
+
+// Broadcaster fans values out to in-process subscribers. Publish never
+// blocks: each subscriber has a bounded buffer, and one that falls behind
+// loses values or its subscription according to the SlowSubscriberPolicy,
+// so a stalled reader cannot hold up the publisher or the other readers.
+//
+//	carts := shared.NewBroadcaster[CartUpdate](shared.WithBroadcasterName("cart-updates"))
+//	...
+//	sub := carts.Subscribe()
+//	defer sub.Close()
+//	for u := range sub.C() {
+//	    ws.WriteJSON(u)
+//	}
+//	...
+//	carts.Publish(CartUpdate{UserID: id, Items: n})
+type Broadcaster[T any] struct {
+	cfg broadcasterConfig
+
+	mu     sync.Mutex
+	subs   map[*Subscription[T]]struct{}
+	closed bool
+}
+
+// NewBroadcaster returns a Broadcaster without subscribers.
+func NewBroadcaster[T any](opts ...BroadcasterOption) *Broadcaster[T] {
+	cfg := broadcasterConfig{name: "default", buffer: DefaultSubscriberBuffer}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	if cfg.buffer < 1 {
+		cfg.buffer = 1
+	}
+	return &Broadcaster[T]{cfg: cfg, subs: make(map[*Subscription[T]]struct{})}
+}
+
+// Subscription receives the values published after it was created.
+type Subscription[T any] struct {
+	b       *Broadcaster[T]
+	ch      chan T
+	err     error // set before ch is closed
+	dropped int
+}
+
+// Subscribe adds a subscriber. It is closed right away, with
+// ErrBroadcasterClosed, if the Broadcaster is.
+func (b *Broadcaster[T]) Subscribe() *Subscription[T] {
+	s := &Subscription[T]{b: b, ch: make(chan T, b.cfg.buffer)}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		s.err = ErrBroadcasterClosed
+		close(s.ch)
+		return s
+	}
+	b.subs[s] = struct{}{}
+	broadcasterSubscribers.WithLabelValues(b.cfg.name).Inc()
+	return s
+}
+
+// Unsubscribe removes s and closes its channel. It is a no-op if s already
+// ended.
+func (b *Broadcaster[T]) Unsubscribe(s *Subscription[T]) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.remove(s, nil)
+}
+
+// remove ends s with err. b.mu must be held.
+func (b *Broadcaster[T]) remove(s *Subscription[T], err error) {
+	if _, ok := b.subs[s]; !ok {
+		return
+	}
+	delete(b.subs, s)
+	broadcasterSubscribers.WithLabelValues(b.cfg.name).Dec()
+	s.err = err
+	close(s.ch)
+}
+
+// Publish delivers v to every subscriber and returns how many received it
+// without another value or the subscription being dropped.
+func (b *Broadcaster[T]) Publish(v T) int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return 0
+	}
+	broadcasterPublished.WithLabelValues(b.cfg.name).Inc()
+	delivered := 0
+	for s := range b.subs {
+		select {
+		case s.ch <- v:
+			delivered++
+			continue
+		default:
+		}
+		broadcasterDropped.WithLabelValues(b.cfg.name, b.cfg.policy.String()).Inc()
+		s.dropped++
+		if b.cfg.policy == CloseSlowSubscriber {
+			b.remove(s, ErrSlowSubscriber)
+			continue
+		}
+		// Only Publish sends, under b.mu, so after taking one value out
+		// there is room for v.
+		select {
+		case <-s.ch:
+		default:
+		}
+		s.ch <- v
+	}
+	return delivered
+}
+
+// Subscribers returns the number of current subscribers.
+func (b *Broadcaster[T]) Subscribers() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.subs)
+}
+
+// Close ends every subscription with ErrBroadcasterClosed. Later Publish
+// calls do nothing.
+func (b *Broadcaster[T]) Close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.closed = true
+	for s := range b.subs {
+		b.remove(s, ErrBroadcasterClosed)
+	}
+}
+
+// C returns the channel of published values. It is closed when the
+// subscription ends; Err then tells why.
+func (s *Subscription[T]) C() <-chan T { return s.ch }
+
+// Close unsubscribes s.
+func (s *Subscription[T]) Close() { s.b.Unsubscribe(s) }
+
+// Err returns why the subscription ended: nil after Close or Unsubscribe,
+// ErrSlowSubscriber or ErrBroadcasterClosed. It must only be called once
+// C is closed.
+func (s *Subscription[T]) Err() error { return s.err }
+
+// Dropped returns how many values s lost to being slow.
+func (s *Subscription[T]) Dropped() int {
+	s.b.mu.Lock()
+	defer s.b.mu.Unlock()
+	return s.dropped
+}
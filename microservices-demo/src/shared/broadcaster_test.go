@@ -0,0 +1,61 @@
+package shared
+
+import "testing"
+
+func drain[T any](s *Subscription[T]) []T {
+	var got []T
+	for v := range s.C() {
+		got = append(got, v)
+	}
+	return got
+}
+
+func TestBroadcasterFanOut(t *testing.T) {
+	b := NewBroadcaster[int](WithBroadcasterName("test"))
+	a, c := b.Subscribe(), b.Subscribe()
+	if n := b.Publish(1); n != 2 {
+		t.Errorf("Publish delivered to %d, want 2", n)
+	}
+	c.Close()
+	b.Publish(2)
+	if b.Subscribers() != 1 {
+		t.Errorf("Subscribers = %d, want 1", b.Subscribers())
+	}
+	b.Close()
+	if got := drain(a); len(got) != 2 || got[0] != 1 || got[1] != 2 || a.Err() != ErrBroadcasterClosed {
+		t.Errorf("a received %v, err %v", got, a.Err())
+	}
+	if got := drain(c); len(got) != 1 || c.Err() != nil {
+		t.Errorf("c received %v, err %v", got, c.Err())
+	}
+	if late := b.Subscribe(); len(drain(late)) != 0 || late.Err() != ErrBroadcasterClosed || b.Publish(3) != 0 {
+		t.Error("closed broadcaster accepted a subscriber or a value")
+	}
+}
+
+func TestBroadcasterSlowSubscribers(t *testing.T) {
+	b := NewBroadcaster[int](WithSubscriberBuffer(2))
+	s := b.Subscribe()
+	for i := 1; i <= 5; i++ {
+		b.Publish(i)
+	}
+	b.Unsubscribe(s)
+	if got := drain(s); len(got) != 2 || got[0] != 4 || got[1] != 5 || s.Dropped() != 3 {
+		t.Errorf("drop oldest kept %v, dropped %d; want [4 5], 3", got, s.Dropped())
+	}
+
+	b = NewBroadcaster[int](WithSubscriberBuffer(2), WithSlowSubscriberPolicy(CloseSlowSubscriber))
+	slow, fast := b.Subscribe(), b.Subscribe()
+	for i := 1; i <= 3; i++ {
+		b.Publish(i)
+		if v := <-fast.C(); v != i {
+			t.Errorf("fast subscriber received %d, want %d", v, i)
+		}
+	}
+	if kept := drain(slow); len(kept) != 2 || slow.Err() != ErrSlowSubscriber {
+		t.Errorf("slow subscriber kept %v, err %v", kept, slow.Err())
+	}
+	if b.Subscribers() != 1 {
+		t.Errorf("Subscribers = %d, want only the slow one removed", b.Subscribers())
+	}
+}
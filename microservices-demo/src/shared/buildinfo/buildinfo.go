@@ -0,0 +1,114 @@
+// Package buildinfo reports what build of a service is running: its
+// version, commit and build date, stamped at link time, plus the Go
+// version. The same values appear in /version, the boutique_build_info
+// gauge, every log entry written with a logging.New logger and the
+// service.version trace resource attribute.
+//
+// Set the values with -ldflags:
+//
+//	go build -ldflags "\
+//	    -X github.com/GoogleCloudPlatform/microservices-demo/src/shared/buildinfo.Version=v1.4.0 \
+//	    -X github.com/GoogleCloudPlatform/microservices-demo/src/shared/buildinfo.Commit=$(git rev-parse HEAD) \
+//	    -X github.com/GoogleCloudPlatform/microservices-demo/src/shared/buildinfo.Date=$(date -u +%Y-%m-%dT%H:%M:%SZ)"
+//
+// Values left unset fall back to what the Go toolchain embeds: the main
+// module version and, when built inside a git checkout, the VCS revision and
+// commit time. Version falls back to "dev".
+package buildinfo
+
+import (
+	"encoding/json"
+	"net/http"
+	"runtime"
+	"runtime/debug"
+	"sync"
+)
+
+// Set with -ldflags "-X"; see the package documentation.
+var (
+	Version string
+	Commit  string
+	Date    string
+)
+
+// Info describes the running build.
+type Info struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit,omitempty"`
+	Date      string `json:"date,omitempty"`
+	GoVersion string `json:"goVersion"`
+	// Path is the main module path.
+	Path string `json:"path,omitempty"`
+	// Modified reports a build from a checkout with uncommitted changes.
+	Modified bool `json:"modified,omitempty"`
+}
+
+var info = sync.OnceValue(func() Info {
+	return resolve(Version, Commit, Date, debug.ReadBuildInfo)
+})
+
+// GetInfo returns the build information. It is computed once per process.
+func GetInfo() Info { return info() }
+
+// ShortCommit returns the first 12 characters of the commit, for labels.
+func (i Info) ShortCommit() string {
+	if len(i.Commit) > 12 {
+		return i.Commit[:12]
+	}
+	return i.Commit
+}
+
+// String formats the info for a startup log line, e.g.
+// "v1.4.0 (3f2c1a9b7e4d, 2026-05-01T10:00:00Z, go1.23.4)".
+func (i Info) String() string {
+	s := i.Version + " ("
+	if i.Commit != "" {
+		s += i.ShortCommit()
+		if i.Modified {
+			s += "-dirty"
+		}
+		s += ", "
+	}
+	if i.Date != "" {
+		s += i.Date + ", "
+	}
+	return s + i.GoVersion + ")"
+}
+
+// resolve merges the link-time values with the toolchain's build info.
+func resolve(version, commit, date string, read func() (*debug.BuildInfo, bool)) Info {
+	i := Info{Version: version, Commit: commit, Date: date, GoVersion: runtime.Version()}
+	if bi, ok := read(); ok {
+		i.Path = bi.Main.Path
+		if i.Version == "" && bi.Main.Version != "(devel)" {
+			i.Version = bi.Main.Version
+		}
+		for _, s := range bi.Settings {
+			switch s.Key {
+			case "vcs.revision":
+				if i.Commit == "" {
+					i.Commit = s.Value
+				}
+			case "vcs.time":
+				if i.Date == "" {
+					i.Date = s.Value
+				}
+			case "vcs.modified":
+				i.Modified = s.Value == "true"
+			}
+		}
+	}
+	if i.Version == "" {
+		i.Version = "dev"
+	}
+	return i
+}
+
+// Handler serves the build information as JSON. StartAdminServer mounts it
+// at /version.
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(GetInfo())
+	})
+}
@@ -0,0 +1,52 @@
+package buildinfo
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"runtime/debug"
+	"testing"
+)
+
+func fakeBuildInfo() (*debug.BuildInfo, bool) {
+	return &debug.BuildInfo{
+		Main: debug.Module{Path: "example.com/svc", Version: "(devel)"},
+		Settings: []debug.BuildSetting{
+			{Key: "vcs.revision", Value: "3f2c1a9b7e4d5c6b7a8f"},
+			{Key: "vcs.time", Value: "2026-05-01T10:00:00Z"},
+			{Key: "vcs.modified", Value: "true"},
+		},
+	}, true
+}
+
+func TestResolveFallsBackToToolchain(t *testing.T) {
+	i := resolve("", "", "", fakeBuildInfo)
+	if i.Version != "dev" || i.Commit != "3f2c1a9b7e4d5c6b7a8f" || i.Date != "2026-05-01T10:00:00Z" || !i.Modified || i.Path != "example.com/svc" {
+		t.Errorf("resolve = %+v", i)
+	}
+	if got, want := i.String(), "dev (3f2c1a9b7e4d-dirty, 2026-05-01T10:00:00Z, "+i.GoVersion+")"; got != want {
+		t.Errorf("String = %q, want %q", got, want)
+	}
+}
+
+func TestResolvePrefersLinkerValues(t *testing.T) {
+	i := resolve("v1.4.0", "abc", "2026-06-01", fakeBuildInfo)
+	if i.Version != "v1.4.0" || i.Commit != "abc" || i.Date != "2026-06-01" {
+		t.Errorf("resolve = %+v, want the -ldflags values", i)
+	}
+	if i := resolve("", "", "", func() (*debug.BuildInfo, bool) { return nil, false }); i.Version != "dev" || i.GoVersion == "" {
+		t.Errorf("resolve without build info = %+v", i)
+	}
+}
+
+func TestHandler(t *testing.T) {
+	rec := httptest.NewRecorder()
+	Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/version", nil))
+	var got Info
+	if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	if got != GetInfo() {
+		t.Errorf("/version = %+v, want %+v", got, GetInfo())
+	}
+}
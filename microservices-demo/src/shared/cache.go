@@ -0,0 +1,273 @@
+package shared
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/GoogleCloudPlatform/microservices-demo/src/shared/metrics"
+)
+
+var (
+	cacheHits = metrics.NewCounterVec("cache_hits_total",
+		"Cache lookups that found a live entry, by cache.", "cache")
+	cacheMisses = metrics.NewCounterVec("cache_misses_total",
+		"Cache lookups that found no live entry, by cache.", "cache")
+	cacheEvictions = metrics.NewCounterVec("cache_evictions_total",
+		"Entries removed from a cache, by cache and reason (expired, capacity).", "cache", "reason")
+	cacheEntries = metrics.NewGaugeVec("cache_entries",
+		"Entries currently held by a cache.", "cache")
+)
+
+// Cache defaults.
+const (
+	DefaultCacheTTL         = 5 * time.Minute
+	DefaultCacheMaxEntries  = 10000
+	DefaultCacheLoadTimeout = 30 * time.Second
+)
+
+type cacheConfig struct {
+	name        string
+	ttl         time.Duration
+	maxEntries  int
+	loadTimeout time.Duration
+	clock       Clock
+}
+
+// CacheOption configures a Cache.
+type CacheOption func(*cacheConfig)
+
+// WithCacheName sets the cache label on the cache's metrics (default
+// "default"). Give each cache in a process its own name.
+func WithCacheName(name string) CacheOption {
+	return func(c *cacheConfig) { c.name = name }
+}
+
+// WithCacheTTL sets how long entries live; zero or negative means they never
+// expire.
+func WithCacheTTL(d time.Duration) CacheOption {
+	return func(c *cacheConfig) { c.ttl = d }
+}
+
+// WithCacheMaxEntries bounds the cache size; the least recently used entry is
+// evicted to make room. Zero or negative means unbounded.
+func WithCacheMaxEntries(n int) CacheOption {
+	return func(c *cacheConfig) { c.maxEntries = n }
+}
+
+// WithCacheLoadTimeout bounds each GetOrLoad load call (default
+// DefaultCacheLoadTimeout). Loads are shared by every caller waiting on the
+// key, so they run detached from the callers' contexts and only this bounds
+// them. Zero or negative means no bound.
+func WithCacheLoadTimeout(d time.Duration) CacheOption {
+	return func(c *cacheConfig) { c.loadTimeout = d }
+}
+
+// WithCacheClock sets the clock used to expire entries, for tests.
+func WithCacheClock(clk Clock) CacheOption {
+	return func(c *cacheConfig) { c.clock = clk }
+}
+
+type cacheEntry[K comparable, V any] struct {
+	key     K
+	value   V
+	expires time.Time // zero for no expiry
+}
+
+// cacheLoad is an in-flight GetOrLoad shared by concurrent callers.
+type cacheLoad[V any] struct {
+	done  chan struct{}
+	value V
+	err   error
+}
+
+// Cache is an in-memory LRU cache with per-entry TTLs, safe for concurrent
+// use. GetOrLoad deduplicates concurrent loads of the same key, so a cold
+// cache does not send a burst of identical requests downstream:
+//
+//	rates := shared.NewCache[string, map[string]float64](
+//	    shared.WithCacheName("currency-rates"),
+//	    shared.WithCacheTTL(time.Hour))
+//	r, err := rates.GetOrLoad(ctx, "EUR", func(ctx context.Context) (map[string]float64, error) {
+//	    return fetchRates(ctx, "EUR")
+//	})
+type Cache[K comparable, V any] struct {
+	cfg cacheConfig
+
+	mu      sync.Mutex
+	lru     *list.List // front is most recently used
+	entries map[K]*list.Element
+	loads   map[K]*cacheLoad[V]
+}
+
+// NewCache returns an empty Cache with DefaultCacheTTL,
+// DefaultCacheMaxEntries and DefaultCacheLoadTimeout unless overridden.
+func NewCache[K comparable, V any](opts ...CacheOption) *Cache[K, V] {
+	cfg := cacheConfig{name: "default", ttl: DefaultCacheTTL, maxEntries: DefaultCacheMaxEntries, loadTimeout: DefaultCacheLoadTimeout}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	cfg.clock = clockOrReal(cfg.clock)
+	return &Cache[K, V]{
+		cfg:     cfg,
+		lru:     list.New(),
+		entries: make(map[K]*list.Element),
+		loads:   make(map[K]*cacheLoad[V]),
+	}
+}
+
+// Get returns the live value for key.
+func (c *Cache[K, V]) Get(key K) (V, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	v, ok := c.get(key)
+	if ok {
+		cacheHits.WithLabelValues(c.cfg.name).Inc()
+	} else {
+		cacheMisses.WithLabelValues(c.cfg.name).Inc()
+	}
+	return v, ok
+}
+
+// get looks key up without touching metrics. c.mu must be held.
+func (c *Cache[K, V]) get(key K) (V, bool) {
+	el, ok := c.entries[key]
+	if !ok {
+		var zero V
+		return zero, false
+	}
+	e := el.Value.(*cacheEntry[K, V])
+	if !e.expires.IsZero() && !c.cfg.clock.Now().Before(e.expires) {
+		c.remove(el, "expired")
+		var zero V
+		return zero, false
+	}
+	c.lru.MoveToFront(el)
+	return e.value, true
+}
+
+// Set stores value under key with the cache's TTL.
+func (c *Cache[K, V]) Set(key K, value V) {
+	c.SetWithTTL(key, value, c.cfg.ttl)
+}
+
+// SetWithTTL stores value under key with its own TTL; zero or negative means
+// it never expires.
+func (c *Cache[K, V]) SetWithTTL(key K, value V, ttl time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.set(key, value, ttl)
+}
+
+func (c *Cache[K, V]) set(key K, value V, ttl time.Duration) {
+	var expires time.Time
+	if ttl > 0 {
+		expires = c.cfg.clock.Now().Add(ttl)
+	}
+	if el, ok := c.entries[key]; ok {
+		e := el.Value.(*cacheEntry[K, V])
+		e.value, e.expires = value, expires
+		c.lru.MoveToFront(el)
+		return
+	}
+	c.entries[key] = c.lru.PushFront(&cacheEntry[K, V]{key: key, value: value, expires: expires})
+	for c.cfg.maxEntries > 0 && c.lru.Len() > c.cfg.maxEntries {
+		c.remove(c.lru.Back(), "capacity")
+	}
+	cacheEntries.WithLabelValues(c.cfg.name).Set(float64(c.lru.Len()))
+}
+
+// remove drops el, counting it as evicted for reason unless reason is empty.
+func (c *Cache[K, V]) remove(el *list.Element, reason string) {
+	c.lru.Remove(el)
+	delete(c.entries, el.Value.(*cacheEntry[K, V]).key)
+	if reason != "" {
+		cacheEvictions.WithLabelValues(c.cfg.name, reason).Inc()
+	}
+	cacheEntries.WithLabelValues(c.cfg.name).Set(float64(c.lru.Len()))
+}
+
+// Delete removes key.
+func (c *Cache[K, V]) Delete(key K) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.entries[key]; ok {
+		c.remove(el, "")
+	}
+}
+
+// Purge removes every entry.
+func (c *Cache[K, V]) Purge() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.lru.Init()
+	clear(c.entries)
+	cacheEntries.WithLabelValues(c.cfg.name).Set(0)
+}
+
+// Len returns the number of entries, including expired ones not yet evicted.
+func (c *Cache[K, V]) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.lru.Len()
+}
+
+// GetOrLoad returns the live value for key, calling load to fill a miss.
+// Concurrent callers for the same key share one load call; each waits until
+// it finishes or its own ctx is done. The load runs with the values of the
+// first caller's ctx but not its cancellation, so that caller giving up does
+// not fail the others; WithCacheLoadTimeout bounds it instead. Errors from
+// load are returned to every waiter and are not cached.
+func (c *Cache[K, V]) GetOrLoad(ctx context.Context, key K, load func(ctx context.Context) (V, error)) (V, error) {
+	c.mu.Lock()
+	if v, ok := c.get(key); ok {
+		c.mu.Unlock()
+		cacheHits.WithLabelValues(c.cfg.name).Inc()
+		return v, nil
+	}
+	cacheMisses.WithLabelValues(c.cfg.name).Inc()
+	l, ok := c.loads[key]
+	if !ok {
+		l = &cacheLoad[V]{done: make(chan struct{})}
+		c.loads[key] = l
+		go c.load(context.WithoutCancel(ctx), key, l, load)
+	}
+	c.mu.Unlock()
+	select {
+	case <-l.done:
+		return l.value, l.err
+	case <-ctx.Done():
+		var zero V
+		return zero, ctx.Err()
+	}
+}
+
+// load calls fn under the load timeout, caches its value on success and
+// publishes the result to the waiters.
+func (c *Cache[K, V]) load(ctx context.Context, key K, l *cacheLoad[V], fn func(ctx context.Context) (V, error)) {
+	defer func() {
+		if r := recover(); r != nil {
+			l.err = fmt.Errorf("%w: %v", errCacheLoadPanicked, r)
+		}
+		c.mu.Lock()
+		delete(c.loads, key)
+		if l.err == nil {
+			c.set(key, l.value, c.cfg.ttl)
+		}
+		c.mu.Unlock()
+		close(l.done)
+	}()
+	if c.cfg.loadTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.cfg.loadTimeout)
+		defer cancel()
+	}
+	l.value, l.err = fn(ctx)
+}
+
+// errCacheLoadPanicked is returned to the waiters when the load they share
+// panicked.
+var errCacheLoadPanicked = errors.New("cache: load panicked")
@@ -0,0 +1,134 @@
+package shared
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestCacheTTL(t *testing.T) {
+	clk := NewFakeClock(time.Unix(0, 0))
+	c := NewCache[string, int](WithCacheName("test-ttl"), WithCacheTTL(time.Minute), WithCacheClock(clk))
+	c.Set("a", 1)
+	if v, ok := c.Get("a"); !ok || v != 1 {
+		t.Fatalf("Get(a) = %d, %v; want 1, true", v, ok)
+	}
+	clk.Advance(time.Minute)
+	if _, ok := c.Get("a"); ok {
+		t.Error("entry still live after its TTL")
+	}
+	if c.Len() != 0 {
+		t.Errorf("Len = %d after expiry, want 0", c.Len())
+	}
+}
+
+func TestCacheLRUEviction(t *testing.T) {
+	c := NewCache[int, int](WithCacheName("test-lru"), WithCacheMaxEntries(2))
+	c.Set(1, 1)
+	c.Set(2, 2)
+	c.Get(1) // 2 is now least recently used
+	c.Set(3, 3)
+	if _, ok := c.Get(2); ok {
+		t.Error("least recently used entry not evicted")
+	}
+	for _, k := range []int{1, 3} {
+		if _, ok := c.Get(k); !ok {
+			t.Errorf("entry %d evicted", k)
+		}
+	}
+}
+
+func TestCacheGetOrLoadDeduplicates(t *testing.T) {
+	c := NewCache[string, string](WithCacheName("test-load"))
+	var calls atomic.Int32
+	release := make(chan struct{})
+	load := func(ctx context.Context) (string, error) {
+		calls.Add(1)
+		<-release
+		return "v", nil
+	}
+
+	var wg sync.WaitGroup
+	for range 10 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if v, err := c.GetOrLoad(context.Background(), "k", load); err != nil || v != "v" {
+				t.Errorf("GetOrLoad = %q, %v", v, err)
+			}
+		}()
+	}
+	time.Sleep(20 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	if n := calls.Load(); n != 1 {
+		t.Errorf("load called %d times, want 1", n)
+	}
+	if v, ok := c.Get("k"); !ok || v != "v" {
+		t.Errorf("loaded value not cached: %q, %v", v, ok)
+	}
+}
+
+func TestCacheGetOrLoadDoesNotCacheErrors(t *testing.T) {
+	c := NewCache[string, int](WithCacheName("test-load-err"))
+	boom := errors.New("boom")
+	if _, err := c.GetOrLoad(context.Background(), "k", func(context.Context) (int, error) { return 0, boom }); !errors.Is(err, boom) {
+		t.Fatalf("GetOrLoad err = %v, want %v", err, boom)
+	}
+	v, err := c.GetOrLoad(context.Background(), "k", func(context.Context) (int, error) { return 7, nil })
+	if err != nil || v != 7 {
+		t.Errorf("retry after error = %d, %v; want 7, nil", v, err)
+	}
+}
+
+func TestCacheGetOrLoadOutlivesFirstCaller(t *testing.T) {
+	c := NewCache[string, string](WithCacheName("test-load-cancel"))
+	started := make(chan struct{})
+	release := make(chan struct{})
+	load := func(ctx context.Context) (string, error) {
+		close(started)
+		select {
+		case <-release:
+			return "v", nil
+		case <-ctx.Done():
+			return "", ctx.Err()
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	first := make(chan error, 1)
+	go func() {
+		_, err := c.GetOrLoad(ctx, "k", load)
+		first <- err
+	}()
+	<-started
+	second := make(chan string, 1)
+	go func() {
+		v, _ := c.GetOrLoad(context.Background(), "k", load)
+		second <- v
+	}()
+
+	cancel()
+	if err := <-first; !errors.Is(err, context.Canceled) {
+		t.Errorf("canceled caller got %v, want %v", err, context.Canceled)
+	}
+	close(release)
+	if v := <-second; v != "v" {
+		t.Errorf("other caller got %q after the first gave up, want v", v)
+	}
+}
+
+func TestCacheGetOrLoadTimeout(t *testing.T) {
+	c := NewCache[string, int](WithCacheName("test-load-timeout"), WithCacheLoadTimeout(10*time.Millisecond))
+	_, err := c.GetOrLoad(context.Background(), "k", func(ctx context.Context) (int, error) {
+		<-ctx.Done()
+		return 0, ctx.Err()
+	})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("GetOrLoad err = %v, want %v", err, context.DeadlineExceeded)
+	}
+}
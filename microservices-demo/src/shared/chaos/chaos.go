@@ -0,0 +1,191 @@
+// Package chaos injects faults into selected RPCs and HTTP requests so the
+// resilience suite can target a single method without a service mesh. Rules
+// are matched against each request and can add latency, fail it with a given
+// gRPC code or HTTP status, or drop the connection.
+//
+// Rules are changed at runtime through Handler, typically mounted on the
+// admin server:
+//
+//	inj := chaos.New()
+//	srv := grpcserver.New(grpcserver.WithUnaryInterceptors(inj.UnaryServerInterceptor()))
+//	shared.StartAdminServer("", shared.WithAdminHandler("/admin/chaos", inj.Handler()))
+//
+//	curl -X PUT http://<pod-ip>:8090/admin/chaos -d '[
+//	  {"method": "/hipstershop.PaymentService/Charge", "percent": 50, "code": "Unavailable"},
+//	  {"method": "/hipstershop.ShippingService/*", "latency": "2s", "trigger": "slow-shipping"}
+//	]'
+//
+// An injector without rules adds no overhead beyond an atomic load.
+package chaos
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand/v2"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"google.golang.org/grpc/codes"
+
+	"github.com/GoogleCloudPlatform/microservices-demo/src/shared/metrics"
+)
+
+// TriggerHeader limits a rule with a Trigger to requests carrying this header
+// (or gRPC metadata key) with the same value.
+const TriggerHeader = "X-Chaos-Trigger"
+
+// Duration is a time.Duration that reads and writes as a string like "250ms".
+type Duration time.Duration
+
+// MarshalJSON encodes d as a duration string.
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// UnmarshalJSON accepts a duration string.
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// Rule describes one fault. All match conditions must hold for the fault to
+// be applied; faults are applied in the order latency, reset, error.
+type Rule struct {
+	// Name identifies the rule in metrics; it defaults to Method.
+	Name string `json:"name,omitempty"`
+	// Method is a full gRPC method or HTTP path. A trailing * matches any
+	// suffix; empty matches everything.
+	Method string `json:"method,omitempty"`
+	// Percent of matching requests affected, 0–100. Zero means 100.
+	Percent float64 `json:"percent,omitempty"`
+	// Trigger, if set, restricts the rule to requests whose TriggerHeader
+	// equals it.
+	Trigger string `json:"trigger,omitempty"`
+
+	// Latency is added before the request is handled.
+	Latency Duration `json:"latency,omitempty"`
+	// Code fails gRPC calls with this code, by name (e.g. "Unavailable").
+	Code string `json:"code,omitempty"`
+	// HTTPStatus fails HTTP requests with this status.
+	HTTPStatus int `json:"httpStatus,omitempty"`
+	// Reset drops the connection: HTTP connections are closed without a
+	// response and gRPC calls fail with Unavailable.
+	Reset bool `json:"reset,omitempty"`
+
+	code codes.Code
+}
+
+// validate checks r and resolves its gRPC code.
+func (r *Rule) validate() error {
+	if r.Percent < 0 || r.Percent > 100 {
+		return fmt.Errorf("chaos: rule %q: percent must be within 0-100", r.label())
+	}
+	if r.Code != "" {
+		var c codes.Code
+		if err := c.UnmarshalJSON([]byte(`"` + strings.ToUpper(snake(r.Code)) + `"`)); err != nil {
+			return fmt.Errorf("chaos: rule %q: unknown code %q", r.label(), r.Code)
+		}
+		r.code = c
+	}
+	if r.HTTPStatus != 0 && (r.HTTPStatus < 100 || r.HTTPStatus > 599) {
+		return fmt.Errorf("chaos: rule %q: invalid HTTP status %d", r.label(), r.HTTPStatus)
+	}
+	return nil
+}
+
+// snake turns a CamelCase code name into SNAKE_CASE, the form codes.Code
+// parses, so both "DeadlineExceeded" and "DEADLINE_EXCEEDED" are accepted.
+func snake(s string) string {
+	if strings.Contains(s, "_") || strings.ToUpper(s) == s {
+		return s
+	}
+	var b strings.Builder
+	for i, r := range s {
+		if i > 0 && r >= 'A' && r <= 'Z' {
+			b.WriteByte('_')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+func (r *Rule) label() string {
+	if r.Name != "" {
+		return r.Name
+	}
+	if r.Method != "" {
+		return r.Method
+	}
+	return "*"
+}
+
+// matches reports whether r applies to a request for method carrying the
+// given trigger value, rolling the dice for partial rules.
+func (r *Rule) matches(method, trigger string) bool {
+	if r.Trigger != "" && r.Trigger != trigger {
+		return false
+	}
+	if prefix, ok := strings.CutSuffix(r.Method, "*"); ok {
+		if !strings.HasPrefix(method, prefix) {
+			return false
+		}
+	} else if r.Method != "" && r.Method != method {
+		return false
+	}
+	return r.Percent == 0 || r.Percent >= 100 || rand.Float64()*100 < r.Percent
+}
+
+var injectedTotal = metrics.NewCounterVec("chaos_injected_total",
+	"Faults injected by chaos rules, by rule and fault kind.", "rule", "fault")
+
+// Injector holds the active rules.
+type Injector struct {
+	rules atomic.Pointer[[]Rule]
+}
+
+// New returns an Injector with no rules.
+func New() *Injector {
+	inj := &Injector{}
+	inj.rules.Store(&[]Rule{})
+	return inj
+}
+
+// SetRules validates and replaces the active rules.
+func (inj *Injector) SetRules(rules []Rule) error {
+	rs := make([]Rule, len(rules))
+	copy(rs, rules)
+	for i := range rs {
+		if err := rs[i].validate(); err != nil {
+			return err
+		}
+	}
+	inj.rules.Store(&rs)
+	return nil
+}
+
+// Rules returns the active rules.
+func (inj *Injector) Rules() []Rule {
+	rs := *inj.rules.Load()
+	out := make([]Rule, len(rs))
+	copy(out, rs)
+	return out
+}
+
+// match returns the first rule applying to the request, if any.
+func (inj *Injector) match(method, trigger string) (Rule, bool) {
+	for _, r := range *inj.rules.Load() {
+		if r.matches(method, trigger) {
+			return r, true
+		}
+	}
+	return Rule{}, false
+}
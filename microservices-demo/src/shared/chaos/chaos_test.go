@@ -0,0 +1,107 @@
+package chaos
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func okHandler(ctx context.Context, req any) (any, error) { return "ok", nil }
+
+func TestUnaryServerInterceptorInjectsCode(t *testing.T) {
+	inj := New()
+	if err := inj.SetRules([]Rule{{Method: "/hipstershop.PaymentService/*", Code: "Unavailable"}}); err != nil {
+		t.Fatal(err)
+	}
+	intercept := inj.UnaryServerInterceptor()
+
+	_, err := intercept(context.Background(), nil, &grpc.UnaryServerInfo{FullMethod: "/hipstershop.PaymentService/Charge"}, okHandler)
+	if status.Code(err) != codes.Unavailable {
+		t.Errorf("Charge = %v, want Unavailable", err)
+	}
+	if _, err := intercept(context.Background(), nil, &grpc.UnaryServerInfo{FullMethod: "/hipstershop.CartService/GetCart"}, okHandler); err != nil {
+		t.Errorf("GetCart = %v, want unaffected", err)
+	}
+}
+
+func TestTriggerAndLatency(t *testing.T) {
+	inj := New()
+	inj.SetRules([]Rule{{Latency: Duration(30 * time.Millisecond), Trigger: "slow"}})
+	intercept := inj.UnaryServerInterceptor()
+	info := &grpc.UnaryServerInfo{FullMethod: "/svc/M"}
+
+	start := time.Now()
+	intercept(context.Background(), nil, info, okHandler)
+	if time.Since(start) >= 30*time.Millisecond {
+		t.Error("latency injected without the trigger")
+	}
+
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(TriggerHeader, "slow"))
+	start = time.Now()
+	if _, err := intercept(ctx, nil, info, okHandler); err != nil {
+		t.Fatal(err)
+	}
+	if time.Since(start) < 30*time.Millisecond {
+		t.Error("latency not injected with the trigger")
+	}
+}
+
+func TestSetRulesValidates(t *testing.T) {
+	for _, r := range []Rule{
+		{Code: "NoSuchCode"},
+		{Percent: 120},
+		{HTTPStatus: 42},
+	} {
+		if err := New().SetRules([]Rule{r}); err == nil {
+			t.Errorf("SetRules(%+v) succeeded, want error", r)
+		}
+	}
+	if err := New().SetRules([]Rule{{Code: "DEADLINE_EXCEEDED"}, {Code: "DeadlineExceeded"}}); err != nil {
+		t.Errorf("SetRules with valid code spellings: %v", err)
+	}
+}
+
+func TestMiddlewareAndHandler(t *testing.T) {
+	inj := New()
+	admin := inj.Handler()
+	rec := httptest.NewRecorder()
+	admin.ServeHTTP(rec, httptest.NewRequest(http.MethodPut, "/admin/chaos",
+		strings.NewReader(`[{"method":"/cart","httpStatus":503}]`)))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("PUT rules = %d: %s", rec.Code, rec.Body)
+	}
+
+	h := inj.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/cart", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("GET /cart = %d, want 503", rec.Code)
+	}
+
+	admin.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodDelete, "/admin/chaos", nil))
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/cart", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("GET /cart after DELETE = %d, want 200", rec.Code)
+	}
+}
+
+func TestMiddlewareReset(t *testing.T) {
+	inj := New()
+	inj.SetRules([]Rule{{Reset: true}})
+	srv := httptest.NewServer(inj.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})))
+	defer srv.Close()
+
+	if resp, err := http.Get(srv.URL); err == nil {
+		resp.Body.Close()
+		t.Error("request succeeded despite reset rule")
+	}
+}
@@ -0,0 +1,151 @@
+package chaos
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// sleep waits for d or until ctx is done.
+func sleep(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// grpcFault applies the matching rule, if any, and returns the error to fail
+// the call with.
+func (inj *Injector) grpcFault(ctx context.Context, method string) error {
+	var trigger string
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if v := md.Get(TriggerHeader); len(v) > 0 {
+			trigger = v[0]
+		}
+	}
+	r, ok := inj.match(method, trigger)
+	if !ok {
+		return nil
+	}
+	if r.Latency > 0 {
+		injectedTotal.WithLabelValues(r.label(), "latency").Inc()
+		if err := sleep(ctx, time.Duration(r.Latency)); err != nil {
+			return status.FromContextError(err).Err()
+		}
+	}
+	if r.Reset {
+		injectedTotal.WithLabelValues(r.label(), "reset").Inc()
+		return status.Error(codes.Unavailable, "chaos: connection reset")
+	}
+	if r.Code != "" {
+		injectedTotal.WithLabelValues(r.label(), "error").Inc()
+		return status.Errorf(r.code, "chaos: injected %v", r.code)
+	}
+	return nil
+}
+
+// UnaryServerInterceptor applies the injector's rules to unary RPCs.
+func (inj *Injector) UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		if err := inj.grpcFault(ctx, info.FullMethod); err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+// StreamServerInterceptor applies the injector's rules when a stream opens.
+func (inj *Injector) StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if err := inj.grpcFault(ss.Context(), info.FullMethod); err != nil {
+			return err
+		}
+		return handler(srv, ss)
+	}
+}
+
+// Middleware applies the injector's rules to HTTP requests, matching rules
+// against the URL path.
+func (inj *Injector) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rule, ok := inj.match(r.URL.Path, r.Header.Get(TriggerHeader))
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if rule.Latency > 0 {
+			injectedTotal.WithLabelValues(rule.label(), "latency").Inc()
+			if sleep(r.Context(), time.Duration(rule.Latency)) != nil {
+				return
+			}
+		}
+		if rule.Reset {
+			injectedTotal.WithLabelValues(rule.label(), "reset").Inc()
+			resetConn(w)
+			return
+		}
+		if rule.HTTPStatus != 0 {
+			injectedTotal.WithLabelValues(rule.label(), "error").Inc()
+			http.Error(w, fmt.Sprintf("chaos: injected %d", rule.HTTPStatus), rule.HTTPStatus)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// resetConn closes the client connection without a response, with a TCP
+// reset where possible. If the connection cannot be hijacked (HTTP/2), the
+// handler is aborted instead, which resets the stream.
+func resetConn(w http.ResponseWriter) {
+	conn, _, err := http.NewResponseController(w).Hijack()
+	if err != nil {
+		panic(http.ErrAbortHandler)
+	}
+	if tc, ok := conn.(*net.TCPConn); ok {
+		tc.SetLinger(0)
+	}
+	conn.Close()
+}
+
+// Handler serves the rule set:
+//
+//	GET    returns the active rules as JSON
+//	PUT    replaces them with the JSON array in the body
+//	DELETE removes every rule
+func (inj *Injector) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+		case http.MethodPut, http.MethodPost:
+			var rules []Rule
+			if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&rules); err != nil {
+				http.Error(w, fmt.Sprintf("invalid body: %v", err), http.StatusBadRequest)
+				return
+			}
+			if err := inj.SetRules(rules); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		case http.MethodDelete:
+			inj.SetRules(nil)
+		default:
+			w.Header().Set("Allow", "GET, PUT, DELETE")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(inj.Rules())
+	})
+}
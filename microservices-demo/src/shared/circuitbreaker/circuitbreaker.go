@@ -0,0 +1,235 @@
+// Package circuitbreaker stops a service from hammering a dependency that is
+// already failing. Each target gets its own breaker that opens after a run of
+// consecutive failures, rejects calls immediately while open, and after a
+// probe interval lets a limited number of trial calls through (half-open) to
+// decide whether to close again.
+//
+// Breakers can be dropped into existing clients without touching call sites
+// via UnaryClientInterceptor, StreamClientInterceptor and Transport. State is
+// exported as boutique_circuitbreaker_state{target} (0 closed, 1 half-open,
+// 2 open).
+package circuitbreaker
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/GoogleCloudPlatform/microservices-demo/src/shared/metrics"
+)
+
+// ErrOpen is returned when a call is rejected because the breaker is open or
+// its half-open probes are all in flight.
+var ErrOpen = errors.New("circuit breaker is open")
+
+// State is the state of a breaker.
+type State int
+
+const (
+	Closed State = iota
+	HalfOpen
+	Open
+)
+
+func (s State) String() string {
+	switch s {
+	case Closed:
+		return "closed"
+	case HalfOpen:
+		return "half-open"
+	case Open:
+		return "open"
+	}
+	return fmt.Sprintf("State(%d)", int(s))
+}
+
+// Settings configures a breaker. Zero fields take the defaults documented on
+// each.
+type Settings struct {
+	// FailureThreshold is the number of consecutive failures that opens the
+	// breaker (default 5).
+	FailureThreshold int
+	// OpenTimeout is how long the breaker stays open before probing
+	// (default 30s).
+	OpenTimeout time.Duration
+	// HalfOpenProbes is the number of trial calls allowed while half-open;
+	// that many consecutive successes close the breaker (default 1).
+	HalfOpenProbes int
+	// IsFailure classifies a call result. Nil counts every non-nil error.
+	IsFailure func(error) bool
+}
+
+func (s Settings) withDefaults() Settings {
+	if s.FailureThreshold <= 0 {
+		s.FailureThreshold = 5
+	}
+	if s.OpenTimeout <= 0 {
+		s.OpenTimeout = 30 * time.Second
+	}
+	if s.HalfOpenProbes <= 0 {
+		s.HalfOpenProbes = 1
+	}
+	if s.IsFailure == nil {
+		s.IsFailure = func(err error) bool { return err != nil }
+	}
+	return s
+}
+
+var (
+	stateGauge = metrics.NewGaugeVec("circuitbreaker_state",
+		"Circuit breaker state by target: 0 closed, 1 half-open, 2 open.", "target")
+	transitionsTotal = metrics.NewCounterVec("circuitbreaker_transitions_total",
+		"Circuit breaker state changes by target and new state.", "target", "state")
+	rejectedTotal = metrics.NewCounterVec("circuitbreaker_rejected_total",
+		"Calls rejected by an open circuit breaker, by target.", "target")
+)
+
+// Breaker guards calls to a single target.
+type Breaker struct {
+	name     string
+	settings Settings
+	now      func() time.Time
+
+	mu    sync.Mutex
+	state State
+	// generation counts transitions; calls are stamped with the generation
+	// that admitted them so results from an earlier state are ignored.
+	generation uint64
+	failures   int
+	successes  int
+	probes     int // calls admitted in the current half-open generation
+	openedAt   time.Time
+}
+
+// New creates a closed breaker for the named target.
+func New(name string, s Settings) *Breaker {
+	b := &Breaker{name: name, settings: s.withDefaults(), now: time.Now}
+	stateGauge.WithLabelValues(name).Set(float64(Closed))
+	return b
+}
+
+// Name returns the target the breaker guards.
+func (b *Breaker) Name() string { return b.name }
+
+// State returns the current state, moving from open to half-open if the open
+// timeout has elapsed.
+func (b *Breaker) State() State {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.refresh()
+	return b.state
+}
+
+// Allow reserves a call. If the breaker rejects it, Allow returns ErrOpen;
+// otherwise the caller must report the outcome by calling done exactly once.
+func (b *Breaker) Allow() (done func(err error), err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.refresh()
+
+	switch b.state {
+	case Open:
+		rejectedTotal.WithLabelValues(b.name).Inc()
+		return nil, ErrOpen
+	case HalfOpen:
+		if b.probes >= b.settings.HalfOpenProbes {
+			rejectedTotal.WithLabelValues(b.name).Inc()
+			return nil, ErrOpen
+		}
+		b.probes++
+	}
+	gen := b.generation
+	var once sync.Once
+	return func(err error) {
+		once.Do(func() { b.record(gen, b.settings.IsFailure(err)) })
+	}, nil
+}
+
+// Do runs fn if the breaker allows it and records the result.
+func (b *Breaker) Do(fn func() error) error {
+	done, err := b.Allow()
+	if err != nil {
+		return err
+	}
+	err = fn()
+	done(err)
+	return err
+}
+
+// record applies the outcome of a call admitted in generation gen.
+func (b *Breaker) record(gen uint64, failed bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	// Ignore results of calls admitted before the last transition; they say
+	// nothing about the dependency's current health.
+	if gen != b.generation {
+		return
+	}
+	switch b.state {
+	case Closed:
+		if !failed {
+			b.failures = 0
+			return
+		}
+		b.failures++
+		if b.failures >= b.settings.FailureThreshold {
+			b.transition(Open)
+		}
+	case HalfOpen:
+		if failed {
+			b.transition(Open)
+			return
+		}
+		b.successes++
+		if b.successes >= b.settings.HalfOpenProbes {
+			b.transition(Closed)
+		}
+	}
+}
+
+// refresh moves an open breaker to half-open once its timeout has elapsed.
+func (b *Breaker) refresh() {
+	if b.state == Open && b.now().Sub(b.openedAt) >= b.settings.OpenTimeout {
+		b.transition(HalfOpen)
+	}
+}
+
+func (b *Breaker) transition(to State) {
+	b.state = to
+	b.generation++
+	b.failures = 0
+	b.successes = 0
+	b.probes = 0
+	if to == Open {
+		b.openedAt = b.now()
+	}
+	stateGauge.WithLabelValues(b.name).Set(float64(to))
+	transitionsTotal.WithLabelValues(b.name, to.String()).Inc()
+}
+
+// Group lazily creates one breaker per target with shared settings.
+type Group struct {
+	settings Settings
+
+	mu       sync.Mutex
+	breakers map[string]*Breaker
+}
+
+// NewGroup creates an empty Group.
+func NewGroup(s Settings) *Group {
+	return &Group{settings: s, breakers: make(map[string]*Breaker)}
+}
+
+// Get returns the breaker for target, creating it on first use.
+func (g *Group) Get(target string) *Breaker {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	b, ok := g.breakers[target]
+	if !ok {
+		b = New(target, g.settings)
+		g.breakers[target] = b
+	}
+	return b
+}
@@ -0,0 +1,131 @@
+package circuitbreaker
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+var errBoom = errors.New("boom")
+
+// fakeClock lets tests advance time without sleeping.
+type fakeClock struct{ t time.Time }
+
+func (c *fakeClock) now() time.Time { return c.t }
+
+func newTestBreaker(s Settings) (*Breaker, *fakeClock) {
+	clk := &fakeClock{t: time.Unix(0, 0)}
+	b := New("test", s)
+	b.now = clk.now
+	return b, clk
+}
+
+func TestBreakerOpensAndRecovers(t *testing.T) {
+	b, clk := newTestBreaker(Settings{FailureThreshold: 3, OpenTimeout: time.Second})
+
+	for i := 0; i < 3; i++ {
+		b.Do(func() error { return errBoom })
+	}
+	if got := b.State(); got != Open {
+		t.Fatalf("state after 3 failures = %v, want open", got)
+	}
+	if err := b.Do(func() error { return nil }); !errors.Is(err, ErrOpen) {
+		t.Errorf("Do while open = %v, want ErrOpen", err)
+	}
+
+	clk.t = clk.t.Add(time.Second)
+	if got := b.State(); got != HalfOpen {
+		t.Fatalf("state after timeout = %v, want half-open", got)
+	}
+	if err := b.Do(func() error { return nil }); err != nil {
+		t.Errorf("probe: %v", err)
+	}
+	if got := b.State(); got != Closed {
+		t.Errorf("state after successful probe = %v, want closed", got)
+	}
+}
+
+func TestBreakerSuccessResetsFailures(t *testing.T) {
+	b, _ := newTestBreaker(Settings{FailureThreshold: 2})
+	b.Do(func() error { return errBoom })
+	b.Do(func() error { return nil })
+	b.Do(func() error { return errBoom })
+	if got := b.State(); got != Closed {
+		t.Errorf("state = %v, want closed since failures were not consecutive", got)
+	}
+}
+
+func TestBreakerHalfOpenLimitsProbes(t *testing.T) {
+	b, clk := newTestBreaker(Settings{FailureThreshold: 1, OpenTimeout: time.Second})
+	b.Do(func() error { return errBoom })
+	clk.t = clk.t.Add(time.Second)
+
+	done, err := b.Allow()
+	if err != nil {
+		t.Fatalf("first probe rejected: %v", err)
+	}
+	if _, err := b.Allow(); !errors.Is(err, ErrOpen) {
+		t.Errorf("second concurrent probe = %v, want ErrOpen", err)
+	}
+	done(errBoom)
+	if got := b.State(); got != Open {
+		t.Errorf("state after failed probe = %v, want open", got)
+	}
+}
+
+func TestBreakerProbeWithSlowClosedCall(t *testing.T) {
+	b, clk := newTestBreaker(Settings{FailureThreshold: 1, OpenTimeout: time.Second})
+	slow, err := b.Allow()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.Do(func() error { return errBoom })
+	clk.t = clk.t.Add(time.Second)
+
+	// The slow call admitted while closed is still in flight; it must not
+	// take the probe's slot.
+	if err := b.Do(func() error { return nil }); err != nil {
+		t.Fatalf("probe rejected: %v", err)
+	}
+	if got := b.State(); got != Closed {
+		t.Fatalf("state after successful probe = %v, want closed", got)
+	}
+	// Its late failure belongs to an earlier generation and is ignored.
+	slow(errBoom)
+	if got := b.State(); got != Closed {
+		t.Errorf("state after stale failure = %v, want closed", got)
+	}
+}
+
+func TestIsGRPCFailure(t *testing.T) {
+	if !IsGRPCFailure(status.Error(codes.Unavailable, "")) {
+		t.Error("Unavailable not counted as failure")
+	}
+	if IsGRPCFailure(status.Error(codes.NotFound, "")) || IsGRPCFailure(nil) {
+		t.Error("NotFound or nil counted as failure")
+	}
+}
+
+func TestTransport(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	client := &http.Client{Transport: Transport(NewGroup(Settings{FailureThreshold: 2, OpenTimeout: time.Hour}), nil)}
+	for i := 0; i < 2; i++ {
+		resp, err := client.Get(srv.URL)
+		if err != nil {
+			t.Fatalf("request %d: %v", i, err)
+		}
+		resp.Body.Close()
+	}
+	if _, err := client.Get(srv.URL); !errors.Is(err, ErrOpen) {
+		t.Errorf("request after two 503s = %v, want ErrOpen", err)
+	}
+}
@@ -0,0 +1,100 @@
+package circuitbreaker
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// IsGRPCFailure counts errors that indicate an unhealthy server: Unavailable,
+// DeadlineExceeded, ResourceExhausted, Internal and Unknown. Errors such as
+// NotFound or InvalidArgument are the caller's problem and do not trip the
+// breaker. Use it as Settings.IsFailure for gRPC breakers.
+func IsGRPCFailure(err error) bool {
+	switch status.Code(err) {
+	case codes.Unavailable, codes.DeadlineExceeded, codes.ResourceExhausted, codes.Internal, codes.Unknown:
+		return true
+	}
+	return false
+}
+
+// rejected converts ErrOpen into a gRPC Unavailable error naming the target.
+func rejected(target string) error {
+	return status.Errorf(codes.Unavailable, "%v for %s", ErrOpen, target)
+}
+
+// UnaryClientInterceptor guards calls with the group's breaker for the
+// connection's target:
+//
+//	breakers := circuitbreaker.NewGroup(circuitbreaker.Settings{IsFailure: circuitbreaker.IsGRPCFailure})
+//	conn, err := grpcclient.Dial(ctx, addr,
+//	    grpcclient.WithUnaryInterceptors(circuitbreaker.UnaryClientInterceptor(breakers)))
+func UnaryClientInterceptor(g *Group) grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		b := g.Get(cc.Target())
+		done, err := b.Allow()
+		if err != nil {
+			return rejected(b.Name())
+		}
+		err = invoker(ctx, method, req, reply, cc, opts...)
+		done(err)
+		return err
+	}
+}
+
+// StreamClientInterceptor guards stream creation with the group's breaker for
+// the connection's target. Only failures to open the stream are counted.
+func StreamClientInterceptor(g *Group) grpc.StreamClientInterceptor {
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		b := g.Get(cc.Target())
+		done, err := b.Allow()
+		if err != nil {
+			return nil, rejected(b.Name())
+		}
+		cs, err := streamer(ctx, desc, cc, method, opts...)
+		done(err)
+		return cs, err
+	}
+}
+
+// Transport wraps base with per-host breakers from g. Transport errors and
+// 5xx responses count as failures; while a host's breaker is open, requests
+// fail with an error wrapping ErrOpen. A nil base uses http.DefaultTransport.
+func Transport(g *Group, base http.RoundTripper) http.RoundTripper {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return &transport{group: g, base: base}
+}
+
+type transport struct {
+	group *Group
+	base  http.RoundTripper
+}
+
+// errServer marks a 5xx response so the breaker can count it as a failure.
+type errServer struct{ code int }
+
+func (e errServer) Error() string { return fmt.Sprintf("server error %d", e.code) }
+
+func (t *transport) RoundTrip(r *http.Request) (*http.Response, error) {
+	b := t.group.Get(r.URL.Host)
+	done, err := b.Allow()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", r.URL.Host, err)
+	}
+	resp, err := t.base.RoundTrip(r)
+	switch {
+	case err != nil:
+		done(err)
+	case resp.StatusCode >= 500:
+		done(errServer{resp.StatusCode})
+	default:
+		done(nil)
+	}
+	return resp, err
+}
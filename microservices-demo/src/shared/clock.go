@@ -0,0 +1,286 @@
+package shared
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Clock abstracts the passage of time so code that waits can be tested
+// deterministically. Retry, the ratelimit package and periodic coverage dumps
+// accept one; production code uses RealClock and tests use a FakeClock.
+type Clock interface {
+	Now() time.Time
+	After(d time.Duration) <-chan time.Time
+	NewTimer(d time.Duration) Timer
+	NewTicker(d time.Duration) Ticker
+	// Sleep pauses for d or until ctx is done, returning ctx's error in the
+	// latter case.
+	Sleep(ctx context.Context, d time.Duration) error
+}
+
+// Timer is the Clock counterpart of *time.Timer.
+type Timer interface {
+	C() <-chan time.Time
+	Stop() bool
+	Reset(d time.Duration) bool
+}
+
+// Ticker is the Clock counterpart of *time.Ticker.
+type Ticker interface {
+	C() <-chan time.Time
+	Stop()
+	Reset(d time.Duration)
+}
+
+// RealClock returns a Clock backed by the time package.
+func RealClock() Clock { return realClock{} }
+
+// clockOrReal returns c, or RealClock if c is nil.
+func clockOrReal(c Clock) Clock {
+	if c == nil {
+		return realClock{}
+	}
+	return c
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time                         { return time.Now() }
+func (realClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+func (realClock) NewTimer(d time.Duration) Timer         { return realTimer{time.NewTimer(d)} }
+func (realClock) NewTicker(d time.Duration) Ticker       { return realTicker{time.NewTicker(d)} }
+
+func (realClock) Sleep(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+type realTimer struct{ t *time.Timer }
+
+func (t realTimer) C() <-chan time.Time        { return t.t.C }
+func (t realTimer) Stop() bool                 { return t.t.Stop() }
+func (t realTimer) Reset(d time.Duration) bool { return t.t.Reset(d) }
+
+type realTicker struct{ t *time.Ticker }
+
+func (t realTicker) C() <-chan time.Time   { return t.t.C }
+func (t realTicker) Stop()                 { t.t.Stop() }
+func (t realTicker) Reset(d time.Duration) { t.t.Reset(d) }
+
+// FakeClock is a Clock whose time only moves when Advance or Set is called.
+// Timers and tickers fire synchronously inside those calls, in deadline
+// order. Channels are buffered with capacity one and, like the time package,
+// a tick is dropped if the previous one has not been received.
+//
+// Usage:
+//
+//	clk := shared.NewFakeClock(time.Unix(0, 0))
+//	go func() { done <- shared.Retry(ctx, shared.RetryPolicy{Clock: clk, ...}, fn) }()
+//	clk.BlockUntil(1) // Retry is now waiting
+//	clk.Advance(time.Second)
+type FakeClock struct {
+	mu      sync.Mutex
+	now     time.Time
+	waiters []*fakeWaiter
+	changed chan struct{} // closed and replaced whenever waiters changes
+}
+
+type fakeWaiter struct {
+	clock  *FakeClock
+	when   time.Time
+	period time.Duration // non-zero for tickers
+	ch     chan time.Time
+	active bool
+}
+
+// NewFakeClock returns a FakeClock set to t.
+func NewFakeClock(t time.Time) *FakeClock {
+	return &FakeClock{now: t, changed: make(chan struct{})}
+}
+
+// Now returns the fake time.
+func (c *FakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// After returns a channel that receives the fake time once d has elapsed.
+func (c *FakeClock) After(d time.Duration) <-chan time.Time {
+	return c.NewTimer(d).C()
+}
+
+// NewTimer returns a timer firing once d has elapsed.
+func (c *FakeClock) NewTimer(d time.Duration) Timer {
+	return &fakeTimer{c.add(d, 0)}
+}
+
+// NewTicker returns a ticker firing every d. It panics if d is not positive.
+func (c *FakeClock) NewTicker(d time.Duration) Ticker {
+	if d <= 0 {
+		panic("shared: non-positive interval for FakeClock.NewTicker")
+	}
+	return &fakeTicker{c.add(d, d)}
+}
+
+// Sleep blocks until the clock has been advanced by d or ctx is done.
+func (c *FakeClock) Sleep(ctx context.Context, d time.Duration) error {
+	t := c.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C():
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Advance moves the clock forward by d, firing every timer and ticker whose
+// deadline is reached along the way.
+func (c *FakeClock) Advance(d time.Duration) {
+	c.Set(c.Now().Add(d))
+}
+
+// Set moves the clock to t, firing due timers and tickers. Moving backwards
+// fires nothing.
+func (c *FakeClock) Set(t time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.fire(t)
+}
+
+// fire delivers every waiter due at or before t and moves the clock to t.
+// c.mu must be held.
+func (c *FakeClock) fire(t time.Time) {
+	if t.Before(c.now) {
+		c.now = t
+		return
+	}
+	for {
+		w := c.nextDue(t)
+		if w == nil {
+			break
+		}
+		c.now = w.when
+		select {
+		case w.ch <- w.when:
+		default:
+		}
+		if w.period > 0 {
+			w.when = w.when.Add(w.period)
+		} else {
+			c.remove(w)
+		}
+	}
+	c.now = t
+}
+
+// Waiters returns the number of active timers and tickers.
+func (c *FakeClock) Waiters() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.waiters)
+}
+
+// BlockUntil waits until at least n timers or tickers are active, so a test
+// can be sure the code under test is waiting before it advances the clock.
+func (c *FakeClock) BlockUntil(n int) {
+	for {
+		c.mu.Lock()
+		if len(c.waiters) >= n {
+			c.mu.Unlock()
+			return
+		}
+		ch := c.changed
+		c.mu.Unlock()
+		<-ch
+	}
+}
+
+// nextDue returns the earliest waiter due at or before t. c.mu must be held.
+func (c *FakeClock) nextDue(t time.Time) *fakeWaiter {
+	sort.SliceStable(c.waiters, func(i, j int) bool { return c.waiters[i].when.Before(c.waiters[j].when) })
+	if len(c.waiters) > 0 && !c.waiters[0].when.After(t) {
+		return c.waiters[0]
+	}
+	return nil
+}
+
+func (c *FakeClock) add(d, period time.Duration) *fakeWaiter {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	w := &fakeWaiter{clock: c, when: c.now.Add(d), period: period, ch: make(chan time.Time, 1)}
+	c.insert(w)
+	if d <= 0 {
+		// Like time.NewTimer, a non-positive duration fires immediately.
+		c.fire(c.now)
+	}
+	return w
+}
+
+// insert and remove maintain the waiter list. c.mu must be held.
+func (c *FakeClock) insert(w *fakeWaiter) {
+	w.active = true
+	c.waiters = append(c.waiters, w)
+	c.notifyChanged()
+}
+
+func (c *FakeClock) remove(w *fakeWaiter) bool {
+	if !w.active {
+		return false
+	}
+	w.active = false
+	for i, x := range c.waiters {
+		if x == w {
+			c.waiters = append(c.waiters[:i], c.waiters[i+1:]...)
+			break
+		}
+	}
+	c.notifyChanged()
+	return true
+}
+
+func (c *FakeClock) notifyChanged() {
+	close(c.changed)
+	c.changed = make(chan struct{})
+}
+
+// reset re-arms w to fire d from now.
+func (w *fakeWaiter) reset(d time.Duration) bool {
+	c := w.clock
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	wasActive := c.remove(w)
+	w.when = c.now.Add(d)
+	if w.period > 0 {
+		w.period = d
+	}
+	c.insert(w)
+	return wasActive
+}
+
+func (w *fakeWaiter) stop() bool {
+	w.clock.mu.Lock()
+	defer w.clock.mu.Unlock()
+	return w.clock.remove(w)
+}
+
+type fakeTimer struct{ w *fakeWaiter }
+
+func (t *fakeTimer) C() <-chan time.Time        { return t.w.ch }
+func (t *fakeTimer) Stop() bool                 { return t.w.stop() }
+func (t *fakeTimer) Reset(d time.Duration) bool { return t.w.reset(d) }
+
+type fakeTicker struct{ w *fakeWaiter }
+
+func (t *fakeTicker) C() <-chan time.Time   { return t.w.ch }
+func (t *fakeTicker) Stop()                 { t.w.stop() }
+func (t *fakeTicker) Reset(d time.Duration) { t.w.reset(d) }
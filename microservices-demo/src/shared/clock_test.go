@@ -0,0 +1,93 @@
+package shared
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestFakeClockTimers(t *testing.T) {
+	clk := NewFakeClock(time.Unix(0, 0))
+	short := clk.NewTimer(time.Second)
+	long := clk.NewTimer(3 * time.Second)
+
+	clk.Advance(2 * time.Second)
+	select {
+	case got := <-short.C():
+		if want := time.Unix(1, 0); !got.Equal(want) {
+			t.Errorf("short fired at %v, want %v", got, want)
+		}
+	default:
+		t.Fatal("short timer did not fire")
+	}
+	select {
+	case <-long.C():
+		t.Fatal("long timer fired early")
+	default:
+	}
+	if !long.Stop() {
+		t.Error("Stop on pending timer returned false")
+	}
+	clk.Advance(time.Hour)
+	select {
+	case <-long.C():
+		t.Error("stopped timer fired")
+	default:
+	}
+	if got := clk.Now(); !got.Equal(time.Unix(3602, 0)) {
+		t.Errorf("Now = %v", got)
+	}
+}
+
+func TestFakeClockTicker(t *testing.T) {
+	clk := NewFakeClock(time.Unix(0, 0))
+	tk := clk.NewTicker(time.Second)
+	defer tk.Stop()
+
+	for i := 1; i <= 3; i++ {
+		clk.Advance(time.Second)
+		if got := <-tk.C(); !got.Equal(time.Unix(int64(i), 0)) {
+			t.Errorf("tick %d at %v", i, got)
+		}
+	}
+}
+
+func TestFakeClockSleepAndBlockUntil(t *testing.T) {
+	clk := NewFakeClock(time.Unix(0, 0))
+	done := make(chan error)
+	go func() { done <- clk.Sleep(context.Background(), time.Minute) }()
+
+	clk.BlockUntil(1)
+	clk.Advance(time.Minute)
+	if err := <-done; err != nil {
+		t.Errorf("Sleep = %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := clk.Sleep(ctx, time.Minute); err != context.Canceled {
+		t.Errorf("Sleep with cancelled ctx = %v, want Canceled", err)
+	}
+}
+
+func TestRetryWithFakeClock(t *testing.T) {
+	clk := NewFakeClock(time.Unix(0, 0))
+	calls := 0
+	done := make(chan error)
+	go func() {
+		done <- Retry(context.Background(), RetryPolicy{MaxAttempts: 2, InitialBackoff: time.Hour, Clock: clk},
+			func(context.Context) error {
+				calls++
+				if calls == 1 {
+					return errors.New("flaky")
+				}
+				return nil
+			})
+	}()
+	clk.BlockUntil(1)
+	clk.Advance(time.Hour)
+	if err := <-done; err != nil || calls != 2 {
+		t.Errorf("Retry = %v after %d calls, want nil after 2", err, calls)
+	}
+}
@@ -0,0 +1,156 @@
+package shared
+
+import (
+	"encoding"
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// LoadConfig fills the struct pointed to by cfg from environment variables,
+// driven by struct tags:
+//
+//	type Config struct {
+//	    Port         int           `env:"PORT" default:"50051"`
+//	    CatalogAddr  string        `env:"PRODUCT_CATALOG_SERVICE_ADDR" required:"true"`
+//	    Timeout      time.Duration `env:"RPC_TIMEOUT" default:"5s"`
+//	    AllowedHosts []string      `env:"ALLOWED_HOSTS" default:"localhost,127.0.0.1"`
+//	    Tracing      TracingConfig `envPrefix:"TRACING_"`
+//	}
+//
+//	var cfg Config
+//	if err := shared.LoadConfig(&cfg); err != nil {
+//	    log.Fatal(err)
+//	}
+//
+// Supported field types are strings, booleans, signed and unsigned integers,
+// floats, time.Duration, types implementing encoding.TextUnmarshaler, slices
+// of those (comma-separated), and nested structs, whose variable names are
+// prefixed with the envPrefix tag. Fields without an env tag are left alone.
+//
+// Every missing required variable and unparsable value is reported in a single
+// joined error, so a misconfigured deployment fails at startup with the full
+// list rather than one typo at a time.
+func LoadConfig(cfg any) error {
+	return loadConfig(cfg, os.LookupEnv)
+}
+
+// loadConfig is LoadConfig with a pluggable variable source.
+func loadConfig(cfg any, lookup func(string) (string, bool)) error {
+	v := reflect.ValueOf(cfg)
+	if v.Kind() != reflect.Pointer || v.IsNil() || v.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("config: LoadConfig requires a non-nil pointer to a struct, got %T", cfg)
+	}
+	var errs []error
+	loadConfigStruct(v.Elem(), "", lookup, &errs)
+	return errors.Join(errs...)
+}
+
+func loadConfigStruct(v reflect.Value, prefix string, lookup func(string) (string, bool), errs *[]error) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		fv := v.Field(i)
+
+		name, ok := field.Tag.Lookup("env")
+		if !ok {
+			if fv.Kind() == reflect.Struct && !isConfigScalar(fv) {
+				loadConfigStruct(fv, prefix+field.Tag.Get("envPrefix"), lookup, errs)
+			}
+			continue
+		}
+		name = prefix + name
+
+		raw, found := lookup(name)
+		if !found || raw == "" {
+			if field.Tag.Get("required") == "true" {
+				*errs = append(*errs, fmt.Errorf("config: %s is required", name))
+				continue
+			}
+			raw, found = field.Tag.Lookup("default")
+			if !found {
+				continue
+			}
+		}
+		if err := setConfigValue(fv, raw); err != nil {
+			*errs = append(*errs, fmt.Errorf("config: %s=%q: %w", name, raw, err))
+		}
+	}
+}
+
+var (
+	durationType        = reflect.TypeOf(time.Duration(0))
+	textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+)
+
+// isConfigScalar reports whether v is set from a single value even though it
+// may be a struct, such as time.Time.
+func isConfigScalar(v reflect.Value) bool {
+	return v.Addr().Type().Implements(textUnmarshalerType)
+}
+
+// setConfigValue parses raw into v.
+func setConfigValue(v reflect.Value, raw string) error {
+	if v.CanAddr() && isConfigScalar(v) {
+		return v.Addr().Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(raw))
+	}
+	if v.Type() == durationType {
+		d, err := time.ParseDuration(raw)
+		if err != nil {
+			return err
+		}
+		v.SetInt(int64(d))
+		return nil
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(raw)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(raw, 0, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(raw, 0, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(raw, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetFloat(f)
+	case reflect.Slice:
+		var parts []string
+		if raw != "" {
+			parts = strings.Split(raw, ",")
+		}
+		s := reflect.MakeSlice(v.Type(), len(parts), len(parts))
+		for i, p := range parts {
+			if err := setConfigValue(s.Index(i), strings.TrimSpace(p)); err != nil {
+				return fmt.Errorf("element %d: %w", i, err)
+			}
+		}
+		v.Set(s)
+	default:
+		return fmt.Errorf("unsupported field type %v", v.Type())
+	}
+	return nil
+}
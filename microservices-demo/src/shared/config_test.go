@@ -0,0 +1,76 @@
+package shared
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+type testTracingConfig struct {
+	Enabled bool `env:"ENABLED" default:"true"`
+}
+
+type testConfig struct {
+	Port     int               `env:"PORT" default:"50051"`
+	Addr     string            `env:"ADDR" required:"true"`
+	Timeout  time.Duration     `env:"TIMEOUT" default:"5s"`
+	Hosts    []string          `env:"HOSTS" default:"a, b"`
+	Ratio    float64           `env:"RATIO"`
+	Deadline time.Time         `env:"DEADLINE"`
+	Tracing  testTracingConfig `envPrefix:"TRACING_"`
+	Ignored  string
+}
+
+func mapLookup(m map[string]string) func(string) (string, bool) {
+	return func(k string) (string, bool) {
+		v, ok := m[k]
+		return v, ok
+	}
+}
+
+func TestLoadConfig(t *testing.T) {
+	var cfg testConfig
+	err := loadConfig(&cfg, mapLookup(map[string]string{
+		"ADDR":            "catalog:3550",
+		"RATIO":           "0.25",
+		"DEADLINE":        "2026-01-02T15:04:05Z",
+		"TRACING_ENABLED": "false",
+	}))
+	if err != nil {
+		t.Fatalf("loadConfig: %v", err)
+	}
+	if cfg.Port != 50051 || cfg.Addr != "catalog:3550" || cfg.Timeout != 5*time.Second || cfg.Ratio != 0.25 {
+		t.Errorf("cfg = %+v", cfg)
+	}
+	if len(cfg.Hosts) != 2 || cfg.Hosts[0] != "a" || cfg.Hosts[1] != "b" {
+		t.Errorf("Hosts = %q, want [a b]", cfg.Hosts)
+	}
+	if cfg.Deadline.Year() != 2026 {
+		t.Errorf("Deadline = %v", cfg.Deadline)
+	}
+	if cfg.Tracing.Enabled {
+		t.Errorf("Tracing.Enabled = true, want false from TRACING_ENABLED")
+	}
+}
+
+func TestLoadConfigAggregatesErrors(t *testing.T) {
+	var cfg testConfig
+	err := loadConfig(&cfg, mapLookup(map[string]string{
+		"PORT":    "fifty",
+		"TIMEOUT": "5 parsecs",
+	}))
+	if err == nil {
+		t.Fatal("loadConfig succeeded, want errors")
+	}
+	for _, want := range []string{"ADDR is required", "PORT=", "TIMEOUT="} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error %q does not mention %q", err, want)
+		}
+	}
+}
+
+func TestLoadConfigRejectsNonPointer(t *testing.T) {
+	if err := LoadConfig(testConfig{}); err == nil {
+		t.Error("LoadConfig(struct) succeeded, want error")
+	}
+}
@@ -0,0 +1,275 @@
+package shared
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"maps"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// DefaultConfigPollInterval is how often a ConfigWatcher re-reads its file
+// unless overridden with WithConfigPollInterval.
+const DefaultConfigPollInterval = 10 * time.Second
+
+// ConfigWatcher keeps a config struct of type T up to date with a mounted
+// file and the environment, and notifies subscribers when it changes.
+type ConfigWatcher[T any] struct {
+	path     string
+	interval time.Duration
+	lookup   func(string) (string, bool)
+
+	current atomic.Pointer[T]
+
+	reloadMu sync.Mutex // serializes reloads; guards files
+	files    map[string]string
+
+	mu        sync.Mutex
+	callbacks []func(old, cur T)
+	subs      []chan T
+}
+
+// ConfigWatchOption configures WatchConfig.
+type ConfigWatchOption func(*configWatchOptions)
+
+type configWatchOptions struct {
+	interval time.Duration
+}
+
+// WithConfigPollInterval sets how often the file is re-read. SIGHUP always
+// triggers an immediate reload.
+func WithConfigPollInterval(d time.Duration) ConfigWatchOption {
+	return func(o *configWatchOptions) { o.interval = d }
+}
+
+// WatchConfig loads a T the same way LoadConfig does, except that values are
+// also read from path, and keeps reloading it until ctx is done.
+//
+// path may be a directory, as produced by mounting a Kubernetes ConfigMap,
+// where each file name is a variable name and its content the value; or a
+// single file of KEY=VALUE lines, where blank lines and lines starting with #
+// are ignored. Environment variables take precedence over the file, so a
+// deployment can still pin a value.
+//
+// The file is re-read every poll interval and whenever the process receives
+// SIGHUP. A reload that fails validation is logged and the previous config is
+// kept. The initial load must succeed.
+//
+// Usage:
+//
+//	w, err := shared.WatchConfig[Config](ctx, "/etc/checkout")
+//	if err != nil {
+//	    log.Fatal(err)
+//	}
+//	w.OnChange(func(old, cur Config) {
+//	    limiter.SetLimit(cur.RateLimit)
+//	})
+//	cfg := w.Current()
+func WatchConfig[T any](ctx context.Context, path string, opts ...ConfigWatchOption) (*ConfigWatcher[T], error) {
+	o := configWatchOptions{interval: DefaultConfigPollInterval}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	w := &ConfigWatcher[T]{path: path, interval: o.interval, lookup: os.LookupEnv}
+	if err := w.load(); err != nil {
+		return nil, err
+	}
+
+	hup := make(chan os.Signal, 1)
+	DefaultSignalRouter().Notify("config "+path, hup, syscall.SIGHUP)
+	go w.run(ctx, hup)
+	return w, nil
+}
+
+// Current returns the most recently loaded config.
+func (w *ConfigWatcher[T]) Current() T {
+	return *w.current.Load()
+}
+
+// OnChange registers fn to be called, from the watcher goroutine, with the
+// previous and new config after every reload that changed a value.
+func (w *ConfigWatcher[T]) OnChange(fn func(old, cur T)) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.callbacks = append(w.callbacks, fn)
+}
+
+// Subscribe returns a channel that receives the new config after every
+// change. A slow receiver only sees the latest value. The channel is closed
+// when the watcher stops.
+func (w *ConfigWatcher[T]) Subscribe() <-chan T {
+	ch := make(chan T, 1)
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.subs = append(w.subs, ch)
+	return ch
+}
+
+// Reload re-reads the file immediately and reports whether the config changed.
+func (w *ConfigWatcher[T]) Reload() (bool, error) {
+	w.reloadMu.Lock()
+	defer w.reloadMu.Unlock()
+
+	files, err := ReadConfigFiles(w.path)
+	if err != nil {
+		return false, err
+	}
+	if maps.Equal(files, w.files) {
+		return false, nil
+	}
+	old := w.Current()
+	if err := w.apply(files); err != nil {
+		return false, err
+	}
+	w.notify(old, w.Current())
+	return true, nil
+}
+
+func (w *ConfigWatcher[T]) run(ctx context.Context, hup chan os.Signal) {
+	defer DefaultSignalRouter().Stop(hup)
+	defer w.closeSubs()
+
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+			log.Printf("Config: Received SIGHUP, reloading %s", w.path)
+		case <-ticker.C:
+		}
+		changed, err := w.Reload()
+		if err != nil {
+			log.Printf("Config: Reload of %s failed, keeping previous config: %v", w.path, err)
+			continue
+		}
+		if changed {
+			log.Printf("Config: Reloaded %s", w.path)
+		}
+	}
+}
+
+// load performs the initial read.
+func (w *ConfigWatcher[T]) load() error {
+	files, err := ReadConfigFiles(w.path)
+	if err != nil {
+		return err
+	}
+	return w.apply(files)
+}
+
+// apply builds a T from files overlaid with the environment and, if it is
+// valid, makes it current.
+func (w *ConfigWatcher[T]) apply(files map[string]string) error {
+	var cfg T
+	err := loadConfig(&cfg, func(key string) (string, bool) {
+		if v, ok := w.lookup(key); ok {
+			return v, true
+		}
+		v, ok := files[key]
+		return v, ok
+	})
+	if err != nil {
+		return err
+	}
+	w.files = files
+	w.current.Store(&cfg)
+	return nil
+}
+
+func (w *ConfigWatcher[T]) notify(old, cur T) {
+	w.mu.Lock()
+	callbacks := append([]func(old, cur T){}, w.callbacks...)
+	subs := append([]chan T{}, w.subs...)
+	w.mu.Unlock()
+
+	for _, fn := range callbacks {
+		fn(old, cur)
+	}
+	for _, ch := range subs {
+		select {
+		case <-ch:
+		default:
+		}
+		ch <- cur
+	}
+}
+
+func (w *ConfigWatcher[T]) closeSubs() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for _, ch := range w.subs {
+		close(ch)
+	}
+	w.subs = nil
+}
+
+// ReadConfigFiles reads the variables stored at path in the formats described
+// on WatchConfig. A missing path yields no variables so a service can start
+// before its ConfigMap exists.
+func ReadConfigFiles(path string) (map[string]string, error) {
+	info, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return map[string]string{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("config: %w", err)
+	}
+	if !info.IsDir() {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("config: %w", err)
+		}
+		return parseConfigFile(path, data)
+	}
+
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		return nil, fmt.Errorf("config: %w", err)
+	}
+	files := make(map[string]string, len(entries))
+	for _, e := range entries {
+		// ConfigMap volumes keep their data in hidden ..data directories and
+		// expose each key as a symlink into them.
+		if strings.HasPrefix(e.Name(), ".") {
+			continue
+		}
+		p := filepath.Join(path, e.Name())
+		if fi, err := os.Stat(p); err != nil || fi.IsDir() {
+			continue
+		}
+		data, err := os.ReadFile(p)
+		if err != nil {
+			return nil, fmt.Errorf("config: %w", err)
+		}
+		files[e.Name()] = strings.TrimSpace(string(data))
+	}
+	return files, nil
+}
+
+// parseConfigFile parses KEY=VALUE lines.
+func parseConfigFile(path string, data []byte) (map[string]string, error) {
+	files := make(map[string]string)
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			return nil, fmt.Errorf("config: %s:%d: expected KEY=VALUE", path, n)
+		}
+		files[strings.TrimSpace(key)] = strings.Trim(strings.TrimSpace(value), `"`)
+	}
+	return files, sc.Err()
+}
@@ -0,0 +1,96 @@
+package shared
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type watchedConfig struct {
+	Limit int    `env:"LIMIT" default:"10"`
+	Mode  string `env:"MODE"`
+}
+
+func TestWatchConfigDirectory(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "LIMIT"), []byte("20\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	w, err := WatchConfig[watchedConfig](ctx, dir, WithConfigPollInterval(time.Hour))
+	if err != nil {
+		t.Fatalf("WatchConfig: %v", err)
+	}
+	if got := w.Current().Limit; got != 20 {
+		t.Errorf("Limit = %d, want 20", got)
+	}
+
+	var gotOld, gotCur watchedConfig
+	w.OnChange(func(old, cur watchedConfig) { gotOld, gotCur = old, cur })
+	ch := w.Subscribe()
+
+	if err := os.WriteFile(filepath.Join(dir, "LIMIT"), []byte("30"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if changed, err := w.Reload(); err != nil || !changed {
+		t.Fatalf("Reload() = %v, %v, want true, nil", changed, err)
+	}
+	if gotOld.Limit != 20 || gotCur.Limit != 30 {
+		t.Errorf("OnChange got old=%d cur=%d, want 20, 30", gotOld.Limit, gotCur.Limit)
+	}
+	if cfg := <-ch; cfg.Limit != 30 {
+		t.Errorf("Subscribe received Limit=%d, want 30", cfg.Limit)
+	}
+	if changed, _ := w.Reload(); changed {
+		t.Error("Reload reported a change for identical content")
+	}
+}
+
+func TestWatchConfigKeepsPreviousOnInvalidReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.env")
+	if err := os.WriteFile(path, []byte("# limits\nLIMIT=5\nMODE=\"fast\"\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	w, err := WatchConfig[watchedConfig](ctx, path, WithConfigPollInterval(time.Hour))
+	if err != nil {
+		t.Fatalf("WatchConfig: %v", err)
+	}
+	if cfg := w.Current(); cfg.Limit != 5 || cfg.Mode != "fast" {
+		t.Errorf("Current() = %+v", cfg)
+	}
+
+	if err := os.WriteFile(path, []byte("LIMIT=lots\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Reload(); err == nil {
+		t.Error("Reload accepted an invalid value")
+	}
+	if got := w.Current().Limit; got != 5 {
+		t.Errorf("Limit after failed reload = %d, want 5", got)
+	}
+}
+
+func TestWatchConfigEnvOverridesFile(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "MODE"), []byte("file"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("MODE", "env")
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	w, err := WatchConfig[watchedConfig](ctx, dir, WithConfigPollInterval(time.Hour))
+	if err != nil {
+		t.Fatalf("WatchConfig: %v", err)
+	}
+	if got := w.Current().Mode; got != "env" {
+		t.Errorf("Mode = %q, want env", got)
+	}
+}
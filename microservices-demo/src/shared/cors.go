@@ -0,0 +1,85 @@
+package shared
+
+import (
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CORSConfig controls which browser origins may call an HTTP API. Load it
+// with CORSConfigFromEnv or fill it in directly.
+type CORSConfig struct {
+	// AllowedOrigins lists origins such as "https://shop.example.com"; "*"
+	// allows any. Empty disables CORS, so browsers only allow same-origin
+	// calls.
+	AllowedOrigins []string `env:"CORS_ALLOWED_ORIGINS"`
+	// AllowedMethods answers preflight requests.
+	AllowedMethods []string `env:"CORS_ALLOWED_METHODS" default:"GET,POST,OPTIONS"`
+	// AllowedHeaders answers preflight requests.
+	AllowedHeaders []string `env:"CORS_ALLOWED_HEADERS" default:"Authorization,Content-Type,X-Request-Id,baggage"`
+	// AllowCredentials lets browsers send cookies and auth headers. The
+	// matching origin is echoed instead of "*", as the spec requires.
+	AllowCredentials bool `env:"CORS_ALLOW_CREDENTIALS"`
+	// MaxAge is how long browsers may cache a preflight response.
+	MaxAge time.Duration `env:"CORS_MAX_AGE" default:"10m"`
+}
+
+// CORSConfigFromEnv loads a CORSConfig with LoadConfig.
+func CORSConfigFromEnv() (CORSConfig, error) {
+	var cfg CORSConfig
+	err := LoadConfig(&cfg)
+	return cfg, err
+}
+
+// allowOrigin returns the Access-Control-Allow-Origin value for origin, or
+// "" if it is not allowed.
+func (c CORSConfig) allowOrigin(origin string) string {
+	if origin == "" {
+		return ""
+	}
+	if slices.Contains(c.AllowedOrigins, "*") {
+		if c.AllowCredentials {
+			return origin
+		}
+		return "*"
+	}
+	if slices.Contains(c.AllowedOrigins, origin) {
+		return origin
+	}
+	return ""
+}
+
+// Middleware adds CORS headers for allowed origins and answers their
+// preflight requests without calling next. Requests from other origins pass
+// through unchanged; the browser then refuses to expose the response.
+func (c CORSConfig) Middleware(next http.Handler) http.Handler {
+	if len(c.AllowedOrigins) == 0 {
+		return next
+	}
+	methods := strings.Join(c.AllowedMethods, ", ")
+	headers := strings.Join(c.AllowedHeaders, ", ")
+	maxAge := strconv.Itoa(int(c.MaxAge / time.Second))
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h := w.Header()
+		h.Add("Vary", "Origin")
+		allow := c.allowOrigin(r.Header.Get("Origin"))
+		if allow == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		h.Set("Access-Control-Allow-Origin", allow)
+		if c.AllowCredentials {
+			h.Set("Access-Control-Allow-Credentials", "true")
+		}
+		if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+			h.Set("Access-Control-Allow-Methods", methods)
+			h.Set("Access-Control-Allow-Headers", headers)
+			h.Set("Access-Control-Max-Age", maxAge)
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
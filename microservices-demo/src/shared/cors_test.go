@@ -0,0 +1,53 @@
+package shared
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestCORSMiddleware(t *testing.T) {
+	called := false
+	h := CORSConfig{
+		AllowedOrigins: []string{"https://shop.test"},
+		AllowedMethods: []string{"GET", "POST"},
+		AllowedHeaders: []string{"Content-Type"},
+		MaxAge:         time.Minute,
+	}.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { called = true }))
+
+	req := httptest.NewRequest(http.MethodOptions, "/", nil)
+	req.Header.Set("Origin", "https://shop.test")
+	req.Header.Set("Access-Control-Request-Method", "POST")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if called || rec.Code != http.StatusNoContent {
+		t.Errorf("preflight: status %d, handler called %v; want 204 without the handler", rec.Code, called)
+	}
+	if got := rec.Header().Get("Access-Control-Allow-Methods"); got != "GET, POST" {
+		t.Errorf("Access-Control-Allow-Methods = %q", got)
+	}
+	if got := rec.Header().Get("Access-Control-Max-Age"); got != "60" {
+		t.Errorf("Access-Control-Max-Age = %q, want 60", got)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Origin", "https://evil.test")
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if !called || rec.Header().Get("Access-Control-Allow-Origin") != "" {
+		t.Errorf("other origin: handler called %v, allow origin %q; want called and no header",
+			called, rec.Header().Get("Access-Control-Allow-Origin"))
+	}
+}
+
+func TestCORSWildcardWithCredentials(t *testing.T) {
+	cfg := CORSConfig{AllowedOrigins: []string{"*"}}
+	if got := cfg.allowOrigin("https://a.test"); got != "*" {
+		t.Errorf("allowOrigin = %q, want *", got)
+	}
+	cfg.AllowCredentials = true
+	if got := cfg.allowOrigin("https://a.test"); got != "https://a.test" {
+		t.Errorf("allowOrigin with credentials = %q, want the origin echoed", got)
+	}
+}
@@ -0,0 +1,249 @@
+// Package cost attributes the resources a service spends to the RPC methods
+// that spent them: CPU time, heap allocations and downstream calls, per
+// method, so a load test shows which endpoints are expensive and not only
+// which are slow.
+//
+// Go has no per-goroutine CPU or allocation counters, so the Accountant
+// reads the process-wide ones from runtime/metrics whenever a call starts
+// or ends and splits what was spent since the previous reading evenly
+// among the calls in flight. Over many calls this converges on each
+// method's share; a single call's figures are only an estimate. The
+// runtime advances its CPU estimate at each garbage collection, so CPU
+// arrives in GC-cycle-sized chunks, and what is spent with no call in
+// flight is reported as unattributed.
+//
+//	acc := cost.New()
+//	srv := grpcserver.New(grpcserver.WithUnaryInterceptors(acc.UnaryServerInterceptor()))
+//	conn, _ := grpcclient.Dial(ctx, addr, grpcclient.WithUnaryInterceptors(cost.UnaryClientInterceptor()))
+//	shared.StartAdminServer("", shared.WithAdminHandler("/admin/cost", acc.Handler()))
+package cost
+
+import (
+	"cmp"
+	"context"
+	runtimemetrics "runtime/metrics"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/GoogleCloudPlatform/microservices-demo/src/shared/metrics"
+)
+
+var (
+	callsTotal = metrics.NewCounterVec("rpc_cost_calls_total",
+		"Calls accounted for, by method.", "method")
+	cpuTotal = metrics.NewCounterVec("rpc_cost_cpu_seconds_total",
+		"Estimated CPU time of Go code attributed to calls, by method.", "method")
+	allocBytesTotal = metrics.NewCounterVec("rpc_cost_alloc_bytes_total",
+		"Estimated heap bytes allocated by calls, by method.", "method")
+	allocsTotal = metrics.NewCounterVec("rpc_cost_allocs_total",
+		"Estimated heap objects allocated by calls, by method.", "method")
+	downstreamTotal = metrics.NewCounterVec("rpc_cost_downstream_calls_total",
+		"Downstream calls made while serving calls, by method and downstream method.", "method", "downstream")
+	unattributedCPU = metrics.NewCounterVec("rpc_cost_unattributed_cpu_seconds_total",
+		"CPU time of Go code spent while no call was in flight.")
+)
+
+// runtime/metrics names read by the Accountant.
+const (
+	cpuMetric     = "/cpu/classes/user:cpu-seconds"
+	bytesMetric   = "/gc/heap/allocs:bytes"
+	objectsMetric = "/gc/heap/allocs:objects"
+)
+
+// Usage is an amount of resources.
+type Usage struct {
+	CPUSeconds float64 `json:"cpuSeconds"`
+	AllocBytes float64 `json:"allocBytes"`
+	Allocs     float64 `json:"allocs"`
+}
+
+func (u Usage) add(v Usage) Usage {
+	return Usage{u.CPUSeconds + v.CPUSeconds, u.AllocBytes + v.AllocBytes, u.Allocs + v.Allocs}
+}
+
+func (u Usage) sub(v Usage) Usage {
+	return Usage{u.CPUSeconds - v.CPUSeconds, u.AllocBytes - v.AllocBytes, u.Allocs - v.Allocs}
+}
+
+func (u Usage) div(n float64) Usage {
+	return Usage{u.CPUSeconds / n, u.AllocBytes / n, u.Allocs / n}
+}
+
+// MethodCost is what the calls of one method have cost since the
+// Accountant started or was reset.
+type MethodCost struct {
+	Method string `json:"method"`
+	Calls  uint64 `json:"calls"`
+	Total  Usage  `json:"total"`
+	// PerCall is Total divided by Calls.
+	PerCall Usage `json:"perCall"`
+	// Downstream counts the calls made to other services, by downstream
+	// method.
+	Downstream map[string]uint64 `json:"downstream,omitempty"`
+}
+
+// Report is a snapshot of an Accountant.
+type Report struct {
+	Since time.Time `json:"since"`
+	// Methods is sorted by total CPU time, most expensive first.
+	Methods      []MethodCost `json:"methods"`
+	Unattributed Usage        `json:"unattributed"`
+}
+
+// Accountant attributes resource usage to the calls it tracks.
+type Accountant struct {
+	mu           sync.Mutex
+	samples      []runtimemetrics.Sample
+	last         Usage
+	inflight     map[*call]struct{}
+	methods      map[string]*MethodCost
+	unattributed Usage
+	since        time.Time
+}
+
+// call is one call in flight.
+type call struct {
+	method string
+	usage  Usage // guarded by the Accountant's mu
+
+	mu         sync.Mutex
+	downstream map[string]uint64
+}
+
+// New returns an Accountant.
+func New() *Accountant {
+	a := &Accountant{
+		samples:  []runtimemetrics.Sample{{Name: cpuMetric}, {Name: bytesMetric}, {Name: objectsMetric}},
+		inflight: make(map[*call]struct{}),
+		methods:  make(map[string]*MethodCost),
+		since:    time.Now(),
+	}
+	a.last = a.read()
+	return a
+}
+
+// read returns the process totals. a.mu must be held, or a not yet shared.
+func (a *Accountant) read() Usage {
+	runtimemetrics.Read(a.samples)
+	var u Usage
+	for _, s := range a.samples {
+		switch s.Value.Kind() {
+		case runtimemetrics.KindFloat64:
+			u.CPUSeconds = s.Value.Float64()
+		case runtimemetrics.KindUint64:
+			if s.Name == bytesMetric {
+				u.AllocBytes = float64(s.Value.Uint64())
+			} else {
+				u.Allocs = float64(s.Value.Uint64())
+			}
+		}
+	}
+	return u
+}
+
+// sample splits the usage since the last reading among the calls in
+// flight. a.mu must be held.
+func (a *Accountant) sample() {
+	now := a.read()
+	delta := now.sub(a.last)
+	a.last = now
+	if len(a.inflight) == 0 {
+		a.unattributed = a.unattributed.add(delta)
+		unattributedCPU.WithLabelValues().Add(delta.CPUSeconds)
+		return
+	}
+	share := delta.div(float64(len(a.inflight)))
+	for c := range a.inflight {
+		c.usage = c.usage.add(share)
+	}
+}
+
+type callKey struct{}
+
+// start begins accounting a call of method, returning ctx carrying it.
+func (a *Accountant) start(ctx context.Context, method string) (context.Context, *call) {
+	c := &call{method: method}
+	a.mu.Lock()
+	a.sample()
+	a.inflight[c] = struct{}{}
+	a.mu.Unlock()
+	return context.WithValue(ctx, callKey{}, c), c
+}
+
+// end finishes accounting c and adds it to its method.
+func (a *Accountant) end(c *call) {
+	a.mu.Lock()
+	a.sample()
+	delete(a.inflight, c)
+	m := a.methods[c.method]
+	if m == nil {
+		m = &MethodCost{Method: c.method, Downstream: make(map[string]uint64)}
+		a.methods[c.method] = m
+	}
+	m.Calls++
+	m.Total = m.Total.add(c.usage)
+	c.mu.Lock()
+	for d, n := range c.downstream {
+		m.Downstream[d] += n
+		downstreamTotal.WithLabelValues(c.method, d).Add(float64(n))
+	}
+	c.mu.Unlock()
+	a.mu.Unlock()
+
+	callsTotal.WithLabelValues(c.method).Inc()
+	cpuTotal.WithLabelValues(c.method).Add(c.usage.CPUSeconds)
+	allocBytesTotal.WithLabelValues(c.method).Add(c.usage.AllocBytes)
+	allocsTotal.WithLabelValues(c.method).Add(c.usage.Allocs)
+}
+
+// countDownstream counts a call to downstream against the call in ctx, if
+// any.
+func countDownstream(ctx context.Context, downstream string) {
+	c, ok := ctx.Value(callKey{}).(*call)
+	if !ok {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.downstream == nil {
+		c.downstream = make(map[string]uint64)
+	}
+	c.downstream[downstream]++
+}
+
+// Report returns what each method has cost so far. Calls still in flight
+// are not included.
+func (a *Accountant) Report() Report {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.sample()
+	r := Report{Since: a.since, Unattributed: a.unattributed, Methods: make([]MethodCost, 0, len(a.methods))}
+	for _, m := range a.methods {
+		mc := *m
+		mc.PerCall = m.Total.div(float64(m.Calls))
+		mc.Downstream = make(map[string]uint64, len(m.Downstream))
+		for d, n := range m.Downstream {
+			mc.Downstream[d] = n
+		}
+		r.Methods = append(r.Methods, mc)
+	}
+	slices.SortFunc(r.Methods, func(x, y MethodCost) int {
+		if c := cmp.Compare(y.Total.CPUSeconds, x.Total.CPUSeconds); c != 0 {
+			return c
+		}
+		return cmp.Compare(x.Method, y.Method)
+	})
+	return r
+}
+
+// Reset forgets the costs reported so far, e.g. before a load test. The
+// Prometheus counters are not reset.
+func (a *Accountant) Reset() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.sample()
+	a.methods = make(map[string]*MethodCost)
+	a.unattributed = Usage{}
+	a.since = time.Now()
+}
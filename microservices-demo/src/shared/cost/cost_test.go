@@ -0,0 +1,157 @@
+package cost
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+)
+
+var sink []byte
+
+// serve runs handler as a unary call of method.
+func serve(a *Accountant, method string, handler grpc.UnaryHandler) {
+	a.UnaryServerInterceptor()(context.Background(), nil, &grpc.UnaryServerInfo{FullMethod: method}, handler)
+}
+
+func find(t *testing.T, r Report, method string) MethodCost {
+	t.Helper()
+	for _, m := range r.Methods {
+		if m.Method == method {
+			return m
+		}
+	}
+	t.Fatalf("report has no %s: %+v", method, r)
+	return MethodCost{}
+}
+
+func TestAttribution(t *testing.T) {
+	a := New()
+	for range 3 {
+		serve(a, "/shop.Catalog/Search", func(context.Context, any) (any, error) {
+			sink = make([]byte, 1<<20)
+			end := time.Now().Add(20 * time.Millisecond)
+			for time.Now().Before(end) {
+			}
+			// The runtime's CPU estimate only advances at a collection.
+			runtime.GC()
+			return nil, nil
+		})
+		serve(a, "/shop.Catalog/GetProduct", func(context.Context, any) (any, error) { return nil, nil })
+	}
+	r := a.Report()
+	heavy, light := find(t, r, "/shop.Catalog/Search"), find(t, r, "/shop.Catalog/GetProduct")
+	if heavy.Calls != 3 || light.Calls != 3 {
+		t.Errorf("calls = %d, %d; want 3, 3", heavy.Calls, light.Calls)
+	}
+	if heavy.PerCall.AllocBytes < 1<<20 || light.PerCall.AllocBytes >= 1<<20 {
+		t.Errorf("bytes per call = %v, %v; want Search above 1MiB and GetProduct below", heavy.PerCall.AllocBytes, light.PerCall.AllocBytes)
+	}
+	if heavy.Total.CPUSeconds <= 0 || heavy.Total.CPUSeconds < light.Total.CPUSeconds {
+		t.Errorf("CPU = %v, %v; want Search above GetProduct", heavy.Total.CPUSeconds, light.Total.CPUSeconds)
+	}
+	if r.Methods[0].Method != "/shop.Catalog/Search" {
+		t.Errorf("most expensive method = %s, want Search", r.Methods[0].Method)
+	}
+}
+
+func TestDownstreamCounts(t *testing.T) {
+	a := New()
+	unary := UnaryClientInterceptor()
+	invoke := func(context.Context, string, any, any, *grpc.ClientConn, ...grpc.CallOption) error { return nil }
+	client := &http.Client{Transport: Transport(roundTripFunc(func(*http.Request) (*http.Response, error) {
+		return &http.Response{StatusCode: http.StatusOK, Body: http.NoBody}, nil
+	}))}
+	serve(a, "/shop.Checkout/PlaceOrder", func(ctx context.Context, _ any) (any, error) {
+		for range 3 {
+			unary(ctx, "/shop.Cart/GetCart", nil, nil, nil, invoke)
+		}
+		unary(ctx, "/shop.Payment/Charge", nil, nil, nil, invoke)
+		req, _ := http.NewRequestWithContext(ctx, http.MethodPost, "http://email:8080/send", nil)
+		if resp, err := client.Do(req); err == nil {
+			resp.Body.Close()
+		}
+		return nil, nil
+	})
+	// Outside any accounted call nothing is counted.
+	unary(context.Background(), "/shop.Cart/GetCart", nil, nil, nil, invoke)
+
+	got := find(t, a.Report(), "/shop.Checkout/PlaceOrder").Downstream
+	want := map[string]uint64{"/shop.Cart/GetCart": 3, "/shop.Payment/Charge": 1, "POST email:8080": 1}
+	if len(got) != len(want) {
+		t.Errorf("downstream = %v, want %v", got, want)
+	}
+	for k, n := range want {
+		if got[k] != n {
+			t.Errorf("downstream[%q] = %d, want %d", k, got[k], n)
+		}
+	}
+}
+
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(r *http.Request) (*http.Response, error) { return f(r) }
+
+func TestConcurrentCalls(t *testing.T) {
+	a := New()
+	var wg sync.WaitGroup
+	for i := range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			method := "/shop.A/Even"
+			if i%2 == 1 {
+				method = "/shop.A/Odd"
+			}
+			for range 50 {
+				serve(a, method, func(context.Context, any) (any, error) {
+					return make([]byte, 4096), nil
+				})
+			}
+		}()
+	}
+	wg.Wait()
+	r := a.Report()
+	if len(r.Methods) != 2 {
+		t.Fatalf("methods = %+v", r.Methods)
+	}
+	for _, m := range r.Methods {
+		if m.Calls != 200 {
+			t.Errorf("%s: %d calls, want 200", m.Method, m.Calls)
+		}
+	}
+}
+
+func TestHandler(t *testing.T) {
+	a := New()
+	serve(a, "/shop.Cart/GetCart", func(context.Context, any) (any, error) { return nil, nil })
+	h := a.Handler()
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/admin/cost", nil))
+	var r Report
+	if err := json.Unmarshal(rec.Body.Bytes(), &r); err != nil {
+		t.Fatal(err)
+	}
+	if len(r.Methods) != 1 || r.Methods[0].Method != "/shop.Cart/GetCart" || r.Methods[0].Calls != 1 {
+		t.Errorf("GET = %s", rec.Body)
+	}
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodDelete, "/admin/cost", nil))
+	if len(a.Report().Methods) != 0 {
+		t.Errorf("DELETE did not reset: %s", rec.Body)
+	}
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/admin/cost", nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("POST = %d, want 405", rec.Code)
+	}
+}
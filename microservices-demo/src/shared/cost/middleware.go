@@ -0,0 +1,91 @@
+package cost
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+
+	"google.golang.org/grpc"
+)
+
+// UnaryServerInterceptor accounts each call to its full method name.
+func (a *Accountant) UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		ctx, c := a.start(ctx, info.FullMethod)
+		defer a.end(c)
+		return handler(ctx, req)
+	}
+}
+
+// StreamServerInterceptor accounts each stream, for its whole life, to its
+// full method name.
+func (a *Accountant) StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx, c := a.start(ss.Context(), info.FullMethod)
+		defer a.end(c)
+		return handler(srv, &serverStream{ServerStream: ss, ctx: ctx})
+	}
+}
+
+// serverStream overrides the context of a stream.
+type serverStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *serverStream) Context() context.Context { return s.ctx }
+
+// UnaryClientInterceptor counts each outgoing call, by full method name,
+// against the call being served in its context, whichever Accountant
+// tracks it. Calls made outside an accounted call are not counted.
+func UnaryClientInterceptor() grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		countDownstream(ctx, method)
+		return invoker(ctx, method, req, reply, cc, opts...)
+	}
+}
+
+// StreamClientInterceptor is UnaryClientInterceptor for streams.
+func StreamClientInterceptor() grpc.StreamClientInterceptor {
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		countDownstream(ctx, method)
+		return streamer(ctx, desc, cc, method, opts...)
+	}
+}
+
+// Transport returns an http.RoundTripper counting each outgoing request,
+// as "<METHOD> <host>", against the call being served in its context. A
+// nil base uses http.DefaultTransport.
+func Transport(base http.RoundTripper) http.RoundTripper {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return roundTripper{base}
+}
+
+type roundTripper struct{ base http.RoundTripper }
+
+func (t roundTripper) RoundTrip(r *http.Request) (*http.Response, error) {
+	countDownstream(r.Context(), r.Method+" "+r.URL.Host)
+	return t.base.RoundTrip(r)
+}
+
+// Handler serves the report:
+//
+//	GET    returns the Report as JSON
+//	DELETE resets it, e.g. before a load test
+func (a *Accountant) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+		case http.MethodDelete:
+			a.Reset()
+		default:
+			w.Header().Set("Allow", "GET, DELETE")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(a.Report())
+	})
+}
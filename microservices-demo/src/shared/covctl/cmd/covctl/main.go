@@ -0,0 +1,85 @@
+// Command covctl dumps the coverage counters of every pod matching a label
+// selector and copies them to a local directory.
+//
+//	covctl -n boutique -l app=shippingservice -c server -o coverage/raw
+//	covctl -l 'app in (checkoutservice,shippingservice)' -mode http -exec -label e2e -o coverage/raw
+//	covctl -l app=checkoutservice -mode grpc -port 5050 -clear -o coverage/raw
+//
+// It prints one line per pod and, on success, the go tool covdata command
+// that merges the results. The exit status is 1 if any pod failed.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"time"
+
+	"github.com/GoogleCloudPlatform/microservices-demo/src/shared/covctl"
+)
+
+func main() {
+	var (
+		namespace  = flag.String("n", "", "namespace (default: the kubectl context's)")
+		selector   = flag.String("l", "", "label selector (required)")
+		container  = flag.String("c", "", "container name")
+		out        = flag.String("o", "coverage", "local output directory")
+		mode       = flag.String("mode", "signal", "how to trigger dumps: signal, http or grpc")
+		label      = flag.String("label", "", "dump label (http and grpc modes)")
+		sig        = flag.String("signal", covctl.DefaultSignal, "signal to send (signal mode)")
+		coverDir   = flag.String("coverdir", covctl.DefaultCoverDir, "GOCOVERDIR inside the pods (signal mode)")
+		port       = flag.Int("port", 0, "admin port (http mode, default 8090) or gRPC port (grpc mode)")
+		viaExec    = flag.Bool("exec", false, "send the HTTP request from inside the pod (http mode)")
+		clear      = flag.Bool("clear", false, "reset counters after dumping (grpc mode)")
+		parallel   = flag.Int("parallel", 8, "pods processed at once")
+		timeout    = flag.Duration("timeout", 2*time.Minute, "per-pod timeout")
+		kubeCtx    = flag.String("context", "", "kubectl context")
+		kubeconfig = flag.String("kubeconfig", "", "kubeconfig file")
+	)
+	flag.Parse()
+
+	var trigger covctl.Trigger
+	switch *mode {
+	case "signal":
+		trigger = covctl.SignalTrigger{Signal: *sig, CoverDir: *coverDir}
+	case "http":
+		trigger = covctl.HTTPTrigger{Port: *port, Token: os.Getenv("ADMIN_TOKEN"), ViaExec: *viaExec}
+	case "grpc":
+		trigger = covctl.GRPCTrigger{Port: *port, Clear: *clear}
+	default:
+		fmt.Fprintf(os.Stderr, "covctl: unknown mode %q\n", *mode)
+		os.Exit(2)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	results, err := covctl.Collect(ctx, covctl.Config{
+		Namespace:   *namespace,
+		Selector:    *selector,
+		Container:   *container,
+		Trigger:     trigger,
+		Label:       *label,
+		OutDir:      *out,
+		Cluster:     covctl.Kubectl{Context: *kubeCtx, Kubeconfig: *kubeconfig},
+		Parallelism: *parallel,
+		PodTimeout:  *timeout,
+	})
+	for _, r := range results {
+		if r.Err != nil {
+			fmt.Printf("FAIL %s: %v\n", r.Pod, r.Err)
+			continue
+		}
+		fmt.Printf("ok   %s: %d files from %s in %v\n", r.Pod, r.Files, r.RemoteDir, r.Duration.Round(time.Millisecond))
+	}
+	if in := covctl.MergeInputs(results); in != "" {
+		fmt.Printf("\nmerge with:\n  go tool covdata merge -i=%s -o %s/merged\n", in, *out)
+	}
+	if err != nil {
+		if len(results) == 0 {
+			fmt.Fprintln(os.Stderr, err)
+		}
+		os.Exit(1)
+	}
+}
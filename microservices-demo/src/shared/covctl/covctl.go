@@ -0,0 +1,202 @@
+// Package covctl collects Go coverage data from a fleet of pods: it finds
+// every pod matching a label selector, makes each one dump its counters,
+// waits for the dump to finish and copies the counter files to a local
+// directory, ready for go tool covdata. It replaces the kubectl loops test
+// harnesses otherwise script by hand.
+//
+// Library usage:
+//
+//	results, err := covctl.Collect(ctx, covctl.Config{
+//	    Namespace: "boutique",
+//	    Selector:  "app in (checkoutservice,shippingservice)",
+//	    Container: "server",
+//	    Trigger:   covctl.HTTPTrigger{ViaExec: true},
+//	    Label:     "checkout-e2e",
+//	    OutDir:    "coverage/raw",
+//	})
+//	// go tool covdata merge -i=<covctl.MergeInputs(results)> -o coverage/merged
+//
+// The covctl command in cmd/covctl wraps Collect for shell use.
+//
+// Pods are processed in parallel. A pod that fails does not stop the
+// others; Collect reports every failure in its error and in the results.
+package covctl
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Config describes one collection.
+type Config struct {
+	// Namespace holds the pods; empty uses the kubectl context's namespace.
+	Namespace string
+	// Selector is a Kubernetes label selector, e.g. "app=checkoutservice".
+	Selector string
+	// Container names the service container in multi-container pods.
+	Container string
+	// Trigger makes each pod dump; nil uses SignalTrigger{}.
+	Trigger Trigger
+	// Label names the dump, so it lands in its own subdirectory of
+	// GOCOVERDIR and only it is copied. Not supported by SignalTrigger.
+	Label string
+	// OutDir receives one subdirectory per pod, named after it.
+	OutDir string
+	// Cluster defaults to Kubectl{}.
+	Cluster Cluster
+	// Parallelism bounds how many pods are processed at once (default 8).
+	Parallelism int
+	// PodTimeout bounds the dump and copy of one pod (default 2m).
+	PodTimeout time.Duration
+}
+
+// PodResult is the outcome for one pod.
+type PodResult struct {
+	Pod Pod
+	// RemoteDir is the directory the dump was written to in the pod.
+	RemoteDir string
+	// LocalDir is where its files were copied.
+	LocalDir string
+	// Files is the number of coverage files copied.
+	Files    int
+	Duration time.Duration
+	Err      error
+}
+
+// Collect dumps and copies the coverage of every pod cfg selects. It
+// returns the per-pod results, sorted by pod, and an error joining the
+// failures. Selecting no pods is an error, since it usually means a wrong
+// selector or namespace.
+func Collect(ctx context.Context, cfg Config) ([]PodResult, error) {
+	if cfg.Selector == "" {
+		return nil, errors.New("covctl: a label selector is required")
+	}
+	if cfg.OutDir == "" {
+		return nil, errors.New("covctl: an output directory is required")
+	}
+	if cfg.Cluster == nil {
+		cfg.Cluster = Kubectl{}
+	}
+	if cfg.Trigger == nil {
+		cfg.Trigger = SignalTrigger{}
+	}
+	if cfg.Parallelism <= 0 {
+		cfg.Parallelism = 8
+	}
+	if cfg.PodTimeout <= 0 {
+		cfg.PodTimeout = 2 * time.Minute
+	}
+
+	pods, err := cfg.Cluster.Pods(ctx, cfg.Namespace, cfg.Selector)
+	if err != nil {
+		return nil, fmt.Errorf("covctl: listing pods: %w", err)
+	}
+	if len(pods) == 0 {
+		return nil, fmt.Errorf("covctl: no running pods match %q", cfg.Selector)
+	}
+	if err := os.MkdirAll(cfg.OutDir, 0o755); err != nil {
+		return nil, fmt.Errorf("covctl: %w", err)
+	}
+
+	results := make([]PodResult, len(pods))
+	sem := make(chan struct{}, cfg.Parallelism)
+	var wg sync.WaitGroup
+	for i, pod := range pods {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			results[i] = collectPod(ctx, cfg, pod)
+		}()
+	}
+	wg.Wait()
+
+	sort.Slice(results, func(i, j int) bool { return results[i].Pod.String() < results[j].Pod.String() })
+	var errs []error
+	for _, r := range results {
+		if r.Err != nil {
+			errs = append(errs, fmt.Errorf("covctl: pod %s: %w", r.Pod, r.Err))
+		}
+	}
+	return results, errors.Join(errs...)
+}
+
+func collectPod(ctx context.Context, cfg Config, pod Pod) PodResult {
+	ctx, cancel := context.WithTimeout(ctx, cfg.PodTimeout)
+	defer cancel()
+	r := PodResult{Pod: pod, LocalDir: filepath.Join(cfg.OutDir, pod.Name)}
+	start := time.Now()
+	defer func() { r.Duration = time.Since(start) }()
+
+	r.RemoteDir, r.Err = cfg.Trigger.Dump(ctx, cfg.Cluster, pod, cfg.Container, cfg.Label)
+	if r.Err != nil {
+		r.Err = fmt.Errorf("dump: %w", r.Err)
+		return r
+	}
+	// kubectl cp merges into an existing directory; start clean so files
+	// from an earlier run are not mistaken for this one's.
+	if err := os.RemoveAll(r.LocalDir); err != nil {
+		r.Err = err
+		return r
+	}
+	if err := cfg.Cluster.Copy(ctx, pod, cfg.Container, r.RemoteDir, r.LocalDir); err != nil {
+		r.Err = fmt.Errorf("copy %s: %w", r.RemoteDir, err)
+		return r
+	}
+	r.Files, r.Err = countCoverageFiles(r.LocalDir)
+	if r.Err == nil && r.Files == 0 {
+		r.Err = fmt.Errorf("no coverage files in %s", r.RemoteDir)
+	}
+	slog.InfoContext(ctx, "covctl: collected coverage", "pod", pod.String(), "dir", r.RemoteDir, "files", r.Files)
+	return r
+}
+
+// isCoverageFile matches the files the runtime writes to GOCOVERDIR.
+func isCoverageFile(name string) bool {
+	return strings.HasPrefix(name, "covmeta.") || strings.HasPrefix(name, "covcounters.")
+}
+
+func countCoverageFiles(dir string) (int, error) {
+	n := 0
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() && isCoverageFile(d.Name()) {
+			n++
+		}
+		return nil
+	})
+	return n, err
+}
+
+// MergeInputs returns the comma-separated -i argument of
+// "go tool covdata merge" for the successful results: every directory
+// below their LocalDirs that holds a covmeta file.
+func MergeInputs(results []PodResult) string {
+	var dirs []string
+	for _, r := range results {
+		if r.Err != nil {
+			continue
+		}
+		filepath.WalkDir(r.LocalDir, func(path string, d fs.DirEntry, err error) error {
+			if err == nil && d.IsDir() {
+				if m, _ := filepath.Glob(filepath.Join(path, "covmeta.*")); len(m) > 0 {
+					dirs = append(dirs, path)
+				}
+			}
+			return nil
+		})
+	}
+	return strings.Join(dirs, ",")
+}
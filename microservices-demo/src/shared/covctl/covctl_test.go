@@ -0,0 +1,162 @@
+package covctl
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"google.golang.org/grpc"
+
+	pb "github.com/GoogleCloudPlatform/microservices-demo/src/shared/genproto"
+)
+
+// fakeCluster maps each pod's filesystem to a local directory. kill writes
+// a counter file into the cover dir, like the coverage signal handler.
+type fakeCluster struct {
+	root string
+	pods []Pod
+
+	mu    sync.Mutex
+	execs []string
+}
+
+func newFakeCluster(t *testing.T, pods ...Pod) *fakeCluster {
+	c := &fakeCluster{root: t.TempDir(), pods: pods}
+	for _, p := range pods {
+		writeCoverage(t, c.path(p, DefaultCoverDir))
+	}
+	return c
+}
+
+func (c *fakeCluster) path(p Pod, dir string) string {
+	return filepath.Join(c.root, p.Name, dir)
+}
+
+func writeCoverage(t *testing.T, dir string) {
+	t.Helper()
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"covmeta.abc", "covcounters.abc.1"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte("x"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func (c *fakeCluster) Pods(_ context.Context, namespace, selector string) ([]Pod, error) {
+	return c.pods, nil
+}
+
+func (c *fakeCluster) Exec(_ context.Context, p Pod, container string, cmd ...string) ([]byte, error) {
+	c.mu.Lock()
+	c.execs = append(c.execs, p.Name+": "+strings.Join(cmd, " "))
+	c.mu.Unlock()
+	switch cmd[0] {
+	case "find":
+		matches, _ := filepath.Glob(filepath.Join(c.path(p, cmd[1]), "covcounters.*"))
+		return []byte(strings.Join(matches, "\n")), nil
+	case "kill":
+		dir := c.path(p, DefaultCoverDir)
+		return nil, os.WriteFile(filepath.Join(dir, "covcounters.abc.2"), []byte("x"), 0o644)
+	}
+	return nil, fmt.Errorf("unexpected command %q", cmd)
+}
+
+func (c *fakeCluster) Copy(_ context.Context, p Pod, container, src, dst string) error {
+	return os.CopyFS(dst, os.DirFS(c.path(p, src)))
+}
+
+func TestCollectWithSignal(t *testing.T) {
+	pods := []Pod{{Namespace: "demo", Name: "shipping-b"}, {Namespace: "demo", Name: "shipping-a"}}
+	cluster := newFakeCluster(t, pods...)
+	out := t.TempDir()
+
+	results, err := Collect(context.Background(), Config{Selector: "app=shipping", OutDir: out, Cluster: cluster})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 2 || results[0].Pod.Name != "shipping-a" {
+		t.Fatalf("results = %+v, want both pods sorted", results)
+	}
+	for _, r := range results {
+		if r.Files != 3 || r.RemoteDir != DefaultCoverDir {
+			t.Errorf("%s: %d files from %s, want 3 from %s", r.Pod, r.Files, r.RemoteDir, DefaultCoverDir)
+		}
+	}
+	want := filepath.Join(out, "shipping-a") + "," + filepath.Join(out, "shipping-b")
+	if got := MergeInputs(results); got != want {
+		t.Errorf("MergeInputs = %q, want %q", got, want)
+	}
+}
+
+func TestCollectReportsPodFailures(t *testing.T) {
+	cluster := newFakeCluster(t, Pod{Namespace: "demo", Name: "p1"})
+	_, err := Collect(context.Background(), Config{
+		Selector: "app=x", OutDir: t.TempDir(), Cluster: cluster, Label: "run-1",
+	})
+	if err == nil || !strings.Contains(err.Error(), "pod demo/p1") {
+		t.Errorf("Collect = %v, want an error naming the pod", err)
+	}
+
+	_, err = Collect(context.Background(), Config{Selector: "app=x", OutDir: t.TempDir(), Cluster: &fakeCluster{}})
+	if err == nil || !strings.Contains(err.Error(), "no running pods") {
+		t.Errorf("Collect with no pods = %v", err)
+	}
+}
+
+func TestHTTPTrigger(t *testing.T) {
+	var gotLabel string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/coverage/dump" {
+			t.Errorf("got %s %s", r.Method, r.URL.Path)
+		}
+		gotLabel = r.Header.Get("X-Coverage-Label")
+		fmt.Fprintln(w, "coverage data written to /coverage-data/e2e")
+	}))
+	defer srv.Close()
+	host, port, _ := net.SplitHostPort(srv.Listener.Addr().String())
+	p, _ := strconv.Atoi(port)
+
+	dir, err := HTTPTrigger{Port: p}.Dump(context.Background(), nil, Pod{IP: host}, "", "e2e")
+	if err != nil || dir != "/coverage-data/e2e" || gotLabel != "e2e" {
+		t.Errorf("Dump = %q, %v with label %q; want /coverage-data/e2e", dir, err, gotLabel)
+	}
+}
+
+type coverageServer struct {
+	pb.UnimplementedCoverageControlServer
+}
+
+func (coverageServer) Dump(_ context.Context, req *pb.DumpRequest) (*pb.DumpResponse, error) {
+	if !req.GetClear() {
+		return nil, errors.New("expected clear")
+	}
+	return &pb.DumpResponse{Dir: "/coverage-data/" + req.GetLabel()}, nil
+}
+
+func TestGRPCTrigger(t *testing.T) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := grpc.NewServer()
+	pb.RegisterCoverageControlServer(srv, coverageServer{})
+	go srv.Serve(lis)
+	defer srv.Stop()
+
+	port := lis.Addr().(*net.TCPAddr).Port
+	dir, err := GRPCTrigger{Port: port, Clear: true}.Dump(context.Background(), nil, Pod{IP: "127.0.0.1"}, "", "e2e")
+	if err != nil || dir != "/coverage-data/e2e" {
+		t.Errorf("Dump = %q, %v; want /coverage-data/e2e", dir, err)
+	}
+}
@@ -0,0 +1,120 @@
+package covctl
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// Pod is a running pod matched by the selector.
+type Pod struct {
+	Namespace string
+	Name      string
+	IP        string
+}
+
+func (p Pod) String() string { return p.Namespace + "/" + p.Name }
+
+// Cluster is the Kubernetes access Collect needs. Kubectl implements it;
+// tests substitute a fake.
+type Cluster interface {
+	// Pods returns the running pods in namespace matching selector.
+	Pods(ctx context.Context, namespace, selector string) ([]Pod, error)
+	// Exec runs cmd in container (the default container if empty) and
+	// returns its standard output.
+	Exec(ctx context.Context, pod Pod, container string, cmd ...string) ([]byte, error)
+	// Copy copies the directory src in the pod to the local directory dst.
+	Copy(ctx context.Context, pod Pod, container, src, dst string) error
+}
+
+// Kubectl drives the kubectl binary, so covctl uses whatever credentials
+// and cluster the harness already has configured. Copy relies on tar in the
+// container, which the distroless debug images provide.
+type Kubectl struct {
+	// Path is the kubectl binary; empty means "kubectl" on $PATH.
+	Path string
+	// Context and Kubeconfig select the cluster; empty uses the defaults.
+	Context    string
+	Kubeconfig string
+}
+
+func (k Kubectl) run(ctx context.Context, args ...string) ([]byte, error) {
+	path := k.Path
+	if path == "" {
+		path = "kubectl"
+	}
+	var global []string
+	if k.Context != "" {
+		global = append(global, "--context", k.Context)
+	}
+	if k.Kubeconfig != "" {
+		global = append(global, "--kubeconfig", k.Kubeconfig)
+	}
+	cmd := exec.CommandContext(ctx, path, append(global, args...)...)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, fmt.Errorf("kubectl %s: %w: %s", args[0], err, msg)
+		}
+		return nil, fmt.Errorf("kubectl %s: %w", args[0], err)
+	}
+	return stdout.Bytes(), nil
+}
+
+// Pods lists pods with kubectl get, skipping those not in the Running phase.
+func (k Kubectl) Pods(ctx context.Context, namespace, selector string) ([]Pod, error) {
+	args := []string{"get", "pods", "-o", "json", "-l", selector}
+	if namespace != "" {
+		args = append(args, "-n", namespace)
+	}
+	out, err := k.run(ctx, args...)
+	if err != nil {
+		return nil, err
+	}
+	var list struct {
+		Items []struct {
+			Metadata struct {
+				Name      string `json:"name"`
+				Namespace string `json:"namespace"`
+			} `json:"metadata"`
+			Status struct {
+				Phase string `json:"phase"`
+				PodIP string `json:"podIP"`
+			} `json:"status"`
+		} `json:"items"`
+	}
+	if err := json.Unmarshal(out, &list); err != nil {
+		return nil, fmt.Errorf("kubectl get pods: %w", err)
+	}
+	var pods []Pod
+	for _, it := range list.Items {
+		if it.Status.Phase != "Running" {
+			continue
+		}
+		pods = append(pods, Pod{Namespace: it.Metadata.Namespace, Name: it.Metadata.Name, IP: it.Status.PodIP})
+	}
+	return pods, nil
+}
+
+// Exec runs kubectl exec.
+func (k Kubectl) Exec(ctx context.Context, pod Pod, container string, cmd ...string) ([]byte, error) {
+	args := []string{"exec", "-n", pod.Namespace, pod.Name}
+	if container != "" {
+		args = append(args, "-c", container)
+	}
+	return k.run(ctx, append(append(args, "--"), cmd...)...)
+}
+
+// Copy runs kubectl cp.
+func (k Kubectl) Copy(ctx context.Context, pod Pod, container, src, dst string) error {
+	args := []string{"cp", "-n", pod.Namespace}
+	if container != "" {
+		args = append(args, "-c", container)
+	}
+	_, err := k.run(ctx, append(args, pod.Name+":"+src, dst)...)
+	return err
+}
@@ -0,0 +1,209 @@
+package covctl
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+
+	pb "github.com/GoogleCloudPlatform/microservices-demo/src/shared/genproto"
+)
+
+// Defaults matching the manifests and shared.StartAdminServer.
+const (
+	DefaultCoverDir = "/coverage-data"
+	DefaultHTTPPort = 8090
+	DefaultSignal   = "USR1"
+)
+
+// Trigger makes one pod write its coverage counters.
+type Trigger interface {
+	// Dump returns once the counters are written, with the directory inside
+	// the pod that holds them.
+	Dump(ctx context.Context, c Cluster, pod Pod, container, label string) (string, error)
+}
+
+// SignalTrigger sends a signal through kubectl exec, like
+// "kubectl exec <pod> -- kill -USR1 1", for services using
+// shared.SetupCoverageSignalHandler. The handler dumps asynchronously, so
+// Dump polls CoverDir until a new counter file appears.
+//
+// Signals cannot carry a label; set COVERAGE_LABEL on the pods, or use
+// HTTPTrigger or GRPCTrigger, to separate runs.
+type SignalTrigger struct {
+	// Signal is the name passed to kill (default DefaultSignal).
+	Signal string
+	// PID is the process to signal (default 1, the container entrypoint).
+	PID int
+	// CoverDir is the pod's GOCOVERDIR (default DefaultCoverDir).
+	CoverDir string
+	// PollInterval spaces the checks for new counters (default 500ms).
+	PollInterval time.Duration
+}
+
+func (t SignalTrigger) Dump(ctx context.Context, c Cluster, pod Pod, container, label string) (string, error) {
+	if label != "" {
+		return "", fmt.Errorf("signal trigger cannot label dumps (label %q)", label)
+	}
+	sig, pid, dir, poll := t.Signal, t.PID, t.CoverDir, t.PollInterval
+	if sig == "" {
+		sig = DefaultSignal
+	}
+	if pid == 0 {
+		pid = 1
+	}
+	if dir == "" {
+		dir = DefaultCoverDir
+	}
+	if poll <= 0 {
+		poll = 500 * time.Millisecond
+	}
+
+	before, err := counterFiles(ctx, c, pod, container, dir)
+	if err != nil {
+		return "", err
+	}
+	if _, err := c.Exec(ctx, pod, container, "kill", "-"+strings.TrimPrefix(sig, "SIG"), strconv.Itoa(pid)); err != nil {
+		return "", err
+	}
+	ticker := time.NewTicker(poll)
+	defer ticker.Stop()
+	for {
+		n, err := counterFiles(ctx, c, pod, container, dir)
+		if err != nil {
+			return "", err
+		}
+		if n > before {
+			return dir, nil
+		}
+		select {
+		case <-ctx.Done():
+			return "", fmt.Errorf("waiting for counters in %s: %w", dir, ctx.Err())
+		case <-ticker.C:
+		}
+	}
+}
+
+// counterFiles counts the counter files under dir in the pod.
+func counterFiles(ctx context.Context, c Cluster, pod Pod, container, dir string) (int, error) {
+	out, err := c.Exec(ctx, pod, container, "find", dir, "-name", "covcounters.*")
+	if err != nil {
+		return 0, err
+	}
+	return len(strings.Fields(string(out))), nil
+}
+
+// HTTPTrigger calls POST /coverage/dump on the admin server (see
+// shared.CoverageHandler). By default it connects to the pod IP, which
+// requires running inside the cluster; with ViaExec it runs busybox wget in
+// the pod against localhost instead, which works from anywhere kubectl does.
+type HTTPTrigger struct {
+	// Port is the admin port (default DefaultHTTPPort).
+	Port int
+	// Token is sent as a bearer token when ADMIN_TOKEN protects the server.
+	Token string
+	// ViaExec sends the request from inside the pod.
+	ViaExec bool
+	// Client makes direct requests; nil uses a client with a 30s timeout.
+	Client *http.Client
+}
+
+func (t HTTPTrigger) Dump(ctx context.Context, c Cluster, pod Pod, container, label string) (string, error) {
+	port := t.Port
+	if port == 0 {
+		port = DefaultHTTPPort
+	}
+	var body []byte
+	if t.ViaExec {
+		cmd := []string{"wget", "-q", "-O", "-", "--post-data", ""}
+		if label != "" {
+			cmd = append(cmd, "--header", "X-Coverage-Label: "+label)
+		}
+		if t.Token != "" {
+			cmd = append(cmd, "--header", "Authorization: Bearer "+t.Token)
+		}
+		out, err := c.Exec(ctx, pod, container, append(cmd, fmt.Sprintf("http://127.0.0.1:%d/coverage/dump", port))...)
+		if err != nil {
+			return "", err
+		}
+		body = out
+	} else {
+		if pod.IP == "" {
+			return "", errors.New("pod has no IP")
+		}
+		url := "http://" + net.JoinHostPort(pod.IP, strconv.Itoa(port)) + "/coverage/dump"
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, nil)
+		if err != nil {
+			return "", err
+		}
+		if label != "" {
+			req.Header.Set("X-Coverage-Label", label)
+		}
+		if t.Token != "" {
+			req.Header.Set("Authorization", "Bearer "+t.Token)
+		}
+		client := t.Client
+		if client == nil {
+			client = &http.Client{Timeout: 30 * time.Second}
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			return "", err
+		}
+		defer resp.Body.Close()
+		body, _ = io.ReadAll(io.LimitReader(resp.Body, 4096))
+		if resp.StatusCode != http.StatusOK {
+			return "", fmt.Errorf("%s: %s", resp.Status, bytes.TrimSpace(body))
+		}
+	}
+	// The handler answers "coverage data written to <dir>".
+	dir, ok := strings.CutPrefix(strings.TrimSpace(string(body)), "coverage data written to ")
+	if !ok || dir == "" {
+		return "", fmt.Errorf("unexpected response %q", bytes.TrimSpace(body))
+	}
+	return dir, nil
+}
+
+// GRPCTrigger calls the CoverageControl service registered with
+// shared.RegisterCoverageControl, on the pod IP.
+type GRPCTrigger struct {
+	// Port is the service's gRPC port.
+	Port int
+	// Clear resets the counters after the dump, so the next collection only
+	// covers what ran in between.
+	Clear bool
+	// DialOptions default to plaintext.
+	DialOptions []grpc.DialOption
+}
+
+func (t GRPCTrigger) Dump(ctx context.Context, c Cluster, pod Pod, container, label string) (string, error) {
+	if pod.IP == "" {
+		return "", errors.New("pod has no IP")
+	}
+	if t.Port == 0 {
+		return "", errors.New("gRPC trigger needs a port")
+	}
+	opts := t.DialOptions
+	if len(opts) == 0 {
+		opts = []grpc.DialOption{grpc.WithTransportCredentials(insecure.NewCredentials())}
+	}
+	conn, err := grpc.NewClient(net.JoinHostPort(pod.IP, strconv.Itoa(t.Port)), opts...)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+	resp, err := pb.NewCoverageControlClient(conn).Dump(ctx, &pb.DumpRequest{Label: label, Clear: t.Clear})
+	if err != nil {
+		return "", err
+	}
+	return resp.GetDir(), nil
+}
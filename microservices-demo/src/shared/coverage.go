@@ -2,11 +2,15 @@
 package shared
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"log"
 	"os"
-	"os/signal"
+	"path/filepath"
 	"runtime/coverage"
 	"syscall"
+	"time"
 )
 
 // SetupCoverageSignalHandler enables on-demand coverage dumping via SIGUSR1 signal.
@@ -15,6 +19,9 @@ import (
 // When GOCOVERDIR environment variable is set, this function registers a signal handler
 // that listens for SIGUSR1. On receiving the signal, it writes coverage data to the
 // directory specified by GOCOVERDIR and clears the counters for the next collection.
+// Signals arriving in quick succession are coalesced (see WithMinDumpInterval),
+// and NextCoverageDump lets a caller wait for the resulting dump.
+// The signals are also forwarded to children started with ExecWithCoverage.
 //
 // This is particularly useful for integration testing scenarios where:
 //   - Services need to keep running after tests complete
@@ -34,40 +41,249 @@ import (
 //
 // Note: This function is a no-op if GOCOVERDIR is not set, allowing the same
 // binary to run with or without coverage collection based on environment config.
-func SetupCoverageSignalHandler() {
+//
+// Behaviour can be extended with options, for example:
+//
+//	shared.SetupCoverageSignalHandler(shared.WithDumpOnShutdown())
+func SetupCoverageSignalHandler(opts ...CoverageOption) {
 	coverDir, exists := os.LookupEnv("GOCOVERDIR")
 	if !exists {
 		// Coverage not enabled, skip handler setup
 		return
 	}
 
-	// Create signal channel for SIGUSR1
+	cfg := coverageConfig{minDumpInterval: DefaultCoverageMinDumpInterval}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	if len(cfg.signals) == 0 {
+		cfg.signals = []coverageSignal{{sig: syscall.SIGUSR1, clear: true}}
+	}
+
+	// Create signal channel for the configured dump signals
 	c := make(chan os.Signal, 1)
-	signal.Notify(c, syscall.SIGUSR1)
+	clearOn := make(map[os.Signal]bool, len(cfg.signals))
+	for _, s := range cfg.signals {
+		DefaultSignalRouter().Notify("coverage", c, s.sig)
+		clearOn[s.sig] = s.clear
+	}
 
 	// Start goroutine to handle coverage dump signals
-	go func() {
-		for {
-			<-c
-			log.Println("Coverage: Received SIGUSR1 signal, dumping coverage data...")
-
-			// Write coverage counters to GOCOVERDIR
-			if err := coverage.WriteCountersDir(coverDir); err != nil {
-				log.Printf("Coverage: Error writing coverage data: %v", err)
-			} else {
-				log.Println("Coverage: Successfully wrote coverage data")
-			}
+	go handleCoverageSignals(c, coverDir, clearOn, cfg)
+
+	for _, fn := range cfg.callbacks {
+		registerCoverageCallback(fn)
+	}
+	if cfg.dumpOnShutdown {
+		setupCoverageShutdownHandler(coverDir)
+	}
+	recordCoverageSignalHandler()
 
-			// Clear counters for next collection period
-			// This allows tracking coverage for each test run separately
-			if err := coverage.ClearCounters(); err != nil {
-				log.Printf("Coverage: Error clearing counters: %v", err)
-			} else {
-				log.Println("Coverage: Counters cleared for next collection")
+	log.Printf("Coverage: Signal handler registered (GOCOVERDIR=%s)", coverDir)
+	for _, s := range cfg.signals {
+		if s.clear {
+			log.Printf("Coverage: Send %v to dump and clear coverage without stopping the service", s.sig)
+		} else {
+			log.Printf("Coverage: Send %v to dump coverage without clearing counters", s.sig)
+		}
+	}
+}
+
+// CoverageOption configures SetupCoverageSignalHandler and the other
+// coverage entry points that accept options.
+type CoverageOption func(*coverageConfig)
+
+type coverageConfig struct {
+	signals           []coverageSignal
+	dumpOnShutdown    bool
+	dumpOnLabelChange bool
+	minDumpInterval   time.Duration
+	callbacks         []func(CoverageDumpResult)
+	clock             Clock
+}
+
+// coverageSignal binds a signal to a dump, optionally followed by a clear.
+type coverageSignal struct {
+	sig   os.Signal
+	clear bool
+}
+
+// WithDumpSignal registers sig as a trigger that dumps coverage and then clears
+// the counters, which is the behaviour SIGUSR1 has by default. Use it when
+// SIGUSR1 is already claimed by another library:
+//
+//	shared.SetupCoverageSignalHandler(shared.WithDumpSignal(syscall.SIGUSR2))
+//
+// Passing any signal option replaces the SIGUSR1 default; it can be called
+// multiple times to register several signals.
+func WithDumpSignal(sig os.Signal) CoverageOption {
+	return func(c *coverageConfig) {
+		c.signals = append(c.signals, coverageSignal{sig: sig, clear: true})
+	}
+}
+
+// WithDumpOnlySignal registers sig as a trigger that dumps coverage but leaves
+// the counters untouched, so successive dumps are cumulative. It can be
+// combined with WithDumpSignal to give two signals different semantics.
+func WithDumpOnlySignal(sig os.Signal) CoverageOption {
+	return func(c *coverageConfig) {
+		c.signals = append(c.signals, coverageSignal{sig: sig, clear: false})
+	}
+}
+
+// WithDumpOnShutdown makes the handler also trap SIGTERM and SIGINT. On either
+// signal the coverage counters are written to GOCOVERDIR, then the default
+// signal behaviour is restored and the signal is re-raised so the process
+// terminates exactly as it would have without the handler. This guarantees the
+// last test run's coverage survives a pod being stopped.
+//
+// If the service uses a ShutdownManager, the manager takes over: it dumps
+// coverage after all of its hooks have run and this option becomes a no-op.
+func WithDumpOnShutdown() CoverageOption {
+	return func(c *coverageConfig) {
+		c.dumpOnShutdown = true
+	}
+}
+
+// handleCoverageSignals dumps coverage for each signal received on c, at
+// most once per cfg.minDumpInterval. Dumps are serialized anyway; the
+// interval keeps a burst of signals from producing a burst of dumps, without
+// dropping any: the signals arriving while one is held back are folded into
+// it.
+func handleCoverageSignals(c <-chan os.Signal, coverDir string, clearOn map[os.Signal]bool, cfg coverageConfig) {
+	clock := clockOrReal(cfg.clock)
+	var last time.Time
+	for sig := range c {
+		forwardCoverageSignal(sig)
+		clear := clearOn[sig]
+		if !last.IsZero() {
+			if wait := cfg.minDumpInterval - clock.Now().Sub(last); wait > 0 {
+				log.Printf("Coverage: Received %v signal within %v of the last dump, dumping in %v", sig, cfg.minDumpInterval, wait)
+				clock.Sleep(context.Background(), wait)
+			}
+		}
+		// Fold in the signals that arrived while waiting.
+		for pending := true; pending; {
+			select {
+			case s := <-c:
+				forwardCoverageSignal(s)
+				clear = clear || clearOn[s]
+			default:
+				pending = false
 			}
 		}
+
+		log.Printf("Coverage: Received %v signal, dumping coverage data...", sig)
+		res := dumpCoverageLabeled("signal", coverDir, "", clear)
+		last = clock.Now()
+		switch {
+		case res.Err != nil:
+			log.Printf("Coverage: Error writing coverage data: %v", res.Err)
+		case res.Cleared:
+			log.Printf("Coverage: Successfully wrote coverage data to %s, counters cleared for next collection", res.Dir)
+		default:
+			log.Printf("Coverage: Successfully wrote coverage data to %s", res.Dir)
+		}
+	}
+}
+
+// setupCoverageShutdownHandler dumps coverage once on SIGTERM/SIGINT and then
+// re-raises the signal with the default disposition.
+func setupCoverageShutdownHandler(coverDir string) {
+	c := make(chan os.Signal, 1)
+	DefaultSignalRouter().Notify("coverage-shutdown", c, syscall.SIGTERM, syscall.SIGINT)
+
+	go func() {
+		sig := <-c
+		if shutdownManagerActive.Load() {
+			// A ShutdownManager owns termination and dumps coverage as its
+			// final hook; re-raising here would cut its hooks short.
+			DefaultSignalRouter().Stop(c)
+			return
+		}
+		log.Printf("Coverage: Received %v signal, dumping coverage data before exit...", sig)
+		if res := dumpCoverageLabeled("shutdown", coverDir, "", false); res.Err != nil {
+			log.Printf("Coverage: Error writing coverage data: %v", res.Err)
+		} else {
+			log.Printf("Coverage: Successfully wrote coverage data to %s", res.Dir)
+		}
+
+		// Restore default handling and deliver the signal again so the
+		// process exits with the usual status for that signal.
+		DefaultSignalRouter().Reset(sig)
+		if err := syscall.Kill(os.Getpid(), sig.(syscall.Signal)); err != nil {
+			log.Printf("Coverage: Error re-raising %v: %v", sig, err)
+			os.Exit(1)
+		}
 	}()
+}
 
-	log.Printf("Coverage: Signal handler registered (GOCOVERDIR=%s)", coverDir)
-	log.Println("Coverage: Send SIGUSR1 to dump coverage without stopping the service")
+// ErrCoverageDisabled is returned when a coverage operation is requested but
+// no output directory is available (GOCOVERDIR unset and no dir given).
+var ErrCoverageDisabled = errors.New("coverage: GOCOVERDIR is not set")
+
+// DumpCoverage writes the current coverage counters to dir. If dir is empty,
+// the directory named by GOCOVERDIR is used. The meta-data file is written too
+// if dir does not have one yet, so the result can be fed straight to
+// `go tool covdata`.
+//
+// Use it to snapshot coverage at application-defined checkpoints, such as the
+// end of a test scenario or just before shutdown:
+//
+//	if err := shared.DumpCoverage(""); err != nil {
+//	    log.Printf("coverage dump failed: %v", err)
+//	}
+//
+// The binary must be built with -cover, otherwise an error is returned. It is
+// safe to call concurrently with the other coverage functions. Like every
+// other dump it is uploaded to the configured CoverageStorage and reported to
+// NextCoverageDump and WithDumpCallback, with trigger "api".
+func DumpCoverage(dir string) error {
+	if dir == "" {
+		dir = os.Getenv("GOCOVERDIR")
+	}
+	if dir == "" {
+		return ErrCoverageDisabled
+	}
+	return dumpCoverageTo("api", dir, false).Err
+}
+
+// writeCoverage writes meta-data (if missing) and counters to dir.
+func writeCoverage(dir string) error {
+	// go tool covdata refuses directories without meta-data, which the runtime
+	// only writes on clean exit. Write it alongside the first counters in each
+	// directory so every dump is usable on its own.
+	if !hasCoverageMeta(dir) {
+		if err := coverage.WriteMetaDir(dir); err != nil {
+			return fmt.Errorf("coverage: writing meta-data to %s: %w", dir, err)
+		}
+	}
+	if err := coverage.WriteCountersDir(dir); err != nil {
+		return fmt.Errorf("coverage: writing counters to %s: %w", dir, err)
+	}
+	return nil
+}
+
+// hasCoverageMeta reports whether dir already contains a covmeta file.
+func hasCoverageMeta(dir string) bool {
+	matches, _ := filepath.Glob(filepath.Join(dir, "covmeta.*"))
+	return len(matches) > 0
+}
+
+// ClearCoverage resets all coverage counters to zero so that the next dump
+// only reflects code executed from this point on.
+//
+// The binary must be built with -cover, otherwise an error is returned.
+func ClearCoverage() error {
+	coverageMu.Lock()
+	defer coverageMu.Unlock()
+	return clearCoverage()
+}
+
+// clearCoverage is ClearCoverage for callers holding coverageMu.
+func clearCoverage() error {
+	if err := coverage.ClearCounters(); err != nil {
+		return fmt.Errorf("coverage: clearing counters: %w", err)
+	}
+	return nil
 }
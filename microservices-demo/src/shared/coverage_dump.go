@@ -0,0 +1,138 @@
+package shared
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultCoverageMinDumpInterval is the shortest gap the signal handler
+// leaves between two dumps unless WithMinDumpInterval says otherwise.
+const DefaultCoverageMinDumpInterval = time.Second
+
+// coverageMu serializes writing and clearing the counters. The runtime
+// offers no guarantee for WriteCountersDir and ClearCounters running
+// concurrently, and a clear landing between the writes of two triggers
+// would lose counts.
+var (
+	coverageMu      sync.Mutex
+	coverageDumping atomic.Bool
+)
+
+// CoverageDumpResult describes a finished dump, whatever triggered it.
+type CoverageDumpResult struct {
+	// Trigger is what asked for the dump: "signal", "shutdown", "http",
+	// "grpc", "periodic", "label" or "api" (DumpCoverage).
+	Trigger string
+	Dir     string
+	// Cleared reports whether the counters were reset after the dump.
+	Cleared bool
+	// Err is the error writing or clearing the counters, nil on success.
+	Err      error
+	Time     time.Time
+	Duration time.Duration
+}
+
+var (
+	coverageNotifyMu  sync.Mutex
+	coverageWaiters   []chan CoverageDumpResult
+	coverageCallbacks []func(CoverageDumpResult)
+)
+
+// NextCoverageDump returns a channel that receives the result of the next
+// dump to finish, including its upload if a CoverageStorage is configured.
+// Call it before triggering the dump so the result cannot be missed:
+//
+//	done := shared.NextCoverageDump()
+//	syscall.Kill(os.Getpid(), syscall.SIGUSR1)
+//	if res := <-done; res.Err != nil {
+//	    return res.Err
+//	}
+func NextCoverageDump() <-chan CoverageDumpResult {
+	ch := make(chan CoverageDumpResult, 1)
+	coverageNotifyMu.Lock()
+	coverageWaiters = append(coverageWaiters, ch)
+	coverageNotifyMu.Unlock()
+	return ch
+}
+
+// CoverageDumpInProgress reports whether counters are being written or
+// cleared right now.
+func CoverageDumpInProgress() bool {
+	return coverageDumping.Load()
+}
+
+// WithDumpCallback makes SetupCoverageSignalHandler register fn to be called
+// after every dump of the process, whichever way it was triggered. Callbacks
+// run on the goroutine that dumped, so fn should return quickly.
+func WithDumpCallback(fn func(CoverageDumpResult)) CoverageOption {
+	return func(c *coverageConfig) { c.callbacks = append(c.callbacks, fn) }
+}
+
+// WithMinDumpInterval sets the shortest gap between two dumps triggered by
+// signals (default DefaultCoverageMinDumpInterval). A signal arriving sooner
+// is held until the interval has passed, and signals received meanwhile are
+// coalesced into that one dump, which clears the counters if any of them
+// asked for it. Zero disables debouncing.
+func WithMinDumpInterval(d time.Duration) CoverageOption {
+	return func(c *coverageConfig) { c.minDumpInterval = d }
+}
+
+// dumpCoverageTo writes the counters to dir, creating it if needed, and
+// clears them afterwards if clear is set, all under coverageMu so that no
+// other trigger can interleave. The dump is then uploaded, outside the lock,
+// and its result reported to NextCoverageDump waiters and callbacks.
+func dumpCoverageTo(trigger, dir string, clear bool) CoverageDumpResult {
+	res := CoverageDumpResult{Trigger: trigger, Dir: dir, Time: time.Now()}
+	if !coverageMu.TryLock() {
+		log.Printf("Coverage: Dump in progress, %s dump waiting for it to finish", trigger)
+		coverageMu.Lock()
+	}
+	coverageDumping.Store(true)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		res.Err = fmt.Errorf("coverage: creating dump dir: %w", err)
+	} else {
+		res.Err = writeCoverage(dir)
+		recordCoverageDump(dir, res.Err)
+	}
+	written := res.Err == nil
+	if written && clear {
+		if res.Err = clearCoverage(); res.Err == nil {
+			res.Cleared = true
+		}
+	}
+	coverageDumping.Store(false)
+	coverageMu.Unlock()
+	res.Duration = time.Since(res.Time)
+
+	if written {
+		uploadCoverageDir(dir)
+	}
+	notifyCoverageDump(res)
+	return res
+}
+
+// registerCoverageCallback adds fn to the callbacks run after every dump.
+func registerCoverageCallback(fn func(CoverageDumpResult)) {
+	coverageNotifyMu.Lock()
+	coverageCallbacks = append(coverageCallbacks, fn)
+	coverageNotifyMu.Unlock()
+}
+
+// notifyCoverageDump hands res to the pending waiters and the callbacks.
+func notifyCoverageDump(res CoverageDumpResult) {
+	coverageNotifyMu.Lock()
+	waiters := coverageWaiters
+	coverageWaiters = nil
+	callbacks := coverageCallbacks
+	coverageNotifyMu.Unlock()
+	for _, ch := range waiters {
+		ch <- res
+	}
+	for _, fn := range callbacks {
+		fn(res)
+	}
+}
@@ -0,0 +1,106 @@
+package shared
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestNextCoverageDumpReportsResult(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "snap")
+	done := NextCoverageDump()
+
+	res := dumpCoverageTo("test", dir, true)
+	got := <-done
+	if got.Trigger != "test" || got.Dir != dir {
+		t.Errorf("result = %+v, want trigger test and dir %s", got, dir)
+	}
+	// Test binaries are usually built without -cover, in which case the
+	// dump fails and the counters must not be cleared.
+	if (got.Err == nil) != (res.Err == nil) || (got.Err != nil && got.Cleared) {
+		t.Errorf("result = %+v, returned %+v", got, res)
+	}
+	if CoverageDumpInProgress() {
+		t.Error("dump still reported in progress")
+	}
+}
+
+func TestDumpCoverageNotifies(t *testing.T) {
+	dir := t.TempDir()
+	done := NextCoverageDump()
+
+	err := DumpCoverage(dir)
+	got := <-done
+	if got.Trigger != "api" || got.Dir != dir || got.Err != err {
+		t.Errorf("result = %+v, want trigger api, dir %s and error %v", got, dir, err)
+	}
+}
+
+func TestSignalHandlerCoalescesBursts(t *testing.T) {
+	coverDir := t.TempDir()
+	clk := NewFakeClock(time.Date(2026, 1, 2, 15, 4, 5, 0, time.UTC))
+	c := make(chan os.Signal, 4)
+	defer close(c)
+
+	var mu sync.Mutex
+	var results []CoverageDumpResult
+	registerCoverageCallback(func(r CoverageDumpResult) {
+		if r.Trigger == "signal" {
+			mu.Lock()
+			results = append(results, r)
+			mu.Unlock()
+		}
+	})
+
+	cfg := coverageConfig{minDumpInterval: time.Second, clock: clk}
+	go handleCoverageSignals(c, coverDir, map[os.Signal]bool{syscall.SIGUSR1: true}, cfg)
+
+	done := NextCoverageDump()
+	c <- syscall.SIGUSR1
+	<-done
+
+	// The next signal is held back until a second has passed; the ones
+	// arriving meanwhile are folded into the same dump.
+	done = NextCoverageDump()
+	c <- syscall.SIGUSR1
+	clk.BlockUntil(1)
+	c <- syscall.SIGUSR1
+	c <- syscall.SIGUSR1
+	select {
+	case r := <-done:
+		t.Fatalf("dump %+v ran before the minimum interval", r)
+	case <-time.After(20 * time.Millisecond):
+	}
+	clk.Advance(time.Second)
+	<-done
+	if n := len(c); n != 0 {
+		t.Errorf("%d signals left unprocessed after the coalesced dump", n)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(results) != 2 {
+		t.Fatalf("got %d dumps, want 2", len(results))
+	}
+}
+
+func TestDumpCoverageSerialized(t *testing.T) {
+	dir := t.TempDir()
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			dumpCoverageTo("test", dir, i%2 == 0)
+			ClearCoverage()
+		}()
+	}
+	wg.Wait()
+	if CoverageDumpInProgress() || !coverageMu.TryLock() {
+		t.Fatal("coverage lock still held after all dumps finished")
+	}
+	coverageMu.Unlock()
+}
@@ -0,0 +1,96 @@
+package shared
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// coverageChildDir is the subdirectory of GOCOVERDIR holding the coverage of
+// processes started with ExecWithCoverage.
+const coverageChildDir = "children"
+
+var (
+	coverageChildSeq atomic.Int64
+
+	coverageChildMu  sync.Mutex
+	coverageChildren = make(map[*os.Process]string)
+)
+
+// ExecWithCoverage starts cmd like cmd.Start, with its coverage plumbed into
+// the parent's. When GOCOVERDIR is set, the child gets its own directory,
+// $GOCOVERDIR/children/<name>.<n>/ where name is the base name of cmd.Path,
+// so its counter files neither collide with the parent's nor with those of
+// other children. While the child runs, the dump signals received by the
+// handler SetupCoverageSignalHandler installs are forwarded to it, so a
+// single kill -USR1 collects the whole process tree:
+//
+//	cmd := exec.CommandContext(ctx, "/bin/thumbnailer", "-listen", sock)
+//	if err := shared.ExecWithCoverage(cmd); err != nil {
+//	    return err
+//	}
+//	defer cmd.Wait()
+//
+// The child must be a Go binary built with -cover that calls
+// SetupCoverageSignalHandler for the same signals (helpers sharing the
+// service's main package do). Dumps triggered over HTTP or gRPC are not
+// forwarded. Without GOCOVERDIR ExecWithCoverage is cmd.Start.
+func ExecWithCoverage(cmd *exec.Cmd) error {
+	coverDir, ok := os.LookupEnv("GOCOVERDIR")
+	if !ok {
+		return cmd.Start()
+	}
+	if cmd.Process != nil {
+		return errors.New("coverage: exec: already started")
+	}
+
+	name := fmt.Sprintf("%s.%d", filepath.Base(cmd.Path), coverageChildSeq.Add(1))
+	dir := filepath.Join(coverDir, coverageChildDir, name)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return fmt.Errorf("coverage: creating child dir: %w", err)
+	}
+	cmd.Env = withCoverageDir(cmd.Environ(), dir)
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	coverageChildMu.Lock()
+	coverageChildren[cmd.Process] = name
+	coverageChildMu.Unlock()
+	log.Printf("Coverage: Started %s (pid %d) with GOCOVERDIR=%s", cmd.Path, cmd.Process.Pid, dir)
+	return nil
+}
+
+// withCoverageDir returns env with GOCOVERDIR set to dir.
+func withCoverageDir(env []string, dir string) []string {
+	out := make([]string, 0, len(env)+1)
+	for _, kv := range env {
+		if !strings.HasPrefix(kv, "GOCOVERDIR=") {
+			out = append(out, kv)
+		}
+	}
+	return append(out, "GOCOVERDIR="+dir)
+}
+
+// forwardCoverageSignal sends sig to the children started with
+// ExecWithCoverage, forgetting those that have exited.
+func forwardCoverageSignal(sig os.Signal) {
+	coverageChildMu.Lock()
+	defer coverageChildMu.Unlock()
+	for p, name := range coverageChildren {
+		if err := p.Signal(sig); err != nil {
+			if !errors.Is(err, os.ErrProcessDone) {
+				log.Printf("Coverage: Error forwarding %v to %s (pid %d): %v", sig, name, p.Pid, err)
+			}
+			delete(coverageChildren, p)
+			continue
+		}
+		log.Printf("Coverage: Forwarded %v to %s (pid %d)", sig, name, p.Pid)
+	}
+}
@@ -0,0 +1,93 @@
+package shared
+
+import (
+	"bufio"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+func TestExecWithCoveragePlumbsDir(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh not available")
+	}
+	coverDir := t.TempDir()
+	t.Setenv("GOCOVERDIR", coverDir)
+
+	cmd := exec.Command("sh", "-c", `echo "$GOCOVERDIR"`)
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ExecWithCoverage(cmd); err != nil {
+		t.Fatal(err)
+	}
+	line, _ := bufio.NewReader(out).ReadString('\n')
+	if err := cmd.Wait(); err != nil {
+		t.Fatal(err)
+	}
+
+	dir := strings.TrimSpace(line)
+	if filepath.Dir(dir) != filepath.Join(coverDir, coverageChildDir) || !strings.HasPrefix(filepath.Base(dir), "sh.") {
+		t.Errorf("child GOCOVERDIR = %q, want %s/%s/sh.<n>", dir, coverDir, coverageChildDir)
+	}
+	if fi, err := os.Stat(dir); err != nil || !fi.IsDir() {
+		t.Errorf("child dir not created: %v", err)
+	}
+
+	// The exited child is forgotten on the next forward.
+	forwardCoverageSignal(syscall.SIGUSR1)
+	coverageChildMu.Lock()
+	_, tracked := coverageChildren[cmd.Process]
+	coverageChildMu.Unlock()
+	if tracked {
+		t.Error("exited child still tracked")
+	}
+}
+
+func TestExecWithCoverageForwardsSignals(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh not available")
+	}
+	t.Setenv("GOCOVERDIR", t.TempDir())
+
+	cmd := exec.Command("sh", "-c", `trap 'echo dump; exit 0' USR1; echo ready; while :; do sleep 0.01; done`)
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ExecWithCoverage(cmd); err != nil {
+		t.Fatal(err)
+	}
+	defer cmd.Process.Kill()
+	r := bufio.NewReader(out)
+	if line, _ := r.ReadString('\n'); line != "ready\n" {
+		t.Fatalf("child said %q", line)
+	}
+
+	forwardCoverageSignal(syscall.SIGUSR1)
+	if line, _ := r.ReadString('\n'); line != "dump\n" {
+		t.Errorf("child said %q after the forwarded signal, want dump", line)
+	}
+	if err := cmd.Wait(); err != nil {
+		t.Errorf("child exited with %v", err)
+	}
+}
+
+func TestExecWithoutCoverageDir(t *testing.T) {
+	if dir, ok := os.LookupEnv("GOCOVERDIR"); ok {
+		t.Setenv("GOCOVERDIR", dir)
+		os.Unsetenv("GOCOVERDIR")
+	}
+	cmd := exec.Command("true")
+	if err := ExecWithCoverage(cmd); err != nil {
+		t.Skipf("true not available: %v", err)
+	}
+	cmd.Wait()
+	if cmd.Env != nil {
+		t.Errorf("environment changed without GOCOVERDIR: %v", cmd.Env)
+	}
+}
@@ -0,0 +1,85 @@
+package shared
+
+import (
+	"context"
+	"log"
+	"os"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	pb "github.com/GoogleCloudPlatform/microservices-demo/src/shared/genproto"
+)
+
+// RegisterCoverageControl registers the CoverageControl gRPC service on s so a
+// test harness can dump, clear, and inspect coverage over the same protocol it
+// already uses to talk to the service:
+//
+//	srv := grpc.NewServer()
+//	pb.RegisterShippingServiceServer(srv, svc)
+//	shared.RegisterCoverageControl(srv)
+//
+// The service is always registered; when GOCOVERDIR is not set, Dump and Clear
+// fail with FailedPrecondition and Status reports enabled=false.
+func RegisterCoverageControl(s grpc.ServiceRegistrar) {
+	pb.RegisterCoverageControlServer(s, &coverageControlServer{})
+}
+
+// coverageControlServer implements pb.CoverageControlServer.
+type coverageControlServer struct {
+	pb.UnimplementedCoverageControlServer
+}
+
+// Dump writes coverage counters and optionally clears them afterwards.
+func (s *coverageControlServer) Dump(ctx context.Context, req *pb.DumpRequest) (*pb.DumpResponse, error) {
+	coverDir, ok := os.LookupEnv("GOCOVERDIR")
+	if !ok {
+		return nil, status.Error(codes.FailedPrecondition, ErrCoverageDisabled.Error())
+	}
+
+	log.Println("Coverage: Received gRPC dump request, dumping coverage data...")
+	// The clear happens under the same lock as the dump, so no other
+	// trigger's counts can fall between the two.
+	res := dumpCoverageLabeled("grpc", coverDir, req.GetLabel(), req.GetClear())
+	if res.Err != nil {
+		log.Printf("Coverage: Error writing coverage data: %v", res.Err)
+		return nil, status.Error(codes.Internal, res.Err.Error())
+	}
+	log.Printf("Coverage: Successfully wrote coverage data to %s", res.Dir)
+	if res.Cleared {
+		log.Println("Coverage: Counters cleared for next collection")
+	}
+	return &pb.DumpResponse{Dir: res.Dir}, nil
+}
+
+// Clear resets coverage counters.
+func (s *coverageControlServer) Clear(ctx context.Context, req *pb.ClearRequest) (*pb.ClearResponse, error) {
+	if _, ok := os.LookupEnv("GOCOVERDIR"); !ok {
+		return nil, status.Error(codes.FailedPrecondition, ErrCoverageDisabled.Error())
+	}
+	if err := ClearCoverage(); err != nil {
+		log.Printf("Coverage: Error clearing counters: %v", err)
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	log.Println("Coverage: Counters cleared via gRPC request")
+	return &pb.ClearResponse{}, nil
+}
+
+// Status reports the state of the coverage subsystem.
+func (s *coverageControlServer) Status(ctx context.Context, req *pb.StatusRequest) (*pb.StatusResponse, error) {
+	st := CoverageStatus()
+	res := &pb.StatusResponse{
+		Enabled:                 st.Enabled,
+		CoverDir:                st.CoverDir,
+		SignalHandlerRegistered: st.SignalHandlerRegistered,
+		DumpCount:               st.Dumps,
+		LastDumpDir:             st.LastDumpDir,
+		LastError:               st.LastError,
+	}
+	if !st.LastDumpTime.IsZero() {
+		res.LastDumpTime = timestamppb.New(st.LastDumpTime)
+	}
+	return res, nil
+}
@@ -0,0 +1,48 @@
+package shared
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	pb "github.com/GoogleCloudPlatform/microservices-demo/src/shared/genproto"
+)
+
+func TestCoverageControlDisabled(t *testing.T) {
+	if dir, ok := os.LookupEnv("GOCOVERDIR"); ok {
+		t.Setenv("GOCOVERDIR", dir)
+		os.Unsetenv("GOCOVERDIR")
+	}
+	s := &coverageControlServer{}
+	ctx := context.Background()
+
+	if _, err := s.Dump(ctx, &pb.DumpRequest{}); status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("Dump: got code %v, want %v", status.Code(err), codes.FailedPrecondition)
+	}
+	if _, err := s.Clear(ctx, &pb.ClearRequest{}); status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("Clear: got code %v, want %v", status.Code(err), codes.FailedPrecondition)
+	}
+	res, err := s.Status(ctx, &pb.StatusRequest{})
+	if err != nil {
+		t.Fatalf("Status: %v", err)
+	}
+	if res.GetEnabled() {
+		t.Error("Status: enabled = true, want false")
+	}
+}
+
+func TestCoverageControlStatus(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("GOCOVERDIR", dir)
+
+	res, err := (&coverageControlServer{}).Status(context.Background(), &pb.StatusRequest{})
+	if err != nil {
+		t.Fatalf("Status: %v", err)
+	}
+	if !res.GetEnabled() || res.GetCoverDir() != dir {
+		t.Errorf("Status = %+v, want enabled with cover_dir %q", res, dir)
+	}
+}
@@ -0,0 +1,78 @@
+package shared
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+)
+
+// CoverageHandler returns an http.Handler exposing coverage controls over HTTP.
+// It is an alternative to SIGUSR1 for environments where sending signals to the
+// process is awkward (e.g. containers without a kill binary).
+//
+// The handler serves three endpoints:
+//
+//	POST /coverage/dump   writes coverage counters to GOCOVERDIR
+//	                      (or $GOCOVERDIR/<label>/ if X-Coverage-Label is set)
+//	POST /coverage/clear  resets coverage counters
+//	GET  /coverage/status reports CoverageStatus as JSON
+//
+// Mount it on an internal admin port, never on a public listener:
+//
+//	mux := http.NewServeMux()
+//	mux.Handle("/coverage/", shared.CoverageHandler())
+//	go http.ListenAndServe(":9090", mux)
+//
+// Then trigger a dump from the test harness:
+//
+//	curl -X POST http://<pod-ip>:9090/coverage/dump
+//
+// If GOCOVERDIR is not set the dump and clear endpoints respond with
+// 503 Service Unavailable; status is always available.
+func CoverageHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/coverage/dump", coveragePostOnly(func(w http.ResponseWriter, r *http.Request) {
+		coverDir, ok := os.LookupEnv("GOCOVERDIR")
+		if !ok {
+			http.Error(w, "coverage not enabled: GOCOVERDIR is not set", http.StatusServiceUnavailable)
+			return
+		}
+		log.Println("Coverage: Received HTTP dump request, dumping coverage data...")
+		res := dumpCoverageLabeled("http", coverDir, r.Header.Get(CoverageLabelHeader), false)
+		if res.Err != nil {
+			log.Printf("Coverage: Error writing coverage data: %v", res.Err)
+			http.Error(w, res.Err.Error(), http.StatusInternalServerError)
+			return
+		}
+		log.Printf("Coverage: Successfully wrote coverage data to %s", res.Dir)
+		fmt.Fprintf(w, "coverage data written to %s\n", res.Dir)
+	}))
+	mux.HandleFunc("/coverage/clear", coveragePostOnly(func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := os.LookupEnv("GOCOVERDIR"); !ok {
+			http.Error(w, "coverage not enabled: GOCOVERDIR is not set", http.StatusServiceUnavailable)
+			return
+		}
+		if err := ClearCoverage(); err != nil {
+			log.Printf("Coverage: Error clearing counters: %v", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		log.Println("Coverage: Counters cleared via HTTP request")
+		fmt.Fprintln(w, "coverage counters cleared")
+	}))
+	mux.HandleFunc("/coverage/status", serveCoverageStatus)
+	return mux
+}
+
+// coveragePostOnly rejects any request that is not a POST.
+func coveragePostOnly(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		h(w, r)
+	}
+}
@@ -0,0 +1,34 @@
+package shared
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+func TestCoverageHandlerRejectsNonPost(t *testing.T) {
+	h := CoverageHandler()
+	for _, path := range []string{"/coverage/dump", "/coverage/clear"} {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		if rec.Code != http.StatusMethodNotAllowed {
+			t.Errorf("GET %s: got status %d, want %d", path, rec.Code, http.StatusMethodNotAllowed)
+		}
+	}
+}
+
+func TestCoverageHandlerDisabledWithoutGOCOVERDIR(t *testing.T) {
+	if dir, ok := os.LookupEnv("GOCOVERDIR"); ok {
+		t.Setenv("GOCOVERDIR", dir)
+		os.Unsetenv("GOCOVERDIR")
+	}
+	h := CoverageHandler()
+	for _, path := range []string{"/coverage/dump", "/coverage/clear"} {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, path, nil))
+		if rec.Code != http.StatusServiceUnavailable {
+			t.Errorf("POST %s: got status %d, want %d", path, rec.Code, http.StatusServiceUnavailable)
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package shared
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// CoverageLabelHeader is the HTTP header CoverageHandler reads to file a dump
+// under a specific label.
+const CoverageLabelHeader = "X-Coverage-Label"
+
+var (
+	coverageLabelMu sync.RWMutex
+	coverageLabel   string
+
+	// coverageSubdirs is set when every dump should go to its own subdirectory.
+	coverageSubdirs atomic.Bool
+)
+
+// SetCoverageLabel sets the label used to name the subdirectory of GOCOVERDIR
+// that subsequent dumps are written to, e.g. "checkout-flow" results in
+// $GOCOVERDIR/checkout-flow/. Passing an empty string clears the label.
+//
+// A label set here takes precedence over the COVERAGE_LABEL environment
+// variable; a label passed per request (such as the X-Coverage-Label header)
+// takes precedence over both.
+func SetCoverageLabel(label string) {
+	coverageLabelMu.Lock()
+	defer coverageLabelMu.Unlock()
+	coverageLabel = label
+}
+
+// CoverageLabel returns the label set with SetCoverageLabel, falling back to
+// the COVERAGE_LABEL environment variable.
+func CoverageLabel() string {
+	coverageLabelMu.RLock()
+	label := coverageLabel
+	coverageLabelMu.RUnlock()
+	if label != "" {
+		return label
+	}
+	return os.Getenv("COVERAGE_LABEL")
+}
+
+// WithPerDumpSubdirs makes every dump go to its own subdirectory of
+// GOCOVERDIR: $GOCOVERDIR/<label>/ when a label is set, otherwise
+// $GOCOVERDIR/<timestamp>/. This keeps successive dumps from interleaving
+// their counter files and makes per-test-run attribution possible.
+//
+// The same mode can be enabled without code changes by setting
+// COVERAGE_SUBDIRS=true.
+func WithPerDumpSubdirs() CoverageOption {
+	return func(c *coverageConfig) {
+		coverageSubdirs.Store(true)
+	}
+}
+
+// coverageDumpDir resolves the directory a dump should be written to.
+// label overrides the process-wide label when non-empty.
+func coverageDumpDir(base, label string) string {
+	if label == "" {
+		label = CoverageLabel()
+	}
+	if label != "" {
+		return filepath.Join(base, sanitizeCoverageLabel(label))
+	}
+	if coverageSubdirs.Load() || os.Getenv("COVERAGE_SUBDIRS") == "true" {
+		return filepath.Join(base, time.Now().UTC().Format(coverageTimestampFormat))
+	}
+	return base
+}
+
+// sanitizeCoverageLabel maps a label to a safe single path element.
+func sanitizeCoverageLabel(label string) string {
+	clean := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '-', r == '_', r == '.':
+			return r
+		}
+		return '_'
+	}, label)
+	if strings.Trim(clean, ".") == "" {
+		return "_"
+	}
+	return clean
+}
+
+// dumpCoverageLabeled writes counters to the directory resolved by
+// coverageDumpDir, clearing them afterwards if clear is set.
+func dumpCoverageLabeled(trigger, base, label string, clear bool) CoverageDumpResult {
+	return dumpCoverageTo(trigger, coverageDumpDir(base, label), clear)
+}
@@ -0,0 +1,150 @@
+package shared
+
+import (
+	"context"
+	"log"
+	"os"
+	"sync"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// CoverageLabelMetadataKey is the gRPC metadata key naming the end-to-end
+// scenario a call belongs to, the counterpart of CoverageLabelHeader.
+const CoverageLabelMetadataKey = "x-coverage-label"
+
+// coverageLabelSwitch serializes label changes so a dump on switch cannot
+// interleave with the next one.
+var coverageLabelSwitch sync.Mutex
+
+type coverageLabelCtxKey struct{}
+
+// ContextWithCoverageLabel returns a copy of ctx whose outgoing calls, made
+// through CoverageLabelClientInterceptor, carry label.
+func ContextWithCoverageLabel(ctx context.Context, label string) context.Context {
+	return context.WithValue(ctx, coverageLabelCtxKey{}, label)
+}
+
+// CoverageLabelFromContext returns the label of the call ctx belongs to, or
+// "" if it has none.
+func CoverageLabelFromContext(ctx context.Context) string {
+	l, _ := ctx.Value(coverageLabelCtxKey{}).(string)
+	return l
+}
+
+// WithDumpOnLabelChange makes the coverage label interceptors dump and clear
+// the counters whenever a call arrives with a label different from the
+// current one. The counters gathered so far are filed under the old label,
+// so scenarios run one after another against a long-running service are
+// attributed separately without the harness triggering a dump in between.
+// Calls of the old scenario still in flight at the switch count towards the
+// new one.
+func WithDumpOnLabelChange() CoverageOption {
+	return func(c *coverageConfig) { c.dumpOnLabelChange = true }
+}
+
+// CoverageLabelUnaryInterceptor reads the x-coverage-label metadata key of
+// each call and makes it the process's coverage label (see
+// SetCoverageLabel), so the next dump, whichever way it is triggered, is
+// written to $GOCOVERDIR/<label>/. The test harness tags the calls of each
+// end-to-end scenario:
+//
+//	ctx = metadata.AppendToOutgoingContext(ctx, shared.CoverageLabelMetadataKey, "checkout-flow")
+//
+// Coverage counters are process-wide, so labels attribute coverage to
+// scenarios that run one at a time, not to concurrent ones. Calls without
+// the key leave the label unchanged. The label is also stored in the call's
+// context, so CoverageLabelClientInterceptor forwards it downstream and the
+// whole call graph is labelled alike.
+//
+// Usage:
+//
+//	srv := grpcserver.New(grpcserver.WithUnaryInterceptors(
+//	    shared.CoverageLabelUnaryInterceptor(shared.WithDumpOnLabelChange())))
+//
+// The interceptor only passes calls through when GOCOVERDIR is not set.
+func CoverageLabelUnaryInterceptor(opts ...CoverageOption) grpc.UnaryServerInterceptor {
+	cfg := coverageConfig{}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		return handler(incomingCoverageLabel(ctx, cfg), req)
+	}
+}
+
+// CoverageLabelStreamInterceptor is the streaming variant of
+// CoverageLabelUnaryInterceptor.
+func CoverageLabelStreamInterceptor(opts ...CoverageOption) grpc.StreamServerInterceptor {
+	cfg := coverageConfig{}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx := incomingCoverageLabel(ss.Context(), cfg)
+		if ctx == ss.Context() {
+			return handler(srv, ss)
+		}
+		return handler(srv, &coverageLabelStream{ServerStream: ss, ctx: ctx})
+	}
+}
+
+type coverageLabelStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *coverageLabelStream) Context() context.Context { return s.ctx }
+
+// CoverageLabelClientInterceptor sends the label of ctx, set by the server
+// interceptors or ContextWithCoverageLabel, with outgoing calls.
+func CoverageLabelClientInterceptor() grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		if l := CoverageLabelFromContext(ctx); l != "" {
+			md, _ := metadata.FromOutgoingContext(ctx)
+			if len(md.Get(CoverageLabelMetadataKey)) == 0 {
+				ctx = metadata.AppendToOutgoingContext(ctx, CoverageLabelMetadataKey, l)
+			}
+		}
+		return invoker(ctx, method, req, reply, cc, opts...)
+	}
+}
+
+// incomingCoverageLabel applies the call's label, if any, and returns ctx
+// carrying it.
+func incomingCoverageLabel(ctx context.Context, cfg coverageConfig) context.Context {
+	md, _ := metadata.FromIncomingContext(ctx)
+	v := md.Get(CoverageLabelMetadataKey)
+	if len(v) == 0 || v[0] == "" {
+		return ctx
+	}
+	label := v[0]
+	if coverDir, ok := os.LookupEnv("GOCOVERDIR"); ok {
+		switchCoverageLabel(coverDir, label, cfg.dumpOnLabelChange)
+	}
+	return ContextWithCoverageLabel(ctx, label)
+}
+
+// switchCoverageLabel makes label current, first dumping and clearing the
+// counters gathered under the previous label if dump is set.
+func switchCoverageLabel(coverDir, label string, dump bool) {
+	if CoverageLabel() == label {
+		return
+	}
+	coverageLabelSwitch.Lock()
+	defer coverageLabelSwitch.Unlock()
+	prev := CoverageLabel()
+	if prev == label {
+		return
+	}
+	if dump && prev != "" {
+		res := dumpCoverageLabeled("label", coverDir, prev, true)
+		if res.Err != nil {
+			log.Printf("Coverage: Error writing coverage data for label %q: %v", prev, res.Err)
+		} else {
+			log.Printf("Coverage: Label changed to %q, wrote coverage data for %q to %s", label, prev, res.Dir)
+		}
+	}
+	SetCoverageLabel(label)
+}
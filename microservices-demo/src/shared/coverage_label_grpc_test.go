@@ -0,0 +1,62 @@
+package shared
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+func TestCoverageLabelUnaryInterceptor(t *testing.T) {
+	t.Setenv("GOCOVERDIR", t.TempDir())
+	t.Setenv("COVERAGE_LABEL", "")
+	defer SetCoverageLabel("")
+	intercept := CoverageLabelUnaryInterceptor()
+	info := &grpc.UnaryServerInfo{FullMethod: "/hipstershop.CheckoutService/PlaceOrder"}
+
+	var got string
+	handler := func(ctx context.Context, req any) (any, error) {
+		got = CoverageLabelFromContext(ctx)
+		return nil, nil
+	}
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(CoverageLabelMetadataKey, "checkout-flow"))
+	intercept(ctx, nil, info, handler)
+	if got != "checkout-flow" || CoverageLabel() != "checkout-flow" {
+		t.Errorf("context label %q, process label %q; want checkout-flow", got, CoverageLabel())
+	}
+
+	intercept(context.Background(), nil, info, handler)
+	if got != "" || CoverageLabel() != "checkout-flow" {
+		t.Errorf("unlabelled call: context label %q, process label %q; want none and unchanged", got, CoverageLabel())
+	}
+}
+
+func TestCoverageLabelIgnoredWithoutCoverDir(t *testing.T) {
+	t.Setenv("GOCOVERDIR", "") // restored after the test
+	os.Unsetenv("GOCOVERDIR")
+	t.Setenv("COVERAGE_LABEL", "")
+	defer SetCoverageLabel("")
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(CoverageLabelMetadataKey, "browse-flow"))
+	CoverageLabelUnaryInterceptor()(ctx, nil, &grpc.UnaryServerInfo{}, func(context.Context, any) (any, error) { return nil, nil })
+	if l := CoverageLabel(); l != "" {
+		t.Errorf("process label = %q without GOCOVERDIR, want unchanged", l)
+	}
+}
+
+func TestCoverageLabelClientInterceptor(t *testing.T) {
+	var got []string
+	invoker := func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+		md, _ := metadata.FromOutgoingContext(ctx)
+		got = md.Get(CoverageLabelMetadataKey)
+		return nil
+	}
+	ctx := ContextWithCoverageLabel(context.Background(), "browse-flow")
+	if err := CoverageLabelClientInterceptor()(ctx, "/m", nil, nil, nil, invoker); err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 || got[0] != "browse-flow" {
+		t.Errorf("outgoing %s = %q, want [browse-flow]", CoverageLabelMetadataKey, got)
+	}
+}
@@ -0,0 +1,55 @@
+package shared
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestCoverageDumpDir(t *testing.T) {
+	t.Setenv("COVERAGE_LABEL", "")
+	t.Setenv("COVERAGE_SUBDIRS", "")
+	base := "/cover"
+
+	if got := coverageDumpDir(base, ""); got != base {
+		t.Errorf("no label: got %q, want %q", got, base)
+	}
+
+	t.Setenv("COVERAGE_LABEL", "from-env")
+	if got, want := coverageDumpDir(base, ""), filepath.Join(base, "from-env"); got != want {
+		t.Errorf("env label: got %q, want %q", got, want)
+	}
+
+	SetCoverageLabel("checkout-flow")
+	defer SetCoverageLabel("")
+	if got, want := coverageDumpDir(base, ""), filepath.Join(base, "checkout-flow"); got != want {
+		t.Errorf("set label: got %q, want %q", got, want)
+	}
+
+	if got, want := coverageDumpDir(base, "../escape/attempt"), filepath.Join(base, ".._escape_attempt"); got != want {
+		t.Errorf("explicit label: got %q, want %q", got, want)
+	}
+}
+
+func TestCoverageDumpDirTimestamped(t *testing.T) {
+	t.Setenv("COVERAGE_LABEL", "")
+	t.Setenv("COVERAGE_SUBDIRS", "true")
+	base := "/cover"
+
+	got := coverageDumpDir(base, "")
+	if filepath.Dir(got) != base || got == base {
+		t.Errorf("got %q, want a timestamped subdirectory of %q", got, base)
+	}
+}
+
+func TestSanitizeCoverageLabel(t *testing.T) {
+	tests := map[string]string{
+		"browse-flow": "browse-flow",
+		"run 1/2":     "run_1_2",
+		"..":          "_",
+	}
+	for in, want := range tests {
+		if got := sanitizeCoverageLabel(in); got != want {
+			t.Errorf("sanitizeCoverageLabel(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package shared
+
+import (
+	"context"
+	"errors"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// coverageTimestampFormat names snapshot directories so they sort chronologically.
+const coverageTimestampFormat = "20060102T150405.000Z"
+
+// StartPeriodicCoverageDump writes a coverage snapshot every interval until ctx
+// is cancelled. Each snapshot is written to its own timestamped subdirectory of
+// GOCOVERDIR (e.g. $GOCOVERDIR/20240102T150405.000Z/), so long-running soak
+// tests produce a series of snapshots rather than one growing set of files.
+//
+// Counters are not cleared between snapshots, so every snapshot is cumulative
+// since process start (or since the last explicit ClearCoverage call).
+//
+// Usage:
+//
+//	ctx, cancel := context.WithCancel(context.Background())
+//	defer cancel()
+//	if err := shared.StartPeriodicCoverageDump(ctx, 5*time.Minute); err != nil {
+//	    log.Printf("periodic coverage disabled: %v", err)
+//	}
+//
+// It returns ErrCoverageDisabled if GOCOVERDIR is not set. Of the
+// CoverageOptions only WithCoverageClock applies here.
+func StartPeriodicCoverageDump(ctx context.Context, interval time.Duration, opts ...CoverageOption) error {
+	coverDir, exists := os.LookupEnv("GOCOVERDIR")
+	if !exists {
+		return ErrCoverageDisabled
+	}
+	if interval <= 0 {
+		return errors.New("coverage: periodic dump interval must be positive")
+	}
+
+	var cfg coverageConfig
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	ticker := clockOrReal(cfg.clock).NewTicker(interval)
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				log.Println("Coverage: Periodic dump stopped")
+				return
+			case t := <-ticker.C():
+				dir, err := dumpCoverageSnapshot(coverDir, t.UTC().Format(coverageTimestampFormat))
+				if err != nil {
+					log.Printf("Coverage: Error writing periodic snapshot: %v", err)
+					continue
+				}
+				log.Printf("Coverage: Wrote periodic snapshot to %s", dir)
+			}
+		}
+	}()
+
+	log.Printf("Coverage: Periodic dump every %v (GOCOVERDIR=%s)", interval, coverDir)
+	return nil
+}
+
+// WithCoverageClock sets the clock driving StartPeriodicCoverageDump and the
+// signal handler's debouncing, so tests can trigger snapshots and release
+// held-back dumps by advancing a FakeClock.
+func WithCoverageClock(c Clock) CoverageOption {
+	return func(cfg *coverageConfig) {
+		cfg.clock = c
+	}
+}
+
+// dumpCoverageSnapshot writes coverage counters into base/name, creating the
+// directory if needed, and returns the directory written to.
+func dumpCoverageSnapshot(base, name string) (string, error) {
+	res := dumpCoverageTo("periodic", filepath.Join(base, name), false)
+	return res.Dir, res.Err
+}
@@ -0,0 +1,313 @@
+package shared
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ErrCoverageBelowThreshold is wrapped by CoverageReport.Check when coverage
+// is under the required percentage.
+var ErrCoverageBelowThreshold = errors.New("coverage: below threshold")
+
+// CoverageToolOption configures the functions that run go tool covdata.
+type CoverageToolOption func(*coverageToolConfig)
+
+type coverageToolConfig struct {
+	gobin    string
+	packages []string
+}
+
+// WithCoverageGoBinary runs the given go command instead of "go" on $PATH.
+func WithCoverageGoBinary(path string) CoverageToolOption {
+	return func(c *coverageToolConfig) { c.gobin = path }
+}
+
+// WithCoveragePackages restricts the output to packages matching the
+// patterns, as covdata's -pkg flag does, e.g. ".../shippingservice/...".
+func WithCoveragePackages(patterns ...string) CoverageToolOption {
+	return func(c *coverageToolConfig) { c.packages = append(c.packages, patterns...) }
+}
+
+// covdata runs go tool covdata cmd -i=<inputs> with args.
+func covdata(ctx context.Context, cmd string, inputs []string, opts []CoverageToolOption, args ...string) error {
+	if len(inputs) == 0 {
+		return errors.New("coverage: no input directories")
+	}
+	cfg := coverageToolConfig{gobin: "go"}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	argv := []string{"tool", "covdata", cmd, "-i=" + strings.Join(inputs, ",")}
+	if len(cfg.packages) > 0 {
+		argv = append(argv, "-pkg="+strings.Join(cfg.packages, ","))
+	}
+	c := exec.CommandContext(ctx, cfg.gobin, append(argv, args...)...)
+	var stderr bytes.Buffer
+	c.Stderr = &stderr
+	if err := c.Run(); err != nil {
+		return fmt.Errorf("coverage: go tool covdata %s: %w: %s", cmd, err, bytes.TrimSpace(stderr.Bytes()))
+	}
+	return nil
+}
+
+// MergeCoverage merges the GOCOVERDIR snapshots in inputs into outDir,
+// which is created if needed, summing the counters of repeated runs of the
+// same binary. The result is itself a valid input for the other functions.
+//
+// Usage, after collecting from every pod:
+//
+//	err := shared.MergeCoverage(ctx, "coverage/merged", dirs)
+func MergeCoverage(ctx context.Context, outDir string, inputs []string, opts ...CoverageToolOption) error {
+	if err := os.MkdirAll(outDir, 0o755); err != nil {
+		return fmt.Errorf("coverage: %w", err)
+	}
+	return covdata(ctx, "merge", inputs, opts, "-o="+outDir)
+}
+
+// WriteCoverageProfile converts the snapshots in inputs to the text profile
+// format of go test -coverprofile, which go tool cover -html and most CI
+// coverage services read.
+func WriteCoverageProfile(ctx context.Context, outFile string, inputs []string, opts ...CoverageToolOption) error {
+	return covdata(ctx, "textfmt", inputs, opts, "-o="+outFile)
+}
+
+// CoverageBlock is one line of a text coverage profile: a span of source
+// and how often it ran.
+type CoverageBlock struct {
+	// File is the import path of the package plus the file name.
+	File                string
+	StartLine, StartCol int
+	EndLine, EndCol     int
+	NumStmt             int
+	Count               int
+}
+
+// CoverageProfile is a parsed text coverage profile.
+type CoverageProfile struct {
+	// Mode is set, count or atomic.
+	Mode   string
+	Blocks []CoverageBlock
+}
+
+// ParseCoverageProfile reads a text coverage profile, as written by
+// WriteCoverageProfile or go test -coverprofile. Blocks listed several
+// times, as when profiles are concatenated, are combined: counts are summed,
+// except in set mode, where a block is covered if any entry covers it.
+func ParseCoverageProfile(r io.Reader) (*CoverageProfile, error) {
+	p := &CoverageProfile{}
+	index := make(map[string]int)
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 64*1024), 1<<20)
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" {
+			continue
+		}
+		if mode, ok := strings.CutPrefix(line, "mode: "); ok {
+			if p.Mode != "" && p.Mode != mode {
+				return nil, fmt.Errorf("coverage: line %d: mode %s after mode %s", n, mode, p.Mode)
+			}
+			p.Mode = mode
+			continue
+		}
+		b, err := parseCoverageBlock(line)
+		if err != nil {
+			return nil, fmt.Errorf("coverage: line %d: %w", n, err)
+		}
+		key := line[:strings.LastIndexByte(line, ' ')]
+		if i, ok := index[key]; ok {
+			if p.Mode == "set" {
+				p.Blocks[i].Count = max(p.Blocks[i].Count, b.Count)
+			} else {
+				p.Blocks[i].Count += b.Count
+			}
+			continue
+		}
+		index[key] = len(p.Blocks)
+		p.Blocks = append(p.Blocks, b)
+	}
+	if err := sc.Err(); err != nil {
+		return nil, fmt.Errorf("coverage: %w", err)
+	}
+	if p.Mode == "" {
+		return nil, errors.New("coverage: profile has no mode line")
+	}
+	return p, nil
+}
+
+// parseCoverageBlock parses "file.go:12.34,15.2 3 1".
+func parseCoverageBlock(line string) (CoverageBlock, error) {
+	var b CoverageBlock
+	colon := strings.LastIndexByte(line, ':')
+	if colon < 0 {
+		return b, fmt.Errorf("malformed block %q", line)
+	}
+	b.File = line[:colon]
+	f := strings.Fields(line[colon+1:])
+	if len(f) != 3 {
+		return b, fmt.Errorf("malformed block %q", line)
+	}
+	start, end, ok := strings.Cut(f[0], ",")
+	if !ok {
+		return b, fmt.Errorf("malformed block %q", line)
+	}
+	var err error
+	parse := func(s string) int {
+		v, e := strconv.Atoi(s)
+		if e != nil && err == nil {
+			err = fmt.Errorf("malformed block %q", line)
+		}
+		return v
+	}
+	sl, sc, _ := strings.Cut(start, ".")
+	el, ec, _ := strings.Cut(end, ".")
+	b.StartLine, b.StartCol = parse(sl), parse(sc)
+	b.EndLine, b.EndCol = parse(el), parse(ec)
+	b.NumStmt, b.Count = parse(f[1]), parse(f[2])
+	return b, err
+}
+
+// PackageCoverage is the statement coverage of one package.
+type PackageCoverage struct {
+	Package    string
+	Statements int
+	Covered    int
+}
+
+// Percent returns the share of statements covered, from 0 to 100. A package
+// without statements counts as fully covered.
+func (c PackageCoverage) Percent() float64 {
+	if c.Statements == 0 {
+		return 100
+	}
+	return 100 * float64(c.Covered) / float64(c.Statements)
+}
+
+// CoverageReport summarizes a profile per package.
+type CoverageReport struct {
+	Total PackageCoverage
+	// Packages are sorted by import path.
+	Packages []PackageCoverage
+}
+
+// Report computes the per-package and total statement coverage.
+func (p *CoverageProfile) Report() *CoverageReport {
+	byPkg := make(map[string]*PackageCoverage)
+	r := &CoverageReport{Total: PackageCoverage{Package: "total"}}
+	for _, b := range p.Blocks {
+		name := path.Dir(b.File)
+		pc, ok := byPkg[name]
+		if !ok {
+			pc = &PackageCoverage{Package: name}
+			byPkg[name] = pc
+		}
+		pc.Statements += b.NumStmt
+		r.Total.Statements += b.NumStmt
+		if b.Count > 0 {
+			pc.Covered += b.NumStmt
+			r.Total.Covered += b.NumStmt
+		}
+	}
+	for _, pc := range byPkg {
+		r.Packages = append(r.Packages, *pc)
+	}
+	sort.Slice(r.Packages, func(i, j int) bool { return r.Packages[i].Package < r.Packages[j].Package })
+	return r
+}
+
+// ReportCoverage summarizes the snapshots in inputs per package, the
+// numbers go tool covdata percent prints, as data a program can check.
+//
+// Usage, in a test orchestrator:
+//
+//	report, err := shared.ReportCoverage(ctx, dirs,
+//	    shared.WithCoveragePackages("github.com/GoogleCloudPlatform/microservices-demo/..."))
+//	if err != nil {
+//	    return err
+//	}
+//	report.WriteTo(os.Stdout)
+//	if err := report.Check(60, map[string]float64{".../shippingservice": 75}); err != nil {
+//	    return err
+//	}
+func ReportCoverage(ctx context.Context, inputs []string, opts ...CoverageToolOption) (*CoverageReport, error) {
+	tmp, err := os.MkdirTemp("", "coverage-report-")
+	if err != nil {
+		return nil, fmt.Errorf("coverage: %w", err)
+	}
+	defer os.RemoveAll(tmp)
+	out := filepath.Join(tmp, "coverage.txt")
+	if err := WriteCoverageProfile(ctx, out, inputs, opts...); err != nil {
+		return nil, err
+	}
+	f, err := os.Open(out)
+	if err != nil {
+		return nil, fmt.Errorf("coverage: %w", err)
+	}
+	defer f.Close()
+	p, err := ParseCoverageProfile(f)
+	if err != nil {
+		return nil, err
+	}
+	return p.Report(), nil
+}
+
+// WriteTo prints one line per package and the total, in the format of
+// go test -cover.
+func (r *CoverageReport) WriteTo(w io.Writer) (int64, error) {
+	var buf bytes.Buffer
+	for _, pc := range r.Packages {
+		fmt.Fprintf(&buf, "%s\tcoverage: %.1f%% of statements\n", pc.Package, pc.Percent())
+	}
+	fmt.Fprintf(&buf, "total\tcoverage: %.1f%% of statements\n", r.Total.Percent())
+	n, err := w.Write(buf.Bytes())
+	return int64(n), err
+}
+
+// Check returns an error wrapping ErrCoverageBelowThreshold, naming every
+// shortfall, if overall coverage is under total or a package is under its entry
+// in perPackage. perPackage keys are import paths; a key ending in "/..."
+// applies to every package below it, and a key starting with ".../" to
+// every package whose path ends with the rest. Either threshold may be zero.
+func (r *CoverageReport) Check(total float64, perPackage map[string]float64) error {
+	var failures []string
+	if r.Total.Percent() < total {
+		failures = append(failures, fmt.Sprintf("total %.1f%% < %.1f%%", r.Total.Percent(), total))
+	}
+	patterns := make([]string, 0, len(perPackage))
+	for p := range perPackage {
+		patterns = append(patterns, p)
+	}
+	sort.Strings(patterns)
+	for _, pc := range r.Packages {
+		for _, pat := range patterns {
+			if want := perPackage[pat]; matchCoveragePackage(pat, pc.Package) && pc.Percent() < want {
+				failures = append(failures, fmt.Sprintf("%s %.1f%% < %.1f%%", pc.Package, pc.Percent(), want))
+			}
+		}
+	}
+	if len(failures) > 0 {
+		return fmt.Errorf("%w: %s", ErrCoverageBelowThreshold, strings.Join(failures, "; "))
+	}
+	return nil
+}
+
+func matchCoveragePackage(pattern, pkg string) bool {
+	if rest, ok := strings.CutPrefix(pattern, ".../"); ok {
+		return pkg == rest || strings.HasSuffix(pkg, "/"+rest)
+	}
+	if prefix, ok := strings.CutSuffix(pattern, "/..."); ok {
+		return pkg == prefix || strings.HasPrefix(pkg, prefix+"/")
+	}
+	return pkg == pattern
+}
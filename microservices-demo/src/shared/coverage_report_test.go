@@ -0,0 +1,132 @@
+package shared
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const testCoverageProfile = `mode: set
+example.com/shop/cart/cart.go:10.2,12.3 2 1
+example.com/shop/cart/cart.go:14.2,15.3 2 0
+example.com/shop/pay/pay.go:5.2,9.3 4 0
+example.com/shop/pay/pay.go:5.2,9.3 4 1
+example.com/shop/pay/pay.go:11.2,12.3 1 0
+`
+
+func TestParseCoverageProfile(t *testing.T) {
+	p, err := ParseCoverageProfile(strings.NewReader(testCoverageProfile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Mode != "set" || len(p.Blocks) != 4 {
+		t.Fatalf("mode %q with %d blocks, want set with 4 (duplicates combined)", p.Mode, len(p.Blocks))
+	}
+	if b := p.Blocks[2]; b.File != "example.com/shop/pay/pay.go" || b.StartLine != 5 || b.EndCol != 3 || b.Count != 1 {
+		t.Errorf("combined block = %+v", b)
+	}
+
+	r := p.Report()
+	if r.Total.Statements != 9 || r.Total.Covered != 6 {
+		t.Errorf("total = %+v, want 6 of 9", r.Total)
+	}
+	if len(r.Packages) != 2 || r.Packages[0].Package != "example.com/shop/cart" || r.Packages[0].Percent() != 50 {
+		t.Errorf("packages = %+v", r.Packages)
+	}
+
+	if _, err := ParseCoverageProfile(strings.NewReader("a.go:1.1,2.2 1 1\n")); err == nil {
+		t.Error("profile without mode parsed")
+	}
+	if _, err := ParseCoverageProfile(strings.NewReader("mode: set\na.go:1.1 1 1\n")); err == nil {
+		t.Error("malformed block parsed")
+	}
+}
+
+func TestCoverageReportCheck(t *testing.T) {
+	p, _ := ParseCoverageProfile(strings.NewReader(testCoverageProfile))
+	r := p.Report()
+	if err := r.Check(60, map[string]float64{"example.com/shop/...": 50}); err != nil {
+		t.Errorf("Check within thresholds = %v", err)
+	}
+	err := r.Check(70, map[string]float64{".../pay": 90})
+	if !errors.Is(err, ErrCoverageBelowThreshold) {
+		t.Fatalf("Check = %v, want ErrCoverageBelowThreshold", err)
+	}
+	if msg := err.Error(); !strings.Contains(msg, "total 66.7%") || !strings.Contains(msg, "example.com/shop/pay 80.0%") {
+		t.Errorf("Check error %q should name both shortfalls", msg)
+	}
+}
+
+func TestMergeAndReportCoverage(t *testing.T) {
+	if testing.Short() {
+		t.Skip("builds a coverage-instrumented binary")
+	}
+	gobin, err := exec.LookPath("go")
+	if err != nil {
+		t.Skip("go command not found")
+	}
+	src := t.TempDir()
+	os.WriteFile(filepath.Join(src, "go.mod"), []byte("module example.com/covdemo\n\ngo 1.23\n"), 0o644)
+	os.WriteFile(filepath.Join(src, "main.go"), []byte(`package main
+
+import "os"
+
+func used() int { return 1 }
+
+func unused() int {
+	x := 2
+	return x
+}
+
+func main() {
+	if len(os.Args) > 1 {
+		unused()
+	}
+	used()
+}
+`), 0o644)
+	bin := filepath.Join(src, "covdemo")
+	build := exec.Command(gobin, "build", "-cover", "-o", bin, ".")
+	build.Dir = src
+	build.Env = append(os.Environ(), "GOFLAGS=", "GOWORK=off")
+	if out, err := build.CombinedOutput(); err != nil {
+		t.Fatalf("build: %v\n%s", err, out)
+	}
+	root := t.TempDir()
+	var dirs []string
+	for i, args := range [][]string{nil, {"all"}} {
+		dir := filepath.Join(root, "run"+string(rune('0'+i)))
+		os.Mkdir(dir, 0o755)
+		run := exec.Command(bin, args...)
+		run.Env = append(os.Environ(), "GOCOVERDIR="+dir)
+		if out, err := run.CombinedOutput(); err != nil {
+			t.Fatalf("run: %v\n%s", err, out)
+		}
+		dirs = append(dirs, dir)
+	}
+
+	ctx := context.Background()
+	single, err := ReportCoverage(ctx, dirs[:1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	merged := filepath.Join(root, "merged")
+	if err := MergeCoverage(ctx, merged, dirs); err != nil {
+		t.Fatal(err)
+	}
+	all, err := ReportCoverage(ctx, []string{merged})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if single.Total.Percent() >= 100 || all.Total.Percent() != 100 {
+		t.Errorf("coverage: first run %.1f%%, merged %.1f%%; want below 100 and 100",
+			single.Total.Percent(), all.Total.Percent())
+	}
+	if err := single.Check(100, nil); !errors.Is(err, ErrCoverageBelowThreshold) {
+		t.Errorf("Check(100) on partial coverage = %v", err)
+	}
+}
@@ -0,0 +1,75 @@
+package shared
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// CoverageState describes the coverage subsystem of the running process.
+type CoverageState struct {
+	// Enabled reports whether GOCOVERDIR is set.
+	Enabled  bool   `json:"enabled"`
+	CoverDir string `json:"coverDir,omitempty"`
+	// SignalHandlerRegistered reports whether SetupCoverageSignalHandler ran
+	// with coverage enabled.
+	SignalHandlerRegistered bool `json:"signalHandlerRegistered"`
+	// Dumps counts successful dumps since process start.
+	Dumps        int64     `json:"dumps"`
+	LastDumpTime time.Time `json:"lastDumpTime"`
+	LastDumpDir  string    `json:"lastDumpDir,omitempty"`
+	// LastError is the error from the most recent dump, empty if it succeeded.
+	LastError string `json:"lastError,omitempty"`
+	// DumpInProgress reports whether counters are being written or cleared.
+	DumpInProgress bool `json:"dumpInProgress"`
+}
+
+var (
+	coverageStateMu sync.Mutex
+	coverageState   CoverageState
+)
+
+// CoverageStatus returns a snapshot of the coverage subsystem's state so a
+// test harness can verify collection is actually wired up before relying on it.
+func CoverageStatus() CoverageState {
+	coverageStateMu.Lock()
+	s := coverageState
+	coverageStateMu.Unlock()
+	s.CoverDir, s.Enabled = os.LookupEnv("GOCOVERDIR")
+	s.DumpInProgress = CoverageDumpInProgress()
+	return s
+}
+
+// recordCoverageDump updates the status after a dump attempt.
+func recordCoverageDump(dir string, err error) {
+	coverageStateMu.Lock()
+	defer coverageStateMu.Unlock()
+	if err != nil {
+		coverageState.LastError = err.Error()
+		return
+	}
+	coverageState.Dumps++
+	coverageState.LastDumpTime = time.Now().UTC()
+	coverageState.LastDumpDir = dir
+	coverageState.LastError = ""
+}
+
+// recordCoverageSignalHandler marks the signal handler as registered.
+func recordCoverageSignalHandler() {
+	coverageStateMu.Lock()
+	defer coverageStateMu.Unlock()
+	coverageState.SignalHandlerRegistered = true
+}
+
+// serveCoverageStatus writes CoverageStatus as JSON.
+func serveCoverageStatus(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(CoverageStatus())
+}
@@ -0,0 +1,47 @@
+package shared
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCoverageStatusRecordsDumps(t *testing.T) {
+	t.Setenv("GOCOVERDIR", "/cover")
+	before := CoverageStatus()
+
+	recordCoverageDump("/cover/a", nil)
+	recordCoverageDump("/cover/b", errors.New("boom"))
+
+	st := CoverageStatus()
+	if !st.Enabled || st.CoverDir != "/cover" {
+		t.Errorf("Enabled/CoverDir = %v/%q", st.Enabled, st.CoverDir)
+	}
+	if st.Dumps != before.Dumps+1 {
+		t.Errorf("Dumps = %d, want %d", st.Dumps, before.Dumps+1)
+	}
+	if st.LastDumpDir != "/cover/a" || st.LastDumpTime.IsZero() {
+		t.Errorf("LastDumpDir/LastDumpTime = %q/%v", st.LastDumpDir, st.LastDumpTime)
+	}
+	if st.LastError != "boom" {
+		t.Errorf("LastError = %q, want %q", st.LastError, "boom")
+	}
+}
+
+func TestCoverageStatusEndpoint(t *testing.T) {
+	t.Setenv("GOCOVERDIR", "/cover")
+	rec := httptest.NewRecorder()
+	CoverageHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/coverage/status", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status code = %d", rec.Code)
+	}
+	var st CoverageState
+	if err := json.NewDecoder(rec.Body).Decode(&st); err != nil {
+		t.Fatalf("decoding response: %v", err)
+	}
+	if !st.Enabled || st.CoverDir != "/cover" {
+		t.Errorf("got %+v", st)
+	}
+}
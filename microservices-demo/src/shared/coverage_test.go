@@ -0,0 +1,176 @@
+package shared
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestDumpCoverageWithoutDir(t *testing.T) {
+	if dir, ok := os.LookupEnv("GOCOVERDIR"); ok {
+		t.Setenv("GOCOVERDIR", dir)
+		os.Unsetenv("GOCOVERDIR")
+	}
+	if err := DumpCoverage(""); !errors.Is(err, ErrCoverageDisabled) {
+		t.Errorf("DumpCoverage(\"\") = %v, want %v", err, ErrCoverageDisabled)
+	}
+}
+
+func TestCoverageSignalOptions(t *testing.T) {
+	cfg := coverageConfig{}
+	for _, opt := range []CoverageOption{
+		WithDumpSignal(syscall.SIGUSR2),
+		WithDumpOnlySignal(syscall.SIGUSR1),
+		WithDumpOnShutdown(),
+	} {
+		opt(&cfg)
+	}
+
+	want := []coverageSignal{
+		{sig: syscall.SIGUSR2, clear: true},
+		{sig: syscall.SIGUSR1, clear: false},
+	}
+	if len(cfg.signals) != len(want) {
+		t.Fatalf("got %d signals, want %d", len(cfg.signals), len(want))
+	}
+	for i := range want {
+		if cfg.signals[i] != want[i] {
+			t.Errorf("signal %d: got %+v, want %+v", i, cfg.signals[i], want[i])
+		}
+	}
+	if !cfg.dumpOnShutdown {
+		t.Error("dumpOnShutdown not set")
+	}
+}
+
+func TestStartPeriodicCoverageDumpValidation(t *testing.T) {
+	t.Setenv("GOCOVERDIR", t.TempDir())
+	if err := StartPeriodicCoverageDump(context.Background(), 0); err == nil {
+		t.Error("expected error for zero interval")
+	}
+
+	os.Unsetenv("GOCOVERDIR")
+	if err := StartPeriodicCoverageDump(context.Background(), time.Minute); !errors.Is(err, ErrCoverageDisabled) {
+		t.Errorf("got %v, want %v", err, ErrCoverageDisabled)
+	}
+}
+
+func TestStartPeriodicCoverageDumpUsesClock(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("GOCOVERDIR", dir)
+	clk := NewFakeClock(time.Date(2026, 1, 2, 15, 4, 5, 0, time.UTC))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	if err := StartPeriodicCoverageDump(ctx, time.Minute, WithCoverageClock(clk)); err != nil {
+		t.Fatal(err)
+	}
+	clk.Advance(time.Minute)
+
+	// The snapshot directory is created before counters are written, so it
+	// appears even when the test binary is built without -cover.
+	snapshot := filepath.Join(dir, "20260102T150505.000Z")
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if _, err := os.Stat(snapshot); err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("snapshot directory %s not created after advancing the clock", snapshot)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestHasCoverageMeta(t *testing.T) {
+	dir := t.TempDir()
+	if hasCoverageMeta(dir) {
+		t.Error("empty dir reported as having meta-data")
+	}
+	if err := os.WriteFile(filepath.Join(dir, "covmeta.0123abcd"), nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if !hasCoverageMeta(dir) {
+		t.Error("covmeta file not detected")
+	}
+}
+
+// buildCoverageHelper builds the command in testdata/name with -cover and
+// returns its path.
+func buildCoverageHelper(t *testing.T, name string) string {
+	t.Helper()
+	gobin, err := exec.LookPath("go")
+	if err != nil {
+		t.Skip("go tool not available")
+	}
+	// Only a program built with -cover has counters to dump (in atomic
+	// mode, as the service images are built, to dump them live), and test
+	// binaries do not expose theirs to runtime/coverage.
+	bin := filepath.Join(t.TempDir(), name)
+	if out, err := exec.Command(gobin, "build", "-cover", "-covermode=atomic", "-o", bin, "./testdata/"+name).CombinedOutput(); err != nil {
+		t.Fatalf("building helper: %v\n%s", err, out)
+	}
+	return bin
+}
+
+func TestDumpOnShutdownSubprocess(t *testing.T) {
+	if testing.Short() {
+		t.Skip("builds a -cover binary")
+	}
+	bin := buildCoverageHelper(t, "coverageshutdown")
+
+	dir := t.TempDir()
+	cmd := exec.Command(bin)
+	cmd.Env = append(os.Environ(), "GOCOVERDIR="+dir)
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer cmd.Process.Kill()
+	if line, _ := bufio.NewReader(out).ReadString('\n'); line != "ready\n" {
+		t.Fatalf("helper said %q", line)
+	}
+
+	if err := cmd.Process.Signal(syscall.SIGTERM); err != nil {
+		t.Fatal(err)
+	}
+	err = cmd.Wait()
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) {
+		t.Fatalf("helper exited with %v, want killed by SIGTERM", err)
+	}
+	if ws, ok := exitErr.Sys().(syscall.WaitStatus); !ok || !ws.Signaled() || ws.Signal() != syscall.SIGTERM {
+		t.Errorf("helper exit status = %v, want killed by SIGTERM", exitErr)
+	}
+	for _, pattern := range []string{"covmeta.*", "covcounters.*"} {
+		if m, _ := filepath.Glob(filepath.Join(dir, pattern)); len(m) == 0 {
+			t.Errorf("no %s written to GOCOVERDIR", pattern)
+		}
+	}
+}
+
+func TestDumpCoverageUploadsSubprocess(t *testing.T) {
+	if testing.Short() {
+		t.Skip("builds a -cover binary")
+	}
+	bin := buildCoverageHelper(t, "coveragedump")
+
+	upload := t.TempDir()
+	cmd := exec.Command(bin)
+	cmd.Env = append(os.Environ(), "GOCOVERDIR="+t.TempDir(), "GOCOV_UPLOAD_URL=file://"+upload)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("helper: %v\n%s", err, out)
+	}
+	if m, _ := filepath.Glob(filepath.Join(upload, "*", "*.tar.gz")); len(m) != 1 {
+		t.Errorf("uploaded archives = %v, want one", m)
+	}
+}
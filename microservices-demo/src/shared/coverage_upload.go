@@ -0,0 +1,380 @@
+package shared
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CoverageStorage is a destination for archived coverage dumps. Implementations
+// must be safe for concurrent use.
+type CoverageStorage interface {
+	// Upload stores the contents of r under the object name.
+	Upload(ctx context.Context, name string, r io.Reader) error
+}
+
+const (
+	coverageUploadAttempts = 3
+	coverageUploadBackoff  = time.Second
+	coverageUploadTimeout  = 2 * time.Minute
+)
+
+var (
+	coverageStorageMu   sync.Mutex
+	coverageStorage     CoverageStorage
+	coverageStorageInit bool
+)
+
+// coverageHTTPClient sends uploads and metadata requests. Uploads are bounded
+// by coverageUploadTimeout and retried by uploadCoverageDir, not per request.
+var coverageHTTPClient = NewHTTPClient(WithHTTPClientName("coverage"),
+	WithHTTPClientTimeout(0), WithHTTPAttemptTimeout(0), WithoutHTTPRetry())
+
+// SetCoverageStorage installs the backend that every dump is uploaded to after
+// it has been written locally. Passing nil disables uploads. It overrides any
+// backend configured through GOCOV_UPLOAD_URL.
+func SetCoverageStorage(s CoverageStorage) {
+	coverageStorageMu.Lock()
+	defer coverageStorageMu.Unlock()
+	coverageStorage = s
+	coverageStorageInit = true
+}
+
+// currentCoverageStorage returns the configured backend, lazily building one
+// from GOCOV_UPLOAD_URL on first use.
+func currentCoverageStorage() CoverageStorage {
+	coverageStorageMu.Lock()
+	defer coverageStorageMu.Unlock()
+	if !coverageStorageInit {
+		coverageStorageInit = true
+		if raw := os.Getenv("GOCOV_UPLOAD_URL"); raw != "" {
+			s, err := NewCoverageStorage(raw)
+			if err != nil {
+				log.Printf("Coverage: Upload disabled: %v", err)
+			} else {
+				coverageStorage = s
+				log.Printf("Coverage: Uploading dumps to %s", raw)
+			}
+		}
+	}
+	return coverageStorage
+}
+
+// NewCoverageStorage builds a CoverageStorage from a URL. Supported schemes:
+//
+//	file:///mnt/coverage            copy archives into a mounted volume
+//	http(s)://host/bucket/prefix    plain HTTP PUT (MinIO, presigned proxies);
+//	                                GOCOV_UPLOAD_TOKEN is sent as a bearer token
+//	gs://bucket/prefix              Google Cloud Storage, authenticated with
+//	                                GOCOV_UPLOAD_TOKEN or the GCE metadata server
+//	s3://bucket/prefix              Amazon S3 (or MinIO via GOCOV_S3_ENDPOINT),
+//	                                signed with AWS_ACCESS_KEY_ID,
+//	                                AWS_SECRET_ACCESS_KEY, AWS_SESSION_TOKEN
+//	                                (if set) and AWS_REGION
+func NewCoverageStorage(rawURL string) (CoverageStorage, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("coverage: parsing upload URL: %w", err)
+	}
+	prefix := strings.Trim(u.Path, "/")
+	switch u.Scheme {
+	case "file":
+		return fileCoverageStorage{dir: u.Path}, nil
+	case "http", "https":
+		return &httpCoverageStorage{base: strings.TrimSuffix(rawURL, "/"), token: staticToken(os.Getenv("GOCOV_UPLOAD_TOKEN"))}, nil
+	case "gs":
+		token := staticToken(os.Getenv("GOCOV_UPLOAD_TOKEN"))
+		if os.Getenv("GOCOV_UPLOAD_TOKEN") == "" {
+			token = gceMetadataToken
+		}
+		base := "https://storage.googleapis.com/" + path.Join(u.Host, prefix)
+		return &httpCoverageStorage{base: base, token: token}, nil
+	case "s3":
+		return newS3CoverageStorage(u.Host, prefix)
+	default:
+		return nil, fmt.Errorf("coverage: unsupported upload scheme %q", u.Scheme)
+	}
+}
+
+// uploadCoverageDir archives the counter files in dir and uploads them to the
+// configured backend, if any. It retries transient failures.
+func uploadCoverageDir(dir string) {
+	storage := currentCoverageStorage()
+	if storage == nil {
+		return
+	}
+
+	archive, err := tarCoverageDir(dir)
+	if err != nil {
+		log.Printf("Coverage: Error archiving %s: %v", dir, err)
+		return
+	}
+	host, _ := os.Hostname()
+	name := path.Join(host, filepath.Base(dir)+"-"+time.Now().UTC().Format(coverageTimestampFormat)+".tar.gz")
+
+	ctx, cancel := context.WithTimeout(context.Background(), coverageUploadTimeout)
+	defer cancel()
+	attempts := 0
+	err = Retry(ctx, RetryPolicy{
+		MaxAttempts:    coverageUploadAttempts,
+		InitialBackoff: coverageUploadBackoff,
+		Multiplier:     2,
+		Retryable:      func(error) bool { return true },
+		OnRetry: func(attempt int, delay time.Duration, err error) {
+			log.Printf("Coverage: Upload attempt %d failed, retrying in %v: %v", attempt, delay, err)
+		},
+	}, func(ctx context.Context) error {
+		attempts++
+		return storage.Upload(ctx, name, bytes.NewReader(archive))
+	})
+	if err != nil {
+		log.Printf("Coverage: Error uploading %s after %d attempts: %v", name, attempts, err)
+		return
+	}
+	log.Printf("Coverage: Uploaded %s", name)
+}
+
+// tarCoverageDir returns a gzipped tarball of the regular files directly in dir.
+func tarCoverageDir(dir string) ([]byte, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	for _, e := range entries {
+		if !e.Type().IsRegular() {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			return nil, err
+		}
+		hdr, err := tar.FileInfoHeader(info, "")
+		if err != nil {
+			return nil, err
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return nil, err
+		}
+		data, err := os.ReadFile(filepath.Join(dir, e.Name()))
+		if err != nil {
+			return nil, err
+		}
+		if _, err := tw.Write(data); err != nil {
+			return nil, err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return nil, err
+	}
+	if err := gz.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// fileCoverageStorage copies archives into a local directory.
+type fileCoverageStorage struct {
+	dir string
+}
+
+func (s fileCoverageStorage) Upload(ctx context.Context, name string, r io.Reader) error {
+	dst := filepath.Join(s.dir, filepath.FromSlash(name))
+	if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
+		return err
+	}
+	f, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// tokenSource returns a bearer token, or "" for anonymous requests.
+type tokenSource func(ctx context.Context) (string, error)
+
+func staticToken(token string) tokenSource {
+	return func(context.Context) (string, error) { return token, nil }
+}
+
+// gceMetadataToken fetches an access token for the default service account.
+func gceMetadataToken(ctx context.Context) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet,
+		"http://metadata.google.internal/computeMetadata/v1/instance/service-accounts/default/token", nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Metadata-Flavor", "Google")
+	resp, err := coverageHTTPClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("metadata server returned %s", resp.Status)
+	}
+	var tok struct {
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&tok); err != nil {
+		return "", err
+	}
+	return tok.AccessToken, nil
+}
+
+// httpCoverageStorage PUTs archives to <base>/<name>.
+type httpCoverageStorage struct {
+	base  string
+	token tokenSource
+}
+
+func (s *httpCoverageStorage) Upload(ctx context.Context, name string, r io.Reader) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, s.base+"/"+name, r)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/gzip")
+	token, err := s.token(ctx)
+	if err != nil {
+		return fmt.Errorf("obtaining upload token: %w", err)
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	return doCoverageUpload(req)
+}
+
+// s3CoverageStorage uploads to S3-compatible storage using AWS Signature V4.
+type s3CoverageStorage struct {
+	endpoint  string // scheme://host, no trailing slash
+	bucket    string
+	prefix    string
+	region    string
+	accessKey string
+	secretKey string
+	// sessionToken accompanies temporary credentials, such as those from an
+	// assumed role.
+	sessionToken string
+}
+
+func newS3CoverageStorage(bucket, prefix string) (*s3CoverageStorage, error) {
+	s := &s3CoverageStorage{
+		bucket:       bucket,
+		prefix:       prefix,
+		region:       os.Getenv("AWS_REGION"),
+		accessKey:    os.Getenv("AWS_ACCESS_KEY_ID"),
+		secretKey:    os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		sessionToken: os.Getenv("AWS_SESSION_TOKEN"),
+		endpoint:     strings.TrimSuffix(os.Getenv("GOCOV_S3_ENDPOINT"), "/"),
+	}
+	if s.region == "" {
+		s.region = "us-east-1"
+	}
+	if s.accessKey == "" || s.secretKey == "" {
+		return nil, errors.New("coverage: s3 upload requires AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY")
+	}
+	if s.endpoint == "" {
+		s.endpoint = "https://s3." + s.region + ".amazonaws.com"
+	}
+	return s, nil
+}
+
+func (s *s3CoverageStorage) Upload(ctx context.Context, name string, r io.Reader) error {
+	body, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	// Path-style addressing works for both AWS and MinIO.
+	key := path.Join(s.bucket, s.prefix, name)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, s.endpoint+"/"+key, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/gzip")
+	s.sign(req, body, time.Now().UTC())
+	return doCoverageUpload(req)
+}
+
+// sign adds AWS Signature Version 4 headers to req.
+func (s *s3CoverageStorage) sign(req *http.Request, body []byte, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	day := now.Format("20060102")
+	payloadHash := sha256Hex(body)
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	signedHeaders := "content-type;host;x-amz-content-sha256;x-amz-date"
+	canonicalHeaders := "content-type:" + req.Header.Get("Content-Type") + "\n" +
+		"host:" + req.URL.Host + "\n" +
+		"x-amz-content-sha256:" + payloadHash + "\n" +
+		"x-amz-date:" + amzDate + "\n"
+	if s.sessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", s.sessionToken)
+		signedHeaders += ";x-amz-security-token"
+		canonicalHeaders += "x-amz-security-token:" + s.sessionToken + "\n"
+	}
+	canonicalRequest := strings.Join([]string{
+		req.Method, req.URL.EscapedPath(), req.URL.RawQuery,
+		canonicalHeaders, signedHeaders, payloadHash,
+	}, "\n")
+
+	scope := day + "/" + s.region + "/s3/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + sha256Hex([]byte(canonicalRequest))
+
+	key := hmacSHA256([]byte("AWS4"+s.secretKey), day)
+	key = hmacSHA256(key, s.region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+s.accessKey+"/"+scope+
+		", SignedHeaders="+signedHeaders+", Signature="+signature)
+}
+
+func sha256Hex(b []byte) string {
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	m := hmac.New(sha256.New, key)
+	m.Write([]byte(data))
+	return m.Sum(nil)
+}
+
+// doCoverageUpload sends req and maps non-2xx responses to errors.
+func doCoverageUpload(req *http.Request) error {
+	resp, err := coverageHTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("upload to %s returned %s: %s", req.URL.Redacted(), resp.Status, bytes.TrimSpace(msg))
+	}
+	return nil
+}
@@ -0,0 +1,134 @@
+package shared
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestTarCoverageDir(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"covcounters.a", "covmeta.b"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(name), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Mkdir(filepath.Join(dir, "nested"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	archive, err := tarCoverageDir(dir)
+	if err != nil {
+		t.Fatalf("tarCoverageDir: %v", err)
+	}
+	gz, err := gzip.NewReader(bytes.NewReader(archive))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr := tar.NewReader(gz)
+	var names []string
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, hdr.Name)
+	}
+	if got := strings.Join(names, ","); got != "covcounters.a,covmeta.b" {
+		t.Errorf("archive entries = %s", got)
+	}
+}
+
+func TestNewCoverageStorage(t *testing.T) {
+	for _, raw := range []string{"file:///tmp/cov", "https://minio:9000/bucket/prefix", "gs://bucket/prefix"} {
+		if _, err := NewCoverageStorage(raw); err != nil {
+			t.Errorf("NewCoverageStorage(%q): %v", raw, err)
+		}
+	}
+	if _, err := NewCoverageStorage("ftp://host/x"); err == nil {
+		t.Error("expected error for unsupported scheme")
+	}
+	t.Setenv("AWS_ACCESS_KEY_ID", "")
+	if _, err := NewCoverageStorage("s3://bucket/prefix"); err == nil {
+		t.Error("expected error for s3 without credentials")
+	}
+}
+
+func TestUploadCoverageDirRetries(t *testing.T) {
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPut || !strings.HasPrefix(r.URL.Path, "/bucket/") {
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+		}
+		if calls.Add(1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	storage, err := NewCoverageStorage(srv.URL + "/bucket")
+	if err != nil {
+		t.Fatal(err)
+	}
+	SetCoverageStorage(storage)
+	defer SetCoverageStorage(nil)
+
+	uploadCoverageDir(t.TempDir())
+	if got := calls.Load(); got != 2 {
+		t.Errorf("upload attempts = %d, want 2", got)
+	}
+}
+
+func TestFileCoverageStorage(t *testing.T) {
+	dir := t.TempDir()
+	s := fileCoverageStorage{dir: dir}
+	if err := s.Upload(context.Background(), "pod-1/run.tar.gz", strings.NewReader("data")); err != nil {
+		t.Fatalf("Upload: %v", err)
+	}
+	got, err := os.ReadFile(filepath.Join(dir, "pod-1", "run.tar.gz"))
+	if err != nil || string(got) != "data" {
+		t.Errorf("uploaded file = %q, %v", got, err)
+	}
+}
+
+func TestS3SignSessionToken(t *testing.T) {
+	now := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	sign := func(token string) *http.Request {
+		s := &s3CoverageStorage{region: "us-east-1", accessKey: "AKID", secretKey: "secret", sessionToken: token}
+		req := httptest.NewRequest(http.MethodPut, "https://s3.us-east-1.amazonaws.com/bucket/run.tar.gz", nil)
+		req.Header.Set("Content-Type", "application/gzip")
+		s.sign(req, []byte("data"), now)
+		return req
+	}
+
+	plain := sign("")
+	if h := plain.Header.Get("X-Amz-Security-Token"); h != "" {
+		t.Errorf("X-Amz-Security-Token = %q without a session token", h)
+	}
+	withToken := sign("tok")
+	if h := withToken.Header.Get("X-Amz-Security-Token"); h != "tok" {
+		t.Errorf("X-Amz-Security-Token = %q, want tok", h)
+	}
+	auth := withToken.Header.Get("Authorization")
+	if !strings.Contains(auth, "SignedHeaders=content-type;host;x-amz-content-sha256;x-amz-date;x-amz-security-token,") {
+		t.Errorf("Authorization = %q, want the token among the signed headers", auth)
+	}
+	if auth == plain.Header.Get("Authorization") {
+		t.Error("session token does not change the signature")
+	}
+}
@@ -0,0 +1,147 @@
+// Package crypto encrypts data at rest, such as the addresses and email
+// addresses services persist about customers.
+//
+// Seal and Open are the primitives: authenticated encryption with
+// AES-256-GCM or XChaCha20-Poly1305 under a random nonce. DeriveKey derives
+// independent keys from one secret with HKDF-SHA256.
+//
+// Envelope builds envelope encryption on them: every message is encrypted
+// under a fresh data key (DEK), which is wrapped by a key-encryption key
+// (KEK) read from package secrets and stored alongside the ciphertext. The
+// KEK never leaves the process, and rotating it only re-wraps data keys
+// (see Envelope.Rewrap). EncryptFields and DecryptFields encrypt the string
+// fields of a struct tagged `encrypt`, in place, before it is persisted:
+//
+//	type Customer struct {
+//	    ID      string
+//	    Email   string `encrypt:""`
+//	    Address Address
+//	}
+//	type Address struct {
+//	    Street string `encrypt:"street"`
+//	    City   string
+//	}
+//
+//	env, err := appcrypto.New(ctx, cfg)
+//	if err := env.EncryptFields(ctx, &c, c.ID); err != nil { ... }
+//
+// The package name shadows the standard library; import it under another
+// name:
+//
+//	import appcrypto "github.com/GoogleCloudPlatform/microservices-demo/src/shared/crypto"
+package crypto
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/hkdf"
+)
+
+// Algorithm names an authenticated encryption algorithm.
+type Algorithm string
+
+const (
+	// AESGCM is AES-256 in Galois/Counter Mode, hardware-accelerated on
+	// most servers. Its 96-bit random nonces limit a key to about 2^32
+	// messages, which Envelope avoids by using each data key once.
+	AESGCM Algorithm = "aes-256-gcm"
+	// XChaCha20Poly1305 has 192-bit nonces, safe to pick at random for any
+	// number of messages, and is fast without AES instructions.
+	XChaCha20Poly1305 Algorithm = "xchacha20-poly1305"
+)
+
+// KeySize is the key length, in bytes, of both algorithms.
+const KeySize = 32
+
+// ErrDecrypt is returned when a ciphertext fails authentication: it was
+// modified, truncated, or sealed under another key or additional data.
+var ErrDecrypt = errors.New("crypto: message authentication failed")
+
+// NewAEAD returns alg keyed with key, which must be KeySize bytes.
+func NewAEAD(alg Algorithm, key []byte) (cipher.AEAD, error) {
+	if len(key) != KeySize {
+		return nil, fmt.Errorf("crypto: key is %d bytes, want %d", len(key), KeySize)
+	}
+	switch alg {
+	case AESGCM:
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, err
+		}
+		return cipher.NewGCM(block)
+	case XChaCha20Poly1305:
+		return chacha20poly1305.NewX(key)
+	}
+	return nil, fmt.Errorf("crypto: unknown algorithm %q", alg)
+}
+
+// Seal encrypts plaintext and authenticates it together with aad, which is
+// not encrypted but must be passed to Open unchanged. The result is a
+// random nonce followed by the ciphertext.
+func Seal(alg Algorithm, key, plaintext, aad []byte) ([]byte, error) {
+	aead, err := NewAEAD(alg, key)
+	if err != nil {
+		return nil, err
+	}
+	return seal(aead, plaintext, aad)
+}
+
+// Open decrypts a message sealed by Seal, returning ErrDecrypt if it does
+// not authenticate.
+func Open(alg Algorithm, key, sealed, aad []byte) ([]byte, error) {
+	aead, err := NewAEAD(alg, key)
+	if err != nil {
+		return nil, err
+	}
+	return open(aead, sealed, aad)
+}
+
+func seal(aead cipher.AEAD, plaintext, aad []byte) ([]byte, error) {
+	out := make([]byte, aead.NonceSize(), aead.NonceSize()+len(plaintext)+aead.Overhead())
+	if _, err := io.ReadFull(rand.Reader, out); err != nil {
+		return nil, fmt.Errorf("crypto: generating nonce: %w", err)
+	}
+	return aead.Seal(out, out, plaintext, aad), nil
+}
+
+func open(aead cipher.AEAD, sealed, aad []byte) ([]byte, error) {
+	n := aead.NonceSize()
+	if len(sealed) < n+aead.Overhead() {
+		return nil, ErrDecrypt
+	}
+	plaintext, err := aead.Open(nil, sealed[:n], sealed[n:], aad)
+	if err != nil {
+		return nil, ErrDecrypt
+	}
+	return plaintext, nil
+}
+
+// DeriveKey derives a KeySize key from secret with HKDF-SHA256. Different
+// infos, such as "sessions" and "exports", give independent keys from the
+// same secret; salt may be nil.
+func DeriveKey(secret, salt []byte, info string) ([]byte, error) {
+	if len(secret) == 0 {
+		return nil, errors.New("crypto: empty secret")
+	}
+	key := make([]byte, KeySize)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, secret, salt, []byte(info)), key); err != nil {
+		return nil, fmt.Errorf("crypto: deriving key: %w", err)
+	}
+	return key, nil
+}
+
+// NewKey returns a random KeySize key.
+func NewKey() ([]byte, error) {
+	key := make([]byte, KeySize)
+	if _, err := io.ReadFull(rand.Reader, key); err != nil {
+		return nil, fmt.Errorf("crypto: generating key: %w", err)
+	}
+	return key, nil
+}
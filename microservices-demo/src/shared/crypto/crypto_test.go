@@ -0,0 +1,191 @@
+package crypto
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/GoogleCloudPlatform/microservices-demo/src/shared/secrets"
+)
+
+func TestSealOpen(t *testing.T) {
+	key, _ := NewKey()
+	for _, alg := range []Algorithm{AESGCM, XChaCha20Poly1305} {
+		sealed, err := Seal(alg, key, []byte("1600 Amphitheatre Parkway"), []byte("user-1"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := Open(alg, key, sealed, []byte("user-1"))
+		if err != nil || string(got) != "1600 Amphitheatre Parkway" {
+			t.Errorf("%s: Open = %q, %v", alg, got, err)
+		}
+		if _, err := Open(alg, key, sealed, []byte("user-2")); !errors.Is(err, ErrDecrypt) {
+			t.Errorf("%s: Open with other aad = %v, want ErrDecrypt", alg, err)
+		}
+		sealed[len(sealed)-1] ^= 1
+		if _, err := Open(alg, key, sealed, []byte("user-1")); !errors.Is(err, ErrDecrypt) {
+			t.Errorf("%s: Open of a modified ciphertext = %v, want ErrDecrypt", alg, err)
+		}
+	}
+	if _, err := Seal(AESGCM, key[:16], nil, nil); err == nil {
+		t.Error("Seal accepted a 16-byte key")
+	}
+}
+
+func TestDeriveKey(t *testing.T) {
+	a, _ := DeriveKey([]byte("master"), nil, "sessions")
+	b, _ := DeriveKey([]byte("master"), nil, "exports")
+	again, _ := DeriveKey([]byte("master"), nil, "sessions")
+	if len(a) != KeySize || bytes.Equal(a, b) || !bytes.Equal(a, again) {
+		t.Errorf("DeriveKey: sessions %x, exports %x, sessions again %x", a, b, again)
+	}
+}
+
+func keyLine(t *testing.T, id string) string {
+	t.Helper()
+	key, _ := NewKey()
+	return id + " " + base64.StdEncoding.EncodeToString(key) + "\n"
+}
+
+func newEnvelope(t *testing.T, keyring secrets.MapBackend, alg Algorithm) *Envelope {
+	t.Helper()
+	store := secrets.New([]secrets.Backend{keyring}, secrets.WithTTL(0))
+	e, err := New(context.Background(), Config{Algorithm: alg}, WithStore(store))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return e
+}
+
+func TestEnvelopeRotation(t *testing.T) {
+	ctx := context.Background()
+	old := keyLine(t, "2026-04")
+	keyring := secrets.MapBackend{"encryption-keys": old}
+	e := newEnvelope(t, keyring, XChaCha20Poly1305)
+
+	ct, err := e.Encrypt(ctx, []byte("alice@example.com"), []byte("user-1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(ct, []byte("alice")) {
+		t.Fatal("ciphertext contains the plaintext")
+	}
+	if id, _ := KeyID(ct); id != "2026-04" {
+		t.Errorf("KeyID = %q", id)
+	}
+
+	// A new first line takes over encryption; the old KEK still decrypts.
+	keyring["encryption-keys"] = keyLine(t, "2026-10") + old
+	rewrapped, err := e.Rewrap(ctx, ct)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id, _ := KeyID(rewrapped); id != "2026-10" {
+		t.Errorf("KeyID after Rewrap = %q", id)
+	}
+	for _, c := range [][]byte{ct, rewrapped} {
+		if got, err := e.Decrypt(ctx, c, []byte("user-1")); err != nil || string(got) != "alice@example.com" {
+			t.Errorf("Decrypt = %q, %v", got, err)
+		}
+	}
+	if again, _ := e.Rewrap(ctx, rewrapped); !bytes.Equal(again, rewrapped) {
+		t.Error("Rewrap changed a ciphertext already under the current KEK")
+	}
+
+	// Once the old KEK is removed, only rewrapped data still decrypts.
+	keyring["encryption-keys"] = strings.Split(keyring["encryption-keys"], "\n")[0]
+	if _, err := e.Decrypt(ctx, ct, []byte("user-1")); !errors.Is(err, ErrUnknownKey) {
+		t.Errorf("Decrypt under a removed KEK = %v, want ErrUnknownKey", err)
+	}
+	if _, err := e.Decrypt(ctx, rewrapped, []byte("user-1")); err != nil {
+		t.Error(err)
+	}
+
+	// A broken revision keeps the previous keyring.
+	keyring["encryption-keys"] = "2027-01 not-base64"
+	if _, err := e.Decrypt(ctx, rewrapped, []byte("user-1")); err != nil {
+		t.Errorf("Decrypt after a broken keyring revision = %v", err)
+	}
+}
+
+func TestEnvelopeTampering(t *testing.T) {
+	ctx := context.Background()
+	e := newEnvelope(t, secrets.MapBackend{"encryption-keys": keyLine(t, "k1")}, AESGCM)
+	ct, _ := e.Encrypt(ctx, []byte("secret"), nil)
+	for i := range ct {
+		c := bytes.Clone(ct)
+		c[i] ^= 0x80
+		if _, err := e.Decrypt(ctx, c, nil); err == nil {
+			t.Fatalf("Decrypt accepted a ciphertext modified at byte %d", i)
+		}
+	}
+	if _, err := e.Decrypt(ctx, ct[:len(ct)-1], nil); !errors.Is(err, ErrDecrypt) {
+		t.Errorf("Decrypt of a truncated ciphertext = %v", err)
+	}
+}
+
+type address struct {
+	Street string `encrypt:"street"`
+	City   string
+}
+
+type customer struct {
+	ID       string
+	Email    string `encrypt:""`
+	Phone    string `encrypt:""`
+	Address  address
+	Previous []*address
+}
+
+func TestEncryptFields(t *testing.T) {
+	ctx := context.Background()
+	e := newEnvelope(t, secrets.MapBackend{"encryption-keys": keyLine(t, "k1")}, AESGCM)
+	c := customer{
+		ID:       "user-1",
+		Email:    "alice@example.com",
+		Address:  address{Street: "1600 Amphitheatre Parkway", City: "Mountain View"},
+		Previous: []*address{{Street: "345 Spear St", City: "San Francisco"}, nil},
+	}
+	if err := e.EncryptFields(ctx, &c, c.ID); err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []string{c.Email, c.Address.Street, c.Previous[0].Street} {
+		if !strings.HasPrefix(s, Prefix) {
+			t.Errorf("field left as %q", s)
+		}
+	}
+	if c.Phone != "" || c.Address.City != "Mountain View" {
+		t.Errorf("untagged or empty fields changed: %+v", c)
+	}
+	enc := c.Email
+	if err := e.EncryptFields(ctx, &c, c.ID); err != nil || c.Email != enc {
+		t.Error("EncryptFields re-encrypted an encrypted field")
+	}
+
+	// A value moved to another record or field does not decrypt.
+	moved := customer{Email: c.Email}
+	if err := e.DecryptFields(ctx, &moved, "user-2"); !errors.Is(err, ErrDecrypt) {
+		t.Errorf("DecryptFields for another record = %v, want ErrDecrypt", err)
+	}
+	swapped := customer{Address: address{Street: c.Email}}
+	if err := e.DecryptFields(ctx, &swapped, "user-1"); !errors.Is(err, ErrDecrypt) {
+		t.Errorf("DecryptFields of a swapped field = %v, want ErrDecrypt", err)
+	}
+
+	if err := e.DecryptFields(ctx, &c, c.ID); err != nil {
+		t.Fatal(err)
+	}
+	if c.Email != "alice@example.com" || c.Address.Street != "1600 Amphitheatre Parkway" || c.Previous[0].Street != "345 Spear St" {
+		t.Errorf("DecryptFields = %+v", c)
+	}
+
+	var bad struct {
+		Age int `encrypt:""`
+	}
+	if err := e.EncryptFields(ctx, &bad, ""); err == nil {
+		t.Error("EncryptFields accepted a tagged int field")
+	}
+}
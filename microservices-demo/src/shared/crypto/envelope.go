@@ -0,0 +1,351 @@
+package crypto
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+
+	shared "github.com/GoogleCloudPlatform/microservices-demo/src/shared"
+	"github.com/GoogleCloudPlatform/microservices-demo/src/shared/metrics"
+	"github.com/GoogleCloudPlatform/microservices-demo/src/shared/secrets"
+)
+
+// ErrUnknownKey is returned when a ciphertext was wrapped by a KEK that is
+// no longer in the keyring.
+var ErrUnknownKey = errors.New("crypto: unknown key-encryption key")
+
+// version is the first byte of every envelope.
+const version = 1
+
+var algorithmIDs = map[Algorithm]byte{AESGCM: 1, XChaCha20Poly1305: 2}
+
+var operationsTotal = metrics.NewCounterVec("envelope_operations_total",
+	"Envelope encryption operations, by operation (encrypt, decrypt, rewrap) and result (ok, error).", "operation", "result")
+
+// Config configures an Envelope.
+type Config struct {
+	// Secret names the KEK keyring secret. It holds one KEK per line, as an
+	// ID and 32 base64-encoded bytes; the first line encrypts, and every
+	// line decrypts, so a KEK is rotated by adding a new first line and
+	// removing the old one once Rewrap has run over the stored data:
+	//
+	//	# id     key
+	//	2026-10  Jm5yV2x0c0s4dFlQbWNncnRxaFpJdEc2T1o3bVNlQkU=
+	//	2026-04  q0o1ZKxW3bJ8YH0tE9dC6nq2Lr5uVfTgA7sMiPwXyDc=
+	Secret string `env:"ENCRYPTION_KEYS_SECRET" default:"encryption-keys"`
+	// Algorithm encrypts new data. Existing ciphertexts record their own.
+	Algorithm Algorithm `env:"ENCRYPTION_ALGORITHM" default:"aes-256-gcm"`
+}
+
+// ConfigFromEnv loads a Config from the environment.
+func ConfigFromEnv() (Config, error) {
+	var c Config
+	err := shared.LoadConfig(&c)
+	return c, err
+}
+
+// Option configures New.
+type Option func(*Envelope)
+
+// WithStore reads the keyring from s instead of secrets.Default().
+func WithStore(s *secrets.Store) Option {
+	return func(e *Envelope) { e.store = s }
+}
+
+// Envelope encrypts data under single-use data keys wrapped by the KEKs of
+// a keyring.
+//
+// Its output is self-describing:
+//
+//	version (1) | algorithm (1) | len(kek id) (1) | kek id | len(dek) (2) | wrapped dek | sealed data
+//
+// The wrapped data key is authenticated with the header before it; the data
+// with the version, algorithm and caller's additional data.
+type Envelope struct {
+	cfg   Config
+	store *secrets.Store
+
+	mu      sync.Mutex
+	raw     string // the secret as last parsed
+	primary string
+	keks    map[string][]byte
+}
+
+// New returns an Envelope, failing if the keyring secret cannot be read or
+// parsed.
+func New(ctx context.Context, cfg Config, opts ...Option) (*Envelope, error) {
+	if cfg.Secret == "" {
+		cfg.Secret = "encryption-keys"
+	}
+	if cfg.Algorithm == "" {
+		cfg.Algorithm = AESGCM
+	}
+	if _, ok := algorithmIDs[cfg.Algorithm]; !ok {
+		return nil, fmt.Errorf("crypto: unknown algorithm %q", cfg.Algorithm)
+	}
+	e := &Envelope{cfg: cfg}
+	for _, opt := range opts {
+		opt(e)
+	}
+	if e.store == nil {
+		e.store = secrets.Default()
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if err := e.load(ctx); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// Encrypt seals plaintext, authenticating aad with it. Pass the same aad,
+// such as the ID of the record the value belongs to, to Decrypt.
+func (e *Envelope) Encrypt(ctx context.Context, plaintext, aad []byte) ([]byte, error) {
+	out, err := e.encrypt(ctx, plaintext, aad)
+	count("encrypt", err)
+	return out, err
+}
+
+func (e *Envelope) encrypt(ctx context.Context, plaintext, aad []byte) ([]byte, error) {
+	id, kek := e.current(ctx)
+	dek, err := NewKey()
+	if err != nil {
+		return nil, err
+	}
+	alg := algorithmIDs[e.cfg.Algorithm]
+	header, err := wrap(alg, id, kek, dek)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := NewAEAD(e.cfg.Algorithm, dek)
+	if err != nil {
+		return nil, err
+	}
+	sealed, err := seal(aead, plaintext, dataAAD(alg, aad))
+	if err != nil {
+		return nil, err
+	}
+	return append(header, sealed...), nil
+}
+
+// Decrypt opens a ciphertext made by Encrypt, returning ErrDecrypt if it or
+// aad was altered and ErrUnknownKey if its KEK has left the keyring.
+func (e *Envelope) Decrypt(ctx context.Context, ciphertext, aad []byte) ([]byte, error) {
+	out, err := e.decrypt(ctx, ciphertext, aad)
+	count("decrypt", err)
+	return out, err
+}
+
+func (e *Envelope) decrypt(ctx context.Context, ciphertext, aad []byte) ([]byte, error) {
+	h, err := parseHeader(ciphertext)
+	if err != nil {
+		return nil, err
+	}
+	dek, err := e.unwrap(ctx, h)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := NewAEAD(h.alg, dek)
+	if err != nil {
+		return nil, err
+	}
+	return open(aead, ciphertext[h.size:], dataAAD(h.algID, aad))
+}
+
+// Rewrap re-wraps the data key of ciphertext under the current KEK, without
+// decrypting the data, and returns ciphertext unchanged if it already uses
+// that KEK. Run it over stored data after rotating the keyring.
+func (e *Envelope) Rewrap(ctx context.Context, ciphertext []byte) ([]byte, error) {
+	out, err := e.rewrap(ctx, ciphertext)
+	count("rewrap", err)
+	return out, err
+}
+
+func (e *Envelope) rewrap(ctx context.Context, ciphertext []byte) ([]byte, error) {
+	h, err := parseHeader(ciphertext)
+	if err != nil {
+		return nil, err
+	}
+	id, kek := e.current(ctx)
+	if h.kekID == id {
+		return ciphertext, nil
+	}
+	dek, err := e.unwrap(ctx, h)
+	if err != nil {
+		return nil, err
+	}
+	header, err := wrap(h.algID, id, kek, dek)
+	if err != nil {
+		return nil, err
+	}
+	return append(header, ciphertext[h.size:]...), nil
+}
+
+// KeyID returns the ID of the KEK that wraps the data key of ciphertext.
+func KeyID(ciphertext []byte) (string, error) {
+	h, err := parseHeader(ciphertext)
+	return h.kekID, err
+}
+
+func count(op string, err error) {
+	result := "ok"
+	if err != nil {
+		result = "error"
+	}
+	operationsTotal.WithLabelValues(op, result).Inc()
+}
+
+// header is the parsed prefix of an envelope.
+type header struct {
+	algID   byte
+	alg     Algorithm
+	kekID   string
+	wrapped []byte
+	size    int // length of the whole header
+}
+
+// wrap returns the header of an envelope carrying dek wrapped by kek.
+func wrap(alg byte, id string, kek, dek []byte) ([]byte, error) {
+	b := []byte{version, alg, byte(len(id))}
+	b = append(b, id...)
+	// A KEK wraps far more data keys than AES-GCM's random nonces allow
+	// for, so data keys are always wrapped with XChaCha20-Poly1305.
+	aead, err := NewAEAD(XChaCha20Poly1305, kek)
+	if err != nil {
+		return nil, err
+	}
+	wrapped, err := seal(aead, dek, b)
+	if err != nil {
+		return nil, err
+	}
+	b = binary.BigEndian.AppendUint16(b, uint16(len(wrapped)))
+	return append(b, wrapped...), nil
+}
+
+func parseHeader(b []byte) (header, error) {
+	if len(b) < 3 || b[0] != version {
+		return header{}, fmt.Errorf("%w: not an envelope", ErrDecrypt)
+	}
+	h := header{algID: b[1]}
+	for alg, id := range algorithmIDs {
+		if id == h.algID {
+			h.alg = alg
+		}
+	}
+	if h.alg == "" {
+		return header{}, fmt.Errorf("crypto: envelope uses unknown algorithm %d", h.algID)
+	}
+	n := 3 + int(b[2])
+	if len(b) < n+2 {
+		return header{}, fmt.Errorf("%w: truncated envelope", ErrDecrypt)
+	}
+	h.kekID = string(b[3:n])
+	m := n + 2 + int(binary.BigEndian.Uint16(b[n:]))
+	if len(b) < m {
+		return header{}, fmt.Errorf("%w: truncated envelope", ErrDecrypt)
+	}
+	h.wrapped, h.size = b[n+2:m], m
+	return h, nil
+}
+
+// unwrap returns the data key of an envelope.
+func (e *Envelope) unwrap(ctx context.Context, h header) ([]byte, error) {
+	e.mu.Lock()
+	e.refresh(ctx)
+	kek, ok := e.keks[h.kekID]
+	e.mu.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("%w %q", ErrUnknownKey, h.kekID)
+	}
+	aead, err := NewAEAD(XChaCha20Poly1305, kek)
+	if err != nil {
+		return nil, err
+	}
+	prefix := append([]byte{version, h.algID, byte(len(h.kekID))}, h.kekID...)
+	return open(aead, h.wrapped, prefix)
+}
+
+// dataAAD binds the sealed data to its algorithm and the caller's aad.
+func dataAAD(alg byte, aad []byte) []byte {
+	return append([]byte{version, alg}, aad...)
+}
+
+// current returns the KEK that encrypts.
+func (e *Envelope) current(ctx context.Context) (string, []byte) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.refresh(ctx)
+	return e.primary, e.keks[e.primary]
+}
+
+// refresh reloads the keyring, keeping the previous one if that fails. e.mu
+// must be held.
+func (e *Envelope) refresh(ctx context.Context) {
+	if err := e.load(ctx); err != nil {
+		slog.WarnContext(ctx, "encryption keyring refresh failed, using previous keys", "secret", e.cfg.Secret, "error", err)
+	}
+}
+
+// load re-parses the keyring if the secret changed. e.mu must be held.
+func (e *Envelope) load(ctx context.Context) error {
+	v, err := e.store.Get(ctx, e.cfg.Secret)
+	if err != nil {
+		return fmt.Errorf("crypto: reading keyring: %w", err)
+	}
+	raw := v.Reveal()
+	if e.keks != nil && raw == e.raw {
+		return nil
+	}
+	primary, keks, err := parseKeyring(raw)
+	if err != nil {
+		if e.keks != nil {
+			e.raw = raw // warn once per broken revision, keeping the old keys
+		}
+		return err
+	}
+	e.raw, e.primary, e.keks = raw, primary, keks
+	return nil
+}
+
+// parseKeyring parses the keyring secret into the ID of its first KEK and
+// every KEK by ID.
+func parseKeyring(raw string) (string, map[string][]byte, error) {
+	var primary string
+	keks := make(map[string][]byte)
+	sc := bufio.NewScanner(strings.NewReader(raw))
+	for n := 1; sc.Scan(); n++ {
+		line, _, _ := strings.Cut(sc.Text(), "#")
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) != 2 {
+			return "", nil, fmt.Errorf("crypto: keyring line %d: want an ID and a base64 key", n)
+		}
+		id := fields[0]
+		if len(id) > 255 {
+			return "", nil, fmt.Errorf("crypto: keyring line %d: ID longer than 255 bytes", n)
+		}
+		if _, dup := keks[id]; dup {
+			return "", nil, fmt.Errorf("crypto: keyring line %d: duplicate ID %s", n, id)
+		}
+		key, err := base64.StdEncoding.DecodeString(fields[1])
+		if err != nil || len(key) != KeySize {
+			return "", nil, fmt.Errorf("crypto: keyring line %d: key %s is not %d base64-encoded bytes", n, id, KeySize)
+		}
+		if primary == "" {
+			primary = id
+		}
+		keks[id] = key
+	}
+	if primary == "" {
+		return "", nil, errors.New("crypto: keyring holds no keys")
+	}
+	return primary, keks, nil
+}
@@ -0,0 +1,130 @@
+package crypto
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// Prefix starts every string encrypted by EncryptString.
+const Prefix = "enc:v1:"
+
+// EncryptString encrypts s into printable text starting with Prefix, which
+// fits the string columns PII is usually stored in. The empty string stays
+// empty, and an already encrypted s is returned unchanged.
+func (e *Envelope) EncryptString(ctx context.Context, s, aad string) (string, error) {
+	if s == "" || strings.HasPrefix(s, Prefix) {
+		return s, nil
+	}
+	b, err := e.Encrypt(ctx, []byte(s), []byte(aad))
+	if err != nil {
+		return "", err
+	}
+	return Prefix + base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// DecryptString decrypts a string made by EncryptString. Strings without
+// Prefix are returned unchanged, so rows written before encryption was
+// turned on still read.
+func (e *Envelope) DecryptString(ctx context.Context, s, aad string) (string, error) {
+	enc, ok := strings.CutPrefix(s, Prefix)
+	if !ok {
+		return s, nil
+	}
+	b, err := base64.RawURLEncoding.DecodeString(enc)
+	if err != nil {
+		return "", fmt.Errorf("%w: malformed encrypted string", ErrDecrypt)
+	}
+	plaintext, err := e.Decrypt(ctx, b, []byte(aad))
+	if err != nil {
+		return "", err
+	}
+	return string(plaintext), nil
+}
+
+// EncryptFields encrypts, in place, the string fields tagged `encrypt` of
+// the struct v points to, recursing into nested structs, pointers to them,
+// and slices of either. The tag's value labels the field (the field name if
+// empty); it is authenticated with the value together with record, such as
+// the ID of the row being stored, so ciphertexts cannot be moved between
+// fields or records undetected. Pass "" as record to bind to the label
+// alone.
+func (e *Envelope) EncryptFields(ctx context.Context, v any, record string) error {
+	return walk(v, func(f reflect.Value, label string) error {
+		s, err := e.EncryptString(ctx, f.String(), fieldAAD(label, record))
+		if err == nil {
+			f.SetString(s)
+		}
+		return err
+	})
+}
+
+// DecryptFields reverses EncryptFields, with the same record.
+func (e *Envelope) DecryptFields(ctx context.Context, v any, record string) error {
+	return walk(v, func(f reflect.Value, label string) error {
+		s, err := e.DecryptString(ctx, f.String(), fieldAAD(label, record))
+		if err != nil {
+			return fmt.Errorf("crypto: field %s: %w", label, err)
+		}
+		f.SetString(s)
+		return nil
+	})
+}
+
+func fieldAAD(label, record string) string {
+	return label + "\x00" + record
+}
+
+func walk(v any, fn func(reflect.Value, string) error) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Pointer || rv.IsNil() || rv.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("crypto: want a pointer to a struct, got %T", v)
+	}
+	return walkValue(rv.Elem(), fn)
+}
+
+func walkValue(v reflect.Value, fn func(reflect.Value, string) error) error {
+	switch v.Kind() {
+	case reflect.Pointer:
+		if v.IsNil() {
+			return nil
+		}
+		return walkValue(v.Elem(), fn)
+	case reflect.Slice, reflect.Array:
+		for i := range v.Len() {
+			if err := walkValue(v.Index(i), fn); err != nil {
+				return err
+			}
+		}
+		return nil
+	case reflect.Struct:
+	default:
+		return nil
+	}
+	t := v.Type()
+	for i := range t.NumField() {
+		sf, f := t.Field(i), v.Field(i)
+		if !sf.IsExported() {
+			continue
+		}
+		label, tagged := sf.Tag.Lookup("encrypt")
+		if !tagged {
+			if err := walkValue(f, fn); err != nil {
+				return err
+			}
+			continue
+		}
+		if f.Kind() != reflect.String {
+			return fmt.Errorf("crypto: field %s.%s is tagged encrypt but is not a string", t.Name(), sf.Name)
+		}
+		if label == "" {
+			label = sf.Name
+		}
+		if err := fn(f, label); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,182 @@
+package shared
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"html/template"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// csrfTokenLen is the length in bytes of a raw CSRF token.
+const csrfTokenLen = 32
+
+// CSRFConfig configures double-submit-cookie CSRF protection: a random
+// token is kept in a cookie, and every state-changing request must echo it
+// in a form field or header, which a cross-site page cannot read and so
+// cannot forge. Load it with CSRFConfigFromEnv or fill it in directly.
+//
+// Usage:
+//
+//	cfg, _ := shared.CSRFConfigFromEnv()
+//	mux.HandleFunc("/cart", func(w http.ResponseWriter, r *http.Request) {
+//	    templates.ExecuteTemplate(w, "cart", map[string]any{
+//	        "csrf_field": shared.CSRFTemplateField(r), // inside each <form method="POST">
+//	    })
+//	})
+//	http.ListenAndServe(addr, cfg.Middleware(mux))
+//
+// Scripts can send the token from shared.CSRFToken(r), rendered into a
+// <meta> tag, in the X-CSRF-Token header instead.
+type CSRFConfig struct {
+	// CookieName names the cookie holding the token.
+	CookieName string `env:"CSRF_COOKIE_NAME" default:"shop_csrf"`
+	// FieldName names the form field checked on form submissions.
+	FieldName string `env:"CSRF_FIELD_NAME" default:"csrf_token"`
+	// HeaderName names the header checked before the form field.
+	HeaderName string `env:"CSRF_HEADER_NAME" default:"X-CSRF-Token"`
+	// ExemptPaths skips the check for webhooks and other endpoints not
+	// called from browsers. Entries ending in "/" match every path under
+	// them; others match exactly.
+	ExemptPaths []string `env:"CSRF_EXEMPT_PATHS"`
+	// Secure marks the cookie Secure, so browsers only send it over HTTPS.
+	Secure bool `env:"CSRF_SECURE_COOKIE"`
+	// MaxAge is the lifetime of the token cookie.
+	MaxAge time.Duration `env:"CSRF_MAX_AGE" default:"48h"`
+}
+
+// CSRFConfigFromEnv loads a CSRFConfig with LoadConfig.
+func CSRFConfigFromEnv() (CSRFConfig, error) {
+	var cfg CSRFConfig
+	err := LoadConfig(&cfg)
+	return cfg, err
+}
+
+type csrfTokenKey struct{}
+
+// csrfContext is what Middleware stores for CSRFToken and CSRFTemplateField.
+type csrfContext struct {
+	token []byte
+	field string
+}
+
+// Middleware issues the token cookie to browsers without one and rejects
+// POST, PUT, PATCH and DELETE requests whose header or form field does not
+// match it with 403 Forbidden.
+func (c CSRFConfig) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := c.cookieToken(r)
+		if token == nil {
+			token = make([]byte, csrfTokenLen)
+			if _, err := rand.Read(token); err != nil {
+				http.Error(w, "internal error", http.StatusInternalServerError)
+				return
+			}
+			http.SetCookie(w, &http.Cookie{
+				Name:     c.CookieName,
+				Value:    base64.RawURLEncoding.EncodeToString(token),
+				Path:     "/",
+				MaxAge:   int(c.MaxAge / time.Second),
+				HttpOnly: true,
+				Secure:   c.Secure,
+				SameSite: http.SameSiteLaxMode,
+			})
+		}
+		// Caches must not hand one visitor's token to another.
+		w.Header().Add("Vary", "Cookie")
+		r = r.WithContext(context.WithValue(r.Context(), csrfTokenKey{}, csrfContext{token, c.FieldName}))
+
+		if !csrfSafeMethod(r.Method) && !c.exempt(r.URL.Path) && !c.valid(r, token) {
+			log.Printf("CSRF: rejected %s %s from %s: missing or invalid token", r.Method, r.URL.Path, ClientIP(r))
+			http.Error(w, "Forbidden - invalid CSRF token", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// cookieToken returns the well-formed token of r's cookie, or nil.
+func (c CSRFConfig) cookieToken(r *http.Request) []byte {
+	ck, err := r.Cookie(c.CookieName)
+	if err != nil {
+		return nil
+	}
+	token, err := base64.RawURLEncoding.DecodeString(ck.Value)
+	if err != nil || len(token) != csrfTokenLen {
+		return nil
+	}
+	return token
+}
+
+// valid reports whether r echoes token. A fresh token never validates, so
+// a request without the cookie fails.
+func (c CSRFConfig) valid(r *http.Request, token []byte) bool {
+	if _, err := r.Cookie(c.CookieName); err != nil {
+		return false
+	}
+	sent := r.Header.Get(c.HeaderName)
+	if sent == "" {
+		sent = r.PostFormValue(c.FieldName)
+	}
+	got := unmaskCSRFToken(sent)
+	return got != nil && subtle.ConstantTimeCompare(got, token) == 1
+}
+
+func (c CSRFConfig) exempt(path string) bool {
+	for _, p := range c.ExemptPaths {
+		if path == p || (strings.HasSuffix(p, "/") && strings.HasPrefix(path, p)) {
+			return true
+		}
+	}
+	return false
+}
+
+func csrfSafeMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+		return true
+	}
+	return false
+}
+
+// CSRFToken returns the token to embed in pages rendered for r, or "" if
+// r did not pass through CSRFConfig.Middleware. Each call masks the token
+// with a fresh random pad, so compressed responses do not leak it (BREACH).
+func CSRFToken(r *http.Request) string {
+	cc, ok := r.Context().Value(csrfTokenKey{}).(csrfContext)
+	if !ok {
+		return ""
+	}
+	masked := make([]byte, 2*csrfTokenLen)
+	if _, err := rand.Read(masked[:csrfTokenLen]); err != nil {
+		return ""
+	}
+	for i, b := range cc.token {
+		masked[csrfTokenLen+i] = b ^ masked[i]
+	}
+	return base64.RawURLEncoding.EncodeToString(masked)
+}
+
+// CSRFTemplateField returns a hidden form input carrying CSRFToken(r)
+// under the configured FieldName, ready to place inside a template's forms.
+func CSRFTemplateField(r *http.Request) template.HTML {
+	cc, _ := r.Context().Value(csrfTokenKey{}).(csrfContext)
+	return template.HTML(`<input type="hidden" name="` + template.HTMLEscapeString(cc.field) +
+		`" value="` + CSRFToken(r) + `">`)
+}
+
+func unmaskCSRFToken(s string) []byte {
+	masked, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil || len(masked) != 2*csrfTokenLen {
+		return nil
+	}
+	token := make([]byte, csrfTokenLen)
+	for i := range token {
+		token[i] = masked[i] ^ masked[csrfTokenLen+i]
+	}
+	return token
+}
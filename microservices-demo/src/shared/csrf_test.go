@@ -0,0 +1,90 @@
+package shared
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func newCSRFTestHandler(t *testing.T, cfg CSRFConfig) (http.Handler, *string) {
+	t.Helper()
+	var field string
+	return cfg.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		field = string(CSRFTemplateField(r))
+		w.WriteHeader(http.StatusOK)
+	})), &field
+}
+
+func TestCSRFMiddleware(t *testing.T) {
+	cfg, err := CSRFConfigFromEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.ExemptPaths = []string{"/hooks/"}
+	h, field := newCSRFTestHandler(t, cfg)
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/cart", nil))
+	cookies := rec.Result().Cookies()
+	if rec.Code != http.StatusOK || len(cookies) != 1 || cookies[0].Name != "shop_csrf" || !cookies[0].HttpOnly {
+		t.Fatalf("GET: status %d, cookies %v", rec.Code, cookies)
+	}
+	m := regexp.MustCompile(`name="csrf_token" value="([^"]+)"`).FindStringSubmatch(*field)
+	if m == nil {
+		t.Fatalf("template field = %q", *field)
+	}
+	token := m[1]
+
+	post := func(path, form string, withCookie bool, header string) int {
+		req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(form))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if withCookie {
+			req.AddCookie(cookies[0])
+		}
+		if header != "" {
+			req.Header.Set("X-CSRF-Token", header)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec.Code
+	}
+	for name, tt := range map[string]struct {
+		path, form string
+		cookie     bool
+		header     string
+		want       int
+	}{
+		"form field":      {"/cart", url.Values{"csrf_token": {token}}.Encode(), true, "", http.StatusOK},
+		"header":          {"/cart", "", true, token, http.StatusOK},
+		"missing token":   {"/cart", "product_id=1", true, "", http.StatusForbidden},
+		"missing cookie":  {"/cart", url.Values{"csrf_token": {token}}.Encode(), false, "", http.StatusForbidden},
+		"forged token":    {"/cart", "", true, strings.Repeat("A", len(token)), http.StatusForbidden},
+		"exempt prefix":   {"/hooks/payment", "", false, "", http.StatusOK},
+		"not exempt path": {"/hooks", "", false, "", http.StatusForbidden},
+	} {
+		if got := post(tt.path, tt.form, tt.cookie, tt.header); got != tt.want {
+			t.Errorf("%s: status %d, want %d", name, got, tt.want)
+		}
+	}
+}
+
+func TestCSRFTokenMasked(t *testing.T) {
+	cfg, _ := CSRFConfigFromEnv()
+	var a, b string
+	h := cfg.Middleware(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+		a, b = CSRFToken(r), CSRFToken(r)
+	}))
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	if a == "" || a == b {
+		t.Errorf("tokens %q and %q: want distinct masks of one token", a, b)
+	}
+	if string(unmaskCSRFToken(a)) != string(unmaskCSRFToken(b)) {
+		t.Error("masked tokens do not unmask to the same token")
+	}
+	if got := CSRFToken(httptest.NewRequest(http.MethodGet, "/", nil)); got != "" {
+		t.Errorf("CSRFToken without middleware = %q", got)
+	}
+}
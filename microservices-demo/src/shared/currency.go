@@ -0,0 +1,253 @@
+package shared
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/GoogleCloudPlatform/microservices-demo/src/shared/metrics"
+)
+
+var (
+	currencyRateFetchesTotal = metrics.NewCounterVec("currency_rate_fetches_total",
+		"Exchange rate table fetches, by provider and result (ok, error).", "provider", "result")
+	currencyRatesAge = metrics.NewGaugeVec("currency_rates_age_seconds",
+		"Age of the exchange rates in use, from their publication time.", "provider")
+	currencyStaleServedTotal = metrics.NewCounterVec("currency_stale_rates_served_total",
+		"Conversions answered from an expired rate table because refreshing it failed.", "provider")
+)
+
+// Defaults for NewCurrencyConverter.
+const (
+	DefaultRatesTTL   = time.Hour
+	DefaultMaxRateAge = 96 * time.Hour // covers the ECB's weekend gap
+)
+
+var (
+	// ErrUnsupportedCurrency is returned for a currency missing from the
+	// rate table.
+	ErrUnsupportedCurrency = errors.New("currency: unsupported currency")
+	// ErrStaleRates is returned when the only rates available are older than
+	// the converter's maximum age.
+	ErrStaleRates = errors.New("currency: exchange rates are stale")
+)
+
+// RateTable is a set of exchange rates: one Base unit buys Rates[c] of
+// currency c. Rates are decimal strings, as published, so no precision is
+// lost before conversion.
+type RateTable struct {
+	Base  string
+	Rates map[string]string
+	// AsOf is when the rates were published; zero means unknown, and such
+	// tables never count as stale.
+	AsOf time.Time
+}
+
+// RateProvider supplies exchange rates to a CurrencyConverter. See
+// StaticRates, ECBRates and CurrencyServiceRates.
+type RateProvider interface {
+	// Name labels the provider in metrics and logs.
+	Name() string
+	// Rates fetches the current table.
+	Rates(ctx context.Context) (RateTable, error)
+}
+
+// parsedRates is a RateTable with exact rates, including the base.
+type parsedRates struct {
+	rates     map[string]*big.Rat
+	asOf      time.Time
+	fetchedAt time.Time
+}
+
+func parseRateTable(t RateTable) (*parsedRates, error) {
+	p := &parsedRates{rates: make(map[string]*big.Rat, len(t.Rates)+1), asOf: t.AsOf}
+	for c, s := range t.Rates {
+		r, ok := new(big.Rat).SetString(s)
+		if !ok || r.Sign() <= 0 {
+			return nil, fmt.Errorf("currency: rate for %s: invalid value %q", c, s)
+		}
+		p.rates[c] = r
+	}
+	if t.Base != "" {
+		p.rates[t.Base] = big.NewRat(1, 1)
+	}
+	return p, nil
+}
+
+type converterConfig struct {
+	ttl      time.Duration
+	maxAge   time.Duration
+	rounding RoundingMode
+	clock    Clock
+}
+
+// ConverterOption configures NewCurrencyConverter.
+type ConverterOption func(*converterConfig)
+
+// WithRatesTTL sets how long a fetched table is used before refreshing it
+// (default DefaultRatesTTL).
+func WithRatesTTL(d time.Duration) ConverterOption {
+	return func(c *converterConfig) { c.ttl = d }
+}
+
+// WithMaxRateAge sets how old, by publication time, rates may be before
+// conversions fail with ErrStaleRates (default DefaultMaxRateAge). Zero
+// disables the check.
+func WithMaxRateAge(d time.Duration) ConverterOption {
+	return func(c *converterConfig) { c.maxAge = d }
+}
+
+// WithConverterRounding sets how converted amounts are rounded to the
+// target currency's minor unit (default RoundHalfEven).
+func WithConverterRounding(m RoundingMode) ConverterOption {
+	return func(c *converterConfig) { c.rounding = m }
+}
+
+// WithConverterClock sets the clock used for expiry and staleness, for
+// tests.
+func WithConverterClock(clock Clock) ConverterOption {
+	return func(c *converterConfig) { c.clock = clock }
+}
+
+// CurrencyConverter converts Money between currencies using rates from a
+// RateProvider. The table is cached for the TTL; if a refresh fails, the
+// previous table keeps being served until its rates exceed the maximum age,
+// so a currencyservice blip does not fail checkouts.
+//
+// Usage:
+//
+//	conv := shared.NewCurrencyConverter(shared.CurrencyServiceRates(conn))
+//	...
+//	usd, err := shared.MoneyFromProto(product.GetPriceUsd())
+//	...
+//	price, err := conv.Convert(ctx, usd, req.GetUserCurrency())
+type CurrencyConverter struct {
+	provider RateProvider
+	cfg      converterConfig
+
+	mu      sync.Mutex // serializes refreshes
+	current *parsedRates
+}
+
+// NewCurrencyConverter returns a converter over p. Rates are fetched on
+// first use.
+func NewCurrencyConverter(p RateProvider, opts ...ConverterOption) *CurrencyConverter {
+	cfg := converterConfig{ttl: DefaultRatesTTL, maxAge: DefaultMaxRateAge, rounding: RoundHalfEven}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	cfg.clock = clockOrReal(cfg.clock)
+	return &CurrencyConverter{provider: p, cfg: cfg}
+}
+
+// rates returns a usable table, refreshing it if it has expired.
+func (c *CurrencyConverter) rates(ctx context.Context) (*parsedRates, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := c.cfg.clock.Now()
+	if c.current != nil && now.Sub(c.current.fetchedAt) < c.cfg.ttl {
+		return c.current, c.checkAge(c.current, now)
+	}
+
+	name := c.provider.Name()
+	t, err := c.provider.Rates(ctx)
+	var fresh *parsedRates
+	if err == nil {
+		fresh, err = parseRateTable(t)
+	}
+	if err != nil {
+		currencyRateFetchesTotal.WithLabelValues(name, "error").Inc()
+		if c.current == nil {
+			return nil, fmt.Errorf("currency: fetching rates from %s: %w", name, err)
+		}
+		if ageErr := c.checkAge(c.current, now); ageErr != nil {
+			return nil, fmt.Errorf("%w (refresh from %s failed: %w)", ageErr, name, err)
+		}
+		log.Printf("Currency: Refreshing rates from %s failed, serving previous table: %v", name, err)
+		currencyStaleServedTotal.WithLabelValues(name).Inc()
+		return c.current, nil
+	}
+	currencyRateFetchesTotal.WithLabelValues(name, "ok").Inc()
+	fresh.fetchedAt = now
+	c.current = fresh
+	return fresh, c.checkAge(fresh, now)
+}
+
+// checkAge updates the age gauge and fails if r is older than allowed.
+func (c *CurrencyConverter) checkAge(r *parsedRates, now time.Time) error {
+	if r.asOf.IsZero() {
+		return nil
+	}
+	age := now.Sub(r.asOf)
+	currencyRatesAge.WithLabelValues(c.provider.Name()).Set(age.Seconds())
+	if c.cfg.maxAge > 0 && age > c.cfg.maxAge {
+		return fmt.Errorf("%w: published %s ago", ErrStaleRates, age.Round(time.Minute))
+	}
+	return nil
+}
+
+// Rate returns how many units of to one unit of from buys.
+func (c *CurrencyConverter) Rate(ctx context.Context, from, to string) (*big.Rat, error) {
+	r, err := c.rates(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return r.rate(from, to)
+}
+
+func (r *parsedRates) rate(from, to string) (*big.Rat, error) {
+	rf, ok := r.rates[from]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedCurrency, from)
+	}
+	rt, ok := r.rates[to]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedCurrency, to)
+	}
+	return new(big.Rat).Quo(rt, rf), nil
+}
+
+// Convert returns m in currency to, rounded to its minor unit. Converting
+// to m's own currency returns m unchanged.
+func (c *CurrencyConverter) Convert(ctx context.Context, m Money, to string) (Money, error) {
+	if !m.Valid() {
+		return Money{}, ErrInvalidMoney
+	}
+	if m.Currency == to {
+		return m, nil
+	}
+	if m == (Money{}) {
+		return Money{Currency: to}, nil
+	}
+	if !validCurrency(to) {
+		return Money{}, fmt.Errorf("%w: %q", ErrUnsupportedCurrency, to)
+	}
+	rate, err := c.Rate(ctx, m.Currency, to)
+	if err != nil {
+		return Money{}, err
+	}
+	unit := big.NewInt(minorUnitNanos(to))
+	n := new(big.Int).Mul(m.nanos(), rate.Num())
+	d := new(big.Int).Mul(rate.Denom(), unit)
+	minor := roundQuo(n, d, c.cfg.rounding)
+	return fromNanos(to, minor.Mul(minor, unit))
+}
+
+// SupportedCurrencies returns the codes in the rate table, sorted.
+func (c *CurrencyConverter) SupportedCurrencies(ctx context.Context) ([]string, error) {
+	r, err := c.rates(ctx)
+	if err != nil {
+		return nil, err
+	}
+	codes := make([]string, 0, len(r.rates))
+	for code := range r.rates {
+		codes = append(codes, code)
+	}
+	slices.Sort(codes)
+	return codes, nil
+}
@@ -0,0 +1,196 @@
+package shared
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"maps"
+	"net/http"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// ECBDailyURL is the European Central Bank's daily reference rate feed.
+const ECBDailyURL = "https://www.ecb.europa.eu/stats/eurofxref/eurofxref-daily.xml"
+
+type staticRates struct{ table RateTable }
+
+// StaticRates returns a provider serving a fixed table, e.g. for tests or
+// as the demo's offline fallback. One base unit buys rates[c] of currency c.
+func StaticRates(base string, rates map[string]string) RateProvider {
+	return staticRates{RateTable{Base: base, Rates: maps.Clone(rates)}}
+}
+
+func (staticRates) Name() string { return "static" }
+
+func (s staticRates) Rates(context.Context) (RateTable, error) { return s.table, nil }
+
+type ecbRates struct {
+	url    string
+	client *http.Client
+}
+
+// ECBRates returns a provider reading the ECB's euro reference rates from
+// url (ECBDailyURL if empty). A nil client uses NewHTTPClient with a 10s timeout.
+func ECBRates(url string, client *http.Client) RateProvider {
+	if url == "" {
+		url = ECBDailyURL
+	}
+	if client == nil {
+		client = NewHTTPClient(WithHTTPClientName("ecb"), WithHTTPClientTimeout(10*time.Second))
+	}
+	return &ecbRates{url: url, client: client}
+}
+
+func (*ecbRates) Name() string { return "ecb" }
+
+// ecbEnvelope matches the feed's nested Cube elements:
+// <Cube><Cube time="2024-01-05"><Cube currency="USD" rate="1.0921"/>...
+type ecbEnvelope struct {
+	Cube struct {
+		Day struct {
+			Time  string `xml:"time,attr"`
+			Rates []struct {
+				Currency string `xml:"currency,attr"`
+				Rate     string `xml:"rate,attr"`
+			} `xml:"Cube"`
+		} `xml:"Cube"`
+	} `xml:"Cube"`
+}
+
+func (e *ecbRates) Rates(ctx context.Context) (RateTable, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, e.url, nil)
+	if err != nil {
+		return RateTable{}, err
+	}
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return RateTable{}, fmt.Errorf("ecb: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return RateTable{}, fmt.Errorf("ecb: %s", resp.Status)
+	}
+	var env ecbEnvelope
+	if err := xml.NewDecoder(resp.Body).Decode(&env); err != nil {
+		return RateTable{}, fmt.Errorf("ecb: decoding feed: %w", err)
+	}
+	day := env.Cube.Day
+	if len(day.Rates) == 0 {
+		return RateTable{}, fmt.Errorf("ecb: feed has no rates")
+	}
+	t := RateTable{Base: "EUR", Rates: make(map[string]string, len(day.Rates))}
+	for _, r := range day.Rates {
+		t.Rates[r.Currency] = r.Rate
+	}
+	// Rates are published around 16:00 CET on the given day; dating them
+	// at midnight UTC errs on the side of calling them old.
+	if asOf, err := time.Parse(time.DateOnly, day.Time); err == nil {
+		t.AsOf = asOf
+	}
+	return t, nil
+}
+
+type currencyServiceRates struct {
+	conn grpc.ClientConnInterface
+	base string
+}
+
+// CurrencyServiceRates returns a provider that builds its table from the
+// demo's currencyservice: it lists the supported currencies and converts a
+// fixed EUR amount into each. Only the connection is needed; the messages
+// are built from the service's proto definition at run time, so callers do
+// not need the currencyservice stubs.
+func CurrencyServiceRates(conn grpc.ClientConnInterface) RateProvider {
+	return &currencyServiceRates{conn: conn, base: "EUR"}
+}
+
+func (*currencyServiceRates) Name() string { return "currencyservice" }
+
+// currencyProbeUnits is the amount converted to derive each rate, large
+// enough that the service's nano rounding does not affect the rate's
+// significant digits.
+const currencyProbeUnits = 1000
+
+func (c *currencyServiceRates) Rates(ctx context.Context) (RateTable, error) {
+	list := dynamicpb.NewMessage(currencyDescriptors.supported)
+	if err := c.conn.Invoke(ctx, "/hipstershop.CurrencyService/GetSupportedCurrencies",
+		dynamicpb.NewMessage(currencyDescriptors.empty), list); err != nil {
+		return RateTable{}, fmt.Errorf("currencyservice: listing currencies: %w", err)
+	}
+	codes := list.Get(currencyDescriptors.supported.Fields().ByName("currency_codes")).List()
+
+	t := RateTable{Base: c.base, Rates: make(map[string]string, codes.Len())}
+	money := currencyDescriptors.money.Fields()
+	for i := range codes.Len() {
+		code := codes.Get(i).String()
+		if code == c.base {
+			continue
+		}
+		from := dynamicpb.NewMessage(currencyDescriptors.money)
+		from.Set(money.ByName("currency_code"), protoreflect.ValueOfString(c.base))
+		from.Set(money.ByName("units"), protoreflect.ValueOfInt64(currencyProbeUnits))
+		req := dynamicpb.NewMessage(currencyDescriptors.request)
+		reqFields := currencyDescriptors.request.Fields()
+		req.Set(reqFields.ByName("from"), protoreflect.ValueOfMessage(from))
+		req.Set(reqFields.ByName("to_code"), protoreflect.ValueOfString(code))
+
+		out := dynamicpb.NewMessage(currencyDescriptors.money)
+		if err := c.conn.Invoke(ctx, "/hipstershop.CurrencyService/Convert", req, out); err != nil {
+			return RateTable{}, fmt.Errorf("currencyservice: converting to %s: %w", code, err)
+		}
+		m, err := NewMoney(code, out.Get(money.ByName("units")).Int(), int32(out.Get(money.ByName("nanos")).Int()))
+		if err != nil {
+			return RateTable{}, fmt.Errorf("currencyservice: converting to %s: %w", code, err)
+		}
+		t.Rates[code] = m.nanos().String() + "/" + fmt.Sprint(currencyProbeUnits*nanosPerUnit)
+	}
+	return t, nil
+}
+
+// currencyDescriptors describes the hipstershop messages CurrencyService
+// uses, matching currencyservice/proto/demo.proto.
+var currencyDescriptors = func() (d struct {
+	empty, money, supported, request protoreflect.MessageDescriptor
+}) {
+	str := descriptorpb.FieldDescriptorProto_TYPE_STRING.Enum()
+	field := func(name string, num int32, typ *descriptorpb.FieldDescriptorProto_Type, label *descriptorpb.FieldDescriptorProto_Label) *descriptorpb.FieldDescriptorProto {
+		return &descriptorpb.FieldDescriptorProto{Name: &name, Number: &num, Type: typ, Label: label}
+	}
+	opt := descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum()
+	msg := func(name string, fields ...*descriptorpb.FieldDescriptorProto) *descriptorpb.DescriptorProto {
+		return &descriptorpb.DescriptorProto{Name: &name, Field: fields}
+	}
+	from := field("from", 1, descriptorpb.FieldDescriptorProto_TYPE_MESSAGE.Enum(), opt)
+	from.TypeName = proto.String(".hipstershop.Money")
+
+	name, pkg, syntax := "shared/hipstershop_currency.proto", "hipstershop", "proto3"
+	fd, err := protodesc.NewFile(&descriptorpb.FileDescriptorProto{
+		Name: &name, Package: &pkg, Syntax: &syntax,
+		MessageType: []*descriptorpb.DescriptorProto{
+			msg("Empty"),
+			msg("Money",
+				field("currency_code", 1, str, opt),
+				field("units", 2, descriptorpb.FieldDescriptorProto_TYPE_INT64.Enum(), opt),
+				field("nanos", 3, descriptorpb.FieldDescriptorProto_TYPE_INT32.Enum(), opt)),
+			msg("GetSupportedCurrenciesResponse",
+				field("currency_codes", 1, str, descriptorpb.FieldDescriptorProto_LABEL_REPEATED.Enum())),
+			msg("CurrencyConversionRequest", from, field("to_code", 2, str, opt)),
+		},
+	}, nil)
+	if err != nil {
+		panic(fmt.Sprintf("currency: building hipstershop descriptors: %v", err))
+	}
+	msgs := fd.Messages()
+	d.empty = msgs.ByName("Empty")
+	d.money = msgs.ByName("Money")
+	d.supported = msgs.ByName("GetSupportedCurrenciesResponse")
+	d.request = msgs.ByName("CurrencyConversionRequest")
+	return d
+}()
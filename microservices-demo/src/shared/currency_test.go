@@ -0,0 +1,194 @@
+package shared
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+var demoRates = map[string]string{"USD": "1.1305", "JPY": "126.40", "GBP": "0.85970"}
+
+func TestCurrencyConverterStatic(t *testing.T) {
+	conv := NewCurrencyConverter(StaticRates("EUR", demoRates))
+	ctx := context.Background()
+	tests := []struct {
+		from Money
+		to   string
+		want string
+	}{
+		{mustMoney(t, "EUR", "10"), "USD", "USD 11.30"}, // 11.305 rounds half to even
+		{mustMoney(t, "USD", "11.305"), "EUR", "EUR 10.00"},
+		{mustMoney(t, "USD", "19.99"), "JPY", "JPY 2235"},
+		{mustMoney(t, "GBP", "1"), "GBP", "GBP 1.00"},
+	}
+	for _, tt := range tests {
+		got, err := conv.Convert(ctx, tt.from, tt.to)
+		if err != nil {
+			t.Errorf("Convert(%v, %s): %v", tt.from, tt.to, err)
+			continue
+		}
+		if got.String() != tt.want {
+			t.Errorf("Convert(%v, %s) = %v, want %s", tt.from, tt.to, got, tt.want)
+		}
+	}
+	if _, err := conv.Convert(ctx, mustMoney(t, "EUR", "1"), "XYZ"); !errors.Is(err, ErrUnsupportedCurrency) {
+		t.Errorf("unknown currency: %v", err)
+	}
+	codes, _ := conv.SupportedCurrencies(ctx)
+	if len(codes) != 4 || codes[0] != "EUR" {
+		t.Errorf("SupportedCurrencies = %v", codes)
+	}
+}
+
+type flakyRates struct {
+	calls atomic.Int32
+	fail  atomic.Bool
+	asOf  time.Time
+}
+
+func (f *flakyRates) Name() string { return "flaky" }
+
+func (f *flakyRates) Rates(context.Context) (RateTable, error) {
+	f.calls.Add(1)
+	if f.fail.Load() {
+		return RateTable{}, errors.New("connection refused")
+	}
+	return RateTable{Base: "EUR", Rates: demoRates, AsOf: f.asOf}, nil
+}
+
+func TestCurrencyConverterCachingAndStaleness(t *testing.T) {
+	clk := NewFakeClock(time.Date(2026, 3, 2, 12, 0, 0, 0, time.UTC))
+	p := &flakyRates{asOf: clk.Now()}
+	conv := NewCurrencyConverter(p, WithRatesTTL(time.Hour), WithMaxRateAge(24*time.Hour), WithConverterClock(clk))
+	ctx := context.Background()
+	eur := mustMoney(t, "EUR", "1")
+
+	for range 3 {
+		if _, err := conv.Convert(ctx, eur, "USD"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := p.calls.Load(); n != 1 {
+		t.Errorf("provider called %d times within the TTL, want 1", n)
+	}
+
+	// A failed refresh keeps serving the previous table while it is young
+	// enough.
+	p.fail.Store(true)
+	clk.Advance(2 * time.Hour)
+	if _, err := conv.Convert(ctx, eur, "USD"); err != nil {
+		t.Errorf("refresh failure with usable rates: %v", err)
+	}
+	clk.Advance(23 * time.Hour)
+	if _, err := conv.Convert(ctx, eur, "USD"); !errors.Is(err, ErrStaleRates) {
+		t.Errorf("stale rates: err = %v, want ErrStaleRates", err)
+	}
+
+	if _, err := NewCurrencyConverter(p).Convert(ctx, eur, "USD"); err == nil {
+		t.Error("first fetch failing did not return an error")
+	}
+}
+
+const ecbFeed = `<?xml version="1.0" encoding="UTF-8"?>
+<gesmes:Envelope xmlns:gesmes="http://www.gesmes.org/xml/2002-08-01" xmlns="http://www.ecb.int/vocabulary/2002-08-01/eurofxref">
+	<gesmes:subject>Reference rates</gesmes:subject>
+	<Cube>
+		<Cube time="2026-03-02">
+			<Cube currency="USD" rate="1.0921"/>
+			<Cube currency="JPY" rate="161.05"/>
+		</Cube>
+	</Cube>
+</gesmes:Envelope>`
+
+func TestECBRates(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(ecbFeed))
+	}))
+	defer srv.Close()
+
+	table, err := ECBRates(srv.URL, srv.Client()).Rates(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if table.Base != "EUR" || table.Rates["USD"] != "1.0921" || table.Rates["JPY"] != "161.05" {
+		t.Errorf("table = %+v", table)
+	}
+	if want := time.Date(2026, 3, 2, 0, 0, 0, 0, time.UTC); !table.AsOf.Equal(want) {
+		t.Errorf("AsOf = %v, want %v", table.AsOf, want)
+	}
+}
+
+// fakeCurrencyService answers CurrencyService calls from demoRates using
+// dynamic messages, as the Node service would.
+func fakeCurrencyService(srv any, stream grpc.ServerStream) error {
+	method, _ := grpc.MethodFromServerStream(stream)
+	d := currencyDescriptors
+	switch method {
+	case "/hipstershop.CurrencyService/GetSupportedCurrencies":
+		if err := stream.RecvMsg(dynamicpb.NewMessage(d.empty)); err != nil {
+			return err
+		}
+		resp := dynamicpb.NewMessage(d.supported)
+		list := resp.Mutable(d.supported.Fields().ByName("currency_codes")).List()
+		for _, c := range []string{"EUR", "USD", "JPY"} {
+			list.Append(protoreflect.ValueOfString(c))
+		}
+		return stream.SendMsg(resp)
+	case "/hipstershop.CurrencyService/Convert":
+		req := dynamicpb.NewMessage(d.request)
+		if err := stream.RecvMsg(req); err != nil {
+			return err
+		}
+		from := req.Get(d.request.Fields().ByName("from")).Message()
+		to := req.Get(d.request.Fields().ByName("to_code")).String()
+		units := from.Get(d.money.Fields().ByName("units")).Int()
+		res, err := convertDemo(to, units)
+		if err != nil {
+			return err
+		}
+		out := dynamicpb.NewMessage(d.money)
+		out.Set(d.money.Fields().ByName("currency_code"), protoreflect.ValueOfString(to))
+		out.Set(d.money.Fields().ByName("units"), protoreflect.ValueOfInt64(res.Units))
+		out.Set(d.money.Fields().ByName("nanos"), protoreflect.ValueOfInt32(res.Nanos))
+		return stream.SendMsg(out)
+	}
+	return errors.New("unexpected method " + method)
+}
+
+func convertDemo(to string, eurUnits int64) (Money, error) {
+	return NewCurrencyConverter(StaticRates("EUR", demoRates)).Convert(context.Background(), Money{Currency: "EUR", Units: eurUnits}, to)
+}
+
+func TestCurrencyServiceRates(t *testing.T) {
+	lis := bufconn.Listen(1 << 20)
+	srv := grpc.NewServer(grpc.UnknownServiceHandler(fakeCurrencyService))
+	go srv.Serve(lis)
+	defer srv.Stop()
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	conv := NewCurrencyConverter(CurrencyServiceRates(conn))
+	got, err := conv.Convert(context.Background(), mustMoney(t, "USD", "1130.50"), "JPY")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.String() != "JPY 126400" {
+		t.Errorf("Convert = %v, want JPY 126400", got)
+	}
+}
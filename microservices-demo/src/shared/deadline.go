@@ -0,0 +1,98 @@
+package shared
+
+import (
+	"context"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/GoogleCloudPlatform/microservices-demo/src/shared/metrics"
+)
+
+// deadlineRejections counts calls refused because too little time was left.
+var deadlineRejections = metrics.NewCounterVec("grpc_server_deadline_rejected_total",
+	"Calls rejected because too little of their deadline remained, by method.", "method")
+
+// RemainingBudget returns the time left before ctx's deadline, and false if
+// ctx has none.
+func RemainingBudget(ctx context.Context) (time.Duration, bool) {
+	d, ok := ctx.Deadline()
+	if !ok {
+		return 0, false
+	}
+	return time.Until(d), true
+}
+
+// BudgetedContext derives a context for a downstream call that gets only
+// fraction (0–1] of the time remaining on ctx, leaving the rest to finish the
+// local work once the call returns:
+//
+//	callCtx, cancel := shared.BudgetedContext(ctx, 0.8)
+//	defer cancel()
+//	quote, err := shipping.GetQuote(callCtx, req)
+//
+// Without a deadline on ctx, or with fraction outside (0, 1), the returned
+// context just inherits ctx.
+func BudgetedContext(ctx context.Context, fraction float64) (context.Context, context.CancelFunc) {
+	remaining, ok := RemainingBudget(ctx)
+	if !ok || fraction <= 0 || fraction >= 1 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, time.Duration(float64(remaining)*fraction))
+}
+
+// ReserveContext derives a context whose deadline is reserve earlier than
+// ctx's, keeping a fixed local budget rather than a fraction. If less than
+// reserve remains, the returned context is already expired.
+func ReserveContext(ctx context.Context, reserve time.Duration) (context.Context, context.CancelFunc) {
+	d, ok := ctx.Deadline()
+	if !ok || reserve <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithDeadline(ctx, d.Add(-reserve))
+}
+
+// MinDeadlineUnaryInterceptor rejects incoming calls with DeadlineExceeded
+// when less than min remains of their deadline, instead of starting work
+// whose result the caller will never see. Calls without a deadline pass.
+func MinDeadlineUnaryInterceptor(min time.Duration) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		if err := checkMinDeadline(ctx, info.FullMethod, min); err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+// MinDeadlineStreamInterceptor is the streaming variant of
+// MinDeadlineUnaryInterceptor.
+func MinDeadlineStreamInterceptor(min time.Duration) grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if err := checkMinDeadline(ss.Context(), info.FullMethod, min); err != nil {
+			return err
+		}
+		return handler(srv, ss)
+	}
+}
+
+func checkMinDeadline(ctx context.Context, method string, min time.Duration) error {
+	remaining, ok := RemainingBudget(ctx)
+	if !ok || remaining >= min {
+		return nil
+	}
+	deadlineRejections.WithLabelValues(method).Inc()
+	return status.Errorf(codes.DeadlineExceeded, "only %v of deadline left, need at least %v", remaining.Round(time.Millisecond), min)
+}
+
+// BudgetUnaryClientInterceptor shortens the deadline of every outgoing call
+// to fraction of what remains on its context, so each hop down the call
+// graph keeps time to handle the response.
+func BudgetUnaryClientInterceptor(fraction float64) grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		ctx, cancel := BudgetedContext(ctx, fraction)
+		defer cancel()
+		return invoker(ctx, method, req, reply, cc, opts...)
+	}
+}
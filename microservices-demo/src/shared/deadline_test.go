@@ -0,0 +1,54 @@
+package shared
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestBudgetedContext(t *testing.T) {
+	parent, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	ctx, cancel := BudgetedContext(parent, 0.5)
+	defer cancel()
+	remaining, ok := RemainingBudget(ctx)
+	if !ok || remaining > 500*time.Millisecond || remaining < 400*time.Millisecond {
+		t.Errorf("remaining = %v, %v; want about 500ms", remaining, ok)
+	}
+
+	ctx, cancel = BudgetedContext(context.Background(), 0.5)
+	defer cancel()
+	if _, ok := ctx.Deadline(); ok {
+		t.Error("BudgetedContext invented a deadline")
+	}
+}
+
+func TestReserveContext(t *testing.T) {
+	parent, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	ctx, cancel := ReserveContext(parent, 300*time.Millisecond)
+	defer cancel()
+	if remaining, _ := RemainingBudget(ctx); remaining > 700*time.Millisecond {
+		t.Errorf("remaining = %v, want at most 700ms", remaining)
+	}
+}
+
+func TestMinDeadlineUnaryInterceptor(t *testing.T) {
+	intercept := MinDeadlineUnaryInterceptor(100 * time.Millisecond)
+	info := &grpc.UnaryServerInfo{FullMethod: "/svc/M"}
+	handler := func(ctx context.Context, req any) (any, error) { return "ok", nil }
+
+	short, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := intercept(short, nil, info, handler); status.Code(err) != codes.DeadlineExceeded {
+		t.Errorf("short deadline = %v, want DeadlineExceeded", err)
+	}
+	if _, err := intercept(context.Background(), nil, info, handler); err != nil {
+		t.Errorf("no deadline = %v, want nil", err)
+	}
+}
@@ -0,0 +1,39 @@
+package shared
+
+import (
+	"context"
+
+	"github.com/GoogleCloudPlatform/microservices-demo/src/shared/discovery"
+)
+
+// Resolve returns the healthy backends of service from the resolver chosen
+// by DISCOVERY_RESOLVER: DNS records of a headless Service, or its
+// EndpointSlices (see package discovery).
+//
+//	eps, err := shared.Resolve(ctx, "cartservice")
+//	if err != nil {
+//	    return err
+//	}
+//	for _, ep := range eps {
+//	    warm(ep.Addr)
+//	}
+//
+// gRPC clients need not call it: grpcclient.Dial resolves and balances
+// "discovery:///cartservice" targets itself.
+func Resolve(ctx context.Context, service string) ([]discovery.Endpoint, error) {
+	r, err := discovery.Default()
+	if err != nil {
+		return nil, err
+	}
+	return r.Resolve(ctx, service)
+}
+
+// WatchService is Resolve for callers that follow the backends as they
+// change; see discovery.Resolver.Watch.
+func WatchService(ctx context.Context, service string) (<-chan discovery.Update, error) {
+	r, err := discovery.Default()
+	if err != nil {
+		return nil, err
+	}
+	return r.Watch(ctx, service), nil
+}
@@ -0,0 +1,153 @@
+// Package discovery finds the healthy backends of a service, so clients can
+// spread load across pods themselves instead of pinning every call to the
+// one pod a ClusterIP connection happens to reach.
+//
+// Two resolvers are provided: DNSResolver reads the SRV or A records of a
+// headless Service, and KubernetesResolver watches the Service's
+// EndpointSlices, learning about pods going unready within a second instead
+// of a DNS TTL. Both implement Resolver, which answers once (Resolve) or
+// keeps the caller up to date (Watch).
+//
+// gRPC clients use it through the "discovery" target scheme, which
+// grpcclient.Dial pairs with a balancing policy:
+//
+//	conn, err := grpcclient.Dial(ctx, "discovery:///cartservice")
+//
+// Environment variables, read by FromEnv for the default resolver:
+//
+//	DISCOVERY_RESOLVER   dns or kubernetes (default dns)
+//	DISCOVERY_PORT_NAME  named Service port to connect to (default grpc)
+//	DISCOVERY_REFRESH    DNS re-resolution interval (default 30s)
+package discovery
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/GoogleCloudPlatform/microservices-demo/src/shared/internal/env"
+)
+
+// DefaultPortName is the Service port resolvers connect to unless told
+// otherwise; every Service in kubernetes-manifests names its port grpc.
+const DefaultPortName = "grpc"
+
+// DefaultRefresh is how often DNSResolver.Watch re-resolves.
+const DefaultRefresh = 30 * time.Second
+
+// ErrNoEndpoints is returned by Resolve when a service has no healthy
+// backends.
+var ErrNoEndpoints = errors.New("discovery: no healthy endpoints")
+
+// Endpoint is one healthy backend.
+type Endpoint struct {
+	// Addr is the host:port to connect to.
+	Addr string
+	// Zone is the backend's topology zone, if known.
+	Zone string
+	// Weight is the backend's relative share of traffic, at least 1.
+	Weight int
+}
+
+// Update is a change seen by Watch: the full set of endpoints, or an error,
+// after which the previous set is still the best known.
+type Update struct {
+	Endpoints []Endpoint
+	Err       error
+}
+
+// Resolver finds the endpoints of a service. Services are named as the
+// resolver expects: a DNS name for DNSResolver, "name" or "name.namespace"
+// for KubernetesResolver. Either may add ":port" to pick a port other than
+// the resolver's default.
+type Resolver interface {
+	// Resolve returns the current healthy endpoints of service, or
+	// ErrNoEndpoints if there are none.
+	Resolve(ctx context.Context, service string) ([]Endpoint, error)
+	// Watch sends an Update with the endpoints of service right away and
+	// whenever they change, until ctx is done, when it closes the
+	// channel. Failures are retried and reported as Updates with Err set.
+	Watch(ctx context.Context, service string) <-chan Update
+}
+
+// FromEnv returns the resolver configured by DISCOVERY_RESOLVER and its
+// companion variables.
+func FromEnv() (Resolver, error) {
+	port := os.Getenv("DISCOVERY_PORT_NAME")
+	if port == "" {
+		port = DefaultPortName
+	}
+	switch kind := strings.ToLower(os.Getenv("DISCOVERY_RESOLVER")); kind {
+	case "", "dns":
+		return &DNSResolver{PortName: port, Refresh: env.Duration("DISCOVERY_REFRESH", DefaultRefresh)}, nil
+	case "kubernetes", "k8s":
+		return NewKubernetesResolver(KubernetesResolverConfig{PortName: port})
+	default:
+		return nil, fmt.Errorf("discovery: unknown DISCOVERY_RESOLVER %q (want dns or kubernetes)", kind)
+	}
+}
+
+var defaultResolver = sync.OnceValues(FromEnv)
+
+// Default returns the resolver of FromEnv, created on first use. It backs
+// the "discovery" gRPC scheme and shared.Resolve.
+func Default() (Resolver, error) { return defaultResolver() }
+
+// normalize sorts endpoints and removes duplicates, so sets can be compared.
+func normalize(eps []Endpoint) []Endpoint {
+	for i := range eps {
+		eps[i].Weight = max(eps[i].Weight, 1)
+	}
+	slices.SortFunc(eps, func(a, b Endpoint) int { return cmp.Compare(a.Addr, b.Addr) })
+	return slices.CompactFunc(eps, func(a, b Endpoint) bool { return a.Addr == b.Addr })
+}
+
+// poll implements Watch by calling resolve every interval and sending the
+// changes.
+func poll(ctx context.Context, interval time.Duration, log *slog.Logger, service string, resolve func(context.Context) ([]Endpoint, error)) <-chan Update {
+	ch := make(chan Update, 1)
+	go func() {
+		defer close(ch)
+		var last []Endpoint
+		first := true
+		t := time.NewTicker(interval)
+		defer t.Stop()
+		for {
+			eps, err := resolve(ctx)
+			if errors.Is(err, ErrNoEndpoints) {
+				eps, err = nil, nil
+			}
+			var u *Update
+			switch {
+			case ctx.Err() != nil:
+				return
+			case err != nil:
+				log.Warn("resolving service failed", "service", service, "error", err)
+				u = &Update{Err: err}
+			case first || !slices.Equal(eps, last):
+				last, first = eps, false
+				u = &Update{Endpoints: eps}
+			}
+			if u != nil {
+				select {
+				case ch <- *u:
+				case <-ctx.Done():
+					return
+				}
+			}
+			select {
+			case <-t.C:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return ch
+}
@@ -0,0 +1,248 @@
+package discovery
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+var quiet = slog.New(slog.NewTextHandler(io.Discard, nil))
+
+type fakeLookup struct {
+	mu    sync.Mutex
+	hosts map[string][]string
+	srvs  map[string][]*net.SRV
+}
+
+func (l *fakeLookup) LookupHost(_ context.Context, host string) ([]string, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if ips, ok := l.hosts[host]; ok {
+		return ips, nil
+	}
+	return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+}
+
+func (l *fakeLookup) LookupSRV(_ context.Context, service, proto, name string) (string, []*net.SRV, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	key := fmt.Sprintf("_%s._%s.%s", service, proto, name)
+	if srvs, ok := l.srvs[key]; ok {
+		return key, srvs, nil
+	}
+	return "", nil, &net.DNSError{Err: "no such host", Name: key, IsNotFound: true}
+}
+
+func addrs(eps []Endpoint) []string {
+	var out []string
+	for _, ep := range eps {
+		out = append(out, ep.Addr)
+	}
+	return out
+}
+
+func TestDNSResolver(t *testing.T) {
+	l := &fakeLookup{
+		hosts: map[string][]string{
+			"cartservice":           {"10.0.0.2", "10.0.0.1", "10.0.0.2"},
+			"cart-0.cartservice":    {"10.0.0.1"},
+			"cart-1.cartservice":    {"10.0.0.2"},
+			"checkoutservice.local": {"10.0.1.1"},
+		},
+		srvs: map[string][]*net.SRV{
+			"_grpc._tcp.cartservice": {
+				{Target: "cart-0.cartservice.", Port: 7070, Weight: 10},
+				{Target: "cart-1.cartservice.", Port: 7070},
+				{Target: "cart-gone.cartservice.", Port: 7070},
+			},
+		},
+	}
+	r := &DNSResolver{Lookup: l}
+	ctx := context.Background()
+
+	eps, err := r.Resolve(ctx, "cartservice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Endpoint{{Addr: "10.0.0.1:7070", Weight: 10}, {Addr: "10.0.0.2:7070", Weight: 1}}
+	if !slices.Equal(eps, want) {
+		t.Errorf("SRV Resolve = %v, want %v", eps, want)
+	}
+
+	eps, err = r.Resolve(ctx, "cartservice:7070")
+	if err != nil || !slices.Equal(addrs(eps), []string{"10.0.0.1:7070", "10.0.0.2:7070"}) {
+		t.Errorf("host:port Resolve = %v, %v", eps, err)
+	}
+
+	if _, err := r.Resolve(ctx, "paymentservice"); err == nil {
+		t.Error("Resolve of an unknown service succeeded")
+	}
+	l.hosts["empty"] = nil
+	if _, err := r.Resolve(ctx, "empty:80"); !errors.Is(err, ErrNoEndpoints) {
+		t.Errorf("Resolve without addresses = %v, want ErrNoEndpoints", err)
+	}
+}
+
+func TestDNSResolverWatch(t *testing.T) {
+	l := &fakeLookup{hosts: map[string][]string{"cartservice": {"10.0.0.1"}}}
+	r := &DNSResolver{Lookup: l, Refresh: 5 * time.Millisecond, Logger: quiet}
+	ctx, cancel := context.WithCancel(context.Background())
+	ch := r.Watch(ctx, "cartservice:7070")
+
+	if u := <-ch; u.Err != nil || !slices.Equal(addrs(u.Endpoints), []string{"10.0.0.1:7070"}) {
+		t.Fatalf("first update = %+v", u)
+	}
+	// Unchanged answers send nothing; a change does.
+	time.Sleep(20 * time.Millisecond)
+	l.mu.Lock()
+	l.hosts["cartservice"] = []string{"10.0.0.1", "10.0.0.3"}
+	l.mu.Unlock()
+	if u := <-ch; !slices.Equal(addrs(u.Endpoints), []string{"10.0.0.1:7070", "10.0.0.3:7070"}) {
+		t.Fatalf("second update = %+v", u)
+	}
+	l.mu.Lock()
+	delete(l.hosts, "cartservice")
+	l.mu.Unlock()
+	if u := <-ch; u.Err == nil {
+		t.Fatalf("update after the records vanished = %+v, want an error", u)
+	}
+
+	cancel()
+	for range ch {
+	}
+}
+
+// fakeAPIServer serves the EndpointSlices of one Service and streams
+// watch events sent on events.
+type fakeAPIServer struct {
+	list   string
+	events chan string
+	gone   bool // answer the next watch with 410 Gone
+	mu     sync.Mutex
+	lists  int
+}
+
+func (s *fakeAPIServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/apis/discovery.k8s.io/v1/namespaces/shop/endpointslices" ||
+		r.URL.Query().Get("labelSelector") != "kubernetes.io/service-name=cartservice" {
+		http.Error(w, `{"message":"unexpected request"}`, http.StatusBadRequest)
+		return
+	}
+	if r.URL.Query().Get("watch") == "" {
+		s.mu.Lock()
+		s.lists++
+		s.mu.Unlock()
+		io.WriteString(w, s.list)
+		return
+	}
+	s.mu.Lock()
+	gone := s.gone
+	s.gone = false
+	s.mu.Unlock()
+	if gone {
+		json.NewEncoder(w).Encode(map[string]any{"type": "ERROR", "object": map[string]any{"code": 410, "message": "too old"}})
+		return
+	}
+	w.(http.Flusher).Flush()
+	for {
+		select {
+		case ev := <-s.events:
+			io.WriteString(w, ev+"\n")
+			w.(http.Flusher).Flush()
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+func slice(name, version, ready, zone string, ips ...string) string {
+	var eps []string
+	for _, ip := range ips {
+		eps = append(eps, fmt.Sprintf(`{"addresses":[%q],"conditions":{"ready":%s},"zone":%q}`, ip, ready, zone))
+	}
+	return fmt.Sprintf(`{"metadata":{"name":%q,"resourceVersion":%q},"endpoints":[%s],"ports":[{"name":"http","port":8080},{"name":"grpc","port":7070}]}`,
+		name, version, strings.Join(eps, ","))
+}
+
+func TestKubernetesResolver(t *testing.T) {
+	fake := &fakeAPIServer{
+		list: `{"metadata":{"resourceVersion":"10"},"items":[` +
+			slice("cart-a", "9", "true", "us-a", "10.0.0.1", "10.0.0.2") + "," +
+			slice("cart-b", "10", "false", "us-b", "10.0.0.3") + `]}`,
+		events: make(chan string),
+	}
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+	r, err := NewKubernetesResolver(KubernetesResolverConfig{
+		Namespace: "default", APIServer: srv.URL, TokenPath: "-", Client: srv.Client(), Logger: quiet,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	eps, err := r.Resolve(ctx, "cartservice.shop")
+	want := []Endpoint{{Addr: "10.0.0.1:7070", Zone: "us-a", Weight: 1}, {Addr: "10.0.0.2:7070", Zone: "us-a", Weight: 1}}
+	if err != nil || !slices.Equal(eps, want) {
+		t.Fatalf("Resolve = %v, %v; want %v", eps, err, want)
+	}
+	if eps, _ := r.Resolve(ctx, "cartservice.shop.svc.cluster.local:http"); len(eps) != 2 || eps[0].Addr != "10.0.0.1:8080" {
+		t.Errorf("Resolve on the http port = %v", eps)
+	}
+
+	ch := r.Watch(ctx, "cartservice.shop")
+	if u := <-ch; !slices.Equal(u.Endpoints, want) {
+		t.Fatalf("first update = %+v", u)
+	}
+	fake.events <- `{"type":"MODIFIED","object":` + slice("cart-b", "11", "true", "us-b", "10.0.0.3") + `}`
+	if u := <-ch; !slices.Equal(addrs(u.Endpoints), []string{"10.0.0.1:7070", "10.0.0.2:7070", "10.0.0.3:7070"}) {
+		t.Fatalf("update after cart-b became ready = %+v", u)
+	}
+	fake.events <- `{"type":"BOOKMARK","object":{"metadata":{"resourceVersion":"12"}}}`
+	fake.events <- `{"type":"DELETED","object":` + slice("cart-a", "13", "true", "us-a") + `}`
+	if u := <-ch; !slices.Equal(addrs(u.Endpoints), []string{"10.0.0.3:7070"}) {
+		t.Fatalf("update after cart-a was deleted = %+v", u)
+	}
+}
+
+func TestKubernetesResolverRelistsWhenGone(t *testing.T) {
+	fake := &fakeAPIServer{
+		list:   `{"metadata":{"resourceVersion":"1"},"items":[` + slice("cart-a", "1", "true", "", "10.0.0.1") + `]}`,
+		events: make(chan string),
+		gone:   true,
+	}
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+	r, err := NewKubernetesResolver(KubernetesResolverConfig{
+		Namespace: "shop", APIServer: srv.URL, TokenPath: "-", Client: srv.Client(), Logger: quiet,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ch := r.Watch(ctx, "cartservice")
+	<-ch
+	// The relisted set is unchanged, so only a later event shows up.
+	fake.events <- `{"type":"ADDED","object":` + slice("cart-b", "2", "true", "", "10.0.0.2") + `}`
+	if u := <-ch; u.Err != nil || len(u.Endpoints) != 2 {
+		t.Fatalf("update = %+v", u)
+	}
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	if fake.lists != 2 {
+		t.Errorf("listed %d times, want 2 (after 410 Gone)", fake.lists)
+	}
+}
@@ -0,0 +1,95 @@
+package discovery
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DNSResolver resolves headless Services through DNS. A name with a port,
+// such as "cartservice:7070", is looked up as A/AAAA records, which for a
+// headless Service are the ready pods' IPs. A name without one is looked up
+// as the SRV records of its named port (_grpc._tcp.cartservice...), which
+// carry the ports and weights too.
+//
+// DNS only shows readiness changes once the records' TTL expires, so prefer
+// KubernetesResolver where the pod may read EndpointSlices.
+type DNSResolver struct {
+	// PortName is the SRV port name (default DefaultPortName).
+	PortName string
+	// Refresh is how often Watch re-resolves (default DefaultRefresh).
+	Refresh time.Duration
+	// Lookup performs the queries. Nil uses net.DefaultResolver.
+	Lookup interface {
+		LookupHost(ctx context.Context, host string) ([]string, error)
+		LookupSRV(ctx context.Context, service, proto, name string) (string, []*net.SRV, error)
+	}
+	// Logger receives Watch failures. Nil uses slog.Default().
+	Logger *slog.Logger
+}
+
+// Resolve implements Resolver.
+func (r *DNSResolver) Resolve(ctx context.Context, service string) ([]Endpoint, error) {
+	lookup := r.Lookup
+	if lookup == nil {
+		lookup = net.DefaultResolver
+	}
+	var eps []Endpoint
+	if host, port, err := net.SplitHostPort(service); err == nil {
+		ips, err := lookup.LookupHost(ctx, host)
+		if err != nil {
+			return nil, fmt.Errorf("discovery: %w", err)
+		}
+		for _, ip := range ips {
+			eps = append(eps, Endpoint{Addr: net.JoinHostPort(ip, port)})
+		}
+	} else {
+		name := r.PortName
+		if name == "" {
+			name = DefaultPortName
+		}
+		_, srvs, err := lookup.LookupSRV(ctx, name, "tcp", service)
+		if err != nil {
+			return nil, fmt.Errorf("discovery: %w", err)
+		}
+		for _, srv := range srvs {
+			port := strconv.Itoa(int(srv.Port))
+			target := strings.TrimSuffix(srv.Target, ".")
+			ips, err := lookup.LookupHost(ctx, target)
+			if err != nil {
+				var dnsErr *net.DNSError
+				if errors.As(err, &dnsErr) && dnsErr.IsNotFound {
+					continue // the pod went away between the queries
+				}
+				return nil, fmt.Errorf("discovery: %w", err)
+			}
+			for _, ip := range ips {
+				eps = append(eps, Endpoint{Addr: net.JoinHostPort(ip, port), Weight: int(srv.Weight)})
+			}
+		}
+	}
+	if len(eps) == 0 {
+		return nil, fmt.Errorf("%w for %s", ErrNoEndpoints, service)
+	}
+	return normalize(eps), nil
+}
+
+// Watch implements Resolver by resolving every Refresh.
+func (r *DNSResolver) Watch(ctx context.Context, service string) <-chan Update {
+	refresh := r.Refresh
+	if refresh <= 0 {
+		refresh = DefaultRefresh
+	}
+	log := r.Logger
+	if log == nil {
+		log = slog.Default()
+	}
+	return poll(ctx, refresh, log, service, func(ctx context.Context) ([]Endpoint, error) {
+		return r.Resolve(ctx, service)
+	})
+}
@@ -0,0 +1,95 @@
+package discovery
+
+import (
+	"context"
+	"sync"
+
+	"google.golang.org/grpc/attributes"
+	"google.golang.org/grpc/resolver"
+)
+
+// Scheme is the gRPC target scheme served by this package, as in
+// "discovery:///cartservice".
+const Scheme = "discovery"
+
+func init() {
+	resolver.Register(&grpcBuilder{})
+}
+
+// NewGRPCBuilder returns a gRPC resolver for the "discovery" scheme backed
+// by r, for grpc.WithResolvers. Targets without one use Default.
+func NewGRPCBuilder(r Resolver) resolver.Builder {
+	return &grpcBuilder{r: r}
+}
+
+type grpcBuilder struct {
+	r Resolver // nil uses Default
+}
+
+func (b *grpcBuilder) Scheme() string { return Scheme }
+
+func (b *grpcBuilder) Build(t resolver.Target, cc resolver.ClientConn, _ resolver.BuildOptions) (resolver.Resolver, error) {
+	r := b.r
+	if r == nil {
+		var err error
+		if r, err = Default(); err != nil {
+			return nil, err
+		}
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	gr := &grpcResolver{cancel: cancel}
+	gr.wg.Add(1)
+	go func() {
+		defer gr.wg.Done()
+		resolved := false
+		for u := range r.Watch(ctx, t.Endpoint()) {
+			switch {
+			case u.Err != nil:
+				// Keep using the last addresses; only a resolver that
+				// never succeeded fails the RPCs waiting on it.
+				if !resolved {
+					cc.ReportError(u.Err)
+				}
+			case len(u.Endpoints) == 0:
+				cc.ReportError(ErrNoEndpoints)
+			default:
+				resolved = true
+				cc.UpdateState(resolver.State{Addresses: addresses(u.Endpoints)})
+			}
+		}
+	}()
+	return gr, nil
+}
+
+type grpcResolver struct {
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// ResolveNow does nothing: Watch already reports every change.
+func (r *grpcResolver) ResolveNow(resolver.ResolveNowOptions) {}
+
+func (r *grpcResolver) Close() {
+	r.cancel()
+	r.wg.Wait()
+}
+
+type endpointKey struct{}
+
+func addresses(eps []Endpoint) []resolver.Address {
+	addrs := make([]resolver.Address, len(eps))
+	for i, ep := range eps {
+		addrs[i] = resolver.Address{
+			Addr:               ep.Addr,
+			BalancerAttributes: attributes.New(endpointKey{}, ep),
+		}
+	}
+	return addrs
+}
+
+// AddressEndpoint returns the Endpoint a gRPC address was resolved from, so
+// balancers can weigh backends by zone or weight.
+func AddressEndpoint(addr resolver.Address) (Endpoint, bool) {
+	ep, ok := addr.BalancerAttributes.Value(endpointKey{}).(Endpoint)
+	return ep, ok
+}
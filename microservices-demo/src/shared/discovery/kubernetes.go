@@ -0,0 +1,325 @@
+package discovery
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/GoogleCloudPlatform/microservices-demo/src/shared/internal/kube"
+)
+
+// KubernetesResolverConfig configures NewKubernetesResolver.
+type KubernetesResolverConfig struct {
+	// Namespace is used for services named without one. Empty uses the
+	// pod's namespace.
+	Namespace string
+	// PortName is the Service port to connect to (default
+	// DefaultPortName). A slice with a single unnamed port uses that.
+	PortName string
+	// APIServer is the API server URL. Empty uses the in-cluster address.
+	APIServer string
+	// TokenPath is the bearer token. Empty uses the service account token;
+	// "-" sends no token.
+	TokenPath string
+	// Client makes the API calls. Nil trusts the service account CA. It
+	// must not have a Timeout, which would cut watches short.
+	Client *http.Client
+	// Logger receives watch failures. Nil uses slog.Default().
+	Logger *slog.Logger
+}
+
+// KubernetesResolver resolves Services from their discovery.k8s.io/v1
+// EndpointSlices, returning the ready endpoints with their zones. Watch
+// follows the API server's watch stream, so a pod failing its readiness
+// probe leaves the set as soon as the kubelet reports it.
+//
+// The pod's service account needs to list and watch endpointslices:
+//
+//	rules:
+//	- apiGroups: ["discovery.k8s.io"]
+//	  resources: ["endpointslices"]
+//	  verbs: ["list", "watch"]
+type KubernetesResolver struct {
+	cfg  KubernetesResolverConfig
+	kube kube.Client
+}
+
+// NewKubernetesResolver returns a KubernetesResolver, failing outside a
+// cluster unless cfg names an API server and namespace.
+func NewKubernetesResolver(cfg KubernetesResolverConfig) (*KubernetesResolver, error) {
+	client, err := kube.Client{APIServer: cfg.APIServer, TokenPath: cfg.TokenPath, HTTP: cfg.Client}.InCluster()
+	if err != nil {
+		return nil, fmt.Errorf("discovery: %w", err)
+	}
+	if cfg.Namespace == "" {
+		if cfg.Namespace, err = kube.Namespace(); err != nil {
+			return nil, fmt.Errorf("discovery: %w", err)
+		}
+	}
+	if cfg.PortName == "" {
+		cfg.PortName = DefaultPortName
+	}
+	if cfg.Logger == nil {
+		cfg.Logger = slog.Default()
+	}
+	return &KubernetesResolver{cfg: cfg, kube: client}, nil
+}
+
+// endpointSlice is the part of a discovery.k8s.io/v1 EndpointSlice the
+// resolver uses.
+type endpointSlice struct {
+	Metadata struct {
+		Name            string `json:"name"`
+		ResourceVersion string `json:"resourceVersion"`
+	} `json:"metadata"`
+	Endpoints []struct {
+		Addresses  []string `json:"addresses"`
+		Conditions struct {
+			Ready *bool `json:"ready"`
+		} `json:"conditions"`
+		Zone string `json:"zone"`
+	} `json:"endpoints"`
+	Ports []struct {
+		Name string `json:"name"`
+		Port int    `json:"port"`
+	} `json:"ports"`
+}
+
+// target is a service name split into what the API calls need.
+type target struct {
+	name, namespace, port string
+}
+
+func (r *KubernetesResolver) parse(service string) target {
+	t := target{namespace: r.cfg.Namespace, port: r.cfg.PortName}
+	if host, port, err := net.SplitHostPort(service); err == nil {
+		service, t.port = host, port
+	}
+	// Accept "name", "name.namespace" and "name.namespace.svc[.cluster.local]".
+	parts := strings.Split(service, ".")
+	t.name = parts[0]
+	if len(parts) > 1 {
+		t.namespace = parts[1]
+	}
+	return t
+}
+
+func (r *KubernetesResolver) slicesPath(t target) string {
+	q := url.Values{"labelSelector": {"kubernetes.io/service-name=" + t.name}}
+	return fmt.Sprintf("/apis/discovery.k8s.io/v1/namespaces/%s/endpointslices?%s", t.namespace, q.Encode())
+}
+
+// Resolve implements Resolver.
+func (r *KubernetesResolver) Resolve(ctx context.Context, service string) ([]Endpoint, error) {
+	t := r.parse(service)
+	set, _, err := r.list(ctx, t)
+	if err != nil {
+		return nil, err
+	}
+	eps := endpointsOf(set, t.port)
+	if len(eps) == 0 {
+		return nil, fmt.Errorf("%w for %s", ErrNoEndpoints, service)
+	}
+	return eps, nil
+}
+
+// list returns the Service's slices by name and the list's resourceVersion,
+// from which a watch continues.
+func (r *KubernetesResolver) list(ctx context.Context, t target) (map[string]endpointSlice, string, error) {
+	resp, err := r.kube.Do(ctx, http.MethodGet, r.slicesPath(t), nil)
+	if err != nil {
+		return nil, "", fmt.Errorf("discovery: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, "", fmt.Errorf("discovery: listing endpointslices of %s/%s: %w", t.namespace, t.name, kube.StatusError(resp))
+	}
+	var list struct {
+		Metadata struct {
+			ResourceVersion string `json:"resourceVersion"`
+		} `json:"metadata"`
+		Items []endpointSlice `json:"items"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&list); err != nil {
+		return nil, "", fmt.Errorf("discovery: decoding endpointslices: %w", err)
+	}
+	byName := make(map[string]endpointSlice, len(list.Items))
+	for _, s := range list.Items {
+		byName[s.Metadata.Name] = s
+	}
+	return byName, list.Metadata.ResourceVersion, nil
+}
+
+// errGone means the watch's resourceVersion is too old and the slices must
+// be listed again.
+var errGone = errors.New("discovery: watch expired")
+
+// Watch implements Resolver: it lists the slices, then follows their watch
+// stream, listing again whenever the stream breaks for longer than the API
+// server keeps history.
+func (r *KubernetesResolver) Watch(ctx context.Context, service string) <-chan Update {
+	t := r.parse(service)
+	ch := make(chan Update, 1)
+	send := func(u Update) bool {
+		select {
+		case ch <- u:
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	}
+	go func() {
+		defer close(ch)
+		var (
+			last    []Endpoint
+			sent    bool
+			backoff = time.Second
+		)
+		publish := func(set map[string]endpointSlice) bool {
+			eps := endpointsOf(set, t.port)
+			if sent && slices.Equal(eps, last) {
+				return true
+			}
+			last, sent = eps, true
+			return send(Update{Endpoints: eps})
+		}
+		for ctx.Err() == nil {
+			set, version, err := r.list(ctx, t)
+			if err == nil {
+				if !publish(set) {
+					return
+				}
+				backoff = time.Second
+				// Follow the stream until it fails; a plain timeout or
+				// disconnect resumes from the last version seen.
+				for err == nil {
+					version, err = r.watch(ctx, t, version, set, publish)
+				}
+				if errors.Is(err, errGone) || ctx.Err() != nil {
+					continue
+				}
+			}
+			r.cfg.Logger.Warn("watching endpointslices failed", "service", service, "error", err)
+			if !send(Update{Err: err}) {
+				return
+			}
+			select {
+			case <-time.After(backoff):
+			case <-ctx.Done():
+				return
+			}
+			backoff = min(2*backoff, 30*time.Second)
+		}
+	}()
+	return ch
+}
+
+// watch follows one watch request from version, applying events to set
+// and calling publish after each. It returns the last version seen and nil
+// when the API server ended the stream normally.
+func (r *KubernetesResolver) watch(ctx context.Context, t target, version string, set map[string]endpointSlice, publish func(map[string]endpointSlice) bool) (string, error) {
+	path := r.slicesPath(t) + "&" + url.Values{
+		"watch":               {"1"},
+		"resourceVersion":     {version},
+		"allowWatchBookmarks": {"true"},
+		"timeoutSeconds":      {"300"},
+	}.Encode()
+	resp, err := r.kube.Do(ctx, http.MethodGet, path, nil)
+	if err != nil {
+		return version, fmt.Errorf("discovery: %w", err)
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusGone:
+		return version, errGone
+	default:
+		return version, fmt.Errorf("discovery: watching endpointslices of %s/%s: %w", t.namespace, t.name, kube.StatusError(resp))
+	}
+	dec := json.NewDecoder(resp.Body)
+	for {
+		var ev struct {
+			Type   string          `json:"type"`
+			Object json.RawMessage `json:"object"`
+		}
+		if err := dec.Decode(&ev); err != nil {
+			if errors.Is(err, io.EOF) {
+				return version, nil
+			}
+			return version, fmt.Errorf("discovery: reading watch: %w", err)
+		}
+		if ev.Type == "ERROR" {
+			var st struct {
+				Code    int    `json:"code"`
+				Message string `json:"message"`
+			}
+			json.Unmarshal(ev.Object, &st)
+			if st.Code == http.StatusGone {
+				return version, errGone
+			}
+			return version, fmt.Errorf("discovery: watch error: %s", st.Message)
+		}
+		var s endpointSlice
+		if err := json.Unmarshal(ev.Object, &s); err != nil {
+			return version, fmt.Errorf("discovery: decoding watch event: %w", err)
+		}
+		version = s.Metadata.ResourceVersion
+		switch ev.Type {
+		case "ADDED", "MODIFIED":
+			set[s.Metadata.Name] = s
+		case "DELETED":
+			delete(set, s.Metadata.Name)
+		default: // BOOKMARK only moves the version on
+			continue
+		}
+		if !publish(set) {
+			return version, ctx.Err()
+		}
+	}
+}
+
+// endpointsOf returns the ready endpoints of set on port, a port name or
+// number.
+func endpointsOf(set map[string]endpointSlice, port string) []Endpoint {
+	var eps []Endpoint
+	for _, s := range set {
+		p, ok := slicePort(s, port)
+		if !ok {
+			continue
+		}
+		for _, e := range s.Endpoints {
+			if e.Conditions.Ready != nil && !*e.Conditions.Ready {
+				continue // nil means ready
+			}
+			for _, addr := range e.Addresses {
+				eps = append(eps, Endpoint{Addr: net.JoinHostPort(addr, strconv.Itoa(p)), Zone: e.Zone})
+			}
+		}
+	}
+	return normalize(eps)
+}
+
+func slicePort(s endpointSlice, port string) (int, bool) {
+	if n, err := strconv.Atoi(port); err == nil {
+		return n, true
+	}
+	for _, p := range s.Ports {
+		if p.Name == port {
+			return p.Port, true
+		}
+	}
+	if len(s.Ports) == 1 && s.Ports[0].Name == "" {
+		return s.Ports[0].Port, true
+	}
+	return 0, false
+}
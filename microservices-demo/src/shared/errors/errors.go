@@ -0,0 +1,185 @@
+// Package errors gives services one way to report failures: an Error carries
+// a gRPC code, a machine-readable reason such as "PRODUCT_NOT_FOUND", a
+// human-readable message and optional metadata. The same value converts to a
+// gRPC status (with the reason and metadata in an ErrorInfo detail) and to an
+// RFC 9457 application/problem+json response, so callers on either transport
+// can branch on the reason instead of parsing messages.
+//
+// The package name shadows the standard library; import it under another
+// name:
+//
+//	import apperrors "github.com/GoogleCloudPlatform/microservices-demo/src/shared/errors"
+//
+//	p, ok := s.products[id]
+//	if !ok {
+//	    return nil, apperrors.NotFound("PRODUCT_NOT_FOUND", "no product with ID %q", id).
+//	        With("product_id", id)
+//	}
+package errors
+
+import (
+	"context"
+	stderrors "errors"
+	"fmt"
+	"maps"
+
+	"google.golang.org/grpc/codes"
+)
+
+// Error is a failure with a code, a reason and metadata.
+type Error struct {
+	// Code classifies the failure and selects the gRPC code and HTTP status.
+	Code codes.Code
+	// Reason is a stable UPPER_SNAKE_CASE identifier clients may match on.
+	Reason string
+	// Message describes the failure to a human.
+	Message string
+	// Metadata holds structured details, e.g. the ID that was not found.
+	Metadata map[string]string
+
+	cause error
+}
+
+// New returns an Error with the given code and reason. The message is
+// formatted as with fmt.Errorf; a %w verb records the wrapped error as the
+// cause returned by Unwrap.
+func New(code codes.Code, reason, format string, args ...any) *Error {
+	err := fmt.Errorf(format, args...)
+	return &Error{Code: code, Reason: reason, Message: err.Error(), cause: stderrors.Unwrap(err)}
+}
+
+// InvalidArgument reports a malformed request.
+func InvalidArgument(reason, format string, args ...any) *Error {
+	return New(codes.InvalidArgument, reason, format, args...)
+}
+
+// NotFound reports that a requested entity does not exist.
+func NotFound(reason, format string, args ...any) *Error {
+	return New(codes.NotFound, reason, format, args...)
+}
+
+// AlreadyExists reports that an entity the caller tried to create exists.
+func AlreadyExists(reason, format string, args ...any) *Error {
+	return New(codes.AlreadyExists, reason, format, args...)
+}
+
+// Conflict reports a concurrent modification the caller may retry after
+// re-reading, e.g. a version mismatch. It maps to codes.Aborted.
+func Conflict(reason, format string, args ...any) *Error {
+	return New(codes.Aborted, reason, format, args...)
+}
+
+// FailedPrecondition reports that the system is not in a state the
+// operation requires, e.g. checking out an empty cart.
+func FailedPrecondition(reason, format string, args ...any) *Error {
+	return New(codes.FailedPrecondition, reason, format, args...)
+}
+
+// Unauthenticated reports missing or invalid credentials.
+func Unauthenticated(reason, format string, args ...any) *Error {
+	return New(codes.Unauthenticated, reason, format, args...)
+}
+
+// PermissionDenied reports that the caller may not perform the operation.
+func PermissionDenied(reason, format string, args ...any) *Error {
+	return New(codes.PermissionDenied, reason, format, args...)
+}
+
+// ResourceExhausted reports a quota or rate limit.
+func ResourceExhausted(reason, format string, args ...any) *Error {
+	return New(codes.ResourceExhausted, reason, format, args...)
+}
+
+// Unavailable reports a transient failure worth retrying.
+func Unavailable(reason, format string, args ...any) *Error {
+	return New(codes.Unavailable, reason, format, args...)
+}
+
+// DeadlineExceeded reports that the operation ran out of time.
+func DeadlineExceeded(reason, format string, args ...any) *Error {
+	return New(codes.DeadlineExceeded, reason, format, args...)
+}
+
+// Unimplemented reports an operation the server does not support.
+func Unimplemented(reason, format string, args ...any) *Error {
+	return New(codes.Unimplemented, reason, format, args...)
+}
+
+// Internal reports a bug or broken invariant on the server.
+func Internal(reason, format string, args ...any) *Error {
+	return New(codes.Internal, reason, format, args...)
+}
+
+// Error returns the message.
+func (e *Error) Error() string {
+	if e.Message == "" {
+		return e.Code.String()
+	}
+	return e.Message
+}
+
+// Unwrap returns the error wrapped with %w, if any.
+func (e *Error) Unwrap() error { return e.cause }
+
+// With returns a copy of e with the given key/value pairs added to its
+// metadata. A trailing key without a value is ignored.
+func (e *Error) With(kv ...string) *Error {
+	c := *e
+	c.Metadata = maps.Clone(e.Metadata)
+	if c.Metadata == nil {
+		c.Metadata = make(map[string]string, len(kv)/2)
+	}
+	for i := 0; i+1 < len(kv); i += 2 {
+		c.Metadata[kv[i]] = kv[i+1]
+	}
+	return &c
+}
+
+// Is reports whether target is an *Error with the same code and reason, so
+// errors.Is(err, apperrors.NotFound("PRODUCT_NOT_FOUND", "")) matches
+// regardless of message and metadata.
+func (e *Error) Is(target error) bool {
+	t, ok := target.(*Error)
+	return ok && t.Code == e.Code && t.Reason == e.Reason
+}
+
+// From converts any error to an *Error. It returns the *Error in err's
+// chain if there is one, rebuilds one from a gRPC status (such as a
+// downstream call's error, including its ErrorInfo), maps context
+// cancellation and expiry, and otherwise returns codes.Unknown wrapping err.
+// From(nil) is nil.
+func From(err error) *Error {
+	if err == nil {
+		return nil
+	}
+	var e *Error
+	if stderrors.As(err, &e) {
+		return e
+	}
+	if e, ok := fromStatus(err); ok {
+		return e
+	}
+	switch {
+	case stderrors.Is(err, context.Canceled):
+		return &Error{Code: codes.Canceled, Message: err.Error(), cause: err}
+	case stderrors.Is(err, context.DeadlineExceeded):
+		return &Error{Code: codes.DeadlineExceeded, Message: err.Error(), cause: err}
+	}
+	return &Error{Code: codes.Unknown, Message: err.Error(), cause: err}
+}
+
+// CodeOf returns the code From(err) would carry; codes.OK for nil.
+func CodeOf(err error) codes.Code {
+	if err == nil {
+		return codes.OK
+	}
+	return From(err).Code
+}
+
+// ReasonOf returns the reason From(err) would carry, or "".
+func ReasonOf(err error) string {
+	if err == nil {
+		return ""
+	}
+	return From(err).Reason
+}
@@ -0,0 +1,145 @@
+package errors
+
+import (
+	"context"
+	"encoding/json"
+	stderrors "errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestNewWrapsCause(t *testing.T) {
+	cause := stderrors.New("connection refused")
+	err := Unavailable("CART_STORE_DOWN", "cart store: %w", cause).With("store", "redis")
+	if err.Error() != "cart store: connection refused" {
+		t.Errorf("Error() = %q", err.Error())
+	}
+	if !stderrors.Is(err, cause) {
+		t.Error("errors.Is does not find the %w cause")
+	}
+	if !stderrors.Is(fmt.Errorf("checkout: %w", err), Unavailable("CART_STORE_DOWN", "")) {
+		t.Error("errors.Is does not match on code and reason")
+	}
+	if stderrors.Is(err, Unavailable("OTHER", "")) {
+		t.Error("errors.Is matched a different reason")
+	}
+	if err.Metadata["store"] != "redis" {
+		t.Errorf("Metadata = %v", err.Metadata)
+	}
+}
+
+func TestWithCopies(t *testing.T) {
+	base := NotFound("PRODUCT_NOT_FOUND", "not found").With("a", "1")
+	derived := base.With("b", "2")
+	if _, ok := base.Metadata["b"]; ok {
+		t.Error("With modified the receiver's metadata")
+	}
+	if len(derived.Metadata) != 2 {
+		t.Errorf("derived metadata = %v", derived.Metadata)
+	}
+}
+
+func TestStatusRoundTrip(t *testing.T) {
+	err := NotFound("PRODUCT_NOT_FOUND", "no product %q", "OLJCESPC7Z").With("product_id", "OLJCESPC7Z")
+	st := status.Convert(err)
+	if st.Code() != codes.NotFound || st.Message() != `no product "OLJCESPC7Z"` {
+		t.Fatalf("status = %v %q", st.Code(), st.Message())
+	}
+
+	// A client sees only the status; From recovers the reason and metadata.
+	got := From(st.Err())
+	if got.Code != codes.NotFound || got.Reason != "PRODUCT_NOT_FOUND" || got.Metadata["product_id"] != "OLJCESPC7Z" {
+		t.Errorf("From(status) = %+v", got)
+	}
+}
+
+func TestFrom(t *testing.T) {
+	tests := []struct {
+		err    error
+		code   codes.Code
+		reason string
+	}{
+		{nil, codes.OK, ""},
+		{fmt.Errorf("wrapped: %w", Conflict("VERSION_MISMATCH", "stale")), codes.Aborted, "VERSION_MISMATCH"},
+		{status.Error(codes.Unavailable, "down"), codes.Unavailable, ""},
+		{fmt.Errorf("call: %w", context.DeadlineExceeded), codes.DeadlineExceeded, ""},
+		{context.Canceled, codes.Canceled, ""},
+		{stderrors.New("boom"), codes.Unknown, ""},
+	}
+	for _, tt := range tests {
+		if got := CodeOf(tt.err); got != tt.code {
+			t.Errorf("CodeOf(%v) = %v, want %v", tt.err, got, tt.code)
+		}
+		if got := ReasonOf(tt.err); got != tt.reason {
+			t.Errorf("ReasonOf(%v) = %q, want %q", tt.err, got, tt.reason)
+		}
+	}
+}
+
+func TestUnaryServerInterceptor(t *testing.T) {
+	intercept := UnaryServerInterceptor()
+	info := &grpc.UnaryServerInfo{FullMethod: "/svc/M"}
+	tests := []struct {
+		err  error
+		code codes.Code
+	}{
+		{fmt.Errorf("lookup: %w", NotFound("X", "missing")), codes.NotFound},
+		{fmt.Errorf("query: %w", context.DeadlineExceeded), codes.DeadlineExceeded},
+		{status.Error(codes.PermissionDenied, "no"), codes.PermissionDenied},
+		{stderrors.New("plain"), codes.Unknown},
+	}
+	for _, tt := range tests {
+		_, err := intercept(context.Background(), nil, info, func(context.Context, any) (any, error) {
+			return nil, tt.err
+		})
+		if got := status.Code(err); got != tt.code {
+			t.Errorf("%v: code = %v, want %v", tt.err, got, tt.code)
+		}
+	}
+	_, err := intercept(context.Background(), nil, info, func(context.Context, any) (any, error) {
+		return nil, fmt.Errorf("lookup: %w", NotFound("X", "missing").With("id", "1"))
+	})
+	if got := From(err); got.Reason != "X" || got.Metadata["id"] != "1" {
+		t.Errorf("wrapped error lost its reason or metadata: %+v", got)
+	}
+}
+
+func TestWriteProblem(t *testing.T) {
+	tests := []struct {
+		err    error
+		status int
+		detail string
+		code   string
+	}{
+		{InvalidArgument("BAD_CURRENCY", "unsupported currency %q", "XYZ"), http.StatusBadRequest, `unsupported currency "XYZ"`, "BAD_CURRENCY"},
+		{status.Error(codes.Unavailable, "payment down"), http.StatusServiceUnavailable, "payment down", ""},
+		{stderrors.New("dial tcp 10.0.0.7:5432: refused"), http.StatusInternalServerError, "", ""},
+	}
+	for _, tt := range tests {
+		h := HandlerFunc(func(w http.ResponseWriter, r *http.Request) error { return tt.err })
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest("GET", "/api/cart", nil))
+
+		if rec.Code != tt.status {
+			t.Errorf("%v: status = %d, want %d", tt.err, rec.Code, tt.status)
+		}
+		if ct := rec.Header().Get("Content-Type"); ct != ProblemContentType {
+			t.Errorf("Content-Type = %q", ct)
+		}
+		var p Problem
+		body, _ := io.ReadAll(rec.Body)
+		if err := json.Unmarshal(body, &p); err != nil {
+			t.Fatalf("body %s: %v", body, err)
+		}
+		if p.Status != tt.status || p.Detail != tt.detail || p.Code != tt.code || p.Instance != "/api/cart" {
+			t.Errorf("%v: problem = %+v", tt.err, p)
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package errors
+
+import (
+	"context"
+	stderrors "errors"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+)
+
+// Domain is the ErrorInfo domain attached to statuses built from an Error.
+const Domain = "boutique"
+
+// GRPCStatus converts e to a status, adding an ErrorInfo detail when e has
+// a reason or metadata. gRPC calls it when a handler returns e, so handlers
+// can return an *Error without converting it themselves.
+func (e *Error) GRPCStatus() *status.Status {
+	s := status.New(e.Code, e.Error())
+	if e.Reason == "" && len(e.Metadata) == 0 {
+		return s
+	}
+	ds, err := s.WithDetails(&errdetails.ErrorInfo{Reason: e.Reason, Domain: Domain, Metadata: e.Metadata})
+	if err != nil {
+		return s
+	}
+	return ds
+}
+
+// fromStatus rebuilds an Error from a gRPC status error.
+func fromStatus(err error) (*Error, bool) {
+	var se interface{ GRPCStatus() *status.Status }
+	if !stderrors.As(err, &se) {
+		return nil, false
+	}
+	s := se.GRPCStatus()
+	e := &Error{Code: s.Code(), Message: s.Message(), cause: err}
+	for _, d := range s.Details() {
+		if info, ok := d.(*errdetails.ErrorInfo); ok {
+			e.Reason = info.GetReason()
+			e.Metadata = info.GetMetadata()
+			break
+		}
+	}
+	return e, true
+}
+
+// ToStatus returns the status for err: nil for nil, otherwise From(err)'s
+// status with its reason and metadata.
+func ToStatus(err error) error {
+	if err == nil {
+		return nil
+	}
+	return From(err).GRPCStatus().Err()
+}
+
+// toRPCError maps a handler's error to a status. Plain errors are left for
+// gRPC to report as codes.Unknown.
+func toRPCError(err error) error {
+	if err == nil {
+		return nil
+	}
+	var e *Error
+	if stderrors.As(err, &e) {
+		return e.GRPCStatus().Err()
+	}
+	if _, ok := status.FromError(err); ok {
+		return err
+	}
+	if stderrors.Is(err, context.Canceled) || stderrors.Is(err, context.DeadlineExceeded) {
+		return status.FromContextError(err).Err()
+	}
+	return err
+}
+
+// UnaryServerInterceptor converts errors returned by handlers to statuses:
+// an *Error anywhere in the chain keeps its code, reason and metadata even
+// when wrapped with fmt.Errorf, and context cancellation and expiry become
+// codes.Canceled and codes.DeadlineExceeded instead of codes.Unknown.
+func UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		resp, err := handler(ctx, req)
+		return resp, toRPCError(err)
+	}
+}
+
+// StreamServerInterceptor is the streaming counterpart of
+// UnaryServerInterceptor.
+func StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		return toRPCError(handler(srv, ss))
+	}
+}
@@ -0,0 +1,131 @@
+package errors
+
+import (
+	"encoding/json"
+	stderrors "errors"
+	"log/slog"
+	"net/http"
+
+	"google.golang.org/grpc/codes"
+
+	"github.com/GoogleCloudPlatform/microservices-demo/src/shared/requestid"
+)
+
+// ProblemContentType is the media type of RFC 9457 problem details.
+const ProblemContentType = "application/problem+json"
+
+// httpStatuses follows the mapping used by grpc-gateway.
+var httpStatuses = map[codes.Code]int{
+	codes.OK:                 http.StatusOK,
+	codes.Canceled:           499, // client closed request
+	codes.Unknown:            http.StatusInternalServerError,
+	codes.InvalidArgument:    http.StatusBadRequest,
+	codes.DeadlineExceeded:   http.StatusGatewayTimeout,
+	codes.NotFound:           http.StatusNotFound,
+	codes.AlreadyExists:      http.StatusConflict,
+	codes.PermissionDenied:   http.StatusForbidden,
+	codes.ResourceExhausted:  http.StatusTooManyRequests,
+	codes.FailedPrecondition: http.StatusBadRequest,
+	codes.Aborted:            http.StatusConflict,
+	codes.OutOfRange:         http.StatusBadRequest,
+	codes.Unimplemented:      http.StatusNotImplemented,
+	codes.Internal:           http.StatusInternalServerError,
+	codes.Unavailable:        http.StatusServiceUnavailable,
+	codes.DataLoss:           http.StatusInternalServerError,
+	codes.Unauthenticated:    http.StatusUnauthorized,
+}
+
+// HTTPStatus returns the HTTP status for a gRPC code.
+func HTTPStatus(c codes.Code) int {
+	if s, ok := httpStatuses[c]; ok {
+		return s
+	}
+	return http.StatusInternalServerError
+}
+
+// Problem is an RFC 9457 problem details body. Code and Metadata are
+// extension members carrying the Error's reason and metadata.
+type Problem struct {
+	Type      string            `json:"type"`
+	Title     string            `json:"title"`
+	Status    int               `json:"status"`
+	Detail    string            `json:"detail,omitempty"`
+	Instance  string            `json:"instance,omitempty"`
+	Code      string            `json:"code,omitempty"`
+	Metadata  map[string]string `json:"metadata,omitempty"`
+	RequestID string            `json:"request_id,omitempty"`
+}
+
+// ProblemFor builds the Problem describing err in response to r. Plain
+// errors, which carry no code, become 500s whose text is logged rather than
+// sent, since it may expose internals.
+func ProblemFor(r *http.Request, err error) Problem {
+	e := From(err)
+	code := HTTPStatus(e.Code)
+	p := Problem{
+		Type:      "about:blank",
+		Title:     http.StatusText(code),
+		Status:    code,
+		Detail:    e.Error(),
+		Instance:  r.URL.Path,
+		Code:      e.Reason,
+		Metadata:  e.Metadata,
+		RequestID: requestid.FromContext(r.Context()),
+	}
+	if e.Code == codes.Unknown && !typed(err) {
+		slog.ErrorContext(r.Context(), "request failed", "path", r.URL.Path, "error", err)
+		p.Detail = ""
+	}
+	if p.Title == "" {
+		p.Title = e.Code.String()
+	}
+	return p
+}
+
+// typed reports whether err carries an *Error or a gRPC status, whose
+// messages are meant for callers.
+func typed(err error) bool {
+	var e *Error
+	if stderrors.As(err, &e) {
+		return true
+	}
+	_, ok := fromStatus(err)
+	return ok
+}
+
+// WriteProblem writes err as an application/problem+json response.
+//
+// Usage:
+//
+//	if err := s.placeOrder(r.Context(), req); err != nil {
+//	    apperrors.WriteProblem(w, r, err)
+//	    return
+//	}
+func WriteProblem(w http.ResponseWriter, r *http.Request, err error) {
+	p := ProblemFor(r, err)
+	w.Header().Set("Content-Type", ProblemContentType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(p.Status)
+	json.NewEncoder(w).Encode(p)
+}
+
+// HandlerFunc is an HTTP handler that returns its failure instead of
+// writing it; ServeHTTP writes a non-nil error with WriteProblem.
+//
+// Usage:
+//
+//	mux.Handle("/api/cart", apperrors.HandlerFunc(func(w http.ResponseWriter, r *http.Request) error {
+//	    cart, err := s.cart(r.Context())
+//	    if err != nil {
+//	        return err
+//	    }
+//	    return json.NewEncoder(w).Encode(cart)
+//	}))
+type HandlerFunc func(w http.ResponseWriter, r *http.Request) error
+
+// ServeHTTP calls f and writes its error, if any, as a problem response.
+func (f HandlerFunc) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if err := f(w, r); err != nil {
+		WriteProblem(w, r, err)
+	}
+}
@@ -0,0 +1,76 @@
+package eventbus
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"google.golang.org/protobuf/proto"
+)
+
+// ContentTypeHeader records the codec a payload was encoded with.
+const ContentTypeHeader = "content-type"
+
+// Codec encodes message payloads.
+type Codec interface {
+	ContentType() string
+	Marshal(v any) ([]byte, error)
+	Unmarshal(data []byte, v any) error
+}
+
+// Built-in codecs.
+var (
+	JSON  Codec = jsonCodec{}
+	Proto Codec = protoCodec{}
+)
+
+var codecs = map[string]Codec{
+	JSON.ContentType():  JSON,
+	Proto.ContentType(): Proto,
+}
+
+// NewMessage encodes v with c into a new message carrying c's content type.
+func NewMessage(c Codec, v any) (*Message, error) {
+	b, err := c.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	m := &Message{Payload: b}
+	m.SetHeader(ContentTypeHeader, c.ContentType())
+	return m, nil
+}
+
+// Decode decodes m's payload into v using the codec named by its content
+// type, defaulting to JSON.
+func Decode(m *Message, v any) error {
+	c, ok := codecs[m.Header(ContentTypeHeader)]
+	if !ok {
+		c = JSON
+	}
+	return c.Unmarshal(m.Payload, v)
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) ContentType() string                { return "application/json" }
+func (jsonCodec) Marshal(v any) ([]byte, error)      { return json.Marshal(v) }
+func (jsonCodec) Unmarshal(data []byte, v any) error { return json.Unmarshal(data, v) }
+
+type protoCodec struct{}
+
+func (protoCodec) ContentType() string { return "application/x-protobuf" }
+
+func (protoCodec) Marshal(v any) ([]byte, error) {
+	m, ok := v.(proto.Message)
+	if !ok {
+		return nil, fmt.Errorf("eventbus: %T is not a proto.Message", v)
+	}
+	return proto.Marshal(m)
+}
+
+func (protoCodec) Unmarshal(data []byte, v any) error {
+	m, ok := v.(proto.Message)
+	if !ok {
+		return fmt.Errorf("eventbus: %T is not a proto.Message", v)
+	}
+	return proto.Unmarshal(data, m)
+}
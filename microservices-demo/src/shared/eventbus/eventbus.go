@@ -0,0 +1,97 @@
+// Package eventbus is a broker-neutral publish/subscribe API with
+// at-least-once delivery. A message is acknowledged only after its handler
+// returns nil; a handler error, crash or lost connection leads to
+// redelivery, so handlers must be idempotent.
+//
+// Subscribers sharing a group split a topic's messages between them; each
+// group receives every message. Trace context travels in message headers,
+// so a consumer's span joins the producer's trace.
+//
+// Backends:
+//
+//	eventbus.NewMemory()          in-process, for tests and local runs
+//	kafka.New(kafka.Config{...})  github.com/.../shared/eventbus/kafka
+//	nats.New(nc, nats.Config{...}) github.com/.../shared/eventbus/nats (JetStream)
+//
+// Usage:
+//
+//	msg, err := eventbus.NewMessage(eventbus.Proto, &pb.OrderResult{...})
+//	msg.Key = []byte(orderID)
+//	err = bus.Publish(ctx, "orders.placed", msg)
+//
+//	policy := eventbus.DeliveryPolicy{Retry: shared.DefaultRetryPolicy, DeadLetter: bus}
+//	err = bus.Subscribe(ctx, "orders.placed", "emailservice", policy.Wrap(
+//	    func(ctx context.Context, m *eventbus.Message) error {
+//	        var order pb.OrderResult
+//	        if err := eventbus.Decode(m, &order); err != nil {
+//	            return shared.Permanent(err)
+//	        }
+//	        return sendConfirmation(ctx, &order)
+//	    }))
+package eventbus
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// ErrClosed is returned by operations on a closed Publisher or Subscriber.
+var ErrClosed = errors.New("eventbus: closed")
+
+// Message is a unit of publication.
+type Message struct {
+	// ID uniquely identifies the message; Publish fills it in when empty.
+	ID string
+	// Topic is set on delivery.
+	Topic string
+	// Key selects the partition (Kafka) so messages with equal keys keep
+	// their order. Optional.
+	Key     []byte
+	Payload []byte
+	Headers map[string]string
+	// Timestamp is the publish time; Publish fills it in when zero.
+	Timestamp time.Time
+	// Attempt is the 1-based delivery attempt, set on delivery.
+	Attempt int
+}
+
+// Header returns the named header, or "" if unset.
+func (m *Message) Header(name string) string {
+	return m.Headers[name]
+}
+
+// SetHeader sets a header, allocating the map if needed.
+func (m *Message) SetHeader(name, value string) {
+	if m.Headers == nil {
+		m.Headers = make(map[string]string)
+	}
+	m.Headers[name] = value
+}
+
+// Handler processes a delivered message. Returning nil acknowledges it; any
+// error leads to redelivery.
+type Handler func(ctx context.Context, m *Message) error
+
+// Publisher sends messages to a topic.
+type Publisher interface {
+	// Publish sends msgs to topic and returns once the broker has accepted
+	// all of them.
+	Publish(ctx context.Context, topic string, msgs ...*Message) error
+	Close() error
+}
+
+// Subscriber delivers messages to handlers.
+type Subscriber interface {
+	// Subscribe consumes topic as a member of group, calling h for each
+	// message. It blocks until ctx is done (returning nil) or the
+	// subscription fails.
+	Subscribe(ctx context.Context, topic, group string, h Handler) error
+	Close() error
+}
+
+// Bus is a backend implementing both sides.
+type Bus interface {
+	Publisher
+	Subscriber
+}
@@ -0,0 +1,167 @@
+package eventbus
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+
+	shared "github.com/GoogleCloudPlatform/microservices-demo/src/shared"
+)
+
+// subscribe runs b.Subscribe in the background until the test ends.
+func subscribe(t *testing.T, b *Memory, topic, group string, h Handler) {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if err := b.Subscribe(ctx, topic, group, h); err != nil {
+			t.Errorf("Subscribe: %v", err)
+		}
+	}()
+	t.Cleanup(func() { cancel(); <-done })
+}
+
+func TestMemoryGroups(t *testing.T) {
+	b := NewMemory()
+	var mu sync.Mutex
+	got := map[string]int{}
+	var wg sync.WaitGroup
+	wg.Add(4) // 2 messages x 2 groups
+	record := func(group string) Handler {
+		return func(ctx context.Context, m *Message) error {
+			mu.Lock()
+			got[group]++
+			mu.Unlock()
+			wg.Done()
+			return nil
+		}
+	}
+	// Two members of "a" share its messages; "b" gets its own copy.
+	subscribe(t, b, "orders", "a", record("a"))
+	subscribe(t, b, "orders", "a", record("a"))
+	subscribe(t, b, "orders", "b", record("b"))
+
+	if err := b.Publish(context.Background(), "orders", &Message{Payload: []byte("1")}, &Message{Payload: []byte("2")}); err != nil {
+		t.Fatal(err)
+	}
+	wg.Wait()
+	if got["a"] != 2 || got["b"] != 2 {
+		t.Errorf("deliveries = %v, want 2 per group", got)
+	}
+}
+
+func TestMemoryRedeliversOnError(t *testing.T) {
+	b := NewMemory()
+	attempts := make(chan int, 3)
+	subscribe(t, b, "t", "g", func(ctx context.Context, m *Message) error {
+		attempts <- m.Attempt
+		if m.Attempt < 3 {
+			return errors.New("try again")
+		}
+		return nil
+	})
+	b.Publish(context.Background(), "t", &Message{})
+	for want := 1; want <= 3; want++ {
+		select {
+		case got := <-attempts:
+			if got != want {
+				t.Fatalf("attempt = %d, want %d", got, want)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("message not redelivered")
+		}
+	}
+}
+
+func TestDeliveryPolicyDeadLetters(t *testing.T) {
+	b := NewMemory()
+	calls := 0
+	h := DeliveryPolicy{
+		Retry:      shared.RetryPolicy{MaxAttempts: 3},
+		DeadLetter: b,
+		Group:      "g",
+	}.Wrap(func(ctx context.Context, m *Message) error {
+		calls++
+		return errors.New("poison")
+	})
+
+	m := &Message{ID: "m1", Topic: "orders", Payload: []byte("x"), Attempt: 1}
+	if err := h(context.Background(), m); err != nil {
+		t.Fatalf("handler = %v, want nil after dead-lettering", err)
+	}
+	if calls != 3 {
+		t.Errorf("handler called %d times, want 3", calls)
+	}
+	dlq := b.Messages("orders.dlq")
+	if len(dlq) != 1 || dlq[0].Header(DeadLetterReasonHeader) != "poison" || dlq[0].Header(DeadLetterIDHeader) != "m1" {
+		t.Fatalf("dead letters = %+v", dlq)
+	}
+}
+
+func TestDeliveryPolicyPermanentSkipsRetries(t *testing.T) {
+	b := NewMemory()
+	calls := 0
+	h := DeliveryPolicy{Retry: shared.RetryPolicy{MaxAttempts: 5}, DeadLetter: b}.Wrap(
+		func(ctx context.Context, m *Message) error {
+			calls++
+			return shared.Permanent(errors.New("bad payload"))
+		})
+	h(context.Background(), &Message{Topic: "t"})
+	if calls != 1 || len(b.Messages("t.dlq")) != 1 {
+		t.Errorf("calls = %d, dead letters = %d; want 1, 1", calls, len(b.Messages("t.dlq")))
+	}
+}
+
+func TestCodecs(t *testing.T) {
+	m, err := NewMessage(Proto, wrapperspb.String("hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got wrapperspb.StringValue
+	if err := Decode(m, &got); err != nil || got.GetValue() != "hello" {
+		t.Errorf("proto Decode = %q, %v", got.GetValue(), err)
+	}
+
+	m, _ = NewMessage(JSON, map[string]int{"n": 1})
+	var v map[string]int
+	if err := Decode(m, &v); err != nil || v["n"] != 1 {
+		t.Errorf("JSON Decode = %v, %v", v, err)
+	}
+}
+
+func TestTraceContextPropagates(t *testing.T) {
+	prev := otel.GetTextMapPropagator()
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	defer otel.SetTextMapPropagator(prev)
+
+	sc := trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID:    trace.TraceID{1, 2, 3},
+		SpanID:     trace.SpanID{4, 5, 6},
+		TraceFlags: trace.FlagsSampled,
+	})
+	ctx := trace.ContextWithSpanContext(context.Background(), sc)
+
+	b := NewMemory()
+	got := make(chan trace.TraceID, 1)
+	subscribe(t, b, "t", "g", func(ctx context.Context, m *Message) error {
+		got <- trace.SpanContextFromContext(ctx).TraceID()
+		return nil
+	})
+	b.Publish(ctx, "t", &Message{})
+	select {
+	case id := <-got:
+		if id != sc.TraceID() {
+			t.Errorf("consumer trace ID = %v, want %v", id, sc.TraceID())
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("message not delivered")
+	}
+}
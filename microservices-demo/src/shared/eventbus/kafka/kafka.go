@@ -0,0 +1,226 @@
+// Package kafka implements eventbus.Bus on Apache Kafka using consumer
+// groups. Offsets are committed only after a handler succeeds; on failure
+// the same message is retried with backoff before the partition advances,
+// preserving per-key ordering. Wrap handlers in an eventbus.DeliveryPolicy
+// to bound that and dead-letter poison messages.
+package kafka
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	kafkago "github.com/segmentio/kafka-go"
+
+	shared "github.com/GoogleCloudPlatform/microservices-demo/src/shared"
+	"github.com/GoogleCloudPlatform/microservices-demo/src/shared/eventbus"
+)
+
+// idHeader carries the eventbus message ID, which Kafka has no slot for.
+const idHeader = "message-id"
+
+// Config configures the Kafka bus.
+type Config struct {
+	Brokers  []string `env:"KAFKA_BROKERS" default:"localhost:9092"`
+	ClientID string   `env:"KAFKA_CLIENT_ID"`
+	// StartOffset is where a new group begins: "earliest" or "latest".
+	StartOffset string `env:"KAFKA_START_OFFSET" default:"earliest"`
+	// BatchTimeout bounds how long the producer waits to fill a batch.
+	BatchTimeout time.Duration `env:"KAFKA_BATCH_TIMEOUT" default:"10ms"`
+	// RedeliveryBackoff is the first delay before retrying a failed message;
+	// it doubles up to MaxRedeliveryBackoff.
+	RedeliveryBackoff    time.Duration `env:"KAFKA_REDELIVERY_BACKOFF" default:"1s"`
+	MaxRedeliveryBackoff time.Duration `env:"KAFKA_MAX_REDELIVERY_BACKOFF" default:"30s"`
+	// TLS, if set, secures broker connections.
+	TLS *tls.Config
+}
+
+// ConfigFromEnv loads a Config with shared.LoadConfig.
+func ConfigFromEnv() (Config, error) {
+	var cfg Config
+	err := shared.LoadConfig(&cfg)
+	return cfg, err
+}
+
+// Bus is a Kafka-backed eventbus.Bus.
+type Bus struct {
+	cfg    Config
+	writer *kafkago.Writer
+
+	mu      sync.Mutex
+	readers map[*kafkago.Reader]struct{}
+	closed  bool
+}
+
+var _ eventbus.Bus = (*Bus)(nil)
+
+// New returns a Bus. Connections are made lazily.
+func New(cfg Config) (*Bus, error) {
+	if len(cfg.Brokers) == 0 {
+		return nil, errors.New("kafka: no brokers configured")
+	}
+	if cfg.StartOffset != "" && cfg.StartOffset != "earliest" && cfg.StartOffset != "latest" {
+		return nil, fmt.Errorf("kafka: StartOffset must be earliest or latest, got %q", cfg.StartOffset)
+	}
+	if cfg.RedeliveryBackoff <= 0 {
+		cfg.RedeliveryBackoff = time.Second
+	}
+	w := &kafkago.Writer{
+		Addr:         kafkago.TCP(cfg.Brokers...),
+		Balancer:     &kafkago.Hash{},
+		RequiredAcks: kafkago.RequireAll,
+		BatchTimeout: cfg.BatchTimeout,
+		Transport:    &kafkago.Transport{ClientID: cfg.ClientID, TLS: cfg.TLS},
+	}
+	return &Bus{cfg: cfg, writer: w, readers: make(map[*kafkago.Reader]struct{})}, nil
+}
+
+// Publish writes msgs to topic with acknowledgement from all in-sync
+// replicas.
+func (b *Bus) Publish(ctx context.Context, topic string, msgs ...*eventbus.Message) (err error) {
+	ctx, end := eventbus.StartPublish(ctx, topic, len(msgs))
+	defer func() { end(err) }()
+
+	out := make([]kafkago.Message, len(msgs))
+	for i, m := range msgs {
+		eventbus.Prepare(ctx, m)
+		out[i] = toKafka(topic, m)
+	}
+	if err := b.writer.WriteMessages(ctx, out...); err != nil {
+		return fmt.Errorf("kafka: publishing to %s: %w", topic, err)
+	}
+	return nil
+}
+
+// Subscribe consumes topic in consumer group group until ctx is done.
+func (b *Bus) Subscribe(ctx context.Context, topic, group string, h eventbus.Handler) error {
+	start := kafkago.FirstOffset
+	if b.cfg.StartOffset == "latest" {
+		start = kafkago.LastOffset
+	}
+	dialer := &kafkago.Dialer{ClientID: b.cfg.ClientID, TLS: b.cfg.TLS, Timeout: 10 * time.Second, DualStack: true}
+	r := kafkago.NewReader(kafkago.ReaderConfig{
+		Brokers:     b.cfg.Brokers,
+		GroupID:     group,
+		Topic:       topic,
+		StartOffset: start,
+		Dialer:      dialer,
+	})
+	if !b.track(r) {
+		r.Close()
+		return eventbus.ErrClosed
+	}
+	defer b.untrack(r)
+
+	for {
+		km, err := r.FetchMessage(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			if errors.Is(err, net.ErrClosed) || errors.Is(err, io.EOF) {
+				return eventbus.ErrClosed
+			}
+			return fmt.Errorf("kafka: fetching from %s: %w", topic, err)
+		}
+		m := fromKafka(km)
+		if !b.deliver(ctx, group, m, h) {
+			return nil
+		}
+		if err := r.CommitMessages(ctx, km); err != nil && ctx.Err() == nil {
+			// The message will be redelivered after a rebalance; handlers
+			// are idempotent by contract.
+			slog.WarnContext(ctx, "kafka: committing offset failed", "topic", topic, "group", group, "error", err)
+		}
+	}
+}
+
+// deliver runs h until it succeeds, backing off between attempts, and
+// reports false if ctx ended first.
+func (b *Bus) deliver(ctx context.Context, group string, m *eventbus.Message, h eventbus.Handler) bool {
+	delay := b.cfg.RedeliveryBackoff
+	for m.Attempt = 1; ; m.Attempt++ {
+		if err := eventbus.Dispatch(ctx, group, m, h); err == nil {
+			return true
+		}
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return false
+		}
+		if delay *= 2; b.cfg.MaxRedeliveryBackoff > 0 && delay > b.cfg.MaxRedeliveryBackoff {
+			delay = b.cfg.MaxRedeliveryBackoff
+		}
+	}
+}
+
+func (b *Bus) track(r *kafkago.Reader) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return false
+	}
+	b.readers[r] = struct{}{}
+	return true
+}
+
+func (b *Bus) untrack(r *kafkago.Reader) {
+	b.mu.Lock()
+	delete(b.readers, r)
+	b.mu.Unlock()
+	r.Close()
+}
+
+// Close flushes pending writes and stops every subscription.
+func (b *Bus) Close() error {
+	b.mu.Lock()
+	b.closed = true
+	readers := make([]*kafkago.Reader, 0, len(b.readers))
+	for r := range b.readers {
+		readers = append(readers, r)
+	}
+	b.mu.Unlock()
+
+	errs := []error{b.writer.Close()}
+	for _, r := range readers {
+		errs = append(errs, r.Close())
+	}
+	return errors.Join(errs...)
+}
+
+func toKafka(topic string, m *eventbus.Message) kafkago.Message {
+	km := kafkago.Message{Topic: topic, Key: m.Key, Value: m.Payload, Time: m.Timestamp}
+	km.Headers = append(km.Headers, kafkago.Header{Key: idHeader, Value: []byte(m.ID)})
+	for k, v := range m.Headers {
+		km.Headers = append(km.Headers, kafkago.Header{Key: k, Value: []byte(v)})
+	}
+	return km
+}
+
+func fromKafka(km kafkago.Message) *eventbus.Message {
+	m := &eventbus.Message{
+		Topic:     km.Topic,
+		Key:       km.Key,
+		Payload:   km.Value,
+		Timestamp: km.Time,
+		Headers:   make(map[string]string, len(km.Headers)),
+	}
+	for _, h := range km.Headers {
+		if h.Key == idHeader {
+			m.ID = string(h.Value)
+			continue
+		}
+		m.Headers[h.Key] = string(h.Value)
+	}
+	if m.ID == "" {
+		m.ID = km.Topic + "/" + strconv.Itoa(km.Partition) + "/" + strconv.FormatInt(km.Offset, 10)
+	}
+	return m
+}
@@ -0,0 +1,41 @@
+package kafka
+
+import (
+	"testing"
+	"time"
+
+	"github.com/GoogleCloudPlatform/microservices-demo/src/shared/eventbus"
+)
+
+func TestMessageRoundTrip(t *testing.T) {
+	m := &eventbus.Message{
+		ID:        "id-1",
+		Key:       []byte("order-1"),
+		Payload:   []byte(`{"total":3}`),
+		Headers:   map[string]string{"traceparent": "00-abc-def-01"},
+		Timestamp: time.Unix(1700000000, 0),
+	}
+	km := toKafka("orders", m)
+	km.Partition, km.Offset = 2, 41
+	got := fromKafka(km)
+	if got.ID != "id-1" || got.Topic != "orders" || string(got.Key) != "order-1" || got.Header("traceparent") != "00-abc-def-01" {
+		t.Errorf("round trip = %+v", got)
+	}
+	if _, ok := got.Headers[idHeader]; ok {
+		t.Error("internal ID header leaked into Headers")
+	}
+
+	km.Headers = nil
+	if got := fromKafka(km); got.ID != "orders/2/41" {
+		t.Errorf("ID without header = %q, want orders/2/41", got.ID)
+	}
+}
+
+func TestNewValidates(t *testing.T) {
+	if _, err := New(Config{}); err == nil {
+		t.Error("New without brokers succeeded")
+	}
+	if _, err := New(Config{Brokers: []string{"k:9092"}, StartOffset: "middle"}); err == nil {
+		t.Error("New with bad StartOffset succeeded")
+	}
+}
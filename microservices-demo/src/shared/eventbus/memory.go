@@ -0,0 +1,157 @@
+package eventbus
+
+import (
+	"context"
+	"maps"
+	"sync"
+)
+
+// Memory is an in-process Bus for tests and local development. Topics keep
+// every message, so a group subscribing late starts from the beginning like a
+// Kafka consumer with auto.offset.reset=earliest. Failed messages are
+// redelivered to the group with Attempt incremented.
+type Memory struct {
+	mu      sync.Mutex
+	topics  map[string]*memTopic
+	changed chan struct{} // closed and replaced on every change
+	closed  bool
+}
+
+type memTopic struct {
+	log    []*Message
+	groups map[string]*memGroup
+}
+
+type memGroup struct {
+	next      int // offset of the next undelivered message in log
+	redeliver []*Message
+}
+
+// NewMemory returns an empty in-memory bus.
+func NewMemory() *Memory {
+	return &Memory{topics: make(map[string]*memTopic), changed: make(chan struct{})}
+}
+
+func (b *Memory) topic(name string) *memTopic {
+	t, ok := b.topics[name]
+	if !ok {
+		t = &memTopic{groups: make(map[string]*memGroup)}
+		b.topics[name] = t
+	}
+	return t
+}
+
+// notify wakes waiting subscribers. b.mu must be held.
+func (b *Memory) notify() {
+	close(b.changed)
+	b.changed = make(chan struct{})
+}
+
+// Publish appends msgs to topic.
+func (b *Memory) Publish(ctx context.Context, topic string, msgs ...*Message) (err error) {
+	ctx, end := StartPublish(ctx, topic, len(msgs))
+	defer func() { end(err) }()
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return ErrClosed
+	}
+	t := b.topic(topic)
+	for _, m := range msgs {
+		Prepare(ctx, m)
+		cp := *m
+		cp.Topic = topic
+		cp.Headers = maps.Clone(m.Headers)
+		t.log = append(t.log, &cp)
+	}
+	b.notify()
+	return nil
+}
+
+// Subscribe consumes topic as a member of group until ctx is done.
+func (b *Memory) Subscribe(ctx context.Context, topic, group string, h Handler) error {
+	for {
+		m, wait, err := b.take(topic, group)
+		if err != nil {
+			return err
+		}
+		if m == nil {
+			select {
+			case <-wait:
+				continue
+			case <-ctx.Done():
+				return nil
+			}
+		}
+		if err := Dispatch(ctx, group, m, h); err != nil {
+			b.requeue(topic, group, m)
+		}
+	}
+}
+
+// take returns the next message for group, or a channel to wait on.
+func (b *Memory) take(topic, group string) (*Message, <-chan struct{}, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return nil, nil, ErrClosed
+	}
+	t := b.topic(topic)
+	g, ok := t.groups[group]
+	if !ok {
+		g = &memGroup{}
+		t.groups[group] = g
+	}
+	if len(g.redeliver) > 0 {
+		m := g.redeliver[0]
+		g.redeliver = g.redeliver[1:]
+		return m, nil, nil
+	}
+	if g.next < len(t.log) {
+		cp := *t.log[g.next]
+		cp.Headers = maps.Clone(cp.Headers)
+		g.next++
+		cp.Attempt = 1
+		return &cp, nil, nil
+	}
+	return nil, b.changed, nil
+}
+
+func (b *Memory) requeue(topic, group string, m *Message) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	cp := *m
+	cp.Attempt++
+	g := b.topics[topic].groups[group]
+	g.redeliver = append(g.redeliver, &cp)
+	b.notify()
+}
+
+// Messages returns a copy of everything published to topic, for assertions.
+func (b *Memory) Messages(topic string) []*Message {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	t, ok := b.topics[topic]
+	if !ok {
+		return nil
+	}
+	out := make([]*Message, len(t.log))
+	for i, m := range t.log {
+		cp := *m
+		out[i] = &cp
+	}
+	return out
+}
+
+// Close makes running and future Subscribe and Publish calls fail with
+// ErrClosed.
+func (b *Memory) Close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !b.closed {
+		b.closed = true
+		b.notify()
+	}
+	return nil
+}
@@ -0,0 +1,220 @@
+// Package nats implements eventbus.Bus on NATS JetStream. Topics are
+// subjects captured by one stream; each group is a durable pull consumer
+// with explicit acks, so a failed or unacknowledged message is redelivered
+// after a backoff. The message ID is sent as Nats-Msg-Id, letting the
+// stream drop duplicate publishes within its dedupe window.
+package nats
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	natsgo "github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+
+	shared "github.com/GoogleCloudPlatform/microservices-demo/src/shared"
+	"github.com/GoogleCloudPlatform/microservices-demo/src/shared/eventbus"
+)
+
+// keyHeader carries eventbus.Message.Key, which NATS has no slot for.
+const keyHeader = "Eventbus-Key"
+
+// Config configures the NATS bus.
+type Config struct {
+	// Stream is the JetStream stream holding the topics.
+	Stream string `env:"NATS_STREAM" default:"BOUTIQUE"`
+	// Subjects, if set, creates or updates Stream to capture them, e.g.
+	// "orders.>". Otherwise the stream must already exist.
+	Subjects []string `env:"NATS_STREAM_SUBJECTS"`
+	// AckWait is how long the server waits for an ack before redelivering.
+	AckWait time.Duration `env:"NATS_ACK_WAIT" default:"30s"`
+	// MaxDeliver bounds deliveries per message; -1 is unlimited.
+	MaxDeliver int `env:"NATS_MAX_DELIVER" default:"-1"`
+	// RedeliveryBackoff delays redelivery after a handler error.
+	RedeliveryBackoff time.Duration `env:"NATS_REDELIVERY_BACKOFF" default:"1s"`
+}
+
+// ConfigFromEnv loads a Config with shared.LoadConfig.
+func ConfigFromEnv() (Config, error) {
+	var cfg Config
+	err := shared.LoadConfig(&cfg)
+	return cfg, err
+}
+
+// Bus is a JetStream-backed eventbus.Bus.
+type Bus struct {
+	cfg Config
+	js  jetstream.JetStream
+
+	mu     sync.Mutex
+	subs   map[jetstream.ConsumeContext]struct{}
+	closed bool
+}
+
+var _ eventbus.Bus = (*Bus)(nil)
+
+// New returns a Bus on nc, creating the stream when cfg.Subjects is set. The
+// caller owns nc and closes it after the Bus.
+func New(ctx context.Context, nc *natsgo.Conn, cfg Config) (*Bus, error) {
+	if cfg.Stream == "" {
+		return nil, errors.New("nats: no stream configured")
+	}
+	js, err := jetstream.New(nc)
+	if err != nil {
+		return nil, fmt.Errorf("nats: %w", err)
+	}
+	if len(cfg.Subjects) > 0 {
+		if _, err := js.CreateOrUpdateStream(ctx, jetstream.StreamConfig{
+			Name:     cfg.Stream,
+			Subjects: cfg.Subjects,
+		}); err != nil {
+			return nil, fmt.Errorf("nats: creating stream %s: %w", cfg.Stream, err)
+		}
+	}
+	return &Bus{cfg: cfg, js: js, subs: make(map[jetstream.ConsumeContext]struct{})}, nil
+}
+
+// Publish sends msgs to subject topic, waiting for each to be stored.
+func (b *Bus) Publish(ctx context.Context, topic string, msgs ...*eventbus.Message) (err error) {
+	ctx, end := eventbus.StartPublish(ctx, topic, len(msgs))
+	defer func() { end(err) }()
+
+	for _, m := range msgs {
+		eventbus.Prepare(ctx, m)
+		if _, err := b.js.PublishMsg(ctx, toNATS(topic, m)); err != nil {
+			return fmt.Errorf("nats: publishing to %s: %w", topic, err)
+		}
+	}
+	return nil
+}
+
+// Subscribe consumes topic through the durable consumer named after group
+// until ctx is done.
+func (b *Bus) Subscribe(ctx context.Context, topic, group string, h eventbus.Handler) error {
+	cons, err := b.js.CreateOrUpdateConsumer(ctx, b.cfg.Stream, jetstream.ConsumerConfig{
+		Durable:       durableName(group, topic),
+		FilterSubject: topic,
+		AckPolicy:     jetstream.AckExplicitPolicy,
+		AckWait:       b.cfg.AckWait,
+		MaxDeliver:    b.cfg.MaxDeliver,
+		DeliverPolicy: jetstream.DeliverAllPolicy,
+	})
+	if err != nil {
+		return fmt.Errorf("nats: creating consumer %s: %w", group, err)
+	}
+
+	failed := make(chan error, 1)
+	cc, err := cons.Consume(func(msg jetstream.Msg) {
+		m := fromNATS(msg)
+		if err := eventbus.Dispatch(ctx, group, m, h); err != nil {
+			msg.NakWithDelay(b.cfg.RedeliveryBackoff)
+			return
+		}
+		msg.Ack()
+	}, jetstream.ConsumeErrHandler(func(_ jetstream.ConsumeContext, err error) {
+		if errors.Is(err, jetstream.ErrConsumerDeleted) {
+			select {
+			case failed <- err:
+			default:
+			}
+		}
+	}))
+	if err != nil {
+		return fmt.Errorf("nats: consuming %s: %w", topic, err)
+	}
+	if !b.track(cc) {
+		cc.Stop()
+		return eventbus.ErrClosed
+	}
+	defer b.untrack(cc)
+
+	select {
+	case <-ctx.Done():
+		return nil
+	case <-cc.Closed():
+		return eventbus.ErrClosed
+	case err := <-failed:
+		return fmt.Errorf("nats: consuming %s: %w", topic, err)
+	}
+}
+
+func (b *Bus) track(cc jetstream.ConsumeContext) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return false
+	}
+	b.subs[cc] = struct{}{}
+	return true
+}
+
+func (b *Bus) untrack(cc jetstream.ConsumeContext) {
+	b.mu.Lock()
+	delete(b.subs, cc)
+	b.mu.Unlock()
+	cc.Stop()
+}
+
+// Close stops every subscription. It does not close the NATS connection.
+func (b *Bus) Close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.closed = true
+	for cc := range b.subs {
+		cc.Stop()
+	}
+	return nil
+}
+
+// durableName derives a consumer name from group and topic; consumer names
+// may not contain '.', '*', '>' or whitespace.
+func durableName(group, topic string) string {
+	r := strings.NewReplacer(".", "_", "*", "_", ">", "_", " ", "_", "\t", "_")
+	return r.Replace(group + "-" + topic)
+}
+
+func toNATS(topic string, m *eventbus.Message) *natsgo.Msg {
+	nm := &natsgo.Msg{Subject: topic, Data: m.Payload, Header: natsgo.Header{}}
+	for k, v := range m.Headers {
+		nm.Header[k] = []string{v}
+	}
+	nm.Header[natsgo.MsgIdHdr] = []string{m.ID}
+	if len(m.Key) > 0 {
+		nm.Header[keyHeader] = []string{string(m.Key)}
+	}
+	return nm
+}
+
+// natsMsg is the part of jetstream.Msg fromNATS needs.
+type natsMsg interface {
+	Subject() string
+	Data() []byte
+	Headers() natsgo.Header
+	Metadata() (*jetstream.MsgMetadata, error)
+}
+
+func fromNATS(msg natsMsg) *eventbus.Message {
+	m := &eventbus.Message{Topic: msg.Subject(), Payload: msg.Data(), Headers: map[string]string{}, Attempt: 1}
+	for k, vs := range msg.Headers() {
+		if len(vs) == 0 {
+			continue
+		}
+		switch k {
+		case natsgo.MsgIdHdr:
+			m.ID = vs[0]
+		case keyHeader:
+			m.Key = []byte(vs[0])
+		default:
+			m.Headers[k] = vs[0]
+		}
+	}
+	if md, err := msg.Metadata(); err == nil {
+		m.Attempt = int(md.NumDelivered)
+		m.Timestamp = md.Timestamp
+	}
+	return m
+}
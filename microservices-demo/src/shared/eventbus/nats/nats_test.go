@@ -0,0 +1,48 @@
+package nats
+
+import (
+	"testing"
+	"time"
+
+	natsgo "github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+
+	"github.com/GoogleCloudPlatform/microservices-demo/src/shared/eventbus"
+)
+
+type fakeMsg struct {
+	nm *natsgo.Msg
+	md *jetstream.MsgMetadata
+}
+
+func (f fakeMsg) Subject() string        { return f.nm.Subject }
+func (f fakeMsg) Data() []byte           { return f.nm.Data }
+func (f fakeMsg) Headers() natsgo.Header { return f.nm.Header }
+func (f fakeMsg) Metadata() (*jetstream.MsgMetadata, error) {
+	return f.md, nil
+}
+
+func TestMessageRoundTrip(t *testing.T) {
+	m := &eventbus.Message{ID: "id-1", Key: []byte("order-1"), Payload: []byte("x"), Headers: map[string]string{"traceparent": "tp"}}
+	nm := toNATS("orders.placed", m)
+	if nm.Header.Get(natsgo.MsgIdHdr) != "id-1" {
+		t.Errorf("Nats-Msg-Id = %q, want id-1", nm.Header.Get(natsgo.MsgIdHdr))
+	}
+	ts := time.Unix(1700000000, 0)
+	got := fromNATS(fakeMsg{nm: nm, md: &jetstream.MsgMetadata{NumDelivered: 3, Timestamp: ts}})
+	if got.ID != "id-1" || string(got.Key) != "order-1" || got.Topic != "orders.placed" || got.Header("traceparent") != "tp" {
+		t.Errorf("round trip = %+v", got)
+	}
+	if got.Attempt != 3 || !got.Timestamp.Equal(ts) {
+		t.Errorf("Attempt, Timestamp = %d, %v; want 3, %v", got.Attempt, got.Timestamp, ts)
+	}
+	if len(got.Headers) != 1 {
+		t.Errorf("internal headers leaked: %v", got.Headers)
+	}
+}
+
+func TestDurableName(t *testing.T) {
+	if got := durableName("email service", "orders.*"); got != "email_service-orders__" {
+		t.Errorf("durableName = %q", got)
+	}
+}
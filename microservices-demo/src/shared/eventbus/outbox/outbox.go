@@ -0,0 +1,84 @@
+// Package outbox implements the transactional outbox pattern on Postgres:
+// events are inserted into a table in the same transaction as the business
+// change, so they are recorded if and only if it commits, and a Relay
+// publishes them to an eventbus.Publisher afterwards.
+//
+// Delivery is at-least-once: a relay that crashes between publishing and
+// marking a row sends it again. Every event keeps the message ID assigned at
+// Enqueue across retries, so consumers (and NATS JetStream, via Nats-Msg-Id)
+// can drop duplicates.
+//
+// Usage:
+//
+//	tx, err := db.BeginTx(ctx, nil)
+//	...
+//	if _, err := tx.ExecContext(ctx, "INSERT INTO orders ...", ...); err != nil { ... }
+//	msg, _ := eventbus.NewMessage(eventbus.Proto, orderResult)
+//	msg.Key = []byte(orderID)
+//	if err := outbox.Enqueue(ctx, tx, "orders.placed", msg); err != nil { ... }
+//	err = tx.Commit()
+//
+//	// once per process:
+//	relay := outbox.NewRelay(db, bus)
+//	go relay.Run(ctx)
+package outbox
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+
+	"github.com/GoogleCloudPlatform/microservices-demo/src/shared/eventbus"
+	"github.com/GoogleCloudPlatform/microservices-demo/src/shared/postgres"
+)
+
+// Table is the outbox table name.
+const Table = "eventbus_outbox"
+
+// Migration creates the outbox table. Run it with postgres.RunMigrations, or
+// include Migration.SQL in the service's own migrations.
+var Migration = postgres.Migration{
+	Version: "0000_eventbus_outbox",
+	SQL: `CREATE TABLE IF NOT EXISTS ` + Table + ` (
+	id           bigserial PRIMARY KEY,
+	message_id   text NOT NULL UNIQUE,
+	topic        text NOT NULL,
+	key          bytea,
+	payload      bytea NOT NULL,
+	headers      jsonb NOT NULL DEFAULT '{}',
+	created_at   timestamptz NOT NULL DEFAULT now(),
+	published_at timestamptz,
+	attempts     integer NOT NULL DEFAULT 0,
+	last_error   text
+);
+CREATE INDEX IF NOT EXISTS ` + Table + `_pending ON ` + Table + ` (id) WHERE published_at IS NULL;`,
+}
+
+// Execer is satisfied by *sql.Tx, *sql.DB and *sql.Conn. Pass the
+// transaction that carries the business change.
+type Execer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+}
+
+// Enqueue records msgs for publication to topic as part of tx. Each message
+// gets its ID, timestamp and the trace context of ctx now, so the eventual
+// publish joins the trace that caused it. Re-enqueueing a message with an
+// ID already in the outbox is a no-op.
+func Enqueue(ctx context.Context, tx Execer, topic string, msgs ...*eventbus.Message) error {
+	for _, m := range msgs {
+		eventbus.Prepare(ctx, m)
+		headers, err := json.Marshal(m.Headers)
+		if err != nil {
+			return fmt.Errorf("outbox: encoding headers: %w", err)
+		}
+		if _, err := tx.ExecContext(ctx,
+			`INSERT INTO `+Table+` (message_id, topic, key, payload, headers, created_at)
+VALUES ($1, $2, $3, $4, $5, $6) ON CONFLICT (message_id) DO NOTHING`,
+			m.ID, topic, m.Key, m.Payload, string(headers), m.Timestamp,
+		); err != nil {
+			return fmt.Errorf("outbox: enqueueing %s: %w", m.ID, err)
+		}
+	}
+	return nil
+}
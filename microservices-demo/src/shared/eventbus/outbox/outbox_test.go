@@ -0,0 +1,221 @@
+package outbox
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/GoogleCloudPlatform/microservices-demo/src/shared/eventbus"
+)
+
+// fakeDB is a database/sql driver that keeps outbox rows in memory and
+// understands exactly the statements this package issues.
+type fakeDB struct {
+	mu     sync.Mutex
+	rows   []*fakeRow
+	nextID int64
+}
+
+type fakeRow struct {
+	id               int64
+	messageID, topic string
+	key, payload     []byte
+	headers          string
+	createdAt        time.Time
+	published        bool
+	attempts         int
+	lastError        string
+}
+
+var registerOnce sync.Once
+
+func openFake(t *testing.T) (*sql.DB, *fakeDB) {
+	t.Helper()
+	registerOnce.Do(func() { sql.Register("outboxfake", fakeDriver{}) })
+	f := &fakeDB{}
+	name := t.Name()
+	fakeDBs.Store(name, f)
+	db, err := sql.Open("outboxfake", name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db, f
+}
+
+var fakeDBs sync.Map
+
+type fakeDriver struct{}
+
+func (fakeDriver) Open(name string) (driver.Conn, error) {
+	f, _ := fakeDBs.Load(name)
+	return &fakeConn{db: f.(*fakeDB)}, nil
+}
+
+type fakeConn struct{ db *fakeDB }
+
+func (c *fakeConn) Prepare(query string) (driver.Stmt, error) {
+	return &fakeStmt{db: c.db, query: query}, nil
+}
+func (c *fakeConn) Close() error              { return nil }
+func (c *fakeConn) Begin() (driver.Tx, error) { return fakeTx{}, nil }
+
+type fakeTx struct{}
+
+func (fakeTx) Commit() error   { return nil }
+func (fakeTx) Rollback() error { return nil }
+
+type fakeStmt struct {
+	db    *fakeDB
+	query string
+}
+
+func (s *fakeStmt) Close() error  { return nil }
+func (s *fakeStmt) NumInput() int { return -1 }
+
+func (s *fakeStmt) Exec(args []driver.Value) (driver.Result, error) {
+	f := s.db
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	switch {
+	case strings.HasPrefix(s.query, "INSERT"):
+		for _, r := range f.rows {
+			if r.messageID == args[0].(string) {
+				return driver.RowsAffected(0), nil
+			}
+		}
+		f.nextID++
+		key, _ := args[2].([]byte)
+		f.rows = append(f.rows, &fakeRow{
+			id: f.nextID, messageID: args[0].(string), topic: args[1].(string),
+			key: key, payload: args[3].([]byte), headers: args[4].(string), createdAt: args[5].(time.Time),
+		})
+	case strings.Contains(s.query, "SET published_at = now()"):
+		r := f.find(args[0].(int64))
+		r.published = true
+		r.attempts++
+	case strings.Contains(s.query, "last_error = $2"):
+		r := f.find(args[0].(int64))
+		r.attempts++
+		r.lastError = args[1].(string)
+	}
+	return driver.RowsAffected(1), nil
+}
+
+func (f *fakeDB) find(id int64) *fakeRow {
+	for _, r := range f.rows {
+		if r.id == id {
+			return r
+		}
+	}
+	return nil
+}
+
+func (s *fakeStmt) Query(args []driver.Value) (driver.Rows, error) {
+	f := s.db
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var out [][]driver.Value
+	for _, r := range f.rows {
+		if !r.published && int64(len(out)) < args[0].(int64) {
+			out = append(out, []driver.Value{r.id, r.messageID, r.topic, r.key, r.payload, []byte(r.headers), r.createdAt})
+		}
+	}
+	return &fakeRows{rows: out}, nil
+}
+
+type fakeRows struct{ rows [][]driver.Value }
+
+func (r *fakeRows) Columns() []string {
+	return []string{"id", "message_id", "topic", "key", "payload", "headers", "created_at"}
+}
+func (r *fakeRows) Close() error { return nil }
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if len(r.rows) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.rows[0])
+	r.rows = r.rows[1:]
+	return nil
+}
+
+// flakyPublisher fails the first n publishes.
+type flakyPublisher struct {
+	*eventbus.Memory
+	fail int
+}
+
+func (p *flakyPublisher) Publish(ctx context.Context, topic string, msgs ...*eventbus.Message) error {
+	if p.fail > 0 {
+		p.fail--
+		return errors.New("broker down")
+	}
+	return p.Memory.Publish(ctx, topic, msgs...)
+}
+
+func TestEnqueueAndRelay(t *testing.T) {
+	db, f := openFake(t)
+	ctx := context.Background()
+
+	tx, _ := db.BeginTx(ctx, nil)
+	m1, _ := eventbus.NewMessage(eventbus.JSON, map[string]string{"order": "1"})
+	m2, _ := eventbus.NewMessage(eventbus.JSON, map[string]string{"order": "2"})
+	if err := Enqueue(ctx, tx, "orders.placed", m1, m2); err != nil {
+		t.Fatal(err)
+	}
+	tx.Commit()
+	if m1.ID == "" || m1.ID == m2.ID {
+		t.Fatalf("message IDs not assigned: %q, %q", m1.ID, m2.ID)
+	}
+
+	bus := &flakyPublisher{Memory: eventbus.NewMemory(), fail: 1}
+	relay := NewRelay(db, bus)
+
+	// The first publish fails: nothing is sent and the row records the error.
+	if n, err := relay.RelayOnce(ctx); err == nil || n != 0 {
+		t.Fatalf("RelayOnce = %d, %v; want 0 and an error", n, err)
+	}
+	if f.rows[0].lastError != "broker down" || f.rows[1].attempts != 0 {
+		t.Errorf("after failure rows = %+v, %+v", f.rows[0], f.rows[1])
+	}
+
+	if n, err := relay.RelayOnce(ctx); err != nil || n != 2 {
+		t.Fatalf("RelayOnce = %d, %v; want 2, nil", n, err)
+	}
+	got := bus.Messages("orders.placed")
+	if len(got) != 2 || got[0].ID != m1.ID || got[1].ID != m2.ID {
+		t.Fatalf("published = %+v", got)
+	}
+	var body map[string]string
+	if err := eventbus.Decode(got[0], &body); err != nil || body["order"] != "1" {
+		t.Errorf("payload = %v, %v", body, err)
+	}
+
+	if n, _ := relay.RelayOnce(ctx); n != 0 {
+		t.Errorf("published rows relayed again: %d", n)
+	}
+}
+
+func TestEnqueueIsIdempotent(t *testing.T) {
+	db, f := openFake(t)
+	m := &eventbus.Message{ID: "fixed", Payload: []byte("{}")}
+	for range 2 {
+		if err := Enqueue(context.Background(), db, "t", m); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(f.rows) != 1 {
+		t.Errorf("rows = %d, want 1", len(f.rows))
+	}
+	var h map[string]string
+	if err := json.Unmarshal([]byte(f.rows[0].headers), &h); err != nil {
+		t.Errorf("headers not valid JSON: %v", err)
+	}
+}
@@ -0,0 +1,188 @@
+package outbox
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+
+	shared "github.com/GoogleCloudPlatform/microservices-demo/src/shared"
+	"github.com/GoogleCloudPlatform/microservices-demo/src/shared/eventbus"
+	"github.com/GoogleCloudPlatform/microservices-demo/src/shared/metrics"
+)
+
+var (
+	relayedTotal = metrics.NewCounterVec("outbox_relayed_total",
+		"Outbox events handed to the publisher, by result (ok, error).", "result")
+	pendingGauge = metrics.NewGaugeVec("outbox_pending",
+		"Outbox events seen unpublished in the last relay batch.")
+)
+
+type relayConfig struct {
+	interval  time.Duration
+	batch     int
+	retention time.Duration
+	clock     shared.Clock
+}
+
+// Option configures a Relay.
+type Option func(*relayConfig)
+
+// WithPollInterval sets how often the relay checks for new events when the
+// previous batch was empty (default 1s).
+func WithPollInterval(d time.Duration) Option {
+	return func(c *relayConfig) { c.interval = d }
+}
+
+// WithBatchSize sets how many events are claimed per transaction (default
+// 100).
+func WithBatchSize(n int) Option {
+	return func(c *relayConfig) { c.batch = n }
+}
+
+// WithRetention sets how long published events are kept before being
+// deleted (default 7 days); zero or negative keeps them forever.
+func WithRetention(d time.Duration) Option {
+	return func(c *relayConfig) { c.retention = d }
+}
+
+// WithClock sets the clock timing polls, for tests.
+func WithClock(clk shared.Clock) Option {
+	return func(c *relayConfig) { c.clock = clk }
+}
+
+// Relay moves events from the outbox table to a publisher. Several replicas
+// may run one concurrently: rows are claimed with FOR UPDATE SKIP LOCKED,
+// so each batch is relayed by exactly one of them, in insertion order.
+type Relay struct {
+	db  *sql.DB
+	pub eventbus.Publisher
+	cfg relayConfig
+}
+
+// NewRelay returns a Relay publishing events from db to pub.
+func NewRelay(db *sql.DB, pub eventbus.Publisher, opts ...Option) *Relay {
+	cfg := relayConfig{interval: time.Second, batch: 100, retention: 7 * 24 * time.Hour}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	if cfg.clock == nil {
+		cfg.clock = shared.RealClock()
+	}
+	return &Relay{db: db, pub: pub, cfg: cfg}
+}
+
+// Run relays events until ctx is done. Full batches are followed
+// immediately by the next one; otherwise it sleeps for the poll interval.
+// Errors are logged and retried on the next poll.
+func (r *Relay) Run(ctx context.Context) error {
+	lastCleanup := time.Time{}
+	for {
+		n, err := r.RelayOnce(ctx)
+		if err != nil && ctx.Err() == nil {
+			slog.WarnContext(ctx, "outbox relay failed", "error", err)
+		}
+		if r.cfg.retention > 0 && r.cfg.clock.Now().Sub(lastCleanup) >= time.Hour {
+			if err := r.cleanup(ctx); err != nil && ctx.Err() == nil {
+				slog.WarnContext(ctx, "outbox cleanup failed", "error", err)
+			}
+			lastCleanup = r.cfg.clock.Now()
+		}
+		if n == r.cfg.batch && err == nil {
+			continue
+		}
+		if err := r.cfg.clock.Sleep(ctx, r.cfg.interval); err != nil {
+			return nil
+		}
+	}
+}
+
+type row struct {
+	id  int64
+	msg eventbus.Message
+}
+
+// RelayOnce claims one batch, publishes it and marks the published events.
+// It stops at the first publish failure so later events are not sent ahead
+// of it, and returns how many events were published.
+func (r *Relay) RelayOnce(ctx context.Context) (int, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("outbox: %w", err)
+	}
+	defer tx.Rollback()
+
+	rows, err := r.claim(ctx, tx)
+	if err != nil {
+		return 0, err
+	}
+	pendingGauge.WithLabelValues().Set(float64(len(rows)))
+
+	published := 0
+	var pubErr error
+	for _, rw := range rows {
+		m := rw.msg
+		// Publish in the trace that enqueued the event, not the relay's.
+		pubCtx := otel.GetTextMapPropagator().Extract(ctx, propagation.MapCarrier(m.Headers))
+		if pubErr = r.pub.Publish(pubCtx, m.Topic, &m); pubErr != nil {
+			relayedTotal.WithLabelValues("error").Inc()
+			if _, err := tx.ExecContext(ctx,
+				`UPDATE `+Table+` SET attempts = attempts + 1, last_error = $2 WHERE id = $1`,
+				rw.id, pubErr.Error()); err != nil {
+				return published, errors.Join(pubErr, err)
+			}
+			break
+		}
+		relayedTotal.WithLabelValues("ok").Inc()
+		if _, err := tx.ExecContext(ctx,
+			`UPDATE `+Table+` SET published_at = now(), attempts = attempts + 1, last_error = NULL WHERE id = $1`,
+			rw.id); err != nil {
+			return published, fmt.Errorf("outbox: marking %s published: %w", m.ID, err)
+		}
+		published++
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("outbox: %w", err)
+	}
+	if pubErr != nil {
+		return published, fmt.Errorf("outbox: publishing %s: %w", rows[published].msg.ID, pubErr)
+	}
+	return published, nil
+}
+
+func (r *Relay) claim(ctx context.Context, tx *sql.Tx) ([]row, error) {
+	rs, err := tx.QueryContext(ctx,
+		`SELECT id, message_id, topic, key, payload, headers, created_at FROM `+Table+`
+WHERE published_at IS NULL ORDER BY id LIMIT $1 FOR UPDATE SKIP LOCKED`, r.cfg.batch)
+	if err != nil {
+		return nil, fmt.Errorf("outbox: claiming events: %w", err)
+	}
+	defer rs.Close()
+
+	var out []row
+	for rs.Next() {
+		var rw row
+		var headers []byte
+		if err := rs.Scan(&rw.id, &rw.msg.ID, &rw.msg.Topic, &rw.msg.Key, &rw.msg.Payload, &headers, &rw.msg.Timestamp); err != nil {
+			return nil, fmt.Errorf("outbox: claiming events: %w", err)
+		}
+		if err := json.Unmarshal(headers, &rw.msg.Headers); err != nil {
+			return nil, fmt.Errorf("outbox: decoding headers of %s: %w", rw.msg.ID, err)
+		}
+		out = append(out, rw)
+	}
+	return out, rs.Err()
+}
+
+// cleanup deletes events published longer ago than the retention period.
+func (r *Relay) cleanup(ctx context.Context) error {
+	_, err := r.db.ExecContext(ctx,
+		`DELETE FROM `+Table+` WHERE published_at < $1`, r.cfg.clock.Now().Add(-r.cfg.retention))
+	return err
+}
@@ -0,0 +1,64 @@
+package eventbus
+
+import (
+	"context"
+	"log/slog"
+	"maps"
+
+	shared "github.com/GoogleCloudPlatform/microservices-demo/src/shared"
+)
+
+// Headers set on dead-lettered messages.
+const (
+	DeadLetterReasonHeader = "x-dead-letter-reason"
+	DeadLetterTopicHeader  = "x-original-topic"
+	DeadLetterGroupHeader  = "x-original-group"
+	DeadLetterIDHeader     = "x-original-id"
+)
+
+// DeliveryPolicy retries a failing handler in-process and then parks the
+// message on a dead-letter topic instead of redelivering it forever.
+type DeliveryPolicy struct {
+	// Retry bounds the in-process attempts for each delivery. Errors wrapped
+	// with shared.Permanent skip straight to the dead-letter topic.
+	Retry shared.RetryPolicy
+	// DeadLetter receives messages that exhausted Retry. Nil leaves them to
+	// the broker's own redelivery.
+	DeadLetter Publisher
+	// DeadLetterTopic names the dead-letter topic for a topic; nil appends
+	// ".dlq".
+	DeadLetterTopic func(topic string) string
+	// Group is recorded in the dead-letter headers.
+	Group string
+}
+
+// Wrap applies the policy to h.
+func (p DeliveryPolicy) Wrap(h Handler) Handler {
+	return func(ctx context.Context, m *Message) error {
+		err := shared.Retry(ctx, p.Retry, func(ctx context.Context) error {
+			return h(ctx, m)
+		})
+		if err == nil || p.DeadLetter == nil || ctx.Err() != nil {
+			return err
+		}
+
+		topic := m.Topic + ".dlq"
+		if p.DeadLetterTopic != nil {
+			topic = p.DeadLetterTopic(m.Topic)
+		}
+		dl := &Message{Key: m.Key, Payload: m.Payload, Headers: maps.Clone(m.Headers)}
+		dl.SetHeader(DeadLetterReasonHeader, err.Error())
+		dl.SetHeader(DeadLetterTopicHeader, m.Topic)
+		dl.SetHeader(DeadLetterIDHeader, m.ID)
+		if p.Group != "" {
+			dl.SetHeader(DeadLetterGroupHeader, p.Group)
+		}
+		if perr := p.DeadLetter.Publish(ctx, topic, dl); perr != nil {
+			slog.ErrorContext(ctx, "dead-lettering message failed, leaving it for redelivery",
+				"topic", m.Topic, "id", m.ID, "error", perr)
+			return err
+		}
+		slog.WarnContext(ctx, "message dead-lettered", "topic", m.Topic, "dlq", topic, "id", m.ID, "error", err)
+		return nil
+	}
+}
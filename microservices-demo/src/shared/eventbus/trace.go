@@ -0,0 +1,98 @@
+package eventbus
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	otelcodes "go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/GoogleCloudPlatform/microservices-demo/src/shared/metrics"
+)
+
+var (
+	publishedTotal = metrics.NewCounterVec("eventbus_published_total",
+		"Messages published, by topic and result (ok, error).", "topic", "result")
+	handledTotal = metrics.NewCounterVec("eventbus_handled_total",
+		"Messages handled, by topic, group and result (ok, error).", "topic", "group", "result")
+	handlingSeconds = metrics.NewHistogramVec("eventbus_handling_seconds",
+		"Message handler latency in seconds, by topic and group.", nil, "topic", "group")
+)
+
+var tracer = otel.Tracer("github.com/GoogleCloudPlatform/microservices-demo/src/shared/eventbus")
+
+// Prepare fills in a message's ID and timestamp and injects the trace
+// context of ctx into its headers. Backends call it from Publish.
+func Prepare(ctx context.Context, m *Message) {
+	if m.ID == "" {
+		m.ID = newID()
+	}
+	if m.Timestamp.IsZero() {
+		m.Timestamp = time.Now()
+	}
+	if m.Headers == nil {
+		m.Headers = make(map[string]string)
+	}
+	otel.GetTextMapPropagator().Inject(ctx, propagation.MapCarrier(m.Headers))
+}
+
+// StartPublish starts a producer span for publishing to topic and returns a
+// function that ends it and records the result. Backends wrap Publish with
+// it, calling Prepare with the returned context.
+func StartPublish(ctx context.Context, topic string, n int) (context.Context, func(error)) {
+	ctx, span := tracer.Start(ctx, topic+" publish",
+		trace.WithSpanKind(trace.SpanKindProducer),
+		trace.WithAttributes(
+			attribute.String("messaging.destination.name", topic),
+			attribute.Int("messaging.batch.message_count", n),
+		))
+	return ctx, func(err error) {
+		result := "ok"
+		if err != nil {
+			result = "error"
+			span.RecordError(err)
+			span.SetStatus(otelcodes.Error, err.Error())
+		}
+		publishedTotal.WithLabelValues(topic, result).Add(float64(n))
+		span.End()
+	}
+}
+
+// Dispatch runs h for a delivered message inside a consumer span linked to
+// the producer's trace, recording metrics. Backends call it for every
+// delivery and acknowledge the message only if it returns nil.
+func Dispatch(ctx context.Context, group string, m *Message, h Handler) error {
+	ctx = otel.GetTextMapPropagator().Extract(ctx, propagation.MapCarrier(m.Headers))
+	ctx, span := tracer.Start(ctx, m.Topic+" process",
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithAttributes(
+			attribute.String("messaging.destination.name", m.Topic),
+			attribute.String("messaging.consumer.group.name", group),
+			attribute.String("messaging.message.id", m.ID),
+			attribute.Int("messaging.delivery.attempt", m.Attempt),
+		))
+	defer span.End()
+
+	start := time.Now()
+	err := h(ctx, m)
+	metrics.Observe(ctx, handlingSeconds.WithLabelValues(m.Topic, group), time.Since(start).Seconds())
+	result := "ok"
+	if err != nil {
+		result = "error"
+		span.RecordError(err)
+		span.SetStatus(otelcodes.Error, err.Error())
+	}
+	handledTotal.WithLabelValues(m.Topic, group, result).Inc()
+	return err
+}
+
+func newID() string {
+	var b [16]byte
+	rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
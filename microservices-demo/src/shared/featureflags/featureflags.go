@@ -0,0 +1,213 @@
+// Package featureflags toggles experimental behavior per service without a
+// redeploy. Flags are declared once with a typed accessor and resolved on
+// every read from a chain of providers, so a change in a ConfigMap or remote
+// flag service takes effect as soon as the provider picks it up.
+//
+// A boolean flag may also hold a percentage, e.g. "25%", which enables it for
+// a stable quarter of users: EnabledFor hashes the flag name together with a
+// user or session ID, so the same user always gets the same answer while the
+// percentage is unchanged and stays enabled as it grows.
+//
+// Usage:
+//
+//	flags := featureflags.New(
+//	    featureflags.EnvProvider("FEATURE_"),
+//	    fileProvider,
+//	)
+//	newCheckout := flags.BoolFlag("new_checkout", false)
+//	maxItems := flags.IntFlag("max_cart_items", 50)
+//
+//	if newCheckout.EnabledFor(sessionID) { ... }
+package featureflags
+
+import (
+	"hash/fnv"
+	"log/slog"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Provider supplies raw flag values. Lookup must be safe for concurrent use.
+type Provider interface {
+	Lookup(name string) (string, bool)
+}
+
+// ProviderFunc adapts a function to Provider.
+type ProviderFunc func(name string) (string, bool)
+
+// Lookup calls f.
+func (f ProviderFunc) Lookup(name string) (string, bool) { return f(name) }
+
+// Set resolves flags from a chain of providers; the first provider that has a
+// value wins.
+type Set struct {
+	mu        sync.RWMutex
+	providers []Provider
+	overrides map[string]string
+}
+
+// New returns a Set consulting providers in order.
+func New(providers ...Provider) *Set {
+	return &Set{providers: providers, overrides: make(map[string]string)}
+}
+
+// Override pins name to value ahead of every provider, for tests and admin
+// tooling. An empty value removes the override.
+func (s *Set) Override(name, value string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if value == "" {
+		delete(s.overrides, name)
+		return
+	}
+	s.overrides[name] = value
+}
+
+// Lookup returns the raw value of name.
+func (s *Set) Lookup(name string) (string, bool) {
+	s.mu.RLock()
+	v, ok := s.overrides[name]
+	providers := s.providers
+	s.mu.RUnlock()
+	if ok {
+		return v, true
+	}
+	for _, p := range providers {
+		if v, ok := p.Lookup(name); ok {
+			return v, true
+		}
+	}
+	return "", false
+}
+
+// BoolFlag declares a boolean flag.
+func (s *Set) BoolFlag(name string, def bool) *BoolFlag {
+	return &BoolFlag{set: s, name: name, def: def}
+}
+
+// IntFlag declares an integer flag.
+func (s *Set) IntFlag(name string, def int) *IntFlag {
+	return &IntFlag{set: s, name: name, def: def}
+}
+
+// StringFlag declares a string flag.
+func (s *Set) StringFlag(name string, def string) *StringFlag {
+	return &StringFlag{set: s, name: name, def: def}
+}
+
+// BoolFlag is an on/off flag with optional percentage rollout.
+type BoolFlag struct {
+	set  *Set
+	name string
+	def  bool
+}
+
+// Name returns the flag's name.
+func (f *BoolFlag) Name() string { return f.name }
+
+// Enabled reports whether the flag is on for everyone. A percentage value
+// counts as on only at 100%.
+func (f *BoolFlag) Enabled() bool {
+	pct, ok := f.percent()
+	if !ok {
+		return f.def
+	}
+	return pct >= 100
+}
+
+// EnabledFor reports whether the flag is on for the given user or session ID.
+func (f *BoolFlag) EnabledFor(key string) bool {
+	pct, ok := f.percent()
+	if !ok {
+		return f.def
+	}
+	switch {
+	case pct <= 0:
+		return false
+	case pct >= 100:
+		return true
+	}
+	return bucket(f.name, key) < pct
+}
+
+// percent resolves the flag to a rollout percentage: true is 100, false 0.
+func (f *BoolFlag) percent() (float64, bool) {
+	raw, ok := f.set.Lookup(f.name)
+	if !ok {
+		return 0, false
+	}
+	raw = strings.TrimSpace(raw)
+	if p, isPct := strings.CutSuffix(raw, "%"); isPct {
+		v, err := strconv.ParseFloat(strings.TrimSpace(p), 64)
+		if err != nil {
+			invalid(f.name, raw)
+			return 0, false
+		}
+		return v, true
+	}
+	b, err := strconv.ParseBool(raw)
+	if err != nil {
+		invalid(f.name, raw)
+		return 0, false
+	}
+	if b {
+		return 100, true
+	}
+	return 0, true
+}
+
+// bucket maps (flag, key) to a stable value in [0, 100).
+func bucket(flag, key string) float64 {
+	h := fnv.New64a()
+	h.Write([]byte(flag))
+	h.Write([]byte{0})
+	h.Write([]byte(key))
+	return float64(h.Sum64()%10000) / 100
+}
+
+// IntFlag is an integer-valued flag.
+type IntFlag struct {
+	set  *Set
+	name string
+	def  int
+}
+
+// Name returns the flag's name.
+func (f *IntFlag) Name() string { return f.name }
+
+// Value returns the flag's value, or its default if unset or invalid.
+func (f *IntFlag) Value() int {
+	raw, ok := f.set.Lookup(f.name)
+	if !ok {
+		return f.def
+	}
+	v, err := strconv.Atoi(strings.TrimSpace(raw))
+	if err != nil {
+		invalid(f.name, raw)
+		return f.def
+	}
+	return v
+}
+
+// StringFlag is a string-valued flag.
+type StringFlag struct {
+	set  *Set
+	name string
+	def  string
+}
+
+// Name returns the flag's name.
+func (f *StringFlag) Name() string { return f.name }
+
+// Value returns the flag's value, or its default if unset.
+func (f *StringFlag) Value() string {
+	if raw, ok := f.set.Lookup(f.name); ok {
+		return raw
+	}
+	return f.def
+}
+
+func invalid(name, raw string) {
+	slog.Warn("ignoring invalid feature flag value", "flag", name, "value", raw)
+}
@@ -0,0 +1,116 @@
+package featureflags
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestTypedFlags(t *testing.T) {
+	s := New(MapProvider{"new_checkout": "true", "max_items": "20", "theme": "dark", "bad_int": "x"})
+
+	if !s.BoolFlag("new_checkout", false).Enabled() {
+		t.Error("new_checkout disabled, want enabled")
+	}
+	if !s.BoolFlag("unset", true).Enabled() {
+		t.Error("unset flag ignored its default")
+	}
+	if got := s.IntFlag("max_items", 50).Value(); got != 20 {
+		t.Errorf("max_items = %d, want 20", got)
+	}
+	if got := s.IntFlag("bad_int", 7).Value(); got != 7 {
+		t.Errorf("bad_int = %d, want default 7", got)
+	}
+	if got := s.StringFlag("theme", "light").Value(); got != "dark" {
+		t.Errorf("theme = %q, want dark", got)
+	}
+}
+
+func TestProviderPrecedence(t *testing.T) {
+	s := New(MapProvider{"f": "first"}, MapProvider{"f": "second", "g": "second"})
+	if got := s.StringFlag("f", "").Value(); got != "first" {
+		t.Errorf("f = %q, want first", got)
+	}
+	if got := s.StringFlag("g", "").Value(); got != "second" {
+		t.Errorf("g = %q, want second", got)
+	}
+	s.Override("f", "pinned")
+	if got := s.StringFlag("f", "").Value(); got != "pinned" {
+		t.Errorf("f after Override = %q, want pinned", got)
+	}
+}
+
+func TestPercentageRollout(t *testing.T) {
+	values := MapProvider{"exp": "30%"}
+	flag := New(values).BoolFlag("exp", false)
+
+	enabled := map[string]bool{}
+	for i := 0; i < 1000; i++ {
+		key := fmt.Sprintf("user-%d", i)
+		if flag.EnabledFor(key) {
+			enabled[key] = true
+		}
+	}
+	if n := len(enabled); n < 230 || n > 370 {
+		t.Errorf("30%% rollout enabled %d of 1000 users", n)
+	}
+	if flag.Enabled() {
+		t.Error("Enabled() true for a partial rollout")
+	}
+
+	values["exp"] = "60%"
+	for key := range enabled {
+		if !flag.EnabledFor(key) {
+			t.Fatalf("%s lost the flag when the rollout grew", key)
+		}
+	}
+}
+
+func TestEnvProvider(t *testing.T) {
+	t.Setenv("FEATURE_NEW_CHECKOUT", "true")
+	if !New(EnvProvider("FEATURE_")).BoolFlag("new-checkout", false).Enabled() {
+		t.Error("FEATURE_NEW_CHECKOUT not picked up")
+	}
+}
+
+func TestFileProvider(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "theme"), []byte("dark\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	p, err := FileProvider(ctx, dir, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := New(p).StringFlag("theme", "light").Value(); got != "dark" {
+		t.Errorf("theme = %q, want dark", got)
+	}
+}
+
+func TestHTTPProvider(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"new_checkout":"25%","max_items":20}`)
+	}))
+	defer srv.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	p, err := HTTPProvider(ctx, srv.URL, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := New(p)
+	if got := s.IntFlag("max_items", 0).Value(); got != 20 {
+		t.Errorf("max_items = %d, want 20", got)
+	}
+	if v, _ := s.Lookup("new_checkout"); v != "25%" {
+		t.Errorf("new_checkout = %q, want 25%%", v)
+	}
+}
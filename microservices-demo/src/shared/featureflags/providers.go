@@ -0,0 +1,141 @@
+package featureflags
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	shared "github.com/GoogleCloudPlatform/microservices-demo/src/shared"
+)
+
+// EnvProvider reads flags from environment variables named prefix followed by
+// the flag name upper-cased with non-alphanumerics replaced by underscores,
+// e.g. "new-checkout" with prefix "FEATURE_" reads FEATURE_NEW_CHECKOUT.
+func EnvProvider(prefix string) Provider {
+	return ProviderFunc(func(name string) (string, bool) {
+		return os.LookupEnv(prefix + envName(name))
+	})
+}
+
+func envName(name string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z':
+			return r - 'a' + 'A'
+		case r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+			return r
+		}
+		return '_'
+	}, name)
+}
+
+// MapProvider serves a fixed set of values.
+type MapProvider map[string]string
+
+// Lookup returns m[name].
+func (m MapProvider) Lookup(name string) (string, bool) {
+	v, ok := m[name]
+	return v, ok
+}
+
+// snapshotProvider serves values refreshed by a background poller.
+type snapshotProvider struct {
+	mu     sync.RWMutex
+	values map[string]string
+}
+
+func (p *snapshotProvider) Lookup(name string) (string, bool) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	v, ok := p.values[name]
+	return v, ok
+}
+
+func (p *snapshotProvider) store(values map[string]string) {
+	p.mu.Lock()
+	p.values = values
+	p.mu.Unlock()
+}
+
+// poll calls load every interval until ctx is done, keeping the last good
+// values when load fails.
+func (p *snapshotProvider) poll(ctx context.Context, interval time.Duration, what string, load func(context.Context) (map[string]string, error)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		values, err := load(ctx)
+		if err != nil {
+			slog.Warn("feature flag refresh failed, keeping previous values", "source", what, "error", err)
+			continue
+		}
+		p.store(values)
+	}
+}
+
+// FileProvider reads flags from a mounted ConfigMap directory (one file per
+// flag) or a KEY=VALUE file, re-reading it every interval until ctx is done.
+// The first read must succeed.
+func FileProvider(ctx context.Context, path string, interval time.Duration) (Provider, error) {
+	load := func(context.Context) (map[string]string, error) { return shared.ReadConfigFiles(path) }
+	values, err := load(ctx)
+	if err != nil {
+		return nil, err
+	}
+	p := &snapshotProvider{values: values}
+	go p.poll(ctx, interval, path, load)
+	return p, nil
+}
+
+// HTTPProvider fetches flags from a remote flag service that serves a JSON
+// object of flag names to values, e.g. {"new_checkout":"25%","max_cart_items":"20"},
+// refreshing every interval until ctx is done. Non-string JSON values are
+// used in their JSON form. The first fetch must succeed.
+func HTTPProvider(ctx context.Context, url string, interval time.Duration) (Provider, error) {
+	client := &http.Client{Timeout: 10 * time.Second}
+	load := func(ctx context.Context) (map[string]string, error) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("featureflags: GET %s: %s", url, resp.Status)
+		}
+		var raw map[string]json.RawMessage
+		if err := json.NewDecoder(resp.Body).Decode(&raw); err != nil {
+			return nil, fmt.Errorf("featureflags: decoding %s: %w", url, err)
+		}
+		values := make(map[string]string, len(raw))
+		for k, v := range raw {
+			var s string
+			if json.Unmarshal(v, &s) == nil {
+				values[k] = s
+			} else {
+				values[k] = string(v)
+			}
+		}
+		return values, nil
+	}
+	values, err := load(ctx)
+	if err != nil {
+		return nil, err
+	}
+	p := &snapshotProvider{values: values}
+	go p.poll(ctx, interval, url, load)
+	return p, nil
+}
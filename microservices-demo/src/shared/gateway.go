@@ -0,0 +1,407 @@
+package shared
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/dynamicpb"
+
+	"github.com/GoogleCloudPlatform/microservices-demo/src/shared/baggage"
+	apperrors "github.com/GoogleCloudPlatform/microservices-demo/src/shared/errors"
+	"github.com/GoogleCloudPlatform/microservices-demo/src/shared/requestid"
+)
+
+// DefaultGatewayPrefix is the path the gateway is mounted under unless
+// WithGatewayPrefix says otherwise.
+const DefaultGatewayPrefix = "/api/"
+
+// gatewayMaxBody bounds request bodies; gRPC's default message limit is the
+// same.
+const gatewayMaxBody = 4 << 20
+
+// DescriptorResolver finds service descriptors by name.
+// *protoregistry.Files implements it.
+type DescriptorResolver interface {
+	FindDescriptorByName(protoreflect.FullName) (protoreflect.Descriptor, error)
+}
+
+type gatewayConfig struct {
+	prefix   string
+	services []string
+	resolver DescriptorResolver
+	cors     CORSConfig
+}
+
+// GatewayOption configures NewGateway.
+type GatewayOption func(*gatewayConfig)
+
+// WithGatewayPrefix sets the path prefix, e.g. "/debug/grpc/" (default
+// DefaultGatewayPrefix).
+func WithGatewayPrefix(prefix string) GatewayOption {
+	return func(c *gatewayConfig) { c.prefix = prefix }
+}
+
+// WithGatewayServices exposes the named services, e.g.
+// "hipstershop.CartService".
+func WithGatewayServices(names ...string) GatewayOption {
+	return func(c *gatewayConfig) { c.services = append(c.services, names...) }
+}
+
+// WithGatewayServer exposes every service registered on srv, except gRPC
+// reflection.
+func WithGatewayServer(srv interface {
+	GetServiceInfo() map[string]grpc.ServiceInfo
+}) GatewayOption {
+	return func(c *gatewayConfig) {
+		for name := range srv.GetServiceInfo() {
+			if !strings.HasPrefix(name, "grpc.reflection.") {
+				c.services = append(c.services, name)
+			}
+		}
+	}
+}
+
+// WithGatewayResolver sets where service descriptors are looked up (default
+// protoregistry.GlobalFiles, which holds every generated package the binary
+// imports).
+func WithGatewayResolver(r DescriptorResolver) GatewayOption {
+	return func(c *gatewayConfig) { c.resolver = r }
+}
+
+// WithGatewayCORS lets browsers on other origins call the gateway.
+func WithGatewayCORS(cfg CORSConfig) GatewayOption {
+	return func(c *gatewayConfig) { c.cors = cfg }
+}
+
+// Gateway serves a gRPC API as JSON over HTTP, for manual testing with curl
+// and for browser tools. It needs no generated gateway code: requests are
+// translated with the services' descriptors and sent over conn.
+//
+//	POST <prefix><package.Service>/<Method>   JSON body → JSON response
+//	GET  <prefix><package.Service>/<Method>?field=value
+//	GET  <prefix>                             list of methods
+//
+// Server-streaming methods answer with newline-delimited JSON, one message
+// per line; client and bidirectional streaming are not supported. Errors are
+// application/problem+json responses built by package errors, so a gRPC
+// NotFound becomes a 404. The Authorization header is forwarded, as is any
+// "Grpc-Metadata-<key>" header as metadata key.
+//
+// Usage, exposing the service's own API on the admin port:
+//
+//	conn, err := grpcclient.Dial(ctx, "localhost:"+port)
+//	...
+//	gw, err := shared.NewGateway(conn, shared.WithGatewayServer(srv))
+//	...
+//	admin, err := shared.StartAdminServer("", shared.WithAdminHandler(gw.Prefix(), gw))
+//
+// and then:
+//
+//	curl localhost:8090/api/hipstershop.ProductCatalogService/GetProduct?id=OLJCESPC7Z
+type Gateway struct {
+	conn    grpc.ClientConnInterface
+	prefix  string
+	methods map[string]protoreflect.MethodDescriptor // by "package.Service/Method"
+	handler http.Handler
+}
+
+// NewGateway returns a gateway over conn for the services chosen with
+// WithGatewayServices or WithGatewayServer.
+func NewGateway(conn grpc.ClientConnInterface, opts ...GatewayOption) (*Gateway, error) {
+	cfg := gatewayConfig{prefix: DefaultGatewayPrefix, resolver: protoregistry.GlobalFiles}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	if len(cfg.services) == 0 {
+		return nil, errors.New("gateway: no services; use WithGatewayServices or WithGatewayServer")
+	}
+	if !strings.HasSuffix(cfg.prefix, "/") {
+		cfg.prefix += "/"
+	}
+	g := &Gateway{conn: conn, prefix: cfg.prefix, methods: make(map[string]protoreflect.MethodDescriptor)}
+	for _, name := range cfg.services {
+		d, err := cfg.resolver.FindDescriptorByName(protoreflect.FullName(name))
+		if err != nil {
+			return nil, fmt.Errorf("gateway: service %s: %w", name, err)
+		}
+		sd, ok := d.(protoreflect.ServiceDescriptor)
+		if !ok {
+			return nil, fmt.Errorf("gateway: %s is not a service", name)
+		}
+		for i := 0; i < sd.Methods().Len(); i++ {
+			md := sd.Methods().Get(i)
+			g.methods[name+"/"+string(md.Name())] = md
+		}
+	}
+	g.handler = cfg.cors.Middleware(requestid.Middleware(baggage.Middleware(http.HandlerFunc(g.serve))))
+	return g, nil
+}
+
+// Prefix returns the path the gateway expects to be mounted under.
+func (g *Gateway) Prefix() string { return g.prefix }
+
+// ServeHTTP implements http.Handler.
+func (g *Gateway) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	g.handler.ServeHTTP(w, r)
+}
+
+func (g *Gateway) serve(w http.ResponseWriter, r *http.Request) {
+	name, ok := strings.CutPrefix(r.URL.Path, g.prefix)
+	if !ok {
+		name, ok = strings.CutPrefix(r.URL.Path+"/", g.prefix)
+	}
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	if name == "" && r.Method == http.MethodGet {
+		g.serveIndex(w)
+		return
+	}
+	md, ok := g.methods[name]
+	if !ok {
+		apperrors.WriteProblem(w, r, apperrors.NotFound("GATEWAY_UNKNOWN_METHOD", "gateway: no method %q", name))
+		return
+	}
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if md.IsStreamingClient() {
+		apperrors.WriteProblem(w, r, apperrors.Unimplemented("GATEWAY_STREAMING_UNSUPPORTED",
+			"gateway: %s is a client-streaming method", name))
+		return
+	}
+
+	req := dynamicpb.NewMessage(md.Input())
+	if err := decodeGatewayRequest(r, req); err != nil {
+		apperrors.WriteProblem(w, r, apperrors.InvalidArgument("GATEWAY_BAD_REQUEST", "gateway: %v", err))
+		return
+	}
+	ctx := metadata.NewOutgoingContext(r.Context(), gatewayMetadata(r.Header))
+	path := "/" + name
+
+	if md.IsStreamingServer() {
+		g.serveStream(w, r.WithContext(ctx), path, md, req)
+		return
+	}
+	resp := dynamicpb.NewMessage(md.Output())
+	if err := g.conn.Invoke(ctx, path, req, resp); err != nil {
+		apperrors.WriteProblem(w, r, err)
+		return
+	}
+	b, err := gatewayJSON.Marshal(resp)
+	if err != nil {
+		apperrors.WriteProblem(w, r, apperrors.Internal("GATEWAY_ENCODING", "gateway: encoding response: %v", err))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(b)
+}
+
+var gatewayJSON = protojson.MarshalOptions{EmitUnpopulated: true}
+
+// serveStream relays a server-streaming call as newline-delimited JSON. An
+// error after the first message is sent as a final {"error": problem} line,
+// since the status code has already gone out.
+func (g *Gateway) serveStream(w http.ResponseWriter, r *http.Request, path string, md protoreflect.MethodDescriptor, req proto.Message) {
+	stream, err := g.conn.NewStream(r.Context(), &grpc.StreamDesc{ServerStreams: true}, path)
+	if err == nil {
+		err = stream.SendMsg(req)
+	}
+	if err == nil {
+		err = stream.CloseSend()
+	}
+	if err != nil {
+		apperrors.WriteProblem(w, r, err)
+		return
+	}
+	flusher, _ := w.(http.Flusher)
+	for started := false; ; started = true {
+		msg := dynamicpb.NewMessage(md.Output())
+		err := stream.RecvMsg(msg)
+		if errors.Is(err, io.EOF) {
+			if !started {
+				w.Header().Set("Content-Type", "application/x-ndjson")
+				w.WriteHeader(http.StatusOK)
+			}
+			return
+		}
+		if err != nil {
+			if !started {
+				apperrors.WriteProblem(w, r, err)
+				return
+			}
+			json.NewEncoder(w).Encode(map[string]any{"error": apperrors.ProblemFor(r, err)})
+			return
+		}
+		if !started {
+			w.Header().Set("Content-Type", "application/x-ndjson")
+		}
+		b, err := gatewayJSON.Marshal(msg)
+		if err != nil {
+			json.NewEncoder(w).Encode(map[string]any{"error": apperrors.ProblemFor(r, err)})
+			return
+		}
+		w.Write(append(b, '\n'))
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+}
+
+// gatewayMethod describes a method in the index.
+type gatewayMethod struct {
+	Path      string `json:"path"`
+	Request   string `json:"request"`
+	Response  string `json:"response"`
+	Streaming string `json:"streaming,omitempty"`
+}
+
+func (g *Gateway) serveIndex(w http.ResponseWriter) {
+	names := make([]string, 0, len(g.methods))
+	for name := range g.methods {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	index := make([]gatewayMethod, 0, len(names))
+	for _, name := range names {
+		md := g.methods[name]
+		m := gatewayMethod{Path: g.prefix + name, Request: string(md.Input().FullName()), Response: string(md.Output().FullName())}
+		switch {
+		case md.IsStreamingClient() && md.IsStreamingServer():
+			m.Streaming = "bidi"
+		case md.IsStreamingClient():
+			m.Streaming = "client"
+		case md.IsStreamingServer():
+			m.Streaming = "server"
+		}
+		index = append(index, m)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(index)
+}
+
+// decodeGatewayRequest fills msg from the JSON body, then from the query
+// string, so a query parameter overrides a body field.
+func decodeGatewayRequest(r *http.Request, msg *dynamicpb.Message) error {
+	if r.Method == http.MethodPost {
+		body, err := io.ReadAll(http.MaxBytesReader(nil, r.Body, gatewayMaxBody))
+		if err != nil {
+			return fmt.Errorf("reading body: %w", err)
+		}
+		if len(strings.TrimSpace(string(body))) > 0 {
+			if err := protojson.Unmarshal(body, msg); err != nil {
+				return err
+			}
+		}
+	}
+	for key, values := range r.URL.Query() {
+		if err := setGatewayField(msg, key, values); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// setGatewayField sets the scalar or repeated scalar field named key, by
+// its JSON or proto name.
+func setGatewayField(msg *dynamicpb.Message, key string, values []string) error {
+	fields := msg.Descriptor().Fields()
+	fd := fields.ByJSONName(key)
+	if fd == nil {
+		fd = fields.ByName(protoreflect.Name(key))
+	}
+	if fd == nil {
+		return fmt.Errorf("query parameter %q: no such field in %s", key, msg.Descriptor().FullName())
+	}
+	if fd.IsMap() || fd.Message() != nil {
+		return fmt.Errorf("query parameter %q: only scalar fields can be set from the query; send a JSON body", key)
+	}
+	if fd.IsList() {
+		list := msg.Mutable(fd).List()
+		for _, s := range values {
+			v, err := parseGatewayValue(fd, s)
+			if err != nil {
+				return fmt.Errorf("query parameter %q: %w", key, err)
+			}
+			list.Append(v)
+		}
+		return nil
+	}
+	v, err := parseGatewayValue(fd, values[len(values)-1])
+	if err != nil {
+		return fmt.Errorf("query parameter %q: %w", key, err)
+	}
+	msg.Set(fd, v)
+	return nil
+}
+
+func parseGatewayValue(fd protoreflect.FieldDescriptor, s string) (protoreflect.Value, error) {
+	switch fd.Kind() {
+	case protoreflect.StringKind:
+		return protoreflect.ValueOfString(s), nil
+	case protoreflect.BoolKind:
+		b, err := strconv.ParseBool(s)
+		return protoreflect.ValueOfBool(b), err
+	case protoreflect.Int32Kind, protoreflect.Sint32Kind, protoreflect.Sfixed32Kind:
+		n, err := strconv.ParseInt(s, 10, 32)
+		return protoreflect.ValueOfInt32(int32(n)), err
+	case protoreflect.Int64Kind, protoreflect.Sint64Kind, protoreflect.Sfixed64Kind:
+		n, err := strconv.ParseInt(s, 10, 64)
+		return protoreflect.ValueOfInt64(n), err
+	case protoreflect.Uint32Kind, protoreflect.Fixed32Kind:
+		n, err := strconv.ParseUint(s, 10, 32)
+		return protoreflect.ValueOfUint32(uint32(n)), err
+	case protoreflect.Uint64Kind, protoreflect.Fixed64Kind:
+		n, err := strconv.ParseUint(s, 10, 64)
+		return protoreflect.ValueOfUint64(n), err
+	case protoreflect.FloatKind:
+		f, err := strconv.ParseFloat(s, 32)
+		return protoreflect.ValueOfFloat32(float32(f)), err
+	case protoreflect.DoubleKind:
+		f, err := strconv.ParseFloat(s, 64)
+		return protoreflect.ValueOfFloat64(f), err
+	case protoreflect.BytesKind:
+		b, err := base64.StdEncoding.DecodeString(s)
+		return protoreflect.ValueOfBytes(b), err
+	case protoreflect.EnumKind:
+		if ev := fd.Enum().Values().ByName(protoreflect.Name(s)); ev != nil {
+			return protoreflect.ValueOfEnum(ev.Number()), nil
+		}
+		n, err := strconv.ParseInt(s, 10, 32)
+		if err != nil {
+			return protoreflect.Value{}, fmt.Errorf("%q is not a %s value", s, fd.Enum().FullName())
+		}
+		return protoreflect.ValueOfEnum(protoreflect.EnumNumber(n)), nil
+	}
+	return protoreflect.Value{}, fmt.Errorf("unsupported field kind %v", fd.Kind())
+}
+
+// gatewayMetadata picks the request headers forwarded to the backend.
+func gatewayMetadata(h http.Header) metadata.MD {
+	md := metadata.MD{}
+	if v := h.Get("Authorization"); v != "" {
+		md.Set("authorization", v)
+	}
+	for k, vs := range h {
+		if name, ok := strings.CutPrefix(k, "Grpc-Metadata-"); ok && name != "" {
+			md.Append(strings.ToLower(name), vs...)
+		}
+	}
+	return md
+}
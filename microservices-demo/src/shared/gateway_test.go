@@ -0,0 +1,201 @@
+package shared
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/test/bufconn"
+
+	apperrors "github.com/GoogleCloudPlatform/microservices-demo/src/shared/errors"
+)
+
+// newTestGateway serves CoverageControl and Health over bufconn behind a
+// gateway on an httptest server.
+func newTestGateway(t *testing.T, opts ...GatewayOption) *httptest.Server {
+	t.Helper()
+	lis := bufconn.Listen(1 << 20)
+	srv := grpc.NewServer()
+	RegisterCoverageControl(srv)
+	hs := health.NewServer()
+	hs.SetServingStatus("cart", healthpb.HealthCheckResponse_NOT_SERVING)
+	healthpb.RegisterHealthServer(srv, hs)
+	go srv.Serve(lis)
+	t.Cleanup(srv.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	gw, err := NewGateway(conn, append([]GatewayOption{WithGatewayServer(srv)}, opts...)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewServer(gw)
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+func TestGatewayUnary(t *testing.T) {
+	ts := newTestGateway(t)
+
+	resp, err := http.Post(ts.URL+"/api/grpc.health.v1.Health/Check", "application/json", strings.NewReader(`{"service":"cart"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var got map[string]any
+	json.NewDecoder(resp.Body).Decode(&got)
+	if resp.StatusCode != http.StatusOK || got["status"] != "NOT_SERVING" {
+		t.Errorf("POST Check = %d %v, want 200 with status NOT_SERVING", resp.StatusCode, got)
+	}
+
+	resp, err = http.Get(ts.URL + "/api/coveragecontrol.CoverageControl/Status")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	got = nil
+	json.NewDecoder(resp.Body).Decode(&got)
+	if _, ok := got["dumpCount"]; !ok {
+		t.Errorf("GET Status = %v, want unpopulated fields included", got)
+	}
+}
+
+func TestGatewayQueryParameters(t *testing.T) {
+	ts := newTestGateway(t)
+
+	resp, err := http.Get(ts.URL + "/api/grpc.health.v1.Health/Check?service=cart")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var got map[string]any
+	json.NewDecoder(resp.Body).Decode(&got)
+	if got["status"] != "NOT_SERVING" {
+		t.Errorf("GET Check?service=cart = %v, want status NOT_SERVING", got)
+	}
+
+	resp, err = http.Get(ts.URL + "/api/coveragecontrol.CoverageControl/Dump?clear=maybe")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("invalid bool parameter: status %d, want 400", resp.StatusCode)
+	}
+}
+
+func TestGatewayErrors(t *testing.T) {
+	t.Setenv("GOCOVERDIR", "")
+	os.Unsetenv("GOCOVERDIR")
+	ts := newTestGateway(t)
+
+	for _, tc := range []struct {
+		path, body string
+		status     int
+		code       string
+	}{
+		{"/api/coveragecontrol.CoverageControl/Dump", `{}`, http.StatusBadRequest, ""},
+		{"/api/coveragecontrol.CoverageControl/Missing", `{}`, http.StatusNotFound, "GATEWAY_UNKNOWN_METHOD"},
+		{"/api/grpc.health.v1.Health/Check", `{"nope":1}`, http.StatusBadRequest, "GATEWAY_BAD_REQUEST"},
+	} {
+		resp, err := http.Post(ts.URL+tc.path, "application/json", strings.NewReader(tc.body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var p apperrors.Problem
+		json.NewDecoder(resp.Body).Decode(&p)
+		resp.Body.Close()
+		if resp.StatusCode != tc.status || p.Code != tc.code || resp.Header.Get("Content-Type") != apperrors.ProblemContentType {
+			t.Errorf("POST %s = %d %+v, want %d with code %q", tc.path, resp.StatusCode, p, tc.status, tc.code)
+		}
+	}
+}
+
+func TestGatewayServerStreaming(t *testing.T) {
+	ts := newTestGateway(t)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, http.MethodPost, ts.URL+"/api/grpc.health.v1.Health/Watch", strings.NewReader(`{"service":"cart"}`))
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "application/x-ndjson" {
+		t.Errorf("Content-Type = %q, want application/x-ndjson", ct)
+	}
+	line, err := bufio.NewReader(resp.Body).ReadString('\n')
+	if err != nil && err != io.EOF {
+		t.Fatal(err)
+	}
+	if !strings.Contains(line, "NOT_SERVING") {
+		t.Errorf("first line = %q, want the current status", line)
+	}
+}
+
+func TestGatewayIndexAndCORS(t *testing.T) {
+	ts := newTestGateway(t, WithGatewayCORS(CORSConfig{AllowedOrigins: []string{"http://ui.test"}}))
+
+	req, _ := http.NewRequest(http.MethodGet, ts.URL+"/api/", nil)
+	req.Header.Set("Origin", "http://ui.test")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var index []gatewayMethod
+	json.NewDecoder(resp.Body).Decode(&index)
+	var watch gatewayMethod
+	for _, m := range index {
+		if m.Path == "/api/grpc.health.v1.Health/Watch" {
+			watch = m
+		}
+	}
+	if watch.Streaming != "server" || len(index) < 5 {
+		t.Errorf("index = %+v, want the CoverageControl and Health methods with Watch streaming", index)
+	}
+	if got := resp.Header.Get("Access-Control-Allow-Origin"); got != "http://ui.test" {
+		t.Errorf("Access-Control-Allow-Origin = %q, want http://ui.test", got)
+	}
+}
+
+func TestGatewayMetadata(t *testing.T) {
+	h := http.Header{}
+	h.Set("Authorization", "Bearer t")
+	h.Set("Grpc-Metadata-Tenant", "acme")
+	h.Set("Cookie", "secret")
+	md := gatewayMetadata(h)
+	want := metadata.Pairs("authorization", "Bearer t", "tenant", "acme")
+	if len(md) != len(want) || md.Get("authorization")[0] != "Bearer t" || md.Get("tenant")[0] != "acme" {
+		t.Errorf("metadata = %v, want %v", md, want)
+	}
+}
+
+func TestNewGatewayNeedsServices(t *testing.T) {
+	if _, err := NewGateway(nil); err == nil {
+		t.Error("NewGateway without services succeeded")
+	}
+	if _, err := NewGateway(nil, WithGatewayServices("no.Such")); err == nil {
+		t.Error("NewGateway with an unknown service succeeded")
+	}
+}
@@ -0,0 +1,23 @@
+package shared
+
+import (
+	"context"
+
+	"google.golang.org/grpc"
+
+	"github.com/GoogleCloudPlatform/microservices-demo/src/shared/grpcclient"
+)
+
+// DialService connects to target with the shared client defaults:
+// wait-for-ready with exponential backoff, a per-call timeout, keepalive,
+// and tracing and metrics interceptors. It forwards to grpcclient.Dial,
+// whose documentation lists the variables and options.
+//
+//	conn, err := shared.DialService(ctx, os.Getenv("SHIPPING_SERVICE_ADDR"))
+//	if err != nil {
+//	    log.Fatal(err)
+//	}
+//	defer conn.Close()
+func DialService(ctx context.Context, target string, opts ...grpcclient.Option) (*grpc.ClientConn, error) {
+	return grpcclient.Dial(ctx, target, opts...)
+}
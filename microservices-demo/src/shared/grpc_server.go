@@ -0,0 +1,20 @@
+package shared
+
+import (
+	"google.golang.org/grpc"
+
+	"github.com/GoogleCloudPlatform/microservices-demo/src/shared/grpcserver"
+)
+
+// NewGRPCServer returns a gRPC server with the shared production defaults:
+// keepalive enforcement, message size limits, request ID, metrics, logging,
+// error mapping and panic recovery interceptors, and optional reflection,
+// all configured from the environment. It forwards to grpcserver.New, whose
+// documentation lists the variables and options.
+//
+//	srv := shared.NewGRPCServer(grpcserver.WithLogger(log))
+//	pb.RegisterShippingServiceServer(srv, svc)
+//	srv.Serve(lis)
+func NewGRPCServer(opts ...grpcserver.Option) *grpc.Server {
+	return grpcserver.New(opts...)
+}
@@ -0,0 +1,266 @@
+package grpcclient
+
+import (
+	"math"
+	"math/rand/v2"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"google.golang.org/grpc/balancer"
+	"google.golang.org/grpc/balancer/base"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/GoogleCloudPlatform/microservices-demo/src/shared/metrics"
+)
+
+// Balancing policies registered by this package, selectable with
+// GRPC_CLIENT_LB_POLICY or WithBalancer next to gRPC's own pick_first and
+// round_robin.
+const (
+	// LeastRequest sends each call to the less busy of two random
+	// backends, by calls in flight, so a slow pod stops receiving new
+	// calls while its queue drains.
+	LeastRequest = "least_request"
+	// EWMA sends each call to the cheaper of two random backends, cost
+	// being the peak-sensitive moving average of their latency times
+	// their calls in flight; it also steers away from backends that are
+	// slow without being busy, such as on a noisy node.
+	EWMA = "ewma"
+)
+
+const (
+	// ewmaDecay is the time constant of the latency average: samples
+	// older than this weigh about a third as much as a fresh one.
+	ewmaDecay = 10 * time.Second
+	// ewmaErrorPenalty is recorded as the latency of calls failing in a
+	// way that suggests the backend is in trouble, so fast failures do not
+	// attract traffic.
+	ewmaErrorPenalty = time.Second
+)
+
+var (
+	backendInflight = metrics.NewGaugeVec("grpc_client_backend_inflight",
+		"Calls in flight per backend, for the least_request and ewma policies.", "service", "backend")
+	backendPicks = metrics.NewCounterVec("grpc_client_backend_picks_total",
+		"Calls sent to each backend by the least_request and ewma policies.", "service", "backend")
+	backendLatency = metrics.NewGaugeVec("grpc_client_backend_latency_ewma_seconds",
+		"Moving average of call latency per backend, for the ewma policy.", "service", "backend")
+)
+
+func init() {
+	balancer.Register(&loadBuilder{name: LeastRequest})
+	balancer.Register(&loadBuilder{name: EWMA})
+}
+
+// loadBuilder builds a base balancer whose pickers share one set of
+// backend statistics per ClientConn, so rebuilding the picker when a
+// backend comes or goes keeps what is known about the others.
+type loadBuilder struct {
+	name string
+}
+
+func (b *loadBuilder) Name() string { return b.name }
+
+func (b *loadBuilder) Build(cc balancer.ClientConn, opts balancer.BuildOptions) balancer.Balancer {
+	pb := &loadPickerBuilder{
+		ewma:    b.name == EWMA,
+		service: opts.Target.Endpoint(),
+		stats:   make(map[balancer.SubConn]*backendStats),
+	}
+	return &loadBalancer{
+		Balancer: base.NewBalancerBuilder(b.name, pb, base.Config{HealthCheck: true}).Build(cc, opts),
+		pb:       pb,
+	}
+}
+
+// loadBalancer hands the policy's config to the picker builder, which base
+// does not.
+type loadBalancer struct {
+	balancer.Balancer
+	pb *loadPickerBuilder
+}
+
+func (b *loadBalancer) UpdateClientConnState(s balancer.ClientConnState) error {
+	if cfg, ok := s.BalancerConfig.(*loadConfig); ok {
+		b.pb.configure(cfg)
+	}
+	return b.Balancer.UpdateClientConnState(s)
+}
+
+// loadPickerBuilder is only called from the balancer's serializer, so its
+// fields need no lock.
+type loadPickerBuilder struct {
+	ewma    bool
+	service string
+	stats   map[balancer.SubConn]*backendStats
+	ejector *ejector // nil without outlier ejection
+}
+
+func (pb *loadPickerBuilder) configure(cfg *loadConfig) {
+	o := cfg.ejection()
+	switch {
+	case o == nil:
+		pb.ejector = nil
+	case pb.ejector == nil || pb.ejector.cfg != o.withDefaults():
+		// The resolver pushes the same config with every update; only a
+		// changed one starts afresh.
+		pb.ejector = newEjector(*o, pb.service, time.Now())
+	}
+}
+
+// backendStats is what the pickers know about one backend.
+type backendStats struct {
+	addr     string
+	inflight atomic.Int64
+
+	mu      sync.Mutex
+	latency float64 // EWMA in seconds
+	stamp   time.Time
+
+	// Outlier ejection; ejections is guarded by the ejector's mutex.
+	calls, failures atomic.Int64 // in the current interval
+	ejectedUntil    atomic.Int64 // unix nanoseconds
+	ejections       int          // ejections in a row, lengthening the next
+}
+
+func (pb *loadPickerBuilder) Build(info base.PickerBuildInfo) balancer.Picker {
+	if len(info.ReadySCs) == 0 {
+		return base.NewErrPicker(balancer.ErrNoSubConnAvailable)
+	}
+	backends := make([]*backendStats, 0, len(info.ReadySCs))
+	conns := make([]balancer.SubConn, 0, len(info.ReadySCs))
+	all := make([]int, 0, len(info.ReadySCs))
+	for sc, sci := range info.ReadySCs {
+		s, ok := pb.stats[sc]
+		if !ok {
+			s = &backendStats{addr: sci.Address.Addr}
+			pb.stats[sc] = s
+		}
+		all = append(all, len(backends))
+		backends = append(backends, s)
+		conns = append(conns, sc)
+	}
+	for sc, s := range pb.stats {
+		if _, ok := info.ReadySCs[sc]; !ok {
+			delete(pb.stats, sc)
+			backendInflight.DeleteLabelValues(pb.service, s.addr)
+			backendLatency.DeleteLabelValues(pb.service, s.addr)
+			backendEjected.DeleteLabelValues(pb.service, s.addr)
+		}
+	}
+	return &loadPicker{ewma: pb.ewma, service: pb.service, conns: conns, backends: backends, all: all, ejector: pb.ejector}
+}
+
+type loadPicker struct {
+	ewma     bool
+	service  string
+	conns    []balancer.SubConn
+	backends []*backendStats
+	all      []int // indexes of every backend
+	ejector  *ejector
+}
+
+// Pick chooses between two random backends ("power of two choices"), which
+// spreads load nearly as well as comparing every backend while keeping
+// replicas of a client from all piling onto the same least-loaded one.
+func (p *loadPicker) Pick(balancer.PickInfo) (balancer.PickResult, error) {
+	candidates := p.all
+	if now := time.Now(); p.ejector != nil && p.ejector.active(now) {
+		candidates = p.eligible(now)
+	}
+	i := candidates[0]
+	if n := len(candidates); n > 1 {
+		a := rand.IntN(n)
+		b := rand.IntN(n - 1)
+		if b >= a {
+			b++
+		}
+		i = candidates[a]
+		if j := candidates[b]; p.cost(j) < p.cost(i) {
+			i = j
+		}
+	}
+	s := p.backends[i]
+	s.inflight.Add(1)
+	backendInflight.WithLabelValues(p.service, s.addr).Inc()
+	backendPicks.WithLabelValues(p.service, s.addr).Inc()
+	start := time.Now()
+	return balancer.PickResult{
+		SubConn: p.conns[i],
+		Done: func(di balancer.DoneInfo) {
+			s.inflight.Add(-1)
+			backendInflight.WithLabelValues(p.service, s.addr).Dec()
+			if p.ewma {
+				rtt := time.Since(start)
+				if backendTrouble(di.Err) {
+					rtt = max(rtt, ewmaErrorPenalty)
+				}
+				backendLatency.WithLabelValues(p.service, s.addr).Set(s.observe(rtt, time.Now()))
+			}
+			if p.ejector != nil {
+				p.ejector.record(s, di.Err, p.backends, time.Now())
+			}
+		},
+	}, nil
+}
+
+// eligible returns the indexes of the backends not ejected at now, or all
+// of them if every one is.
+func (p *loadPicker) eligible(now time.Time) []int {
+	var out []int
+	for i, s := range p.backends {
+		if !s.ejected(now) {
+			out = append(out, i)
+		}
+	}
+	if len(out) == 0 {
+		return p.all
+	}
+	return out
+}
+
+func (p *loadPicker) cost(i int) float64 {
+	s := p.backends[i]
+	inflight := float64(s.inflight.Load())
+	if !p.ewma {
+		return inflight
+	}
+	return s.average() * (inflight + 1)
+}
+
+// observe folds rtt into the average and returns it. A sample above the
+// average replaces it outright, so a backend turning slow is avoided at
+// once and only trusted again gradually.
+func (s *backendStats) observe(rtt time.Duration, now time.Time) float64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	v := rtt.Seconds()
+	if v > s.latency || s.stamp.IsZero() {
+		s.latency = v
+	} else {
+		w := math.Exp(-float64(now.Sub(s.stamp)) / float64(ewmaDecay))
+		s.latency = s.latency*w + v*(1-w)
+	}
+	s.stamp = now
+	return s.latency
+}
+
+// average returns the latency average; backends without samples yet cost
+// nothing, so new pods get traffic right away.
+func (s *backendStats) average() float64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.latency
+}
+
+// backendTrouble reports whether err says more about the backend than
+// about the request.
+func backendTrouble(err error) bool {
+	switch status.Code(err) {
+	case codes.Unavailable, codes.DeadlineExceeded, codes.ResourceExhausted, codes.Internal, codes.Unknown:
+		return true
+	}
+	return false
+}
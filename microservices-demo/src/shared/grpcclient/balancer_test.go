@@ -0,0 +1,83 @@
+package grpcclient
+
+import (
+	"context"
+	"math"
+	"net"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+
+	"github.com/GoogleCloudPlatform/microservices-demo/src/shared/discovery"
+)
+
+func TestLeastRequestAvoidsBusyBackend(t *testing.T) {
+	arrived := make(chan int, 20)
+	release := make(chan struct{})
+	defer close(release)
+	var eps staticResolver
+	for i := range 2 {
+		lis, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		srv := grpc.NewServer(grpc.UnaryInterceptor(func(ctx context.Context, req any, _ *grpc.UnaryServerInfo, h grpc.UnaryHandler) (any, error) {
+			arrived <- i
+			if i == 0 {
+				<-release // backend 0 never answers while the test runs
+			}
+			return h(ctx, req)
+		}))
+		healthpb.RegisterHealthServer(srv, health.NewServer())
+		go srv.Serve(lis)
+		defer srv.Stop()
+		eps = append(eps, discovery.Endpoint{Addr: lis.Addr().String()})
+	}
+
+	conn, err := Dial(context.Background(), "discovery:///cartservice",
+		WithResolver(eps), WithBalancer(LeastRequest), WithoutTracing(), WithCallTimeout(time.Minute))
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer conn.Close()
+	client := healthpb.NewHealthClient(conn)
+	var busy int
+	for range 20 {
+		go client.Check(context.Background(), &healthpb.HealthCheckRequest{})
+		if <-arrived == 0 {
+			busy++
+		}
+	}
+	// Only calls picked before the idle backend was ready, or while both
+	// were idle, may reach the busy one.
+	if busy > 3 {
+		t.Errorf("%d of 20 calls went to the backend with calls stuck in flight", busy)
+	}
+}
+
+func TestDialRejectsUnknownBalancer(t *testing.T) {
+	if _, err := Dial(context.Background(), "localhost:1", WithBalancer("fastest")); err == nil {
+		t.Error("Dial with an unregistered policy succeeded")
+	}
+}
+
+func TestBackendStatsObserve(t *testing.T) {
+	var s backendStats
+	now := time.Now()
+	if got := s.observe(100*time.Millisecond, now); got != 0.1 {
+		t.Fatalf("first sample = %v, want 0.1", got)
+	}
+	// A slower sample takes over at once.
+	if got := s.observe(time.Second, now); got != 1 {
+		t.Fatalf("after a peak = %v, want 1", got)
+	}
+	// A faster one only pulls the average down by how old the last one is.
+	now = now.Add(ewmaDecay)
+	want := math.Exp(-1) + 0.1*(1-math.Exp(-1))
+	if got := s.observe(100*time.Millisecond, now); math.Abs(got-want) > 1e-9 {
+		t.Fatalf("after decay = %v, want %v", got, want)
+	}
+}
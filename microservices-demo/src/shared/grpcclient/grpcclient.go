@@ -0,0 +1,232 @@
+// Package grpcclient dials downstream services with the same client defaults
+// everywhere: wait-for-ready calls bounded by a default per-call timeout,
+// exponential reconnect backoff, keepalive, request ID and baggage propagation,
+// OpenTelemetry tracing and Prometheus metrics.
+//
+// Targets in the "discovery" scheme, such as "discovery:///cartservice",
+// connect to every healthy backend found by package discovery and balance
+// calls across them, instead of sending everything over the one connection
+// a ClusterIP address yields. Besides gRPC's round_robin, the LeastRequest
+// and EWMA policies pick backends by their load, and can eject backends
+// whose calls keep failing (see OutlierEjection).
+package grpcclient
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/backoff"
+	"google.golang.org/grpc/balancer"
+	"google.golang.org/grpc/connectivity"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/keepalive"
+
+	"github.com/GoogleCloudPlatform/microservices-demo/src/shared/baggage"
+	"github.com/GoogleCloudPlatform/microservices-demo/src/shared/discovery"
+	"github.com/GoogleCloudPlatform/microservices-demo/src/shared/internal/env"
+	"github.com/GoogleCloudPlatform/microservices-demo/src/shared/requestid"
+)
+
+// Defaults applied when the corresponding environment variable is unset.
+const (
+	DefaultCallTimeout      = 5 * time.Second
+	DefaultKeepaliveTime    = 30 * time.Second
+	DefaultKeepaliveTimeout = 10 * time.Second
+	DefaultMaxBackoff       = 30 * time.Second
+)
+
+// Option configures Dial.
+type Option func(*config)
+
+type config struct {
+	callTimeout time.Duration
+	block       bool
+	tracing     bool
+	unary       []grpc.UnaryClientInterceptor
+	stream      []grpc.StreamClientInterceptor
+	dialOpts    []grpc.DialOption
+	resolver    discovery.Resolver
+	balancer    string
+	ejection    *OutlierEjection
+}
+
+// WithCallTimeout overrides GRPC_CLIENT_TIMEOUT. Zero disables the default
+// deadline, leaving calls bounded only by their context.
+func WithCallTimeout(d time.Duration) Option {
+	return func(c *config) { c.callTimeout = d }
+}
+
+// WithBlock makes Dial wait until the connection is ready or ctx is done.
+func WithBlock() Option {
+	return func(c *config) { c.block = true }
+}
+
+// WithoutTracing disables the OpenTelemetry stats handler.
+func WithoutTracing() Option {
+	return func(c *config) { c.tracing = false }
+}
+
+// WithUnaryInterceptors appends interceptors after the built-in ones.
+func WithUnaryInterceptors(i ...grpc.UnaryClientInterceptor) Option {
+	return func(c *config) { c.unary = append(c.unary, i...) }
+}
+
+// WithStreamInterceptors appends interceptors after the built-in ones.
+func WithStreamInterceptors(i ...grpc.StreamClientInterceptor) Option {
+	return func(c *config) { c.stream = append(c.stream, i...) }
+}
+
+// WithResolver resolves "discovery" targets with r instead of
+// discovery.Default.
+func WithResolver(r discovery.Resolver) Option {
+	return func(c *config) { c.resolver = r }
+}
+
+// WithBalancer overrides GRPC_CLIENT_LB_POLICY with a registered balancing
+// policy such as round_robin, LeastRequest or EWMA.
+func WithBalancer(policy string) Option {
+	return func(c *config) { c.balancer = policy }
+}
+
+// WithOutlierEjection turns on outlier ejection with o, overriding the
+// GRPC_CLIENT_OUTLIER_* variables. It needs the LeastRequest or EWMA policy
+// and selects LeastRequest when no policy is set.
+func WithOutlierEjection(o OutlierEjection) Option {
+	return func(c *config) { c.ejection = &o }
+}
+
+// WithDialOptions passes additional options straight to grpc.NewClient, e.g.
+// transport credentials to replace the insecure default.
+func WithDialOptions(opts ...grpc.DialOption) Option {
+	return func(c *config) { c.dialOpts = append(c.dialOpts, opts...) }
+}
+
+// Dial creates a client connection to target. Unlike a bare grpc.Dial, calls
+// made on the connection wait for the backend to become ready instead of
+// failing fast, but never longer than the per-call timeout, so a dependency
+// that is down surfaces as DeadlineExceeded rather than a hung request.
+//
+// Settings read from the environment:
+//
+//	GRPC_CLIENT_TIMEOUT            default per-call deadline (default 5s)
+//	GRPC_CLIENT_KEEPALIVE_TIME     ping interval on idle connections (default 30s)
+//	GRPC_CLIENT_KEEPALIVE_TIMEOUT  ping ack timeout (default 10s)
+//	GRPC_CLIENT_MAX_BACKOFF        upper bound on reconnect backoff (default 30s)
+//	GRPC_CLIENT_LB_POLICY          balancing policy: pick_first, round_robin,
+//	                               least_request or ewma (default round_robin
+//	                               for discovery targets, else gRPC's pick_first)
+//	GRPC_CLIENT_OUTLIER_EJECTION   eject failing backends (default false)
+//	GRPC_CLIENT_OUTLIER_EJECTION_TIME
+//	                               first ejection's length (default 30s)
+//	GRPC_CLIENT_OUTLIER_MAX_EJECTION_PERCENT
+//	                               most backends ejected at once (default 10)
+//
+// Usage:
+//
+//	conn, err := grpcclient.Dial(ctx, os.Getenv("SHIPPING_SERVICE_ADDR"))
+//	if err != nil {
+//	    log.Fatal(err)
+//	}
+//	defer conn.Close()
+//	client := pb.NewShippingServiceClient(conn)
+func Dial(ctx context.Context, target string, opts ...Option) (*grpc.ClientConn, error) {
+	c := config{
+		callTimeout: env.Duration("GRPC_CLIENT_TIMEOUT", DefaultCallTimeout),
+		tracing:     true,
+		balancer:    os.Getenv("GRPC_CLIENT_LB_POLICY"),
+	}
+	if env.Bool("GRPC_CLIENT_OUTLIER_EJECTION", false) {
+		c.ejection = &OutlierEjection{
+			EjectionTime:       env.Duration("GRPC_CLIENT_OUTLIER_EJECTION_TIME", 0),
+			MaxEjectionPercent: env.Int("GRPC_CLIENT_OUTLIER_MAX_EJECTION_PERCENT", 0),
+		}
+	}
+	for _, opt := range opts {
+		opt(&c)
+	}
+
+	bc := backoff.DefaultConfig
+	bc.MaxDelay = env.Duration("GRPC_CLIENT_MAX_BACKOFF", DefaultMaxBackoff)
+
+	dialOpts := []grpc.DialOption{
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithDefaultCallOptions(grpc.WaitForReady(true)),
+		grpc.WithConnectParams(grpc.ConnectParams{Backoff: bc, MinConnectTimeout: 5 * time.Second}),
+		grpc.WithKeepaliveParams(keepalive.ClientParameters{
+			Time:                env.Duration("GRPC_CLIENT_KEEPALIVE_TIME", DefaultKeepaliveTime),
+			Timeout:             env.Duration("GRPC_CLIENT_KEEPALIVE_TIMEOUT", DefaultKeepaliveTimeout),
+			PermitWithoutStream: true,
+		}),
+		grpc.WithChainUnaryInterceptor(append([]grpc.UnaryClientInterceptor{
+			requestid.UnaryClientInterceptor(),
+			baggage.UnaryClientInterceptor(),
+			unaryTimeout(c.callTimeout),
+			unaryMetrics(),
+		}, c.unary...)...),
+		grpc.WithChainStreamInterceptor(append([]grpc.StreamClientInterceptor{
+			requestid.StreamClientInterceptor(),
+			baggage.StreamClientInterceptor(),
+			streamMetrics(),
+		}, c.stream...)...),
+	}
+	if c.tracing {
+		dialOpts = append(dialOpts, grpc.WithStatsHandler(otelgrpc.NewClientHandler()))
+	}
+	policy := c.balancer
+	if c.ejection != nil {
+		switch policy {
+		case "":
+			policy = LeastRequest
+		case LeastRequest, EWMA:
+		default:
+			return nil, fmt.Errorf("grpcclient: outlier ejection needs the %s or %s policy, not %s", LeastRequest, EWMA, policy)
+		}
+	}
+	if strings.HasPrefix(target, discovery.Scheme+":") {
+		if policy == "" {
+			// pick_first would pin every call to one of the backends.
+			policy = "round_robin"
+		}
+		if c.resolver != nil {
+			dialOpts = append(dialOpts, grpc.WithResolvers(discovery.NewGRPCBuilder(c.resolver)))
+		}
+	}
+	if policy != "" {
+		if balancer.Get(policy) == nil {
+			return nil, fmt.Errorf("grpcclient: unknown balancing policy %q", policy)
+		}
+		dialOpts = append(dialOpts, grpc.WithDefaultServiceConfig(serviceConfig(policy, c.ejection)))
+	}
+	dialOpts = append(dialOpts, c.dialOpts...)
+
+	conn, err := grpc.NewClient(target, dialOpts...)
+	if err != nil {
+		return nil, fmt.Errorf("grpcclient: dialing %s: %w", target, err)
+	}
+	if c.block {
+		if err := waitReady(ctx, conn); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("grpcclient: connecting to %s: %w", target, err)
+		}
+	}
+	return conn, nil
+}
+
+// waitReady blocks until conn is Ready or ctx is done.
+func waitReady(ctx context.Context, conn *grpc.ClientConn) error {
+	conn.Connect()
+	for {
+		s := conn.GetState()
+		if s == connectivity.Ready {
+			return nil
+		}
+		if !conn.WaitForStateChange(ctx, s) {
+			return ctx.Err()
+		}
+	}
+}
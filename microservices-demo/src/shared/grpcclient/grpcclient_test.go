@@ -0,0 +1,112 @@
+package grpcclient
+
+import (
+	"context"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+
+	"github.com/GoogleCloudPlatform/microservices-demo/src/shared/discovery"
+)
+
+func TestDialCallsHealthyBackend(t *testing.T) {
+	lis := bufconn.Listen(1 << 20)
+	srv := grpc.NewServer()
+	healthpb.RegisterHealthServer(srv, health.NewServer())
+	go srv.Serve(lis)
+	defer srv.Stop()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	conn, err := Dial(ctx, "passthrough:///bufnet", WithBlock(), WithoutTracing(),
+		WithDialOptions(grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return lis.DialContext(ctx)
+		})))
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer conn.Close()
+
+	if _, err := healthpb.NewHealthClient(conn).Check(context.Background(), &healthpb.HealthCheckRequest{}); err != nil {
+		t.Errorf("Check: %v", err)
+	}
+}
+
+func TestDefaultCallTimeoutWhenBackendDown(t *testing.T) {
+	conn, err := Dial(context.Background(), "passthrough:///down", WithCallTimeout(50*time.Millisecond), WithoutTracing(),
+		WithDialOptions(grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return nil, &net.OpError{Op: "dial", Err: net.ErrClosed}
+		})))
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer conn.Close()
+
+	start := time.Now()
+	_, err = healthpb.NewHealthClient(conn).Check(context.Background(), &healthpb.HealthCheckRequest{})
+	if status.Code(err) != codes.DeadlineExceeded {
+		t.Errorf("Check error = %v, want DeadlineExceeded", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("call took %v, default timeout not applied", elapsed)
+	}
+}
+
+// staticResolver always resolves to its endpoints.
+type staticResolver []discovery.Endpoint
+
+func (r staticResolver) Resolve(context.Context, string) ([]discovery.Endpoint, error) {
+	return r, nil
+}
+
+func (r staticResolver) Watch(ctx context.Context, _ string) <-chan discovery.Update {
+	ch := make(chan discovery.Update, 1)
+	ch <- discovery.Update{Endpoints: r}
+	go func() {
+		<-ctx.Done()
+		close(ch)
+	}()
+	return ch
+}
+
+func TestDialDiscoveryBalances(t *testing.T) {
+	var eps staticResolver
+	var calls [2]atomic.Int32
+	for i := range calls {
+		lis, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		srv := grpc.NewServer(grpc.UnaryInterceptor(func(ctx context.Context, req any, _ *grpc.UnaryServerInfo, h grpc.UnaryHandler) (any, error) {
+			calls[i].Add(1)
+			return h(ctx, req)
+		}))
+		healthpb.RegisterHealthServer(srv, health.NewServer())
+		go srv.Serve(lis)
+		defer srv.Stop()
+		eps = append(eps, discovery.Endpoint{Addr: lis.Addr().String()})
+	}
+
+	conn, err := Dial(context.Background(), "discovery:///cartservice", WithResolver(eps), WithoutTracing())
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer conn.Close()
+	client := healthpb.NewHealthClient(conn)
+	for range 20 {
+		if _, err := client.Check(context.Background(), &healthpb.HealthCheckRequest{}); err != nil {
+			t.Fatalf("Check: %v", err)
+		}
+	}
+	if calls[0].Load() == 0 || calls[1].Load() == 0 {
+		t.Errorf("calls per backend = %d, %d; want both used", calls[0].Load(), calls[1].Load())
+	}
+}
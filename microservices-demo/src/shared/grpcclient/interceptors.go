@@ -0,0 +1,55 @@
+package grpcclient
+
+import (
+	"context"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+
+	"github.com/GoogleCloudPlatform/microservices-demo/src/shared/metrics"
+)
+
+var (
+	handledTotal = metrics.NewCounterVec("grpc_client_handled_total",
+		"Total RPCs completed by the client, by method and status code.", "method", "code")
+	handlingSeconds = metrics.NewHistogramVec("grpc_client_handling_seconds",
+		"Latency of RPCs issued by the client.", nil, "method")
+)
+
+// unaryTimeout applies d as the deadline of calls whose context has none.
+func unaryTimeout(d time.Duration) grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		if _, ok := ctx.Deadline(); !ok && d > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, d)
+			defer cancel()
+		}
+		return invoker(ctx, method, req, reply, cc, opts...)
+	}
+}
+
+// unaryMetrics records the outcome and latency of each call.
+func unaryMetrics() grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		start := time.Now()
+		err := invoker(ctx, method, req, reply, cc, opts...)
+		handledTotal.WithLabelValues(method, status.Code(err).String()).Inc()
+		metrics.Observe(ctx, handlingSeconds.WithLabelValues(method), time.Since(start).Seconds())
+		return err
+	}
+}
+
+// streamMetrics records failures to open streams; per-message outcomes are
+// left to tracing.
+func streamMetrics() grpc.StreamClientInterceptor {
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		start := time.Now()
+		cs, err := streamer(ctx, desc, cc, method, opts...)
+		if err != nil {
+			handledTotal.WithLabelValues(method, status.Code(err).String()).Inc()
+			metrics.Observe(ctx, handlingSeconds.WithLabelValues(method), time.Since(start).Seconds())
+		}
+		return cs, err
+	}
+}
@@ -0,0 +1,242 @@
+package grpcclient
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"google.golang.org/grpc/serviceconfig"
+
+	"github.com/GoogleCloudPlatform/microservices-demo/src/shared/metrics"
+)
+
+// OutlierEjection configures taking backends out of the pick set while their
+// calls fail far more often than their peers'. A circuit breaker stops calls
+// to a whole dependency; ejection keeps the dependency usable while one of
+// its pods is broken, which a breaker keyed by target cannot tell apart.
+//
+// Ejection runs inside the LeastRequest and EWMA policies and counts the
+// failures that point at the backend rather than the request: Unavailable,
+// DeadlineExceeded, ResourceExhausted, Internal and Unknown. Zero fields
+// take the defaults documented on each.
+type OutlierEjection struct {
+	// Interval is how often failure rates are evaluated (default 10s).
+	Interval time.Duration
+	// FailurePercent is the share of a backend's calls in an interval that
+	// must fail for it to be ejected (default 50).
+	FailurePercent int
+	// MinRequests is how many calls a backend needs in an interval to be
+	// judged at all (default 20).
+	MinRequests int
+	// EjectionTime is how long a first ejection lasts; a backend ejected
+	// again soon after coming back stays out one EjectionTime longer each
+	// time (default 30s).
+	EjectionTime time.Duration
+	// MaxEjectionTime caps the growing ejection time (default 5m).
+	MaxEjectionTime time.Duration
+	// MaxEjectionPercent caps the share of backends ejected at once
+	// (default 10). One backend may always be ejected, but never the last.
+	MaxEjectionPercent int
+}
+
+func (o OutlierEjection) withDefaults() OutlierEjection {
+	if o.Interval <= 0 {
+		o.Interval = 10 * time.Second
+	}
+	if o.FailurePercent <= 0 {
+		o.FailurePercent = 50
+	}
+	if o.MinRequests <= 0 {
+		o.MinRequests = 20
+	}
+	if o.EjectionTime <= 0 {
+		o.EjectionTime = 30 * time.Second
+	}
+	if o.MaxEjectionTime <= 0 {
+		o.MaxEjectionTime = 5 * time.Minute
+	}
+	if o.MaxEjectionPercent <= 0 {
+		o.MaxEjectionPercent = 10
+	}
+	return o
+}
+
+var (
+	backendEjected = metrics.NewGaugeVec("grpc_client_backend_ejected",
+		"Whether a backend was ejected for failing calls, as of the last evaluation.", "service", "backend")
+	backendEjections = metrics.NewCounterVec("grpc_client_backend_ejections_total",
+		"Backends ejected for failing calls.", "service", "backend")
+)
+
+// loadConfig is the service config of the LeastRequest and EWMA policies:
+//
+//	{"least_request": {"outlierEjection": {"interval": "10s", "failurePercent": 50,
+//	  "minRequests": 20, "ejectionTime": "30s", "maxEjectionTime": "5m",
+//	  "maxEjectionPercent": 10}}}
+type loadConfig struct {
+	serviceconfig.LoadBalancingConfig `json:"-"`
+
+	OutlierEjection *ejectionJSON `json:"outlierEjection,omitempty"`
+}
+
+// ejectionJSON is OutlierEjection with durations spelled as in Go.
+type ejectionJSON struct {
+	Interval           string `json:"interval,omitempty"`
+	FailurePercent     int    `json:"failurePercent,omitempty"`
+	MinRequests        int    `json:"minRequests,omitempty"`
+	EjectionTime       string `json:"ejectionTime,omitempty"`
+	MaxEjectionTime    string `json:"maxEjectionTime,omitempty"`
+	MaxEjectionPercent int    `json:"maxEjectionPercent,omitempty"`
+}
+
+func serviceConfig(policy string, ejection *OutlierEjection) string {
+	var cfg loadConfig
+	if o := ejection; o != nil {
+		cfg.OutlierEjection = &ejectionJSON{
+			Interval:           formatDuration(o.Interval),
+			FailurePercent:     o.FailurePercent,
+			MinRequests:        o.MinRequests,
+			EjectionTime:       formatDuration(o.EjectionTime),
+			MaxEjectionTime:    formatDuration(o.MaxEjectionTime),
+			MaxEjectionPercent: o.MaxEjectionPercent,
+		}
+	}
+	b, _ := json.Marshal(map[string]any{"loadBalancingConfig": []any{map[string]any{policy: cfg}}})
+	return string(b)
+}
+
+func formatDuration(d time.Duration) string {
+	if d <= 0 {
+		return ""
+	}
+	return d.String()
+}
+
+// ParseConfig implements balancer.ConfigParser.
+func (b *loadBuilder) ParseConfig(js json.RawMessage) (serviceconfig.LoadBalancingConfig, error) {
+	var cfg loadConfig
+	if err := json.Unmarshal(js, &cfg); err != nil {
+		return nil, fmt.Errorf("grpcclient: parsing %s config: %w", b.name, err)
+	}
+	if j := cfg.OutlierEjection; j != nil {
+		for _, s := range []string{j.Interval, j.EjectionTime, j.MaxEjectionTime} {
+			if _, err := parseDuration(s); err != nil {
+				return nil, fmt.Errorf("grpcclient: parsing %s config: %w", b.name, err)
+			}
+		}
+	}
+	return &cfg, nil
+}
+
+func parseDuration(s string) (time.Duration, error) {
+	if s == "" {
+		return 0, nil
+	}
+	return time.ParseDuration(s)
+}
+
+// ejection returns the configured OutlierEjection, or nil when disabled.
+func (c *loadConfig) ejection() *OutlierEjection {
+	j := c.OutlierEjection
+	if j == nil {
+		return nil
+	}
+	// ParseConfig vetted the durations.
+	interval, _ := parseDuration(j.Interval)
+	ejectionTime, _ := parseDuration(j.EjectionTime)
+	maxEjectionTime, _ := parseDuration(j.MaxEjectionTime)
+	return &OutlierEjection{
+		Interval:           interval,
+		FailurePercent:     j.FailurePercent,
+		MinRequests:        j.MinRequests,
+		EjectionTime:       ejectionTime,
+		MaxEjectionTime:    maxEjectionTime,
+		MaxEjectionPercent: j.MaxEjectionPercent,
+	}
+}
+
+// ejector decides which backends of one ClientConn are ejected. Failures
+// are counted on each backendStats and evaluated when a call finishes after
+// the interval has passed, so an idle connection costs nothing.
+type ejector struct {
+	cfg     OutlierEjection
+	service string
+
+	// until is the latest ejection end in unix nanoseconds, letting Pick
+	// skip looking for ejected backends most of the time.
+	until atomic.Int64
+
+	mu   sync.Mutex
+	next time.Time // next evaluation
+}
+
+func newEjector(cfg OutlierEjection, service string, now time.Time) *ejector {
+	cfg = cfg.withDefaults()
+	return &ejector{cfg: cfg, service: service, next: now.Add(cfg.Interval)}
+}
+
+// active reports whether any backend may be ejected at now.
+func (e *ejector) active(now time.Time) bool {
+	return now.UnixNano() < e.until.Load()
+}
+
+// record counts a finished call and evaluates backends once per interval.
+func (e *ejector) record(s *backendStats, err error, backends []*backendStats, now time.Time) {
+	s.calls.Add(1)
+	if backendTrouble(err) {
+		s.failures.Add(1)
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if now.Before(e.next) {
+		return
+	}
+	e.next = now.Add(e.cfg.Interval)
+	e.evaluate(backends, now)
+}
+
+// evaluate ejects the backends that failed too often in the interval just
+// ended, within MaxEjectionPercent, and lets the ejection time of the others
+// shrink back. Called with e.mu held.
+func (e *ejector) evaluate(backends []*backendStats, now time.Time) {
+	ejected := 0
+	for _, s := range backends {
+		if s.ejected(now) {
+			ejected++
+		}
+	}
+	limit := min(max(1, len(backends)*e.cfg.MaxEjectionPercent/100), len(backends)-1)
+	for _, s := range backends {
+		calls, failures := s.calls.Swap(0), s.failures.Swap(0)
+		switch {
+		case s.ejected(now):
+		case calls >= int64(e.cfg.MinRequests) && failures*100 >= calls*int64(e.cfg.FailurePercent) && ejected < limit:
+			s.ejections++
+			d := min(time.Duration(s.ejections)*e.cfg.EjectionTime, e.cfg.MaxEjectionTime)
+			until := now.Add(d).UnixNano()
+			s.ejectedUntil.Store(until)
+			if until > e.until.Load() {
+				e.until.Store(until)
+			}
+			ejected++
+			backendEjections.WithLabelValues(e.service, s.addr).Inc()
+			slog.Warn("ejecting gRPC backend", "service", e.service, "backend", s.addr,
+				"failures", failures, "calls", calls, "duration", d)
+		case s.ejections > 0:
+			s.ejections--
+		}
+		ejectedValue := 0.0
+		if s.ejected(now) {
+			ejectedValue = 1
+		}
+		backendEjected.WithLabelValues(e.service, s.addr).Set(ejectedValue)
+	}
+}
+
+// ejected reports whether s is out of the pick set at now.
+func (s *backendStats) ejected(now time.Time) bool {
+	return now.UnixNano() < s.ejectedUntil.Load()
+}
@@ -0,0 +1,147 @@
+package grpcclient
+
+import (
+	"context"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/balancer"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+
+	"github.com/GoogleCloudPlatform/microservices-demo/src/shared/discovery"
+)
+
+func TestEjectorEvaluate(t *testing.T) {
+	now := time.Now()
+	e := newEjector(OutlierEjection{MinRequests: 10, EjectionTime: time.Minute, MaxEjectionTime: 3 * time.Minute}, "cartservice", now)
+	backends := []*backendStats{{addr: "a"}, {addr: "b"}, {addr: "c"}}
+	fail := func(s *backendStats, calls, failures int64) {
+		s.calls.Store(calls)
+		s.failures.Store(failures)
+	}
+
+	// Too few calls to judge b; a and c fail at 60% but only one backend of
+	// three may be out at once.
+	fail(backends[0], 10, 6)
+	fail(backends[1], 5, 5)
+	fail(backends[2], 10, 6)
+	e.evaluate(backends, now)
+	if !backends[0].ejected(now) || backends[1].ejected(now) || backends[2].ejected(now) {
+		t.Fatalf("ejected = %v %v %v, want only a", backends[0].ejected(now), backends[1].ejected(now), backends[2].ejected(now))
+	}
+	if !e.active(now) || e.active(now.Add(time.Minute)) {
+		t.Error("ejector should be active for exactly the ejection time")
+	}
+
+	// Ejected again right after coming back, a stays out twice as long.
+	now = now.Add(time.Minute)
+	fail(backends[0], 20, 20)
+	e.evaluate(backends, now)
+	if !backends[0].ejected(now.Add(2*time.Minute-time.Second)) || backends[0].ejected(now.Add(2*time.Minute)) {
+		t.Error("second ejection should last two ejection times")
+	}
+	for range 3 {
+		now = now.Add(2 * time.Minute)
+		e.evaluate(backends, now)
+	}
+	if backends[0].ejections != 0 {
+		t.Errorf("ejections after healthy intervals = %d, want 0", backends[0].ejections)
+	}
+}
+
+func TestEjectorKeepsLastBackend(t *testing.T) {
+	now := time.Now()
+	e := newEjector(OutlierEjection{MinRequests: 1, MaxEjectionPercent: 100}, "cartservice", now)
+	backends := []*backendStats{{addr: "a"}, {addr: "b"}}
+	for _, s := range backends {
+		s.calls.Store(5)
+		s.failures.Store(5)
+	}
+	e.evaluate(backends, now)
+	if backends[0].ejected(now) == backends[1].ejected(now) {
+		t.Error("want exactly one of two failing backends ejected")
+	}
+}
+
+func TestLoadConfigRoundTrip(t *testing.T) {
+	o := OutlierEjection{Interval: time.Second, FailurePercent: 30, EjectionTime: time.Minute, MaxEjectionPercent: 50}
+	b := &loadBuilder{name: EWMA}
+	js := serviceConfig(EWMA, &o)
+	const prefix, suffix = `{"loadBalancingConfig":[{"ewma":`, `}]}`
+	if len(js) < len(prefix)+len(suffix) || js[:len(prefix)] != prefix {
+		t.Fatalf("service config = %s", js)
+	}
+	cfg, err := b.ParseConfig([]byte(js[len(prefix) : len(js)-len(suffix)]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := cfg.(*loadConfig).ejection(); got == nil || *got != o {
+		t.Errorf("parsed ejection = %+v, want %+v", got, o)
+	}
+	if _, err := b.ParseConfig([]byte(`{"outlierEjection":{"interval":"soon"}}`)); err == nil {
+		t.Error("ParseConfig accepted a malformed duration")
+	}
+	if _, ok := balancer.Get(EWMA).(balancer.ConfigParser); !ok {
+		t.Error("ewma does not parse its config")
+	}
+}
+
+func TestDialEjectsFailingBackend(t *testing.T) {
+	var eps staticResolver
+	var served [2]atomic.Int32
+	for i := range served {
+		lis, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		srv := grpc.NewServer(grpc.UnaryInterceptor(func(ctx context.Context, req any, _ *grpc.UnaryServerInfo, h grpc.UnaryHandler) (any, error) {
+			served[i].Add(1)
+			if i == 0 {
+				return nil, status.Error(codes.Unavailable, "broken pod")
+			}
+			return h(ctx, req)
+		}))
+		healthpb.RegisterHealthServer(srv, health.NewServer())
+		go srv.Serve(lis)
+		defer srv.Stop()
+		eps = append(eps, discovery.Endpoint{Addr: lis.Addr().String()})
+	}
+
+	conn, err := Dial(context.Background(), "discovery:///cartservice", WithResolver(eps), WithoutTracing(),
+		WithOutlierEjection(OutlierEjection{Interval: 20 * time.Millisecond, MinRequests: 3, EjectionTime: time.Minute}))
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer conn.Close()
+	client := healthpb.NewHealthClient(conn)
+	call := func() error {
+		_, err := client.Check(context.Background(), &healthpb.HealthCheckRequest{})
+		return err
+	}
+
+	// Without ejection, each call has even odds of reaching the broken
+	// backend, so a run of 20 successes means it was ejected.
+	deadline := time.Now().Add(5 * time.Second)
+	for ok := 0; ok < 20; {
+		if time.Now().After(deadline) {
+			t.Fatalf("failing backend was never ejected (%d and %d calls served)", served[0].Load(), served[1].Load())
+		}
+		if call() == nil {
+			ok++
+		} else {
+			ok = 0
+		}
+	}
+}
+
+func TestDialOutlierEjectionNeedsLoadPolicy(t *testing.T) {
+	if _, err := Dial(context.Background(), "localhost:1", WithBalancer("round_robin"), WithOutlierEjection(OutlierEjection{})); err == nil {
+		t.Error("Dial enabled outlier ejection under round_robin")
+	}
+}
@@ -0,0 +1,76 @@
+package grpcserver
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"net"
+	"strings"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// panicHealth panics on every Check call.
+type panicHealth struct {
+	healthpb.UnimplementedHealthServer
+}
+
+func (panicHealth) Check(context.Context, *healthpb.HealthCheckRequest) (*healthpb.HealthCheckResponse, error) {
+	panic("boom")
+}
+
+func dial(t *testing.T, srv *grpc.Server) *grpc.ClientConn {
+	t.Helper()
+	lis := bufconn.Listen(1 << 20)
+	go srv.Serve(lis)
+	t.Cleanup(srv.Stop)
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func TestNewRecoversPanics(t *testing.T) {
+	var buf bytes.Buffer
+	srv := New(WithLogger(slog.New(slog.NewJSONHandler(&buf, nil))), WithReflection(false))
+	healthpb.RegisterHealthServer(srv, panicHealth{})
+
+	_, err := healthpb.NewHealthClient(dial(t, srv)).Check(context.Background(), &healthpb.HealthCheckRequest{})
+	if status.Code(err) != codes.Internal {
+		t.Fatalf("Check error = %v, want code Internal", err)
+	}
+	out := buf.String()
+	for _, want := range []string{"panic in gRPC handler", `"code":"Internal"`} {
+		if !strings.Contains(out, want) {
+			t.Errorf("log output missing %q:\n%s", want, out)
+		}
+	}
+}
+
+func TestNewAppliesCustomInterceptors(t *testing.T) {
+	called := false
+	srv := New(WithReflection(false), WithUnaryInterceptors(
+		func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+			called = true
+			return handler(ctx, req)
+		}))
+	healthpb.RegisterHealthServer(srv, health.NewServer())
+
+	if _, err := healthpb.NewHealthClient(dial(t, srv)).Check(context.Background(), &healthpb.HealthCheckRequest{}); err != nil {
+		t.Fatal(err)
+	}
+	if !called {
+		t.Error("custom interceptor not invoked")
+	}
+}
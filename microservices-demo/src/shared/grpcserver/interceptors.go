@@ -0,0 +1,80 @@
+package grpcserver
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+
+	"github.com/GoogleCloudPlatform/microservices-demo/src/shared/metrics"
+)
+
+var (
+	handledTotal = metrics.NewCounterVec("grpc_server_handled_total",
+		"Total RPCs completed on the server, by method and status code.", "method", "code")
+	handlingSeconds = metrics.NewHistogramVec("grpc_server_handling_seconds",
+		"Latency of RPCs handled by the server.", nil, "method")
+)
+
+// unaryMetrics records the outcome and latency of each RPC.
+func unaryMetrics() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		start := time.Now()
+		resp, err := handler(ctx, req)
+		observe(ctx, info.FullMethod, start, err)
+		return resp, err
+	}
+}
+
+// streamMetrics records the outcome and duration of each stream.
+func streamMetrics() grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		start := time.Now()
+		err := handler(srv, ss)
+		observe(ss.Context(), info.FullMethod, start, err)
+		return err
+	}
+}
+
+func observe(ctx context.Context, method string, start time.Time, err error) {
+	handledTotal.WithLabelValues(method, status.Code(err).String()).Inc()
+	metrics.Observe(ctx, handlingSeconds.WithLabelValues(method), time.Since(start).Seconds())
+}
+
+// unaryLogging logs one line per completed RPC; failures are logged at warn.
+func unaryLogging(log *slog.Logger) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		start := time.Now()
+		resp, err := handler(ctx, req)
+		logRPC(ctx, log, info.FullMethod, start, err)
+		return resp, err
+	}
+}
+
+// streamLogging logs one line per completed stream.
+func streamLogging(log *slog.Logger) grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		start := time.Now()
+		err := handler(srv, ss)
+		logRPC(ss.Context(), log, info.FullMethod, start, err)
+		return err
+	}
+}
+
+func logRPC(ctx context.Context, log *slog.Logger, method string, start time.Time, err error) {
+	level := slog.LevelInfo
+	if err != nil {
+		level = slog.LevelWarn
+	}
+	attrs := []any{
+		"method", method,
+		"code", status.Code(err).String(),
+		"duration", time.Since(start),
+	}
+	if err != nil {
+		attrs = append(attrs, "error", err)
+	}
+	log.Log(ctx, level, "rpc completed", attrs...)
+}
@@ -0,0 +1,200 @@
+package shared
+
+import (
+	"hash/fnv"
+	"slices"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+)
+
+// DefaultVirtualNodes is the number of points each unit of weight places on
+// a HashRing, enough to keep members within a few percent of their share.
+const DefaultVirtualNodes = 160
+
+// HashRing maps keys to members by consistent hashing: a membership change
+// only moves the keys of the members that joined or left, about 1/n of them,
+// instead of reshuffling everything as hash-mod-n would. Every process
+// building a ring from the same members and weights maps keys the same way.
+//
+// Lookups are lock-free and safe to run concurrently with updates.
+//
+//	ring := shared.NewHashRing()
+//	ring.Set(map[string]int{"redis-0:6379": 1, "redis-1:6379": 1, "redis-2:6379": 2})
+//	addr, _ := ring.Get(sessionID)
+//	client := clients[addr]
+type HashRing struct {
+	vnodes int
+	hash   func([]byte) uint64
+
+	mu      sync.Mutex // serializes updates
+	members map[string]int
+	ring    atomic.Pointer[ringState]
+}
+
+// ringState is an immutable snapshot of the ring.
+type ringState struct {
+	points []ringPoint // sorted by hash
+	count  int         // distinct members
+}
+
+type ringPoint struct {
+	hash   uint64
+	member string
+}
+
+// HashRingOption configures NewHashRing.
+type HashRingOption func(*HashRing)
+
+// WithHashRingVirtualNodes sets the points per unit of weight (default
+// DefaultVirtualNodes). More points even out the load at the cost of
+// memory and rebuild time.
+func WithHashRingVirtualNodes(n int) HashRingOption {
+	return func(r *HashRing) {
+		if n > 0 {
+			r.vnodes = n
+		}
+	}
+}
+
+// WithHashRingHash replaces the hash function, FNV-1a with a final mix. All
+// processes sharing keys must use the same one.
+func WithHashRingHash(fn func([]byte) uint64) HashRingOption {
+	return func(r *HashRing) { r.hash = fn }
+}
+
+// NewHashRing returns an empty ring.
+func NewHashRing(opts ...HashRingOption) *HashRing {
+	r := &HashRing{vnodes: DefaultVirtualNodes, hash: ringHash, members: make(map[string]int)}
+	for _, opt := range opts {
+		opt(r)
+	}
+	r.ring.Store(&ringState{})
+	return r
+}
+
+// Add adds member with weight (at least 1), or changes its weight. A member
+// of weight 2 receives twice the keys of one of weight 1.
+func (r *HashRing) Add(member string, weight int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.members[member] = max(weight, 1)
+	r.rebuild()
+}
+
+// Remove removes member; its keys move to the members that follow it on
+// the ring.
+func (r *HashRing) Remove(member string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.members[member]; !ok {
+		return
+	}
+	delete(r.members, member)
+	r.rebuild()
+}
+
+// Set replaces the membership with members and their weights, e.g. from a
+// discovery update.
+func (r *HashRing) Set(members map[string]int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.members = make(map[string]int, len(members))
+	for m, w := range members {
+		r.members[m] = max(w, 1)
+	}
+	r.rebuild()
+}
+
+// Members returns the members, sorted.
+func (r *HashRing) Members() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	out := make([]string, 0, len(r.members))
+	for m := range r.members {
+		out = append(out, m)
+	}
+	slices.Sort(out)
+	return out
+}
+
+// Len returns the number of members.
+func (r *HashRing) Len() int { return r.ring.Load().count }
+
+// rebuild recomputes the points. r.mu must be held.
+func (r *HashRing) rebuild() {
+	total := 0
+	for _, w := range r.members {
+		total += w * r.vnodes
+	}
+	points := make([]ringPoint, 0, total)
+	var buf []byte
+	for m, w := range r.members {
+		for i := range w * r.vnodes {
+			buf = strconv.AppendInt(append(append(buf[:0], m...), '#'), int64(i), 10)
+			points = append(points, ringPoint{hash: r.hash(buf), member: m})
+		}
+	}
+	// Ties, however unlikely, break by member so every process agrees.
+	sort.Slice(points, func(i, j int) bool {
+		if points[i].hash != points[j].hash {
+			return points[i].hash < points[j].hash
+		}
+		return points[i].member < points[j].member
+	})
+	r.ring.Store(&ringState{points: points, count: len(r.members)})
+}
+
+// Get returns the member owning key, or false if the ring is empty.
+func (r *HashRing) Get(key string) (string, bool) {
+	s := r.ring.Load()
+	if len(s.points) == 0 {
+		return "", false
+	}
+	return s.points[s.search(r.hash([]byte(key)))].member, true
+}
+
+// GetN returns up to n distinct members for key, the owner first and then
+// the members that would take over from it: the replicas of a replicated
+// key, or the fallbacks to try when the owner is down.
+func (r *HashRing) GetN(key string, n int) []string {
+	s := r.ring.Load()
+	n = min(n, s.count)
+	if n <= 0 {
+		return nil
+	}
+	out := make([]string, 0, n)
+	start := s.search(r.hash([]byte(key)))
+	for i := 0; len(out) < n; i++ {
+		m := s.points[(start+i)%len(s.points)].member
+		if !slices.Contains(out, m) {
+			out = append(out, m)
+		}
+	}
+	return out
+}
+
+// search returns the index of the first point at or after h, wrapping
+// around.
+func (s *ringState) search(h uint64) int {
+	i := sort.Search(len(s.points), func(i int) bool { return s.points[i].hash >= h })
+	if i == len(s.points) {
+		i = 0
+	}
+	return i
+}
+
+// ringHash is FNV-1a followed by the splitmix64 finalizer, which spreads
+// FNV's weak low bits for keys that differ only in their last bytes.
+func ringHash(b []byte) uint64 {
+	h := fnv.New64a()
+	h.Write(b)
+	x := h.Sum64()
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
@@ -0,0 +1,119 @@
+package shared
+
+import (
+	"fmt"
+	"math"
+	"slices"
+	"sync"
+	"testing"
+)
+
+func ringCounts(r *HashRing, keys int) map[string]int {
+	counts := make(map[string]int)
+	for i := range keys {
+		m, _ := r.Get(fmt.Sprintf("session-%d", i))
+		counts[m]++
+	}
+	return counts
+}
+
+func TestHashRingDistributionAndWeights(t *testing.T) {
+	r := NewHashRing()
+	if _, ok := r.Get("x"); ok {
+		t.Fatal("empty ring returned a member")
+	}
+	r.Set(map[string]int{"redis-0": 1, "redis-1": 1, "redis-2": 2})
+	const keys = 40000
+	counts := ringCounts(r, keys)
+	for m, share := range map[string]float64{"redis-0": 0.25, "redis-1": 0.25, "redis-2": 0.5} {
+		if got := float64(counts[m]) / keys; math.Abs(got-share) > 0.05 {
+			t.Errorf("%s owns %.3f of the keys, want about %.2f", m, got, share)
+		}
+	}
+}
+
+func TestHashRingMovesFewKeys(t *testing.T) {
+	r := NewHashRing()
+	r.Set(map[string]int{"a": 1, "b": 1, "c": 1, "d": 1})
+	const keys = 10000
+	before := make([]string, keys)
+	for i := range before {
+		before[i], _ = r.Get(fmt.Sprintf("k%d", i))
+	}
+	r.Add("e", 1)
+	moved := 0
+	for i := range before {
+		after, _ := r.Get(fmt.Sprintf("k%d", i))
+		if after != before[i] {
+			if after != "e" {
+				t.Fatalf("key k%d moved from %s to %s, not to the new member", i, before[i], after)
+			}
+			moved++
+		}
+	}
+	if share := float64(moved) / keys; share < 0.12 || share > 0.28 {
+		t.Errorf("adding a fifth member moved %.3f of the keys, want about 0.2", share)
+	}
+
+	r.Remove("e")
+	for i := range before {
+		if after, _ := r.Get(fmt.Sprintf("k%d", i)); after != before[i] {
+			t.Fatalf("key k%d on %s after removing the new member, want %s", i, after, before[i])
+		}
+	}
+}
+
+func TestHashRingGetN(t *testing.T) {
+	r := NewHashRing(WithHashRingVirtualNodes(10))
+	r.Set(map[string]int{"a": 1, "b": 1, "c": 1})
+	got := r.GetN("cart-42", 5)
+	if owner, _ := r.Get("cart-42"); len(got) != 3 || got[0] != owner {
+		t.Fatalf("GetN = %v, want all three members led by %s", got, owner)
+	}
+	slices.Sort(got)
+	if !slices.Equal(got, []string{"a", "b", "c"}) || !slices.Equal(r.Members(), got) {
+		t.Errorf("GetN members = %v, Members = %v", got, r.Members())
+	}
+	if r.GetN("cart-42", 0) != nil || NewHashRing().GetN("x", 2) != nil {
+		t.Error("GetN returned members for n = 0 or an empty ring")
+	}
+}
+
+func TestHashRingIsDeterministic(t *testing.T) {
+	a, b := NewHashRing(), NewHashRing()
+	a.Set(map[string]int{"x": 1, "y": 3, "z": 1})
+	b.Add("z", 1)
+	b.Add("y", 3)
+	b.Add("x", 1)
+	for i := range 1000 {
+		key := fmt.Sprint(i)
+		ma, _ := a.Get(key)
+		if mb, _ := b.Get(key); ma != mb {
+			t.Fatalf("rings built in different orders disagree on %s", key)
+		}
+	}
+}
+
+func TestHashRingConcurrentUpdates(t *testing.T) {
+	r := NewHashRing(WithHashRingVirtualNodes(8))
+	r.Add("a", 1)
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for i := range 200 {
+			r.Add(fmt.Sprint("m", i%5), 1)
+			r.Remove(fmt.Sprint("m", (i+2)%5))
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := range 2000 {
+			if _, ok := r.Get(fmt.Sprint(i)); !ok {
+				t.Error("Get found no member while a was never removed")
+				return
+			}
+		}
+	}()
+	wg.Wait()
+}
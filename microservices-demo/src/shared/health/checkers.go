@@ -0,0 +1,43 @@
+package health
+
+import (
+	"context"
+	"fmt"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/connectivity"
+)
+
+// GRPCConn reports whether conn can reach its target. An idle connection is
+// asked to connect, and the check waits for it to become ready until the
+// check's deadline.
+func GRPCConn(conn *grpc.ClientConn) Checker {
+	return func(ctx context.Context) error {
+		for {
+			s := conn.GetState()
+			switch s {
+			case connectivity.Ready:
+				return nil
+			case connectivity.Shutdown:
+				return fmt.Errorf("connection to %s is closed", conn.Target())
+			case connectivity.Idle:
+				conn.Connect()
+			}
+			if !conn.WaitForStateChange(ctx, s) {
+				return fmt.Errorf("connection to %s is %v: %w", conn.Target(), s, ctx.Err())
+			}
+		}
+	}
+}
+
+// Pinger is implemented by *sql.DB and most database client libraries.
+type Pinger interface {
+	PingContext(ctx context.Context) error
+}
+
+// Ping reports whether db answers a ping.
+func Ping(db Pinger) Checker {
+	return func(ctx context.Context) error {
+		return db.PingContext(ctx)
+	}
+}
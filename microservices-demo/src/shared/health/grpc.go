@@ -0,0 +1,79 @@
+package health
+
+import (
+	"context"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+)
+
+// WatchInterval is how often Watch re-evaluates the checks.
+var WatchInterval = 5 * time.Second
+
+// RegisterGRPC registers r as the grpc.health.v1.Health service on s. The
+// empty service name reports overall readiness; any other name reports the
+// single check registered under it.
+func RegisterGRPC(s grpc.ServiceRegistrar, r *Registry) {
+	healthpb.RegisterHealthServer(s, &grpcServer{r: r})
+}
+
+// grpcServer implements healthpb.HealthServer.
+type grpcServer struct {
+	healthpb.UnimplementedHealthServer
+	r *Registry
+}
+
+// Check reports the current status of req.Service.
+func (s *grpcServer) Check(ctx context.Context, req *healthpb.HealthCheckRequest) (*healthpb.HealthCheckResponse, error) {
+	st, ok := s.status(ctx, req.GetService())
+	if !ok {
+		return nil, status.Errorf(codes.NotFound, "unknown service %q", req.GetService())
+	}
+	return &healthpb.HealthCheckResponse{Status: st}, nil
+}
+
+// Watch sends the status of req.Service immediately and again whenever it
+// changes, re-evaluating every WatchInterval.
+func (s *grpcServer) Watch(req *healthpb.HealthCheckRequest, ws healthpb.Health_WatchServer) error {
+	ctx := ws.Context()
+	ticker := time.NewTicker(WatchInterval)
+	defer ticker.Stop()
+
+	last := healthpb.HealthCheckResponse_ServingStatus(-1)
+	for {
+		st, ok := s.status(ctx, req.GetService())
+		if !ok {
+			st = healthpb.HealthCheckResponse_SERVICE_UNKNOWN
+		}
+		if st != last {
+			if err := ws.Send(&healthpb.HealthCheckResponse{Status: st}); err != nil {
+				return err
+			}
+			last = st
+		}
+		select {
+		case <-ctx.Done():
+			return status.FromContextError(ctx.Err()).Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *grpcServer) status(ctx context.Context, service string) (healthpb.HealthCheckResponse_ServingStatus, bool) {
+	var rep Report
+	if service == "" {
+		rep = s.r.Run(ctx, Readiness)
+	} else {
+		var ok bool
+		if rep, ok = s.r.Check(ctx, service); !ok {
+			return healthpb.HealthCheckResponse_SERVICE_UNKNOWN, false
+		}
+	}
+	if rep.Healthy {
+		return healthpb.HealthCheckResponse_SERVING, true
+	}
+	return healthpb.HealthCheckResponse_NOT_SERVING, true
+}
@@ -0,0 +1,245 @@
+// Package health lets a service register named checks once and expose them as
+// Kubernetes-style HTTP probes (/healthz, /readyz, /startupz) and as the
+// standard grpc.health.v1 service, so the kubelet, load balancers and other
+// services all see the same answer.
+package health
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// DefaultTimeout bounds each check unless the registry is created with
+// WithTimeout.
+const DefaultTimeout = 2 * time.Second
+
+// Checker reports whether a dependency or subsystem is healthy. It should
+// return promptly once ctx is done.
+type Checker func(ctx context.Context) error
+
+// Kind selects which probe a check contributes to.
+type Kind int
+
+const (
+	// Liveness checks decide whether the process should be restarted. Keep
+	// them to in-process state; a failing dependency is not a reason to
+	// restart.
+	Liveness Kind = iota
+	// Readiness checks decide whether the service should receive traffic.
+	Readiness
+	// Startup checks gate the other probes until initialization has finished.
+	// Once they have all passed, startup is reported as complete for the rest
+	// of the process lifetime.
+	Startup
+)
+
+func (k Kind) String() string {
+	switch k {
+	case Liveness:
+		return "liveness"
+	case Readiness:
+		return "readiness"
+	case Startup:
+		return "startup"
+	}
+	return fmt.Sprintf("Kind(%d)", int(k))
+}
+
+// Result is the outcome of a single check.
+type Result struct {
+	Name     string        `json:"name"`
+	Error    string        `json:"error,omitempty"`
+	Duration time.Duration `json:"durationNs"`
+}
+
+// Report is the outcome of every check of one kind.
+type Report struct {
+	Healthy bool     `json:"healthy"`
+	Checks  []Result `json:"checks"`
+}
+
+type check struct {
+	name  string
+	fn    Checker
+	kinds []Kind
+}
+
+// Registry holds the checks of a service.
+type Registry struct {
+	timeout time.Duration
+
+	mu      sync.RWMutex
+	checks  []check
+	started bool
+}
+
+// Option configures a Registry.
+type Option func(*Registry)
+
+// WithTimeout sets the per-check timeout.
+func WithTimeout(d time.Duration) Option {
+	return func(r *Registry) { r.timeout = d }
+}
+
+// New creates an empty Registry.
+//
+// Usage:
+//
+//	hr := health.New()
+//	hr.Register("catalog", health.GRPCConn(catalogConn), health.Readiness)
+//	hr.Register("db", health.Ping(db), health.Readiness, health.Startup)
+//	health.RegisterGRPC(srv, hr)
+//	mux.Handle("/", hr.Handler())
+func New(opts ...Option) *Registry {
+	r := &Registry{timeout: DefaultTimeout}
+	for _, opt := range opts {
+		opt(r)
+	}
+	return r
+}
+
+// Register adds a named check to the given probes; with no kinds it is a
+// readiness check. Registering a name twice replaces the earlier check.
+func (r *Registry) Register(name string, fn Checker, kinds ...Kind) {
+	if len(kinds) == 0 {
+		kinds = []Kind{Readiness}
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i := range r.checks {
+		if r.checks[i].name == name {
+			r.checks[i] = check{name: name, fn: fn, kinds: kinds}
+			return
+		}
+	}
+	r.checks = append(r.checks, check{name: name, fn: fn, kinds: kinds})
+}
+
+// Run executes every check of kind k concurrently and reports the outcome.
+// A kind with no checks is healthy. Readiness is unhealthy until startup has
+// completed.
+func (r *Registry) Run(ctx context.Context, k Kind) Report {
+	if k == Startup && r.isStarted() {
+		return Report{Healthy: true}
+	}
+	rep := r.run(ctx, r.selectChecks(func(c check) bool { return c.has(k) }))
+	if k == Startup && rep.Healthy {
+		r.mu.Lock()
+		r.started = true
+		r.mu.Unlock()
+	}
+	if k == Readiness && rep.Healthy && !r.isStarted() {
+		if st := r.Run(ctx, Startup); !st.Healthy {
+			rep.Healthy = false
+			rep.Checks = append(rep.Checks, Result{Name: "startup", Error: "startup not complete"})
+		}
+	}
+	return rep
+}
+
+// Check runs the single check called name.
+func (r *Registry) Check(ctx context.Context, name string) (Report, bool) {
+	cs := r.selectChecks(func(c check) bool { return c.name == name })
+	if len(cs) == 0 {
+		return Report{}, false
+	}
+	return r.run(ctx, cs), true
+}
+
+// Ready reports whether every readiness check passes.
+func (r *Registry) Ready(ctx context.Context) error {
+	rep := r.Run(ctx, Readiness)
+	if rep.Healthy {
+		return nil
+	}
+	var errs []error
+	for _, res := range rep.Checks {
+		if res.Error != "" {
+			errs = append(errs, fmt.Errorf("%s: %s", res.Name, res.Error))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (r *Registry) isStarted() bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.started
+}
+
+func (r *Registry) selectChecks(match func(check) bool) []check {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	var cs []check
+	for _, c := range r.checks {
+		if match(c) {
+			cs = append(cs, c)
+		}
+	}
+	return cs
+}
+
+func (r *Registry) run(ctx context.Context, cs []check) Report {
+	results := make([]Result, len(cs))
+	var wg sync.WaitGroup
+	for i, c := range cs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i] = r.runOne(ctx, c)
+		}()
+	}
+	wg.Wait()
+
+	sort.Slice(results, func(i, j int) bool { return results[i].Name < results[j].Name })
+	rep := Report{Healthy: true, Checks: results}
+	for _, res := range results {
+		if res.Error != "" {
+			rep.Healthy = false
+		}
+	}
+	return rep
+}
+
+// runOne runs c under the registry timeout, treating a panic as a failure.
+func (r *Registry) runOne(ctx context.Context, c check) (res Result) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeout)
+	defer cancel()
+
+	res.Name = c.name
+	start := time.Now()
+	errc := make(chan error, 1)
+	go func() {
+		defer func() {
+			if p := recover(); p != nil {
+				errc <- fmt.Errorf("panic: %v", p)
+			}
+		}()
+		errc <- c.fn(ctx)
+	}()
+
+	var err error
+	select {
+	case err = <-errc:
+	case <-ctx.Done():
+		err = fmt.Errorf("did not finish within %v: %w", r.timeout, ctx.Err())
+	}
+	res.Duration = time.Since(start)
+	if err != nil {
+		res.Error = err.Error()
+	}
+	return res
+}
+
+func (c check) has(k Kind) bool {
+	for _, ck := range c.kinds {
+		if ck == k {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,122 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+func TestRunByKind(t *testing.T) {
+	r := New()
+	r.Register("ok", func(context.Context) error { return nil }, Liveness, Readiness)
+	r.Register("db", func(context.Context) error { return errors.New("down") })
+
+	if rep := r.Run(context.Background(), Liveness); !rep.Healthy {
+		t.Errorf("liveness = %+v, want healthy", rep)
+	}
+	rep := r.Run(context.Background(), Readiness)
+	if rep.Healthy {
+		t.Errorf("readiness healthy despite failing db check")
+	}
+	if len(rep.Checks) != 2 || rep.Checks[0].Name != "db" || rep.Checks[0].Error != "down" {
+		t.Errorf("readiness checks = %+v", rep.Checks)
+	}
+}
+
+func TestCheckTimeoutAndPanic(t *testing.T) {
+	r := New(WithTimeout(20 * time.Millisecond))
+	r.Register("slow", func(ctx context.Context) error {
+		<-ctx.Done()
+		time.Sleep(200 * time.Millisecond)
+		return nil
+	})
+	r.Register("panics", func(context.Context) error { panic("boom") })
+
+	start := time.Now()
+	rep := r.Run(context.Background(), Readiness)
+	if rep.Healthy {
+		t.Errorf("readiness healthy, want unhealthy")
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("Run took %v, timeout not applied", elapsed)
+	}
+	for _, res := range rep.Checks {
+		if res.Error == "" {
+			t.Errorf("check %q passed, want failure", res.Name)
+		}
+	}
+}
+
+func TestStartupGatesReadinessOnce(t *testing.T) {
+	r := New()
+	started := false
+	r.Register("warmup", func(context.Context) error {
+		if !started {
+			return errors.New("warming up")
+		}
+		return nil
+	}, Startup)
+
+	if rep := r.Run(context.Background(), Readiness); rep.Healthy {
+		t.Errorf("ready before startup completed")
+	}
+	started = true
+	if rep := r.Run(context.Background(), Readiness); !rep.Healthy {
+		t.Errorf("not ready after startup completed: %+v", rep)
+	}
+	started = false
+	if rep := r.Run(context.Background(), Startup); !rep.Healthy {
+		t.Errorf("startup re-evaluated after completing")
+	}
+}
+
+func TestHandler(t *testing.T) {
+	r := New()
+	r.Register("db", func(context.Context) error { return errors.New("down") })
+	h := r.Handler()
+
+	for path, want := range map[string]int{
+		"/healthz":  http.StatusOK,
+		"/readyz":   http.StatusServiceUnavailable,
+		"/startupz": http.StatusOK,
+	} {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		if rec.Code != want {
+			t.Errorf("GET %s = %d, want %d", path, rec.Code, want)
+		}
+	}
+}
+
+func TestGRPCCheck(t *testing.T) {
+	r := New()
+	r.Register("ok", func(context.Context) error { return nil })
+	r.Register("db", func(context.Context) error { return errors.New("down") })
+	s := &grpcServer{r: r}
+
+	tests := []struct {
+		service string
+		want    healthpb.HealthCheckResponse_ServingStatus
+	}{
+		{"", healthpb.HealthCheckResponse_NOT_SERVING},
+		{"ok", healthpb.HealthCheckResponse_SERVING},
+		{"db", healthpb.HealthCheckResponse_NOT_SERVING},
+	}
+	for _, tt := range tests {
+		res, err := s.Check(context.Background(), &healthpb.HealthCheckRequest{Service: tt.service})
+		if err != nil {
+			t.Fatalf("Check(%q): %v", tt.service, err)
+		}
+		if res.Status != tt.want {
+			t.Errorf("Check(%q) = %v, want %v", tt.service, res.Status, tt.want)
+		}
+	}
+	if _, err := s.Check(context.Background(), &healthpb.HealthCheckRequest{Service: "missing"}); err == nil {
+		t.Errorf("Check(missing) succeeded, want NotFound")
+	}
+}
@@ -0,0 +1,40 @@
+package health
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// Handler returns a mux serving the three probes:
+//
+//	GET /healthz   liveness
+//	GET /readyz    readiness
+//	GET /startupz  startup
+//
+// Each responds 200 when healthy and 503 otherwise, with the Report as JSON.
+func (r *Registry) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("/healthz", r.ProbeHandler(Liveness))
+	mux.Handle("/readyz", r.ProbeHandler(Readiness))
+	mux.Handle("/startupz", r.ProbeHandler(Startup))
+	return mux
+}
+
+// ProbeHandler serves a single probe, for services that mount probes at
+// paths of their own.
+func (r *Registry) ProbeHandler(k Kind) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodGet && req.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		rep := r.Run(req.Context(), k)
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		if !rep.Healthy {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		json.NewEncoder(w).Encode(rep)
+	})
+}
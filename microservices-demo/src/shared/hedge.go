@@ -0,0 +1,156 @@
+package shared
+
+import (
+	"context"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/GoogleCloudPlatform/microservices-demo/src/shared/metrics"
+)
+
+var (
+	hedgeAttemptsTotal = metrics.NewCounterVec("hedge_attempts_total",
+		"Hedged attempts started after the first, by method.", "method")
+	hedgeWinsTotal = metrics.NewCounterVec("hedge_wins_total",
+		"Hedged calls that succeeded, by method and winning attempt (primary, hedge).", "method", "winner")
+)
+
+// DefaultHedgeAttempts is the total number of attempts Hedge makes.
+const DefaultHedgeAttempts = 2
+
+// Hedge calls fn and, if it has not returned after delay, calls it again
+// concurrently, returning whichever succeeds first and cancelling the
+// other. An attempt failing with an error IsRetryable accepts starts the
+// next one immediately rather than waiting out the delay; any other error
+// cancels the attempts still running and is returned at once. Only hedge idempotent, read-only work: both
+// attempts may run to completion on the server.
+//
+// Usage:
+//
+//	product, err := shared.Hedge(ctx, 50*time.Millisecond, func(ctx context.Context) (*pb.Product, error) {
+//	    return catalog.GetProduct(ctx, req)
+//	})
+//
+// Pick delay near the dependency's p95 latency, so roughly one call in
+// twenty is duplicated.
+func Hedge[T any](ctx context.Context, delay time.Duration, fn func(ctx context.Context) (T, error)) (T, error) {
+	v, _, _, err := hedge(ctx, delay, DefaultHedgeAttempts, fn)
+	return v, err
+}
+
+// HedgeN is Hedge with up to attempts concurrent attempts, each started
+// delay after the previous one.
+func HedgeN[T any](ctx context.Context, delay time.Duration, attempts int, fn func(ctx context.Context) (T, error)) (T, error) {
+	v, _, _, err := hedge(ctx, delay, attempts, fn)
+	return v, err
+}
+
+type hedgeResult[T any] struct {
+	v       T
+	err     error
+	attempt int
+}
+
+// hedge runs the attempts and also returns the 0-based index of the one
+// that produced the result (-1 if ctx ended first) and how many started.
+func hedge[T any](ctx context.Context, delay time.Duration, attempts int, fn func(ctx context.Context) (T, error)) (v T, winner, started int, err error) {
+	if attempts < 1 {
+		attempts = 1
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	results := make(chan hedgeResult[T], attempts)
+	launch := func(i int) {
+		go func() {
+			v, err := fn(ctx)
+			results <- hedgeResult[T]{v: v, err: err, attempt: i}
+		}()
+	}
+
+	launch(0)
+	started = 1
+	running := 1
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	var last hedgeResult[T]
+	for {
+		var next <-chan time.Time
+		if started < attempts {
+			next = timer.C
+		}
+		select {
+		case r := <-results:
+			running--
+			if r.err == nil {
+				return r.v, r.attempt, started, nil
+			}
+			if !IsRetryable(r.err) {
+				return r.v, r.attempt, started, r.err
+			}
+			last = r
+			if started < attempts {
+				launch(started)
+				started++
+				running++
+				timer.Reset(delay)
+			} else if running == 0 {
+				return last.v, last.attempt, started, last.err
+			}
+		case <-next:
+			launch(started)
+			started++
+			running++
+			timer.Reset(delay)
+		case <-ctx.Done():
+			var zero T
+			return zero, -1, started, ctx.Err()
+		}
+	}
+}
+
+// HedgingUnaryClientInterceptor hedges the listed methods, sending a second
+// attempt after delay; other methods pass through. Each attempt is a
+// separate RPC, so with a round_robin load-balancing policy (and a headless
+// Service or other multi-address target) it lands on a different replica:
+//
+//	conn, err := grpcclient.Dial(ctx, "dns:///productcatalogservice:3550",
+//	    grpcclient.WithDialOptions(grpc.WithDefaultServiceConfig(`{"loadBalancingConfig":[{"round_robin":{}}]}`)),
+//	    grpcclient.WithUnaryInterceptors(shared.HedgingUnaryClientInterceptor(30*time.Millisecond,
+//	        "/hipstershop.ProductCatalogService/GetProduct",
+//	        "/hipstershop.ProductCatalogService/ListProducts")))
+func HedgingUnaryClientInterceptor(delay time.Duration, methods ...string) grpc.UnaryClientInterceptor {
+	hedged := make(map[string]bool, len(methods))
+	for _, m := range methods {
+		hedged[m] = true
+	}
+	return func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		out, ok := reply.(proto.Message)
+		if !hedged[method] || !ok {
+			return invoker(ctx, method, req, reply, cc, opts...)
+		}
+		// Attempts decode into their own messages; only the winner's is
+		// copied into reply, so a losing attempt cannot race with the caller.
+		winner, attempt, started, err := hedge(ctx, delay, DefaultHedgeAttempts, func(ctx context.Context) (proto.Message, error) {
+			r := out.ProtoReflect().New().Interface()
+			return r, invoker(ctx, method, req, r, cc, opts...)
+		})
+		if started > 1 {
+			hedgeAttemptsTotal.WithLabelValues(method).Add(float64(started - 1))
+		}
+		if err != nil {
+			return err
+		}
+		label := "primary"
+		if attempt > 0 {
+			label = "hedge"
+		}
+		hedgeWinsTotal.WithLabelValues(method, label).Inc()
+		proto.Reset(out)
+		proto.Merge(out, winner)
+		return nil
+	}
+}
@@ -0,0 +1,121 @@
+package shared
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+func TestHedgeSlowPrimary(t *testing.T) {
+	var calls atomic.Int32
+	v, err := Hedge(context.Background(), 10*time.Millisecond, func(ctx context.Context) (string, error) {
+		if calls.Add(1) == 1 {
+			<-ctx.Done() // the slow replica; cancelled once the hedge wins
+			return "", ctx.Err()
+		}
+		return "hedge", nil
+	})
+	if err != nil || v != "hedge" {
+		t.Fatalf("Hedge = %q, %v; want hedge, nil", v, err)
+	}
+	if n := calls.Load(); n != 2 {
+		t.Errorf("calls = %d, want 2", n)
+	}
+}
+
+func TestHedgeFastPrimaryIsNotDuplicated(t *testing.T) {
+	var calls atomic.Int32
+	v, err := Hedge(context.Background(), time.Second, func(ctx context.Context) (int, error) {
+		calls.Add(1)
+		return 1, nil
+	})
+	if err != nil || v != 1 || calls.Load() != 1 {
+		t.Errorf("Hedge = %d, %v with %d calls; want 1, nil, 1 call", v, err, calls.Load())
+	}
+}
+
+func TestHedgeFailureStartsNextImmediately(t *testing.T) {
+	var calls atomic.Int32
+	start := time.Now()
+	v, err := Hedge(context.Background(), time.Hour, func(ctx context.Context) (int, error) {
+		if calls.Add(1) == 1 {
+			return 0, errors.New("replica down")
+		}
+		return 2, nil
+	})
+	if err != nil || v != 2 {
+		t.Fatalf("Hedge = %d, %v; want 2, nil", v, err)
+	}
+	if time.Since(start) > time.Second {
+		t.Error("hedge waited for the delay after a failure")
+	}
+
+	boom := errors.New("boom")
+	if _, err := HedgeN(context.Background(), time.Millisecond, 3, func(ctx context.Context) (int, error) {
+		return 0, boom
+	}); !errors.Is(err, boom) {
+		t.Errorf("all attempts failing: err = %v, want %v", err, boom)
+	}
+}
+
+func TestHedgeNonRetryableErrorReturnsAtOnce(t *testing.T) {
+	var calls atomic.Int32
+	canceled := make(chan struct{})
+	notFound := status.Error(codes.NotFound, "no such product")
+	_, err := HedgeN(context.Background(), time.Millisecond, 3, func(ctx context.Context) (int, error) {
+		if calls.Add(1) == 1 {
+			<-ctx.Done() // the slow primary, outlived by the failing hedge
+			close(canceled)
+			return 0, ctx.Err()
+		}
+		return 0, notFound
+	})
+	if !errors.Is(err, notFound) {
+		t.Fatalf("HedgeN err = %v, want %v", err, notFound)
+	}
+	if n := calls.Load(); n != 2 {
+		t.Errorf("calls = %d, want 2: no hedge after a non-retryable error", n)
+	}
+	select {
+	case <-canceled:
+	case <-time.After(time.Second):
+		t.Error("in-flight attempt not cancelled")
+	}
+}
+
+func TestHedgingUnaryClientInterceptor(t *testing.T) {
+	const method = "/hipstershop.ProductCatalogService/GetProduct"
+	intercept := HedgingUnaryClientInterceptor(5*time.Millisecond, method)
+	var calls atomic.Int32
+	invoker := func(ctx context.Context, m string, req, reply any, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+		n := calls.Add(1)
+		if n == 1 {
+			<-ctx.Done()
+			return ctx.Err()
+		}
+		reply.(*wrapperspb.StringValue).Value = "from hedge"
+		return nil
+	}
+
+	reply := &wrapperspb.StringValue{}
+	if err := intercept(context.Background(), method, nil, reply, nil, invoker); err != nil {
+		t.Fatal(err)
+	}
+	if reply.GetValue() != "from hedge" {
+		t.Errorf("reply = %q, want the hedge's response", reply.GetValue())
+	}
+
+	calls.Store(1) // the next call succeeds immediately
+	other := &wrapperspb.StringValue{}
+	intercept(context.Background(), "/hipstershop.CartService/AddItem", nil, other, nil, invoker)
+	if calls.Load() != 2 {
+		t.Errorf("unlisted method made %d extra calls, want 1", calls.Load()-1)
+	}
+}
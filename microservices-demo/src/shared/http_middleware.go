@@ -0,0 +1,455 @@
+package shared
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"errors"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+
+	"github.com/GoogleCloudPlatform/microservices-demo/src/shared/baggage"
+	"github.com/GoogleCloudPlatform/microservices-demo/src/shared/metrics"
+	"github.com/GoogleCloudPlatform/microservices-demo/src/shared/recovery"
+	"github.com/GoogleCloudPlatform/microservices-demo/src/shared/requestid"
+)
+
+var (
+	httpServerRequests = metrics.NewCounterVec("http_server_requests_total",
+		"HTTP requests handled, by server, method and status code.", "server", "method", "code")
+	httpServerDuration = metrics.NewHistogramVec("http_server_request_duration_seconds",
+		"Latency of HTTP requests handled by the server.", nil, "server", "method")
+)
+
+// Middleware wraps an http.Handler.
+type Middleware func(http.Handler) http.Handler
+
+// Chain wraps h in mws, the first being the outermost.
+func Chain(h http.Handler, mws ...Middleware) http.Handler {
+	for i := len(mws) - 1; i >= 0; i-- {
+		h = mws[i](h)
+	}
+	return h
+}
+
+// MiddlewareOption configures DefaultMiddleware.
+type MiddlewareOption func(*middlewareConfig)
+
+type middlewareConfig struct {
+	name           string
+	log            *slog.Logger
+	trustedProxies []netip.Prefix
+	skipLog        map[string]bool
+	csp            string
+	requestID      bool
+	baggage        bool
+	realIP         bool
+	tracing        bool
+	metrics        bool
+	accessLog      bool
+	recovery       bool
+	securityHeader bool
+	gzip           bool
+}
+
+// WithMiddlewareName labels the server's metrics and spans, e.g.
+// "frontend". The default is "http-server".
+func WithMiddlewareName(name string) MiddlewareOption {
+	return func(c *middlewareConfig) { c.name = name }
+}
+
+// WithMiddlewareLogger sets the logger of the access log and recovery
+// layers. The default is slog.Default().
+func WithMiddlewareLogger(l *slog.Logger) MiddlewareOption {
+	return func(c *middlewareConfig) { c.log = l }
+}
+
+// WithTrustedProxies replaces the networks whose X-Forwarded-For headers
+// the real-IP layer believes. The default is the loopback and private
+// ranges a cluster load balancer or ingress connects from.
+func WithTrustedProxies(cidrs ...string) MiddlewareOption {
+	return func(c *middlewareConfig) {
+		c.trustedProxies = c.trustedProxies[:0]
+		for _, s := range cidrs {
+			if p, err := netip.ParsePrefix(s); err == nil {
+				c.trustedProxies = append(c.trustedProxies, p.Masked())
+			} else {
+				slog.Warn("ignoring invalid trusted proxy network", "cidr", s, "error", err)
+			}
+		}
+	}
+}
+
+// WithoutAccessLogFor leaves requests for the given paths, such as health
+// probes, out of the access log. They are still counted in metrics.
+func WithoutAccessLogFor(paths ...string) MiddlewareOption {
+	return func(c *middlewareConfig) {
+		for _, p := range paths {
+			c.skipLog[p] = true
+		}
+	}
+}
+
+// WithContentSecurityPolicy adds a Content-Security-Policy header to every
+// response that does not set one.
+func WithContentSecurityPolicy(policy string) MiddlewareOption {
+	return func(c *middlewareConfig) { c.csp = policy }
+}
+
+// WithoutRequestIDMiddleware disables the request ID layer, for servers
+// behind a proxy that already assigns one.
+func WithoutRequestIDMiddleware() MiddlewareOption {
+	return func(c *middlewareConfig) { c.requestID = false }
+}
+
+// WithoutBaggageMiddleware disables baggage propagation.
+func WithoutBaggageMiddleware() MiddlewareOption {
+	return func(c *middlewareConfig) { c.baggage = false }
+}
+
+// WithoutRealIP disables X-Forwarded-For resolution; ClientIP then reports
+// the peer address.
+func WithoutRealIP() MiddlewareOption {
+	return func(c *middlewareConfig) { c.realIP = false }
+}
+
+// WithoutHTTPServerTracing disables the OpenTelemetry span per request.
+func WithoutHTTPServerTracing() MiddlewareOption {
+	return func(c *middlewareConfig) { c.tracing = false }
+}
+
+// WithoutHTTPServerMetrics disables the http_server_* metrics.
+func WithoutHTTPServerMetrics() MiddlewareOption {
+	return func(c *middlewareConfig) { c.metrics = false }
+}
+
+// WithoutAccessLog disables the access log.
+func WithoutAccessLog() MiddlewareOption {
+	return func(c *middlewareConfig) { c.accessLog = false }
+}
+
+// WithoutRecovery disables panic recovery.
+func WithoutRecovery() MiddlewareOption {
+	return func(c *middlewareConfig) { c.recovery = false }
+}
+
+// WithoutSecurityHeaders disables the security headers, for APIs that are
+// not served to browsers.
+func WithoutSecurityHeaders() MiddlewareOption {
+	return func(c *middlewareConfig) { c.securityHeader = false }
+}
+
+// WithoutGzip disables response compression.
+func WithoutGzip() MiddlewareOption {
+	return func(c *middlewareConfig) { c.gzip = false }
+}
+
+// defaultTrustedProxies are the networks in-cluster proxies connect from.
+var defaultTrustedProxies = []string{"127.0.0.0/8", "10.0.0.0/8", "172.16.0.0/12", "192.168.0.0/16", "::1/128", "fc00::/7"}
+
+// DefaultMiddleware returns the standard HTTP server stack, outermost first:
+//
+//	request ID → baggage → real IP → tracing → metrics → access log →
+//	recovery → security headers → gzip → handler
+//
+// The request ID and client IP are resolved first so every later layer can
+// log them; tracing encloses metrics and logging so their work is part of
+// the span and log lines carry the trace ID; recovery sits inside metrics
+// and logging so a recovered panic is still counted and logged as a 500,
+// like in grpcserver; gzip is innermost, next to the handler, so the
+// access log records the bytes actually sent. Each layer can be disabled
+// with its Without option.
+//
+// Usage:
+//
+//	mw := shared.DefaultMiddleware(
+//	    shared.WithMiddlewareName("frontend"),
+//	    shared.WithMiddlewareLogger(log),
+//	    shared.WithoutAccessLogFor("/_healthz"))
+//	srv := shared.NewHTTPServer(mw(mux), shared.WithHTTPAddr(":"+port))
+func DefaultMiddleware(opts ...MiddlewareOption) Middleware {
+	c := middlewareConfig{
+		name: "http-server", log: slog.Default(), skipLog: make(map[string]bool),
+		requestID: true, baggage: true, realIP: true, tracing: true, metrics: true,
+		accessLog: true, recovery: true, securityHeader: true, gzip: true,
+	}
+	WithTrustedProxies(defaultTrustedProxies...)(&c)
+	for _, opt := range opts {
+		opt(&c)
+	}
+
+	var mws []Middleware
+	if c.requestID {
+		mws = append(mws, requestid.Middleware)
+	}
+	if c.baggage {
+		mws = append(mws, baggage.Middleware)
+	}
+	if c.realIP {
+		mws = append(mws, realIPMiddleware(c.trustedProxies))
+	}
+	if c.tracing {
+		mws = append(mws, func(next http.Handler) http.Handler { return otelhttp.NewHandler(next, c.name) })
+	}
+	if c.metrics {
+		mws = append(mws, httpMetricsMiddleware(c.name))
+	}
+	if c.accessLog {
+		mws = append(mws, accessLogMiddleware(c.log, c.skipLog))
+	}
+	if c.recovery {
+		mws = append(mws, func(next http.Handler) http.Handler { return recovery.Middleware(c.log, next) })
+	}
+	if c.securityHeader {
+		mws = append(mws, securityHeadersMiddleware(c.csp))
+	}
+	if c.gzip {
+		mws = append(mws, GzipMiddleware)
+	}
+	return func(h http.Handler) http.Handler { return Chain(h, mws...) }
+}
+
+type clientIPKey struct{}
+
+// ClientIP returns the address of the client that made r: the one found by
+// DefaultMiddleware's real-IP layer, or else the host of r.RemoteAddr.
+func ClientIP(r *http.Request) string {
+	if ip, ok := r.Context().Value(clientIPKey{}).(string); ok {
+		return ip
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// realIPMiddleware resolves the client address from X-Forwarded-For when
+// the request comes through trusted proxies: the rightmost address not
+// itself a trusted proxy, since entries to its left can be forged by the
+// client.
+func realIPMiddleware(trusted []netip.Prefix) Middleware {
+	isTrusted := func(a netip.Addr) bool {
+		a = a.Unmap()
+		for _, p := range trusted {
+			if p.Contains(a) {
+				return true
+			}
+		}
+		return false
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ip := ClientIP(r)
+			if addr, err := netip.ParseAddr(ip); err == nil && isTrusted(addr) {
+				hops := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+				for i := len(hops) - 1; i >= 0; i-- {
+					hop, err := netip.ParseAddr(strings.TrimSpace(hops[i]))
+					if err != nil {
+						break
+					}
+					ip = hop.Unmap().String()
+					if !isTrusted(hop) {
+						break
+					}
+				}
+			}
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), clientIPKey{}, ip)))
+		})
+	}
+}
+
+func httpMetricsMiddleware(name string) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+			next.ServeHTTP(sw, r)
+			httpServerRequests.WithLabelValues(name, r.Method, strconv.Itoa(sw.status)).Inc()
+			metrics.Observe(r.Context(), httpServerDuration.WithLabelValues(name, r.Method), time.Since(start).Seconds())
+		})
+	}
+}
+
+// accessLogMiddleware logs one line per request; server errors at warn.
+func accessLogMiddleware(log *slog.Logger, skip map[string]bool) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if skip[r.URL.Path] {
+				next.ServeHTTP(w, r)
+				return
+			}
+			start := time.Now()
+			sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+			next.ServeHTTP(sw, r)
+			level := slog.LevelInfo
+			if sw.status >= 500 {
+				level = slog.LevelWarn
+			}
+			log.LogAttrs(r.Context(), level, "http access",
+				slog.String("method", r.Method),
+				slog.String("path", Redact(r.URL.Path)),
+				slog.Int("status", sw.status),
+				slog.Int64("bytes", sw.bytes),
+				slog.Duration("duration", time.Since(start)),
+				slog.String("client_ip", ClientIP(r)),
+				slog.String("user_agent", r.UserAgent()))
+		})
+	}
+}
+
+// securityHeadersMiddleware sets the headers every browser-facing response
+// should carry, unless the handler overrides them.
+func securityHeadersMiddleware(csp string) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			h := w.Header()
+			h.Set("X-Content-Type-Options", "nosniff")
+			h.Set("X-Frame-Options", "DENY")
+			h.Set("Referrer-Policy", "strict-origin-when-cross-origin")
+			if r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https" {
+				h.Set("Strict-Transport-Security", "max-age=63072000; includeSubDomains")
+			}
+			if csp != "" {
+				h.Set("Content-Security-Policy", csp)
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+var gzipWriters = sync.Pool{New: func() any { return gzip.NewWriter(nil) }}
+
+// GzipMiddleware compresses responses for clients that accept gzip, unless
+// the handler already set a Content-Encoding or the content type is one
+// that is compressed already, such as images and archives.
+func GzipMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Accept-Encoding")
+		if !acceptsEncoding(r, "gzip") || r.Method == http.MethodHead || r.Header.Get("Range") != "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		gw := &gzipWriter{ResponseWriter: w}
+		defer gw.close()
+		next.ServeHTTP(gw, r)
+	})
+}
+
+// acceptsEncoding reports whether r's Accept-Encoding allows coding, such
+// as "gzip" or "br".
+func acceptsEncoding(r *http.Request, coding string) bool {
+	for _, enc := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		name, q, _ := strings.Cut(strings.TrimSpace(enc), ";")
+		if strings.EqualFold(strings.TrimSpace(name), coding) {
+			return strings.ReplaceAll(strings.TrimSpace(q), " ", "") != "q=0"
+		}
+	}
+	return false
+}
+
+// gzipWriter decides on the first write whether to compress.
+type gzipWriter struct {
+	http.ResponseWriter
+	gz          *gzip.Writer
+	decided     bool
+	wroteHeader bool
+	status      int
+}
+
+func (w *gzipWriter) WriteHeader(code int) {
+	if w.wroteHeader {
+		return
+	}
+	w.wroteHeader = true
+	w.status = code
+	// Wait for the first write, which may be needed to sniff the type,
+	// unless the response has no body.
+	if code == http.StatusNoContent || code == http.StatusNotModified || code < 200 {
+		w.decided = true
+		w.ResponseWriter.WriteHeader(code)
+	}
+}
+
+func (w *gzipWriter) Write(p []byte) (int, error) {
+	if !w.decided {
+		w.decide(p)
+	}
+	if w.gz != nil {
+		return w.gz.Write(p)
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+func (w *gzipWriter) decide(p []byte) {
+	w.decided = true
+	h := w.Header()
+	if h.Get("Content-Type") == "" {
+		h.Set("Content-Type", http.DetectContentType(p))
+	}
+	if h.Get("Content-Encoding") == "" && compressible(h.Get("Content-Type")) {
+		h.Set("Content-Encoding", "gzip")
+		h.Del("Content-Length")
+		w.gz = gzipWriters.Get().(*gzip.Writer)
+		w.gz.Reset(w.ResponseWriter)
+	}
+	if !w.wroteHeader {
+		w.wroteHeader, w.status = true, http.StatusOK
+	}
+	w.ResponseWriter.WriteHeader(w.status)
+}
+
+func compressible(contentType string) bool {
+	for _, prefix := range []string{"image/", "video/", "audio/", "font/woff", "application/zip", "application/gzip", "application/x-gzip"} {
+		if strings.HasPrefix(contentType, prefix) && contentType != "image/svg+xml" {
+			return false
+		}
+	}
+	return true
+}
+
+func (w *gzipWriter) Flush() {
+	if !w.decided {
+		w.decide(nil)
+	}
+	if w.gz != nil {
+		w.gz.Flush()
+	}
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Hijack hands over the connection, e.g. for websockets, which are never
+// compressed.
+func (w *gzipWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if w.gz != nil {
+		return nil, nil, errors.New("gzip: connection cannot be hijacked after the response started")
+	}
+	w.decided = true
+	return http.NewResponseController(w.ResponseWriter).Hijack()
+}
+
+func (w *gzipWriter) Unwrap() http.ResponseWriter { return w.ResponseWriter }
+
+func (w *gzipWriter) close() {
+	if !w.decided && w.wroteHeader {
+		// A header without a body.
+		w.decided = true
+		w.ResponseWriter.WriteHeader(w.status)
+	}
+	if w.gz != nil {
+		w.gz.Close()
+		w.gz.Reset(nil)
+		gzipWriters.Put(w.gz)
+		w.gz = nil
+	}
+}
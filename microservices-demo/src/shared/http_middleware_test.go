@@ -0,0 +1,161 @@
+package shared
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/GoogleCloudPlatform/microservices-demo/src/shared/requestid"
+)
+
+func TestChainOrder(t *testing.T) {
+	var order []string
+	layer := func(name string) Middleware {
+		return func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				order = append(order, name)
+				next.ServeHTTP(w, r)
+			})
+		}
+	}
+	h := Chain(http.HandlerFunc(func(http.ResponseWriter, *http.Request) { order = append(order, "handler") }),
+		layer("outer"), layer("inner"))
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	if got := strings.Join(order, ","); got != "outer,inner,handler" {
+		t.Errorf("order = %s", got)
+	}
+}
+
+func TestDefaultMiddleware(t *testing.T) {
+	var buf bytes.Buffer
+	mw := DefaultMiddleware(WithMiddlewareName("test"), WithMiddlewareLogger(slog.New(slog.NewJSONHandler(&buf, nil))),
+		WithoutHTTPServerTracing())
+	var gotIP, gotID string
+	h := mw(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotIP, gotID = ClientIP(r), requestid.FromContext(r.Context())
+		w.Header().Set("Content-Type", "text/html")
+		io.WriteString(w, strings.Repeat("<p>hello</p>", 100))
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "/home", nil)
+	req.RemoteAddr = "10.4.0.2:5555"
+	req.Header.Set("X-Forwarded-For", "1.2.3.4, 203.0.113.9, 10.4.0.1")
+	req.Header.Set("Accept-Encoding", "gzip, deflate")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+
+	// The rightmost untrusted hop is the client; 1.2.3.4 may be forged.
+	if gotIP != "203.0.113.9" {
+		t.Errorf("ClientIP = %q, want 203.0.113.9", gotIP)
+	}
+	if gotID == "" || rec.Header().Get(requestid.Header) != gotID {
+		t.Errorf("request ID %q not echoed, got %q", gotID, rec.Header().Get(requestid.Header))
+	}
+	if rec.Header().Get("X-Content-Type-Options") != "nosniff" || rec.Header().Get("X-Frame-Options") != "DENY" {
+		t.Errorf("security headers missing: %v", rec.Header())
+	}
+	if rec.Header().Get("Content-Encoding") != "gzip" {
+		t.Fatalf("response not compressed: %v", rec.Header())
+	}
+	zr, err := gzip.NewReader(rec.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(zr)
+	if !strings.HasPrefix(string(body), "<p>hello</p>") || len(body) != 1200 {
+		t.Errorf("decompressed body = %q", body)
+	}
+
+	var entry map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+		t.Fatalf("decoding access log %q: %v", buf.String(), err)
+	}
+	if entry["path"] != "/home" || entry["status"] != float64(200) || entry["client_ip"] != "203.0.113.9" {
+		t.Errorf("access log = %v", entry)
+	}
+	if n, _ := entry["bytes"].(float64); n == 0 || n >= 1200 {
+		t.Errorf("access log bytes = %v, want the compressed size", entry["bytes"])
+	}
+}
+
+func TestDefaultMiddlewareRecoversAndDisables(t *testing.T) {
+	var buf bytes.Buffer
+	mw := DefaultMiddleware(WithMiddlewareLogger(slog.New(slog.NewJSONHandler(&buf, nil))),
+		WithoutHTTPServerTracing(), WithoutGzip(), WithoutSecurityHeaders(), WithoutRealIP())
+	h := mw(http.HandlerFunc(func(http.ResponseWriter, *http.Request) { panic("boom") }))
+
+	req := httptest.NewRequest(http.MethodGet, "/panic", nil)
+	req.RemoteAddr = "10.4.0.2:5555"
+	req.Header.Set("X-Forwarded-For", "203.0.113.9")
+	req.Header.Set("Accept-Encoding", "gzip")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusInternalServerError {
+		t.Errorf("status = %d, want 500", rec.Code)
+	}
+	if rec.Header().Get("Content-Encoding") != "" || rec.Header().Get("X-Frame-Options") != "" {
+		t.Errorf("disabled layers still ran: %v", rec.Header())
+	}
+	if !strings.Contains(buf.String(), `"status":500`) || !strings.Contains(buf.String(), `"client_ip":"10.4.0.2"`) {
+		t.Errorf("access log does not record the recovered panic from the peer address: %s", buf.String())
+	}
+}
+
+func TestGzipMiddlewareSkips(t *testing.T) {
+	png := []byte("\x89PNG\r\n\x1a\n" + strings.Repeat("x", 100))
+	for name, tt := range map[string]struct {
+		accept string
+		body   []byte
+		status int
+	}{
+		"no accept-encoding": {"", []byte("hello"), http.StatusOK},
+		"image":              {"gzip", png, http.StatusOK},
+		"not modified":       {"gzip", nil, http.StatusNotModified},
+		"q=0":                {"gzip;q=0", []byte("hello"), http.StatusOK},
+	} {
+		h := GzipMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(tt.status)
+			w.Write(tt.body)
+		}))
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		if tt.accept != "" {
+			req.Header.Set("Accept-Encoding", tt.accept)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if rec.Header().Get("Content-Encoding") != "" || rec.Code != tt.status || !bytes.Equal(rec.Body.Bytes(), tt.body) {
+			t.Errorf("%s: got %d %v %q", name, rec.Code, rec.Header(), rec.Body.Bytes())
+		}
+	}
+}
+
+func TestStatusWriterHijack(t *testing.T) {
+	var sw *statusWriter
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sw = &statusWriter{ResponseWriter: w, status: http.StatusOK}
+		conn, _, err := http.NewResponseController(sw).Hijack()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		conn.Write([]byte("HTTP/1.1 101 Switching Protocols\r\n\r\n"))
+		conn.Close()
+	}))
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if sw.status != http.StatusSwitchingProtocols {
+		t.Errorf("recorded status = %d, want 101", sw.status)
+	}
+}
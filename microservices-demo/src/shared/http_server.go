@@ -0,0 +1,158 @@
+package shared
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/GoogleCloudPlatform/microservices-demo/src/shared/health"
+	"github.com/GoogleCloudPlatform/microservices-demo/src/shared/internal/env"
+)
+
+// Defaults applied by NewHTTPServer.
+const (
+	DefaultHTTPReadHeaderTimeout = 10 * time.Second
+	DefaultHTTPReadTimeout       = 30 * time.Second
+	DefaultHTTPWriteTimeout      = 30 * time.Second
+	DefaultHTTPIdleTimeout       = 2 * time.Minute
+	DefaultHTTPMaxHeaderBytes    = 1 << 20
+	DefaultHTTPDrainTimeout      = 20 * time.Second
+)
+
+// ErrDraining is reported by HTTPServer.ReadinessCheck once shutdown has begun.
+var ErrDraining = errors.New("server is draining")
+
+// HTTPServer is an *http.Server with production timeouts and a two-phase
+// graceful shutdown: first report not-ready while traffic is still served,
+// then stop accepting connections and drain in-flight requests.
+type HTTPServer struct {
+	*http.Server
+
+	drainDelay   time.Duration
+	drainTimeout time.Duration
+	draining     atomic.Bool
+}
+
+// HTTPServerOption configures NewHTTPServer.
+type HTTPServerOption func(*HTTPServer)
+
+// WithHTTPAddr sets the listen address used by ListenAndServe.
+func WithHTTPAddr(addr string) HTTPServerOption {
+	return func(s *HTTPServer) { s.Addr = addr }
+}
+
+// WithHTTPTimeouts overrides the read, write and idle timeouts. Zero values
+// keep the defaults.
+func WithHTTPTimeouts(read, write, idle time.Duration) HTTPServerOption {
+	return func(s *HTTPServer) {
+		if read > 0 {
+			s.ReadTimeout = read
+		}
+		if write > 0 {
+			s.WriteTimeout = write
+		}
+		if idle > 0 {
+			s.IdleTimeout = idle
+		}
+	}
+}
+
+// WithHTTPMaxHeaderBytes overrides the maximum request header size.
+func WithHTTPMaxHeaderBytes(n int) HTTPServerOption {
+	return func(s *HTTPServer) { s.MaxHeaderBytes = n }
+}
+
+// WithDrainTimeout bounds how long Shutdown waits for in-flight requests.
+func WithDrainTimeout(d time.Duration) HTTPServerOption {
+	return func(s *HTTPServer) { s.drainTimeout = d }
+}
+
+// WithDrainDelay overrides HTTP_DRAIN_DELAY: how long Shutdown keeps serving
+// while reporting not-ready before it stops accepting connections.
+func WithDrainDelay(d time.Duration) HTTPServerOption {
+	return func(s *HTTPServer) { s.drainDelay = d }
+}
+
+// NewHTTPServer returns a server for handler with read-header, read, write and
+// idle timeouts and a header size limit set, so a slow or malicious client
+// cannot hold connections open indefinitely.
+//
+// Set a drain delay (HTTP_DRAIN_DELAY, e.g. "5s") a little longer than the
+// readiness probe period and wire ReadinessCheck into the readiness probe:
+// on SIGTERM the pod then fails readiness and is removed from the Service
+// endpoints while it still answers requests, so a rollout drops none.
+//
+// Usage:
+//
+//	srv := shared.NewHTTPServer(mux, shared.WithHTTPAddr(":"+port))
+//	hr.Register("http-server", srv.ReadinessCheck())
+//	srv.RegisterShutdown(sm)
+//	go srv.ListenAndServe()
+//	sm.Wait()
+func NewHTTPServer(handler http.Handler, opts ...HTTPServerOption) *HTTPServer {
+	s := &HTTPServer{
+		Server: &http.Server{
+			Handler:           handler,
+			ReadHeaderTimeout: DefaultHTTPReadHeaderTimeout,
+			ReadTimeout:       DefaultHTTPReadTimeout,
+			WriteTimeout:      DefaultHTTPWriteTimeout,
+			IdleTimeout:       DefaultHTTPIdleTimeout,
+			MaxHeaderBytes:    DefaultHTTPMaxHeaderBytes,
+		},
+		drainDelay:   env.Duration("HTTP_DRAIN_DELAY", 0),
+		drainTimeout: DefaultHTTPDrainTimeout,
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// Draining reports whether Shutdown has been called.
+func (s *HTTPServer) Draining() bool {
+	return s.draining.Load()
+}
+
+// ReadinessCheck returns a health check that fails once Shutdown has begun.
+func (s *HTTPServer) ReadinessCheck() health.Checker {
+	return func(context.Context) error {
+		if s.Draining() {
+			return ErrDraining
+		}
+		return nil
+	}
+}
+
+// Shutdown marks the server as draining, keeps serving for the drain delay,
+// then stops accepting connections and waits up to the drain timeout (or until
+// ctx is done) for in-flight requests. Connections still open after that are
+// closed.
+func (s *HTTPServer) Shutdown(ctx context.Context) error {
+	s.draining.Store(true)
+	if s.drainDelay > 0 {
+		log.Printf("HTTP: Draining, waiting %v before closing listeners", s.drainDelay)
+		t := time.NewTimer(s.drainDelay)
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			t.Stop()
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, s.drainTimeout)
+	defer cancel()
+	if err := s.Server.Shutdown(ctx); err != nil {
+		s.Server.Close()
+		return err
+	}
+	return nil
+}
+
+// RegisterShutdown registers Shutdown with m under a timeout covering the
+// drain delay and drain timeout.
+func (s *HTTPServer) RegisterShutdown(m *ShutdownManager) {
+	m.RegisterWithTimeout("http-server", s.drainDelay+s.drainTimeout+time.Second, s.Shutdown)
+}
@@ -0,0 +1,62 @@
+package shared
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestNewHTTPServerDefaults(t *testing.T) {
+	s := NewHTTPServer(http.NotFoundHandler(), WithHTTPTimeouts(0, time.Minute, 0))
+	if s.ReadHeaderTimeout != DefaultHTTPReadHeaderTimeout || s.ReadTimeout != DefaultHTTPReadTimeout {
+		t.Errorf("read timeouts = %v/%v, want defaults", s.ReadHeaderTimeout, s.ReadTimeout)
+	}
+	if s.WriteTimeout != time.Minute {
+		t.Errorf("WriteTimeout = %v, want 1m", s.WriteTimeout)
+	}
+	if s.MaxHeaderBytes != DefaultHTTPMaxHeaderBytes {
+		t.Errorf("MaxHeaderBytes = %d", s.MaxHeaderBytes)
+	}
+}
+
+func TestHTTPServerDrainsInFlightRequests(t *testing.T) {
+	started := make(chan struct{})
+	s := NewHTTPServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		time.Sleep(50 * time.Millisecond)
+		w.Write([]byte("done"))
+	}), WithDrainDelay(20*time.Millisecond), WithDrainTimeout(time.Second))
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.Serve(lis)
+
+	errc := make(chan error, 1)
+	go func() {
+		resp, err := http.Get("http://" + lis.Addr().String())
+		if err == nil {
+			resp.Body.Close()
+		}
+		errc <- err
+	}()
+	<-started
+
+	check := s.ReadinessCheck()
+	if err := check(context.Background()); err != nil {
+		t.Errorf("ready before shutdown: %v", err)
+	}
+	if err := s.Shutdown(context.Background()); err != nil {
+		t.Fatalf("Shutdown: %v", err)
+	}
+	if err := check(context.Background()); !errors.Is(err, ErrDraining) {
+		t.Errorf("readiness after shutdown = %v, want ErrDraining", err)
+	}
+	if err := <-errc; err != nil {
+		t.Errorf("in-flight request failed: %v", err)
+	}
+}
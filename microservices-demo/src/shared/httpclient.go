@@ -0,0 +1,298 @@
+package shared
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+
+	"github.com/GoogleCloudPlatform/microservices-demo/src/shared/baggage"
+	"github.com/GoogleCloudPlatform/microservices-demo/src/shared/metrics"
+	"github.com/GoogleCloudPlatform/microservices-demo/src/shared/requestid"
+)
+
+// Defaults applied by NewHTTPClient.
+const (
+	DefaultHTTPClientTimeout    = 30 * time.Second
+	DefaultHTTPAttemptTimeout   = 10 * time.Second
+	DefaultHTTPMaxIdleConns     = 100
+	DefaultHTTPMaxIdlePerHost   = 16
+	DefaultHTTPIdleConnTimeout  = 90 * time.Second
+	DefaultHTTPTLSHandshakeTime = 10 * time.Second
+)
+
+var (
+	httpClientRequests = metrics.NewCounterVec("http_client_requests_total",
+		"Outbound HTTP requests, by client, method and status code (\"error\" if no response).",
+		"client", "method", "code")
+	httpClientDuration = metrics.NewHistogramVec("http_client_request_duration_seconds",
+		"Latency of outbound HTTP requests, including retries.", nil, "client", "method")
+	httpClientRetries = metrics.NewCounterVec("http_client_retries_total",
+		"Outbound HTTP attempts retried after a transport error or retryable status.",
+		"client", "method")
+)
+
+// HTTPClientOption configures NewHTTPClient.
+type HTTPClientOption func(*httpClientConfig)
+
+type httpClientConfig struct {
+	name           string
+	timeout        time.Duration
+	attemptTimeout time.Duration
+	retry          *RetryPolicy
+	base           http.RoundTripper
+	maxIdlePerHost int
+	tracing        bool
+}
+
+// WithHTTPClientName labels the client's metrics, e.g. "payment-gateway".
+// The default is "default".
+func WithHTTPClientName(name string) HTTPClientOption {
+	return func(c *httpClientConfig) { c.name = name }
+}
+
+// WithHTTPClientTimeout bounds a whole request, retries and reading the body
+// included (default DefaultHTTPClientTimeout). Zero means no bound.
+func WithHTTPClientTimeout(d time.Duration) HTTPClientOption {
+	return func(c *httpClientConfig) { c.timeout = d }
+}
+
+// WithHTTPAttemptTimeout bounds each attempt (default
+// DefaultHTTPAttemptTimeout), so a hung upstream fails one attempt rather
+// than the whole request. Zero means no per-attempt bound.
+func WithHTTPAttemptTimeout(d time.Duration) HTTPClientOption {
+	return func(c *httpClientConfig) { c.attemptTimeout = d }
+}
+
+// WithHTTPRetry replaces DefaultRetryPolicy. The policy's Retryable is
+// ignored; see NewHTTPClient for what is retried.
+func WithHTTPRetry(policy RetryPolicy) HTTPClientOption {
+	return func(c *httpClientConfig) { c.retry = &policy }
+}
+
+// WithoutHTTPRetry makes every request a single attempt, for callers that
+// retry at a higher level.
+func WithoutHTTPRetry() HTTPClientOption {
+	return func(c *httpClientConfig) { c.retry = nil }
+}
+
+// WithHTTPTransport sends requests through base instead of the tuned
+// *http.Transport NewHTTPClient builds, e.g. a circuitbreaker.Transport or
+// an httptest server's transport.
+func WithHTTPTransport(base http.RoundTripper) HTTPClientOption {
+	return func(c *httpClientConfig) { c.base = base }
+}
+
+// WithHTTPMaxIdleConnsPerHost sets how many keep-alive connections are kept
+// per host (default DefaultHTTPMaxIdlePerHost; net/http's default is 2,
+// which forces new connections under any real load). It has no effect with
+// WithHTTPTransport.
+func WithHTTPMaxIdleConnsPerHost(n int) HTTPClientOption {
+	return func(c *httpClientConfig) { c.maxIdlePerHost = n }
+}
+
+// WithoutHTTPTracing skips the OpenTelemetry span and trace context headers,
+// e.g. for an upstream that rejects unknown headers.
+func WithoutHTTPTracing() HTTPClientOption {
+	return func(c *httpClientConfig) { c.tracing = false }
+}
+
+// NewHTTPClient returns an *http.Client for outbound calls to external
+// services such as the payment gateway or shipping API, instead of
+// http.DefaultClient, which has no timeout.
+//
+// The client:
+//   - starts a client span per request and propagates the trace context,
+//     the request ID and baggage;
+//   - bounds each request with DefaultHTTPClientTimeout and each attempt
+//     with DefaultHTTPAttemptTimeout;
+//   - retries with DefaultRetryPolicy after transport errors and 429 or 5xx
+//     responses other than 501, but only for idempotent methods (GET, HEAD,
+//     OPTIONS, TRACE, PUT, DELETE) or requests with an Idempotency-Key
+//     header, and only if the body can be replayed (http.NewRequest sets
+//     GetBody for bytes and strings readers). When every attempt gets a
+//     retryable status, the last response is returned;
+//   - keeps up to DefaultHTTPMaxIdlePerHost idle connections per host;
+//   - records http_client_requests_total, http_client_request_duration_seconds
+//     and http_client_retries_total, labelled with the client name.
+//
+// Usage:
+//
+//	client := shared.NewHTTPClient(
+//	    shared.WithHTTPClientName("payment-gateway"),
+//	    shared.WithHTTPAttemptTimeout(3*time.Second))
+//	req, _ := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+//	req.Header.Set("Idempotency-Key", orderID)
+//	resp, err := client.Do(req)
+func NewHTTPClient(opts ...HTTPClientOption) *http.Client {
+	policy := DefaultRetryPolicy
+	cfg := httpClientConfig{
+		name:           "default",
+		timeout:        DefaultHTTPClientTimeout,
+		attemptTimeout: DefaultHTTPAttemptTimeout,
+		retry:          &policy,
+		maxIdlePerHost: DefaultHTTPMaxIdlePerHost,
+		tracing:        true,
+	}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	base := cfg.base
+	if base == nil {
+		base = newHTTPTransport(cfg.maxIdlePerHost)
+	}
+	if cfg.attemptTimeout > 0 {
+		base = attemptTimeoutTransport{next: base, timeout: cfg.attemptTimeout}
+	}
+	var rt http.RoundTripper = &retryTransport{next: base, name: cfg.name, policy: cfg.retry}
+	rt = baggage.Transport(requestid.Transport(rt))
+	if cfg.tracing {
+		rt = otelhttp.NewTransport(rt)
+	}
+	return &http.Client{Transport: rt, Timeout: cfg.timeout}
+}
+
+// newHTTPTransport returns http.DefaultTransport's settings with a larger
+// idle pool.
+func newHTTPTransport(maxIdlePerHost int) *http.Transport {
+	return &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: (&net.Dialer{
+			Timeout:   5 * time.Second,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		ForceAttemptHTTP2:     true,
+		MaxIdleConns:          DefaultHTTPMaxIdleConns,
+		MaxIdleConnsPerHost:   maxIdlePerHost,
+		IdleConnTimeout:       DefaultHTTPIdleConnTimeout,
+		TLSHandshakeTimeout:   DefaultHTTPTLSHandshakeTime,
+		ExpectContinueTimeout: time.Second,
+	}
+}
+
+// attemptTimeoutTransport gives each attempt its own deadline, which also
+// covers reading the response body; the deadline is released when the body
+// is closed.
+type attemptTimeoutTransport struct {
+	next    http.RoundTripper
+	timeout time.Duration
+}
+
+func (t attemptTimeoutTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	ctx, cancel := context.WithTimeout(r.Context(), t.timeout)
+	resp, err := t.next.RoundTrip(r.WithContext(ctx))
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	resp.Body = &cancelBody{ReadCloser: resp.Body, cancel: cancel}
+	return resp, nil
+}
+
+type cancelBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *cancelBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
+
+// retryTransport retries failed attempts and records the metrics of each
+// request.
+type retryTransport struct {
+	next   http.RoundTripper
+	name   string
+	policy *RetryPolicy
+}
+
+// errRetryableStatus marks an attempt that got a response worth retrying.
+var errRetryableStatus = errors.New("retryable status")
+
+func (t *retryTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	start := time.Now()
+	resp, err := t.roundTrip(r)
+	code := "error"
+	if err == nil {
+		code = strconv.Itoa(resp.StatusCode)
+	}
+	httpClientRequests.WithLabelValues(t.name, r.Method, code).Inc()
+	metrics.Observe(r.Context(), httpClientDuration.WithLabelValues(t.name, r.Method), time.Since(start).Seconds())
+	return resp, err
+}
+
+func (t *retryTransport) roundTrip(r *http.Request) (*http.Response, error) {
+	if t.policy == nil || !replayable(r) {
+		return t.next.RoundTrip(r)
+	}
+	policy := *t.policy
+	ctx := r.Context()
+	// Attempt timeouts surface as DeadlineExceeded, so judge by the
+	// request's own context rather than the error.
+	policy.Retryable = func(error) bool { return ctx.Err() == nil }
+	policy.OnRetry = func(int, time.Duration, error) {
+		httpClientRetries.WithLabelValues(t.name, r.Method).Inc()
+	}
+
+	var resp *http.Response
+	attempts := 0
+	err := Retry(ctx, policy, func(ctx context.Context) error {
+		req := r
+		if attempts > 0 {
+			if resp != nil {
+				// Drain so the connection can be reused.
+				io.Copy(io.Discard, io.LimitReader(resp.Body, 4<<10))
+				resp.Body.Close()
+				resp = nil
+			}
+			req = r.Clone(ctx)
+			if r.GetBody != nil {
+				body, err := r.GetBody()
+				if err != nil {
+					return Permanent(fmt.Errorf("httpclient: rewinding request body: %w", err))
+				}
+				req.Body = body
+			}
+		}
+		attempts++
+		res, err := t.next.RoundTrip(req)
+		if err != nil {
+			return err
+		}
+		resp = res
+		if retryableStatus(res.StatusCode) {
+			return errRetryableStatus
+		}
+		return nil
+	})
+	if resp != nil {
+		return resp, nil
+	}
+	return nil, err
+}
+
+// replayable reports whether r may be sent again: its method is idempotent
+// or it carries an Idempotency-Key, and its body, if any, can be rewound.
+func replayable(r *http.Request) bool {
+	if r.Body != nil && r.Body != http.NoBody && r.GetBody == nil {
+		return false
+	}
+	switch r.Method {
+	case "", http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return r.Header.Get("Idempotency-Key") != ""
+}
+
+func retryableStatus(code int) bool {
+	return code == http.StatusTooManyRequests || (code >= 500 && code != http.StatusNotImplemented)
+}
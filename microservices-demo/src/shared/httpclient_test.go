@@ -0,0 +1,145 @@
+package shared
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/GoogleCloudPlatform/microservices-demo/src/shared/requestid"
+)
+
+func TestHTTPClientRetriesServerErrors(t *testing.T) {
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if body, _ := io.ReadAll(r.Body); string(body) != "quote" {
+			t.Errorf("attempt %d body = %q, want the replayed body", calls.Load()+1, body)
+		}
+		if calls.Add(1) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte("ok"))
+	}))
+	defer srv.Close()
+
+	client := NewHTTPClient(WithHTTPRetry(fastRetry), WithoutHTTPTracing())
+	req, _ := http.NewRequest(http.MethodPut, srv.URL, strings.NewReader("quote"))
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || calls.Load() != 3 {
+		t.Errorf("got %s after %d calls, want 200 after 3", resp.Status, calls.Load())
+	}
+}
+
+func TestHTTPClientReturnsLastRetryableResponse(t *testing.T) {
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		http.Error(w, "busy", http.StatusTooManyRequests)
+	}))
+	defer srv.Close()
+
+	resp, err := NewHTTPClient(WithHTTPRetry(fastRetry)).Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusTooManyRequests || calls.Load() != 3 {
+		t.Errorf("got %s after %d calls, want 429 after 3", resp.Status, calls.Load())
+	}
+}
+
+func TestHTTPClientDoesNotRetryUnsafeRequests(t *testing.T) {
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer srv.Close()
+	client := NewHTTPClient(WithHTTPRetry(fastRetry))
+
+	resp, err := client.Post(srv.URL, "text/plain", strings.NewReader("charge"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if calls.Load() != 1 {
+		t.Errorf("POST without Idempotency-Key sent %d times, want 1", calls.Load())
+	}
+
+	calls.Store(0)
+	req, _ := http.NewRequest(http.MethodPost, srv.URL, strings.NewReader("charge"))
+	req.Header.Set("Idempotency-Key", "order-1")
+	resp, err = client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if calls.Load() != 3 {
+		t.Errorf("POST with Idempotency-Key sent %d times, want 3", calls.Load())
+	}
+}
+
+func TestHTTPClientAttemptTimeout(t *testing.T) {
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) == 1 {
+			<-r.Context().Done()
+			return
+		}
+		w.Write([]byte("ok"))
+	}))
+	defer srv.Close()
+
+	client := NewHTTPClient(WithHTTPRetry(fastRetry), WithHTTPAttemptTimeout(50*time.Millisecond))
+	resp, err := client.Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if body, err := io.ReadAll(resp.Body); err != nil || string(body) != "ok" {
+		t.Errorf("body = %q, %v; want ok from the second attempt", body, err)
+	}
+}
+
+func TestHTTPClientStopsWhenContextEnds(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}))
+	defer srv.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL, nil)
+	_, err := NewHTTPClient(WithHTTPRetry(RetryPolicy{MaxAttempts: 10, InitialBackoff: time.Millisecond})).Do(req)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Do = %v, want DeadlineExceeded", err)
+	}
+}
+
+func TestHTTPClientPropagatesRequestID(t *testing.T) {
+	got := make(chan string, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got <- r.Header.Get(requestid.Header)
+	}))
+	defer srv.Close()
+
+	req, _ := http.NewRequestWithContext(requestid.NewContext(context.Background(), "req-1"), http.MethodGet, srv.URL, nil)
+	resp, err := NewHTTPClient().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if id := <-got; id != "req-1" {
+		t.Errorf("%s = %q, want req-1", requestid.Header, id)
+	}
+}
@@ -0,0 +1,139 @@
+package i18n
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// parseJSON flattens a JSON object of strings and nested objects into
+// dotted keys.
+func parseJSON(data []byte) (map[string]string, error) {
+	var tree map[string]any
+	if err := json.Unmarshal(data, &tree); err != nil {
+		return nil, err
+	}
+	messages := make(map[string]string)
+	if err := flatten("", tree, messages); err != nil {
+		return nil, err
+	}
+	return messages, nil
+}
+
+func flatten(prefix string, tree map[string]any, out map[string]string) error {
+	for k, v := range tree {
+		key := prefix + k
+		switch v := v.(type) {
+		case string:
+			out[key] = v
+		case map[string]any:
+			if err := flatten(key+".", v, out); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("%s: messages must be strings or objects, got %T", key, v)
+		}
+	}
+	return nil
+}
+
+// parseTOML reads the subset of TOML message catalogs need: comments,
+// [table] and [dotted.table] headers, and key = "string" pairs with bare,
+// quoted or dotted keys. Basic strings support the usual escapes; literal
+// 'strings' are taken as they are.
+func parseTOML(data []byte) (map[string]string, error) {
+	messages := make(map[string]string)
+	prefix := ""
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		if line[0] == '[' {
+			end := strings.IndexByte(line, ']')
+			if end < 0 || strings.HasPrefix(line, "[[") {
+				return nil, fmt.Errorf("line %d: invalid table header", n)
+			}
+			if rest := strings.TrimSpace(line[end+1:]); rest != "" && rest[0] != '#' {
+				return nil, fmt.Errorf("line %d: unexpected %q after table header", n, rest)
+			}
+			table, err := tomlKey(line[1:end])
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", n, err)
+			}
+			prefix = table + "."
+			continue
+		}
+		k, v, ok := strings.Cut(line, "=")
+		if !ok {
+			return nil, fmt.Errorf("line %d: want key = \"value\"", n)
+		}
+		key, err := tomlKey(k)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", n, err)
+		}
+		value, err := tomlString(strings.TrimSpace(v))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", n, err)
+		}
+		messages[prefix+key] = value
+	}
+	return messages, sc.Err()
+}
+
+// tomlKey joins the parts of a dotted key, unquoting quoted parts.
+func tomlKey(s string) (string, error) {
+	var parts []string
+	for _, p := range strings.Split(s, ".") {
+		p = strings.TrimSpace(p)
+		if len(p) >= 2 && (p[0] == '"' || p[0] == '\'') && p[len(p)-1] == p[0] {
+			p = p[1 : len(p)-1]
+		} else if p == "" || strings.ContainsAny(p, " \t\"'#") {
+			return "", fmt.Errorf("invalid key %q", s)
+		}
+		parts = append(parts, p)
+	}
+	return strings.Join(parts, "."), nil
+}
+
+// tomlString parses a single-line basic or literal string, followed by an
+// optional comment.
+func tomlString(s string) (string, error) {
+	if len(s) < 2 {
+		return "", fmt.Errorf("want a quoted string, got %q", s)
+	}
+	var value, rest string
+	switch s[0] {
+	case '\'':
+		end := strings.IndexByte(s[1:], '\'')
+		if end < 0 {
+			return "", fmt.Errorf("unterminated string %s", s)
+		}
+		value, rest = s[1:end+1], s[end+2:]
+	case '"':
+		end := 1
+		for ; end < len(s) && s[end] != '"'; end++ {
+			if s[end] == '\\' {
+				end++
+			}
+		}
+		if end >= len(s) {
+			return "", fmt.Errorf("unterminated string %s", s)
+		}
+		v, err := strconv.Unquote(s[:end+1])
+		if err != nil {
+			return "", fmt.Errorf("invalid string %s: %w", s[:end+1], err)
+		}
+		value, rest = v, s[end+1:]
+	default:
+		return "", fmt.Errorf("want a quoted string, got %q", s)
+	}
+	if rest = strings.TrimSpace(rest); rest != "" && rest[0] != '#' {
+		return "", fmt.Errorf("unexpected %q after string", rest)
+	}
+	return value, nil
+}
@@ -0,0 +1,175 @@
+package i18n
+
+import (
+	"fmt"
+	"html/template"
+	"strconv"
+	"strings"
+	"time"
+
+	shared "github.com/GoogleCloudPlatform/microservices-demo/src/shared"
+)
+
+// Localizer translates and formats for one locale.
+type Localizer struct {
+	bundle *Bundle
+	locale string
+}
+
+// Locale returns the Localizer's locale.
+func (l *Localizer) Locale() string { return l.locale }
+
+// T returns the message key with its placeholders replaced by the given
+// name/value pairs: T("greeting", "name", "Ana") turns "Hello, {name}!" into
+// "Hello, Ana!". A key missing from the locale and the default locale is
+// returned as it is, so it stands out on the page.
+func (l *Localizer) T(key string, args ...any) string {
+	m, ok := l.bundle.lookup(l.locale, key)
+	if !ok {
+		return key
+	}
+	return l.fill(m, args)
+}
+
+// Plural returns the "one" or "other" form of key for n, filled in as by
+// T. Locales of languages without plural forms, such as Japanese, always
+// use "other"; so does a key without a "one" form.
+func (l *Localizer) Plural(key string, n int, args ...any) string {
+	form := "other"
+	if n == 1 && !noPluralLanguages[l.language()] {
+		if _, ok := l.bundle.lookup(l.locale, key+".one"); ok {
+			form = "one"
+		}
+	}
+	return l.T(key+"."+form, args...)
+}
+
+// noPluralLanguages do not inflect nouns for number.
+var noPluralLanguages = map[string]bool{"ja": true, "zh": true, "ko": true, "th": true, "vi": true}
+
+func (l *Localizer) fill(m string, args []any) string {
+	if len(args) < 2 || !strings.Contains(m, "{") {
+		return m
+	}
+	pairs := make([]string, 0, len(args))
+	for i := 0; i+1 < len(args); i += 2 {
+		pairs = append(pairs, "{"+fmt.Sprint(args[i])+"}", l.value(args[i+1]))
+	}
+	return strings.NewReplacer(pairs...).Replace(m)
+}
+
+// value formats a placeholder value for the locale.
+func (l *Localizer) value(v any) string {
+	switch v := v.(type) {
+	case shared.Money:
+		return l.Money(v)
+	case time.Time:
+		return l.Date(v)
+	case int:
+		return l.Number(int64(v))
+	case int64:
+		return l.Number(v)
+	}
+	return fmt.Sprint(v)
+}
+
+func (l *Localizer) language() string {
+	lang, _, _ := strings.Cut(l.locale, "-")
+	return lang
+}
+
+// Money formats m for the locale, as shared.Money.Format does.
+func (l *Localizer) Money(m shared.Money) string { return m.Format(l.locale) }
+
+// Number formats n with the locale's digit grouping: "1,234,567" in
+// English, "1.234.567" in German.
+func (l *Localizer) Number(n int64) string {
+	digits := strconv.FormatInt(n, 10)
+	neg := strings.HasPrefix(digits, "-")
+	digits = strings.TrimPrefix(digits, "-")
+	group := ","
+	if f, ok := localeStyles[l.language()]; ok {
+		group = f.group
+	}
+	var b strings.Builder
+	if neg {
+		b.WriteByte('-')
+	}
+	for i, c := range digits {
+		if i > 0 && (len(digits)-i)%3 == 0 {
+			b.WriteString(group)
+		}
+		b.WriteRune(c)
+	}
+	return b.String()
+}
+
+// localeStyle describes how a language writes dates and numbers.
+type localeStyle struct {
+	// date and dateTime are Go time layouts with English month names,
+	// which are replaced by months.
+	date, dateTime string
+	months         []string
+	group          string
+}
+
+// localeStyles are keyed by language subtag; others fall back to English.
+var localeStyles = map[string]localeStyle{
+	"en": {date: "January 2, 2006", dateTime: "January 2, 2006 3:04 PM", group: ","},
+	"de": {date: "2. January 2006", dateTime: "2. January 2006 15:04", group: ".",
+		months: []string{"Januar", "Februar", "März", "April", "Mai", "Juni", "Juli", "August", "September", "Oktober", "November", "Dezember"}},
+	"fr": {date: "2 January 2006", dateTime: "2 January 2006 15:04", group: "\u202f",
+		months: []string{"janvier", "février", "mars", "avril", "mai", "juin", "juillet", "août", "septembre", "octobre", "novembre", "décembre"}},
+	"es": {date: "2 de January de 2006", dateTime: "2 de January de 2006 15:04", group: ".",
+		months: []string{"enero", "febrero", "marzo", "abril", "mayo", "junio", "julio", "agosto", "septiembre", "octubre", "noviembre", "diciembre"}},
+	"it": {date: "2 January 2006", dateTime: "2 January 2006 15:04", group: ".",
+		months: []string{"gennaio", "febbraio", "marzo", "aprile", "maggio", "giugno", "luglio", "agosto", "settembre", "ottobre", "novembre", "dicembre"}},
+	"nl": {date: "2 January 2006", dateTime: "2 January 2006 15:04", group: ".",
+		months: []string{"januari", "februari", "maart", "april", "mei", "juni", "juli", "augustus", "september", "oktober", "november", "december"}},
+	"pt": {date: "2 de January de 2006", dateTime: "2 de January de 2006 15:04", group: ".",
+		months: []string{"janeiro", "fevereiro", "março", "abril", "maio", "junho", "julho", "agosto", "setembro", "outubro", "novembro", "dezembro"}},
+	"ja": {date: "2006年1月2日", dateTime: "2006年1月2日 15:04", group: ","},
+	"zh": {date: "2006年1月2日", dateTime: "2006年1月2日 15:04", group: ","},
+}
+
+// Date formats the day of t in the locale's long form: "March 5, 2025",
+// "5. März 2025", "2025年3月5日".
+func (l *Localizer) Date(t time.Time) string {
+	f := l.style()
+	return f.localize(t.Format(f.date), t)
+}
+
+// DateTime formats t with its time of day.
+func (l *Localizer) DateTime(t time.Time) string {
+	f := l.style()
+	return f.localize(t.Format(f.dateTime), t)
+}
+
+func (l *Localizer) style() localeStyle {
+	if f, ok := localeStyles[l.language()]; ok {
+		return f
+	}
+	return localeStyles["en"]
+}
+
+// localize swaps the English month name of t in s for the locale's.
+func (f localeStyle) localize(s string, t time.Time) string {
+	if f.months == nil {
+		return s
+	}
+	return strings.Replace(s, t.Month().String(), f.months[t.Month()-1], 1)
+}
+
+// FuncMap returns template functions bound to the Localizer: t, plural,
+// money, number, date and datetime, as in {{ t "cart.title" }} or
+// {{ money .Total }}.
+func (l *Localizer) FuncMap() template.FuncMap {
+	return template.FuncMap{
+		"t":        l.T,
+		"plural":   l.Plural,
+		"money":    l.Money,
+		"number":   l.Number,
+		"date":     l.Date,
+		"datetime": l.DateTime,
+	}
+}
@@ -0,0 +1,287 @@
+// Package i18n translates user-facing text and formats amounts and dates
+// for the visitor's locale.
+//
+// Messages come from catalogs, one file per locale named after its BCP 47
+// tag, in JSON or TOML, usually embedded in the binary:
+//
+//	locales/en.json   {"cart": {"title": "Your cart", "items": {"one": "{count} item", "other": "{count} items"}}}
+//	locales/de.toml   [cart]
+//	                  title = "Ihr Warenkorb"
+//
+// Nested keys are joined with dots ("cart.title"), and "one"/"other"
+// children make a message plural. Placeholders such as {count} are filled
+// from name/value pairs.
+//
+// Usage:
+//
+//	//go:embed locales
+//	var locales embed.FS
+//
+//	b := i18n.New("en")
+//	if err := b.LoadFS(locales, "locales"); err != nil { ... }
+//	mux.Handle("/", b.Middleware(handler))
+//
+//	// in handlers:
+//	l := b.Localizer(r.Context())
+//	title := l.T("cart.title")
+//	count := l.Plural("cart.items", n, "count", n)
+//	total := l.Money(order.Total)
+package i18n
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"path"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// CookieName holds the visitor's chosen locale, which takes precedence over
+// Accept-Language.
+const CookieName = "shop_locale"
+
+// QueryParam switches the locale for one request, e.g. "?lang=de".
+const QueryParam = "lang"
+
+// Bundle holds the message catalogs of every supported locale.
+type Bundle struct {
+	defaultLocale string
+
+	mu       sync.RWMutex
+	catalogs map[string]map[string]string
+}
+
+// New returns an empty Bundle falling back to defaultLocale.
+func New(defaultLocale string) *Bundle {
+	return &Bundle{defaultLocale: Canonical(defaultLocale), catalogs: make(map[string]map[string]string)}
+}
+
+// DefaultLocale returns the locale used when negotiation finds no match.
+func (b *Bundle) DefaultLocale() string { return b.defaultLocale }
+
+// AddMessages merges messages, keyed as in catalogs, into locale.
+func (b *Bundle) AddMessages(locale string, messages map[string]string) {
+	locale = Canonical(locale)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	c := b.catalogs[locale]
+	if c == nil {
+		c = make(map[string]string, len(messages))
+		b.catalogs[locale] = c
+	}
+	for k, v := range messages {
+		c[k] = v
+	}
+}
+
+// LoadFS loads every .json and .toml file in dir of fsys as the catalog of
+// the locale its name gives, such as "pt-BR.json".
+func (b *Bundle) LoadFS(fsys fs.FS, dir string) error {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return fmt.Errorf("i18n: %w", err)
+	}
+	for _, e := range entries {
+		ext := path.Ext(e.Name())
+		if e.IsDir() || (ext != ".json" && ext != ".toml") {
+			continue
+		}
+		data, err := fs.ReadFile(fsys, path.Join(dir, e.Name()))
+		if err != nil {
+			return fmt.Errorf("i18n: %w", err)
+		}
+		var messages map[string]string
+		if ext == ".json" {
+			messages, err = parseJSON(data)
+		} else {
+			messages, err = parseTOML(data)
+		}
+		if err != nil {
+			return fmt.Errorf("i18n: %s: %w", e.Name(), err)
+		}
+		b.AddMessages(strings.TrimSuffix(e.Name(), ext), messages)
+	}
+	return nil
+}
+
+// Locales returns the locales with a catalog, sorted.
+func (b *Bundle) Locales() []string {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	locales := make([]string, 0, len(b.catalogs))
+	for l := range b.catalogs {
+		locales = append(locales, l)
+	}
+	sort.Strings(locales)
+	return locales
+}
+
+// Canonical normalizes a BCP 47 tag's case and separators: "en_us" becomes
+// "en-US" and "zh-hant-tw" becomes "zh-Hant-TW".
+func Canonical(tag string) string {
+	parts := strings.Split(strings.ReplaceAll(strings.TrimSpace(tag), "_", "-"), "-")
+	for i, p := range parts {
+		switch {
+		case i == 0:
+			parts[i] = strings.ToLower(p)
+		case len(p) == 2:
+			parts[i] = strings.ToUpper(p)
+		case len(p) == 4:
+			parts[i] = strings.ToUpper(p[:1]) + strings.ToLower(p[1:])
+		default:
+			parts[i] = strings.ToLower(p)
+		}
+	}
+	return strings.Join(parts, "-")
+}
+
+// Negotiate returns the supported locale best matching the given
+// preferences, most preferred first, or DefaultLocale. A preference matches
+// a locale with the same tag, then one it is a more specific form of
+// ("de-AT" matches "de"), then one of the same language ("de" matches
+// "de-DE").
+func (b *Bundle) Negotiate(preferences ...string) string {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	for _, p := range preferences {
+		p = Canonical(p)
+		if p == "" || p == "*" {
+			continue
+		}
+		if _, ok := b.catalogs[p]; ok {
+			return p
+		}
+		for tag := p; strings.Contains(tag, "-"); {
+			tag = tag[:strings.LastIndexByte(tag, '-')]
+			if _, ok := b.catalogs[tag]; ok {
+				return tag
+			}
+		}
+		lang, _, _ := strings.Cut(p, "-")
+		var match string
+		for l := range b.catalogs {
+			if strings.HasPrefix(l, lang+"-") && (match == "" || l < match) {
+				match = l
+			}
+		}
+		if match != "" {
+			return match
+		}
+	}
+	return b.defaultLocale
+}
+
+// ParseAcceptLanguage returns the tags of an Accept-Language header ordered
+// by decreasing quality, leaving out those with q=0.
+func ParseAcceptLanguage(header string) []string {
+	type entry struct {
+		tag string
+		q   float64
+	}
+	var entries []entry
+	for _, part := range strings.Split(header, ",") {
+		tag, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			f, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				continue
+			}
+			q = f
+		}
+		if tag = strings.TrimSpace(tag); tag != "" && q > 0 {
+			entries = append(entries, entry{tag, q})
+		}
+	}
+	slices.SortStableFunc(entries, func(a, b entry) int {
+		switch {
+		case a.q > b.q:
+			return -1
+		case a.q < b.q:
+			return 1
+		}
+		return 0
+	})
+	tags := make([]string, len(entries))
+	for i, e := range entries {
+		tags[i] = e.tag
+	}
+	return tags
+}
+
+type localeKey struct{}
+
+// WithLocale returns a copy of ctx preferring locale, for example the one
+// saved in a user's profile.
+func WithLocale(ctx context.Context, locale string) context.Context {
+	return context.WithValue(ctx, localeKey{}, locale)
+}
+
+// LocaleFromContext returns the locale stored by WithLocale or Middleware,
+// or "".
+func LocaleFromContext(ctx context.Context) string {
+	l, _ := ctx.Value(localeKey{}).(string)
+	return l
+}
+
+// Middleware negotiates each request's locale from, in order, the lang
+// query parameter, the shop_locale cookie, a locale already in the context
+// and Accept-Language, stores it in the request context and sets the
+// Content-Language header. A lang parameter is remembered in the cookie.
+func (b *Bundle) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var prefs []string
+		query := r.URL.Query().Get(QueryParam)
+		if query != "" {
+			prefs = append(prefs, query)
+		}
+		if c, err := r.Cookie(CookieName); err == nil {
+			prefs = append(prefs, c.Value)
+		}
+		if l := LocaleFromContext(r.Context()); l != "" {
+			prefs = append(prefs, l)
+		}
+		prefs = append(prefs, ParseAcceptLanguage(r.Header.Get("Accept-Language"))...)
+		locale := b.Negotiate(prefs...)
+
+		if query != "" {
+			http.SetCookie(w, &http.Cookie{Name: CookieName, Value: locale, Path: "/", MaxAge: 365 * 24 * 3600, SameSite: http.SameSiteLaxMode})
+		}
+		w.Header().Set("Content-Language", locale)
+		w.Header().Add("Vary", "Accept-Language")
+		next.ServeHTTP(w, r.WithContext(WithLocale(r.Context(), locale)))
+	})
+}
+
+// Localizer returns a Localizer for the locale negotiated for ctx.
+func (b *Bundle) Localizer(ctx context.Context) *Localizer {
+	return b.For(b.Negotiate(LocaleFromContext(ctx)))
+}
+
+// For returns a Localizer for locale, which should be a supported one,
+// e.g. from Negotiate.
+func (b *Bundle) For(locale string) *Localizer {
+	return &Localizer{bundle: b, locale: Canonical(locale)}
+}
+
+// lookup returns the message key of locale, falling back to its parent
+// locales and then the default locale.
+func (b *Bundle) lookup(locale, key string) (string, bool) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	for tag := locale; ; tag = tag[:strings.LastIndexByte(tag, '-')] {
+		if m, ok := b.catalogs[tag][key]; ok {
+			return m, true
+		}
+		if !strings.Contains(tag, "-") {
+			break
+		}
+	}
+	m, ok := b.catalogs[b.defaultLocale][key]
+	return m, ok
+}
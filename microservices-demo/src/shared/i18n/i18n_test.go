@@ -0,0 +1,149 @@
+package i18n
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	shared "github.com/GoogleCloudPlatform/microservices-demo/src/shared"
+)
+
+func newTestBundle(t *testing.T) *Bundle {
+	t.Helper()
+	b := New("en")
+	err := b.LoadFS(fstest.MapFS{
+		"locales/en.json": {Data: []byte(`{"greeting": "Hello, {name}!", "cart": {"title": "Your cart",
+			"items": {"one": "{count} item", "other": "{count} items"}, "total": "Total: {total}"}}`)},
+		"locales/de.toml": {Data: []byte(`# German
+greeting = "Hallo, {name}!"
+
+[cart]
+title = "Ihr Warenkorb"  # shown in the header
+items.one = "{count} Artikel"
+"items".other = '{count} Artikel'
+`)},
+		"locales/pt-BR.json": {Data: []byte(`{"cart": {"title": "Seu carrinho"}}`)},
+		"locales/README.md":  {Data: []byte("ignored")},
+	}, "locales")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestLoadAndTranslate(t *testing.T) {
+	b := newTestBundle(t)
+	if got := b.Locales(); !reflect.DeepEqual(got, []string{"de", "en", "pt-BR"}) {
+		t.Errorf("Locales = %v", got)
+	}
+	en, de, pt := b.For("en"), b.For("de"), b.For("pt-BR")
+	for _, tt := range []struct{ got, want string }{
+		{en.T("greeting", "name", "Ana"), "Hello, Ana!"},
+		{de.T("cart.title"), "Ihr Warenkorb"},
+		{de.Plural("cart.items", 1, "count", 1), "1 Artikel"},
+		{en.Plural("cart.items", 1, "count", 1), "1 item"},
+		{en.Plural("cart.items", 1200, "count", 1200), "1,200 items"},
+		{de.T("cart.total", "total", shared.Money{Currency: "EUR", Units: 1234, Nanos: 500_000_000}), "Total: 1.234,50 €"},
+		{pt.T("greeting", "name", "Ana"), "Hello, Ana!"},
+		{pt.T("cart.title"), "Seu carrinho"},
+		{en.T("missing.key"), "missing.key"},
+	} {
+		if tt.got != tt.want {
+			t.Errorf("got %q, want %q", tt.got, tt.want)
+		}
+	}
+}
+
+func TestLoadRejectsInvalidCatalogs(t *testing.T) {
+	for name, data := range map[string]string{
+		"bad.json": `{"a": 1}`,
+		"bad.toml": "a = 1",
+		"tbl.toml": "[[array]]\na = \"x\"",
+		"str.toml": `a = "unterminated`,
+	} {
+		err := New("en").LoadFS(fstest.MapFS{name: {Data: []byte(data)}}, ".")
+		if err == nil {
+			t.Errorf("%s: loaded without error", name)
+		}
+	}
+}
+
+func TestNegotiate(t *testing.T) {
+	b := newTestBundle(t)
+	for _, tt := range []struct {
+		accept string
+		want   string
+	}{
+		{"de-AT, en;q=0.5", "de"},
+		{"fr, pt;q=0.9", "pt-BR"},
+		{"fr-FR, ja;q=0.5", "en"},
+		{"de;q=0, en-gb;q=0.8", "en"},
+		{"", "en"},
+	} {
+		if got := b.Negotiate(ParseAcceptLanguage(tt.accept)...); got != tt.want {
+			t.Errorf("Negotiate(%q) = %q, want %q", tt.accept, got, tt.want)
+		}
+	}
+	if got := Canonical("zh_hant_tw"); got != "zh-Hant-TW" {
+		t.Errorf("Canonical = %q", got)
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	b := newTestBundle(t)
+	var got string
+	h := b.Middleware(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+		got = b.Localizer(r.Context()).T("cart.title")
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "/?lang=pt-br", nil)
+	req.Header.Set("Accept-Language", "de")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if got != "Seu carrinho" || rec.Header().Get("Content-Language") != "pt-BR" {
+		t.Fatalf("lang parameter: %q, Content-Language %q", got, rec.Header().Get("Content-Language"))
+	}
+	cookies := rec.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != CookieName || cookies[0].Value != "pt-BR" {
+		t.Fatalf("cookies = %v", cookies)
+	}
+
+	// The remembered choice beats Accept-Language and the context.
+	req = httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Accept-Language", "de")
+	req.AddCookie(cookies[0])
+	h.ServeHTTP(httptest.NewRecorder(), req.WithContext(WithLocale(context.Background(), "en")))
+	if got != "Seu carrinho" {
+		t.Errorf("cookie: %q", got)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Accept-Language", "de-CH, en;q=0.3")
+	h.ServeHTTP(httptest.NewRecorder(), req)
+	if got != "Ihr Warenkorb" {
+		t.Errorf("Accept-Language: %q", got)
+	}
+}
+
+func TestFormatting(t *testing.T) {
+	d := time.Date(2025, time.March, 5, 14, 30, 0, 0, time.UTC)
+	b := New("en")
+	for locale, want := range map[string][3]string{
+		"en-US": {"March 5, 2025", "March 5, 2025 2:30 PM", "1,234,567"},
+		"de-DE": {"5. März 2025", "5. März 2025 14:30", "1.234.567"},
+		"ja":    {"2025年3月5日", "2025年3月5日 14:30", "1,234,567"},
+		"sv":    {"March 5, 2025", "March 5, 2025 2:30 PM", "1,234,567"},
+	} {
+		l := b.For(locale)
+		if got := [3]string{l.Date(d), l.DateTime(d), l.Number(1234567)}; got != want {
+			t.Errorf("%s: got %q, want %q", locale, got, want)
+		}
+	}
+	if got := b.For("fr").Number(-1234); got != "-1\u202f234" {
+		t.Errorf("fr Number = %q", got)
+	}
+}
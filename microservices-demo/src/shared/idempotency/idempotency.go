@@ -0,0 +1,246 @@
+// Package idempotency makes retried mutating RPCs safe. A client sends the
+// same idempotency key with every attempt of one logical operation; the
+// server interceptor executes the first attempt, stores its outcome, and
+// replays that outcome to later attempts instead of running the handler
+// again, so a retry after a network blip cannot charge a card twice.
+//
+// Client:
+//
+//	ctx = idempotency.WithKey(ctx, idempotency.NewKey())
+//	err := shared.Retry(ctx, shared.DefaultRetryPolicy, func(ctx context.Context) error {
+//	    _, err := checkout.PlaceOrder(ctx, req)
+//	    return err
+//	})
+//
+// Server:
+//
+//	store := idempotency.NewRedis(rdb)
+//	srv := grpcserver.New(grpcserver.WithUnaryInterceptors(
+//	    idempotency.UnaryServerInterceptor(store,
+//	        idempotency.WithMethods("/hipstershop.CheckoutService/PlaceOrder"))))
+//
+// Keys are scoped by method and, when the auth package authenticated the
+// caller, by user. Reusing a key with a different request body fails with
+// InvalidArgument; a retry that arrives while the first attempt is still
+// running fails with Aborted, which clients retry after a backoff.
+// Retryable failures (see shared.IsRetryable) are not stored, so a later
+// attempt executes again.
+package idempotency
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"log/slog"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/anypb"
+
+	shared "github.com/GoogleCloudPlatform/microservices-demo/src/shared"
+	"github.com/GoogleCloudPlatform/microservices-demo/src/shared/auth"
+	"github.com/GoogleCloudPlatform/microservices-demo/src/shared/metrics"
+)
+
+// MetadataKey is the gRPC metadata key carrying the idempotency key.
+const MetadataKey = "idempotency-key"
+
+// DefaultTTL is how long outcomes are replayable.
+const DefaultTTL = 24 * time.Hour
+
+var (
+	replayedTotal = metrics.NewCounterVec("idempotency_replayed_total",
+		"Calls answered from a stored outcome instead of running the handler, by method.", "method")
+	conflictsTotal = metrics.NewCounterVec("idempotency_conflicts_total",
+		"Calls rejected by the idempotency interceptor, by method and reason (in_progress, mismatch, missing).", "method", "reason")
+)
+
+// NewKey returns a random key suitable for one logical operation.
+func NewKey() string {
+	var b [16]byte
+	rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
+
+// WithKey returns a context whose outgoing gRPC calls carry key. Create it
+// once, outside any retry loop, so every attempt shares the key.
+func WithKey(ctx context.Context, key string) context.Context {
+	return metadata.AppendToOutgoingContext(ctx, MetadataKey, key)
+}
+
+// KeyFromContext returns the idempotency key of an incoming call.
+func KeyFromContext(ctx context.Context) (string, bool) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	if v := md.Get(MetadataKey); len(v) > 0 && v[0] != "" {
+		return v[0], true
+	}
+	return "", false
+}
+
+type config struct {
+	ttl      time.Duration
+	lockTTL  time.Duration
+	methods  map[string]bool
+	required bool
+}
+
+// Option configures the interceptor.
+type Option func(*config)
+
+// WithTTL sets how long outcomes are replayable (default DefaultTTL).
+func WithTTL(d time.Duration) Option {
+	return func(c *config) { c.ttl = d }
+}
+
+// WithLockTimeout bounds how long a key stays reserved by an attempt that
+// never completes, e.g. because its replica crashed (default 1m). Set it
+// above the longest handler deadline.
+func WithLockTimeout(d time.Duration) Option {
+	return func(c *config) { c.lockTTL = d }
+}
+
+// WithMethods limits the interceptor to the given full method names; by
+// default every method whose caller sends a key participates.
+func WithMethods(methods ...string) Option {
+	return func(c *config) {
+		if c.methods == nil {
+			c.methods = make(map[string]bool)
+		}
+		for _, m := range methods {
+			c.methods[m] = true
+		}
+	}
+}
+
+// WithRequired rejects calls to the selected methods that carry no key with
+// InvalidArgument. It only applies together with WithMethods.
+func WithRequired() Option {
+	return func(c *config) { c.required = true }
+}
+
+// UnaryServerInterceptor deduplicates calls sharing an idempotency key
+// using store.
+func UnaryServerInterceptor(store Store, opts ...Option) grpc.UnaryServerInterceptor {
+	cfg := config{ttl: DefaultTTL, lockTTL: time.Minute}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		if cfg.methods != nil && !cfg.methods[info.FullMethod] {
+			return handler(ctx, req)
+		}
+		key, ok := KeyFromContext(ctx)
+		if !ok {
+			if cfg.required && cfg.methods != nil {
+				conflictsTotal.WithLabelValues(info.FullMethod, "missing").Inc()
+				return nil, status.Errorf(codes.InvalidArgument, "%s requires an %s", info.FullMethod, MetadataKey)
+			}
+			return handler(ctx, req)
+		}
+
+		fp, err := fingerprint(info.FullMethod, req)
+		if err != nil {
+			return nil, status.Errorf(codes.Internal, "idempotency: %v", err)
+		}
+		scoped := scopeKey(ctx, info.FullMethod, key)
+		existing, reserved, err := store.Begin(ctx, scoped, Record{State: InProgress, Fingerprint: fp}, cfg.lockTTL)
+		if err != nil {
+			// Failing closed would take checkout down with the store; the
+			// handler still runs, just without protection.
+			slog.WarnContext(ctx, "idempotency store unavailable, running handler unprotected",
+				"method", info.FullMethod, "error", err)
+			return handler(ctx, req)
+		}
+		if !reserved {
+			return replay(info.FullMethod, fp, existing)
+		}
+
+		resp, herr := handler(ctx, req)
+		// Record the outcome even if the caller has gone away: that is
+		// exactly when it will retry.
+		sctx := context.WithoutCancel(ctx)
+		if herr != nil {
+			if shared.IsRetryable(herr) {
+				if err := store.Release(sctx, scoped); err != nil {
+					slog.WarnContext(ctx, "idempotency: releasing key failed", "method", info.FullMethod, "error", err)
+				}
+				return resp, herr
+			}
+			st := status.Convert(herr)
+			rec := Record{State: Done, Fingerprint: fp, Code: uint32(st.Code()), Message: st.Message()}
+			if err := store.Complete(sctx, scoped, rec, cfg.ttl); err != nil {
+				slog.WarnContext(ctx, "idempotency: storing outcome failed", "method", info.FullMethod, "error", err)
+			}
+			return resp, herr
+		}
+
+		rec := Record{State: Done, Fingerprint: fp}
+		if m, ok := resp.(proto.Message); ok {
+			a, err := anypb.New(m)
+			if err == nil {
+				rec.Response, err = proto.Marshal(a)
+			}
+			if err != nil {
+				slog.WarnContext(ctx, "idempotency: encoding response failed", "method", info.FullMethod, "error", err)
+				store.Release(sctx, scoped)
+				return resp, nil
+			}
+		}
+		if err := store.Complete(sctx, scoped, rec, cfg.ttl); err != nil {
+			slog.WarnContext(ctx, "idempotency: storing outcome failed", "method", info.FullMethod, "error", err)
+		}
+		return resp, nil
+	}
+}
+
+// replay answers a call from an existing record.
+func replay(method, fp string, rec *Record) (any, error) {
+	if rec.Fingerprint != fp {
+		conflictsTotal.WithLabelValues(method, "mismatch").Inc()
+		return nil, status.Errorf(codes.InvalidArgument, "%s was already used with a different request", MetadataKey)
+	}
+	if rec.State == InProgress {
+		conflictsTotal.WithLabelValues(method, "in_progress").Inc()
+		return nil, status.Error(codes.Aborted, "a request with this idempotency key is in progress")
+	}
+	replayedTotal.WithLabelValues(method).Inc()
+	if rec.Code != uint32(codes.OK) {
+		return nil, status.Error(codes.Code(rec.Code), rec.Message)
+	}
+	var a anypb.Any
+	if err := proto.Unmarshal(rec.Response, &a); err != nil {
+		return nil, status.Errorf(codes.Internal, "idempotency: decoding stored response: %v", err)
+	}
+	resp, err := a.UnmarshalNew()
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "idempotency: decoding stored response: %v", err)
+	}
+	return resp, nil
+}
+
+// fingerprint hashes the method and the deterministic encoding of req.
+func fingerprint(method string, req any) (string, error) {
+	h := sha256.New()
+	h.Write([]byte(method))
+	h.Write([]byte{0})
+	if m, ok := req.(proto.Message); ok {
+		b, err := proto.MarshalOptions{Deterministic: true}.Marshal(m)
+		if err != nil {
+			return "", err
+		}
+		h.Write(b)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// scopeKey namespaces a client key by method and authenticated user, so two
+// users cannot collide on (or probe) each other's keys.
+func scopeKey(ctx context.Context, method, key string) string {
+	user, _ := auth.UserFromContext(ctx)
+	return method + "|" + user + "|" + key
+}
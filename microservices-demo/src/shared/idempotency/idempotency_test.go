@@ -0,0 +1,142 @@
+package idempotency
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+const method = "/hipstershop.CheckoutService/PlaceOrder"
+
+func call(t *testing.T, intercept grpc.UnaryServerInterceptor, key string, req proto.Message, h grpc.UnaryHandler) (any, error) {
+	t.Helper()
+	ctx := context.Background()
+	if key != "" {
+		ctx = metadata.NewIncomingContext(ctx, metadata.Pairs(MetadataKey, key))
+	}
+	return intercept(ctx, req, &grpc.UnaryServerInfo{FullMethod: method}, h)
+}
+
+func TestReplaysSuccessfulResponse(t *testing.T) {
+	intercept := UnaryServerInterceptor(NewMemory())
+	charges := 0
+	h := func(ctx context.Context, req any) (any, error) {
+		charges++
+		return wrapperspb.String("order-1"), nil
+	}
+
+	req := wrapperspb.String("cart-1")
+	first, err := call(t, intercept, "k1", req, h)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := call(t, intercept, "k1", req, h)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if charges != 1 {
+		t.Errorf("handler ran %d times, want 1", charges)
+	}
+	got, ok := second.(*wrapperspb.StringValue)
+	if !ok || !proto.Equal(got, first.(proto.Message)) {
+		t.Errorf("replayed %T %v, want %v", second, second, first)
+	}
+
+	if _, err := call(t, intercept, "k2", req, h); err != nil || charges != 2 {
+		t.Errorf("new key: err = %v, charges = %d; want nil, 2", err, charges)
+	}
+	if _, err := call(t, intercept, "", req, h); err != nil || charges != 3 {
+		t.Errorf("no key: err = %v, charges = %d; want nil, 3", err, charges)
+	}
+}
+
+func TestRejectsKeyReuseWithDifferentRequest(t *testing.T) {
+	intercept := UnaryServerInterceptor(NewMemory())
+	h := func(ctx context.Context, req any) (any, error) { return wrapperspb.String("ok"), nil }
+	call(t, intercept, "k", wrapperspb.String("a"), h)
+	_, err := call(t, intercept, "k", wrapperspb.String("b"), h)
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("err = %v, want InvalidArgument", err)
+	}
+}
+
+func TestErrorOutcomes(t *testing.T) {
+	intercept := UnaryServerInterceptor(NewMemory())
+	runs := 0
+	declined := func(ctx context.Context, req any) (any, error) {
+		runs++
+		return nil, status.Error(codes.FailedPrecondition, "card declined")
+	}
+	req := wrapperspb.String("x")
+	call(t, intercept, "k", req, declined)
+	_, err := call(t, intercept, "k", req, declined)
+	if status.Code(err) != codes.FailedPrecondition || runs != 1 {
+		t.Errorf("non-retryable error: err = %v, runs = %d; want replayed FailedPrecondition, 1", err, runs)
+	}
+
+	runs = 0
+	flaky := func(ctx context.Context, req any) (any, error) {
+		runs++
+		if runs == 1 {
+			return nil, status.Error(codes.Unavailable, "payment service down")
+		}
+		return wrapperspb.String("ok"), nil
+	}
+	call(t, intercept, "k2", req, flaky)
+	if _, err := call(t, intercept, "k2", req, flaky); err != nil || runs != 2 {
+		t.Errorf("retryable error: err = %v, runs = %d; want executed again", err, runs)
+	}
+}
+
+func TestInProgress(t *testing.T) {
+	store := NewMemory()
+	intercept := UnaryServerInterceptor(store)
+	started, release := make(chan struct{}), make(chan struct{})
+	go call(t, intercept, "k", wrapperspb.String("x"), func(ctx context.Context, req any) (any, error) {
+		close(started)
+		<-release
+		return wrapperspb.String("ok"), nil
+	})
+	<-started
+	_, err := call(t, intercept, "k", wrapperspb.String("x"), nil)
+	close(release)
+	if status.Code(err) != codes.Aborted {
+		t.Errorf("concurrent attempt = %v, want Aborted", err)
+	}
+}
+
+func TestRequiredAndMethods(t *testing.T) {
+	intercept := UnaryServerInterceptor(NewMemory(), WithMethods(method), WithRequired())
+	h := func(ctx context.Context, req any) (any, error) { return wrapperspb.String("ok"), nil }
+	if _, err := call(t, intercept, "", wrapperspb.String("x"), h); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("missing key = %v, want InvalidArgument", err)
+	}
+	_, err := intercept(context.Background(), nil, &grpc.UnaryServerInfo{FullMethod: "/other/Method"}, h)
+	if err != nil {
+		t.Errorf("unselected method = %v, want passthrough", err)
+	}
+}
+
+func TestMemoryExpiry(t *testing.T) {
+	m := NewMemory()
+	now := time.Unix(0, 0)
+	m.now = func() time.Time { return now }
+	ctx := context.Background()
+	if _, ok, _ := m.Begin(ctx, "k", Record{}, time.Minute); !ok {
+		t.Fatal("first Begin not reserved")
+	}
+	if _, ok, _ := m.Begin(ctx, "k", Record{}, time.Minute); ok {
+		t.Fatal("second Begin reserved a held key")
+	}
+	now = now.Add(time.Minute)
+	if _, ok, _ := m.Begin(ctx, "k", Record{}, time.Minute); !ok {
+		t.Error("expired reservation not reclaimed")
+	}
+}
@@ -0,0 +1,164 @@
+package idempotency
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// State is the lifecycle stage of a Record.
+type State int
+
+const (
+	// InProgress marks a key whose first request is still executing.
+	InProgress State = iota
+	// Done marks a key whose outcome has been stored for replay.
+	Done
+)
+
+// Record is what a Store keeps per idempotency key.
+type Record struct {
+	State State `json:"state"`
+	// Fingerprint identifies the request body the key was first used with.
+	Fingerprint string `json:"fingerprint"`
+	// Response is the serialized anypb.Any of a successful response.
+	Response []byte `json:"response,omitempty"`
+	// Code and Message hold a non-retryable error outcome.
+	Code    uint32 `json:"code,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+// Store persists Records. Implementations must make Begin atomic across all
+// replicas sharing the store.
+type Store interface {
+	// Begin reserves key with an InProgress record for lockTTL. If the key
+	// already has a record, Begin returns it and reserved is false.
+	Begin(ctx context.Context, key string, rec Record, lockTTL time.Duration) (existing *Record, reserved bool, err error)
+	// Complete replaces the reservation with the final record for ttl.
+	Complete(ctx context.Context, key string, rec Record, ttl time.Duration) error
+	// Release drops a reservation so a retry can execute the request anew.
+	Release(ctx context.Context, key string) error
+}
+
+// Memory is a Store for a single replica and for tests.
+type Memory struct {
+	mu      sync.Mutex
+	records map[string]memRecord
+	now     func() time.Time
+}
+
+type memRecord struct {
+	rec     Record
+	expires time.Time
+}
+
+// NewMemory returns an empty in-memory store.
+func NewMemory() *Memory {
+	return &Memory{records: make(map[string]memRecord), now: time.Now}
+}
+
+// Begin implements Store.
+func (m *Memory) Begin(_ context.Context, key string, rec Record, lockTTL time.Duration) (*Record, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := m.now()
+	if e, ok := m.records[key]; ok && now.Before(e.expires) {
+		existing := e.rec
+		return &existing, false, nil
+	}
+	m.records[key] = memRecord{rec: rec, expires: now.Add(lockTTL)}
+	// Opportunistically drop expired entries so the map stays bounded.
+	for k, e := range m.records {
+		if !now.Before(e.expires) {
+			delete(m.records, k)
+		}
+	}
+	return nil, true, nil
+}
+
+// Complete implements Store.
+func (m *Memory) Complete(_ context.Context, key string, rec Record, ttl time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.records[key] = memRecord{rec: rec, expires: m.now().Add(ttl)}
+	return nil
+}
+
+// Release implements Store.
+func (m *Memory) Release(_ context.Context, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.records, key)
+	return nil
+}
+
+// Redis is a Store shared by every replica through Redis. Records are JSON
+// values under Prefix+key.
+type Redis struct {
+	Client redis.UniversalClient
+	// Prefix namespaces the keys (default "idempotency:").
+	Prefix string
+}
+
+// NewRedis returns a Store backed by c, for example one from NewClient in
+// the shared redis package.
+func NewRedis(c redis.UniversalClient) *Redis {
+	return &Redis{Client: c, Prefix: "idempotency:"}
+}
+
+// Begin implements Store with SET NX, so exactly one caller wins the key.
+func (r *Redis) Begin(ctx context.Context, key string, rec Record, lockTTL time.Duration) (*Record, bool, error) {
+	b, err := json.Marshal(rec)
+	if err != nil {
+		return nil, false, err
+	}
+	k := r.Prefix + key
+	// Two rounds cover a record expiring between SET NX and GET.
+	for range 2 {
+		ok, err := r.Client.SetNX(ctx, k, b, lockTTL).Result()
+		if err != nil {
+			return nil, false, fmt.Errorf("idempotency: redis: %w", err)
+		}
+		if ok {
+			return nil, true, nil
+		}
+		v, err := r.Client.Get(ctx, k).Bytes()
+		if errors.Is(err, redis.Nil) {
+			continue
+		}
+		if err != nil {
+			return nil, false, fmt.Errorf("idempotency: redis: %w", err)
+		}
+		var existing Record
+		if err := json.Unmarshal(v, &existing); err != nil {
+			return nil, false, fmt.Errorf("idempotency: decoding record %s: %w", key, err)
+		}
+		return &existing, false, nil
+	}
+	return nil, false, fmt.Errorf("idempotency: redis: could not reserve %s", key)
+}
+
+// Complete implements Store.
+func (r *Redis) Complete(ctx context.Context, key string, rec Record, ttl time.Duration) error {
+	b, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	if err := r.Client.Set(ctx, r.Prefix+key, b, ttl).Err(); err != nil {
+		return fmt.Errorf("idempotency: redis: %w", err)
+	}
+	return nil
+}
+
+// Release implements Store.
+func (r *Redis) Release(ctx context.Context, key string) error {
+	if err := r.Client.Del(ctx, r.Prefix+key).Err(); err != nil {
+		return fmt.Errorf("idempotency: redis: %w", err)
+	}
+	return nil
+}
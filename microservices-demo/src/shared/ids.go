@@ -0,0 +1,293 @@
+package shared
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+)
+
+// IDKind is the entity prefix of an ID: lower-case letters, written before
+// an underscore, e.g. the "ord" of "ord_01HQ3K5V8X2N7RZ4P6TBYCWJ9D".
+type IDKind string
+
+// Kinds used across the demo.
+const (
+	IDOrder       IDKind = "ord"
+	IDCart        IDKind = "cart"
+	IDUser        IDKind = "usr"
+	IDShipment    IDKind = "shp"
+	IDTransaction IDKind = "txn"
+	IDSession     IDKind = "sess"
+)
+
+// ErrInvalidID is returned by the parsing helpers for malformed IDs.
+var ErrInvalidID = errors.New("invalid id")
+
+// crockford is the ULID alphabet: Crockford's base32, which drops I, L, O
+// and U so IDs survive being read aloud or retyped.
+const crockford = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+// ULID is a 128-bit identifier whose first 48 bits are a millisecond
+// timestamp, so IDs sort by creation time as bytes and as strings.
+type ULID [16]byte
+
+// Time returns the millisecond the ULID was created.
+func (u ULID) Time() time.Time {
+	ms := uint64(u[0])<<40 | uint64(u[1])<<32 | uint64(u[2])<<24 | uint64(u[3])<<16 | uint64(u[4])<<8 | uint64(u[5])
+	return time.UnixMilli(int64(ms))
+}
+
+// String returns the 26-character Crockford base32 form.
+func (u ULID) String() string {
+	var b [26]byte
+	hi, lo := binary.BigEndian.Uint64(u[:8]), binary.BigEndian.Uint64(u[8:])
+	// 26 digits of 5 bits cover 130 bits; the top two are always zero.
+	for i := 25; i >= 0; i-- {
+		b[i] = crockford[lo&31]
+		lo = lo>>5 | hi<<59
+		hi >>= 5
+	}
+	return string(b[:])
+}
+
+// ParseULID parses the 26-character form, accepting lower case.
+func ParseULID(s string) (ULID, error) {
+	var u ULID
+	if len(s) != 26 {
+		return u, fmt.Errorf("%w: ulid %q: want 26 characters", ErrInvalidID, s)
+	}
+	var hi, lo uint64
+	for i := 0; i < len(s); i++ {
+		v := strings.IndexByte(crockford, upperASCII(s[i]))
+		if v < 0 || (i == 0 && v > 7) {
+			return u, fmt.Errorf("%w: ulid %q", ErrInvalidID, s)
+		}
+		hi = hi<<5 | lo>>59
+		lo = lo<<5 | uint64(v)
+	}
+	binary.BigEndian.PutUint64(u[:8], hi)
+	binary.BigEndian.PutUint64(u[8:], lo)
+	return u, nil
+}
+
+func upperASCII(c byte) byte {
+	if 'a' <= c && c <= 'z' {
+		return c - 'a' + 'A'
+	}
+	return c
+}
+
+type idConfig struct {
+	clock   Clock
+	entropy io.Reader
+}
+
+// IDOption configures NewIDGenerator.
+type IDOption func(*idConfig)
+
+// WithIDClock sets the clock stamping IDs, for tests.
+func WithIDClock(c Clock) IDOption {
+	return func(cfg *idConfig) { cfg.clock = c }
+}
+
+// WithIDEntropy sets the source of the random bits (default crypto/rand).
+func WithIDEntropy(r io.Reader) IDOption {
+	return func(cfg *idConfig) { cfg.entropy = r }
+}
+
+// IDGenerator produces ULIDs that are strictly increasing even within one
+// millisecond: the random part of the previous ID is incremented instead
+// of drawn afresh.
+type IDGenerator struct {
+	cfg idConfig
+
+	mu   sync.Mutex
+	last ULID
+	ms   int64
+}
+
+// NewIDGenerator returns a generator. Most code uses NewID, backed by a
+// process-wide generator.
+func NewIDGenerator(opts ...IDOption) *IDGenerator {
+	var cfg idConfig
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	cfg.clock = clockOrReal(cfg.clock)
+	if cfg.entropy == nil {
+		cfg.entropy = rand.Reader
+	}
+	return &IDGenerator{cfg: cfg}
+}
+
+// ULID returns the next ULID.
+func (g *IDGenerator) ULID() ULID {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if ms := g.cfg.clock.Now().UnixMilli(); ms > g.ms {
+		g.ms = ms
+		putULIDTime(&g.last, ms)
+		if _, err := io.ReadFull(g.cfg.entropy, g.last[6:]); err != nil {
+			panic(fmt.Sprintf("ids: reading entropy: %v", err))
+		}
+		return g.last
+	}
+	// Same millisecond, or the clock stepped back: keep the previous
+	// timestamp and bump the random part, moving to the next millisecond in
+	// the unlikely case it overflows.
+	if !incrementRandom(&g.last) {
+		g.ms++
+		putULIDTime(&g.last, g.ms)
+	}
+	return g.last
+}
+
+func putULIDTime(u *ULID, ms int64) {
+	u[0], u[1], u[2], u[3], u[4], u[5] = byte(ms>>40), byte(ms>>32), byte(ms>>24), byte(ms>>16), byte(ms>>8), byte(ms)
+}
+
+// incrementRandom adds one to the 80 random bits of u, reporting false if
+// they overflowed (and wrapped to zero).
+func incrementRandom(u *ULID) bool {
+	for i := 15; i >= 6; i-- {
+		u[i]++
+		if u[i] != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// NewID returns a prefixed ULID such as "ord_01HQ3K5V8X2N7RZ4P6TBYCWJ9D".
+func (g *IDGenerator) NewID(kind IDKind) string {
+	return string(kind) + "_" + g.ULID().String()
+}
+
+var defaultIDGenerator = NewIDGenerator()
+
+// NewID returns a sortable, prefixed ID for an entity of the given kind,
+// e.g. shared.NewID(shared.IDOrder) → "ord_01HQ3K5V8X2N7RZ4P6TBYCWJ9D".
+// IDs from one process are strictly increasing; across processes they sort
+// by creation millisecond, and 80 random bits keep them from colliding.
+func NewID(kind IDKind) string {
+	return defaultIDGenerator.NewID(kind)
+}
+
+// ParseID splits a prefixed ID into its kind and ULID.
+func ParseID(id string) (IDKind, ULID, error) {
+	prefix, rest, ok := strings.Cut(id, "_")
+	if !ok || !validIDKind(prefix) {
+		return "", ULID{}, fmt.Errorf("%w: %q: want <kind>_<ulid>", ErrInvalidID, id)
+	}
+	u, err := ParseULID(rest)
+	if err != nil {
+		return "", ULID{}, fmt.Errorf("%w: %q", ErrInvalidID, id)
+	}
+	return IDKind(prefix), u, nil
+}
+
+// ValidateID checks that id is a well-formed ID of the given kind, e.g.
+// before using a client-supplied order ID in a query.
+func ValidateID(id string, kind IDKind) error {
+	k, _, err := ParseID(id)
+	if err != nil {
+		return err
+	}
+	if k != kind {
+		return fmt.Errorf("%w: %q is a %s ID, want %s", ErrInvalidID, id, k, kind)
+	}
+	return nil
+}
+
+func validIDKind(s string) bool {
+	if s == "" || len(s) > 16 {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		if s[i] < 'a' || s[i] > 'z' {
+			return false
+		}
+	}
+	return true
+}
+
+// NewUUIDv7 returns an RFC 9562 version 7 UUID: a millisecond timestamp
+// followed by random bits, for tables and APIs that require the UUID
+// format but still benefit from time ordering.
+func NewUUIDv7() string {
+	u := defaultIDGenerator.ULID()
+	u[6] = u[6]&0x0f | 0x70 // version 7
+	u[8] = u[8]&0x3f | 0x80 // RFC 9562 variant
+	return fmt.Sprintf("%x-%x-%x-%x-%x", u[0:4], u[4:6], u[6:8], u[8:10], u[10:16])
+}
+
+// UUIDv7Time returns the creation time encoded in a version 7 UUID.
+func UUIDv7Time(s string) (time.Time, error) {
+	hex := strings.ReplaceAll(s, "-", "")
+	var ms uint64
+	if len(s) != 36 || len(hex) != 32 || hex[12] != '7' {
+		return time.Time{}, fmt.Errorf("%w: %q is not a version 7 UUID", ErrInvalidID, s)
+	}
+	if _, err := fmt.Sscanf(hex[:12], "%x", &ms); err != nil {
+		return time.Time{}, fmt.Errorf("%w: %q", ErrInvalidID, s)
+	}
+	return time.UnixMilli(int64(ms)), nil
+}
+
+// SnowflakeEpoch is the zero time of Snowflake IDs.
+var SnowflakeEpoch = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// Snowflake layout: 41 bits of milliseconds since SnowflakeEpoch (about 69
+// years), 10 bits of node and 12 bits of per-millisecond sequence.
+const (
+	snowflakeNodeBits = 10
+	snowflakeSeqBits  = 12
+	// MaxSnowflakeNode is the highest node number.
+	MaxSnowflakeNode = 1<<snowflakeNodeBits - 1
+)
+
+// Snowflake generates compact int64 IDs, for columns or protocols that
+// need a number. Each concurrently running generator needs a distinct node
+// number, e.g. a StatefulSet ordinal.
+type Snowflake struct {
+	node  int64
+	clock Clock
+
+	mu  sync.Mutex
+	ms  int64
+	seq int64
+}
+
+// NewSnowflake returns a generator for node, in [0, MaxSnowflakeNode]. A nil
+// clock uses RealClock.
+func NewSnowflake(node int64, clock Clock) (*Snowflake, error) {
+	if node < 0 || node > MaxSnowflakeNode {
+		return nil, fmt.Errorf("snowflake: node %d out of range [0, %d]", node, MaxSnowflakeNode)
+	}
+	return &Snowflake{node: node, clock: clockOrReal(clock)}, nil
+}
+
+// Next returns the next ID. When the 4096 IDs of a millisecond are used
+// up, it borrows from the next millisecond rather than blocking.
+func (s *Snowflake) Next() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ms := s.clock.Now().Sub(SnowflakeEpoch).Milliseconds()
+	if ms > s.ms {
+		s.ms, s.seq = ms, 0
+	} else if s.seq++; s.seq == 1<<snowflakeSeqBits {
+		s.ms++
+		s.seq = 0
+	}
+	return s.ms<<(snowflakeNodeBits+snowflakeSeqBits) | s.node<<snowflakeSeqBits | s.seq
+}
+
+// SnowflakeTime returns the creation time of a Snowflake ID.
+func SnowflakeTime(id int64) time.Time {
+	return SnowflakeEpoch.Add(time.Duration(id>>(snowflakeNodeBits+snowflakeSeqBits)) * time.Millisecond)
+}
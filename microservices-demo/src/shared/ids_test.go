@@ -0,0 +1,115 @@
+package shared
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNewIDFormatAndParse(t *testing.T) {
+	id := NewID(IDOrder)
+	if !strings.HasPrefix(id, "ord_") || len(id) != len("ord_")+26 {
+		t.Fatalf("NewID = %q", id)
+	}
+	kind, u, err := ParseID(id)
+	if err != nil || kind != IDOrder {
+		t.Fatalf("ParseID(%q) = %q, %v", id, kind, err)
+	}
+	if u.String() != id[4:] {
+		t.Errorf("round trip = %s, want %s", u, id[4:])
+	}
+	if d := time.Since(u.Time()); d < 0 || d > time.Minute {
+		t.Errorf("ULID time %v is not now", u.Time())
+	}
+	if _, _, err := ParseID(strings.ToLower(id)); err != nil {
+		t.Errorf("lower-case ID rejected: %v", err)
+	}
+
+	if err := ValidateID(id, IDCart); !errors.Is(err, ErrInvalidID) {
+		t.Errorf("ValidateID with the wrong kind = %v", err)
+	}
+	for _, bad := range []string{"", "ord", "ord_", "ORD_" + id[4:], "ord_" + id[4:29], "ord_8" + id[5:], "ord_" + id[4:29] + "U"} {
+		if _, _, err := ParseID(bad); err == nil {
+			t.Errorf("ParseID(%q) succeeded", bad)
+		}
+	}
+}
+
+func TestIDGeneratorMonotonic(t *testing.T) {
+	clk := NewFakeClock(time.UnixMilli(1_700_000_000_000))
+	g := NewIDGenerator(WithIDClock(clk))
+	prev := g.NewID(IDCart)
+	for i := range 1000 {
+		if i%100 == 0 {
+			clk.Advance(time.Millisecond)
+		}
+		id := g.NewID(IDCart)
+		if id <= prev {
+			t.Fatalf("ID %d: %s not after %s", i, id, prev)
+		}
+		prev = id
+	}
+
+	// A clock stepping back must not break ordering.
+	clk.Advance(-time.Second)
+	if id := g.NewID(IDCart); id <= prev {
+		t.Errorf("after clock step back: %s not after %s", id, prev)
+	}
+}
+
+func TestIDGeneratorRandomOverflow(t *testing.T) {
+	clk := NewFakeClock(time.UnixMilli(5000))
+	g := NewIDGenerator(WithIDClock(clk), WithIDEntropy(bytes.NewReader(bytes.Repeat([]byte{0xff}, 10))))
+	first := g.ULID()
+	second := g.ULID()
+	if !second.Time().After(first.Time()) {
+		t.Errorf("overflow did not advance the timestamp: %v then %v", first.Time(), second.Time())
+	}
+	if second.String() <= first.String() {
+		t.Errorf("%s not after %s", second, first)
+	}
+}
+
+func TestUUIDv7(t *testing.T) {
+	u := NewUUIDv7()
+	if len(u) != 36 || u[14] != '7' || !strings.ContainsAny(u[19:20], "89ab") {
+		t.Fatalf("NewUUIDv7 = %q", u)
+	}
+	ts, err := UUIDv7Time(u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := time.Since(ts); d < 0 || d > time.Minute {
+		t.Errorf("UUIDv7Time = %v", ts)
+	}
+	if _, err := UUIDv7Time("6ba7b810-9dad-11d1-80b4-00c04fd430c8"); err == nil {
+		t.Error("version 1 UUID accepted")
+	}
+}
+
+func TestSnowflake(t *testing.T) {
+	clk := NewFakeClock(SnowflakeEpoch.Add(time.Hour))
+	s, err := NewSnowflake(5, clk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	prev := s.Next()
+	for range 5000 { // more than one millisecond's sequence
+		id := s.Next()
+		if id <= prev {
+			t.Fatalf("%d not after %d", id, prev)
+		}
+		prev = id
+	}
+	if node := prev >> snowflakeSeqBits & MaxSnowflakeNode; node != 5 {
+		t.Errorf("node = %d", node)
+	}
+	if got := SnowflakeTime(s.Next()); got.Before(clk.Now()) {
+		t.Errorf("SnowflakeTime = %v, want ≥ %v", got, clk.Now())
+	}
+	if _, err := NewSnowflake(MaxSnowflakeNode+1, nil); err == nil {
+		t.Error("out-of-range node accepted")
+	}
+}
@@ -0,0 +1,52 @@
+// Package env reads typed settings from environment variables, falling back to
+// defaults (with a warning) when a value is missing or malformed.
+package env
+
+import (
+	"log/slog"
+	"os"
+	"strconv"
+	"time"
+)
+
+// Int reads a positive integer.
+func Int(key string, def int) int {
+	v, ok := os.LookupEnv(key)
+	if !ok {
+		return def
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n <= 0 {
+		slog.Warn("ignoring invalid environment variable", "key", key, "value", v)
+		return def
+	}
+	return n
+}
+
+// Duration reads a non-negative time.Duration such as "250ms" or "2m".
+func Duration(key string, def time.Duration) time.Duration {
+	v, ok := os.LookupEnv(key)
+	if !ok {
+		return def
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d < 0 {
+		slog.Warn("ignoring invalid environment variable", "key", key, "value", v)
+		return def
+	}
+	return d
+}
+
+// Bool reads a boolean as accepted by strconv.ParseBool.
+func Bool(key string, def bool) bool {
+	v, ok := os.LookupEnv(key)
+	if !ok {
+		return def
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		slog.Warn("ignoring invalid environment variable", "key", key, "value", v)
+		return def
+	}
+	return b
+}
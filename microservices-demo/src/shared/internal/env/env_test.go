@@ -0,0 +1,33 @@
+package env
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParsing(t *testing.T) {
+	t.Setenv("TEST_INT", "1024")
+	t.Setenv("TEST_BAD_INT", "-3")
+	t.Setenv("TEST_DURATION", "250ms")
+	t.Setenv("TEST_BAD_DURATION", "soon")
+	t.Setenv("TEST_BOOL", "false")
+
+	if got := Int("TEST_INT", 1); got != 1024 {
+		t.Errorf("Int = %d, want 1024", got)
+	}
+	if got := Int("TEST_BAD_INT", 7); got != 7 {
+		t.Errorf("Int(bad) = %d, want default 7", got)
+	}
+	if got := Duration("TEST_DURATION", time.Second); got != 250*time.Millisecond {
+		t.Errorf("Duration = %v, want 250ms", got)
+	}
+	if got := Duration("TEST_BAD_DURATION", time.Second); got != time.Second {
+		t.Errorf("Duration(bad) = %v, want default 1s", got)
+	}
+	if got := Bool("TEST_BOOL", true); got {
+		t.Error("Bool = true, want false")
+	}
+	if got := Bool("TEST_UNSET_BOOL", true); !got {
+		t.Error("Bool(unset) = false, want default true")
+	}
+}
@@ -0,0 +1,112 @@
+// Package kube is a minimal client for the Kubernetes API server, enough
+// for the few objects the shared packages read and write without pulling in
+// client-go: it authenticates with the pod's service account and leaves the
+// request and response bodies to the caller.
+package kube
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ServiceAccountDir is where Kubernetes mounts the pod's service account
+// token, CA bundle and namespace.
+const ServiceAccountDir = "/var/run/secrets/kubernetes.io/serviceaccount"
+
+// Client calls the API server.
+type Client struct {
+	// APIServer is the API server URL. Empty uses the in-cluster address.
+	APIServer string
+	// TokenPath is the bearer token, re-read on every request so rotated
+	// tokens are picked up. Empty uses the service account token; "-"
+	// sends no token.
+	TokenPath string
+	// HTTP makes the calls. Nil trusts the service account CA. It must not
+	// have a Timeout if the client is used for watches.
+	HTTP *http.Client
+}
+
+// InCluster returns c with its empty fields filled in from the pod's
+// environment and service account.
+func (c Client) InCluster() (Client, error) {
+	if c.APIServer == "" {
+		host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+		if host == "" {
+			return c, errors.New("not running in a cluster and no API server given")
+		}
+		c.APIServer = "https://" + net.JoinHostPort(host, port)
+	}
+	c.APIServer = strings.TrimSuffix(c.APIServer, "/")
+	if c.TokenPath == "" {
+		c.TokenPath = filepath.Join(ServiceAccountDir, "token")
+	}
+	if c.HTTP == nil {
+		pem, err := os.ReadFile(filepath.Join(ServiceAccountDir, "ca.crt"))
+		if err != nil {
+			return c, fmt.Errorf("reading cluster CA: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return c, errors.New("no certificates in cluster CA")
+		}
+		c.HTTP = &http.Client{
+			Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12}},
+		}
+	}
+	return c, nil
+}
+
+// Namespace returns the pod's namespace from its service account.
+func Namespace() (string, error) {
+	ns, err := os.ReadFile(filepath.Join(ServiceAccountDir, "namespace"))
+	if err != nil {
+		return "", fmt.Errorf("reading pod namespace: %w", err)
+	}
+	return strings.TrimSpace(string(ns)), nil
+}
+
+// Do sends a request for path, such as
+// "/apis/coordination.k8s.io/v1/namespaces/ns/leases", with a JSON body
+// unless body is nil.
+func (c Client) Do(ctx context.Context, method, path string, body []byte) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, c.APIServer+path, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.TokenPath != "-" {
+		token, err := os.ReadFile(c.TokenPath)
+		if err != nil {
+			return nil, fmt.Errorf("reading token: %w", err)
+		}
+		req.Header.Set("Authorization", "Bearer "+strings.TrimSpace(string(token)))
+	}
+	return c.HTTP.Do(req)
+}
+
+// StatusError describes an unexpected API server response, including the
+// message from its Status body if there is one.
+func StatusError(resp *http.Response) error {
+	var st struct {
+		Message string `json:"message"`
+	}
+	b, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	if json.Unmarshal(b, &st) == nil && st.Message != "" {
+		return fmt.Errorf("%s: %s", resp.Status, st.Message)
+	}
+	return errors.New(resp.Status)
+}
@@ -0,0 +1,347 @@
+package shared
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	mathrand "math/rand/v2"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/GoogleCloudPlatform/microservices-demo/src/shared/metrics"
+)
+
+var (
+	leaderIsLeader = metrics.NewGaugeVec("leader_election_is_leader",
+		"1 while this replica holds the named lease, 0 otherwise.", "name")
+	leaderTransitionsTotal = metrics.NewCounterVec("leader_election_transitions_total",
+		"Leadership changes seen by this replica, by name and event (acquired, lost).", "name", "event")
+)
+
+// LeaderLock is a lease a single holder at a time may own. Implementations
+// are NewKubernetesLeaseLock and, in package redis, NewLeaderLock.
+type LeaderLock interface {
+	// TryAcquire takes the lease for identity, or renews it if identity
+	// already holds it, for ttl. It reports false without error if another
+	// identity holds an unexpired lease.
+	TryAcquire(ctx context.Context, identity string, ttl time.Duration) (bool, error)
+	// Release gives up the lease if identity holds it.
+	Release(ctx context.Context, identity string) error
+}
+
+// LeaderElectionConfig tunes a LeaderElector. Load it with
+// LeaderElectionConfigFromEnv or fill it in directly.
+type LeaderElectionConfig struct {
+	// Name identifies the lease; replicas using the same name compete for it.
+	Name string `env:"LEADER_ELECTION_NAME"`
+	// Backend is "kubernetes", "redis" or "auto" (a Lease when running in a
+	// cluster, otherwise the "redis" backend registered with
+	// WithLeaderBackend, as redis.LeaderBackend does).
+	Backend string `env:"LEADER_ELECTION_BACKEND" default:"auto"`
+	// Namespace holds the Lease object. Empty uses the pod's namespace.
+	Namespace string `env:"POD_NAMESPACE"`
+	// Identity names this replica. Empty uses the hostname plus a random
+	// suffix.
+	Identity string `env:"POD_NAME"`
+	// LeaseDuration is how long other replicas wait after the last renewal
+	// before taking over.
+	LeaseDuration time.Duration `env:"LEADER_ELECTION_LEASE_DURATION" default:"15s"`
+	// RenewDeadline is how long the leader keeps trying to renew before it
+	// gives up leadership. It must be shorter than LeaseDuration.
+	RenewDeadline time.Duration `env:"LEADER_ELECTION_RENEW_DEADLINE" default:"10s"`
+	// RetryPeriod is the interval between acquire and renew attempts.
+	RetryPeriod time.Duration `env:"LEADER_ELECTION_RETRY_PERIOD" default:"2s"`
+}
+
+// LeaderElectionConfigFromEnv loads a LeaderElectionConfig with LoadConfig.
+func LeaderElectionConfigFromEnv() (LeaderElectionConfig, error) {
+	var cfg LeaderElectionConfig
+	err := LoadConfig(&cfg)
+	return cfg, err
+}
+
+func (c LeaderElectionConfig) validate() error {
+	switch {
+	case c.Name == "":
+		return errors.New("leader election: name is required")
+	case c.RetryPeriod <= 0:
+		return errors.New("leader election: retry period must be positive")
+	case c.RenewDeadline <= c.RetryPeriod:
+		return errors.New("leader election: renew deadline must be longer than the retry period")
+	case c.LeaseDuration <= c.RenewDeadline:
+		return errors.New("leader election: lease duration must be longer than the renew deadline")
+	}
+	return nil
+}
+
+// LeaderCallbacks are invoked as the elector gains and loses leadership.
+type LeaderCallbacks struct {
+	// OnStartedLeading runs in its own goroutine once the lease is acquired.
+	// ctx is cancelled when leadership is lost or the elector stops, and the
+	// lease is not released until the callback returns.
+	OnStartedLeading func(ctx context.Context)
+	// OnStoppedLeading runs after OnStartedLeading has returned.
+	OnStoppedLeading func()
+}
+
+type leaderConfig struct {
+	lock     LeaderLock
+	backends map[string]func(LeaderElectionConfig) (LeaderLock, error)
+	clock    Clock
+}
+
+// LeaderOption configures NewLeaderElector.
+type LeaderOption func(*leaderConfig)
+
+// WithLeaderLock uses lock instead of choosing one from the config's
+// Backend.
+func WithLeaderLock(lock LeaderLock) LeaderOption {
+	return func(c *leaderConfig) { c.lock = lock }
+}
+
+// WithLeaderBackend makes newLock build the lock for the backend called
+// name. It is how backends outside this package plug in, such as the
+// "redis" one "auto" falls back to outside Kubernetes, which
+// redis.LeaderBackend registers.
+func WithLeaderBackend(name string, newLock func(LeaderElectionConfig) (LeaderLock, error)) LeaderOption {
+	return func(c *leaderConfig) {
+		if c.backends == nil {
+			c.backends = make(map[string]func(LeaderElectionConfig) (LeaderLock, error))
+		}
+		c.backends[name] = newLock
+	}
+}
+
+// WithLeaderClock sets the clock timing renewals, for tests.
+func WithLeaderClock(clock Clock) LeaderOption {
+	return func(c *leaderConfig) { c.clock = clock }
+}
+
+// LeaderElector keeps at most one replica of a service leading at a time, so
+// background work such as the outbox relay or a Scheduler runs once per
+// deployment rather than once per pod.
+//
+// Usage:
+//
+//	cfg, err := shared.LeaderElectionConfigFromEnv()
+//	...
+//	le, err := shared.NewLeaderElector(cfg, shared.LeaderCallbacks{
+//	    OnStartedLeading: func(ctx context.Context) { relay.Run(ctx) },
+//	}, redis.LeaderBackend(rdb))
+//	...
+//	le.Start()
+//	le.RegisterShutdown(sm)
+//
+// Running in Kubernetes needs RBAC allowing get, create and update on
+// coordination.k8s.io leases in the pod's namespace.
+type LeaderElector struct {
+	cfg   LeaderElectionConfig
+	cb    LeaderCallbacks
+	lock  LeaderLock
+	clock Clock
+
+	leading atomic.Bool
+
+	mu      sync.Mutex
+	started bool
+	cancel  context.CancelFunc
+	done    chan struct{}
+}
+
+// NewLeaderElector validates cfg and picks its lock. It does not contact
+// the backend until Start.
+func NewLeaderElector(cfg LeaderElectionConfig, cb LeaderCallbacks, opts ...LeaderOption) (*LeaderElector, error) {
+	var lc leaderConfig
+	for _, opt := range opts {
+		opt(&lc)
+	}
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+	if cfg.Identity == "" {
+		cfg.Identity = leaderIdentity()
+	}
+	if lc.lock == nil {
+		lock, err := newLeaderLock(cfg, lc.backends)
+		if err != nil {
+			return nil, err
+		}
+		lc.lock = lock
+	}
+	return &LeaderElector{
+		cfg:   cfg,
+		cb:    cb,
+		lock:  lc.lock,
+		clock: clockOrReal(lc.clock),
+		done:  make(chan struct{}),
+	}, nil
+}
+
+// newLeaderLock builds the lock named by cfg.Backend.
+func newLeaderLock(cfg LeaderElectionConfig, backends map[string]func(LeaderElectionConfig) (LeaderLock, error)) (LeaderLock, error) {
+	backend := cfg.Backend
+	if backend == "" || backend == "auto" {
+		backend = "redis"
+		if InKubernetes() {
+			backend = "kubernetes"
+		}
+	}
+	if newLock, ok := backends[backend]; ok {
+		return newLock(cfg)
+	}
+	switch backend {
+	case "kubernetes":
+		return NewKubernetesLeaseLock(KubernetesLeaseConfig{Name: cfg.Name, Namespace: cfg.Namespace})
+	case "redis":
+		return nil, errors.New("leader election: redis backend needs redis.LeaderBackend")
+	default:
+		return nil, fmt.Errorf("leader election: unknown backend %q", cfg.Backend)
+	}
+}
+
+// leaderIdentity returns the hostname with a random suffix, so two
+// processes on one machine do not share an identity.
+func leaderIdentity() string {
+	host, _ := os.Hostname()
+	b := make([]byte, 4)
+	rand.Read(b)
+	return host + "_" + hex.EncodeToString(b)
+}
+
+// Identity returns the name this replica competes under.
+func (e *LeaderElector) Identity() string { return e.cfg.Identity }
+
+// IsLeader reports whether this replica currently holds the lease.
+func (e *LeaderElector) IsLeader() bool { return e.leading.Load() }
+
+// Start begins competing for the lease in the background. Calling it again
+// is a no-op.
+func (e *LeaderElector) Start() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.started {
+		return
+	}
+	e.started = true
+	ctx, cancel := context.WithCancel(context.Background())
+	e.cancel = cancel
+	go func() {
+		defer close(e.done)
+		for e.acquire(ctx) {
+			e.lead(ctx)
+		}
+	}()
+}
+
+// Stop ends leadership, waiting for OnStartedLeading to return and the
+// lease to be released, or for ctx to end.
+func (e *LeaderElector) Stop(ctx context.Context) error {
+	e.mu.Lock()
+	started := e.started
+	if started {
+		e.cancel()
+	}
+	e.mu.Unlock()
+	if !started {
+		return nil
+	}
+	select {
+	case <-e.done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("leader election: %q still leading: %w", e.cfg.Name, ctx.Err())
+	}
+}
+
+// RegisterShutdown registers Stop with m, so the lease is handed over as
+// soon as the pod begins terminating rather than after it expires.
+func (e *LeaderElector) RegisterShutdown(m *ShutdownManager) {
+	m.Register("leader-election", e.Stop)
+}
+
+// acquire retries TryAcquire every RetryPeriod, with jitter, until it
+// succeeds or ctx is done.
+func (e *LeaderElector) acquire(ctx context.Context) bool {
+	for ctx.Err() == nil {
+		ok, err := e.try(ctx)
+		if ok {
+			return true
+		}
+		if err != nil && ctx.Err() == nil {
+			log.Printf("Leader: Acquiring %q failed: %v", e.cfg.Name, err)
+		}
+		wait := e.cfg.RetryPeriod + mathrand.N(e.cfg.RetryPeriod/5+1)
+		e.clock.Sleep(ctx, wait)
+	}
+	return false
+}
+
+func (e *LeaderElector) try(ctx context.Context) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, e.cfg.RenewDeadline)
+	defer cancel()
+	return e.lock.TryAcquire(ctx, e.cfg.Identity, e.cfg.LeaseDuration)
+}
+
+// lead runs the callbacks and renews the lease until a renewal is refused,
+// renewals keep failing for RenewDeadline, or ctx is done.
+func (e *LeaderElector) lead(ctx context.Context) {
+	leaderCtx, cancel := context.WithCancel(ctx)
+	e.leading.Store(true)
+	leaderIsLeader.WithLabelValues(e.cfg.Name).Set(1)
+	leaderTransitionsTotal.WithLabelValues(e.cfg.Name, "acquired").Inc()
+	log.Printf("Leader: %s is now leading %q", e.cfg.Identity, e.cfg.Name)
+
+	var wg sync.WaitGroup
+	if e.cb.OnStartedLeading != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			e.cb.OnStartedLeading(leaderCtx)
+		}()
+	}
+
+	lastRenew := e.clock.Now()
+	for e.clock.Sleep(ctx, e.cfg.RetryPeriod) == nil {
+		ok, err := e.try(ctx)
+		if ok {
+			lastRenew = e.clock.Now()
+			continue
+		}
+		if err == nil {
+			log.Printf("Leader: Lost %q to another replica", e.cfg.Name)
+			break
+		}
+		if ctx.Err() != nil {
+			break
+		}
+		if e.clock.Now().Sub(lastRenew) >= e.cfg.RenewDeadline {
+			log.Printf("Leader: Could not renew %q within %v, stepping down: %v", e.cfg.Name, e.cfg.RenewDeadline, err)
+			break
+		}
+		log.Printf("Leader: Renewing %q failed: %v", e.cfg.Name, err)
+	}
+
+	cancel()
+	wg.Wait()
+	e.leading.Store(false)
+	leaderIsLeader.WithLabelValues(e.cfg.Name).Set(0)
+	leaderTransitionsTotal.WithLabelValues(e.cfg.Name, "lost").Inc()
+
+	if ctx.Err() != nil {
+		rctx, rcancel := context.WithTimeout(context.WithoutCancel(ctx), e.cfg.RenewDeadline)
+		if err := e.lock.Release(rctx, e.cfg.Identity); err != nil {
+			log.Printf("Leader: Releasing %q failed: %v", e.cfg.Name, err)
+		} else {
+			log.Printf("Leader: %s released %q", e.cfg.Identity, e.cfg.Name)
+		}
+		rcancel()
+	}
+	if e.cb.OnStoppedLeading != nil {
+		e.cb.OnStoppedLeading()
+	}
+}
@@ -0,0 +1,232 @@
+package shared
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/GoogleCloudPlatform/microservices-demo/src/shared/internal/kube"
+)
+
+// kubeMicroTime is the wire format of a Lease's acquire and renew times.
+const kubeMicroTime = "2006-01-02T15:04:05.000000Z07:00"
+
+// InKubernetes reports whether the process runs in a Kubernetes pod.
+func InKubernetes() bool {
+	return os.Getenv("KUBERNETES_SERVICE_HOST") != ""
+}
+
+// KubernetesLeaseConfig locates the Lease behind NewKubernetesLeaseLock.
+type KubernetesLeaseConfig struct {
+	// Name is the Lease object's name.
+	Name string
+	// Namespace holds the Lease. Empty uses the pod's namespace.
+	Namespace string
+	// APIServer is the API server URL. Empty uses the in-cluster address.
+	APIServer string
+	// TokenPath is the bearer token, re-read on every request so rotated
+	// tokens are picked up. Empty uses the service account token; set it to
+	// "-" to send no token.
+	TokenPath string
+	// Client makes the API calls. Nil trusts the service account CA.
+	Client *http.Client
+	// Clock times when the current holder's lease expires. Nil uses
+	// RealClock.
+	Clock Clock
+}
+
+// kubeLease is the part of a coordination.k8s.io/v1 Lease the lock uses.
+// Metadata is round-tripped untouched so updates keep the resourceVersion.
+type kubeLease struct {
+	APIVersion string          `json:"apiVersion"`
+	Kind       string          `json:"kind"`
+	Metadata   json.RawMessage `json:"metadata"`
+	Spec       kubeLeaseSpec   `json:"spec"`
+}
+
+type kubeLeaseSpec struct {
+	HolderIdentity       string `json:"holderIdentity,omitempty"`
+	LeaseDurationSeconds int    `json:"leaseDurationSeconds,omitempty"`
+	AcquireTime          string `json:"acquireTime,omitempty"`
+	RenewTime            string `json:"renewTime,omitempty"`
+	LeaseTransitions     int    `json:"leaseTransitions,omitempty"`
+}
+
+type kubernetesLeaseLock struct {
+	cfg  KubernetesLeaseConfig
+	kube kube.Client
+	path string
+
+	// The holder's lease is timed from when this replica last saw it
+	// change, not from its renewTime, so clock skew between nodes does not
+	// matter.
+	mu         sync.Mutex
+	observed   kubeLeaseSpec
+	observedAt time.Time
+}
+
+// NewKubernetesLeaseLock returns a LeaderLock backed by a
+// coordination.k8s.io/v1 Lease, the same object client-go's leader election
+// uses. Concurrent updates are resolved by the API server's optimistic
+// concurrency on resourceVersion.
+func NewKubernetesLeaseLock(cfg KubernetesLeaseConfig) (LeaderLock, error) {
+	if cfg.Name == "" {
+		return nil, errors.New("kubernetes lease: name is required")
+	}
+	client, err := kube.Client{APIServer: cfg.APIServer, TokenPath: cfg.TokenPath, HTTP: cfg.Client}.InCluster()
+	if err != nil {
+		return nil, fmt.Errorf("kubernetes lease: %w", err)
+	}
+	if cfg.Client == nil {
+		client.HTTP.Timeout = 10 * time.Second
+	}
+	if cfg.Namespace == "" {
+		if cfg.Namespace, err = kube.Namespace(); err != nil {
+			return nil, fmt.Errorf("kubernetes lease: %w", err)
+		}
+	}
+	cfg.Clock = clockOrReal(cfg.Clock)
+	path := fmt.Sprintf("/apis/coordination.k8s.io/v1/namespaces/%s/leases", cfg.Namespace)
+	return &kubernetesLeaseLock{cfg: cfg, kube: client, path: path}, nil
+}
+
+func (l *kubernetesLeaseLock) TryAcquire(ctx context.Context, identity string, ttl time.Duration) (bool, error) {
+	now := l.cfg.Clock.Now()
+	stamp := now.UTC().Format(kubeMicroTime)
+	seconds := int((ttl + time.Second - 1) / time.Second)
+
+	lease, err := l.get(ctx)
+	if err != nil {
+		return false, err
+	}
+	if lease == nil {
+		lease = &kubeLease{
+			APIVersion: "coordination.k8s.io/v1",
+			Kind:       "Lease",
+			Spec: kubeLeaseSpec{
+				HolderIdentity:       identity,
+				LeaseDurationSeconds: seconds,
+				AcquireTime:          stamp,
+				RenewTime:            stamp,
+			},
+		}
+		lease.Metadata, _ = json.Marshal(map[string]string{"name": l.cfg.Name, "namespace": l.cfg.Namespace})
+		return l.acquired(ctx, identity, http.MethodPost, l.path, lease)
+	}
+
+	spec := lease.Spec
+	if spec.HolderIdentity != identity && spec.HolderIdentity != "" && !l.expired(spec, now) {
+		return false, nil
+	}
+	if spec.HolderIdentity != identity {
+		spec.AcquireTime = stamp
+		spec.LeaseTransitions++
+	}
+	spec.HolderIdentity = identity
+	spec.LeaseDurationSeconds = seconds
+	spec.RenewTime = stamp
+	lease.Spec = spec
+	return l.acquired(ctx, identity, http.MethodPut, l.path+"/"+l.cfg.Name, lease)
+}
+
+// acquired writes lease for identity. When the write conflicts it re-reads
+// the Lease, since the change it lost to may be identity's own, such as a
+// renewal whose response never arrived: identity then still holds it.
+func (l *kubernetesLeaseLock) acquired(ctx context.Context, identity, method, path string, lease *kubeLease) (bool, error) {
+	ok, err := l.write(ctx, method, path, lease)
+	if ok || err != nil {
+		return ok, err
+	}
+	current, err := l.get(ctx)
+	if err != nil {
+		return false, err
+	}
+	return current != nil && current.Spec.HolderIdentity == identity, nil
+}
+
+func (l *kubernetesLeaseLock) Release(ctx context.Context, identity string) error {
+	lease, err := l.get(ctx)
+	if err != nil || lease == nil || lease.Spec.HolderIdentity != identity {
+		return err
+	}
+	// Keep the object so its transition count survives, but leave it free
+	// and expiring immediately.
+	lease.Spec.HolderIdentity = ""
+	lease.Spec.LeaseDurationSeconds = 1
+	lease.Spec.RenewTime = l.cfg.Clock.Now().UTC().Format(kubeMicroTime)
+	_, err = l.write(ctx, http.MethodPut, l.path+"/"+l.cfg.Name, lease)
+	return err
+}
+
+// expired reports whether spec's holder has gone a full lease without
+// renewing, as observed by this replica.
+func (l *kubernetesLeaseLock) expired(spec kubeLeaseSpec, now time.Time) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if spec != l.observed {
+		l.observed = spec
+		l.observedAt = now
+	}
+	return !now.Before(l.observedAt.Add(time.Duration(spec.LeaseDurationSeconds) * time.Second))
+}
+
+// get returns the Lease, or nil if it does not exist yet.
+func (l *kubernetesLeaseLock) get(ctx context.Context) (*kubeLease, error) {
+	resp, err := l.do(ctx, http.MethodGet, l.path+"/"+l.cfg.Name, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+		var lease kubeLease
+		if err := json.NewDecoder(resp.Body).Decode(&lease); err != nil {
+			return nil, fmt.Errorf("kubernetes lease: decoding %s: %w", l.cfg.Name, err)
+		}
+		return &lease, nil
+	case http.StatusNotFound:
+		return nil, nil
+	default:
+		return nil, kubeStatusError(resp)
+	}
+}
+
+// write creates or updates the Lease, reporting false if another replica
+// changed it first.
+func (l *kubernetesLeaseLock) write(ctx context.Context, method, path string, lease *kubeLease) (bool, error) {
+	body, err := json.Marshal(lease)
+	if err != nil {
+		return false, err
+	}
+	resp, err := l.do(ctx, method, path, body)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusCreated:
+		return true, nil
+	case http.StatusConflict:
+		return false, nil
+	default:
+		return false, kubeStatusError(resp)
+	}
+}
+
+func (l *kubernetesLeaseLock) do(ctx context.Context, method, path string, body []byte) (*http.Response, error) {
+	resp, err := l.kube.Do(ctx, method, path, body)
+	if err != nil {
+		return nil, fmt.Errorf("kubernetes lease: %w", err)
+	}
+	return resp, nil
+}
+
+// kubeStatusError describes an unexpected API server response.
+func kubeStatusError(resp *http.Response) error {
+	return fmt.Errorf("kubernetes lease: %w", kube.StatusError(resp))
+}
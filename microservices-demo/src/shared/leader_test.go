@@ -0,0 +1,306 @@
+package shared
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// memLeaderLock is a LeaderLock shared by the electors in a test.
+type memLeaderLock struct {
+	mu     sync.Mutex
+	holder string
+	fail   error
+}
+
+func (l *memLeaderLock) TryAcquire(ctx context.Context, identity string, ttl time.Duration) (bool, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.fail != nil {
+		return false, l.fail
+	}
+	if l.holder != "" && l.holder != identity {
+		return false, nil
+	}
+	l.holder = identity
+	return true, nil
+}
+
+func (l *memLeaderLock) Release(ctx context.Context, identity string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.holder == identity {
+		l.holder = ""
+	}
+	return nil
+}
+
+func (l *memLeaderLock) setFail(err error) {
+	l.mu.Lock()
+	l.fail = err
+	l.mu.Unlock()
+}
+
+func testLeaderConfig(identity string) LeaderElectionConfig {
+	return LeaderElectionConfig{
+		Name:          "test",
+		Identity:      identity,
+		LeaseDuration: 15 * time.Second,
+		RenewDeadline: 10 * time.Second,
+		RetryPeriod:   2 * time.Second,
+	}
+}
+
+func TestLeaderElectorLeadsAndResigns(t *testing.T) {
+	lock := &memLeaderLock{}
+	started := make(chan struct{})
+	stopped := make(chan struct{})
+	le, err := NewLeaderElector(testLeaderConfig("a"), LeaderCallbacks{
+		OnStartedLeading: func(ctx context.Context) {
+			close(started)
+			<-ctx.Done()
+		},
+		OnStoppedLeading: func() { close(stopped) },
+	}, WithLeaderLock(lock))
+	if err != nil {
+		t.Fatal(err)
+	}
+	le.Start()
+	select {
+	case <-started:
+	case <-time.After(5 * time.Second):
+		t.Fatal("OnStartedLeading not called")
+	}
+	if !le.IsLeader() {
+		t.Error("IsLeader = false while leading")
+	}
+
+	if err := le.Stop(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	<-stopped
+	if le.IsLeader() {
+		t.Error("IsLeader = true after Stop")
+	}
+	if lock.holder != "" {
+		t.Errorf("lease still held by %q after Stop", lock.holder)
+	}
+}
+
+func TestLeaderElectorOnlyOneLeads(t *testing.T) {
+	lock := &memLeaderLock{holder: "other"}
+	clk := NewFakeClock(time.Unix(0, 0))
+	started := make(chan struct{}, 1)
+	le, err := NewLeaderElector(testLeaderConfig("a"), LeaderCallbacks{
+		OnStartedLeading: func(ctx context.Context) { started <- struct{}{} },
+	}, WithLeaderLock(lock), WithLeaderClock(clk))
+	if err != nil {
+		t.Fatal(err)
+	}
+	le.Start()
+	defer le.Stop(context.Background())
+
+	clk.BlockUntil(1)
+	if le.IsLeader() {
+		t.Fatal("became leader while another replica holds the lease")
+	}
+	lock.Release(context.Background(), "other")
+	clk.Advance(3 * time.Second)
+	select {
+	case <-started:
+	case <-time.After(5 * time.Second):
+		t.Fatal("did not take over the released lease")
+	}
+}
+
+func TestLeaderElectorStepsDownAfterRenewDeadline(t *testing.T) {
+	lock := &memLeaderLock{}
+	clk := NewFakeClock(time.Unix(0, 0))
+	lost := make(chan struct{})
+	le, err := NewLeaderElector(testLeaderConfig("a"), LeaderCallbacks{
+		OnStartedLeading: func(ctx context.Context) { <-ctx.Done() },
+		OnStoppedLeading: func() { close(lost) },
+	}, WithLeaderLock(lock), WithLeaderClock(clk))
+	if err != nil {
+		t.Fatal(err)
+	}
+	le.Start()
+	defer le.Stop(context.Background())
+
+	clk.BlockUntil(1)
+	if !le.IsLeader() {
+		t.Fatal("not leading")
+	}
+	lock.setFail(errors.New("backend down"))
+	for range 4 { // 8s: failures within the deadline keep leadership
+		clk.Advance(2 * time.Second)
+		clk.BlockUntil(1)
+	}
+	if !le.IsLeader() {
+		t.Fatal("stepped down before the renew deadline")
+	}
+	clk.Advance(2 * time.Second)
+	select {
+	case <-lost:
+	case <-time.After(5 * time.Second):
+		t.Fatal("still leading after the renew deadline")
+	}
+}
+
+func TestLeaderElectionConfigValidation(t *testing.T) {
+	cfg := testLeaderConfig("a")
+	cfg.RenewDeadline = cfg.LeaseDuration
+	if _, err := NewLeaderElector(cfg, LeaderCallbacks{}, WithLeaderLock(&memLeaderLock{})); err == nil {
+		t.Error("renew deadline equal to lease duration accepted")
+	}
+	cfg = testLeaderConfig("a")
+	cfg.Backend = "redis"
+	if _, err := NewLeaderElector(cfg, LeaderCallbacks{}); err == nil {
+		t.Error("redis backend without a client accepted")
+	}
+	lock := &memLeaderLock{}
+	le, err := NewLeaderElector(cfg, LeaderCallbacks{}, WithLeaderBackend("redis", func(c LeaderElectionConfig) (LeaderLock, error) {
+		if c.Name != cfg.Name {
+			t.Errorf("backend got name %q, want %q", c.Name, cfg.Name)
+		}
+		return lock, nil
+	}))
+	if err != nil || le.lock != lock {
+		t.Errorf("registered redis backend not used: %v", err)
+	}
+}
+
+// fakeLeaseServer serves a single Lease with resourceVersion checks.
+type fakeLeaseServer struct {
+	mu      sync.Mutex
+	lease   *kubeLease
+	version int
+}
+
+func (s *fakeLeaseServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	const base = "/apis/coordination.k8s.io/v1/namespaces/ns/leases"
+	switch {
+	case r.Method == http.MethodGet && r.URL.Path == base+"/lock":
+		if s.lease == nil {
+			http.Error(w, `{"message":"not found"}`, http.StatusNotFound)
+			return
+		}
+		json.NewEncoder(w).Encode(s.lease)
+	case r.Method == http.MethodPost && r.URL.Path == base:
+		if s.lease != nil {
+			w.WriteHeader(http.StatusConflict)
+			return
+		}
+		s.store(w, r, http.StatusCreated)
+	case r.Method == http.MethodPut && r.URL.Path == base+"/lock":
+		var in kubeLease
+		json.NewDecoder(r.Body).Decode(&in)
+		var meta struct {
+			ResourceVersion string `json:"resourceVersion"`
+		}
+		json.Unmarshal(in.Metadata, &meta)
+		if meta.ResourceVersion != strconv.Itoa(s.version) {
+			w.WriteHeader(http.StatusConflict)
+			return
+		}
+		s.lease.Spec = in.Spec
+		s.version++
+		s.lease.Metadata, _ = json.Marshal(map[string]string{"name": "lock", "resourceVersion": strconv.Itoa(s.version)})
+		json.NewEncoder(w).Encode(s.lease)
+	default:
+		http.Error(w, `{"message":"unexpected"}`, http.StatusBadRequest)
+	}
+}
+
+func (s *fakeLeaseServer) store(w http.ResponseWriter, r *http.Request, code int) {
+	var in kubeLease
+	json.NewDecoder(r.Body).Decode(&in)
+	s.version++
+	in.Metadata, _ = json.Marshal(map[string]string{"name": "lock", "resourceVersion": strconv.Itoa(s.version)})
+	s.lease = &in
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(s.lease)
+}
+
+func TestKubernetesLeaseLock(t *testing.T) {
+	fake := &fakeLeaseServer{}
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+	clk := NewFakeClock(time.Unix(1000, 0))
+	newLock := func() LeaderLock {
+		l, err := NewKubernetesLeaseLock(KubernetesLeaseConfig{
+			Name: "lock", Namespace: "ns", APIServer: srv.URL, TokenPath: "-", Client: srv.Client(), Clock: clk,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return l
+	}
+	a, b := newLock(), newLock()
+	ctx := context.Background()
+
+	if ok, err := a.TryAcquire(ctx, "a", 10*time.Second); !ok || err != nil {
+		t.Fatalf("a create = %v, %v", ok, err)
+	}
+	if ok, err := a.TryAcquire(ctx, "a", 10*time.Second); !ok || err != nil {
+		t.Fatalf("a renew = %v, %v", ok, err)
+	}
+	if ok, err := b.TryAcquire(ctx, "b", 10*time.Second); ok || err != nil {
+		t.Fatalf("b while a holds = %v, %v", ok, err)
+	}
+
+	// b takes over once a has not renewed for a full lease as b sees it.
+	clk.Advance(10 * time.Second)
+	if ok, err := b.TryAcquire(ctx, "b", 10*time.Second); !ok || err != nil {
+		t.Fatalf("b after expiry = %v, %v", ok, err)
+	}
+	if got := fake.lease.Spec.LeaseTransitions; got != 1 {
+		t.Errorf("leaseTransitions = %d, want 1", got)
+	}
+
+	if err := a.Release(ctx, "a"); err != nil {
+		t.Fatal(err)
+	}
+	if fake.lease.Spec.HolderIdentity != "b" {
+		t.Errorf("release by a non-holder changed the holder to %q", fake.lease.Spec.HolderIdentity)
+	}
+	if err := b.Release(ctx, "b"); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := a.TryAcquire(ctx, "a", 10*time.Second); !ok || err != nil {
+		t.Fatalf("a after release = %v, %v", ok, err)
+	}
+}
+
+func TestKubernetesLeaseLockConflictWhileHolding(t *testing.T) {
+	fake := &fakeLeaseServer{}
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+	l, err := NewKubernetesLeaseLock(KubernetesLeaseConfig{
+		Name: "lock", Namespace: "ns", APIServer: srv.URL, TokenPath: "-", Client: srv.Client(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	if ok, err := l.TryAcquire(ctx, "a", 10*time.Second); !ok || err != nil {
+		t.Fatalf("create = %v, %v", ok, err)
+	}
+
+	// A write the lock never saw the response to moves the resourceVersion
+	// on, so the renewal conflicts even though a still holds the lease.
+	fake.mu.Lock()
+	fake.version++
+	fake.mu.Unlock()
+	if ok, err := l.TryAcquire(ctx, "a", 10*time.Second); !ok || err != nil {
+		t.Errorf("renew after conflict = %v, %v; want true, nil", ok, err)
+	}
+}
@@ -0,0 +1,96 @@
+package loadgen
+
+import (
+	"context"
+	"fmt"
+)
+
+// Shop is the part of the demo's API the demo scenarios call. Implement it
+// over the generated clients of the services under test:
+//
+//	type shop struct {
+//	    catalog  pb.ProductCatalogServiceClient
+//	    cart     pb.CartServiceClient
+//	    checkout pb.CheckoutServiceClient
+//	}
+//
+//	func (s shop) GetProduct(ctx context.Context, id string) error {
+//	    _, err := s.catalog.GetProduct(ctx, &pb.GetProductRequest{Id: id})
+//	    return err
+//	}
+//	...
+type Shop interface {
+	ListProducts(ctx context.Context) ([]string, error)
+	GetProduct(ctx context.Context, id string) error
+	AddToCart(ctx context.Context, userID, productID string, quantity int32) error
+	GetCart(ctx context.Context, userID string) error
+	PlaceOrder(ctx context.Context, userID, currency string) error
+}
+
+// DemoProducts are the product IDs of the demo catalog, used until
+// ListProducts has returned the real ones.
+var DemoProducts = []string{
+	"0PUK6V6EV0", "1YMWWN1N4O", "2ZYFJ3GM2N", "66VCHSJNUP", "6E92ZMYYFZ",
+	"9SIQT8TOJO", "L9ECAV7KIM", "LS4PSXUNUM", "OLJCESPC7Z",
+}
+
+// DemoCurrencies are the currencies orders are placed in.
+var DemoCurrencies = []string{"EUR", "USD", "JPY", "CAD", "GBP", "TRY"}
+
+// Demo scenario names.
+const (
+	ScenarioBrowse    = "browse"
+	ScenarioAddToCart = "add-to-cart"
+	ScenarioViewCart  = "view-cart"
+	ScenarioCheckout  = "checkout"
+)
+
+// DemoScenarios returns the demo's traffic mix, weighted as its Locust
+// load generator is: mostly browsing, some carts and the odd checkout.
+func DemoScenarios(shop Shop) []Scenario {
+	list := Step{Name: "ListProducts", Run: func(ctx context.Context, s *Session) error {
+		ids, err := shop.ListProducts(ctx)
+		if err == nil && len(ids) > 0 {
+			s.Values["products"] = ids
+		}
+		return err
+	}}
+	get := Step{Name: "GetProduct", Run: func(ctx context.Context, s *Session) error {
+		id := pickProduct(s)
+		s.Values["product"] = id
+		return shop.GetProduct(ctx, id)
+	}}
+	add := Step{Name: "AddToCart", Run: func(ctx context.Context, s *Session) error {
+		id, _ := s.Values["product"].(string)
+		if id == "" {
+			id = pickProduct(s)
+		}
+		return shop.AddToCart(ctx, userID(s), id, 1+s.Rand.Int32N(10))
+	}}
+	cart := Step{Name: "GetCart", Run: func(ctx context.Context, s *Session) error {
+		return shop.GetCart(ctx, userID(s))
+	}}
+	order := Step{Name: "PlaceOrder", Run: func(ctx context.Context, s *Session) error {
+		return shop.PlaceOrder(ctx, userID(s), DemoCurrencies[s.Rand.IntN(len(DemoCurrencies))])
+	}}
+	return []Scenario{
+		{Name: ScenarioBrowse, Weight: 10, Steps: []Step{list, get}},
+		{Name: ScenarioAddToCart, Weight: 2, Steps: []Step{get, add}},
+		{Name: ScenarioViewCart, Weight: 3, Steps: []Step{cart}},
+		{Name: ScenarioCheckout, Weight: 1, Steps: []Step{get, add, cart, order}},
+	}
+}
+
+func pickProduct(s *Session) string {
+	ids, ok := s.Values["products"].([]string)
+	if !ok {
+		ids = DemoProducts
+	}
+	return ids[s.Rand.IntN(len(ids))]
+}
+
+// userID returns the session's user, the same across its iterations so
+// carts fill up before checkout.
+func userID(s *Session) string {
+	return fmt.Sprintf("loadgen-user-%d", s.ID)
+}
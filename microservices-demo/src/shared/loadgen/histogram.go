@@ -0,0 +1,98 @@
+package loadgen
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// histogramGrowth is the ratio between consecutive bucket bounds: quantiles
+// are accurate to within 2%.
+const histogramGrowth = 1.02
+
+var logGrowth = math.Log(histogramGrowth)
+
+// Histogram records latencies in logarithmic buckets, in constant memory
+// however long the run. It is safe for concurrent use.
+type Histogram struct {
+	mu       sync.Mutex
+	buckets  []uint64 // bucket i holds [1µs·g^i, 1µs·g^(i+1))
+	count    uint64
+	sum, max time.Duration
+	min      time.Duration
+}
+
+// Record adds one latency.
+func (h *Histogram) Record(d time.Duration) {
+	i := 0
+	if d > time.Microsecond {
+		i = int(math.Log(float64(d)/float64(time.Microsecond)) / logGrowth)
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if i >= len(h.buckets) {
+		h.buckets = append(h.buckets, make([]uint64, i+1-len(h.buckets))...)
+	}
+	h.buckets[i]++
+	if h.count == 0 || d < h.min {
+		h.min = d
+	}
+	h.count++
+	h.sum += d
+	h.max = max(h.max, d)
+}
+
+// Count returns the number of latencies recorded.
+func (h *Histogram) Count() uint64 {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.count
+}
+
+// Quantile returns the latency below which a fraction q of those recorded
+// fall, e.g. 0.99 for the p99.
+func (h *Histogram) Quantile(q float64) time.Duration {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.quantile(q)
+}
+
+func (h *Histogram) quantile(q float64) time.Duration {
+	if h.count == 0 {
+		return 0
+	}
+	rank := uint64(math.Ceil(q * float64(h.count)))
+	var seen uint64
+	for i, n := range h.buckets {
+		if seen += n; seen >= max(rank, 1) {
+			// The bucket's midpoint, clamped to what was seen.
+			d := time.Duration(float64(time.Microsecond) * math.Pow(histogramGrowth, float64(i)+0.5))
+			return min(max(d, h.min), h.max)
+		}
+	}
+	return h.max
+}
+
+// Summary is a snapshot of a Histogram.
+type Summary struct {
+	Count uint64        `json:"count"`
+	Mean  time.Duration `json:"mean"`
+	Min   time.Duration `json:"min"`
+	P50   time.Duration `json:"p50"`
+	P90   time.Duration `json:"p90"`
+	P99   time.Duration `json:"p99"`
+	P999  time.Duration `json:"p999"`
+	Max   time.Duration `json:"max"`
+}
+
+// Summary returns the histogram's count, mean and quantiles.
+func (h *Histogram) Summary() Summary {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	s := Summary{Count: h.count, Min: h.min, Max: h.max,
+		P50: h.quantile(0.5), P90: h.quantile(0.9), P99: h.quantile(0.99), P999: h.quantile(0.999)}
+	if h.count > 0 {
+		s.Mean = h.sum / time.Duration(h.count)
+	}
+	return s
+}
@@ -0,0 +1,323 @@
+// Package loadgen drives services with scenario-based traffic from Go, for
+// soak tests, coverage runs and benchmarks that would otherwise need
+// external Locust scripts.
+//
+// A Scenario is a named sequence of steps, such as browsing a product or
+// checking out, and a run mixes scenarios by weight. Load follows a
+// Profile of ramp stages in one of two models:
+//
+//   - closed loop (WithClosedLoop): a number of virtual users, each running
+//     scenarios one after another with a think time in between, as Locust
+//     does. Throughput drops when the service slows down.
+//   - open loop (WithOpenLoop): scenarios start at a rate regardless of how
+//     many are still running, as real traffic does, so a slow service shows
+//     up as growing latency instead of lower throughput.
+//
+// Every step's latency goes into a Histogram and the
+// loadgen_step_duration_seconds metric; Run returns them as a Report:
+//
+//	shop := myShop{catalog: pb.NewProductCatalogServiceClient(conn), ...}
+//	gen := loadgen.New(loadgen.DemoScenarios(shop),
+//	    loadgen.WithClosedLoop(loadgen.Ramp(50, time.Minute, 30*time.Minute, time.Minute), loadgen.Think(time.Second, 3*time.Second)))
+//	report, err := gen.Run(ctx)
+//	...
+//	report.WriteText(os.Stdout)
+package loadgen
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"math"
+	"math/rand/v2"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"google.golang.org/grpc/status"
+
+	"github.com/GoogleCloudPlatform/microservices-demo/src/shared/metrics"
+)
+
+var stepDuration = metrics.NewHistogramVec("loadgen_step_duration_seconds",
+	"Latency of load generator steps, by step and result (ok or a gRPC code).", nil, "step", "result")
+
+// tick is how often the load is adjusted to the profile.
+const tick = 10 * time.Millisecond
+
+// Step is one action of a scenario, typically one RPC.
+type Step struct {
+	Name string
+	Run  func(ctx context.Context, s *Session) error
+}
+
+// Scenario is a sequence of steps run in order. A failing step ends the
+// iteration.
+type Scenario struct {
+	Name string
+	// Weight is the scenario's share of the mix, relative to the others'.
+	Weight int
+	Steps  []Step
+}
+
+// Session is the state of one virtual user, kept across its iterations in
+// the closed model and new for every arrival in the open one.
+type Session struct {
+	// ID numbers the session within the run.
+	ID int
+	// Rand is the session's random source, seeded from the run's seed so
+	// runs repeat the same choices.
+	Rand *rand.Rand
+	// Values holds what the steps share, such as the products added to the
+	// cart.
+	Values map[string]any
+}
+
+// Option configures New.
+type Option func(*Generator)
+
+// ThinkTime is the pause of a closed-loop user between iterations, drawn
+// uniformly from [Min, Max].
+type ThinkTime struct{ Min, Max time.Duration }
+
+// Think returns a ThinkTime between lo and hi.
+func Think(lo, hi time.Duration) ThinkTime { return ThinkTime{Min: lo, Max: max(lo, hi)} }
+
+// WithClosedLoop runs as many virtual users as profile targets, each
+// pausing for think between iterations.
+func WithClosedLoop(profile Profile, think ThinkTime) Option {
+	return func(g *Generator) { g.profile, g.open, g.think = profile, false, think }
+}
+
+// WithOpenLoop starts as many iterations per second as profile targets,
+// with at most maxInFlight running at once (0 means no limit); arrivals
+// beyond it are dropped and counted, as a saturated client would lose them.
+func WithOpenLoop(profile Profile, maxInFlight int) Option {
+	return func(g *Generator) { g.profile, g.open, g.maxInFlight = profile, true, maxInFlight }
+}
+
+// WithSeed seeds the sessions' random sources (default 1).
+func WithSeed(seed uint64) Option {
+	return func(g *Generator) { g.seed = seed }
+}
+
+// WithLogger sets the logger of the progress lines. The default is
+// slog.Default().
+func WithLogger(l *slog.Logger) Option {
+	return func(g *Generator) { g.log = l }
+}
+
+// WithProgressInterval logs progress every d (default 10s); zero disables
+// it.
+func WithProgressInterval(d time.Duration) Option {
+	return func(g *Generator) { g.progress = d }
+}
+
+// Generator runs scenarios against a service.
+type Generator struct {
+	scenarios   []Scenario
+	totalWeight int
+	profile     Profile
+	open        bool
+	think       ThinkTime
+	maxInFlight int
+	seed        uint64
+	log         *slog.Logger
+	progress    time.Duration
+}
+
+// New returns a Generator of scenarios, running one closed-loop user for a
+// minute unless configured otherwise.
+func New(scenarios []Scenario, opts ...Option) *Generator {
+	g := &Generator{
+		scenarios: scenarios,
+		profile:   Constant(1, time.Minute),
+		seed:      1,
+		log:       slog.Default(),
+		progress:  10 * time.Second,
+	}
+	for _, opt := range opts {
+		opt(g)
+	}
+	for _, sc := range scenarios {
+		g.totalWeight += max(sc.Weight, 0)
+	}
+	return g
+}
+
+// run is the state of one Run.
+type run struct {
+	g        *Generator
+	ctx      context.Context
+	report   *Report
+	sessions atomic.Int64
+	inFlight atomic.Int64
+	wg       sync.WaitGroup
+}
+
+// Run generates load until the profile ends or ctx is done, then waits for
+// the iterations in flight and returns the report.
+func (g *Generator) Run(ctx context.Context) (*Report, error) {
+	if g.totalWeight == 0 {
+		return nil, errors.New("loadgen: no scenario with a positive weight")
+	}
+	r := &run{g: g, ctx: ctx, report: newReport()}
+	start := time.Now()
+	ticker := time.NewTicker(tick)
+	defer ticker.Stop()
+	var progress <-chan time.Time
+	if g.progress > 0 {
+		t := time.NewTicker(g.progress)
+		defer t.Stop()
+		progress = t.C
+	}
+
+	var users []chan struct{} // closed to stop a user, newest last
+	arrivals, last := 0.0, start
+loop:
+	for {
+		now := time.Now()
+		target, ok := g.profile.At(now.Sub(start))
+		if !ok {
+			break
+		}
+		if g.open {
+			arrivals += target * now.Sub(last).Seconds()
+			for ; arrivals >= 1; arrivals-- {
+				r.arrive()
+			}
+		} else {
+			want := int(math.Round(target))
+			for len(users) < want {
+				stop := make(chan struct{})
+				users = append(users, stop)
+				r.startUser(stop)
+			}
+			for len(users) > want {
+				close(users[len(users)-1])
+				users = users[:len(users)-1]
+			}
+		}
+		last = now
+		select {
+		case <-ctx.Done():
+			break loop
+		case <-progress:
+			r.logProgress(now.Sub(start), len(users))
+		case <-ticker.C:
+		}
+	}
+	for _, stop := range users {
+		close(stop)
+	}
+	r.wg.Wait()
+	r.report.Duration = time.Since(start)
+	return r.report, ctx.Err()
+}
+
+// newSession returns the next session, seeded from its number.
+func (r *run) newSession() *Session {
+	id := int(r.sessions.Add(1))
+	return &Session{ID: id, Rand: rand.New(rand.NewPCG(r.g.seed, uint64(id))), Values: make(map[string]any)}
+}
+
+// arrive starts one open-loop iteration.
+func (r *run) arrive() {
+	if r.g.maxInFlight > 0 && r.inFlight.Load() >= int64(r.g.maxInFlight) {
+		r.report.dropped.Add(1)
+		return
+	}
+	r.inFlight.Add(1)
+	r.wg.Add(1)
+	go func() {
+		defer r.wg.Done()
+		defer r.inFlight.Add(-1)
+		r.iterate(r.newSession())
+	}()
+}
+
+// startUser starts a closed-loop user, which finishes its iteration after
+// stop is closed.
+func (r *run) startUser(stop <-chan struct{}) {
+	r.wg.Add(1)
+	go func() {
+		defer r.wg.Done()
+		s := r.newSession()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-r.ctx.Done():
+				return
+			default:
+			}
+			r.iterate(s)
+			think := r.g.think.Min
+			if span := r.g.think.Max - r.g.think.Min; span > 0 {
+				think += time.Duration(s.Rand.Int64N(int64(span)))
+			}
+			if think > 0 {
+				t := time.NewTimer(think)
+				select {
+				case <-stop:
+					t.Stop()
+					return
+				case <-r.ctx.Done():
+					t.Stop()
+					return
+				case <-t.C:
+				}
+			}
+		}
+	}()
+}
+
+// iterate runs one scenario picked by weight.
+func (r *run) iterate(s *Session) {
+	sc := r.g.pick(s.Rand)
+	start := time.Now()
+	var err error
+	for _, step := range sc.Steps {
+		stepStart := time.Now()
+		err = step.Run(r.ctx, s)
+		d := time.Since(stepStart)
+		metrics.Observe(r.ctx, stepDuration.WithLabelValues(step.Name, result(err)), d.Seconds())
+		r.report.step(step.Name).record(d, err)
+		if err != nil {
+			break
+		}
+	}
+	d := time.Since(start)
+	r.report.scenario(sc.Name).record(d, err)
+	r.report.total.record(d, err)
+}
+
+func (g *Generator) pick(rng *rand.Rand) *Scenario {
+	n := rng.IntN(g.totalWeight)
+	for i := range g.scenarios {
+		if n -= max(g.scenarios[i].Weight, 0); n < 0 {
+			return &g.scenarios[i]
+		}
+	}
+	return &g.scenarios[len(g.scenarios)-1]
+}
+
+func (r *run) logProgress(elapsed time.Duration, users int) {
+	total := r.report.scenarioTotal()
+	attrs := []any{"elapsed", elapsed.Round(time.Second), "iterations", total.Count(),
+		"p99", total.Quantile(0.99), "errors", r.report.errorCount()}
+	if r.g.open {
+		attrs = append(attrs, "in_flight", r.inFlight.Load(), "dropped", r.report.dropped.Load())
+	} else {
+		attrs = append(attrs, "users", users)
+	}
+	r.g.log.Info("load generation progress", attrs...)
+}
+
+// result labels err: "ok", or its gRPC code.
+func result(err error) string {
+	if err == nil {
+		return "ok"
+	}
+	return status.Code(err).String()
+}
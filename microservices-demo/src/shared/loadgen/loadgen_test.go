@@ -0,0 +1,186 @@
+package loadgen
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log/slog"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestProfile(t *testing.T) {
+	p := Ramp(100, time.Second, 2*time.Second, time.Second)
+	for _, tc := range []struct {
+		at   time.Duration
+		want float64
+		ok   bool
+	}{
+		{0, 0, true},
+		{500 * time.Millisecond, 50, true},
+		{2 * time.Second, 100, true},
+		{3500 * time.Millisecond, 50, true},
+		{4 * time.Second, 0, false},
+	} {
+		if got, ok := p.At(tc.at); got != tc.want || ok != tc.ok {
+			t.Errorf("At(%s) = %v, %v, want %v, %v", tc.at, got, ok, tc.want, tc.ok)
+		}
+	}
+	if got, _ := Constant(5, time.Second).At(0); got != 5 {
+		t.Errorf("Constant starts at %v, want 5", got)
+	}
+	if d := p.Duration(); d != 4*time.Second {
+		t.Errorf("Duration = %s", d)
+	}
+}
+
+func TestHistogram(t *testing.T) {
+	var h Histogram
+	for i := 1; i <= 1000; i++ {
+		h.Record(time.Duration(i) * time.Millisecond)
+	}
+	s := h.Summary()
+	for _, q := range []struct {
+		got, want time.Duration
+	}{{s.P50, 500 * time.Millisecond}, {s.P90, 900 * time.Millisecond}, {s.P99, 990 * time.Millisecond}} {
+		if diff := float64(q.got-q.want) / float64(q.want); diff < -0.02 || diff > 0.02 {
+			t.Errorf("quantile = %s, want %s within 2%%", q.got, q.want)
+		}
+	}
+	if s.Count != 1000 || s.Min != time.Millisecond || s.Max != time.Second || s.Mean != 500500*time.Microsecond {
+		t.Errorf("Summary = %+v", s)
+	}
+	var empty Histogram
+	if empty.Quantile(0.5) != 0 {
+		t.Error("empty histogram has a quantile")
+	}
+}
+
+// fakeShop counts calls and fails GetCart with Unavailable.
+type fakeShop struct {
+	mu    sync.Mutex
+	calls map[string]int
+	users map[string]bool
+}
+
+func (f *fakeShop) call(name string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.calls == nil {
+		f.calls = make(map[string]int)
+	}
+	f.calls[name]++
+}
+
+func (f *fakeShop) ListProducts(context.Context) ([]string, error) {
+	f.call("ListProducts")
+	return []string{"A", "B"}, nil
+}
+func (f *fakeShop) GetProduct(context.Context, string) error {
+	f.call("GetProduct")
+	return nil
+}
+func (f *fakeShop) AddToCart(_ context.Context, user, _ string, _ int32) error {
+	f.call("AddToCart")
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.users == nil {
+		f.users = make(map[string]bool)
+	}
+	f.users[user] = true
+	return nil
+}
+func (f *fakeShop) GetCart(context.Context, string) error {
+	f.call("GetCart")
+	return status.Error(codes.Unavailable, "cart down")
+}
+func (f *fakeShop) PlaceOrder(context.Context, string, string) error {
+	f.call("PlaceOrder")
+	return nil
+}
+
+var quiet = WithLogger(slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+func TestClosedLoop(t *testing.T) {
+	shop := &fakeShop{}
+	gen := New(DemoScenarios(shop), WithClosedLoop(Constant(3, 200*time.Millisecond), Think(0, time.Millisecond)), quiet)
+	report, err := gen.Run(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(shop.users) > 3 {
+		t.Errorf("%d users added to carts, want at most 3", len(shop.users))
+	}
+	browse, view := report.Scenario(ScenarioBrowse), report.Scenario(ScenarioViewCart)
+	if browse == nil || view == nil || browse.Count() <= view.Count() {
+		t.Fatalf("browse ran less than view-cart: %v, %v", browse, view)
+	}
+	// GetCart always fails, so checkouts never place their order.
+	if shop.calls["PlaceOrder"] != 0 {
+		t.Errorf("PlaceOrder ran after a failed step")
+	}
+	if errs := report.Step("GetCart").Errors(); errs["Unavailable"] != int(report.Step("GetCart").Count()) {
+		t.Errorf("GetCart errors = %v of %d", errs, report.Step("GetCart").Count())
+	}
+	var buf bytes.Buffer
+	if err := report.WriteText(&buf); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"GetProduct", "checkout", "p99"} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("report lacks %q:\n%s", want, buf.String())
+		}
+	}
+}
+
+func TestOpenLoop(t *testing.T) {
+	view := []Scenario{{Name: "view", Weight: 1, Steps: []Step{{Name: "noop", Run: func(context.Context, *Session) error { return nil }}}}}
+	report, err := New(view, WithOpenLoop(Constant(200, 250*time.Millisecond), 0), quiet).Run(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 200/s for 250ms, give or take a tick.
+	if n := report.Step("noop").Count(); n < 40 || n > 55 {
+		t.Errorf("%d arrivals, want about 50", n)
+	}
+}
+
+func TestOpenLoopDropsAtLimit(t *testing.T) {
+	release := make(chan struct{})
+	block := []Scenario{{Name: "block", Weight: 1, Steps: []Step{{Name: "wait", Run: func(ctx context.Context, _ *Session) error {
+		<-release
+		return nil
+	}}}}}
+	go func() {
+		time.Sleep(150 * time.Millisecond)
+		close(release)
+	}()
+	report, err := New(block, WithOpenLoop(Constant(200, 100*time.Millisecond), 2), quiet).Run(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := report.Step("wait").Count(); n != 2 {
+		t.Errorf("%d iterations ran, want the limit of 2", n)
+	}
+	if report.Dropped() < 10 {
+		t.Errorf("dropped %d arrivals, want most of ~20", report.Dropped())
+	}
+}
+
+func TestRunStopsWithContext(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err := New(DemoScenarios(&fakeShop{}), WithClosedLoop(Constant(2, time.Hour), Think(time.Millisecond, time.Millisecond)), quiet).Run(ctx)
+	if err != context.DeadlineExceeded || time.Since(start) > time.Second {
+		t.Errorf("Run = %v after %s", err, time.Since(start))
+	}
+	if _, err := New(nil).Run(ctx); err == nil {
+		t.Error("Run without scenarios succeeded")
+	}
+}
@@ -0,0 +1,47 @@
+package loadgen
+
+import "time"
+
+// Stage ramps the load linearly from the previous stage's target (zero for
+// the first) to Target over Duration. Target is virtual users in the closed
+// model and arrivals per second in the open one.
+type Stage struct {
+	Duration time.Duration
+	Target   float64
+}
+
+// Profile is a sequence of stages; the run ends after the last.
+type Profile []Stage
+
+// Constant holds target for d, after starting at it.
+func Constant(target float64, d time.Duration) Profile {
+	return Profile{{Duration: 0, Target: target}, {Duration: d, Target: target}}
+}
+
+// Ramp climbs to target over up, holds it for hold and falls back to zero
+// over down, the shape of a soak run.
+func Ramp(target float64, up, hold, down time.Duration) Profile {
+	return Profile{{Duration: up, Target: target}, {Duration: hold, Target: target}, {Duration: down, Target: 0}}
+}
+
+// Duration returns the length of the run.
+func (p Profile) Duration() time.Duration {
+	var d time.Duration
+	for _, s := range p {
+		d += s.Duration
+	}
+	return d
+}
+
+// At returns the target at elapsed into the run, or false once it is over.
+func (p Profile) At(elapsed time.Duration) (float64, bool) {
+	from := 0.0
+	for _, s := range p {
+		if elapsed < s.Duration {
+			return from + (s.Target-from)*float64(elapsed)/float64(s.Duration), true
+		}
+		elapsed -= s.Duration
+		from = s.Target
+	}
+	return from, false
+}
@@ -0,0 +1,165 @@
+package loadgen
+
+import (
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"text/tabwriter"
+	"time"
+)
+
+// Report holds the latencies and errors of a run.
+type Report struct {
+	Duration time.Duration
+
+	mu        sync.Mutex
+	steps     map[string]*Stats
+	scenarios map[string]*Stats
+	total     Stats // every scenario iteration
+	dropped   atomic.Int64
+}
+
+func newReport() *Report {
+	return &Report{steps: make(map[string]*Stats), scenarios: make(map[string]*Stats)}
+}
+
+// Stats are the latencies and errors of one step or scenario.
+type Stats struct {
+	Latency Histogram
+
+	mu     sync.Mutex
+	errors map[string]int
+}
+
+func (s *Stats) record(d time.Duration, err error) {
+	s.Latency.Record(d)
+	if err != nil {
+		s.mu.Lock()
+		if s.errors == nil {
+			s.errors = make(map[string]int)
+		}
+		s.errors[result(err)]++
+		s.mu.Unlock()
+	}
+}
+
+// Count returns the number of runs recorded.
+func (s *Stats) Count() uint64 { return s.Latency.Count() }
+
+// Quantile returns a latency quantile; see Histogram.Quantile.
+func (s *Stats) Quantile(q float64) time.Duration { return s.Latency.Quantile(q) }
+
+// Errors returns the failures by gRPC code.
+func (s *Stats) Errors() map[string]int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return maps.Clone(s.errors)
+}
+
+func (s *Stats) errorCount() int {
+	n := 0
+	for _, c := range s.Errors() {
+		n += c
+	}
+	return n
+}
+
+func (r *Report) step(name string) *Stats     { return r.get(r.steps, name) }
+func (r *Report) scenario(name string) *Stats { return r.get(r.scenarios, name) }
+func (r *Report) scenarioTotal() *Stats       { return &r.total }
+func (r *Report) errorCount() int             { return r.total.errorCount() }
+
+func (r *Report) get(m map[string]*Stats, name string) *Stats {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	s, ok := m[name]
+	if !ok {
+		s = &Stats{}
+		m[name] = s
+	}
+	return s
+}
+
+// Step returns the stats of the step called name, or nil if it never ran.
+func (r *Report) Step(name string) *Stats {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.steps[name]
+}
+
+// Scenario returns the stats of the scenario called name, or nil if it
+// never ran.
+func (r *Report) Scenario(name string) *Stats {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.scenarios[name]
+}
+
+// Dropped returns the open-loop arrivals dropped at the in-flight limit.
+func (r *Report) Dropped() int64 { return r.dropped.Load() }
+
+// Row is one line of a report.
+type Row struct {
+	Name   string         `json:"name"`
+	RPS    float64        `json:"rps"`
+	Errors map[string]int `json:"errors,omitempty"`
+	Summary
+}
+
+// Rows returns the steps and then the scenarios, each sorted by name.
+func (r *Report) Rows() (steps, scenarios []Row) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	rows := func(m map[string]*Stats) []Row {
+		var out []Row
+		for _, name := range slices.Sorted(maps.Keys(m)) {
+			s := m[name]
+			row := Row{Name: name, Errors: s.Errors(), Summary: s.Latency.Summary()}
+			if r.Duration > 0 {
+				row.RPS = float64(row.Count) / r.Duration.Seconds()
+			}
+			out = append(out, row)
+		}
+		return out
+	}
+	return rows(r.steps), rows(r.scenarios)
+}
+
+// WriteText writes the report as aligned tables.
+func (r *Report) WriteText(w io.Writer) error {
+	steps, scenarios := r.Rows()
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', tabwriter.AlignRight)
+	for _, table := range []struct {
+		title string
+		rows  []Row
+	}{{"step", steps}, {"scenario", scenarios}} {
+		fmt.Fprintf(tw, "%s\tcount\terrors\trps\tmean\tp50\tp90\tp99\tmax\t\n", table.title)
+		for _, row := range table.rows {
+			errs := 0
+			for _, n := range row.Errors {
+				errs += n
+			}
+			fmt.Fprintf(tw, "%s\t%d\t%d\t%.1f\t%s\t%s\t%s\t%s\t%s\t\n", row.Name, row.Count, errs, row.RPS,
+				round(row.Mean), round(row.P50), round(row.P90), round(row.P99), round(row.Max))
+		}
+		fmt.Fprintln(tw, "\t\t\t\t\t\t\t\t\t")
+	}
+	if n := r.Dropped(); n > 0 {
+		fmt.Fprintf(tw, "dropped arrivals: %d\t\n", n)
+	}
+	fmt.Fprintf(tw, "duration: %s\t\n", r.Duration.Round(time.Millisecond))
+	return tw.Flush()
+}
+
+func round(d time.Duration) time.Duration {
+	switch {
+	case d >= time.Second:
+		return d.Round(time.Millisecond)
+	case d >= time.Millisecond:
+		return d.Round(10 * time.Microsecond)
+	}
+	return d.Round(time.Microsecond)
+}
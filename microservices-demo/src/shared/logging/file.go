@@ -0,0 +1,64 @@
+package logging
+
+import (
+	"fmt"
+	"os"
+	"sync"
+)
+
+// File is a log file that can be reopened after logrotate or a similar tool
+// has moved it away, so the service keeps writing to the new file instead of
+// the rotated one. Pass it to WithOutput and reopen it on SIGHUP:
+//
+//	f, err := logging.OpenFile("/var/log/checkout/service.log")
+//	if err != nil {
+//	    return err
+//	}
+//	log := logging.New("checkoutservice", logging.WithOutput(f))
+//	shared.DefaultSignalRouter().OnReload("logs", f.Reopen)
+type File struct {
+	path string
+
+	mu sync.Mutex
+	f  *os.File
+}
+
+// OpenFile opens path for appending, creating it if needed.
+func OpenFile(path string) (*File, error) {
+	f := &File{path: path}
+	if err := f.Reopen(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// Reopen opens the file at the path again and closes the previous handle.
+// If opening fails, writes keep going to the previous handle.
+func (f *File) Reopen() error {
+	nf, err := os.OpenFile(f.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		return fmt.Errorf("logging: %w", err)
+	}
+	f.mu.Lock()
+	old := f.f
+	f.f = nf
+	f.mu.Unlock()
+	if old != nil {
+		old.Close()
+	}
+	return nil
+}
+
+// Write appends p to the file.
+func (f *File) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.f.Write(p)
+}
+
+// Close closes the file.
+func (f *File) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.f.Close()
+}
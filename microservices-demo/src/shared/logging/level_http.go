@@ -0,0 +1,56 @@
+package logging
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+)
+
+// levelBody is the JSON shape accepted and returned by LevelHandler.
+type levelBody struct {
+	Level string `json:"level"`
+}
+
+// LevelHandler returns an http.Handler that reads and changes the log level of
+// a running service without a restart:
+//
+//	GET /admin/loglevel                      -> {"level":"info"}
+//	PUT /admin/loglevel {"level":"debug"}    -> {"level":"debug"}
+//
+// Mount it on an internal admin port:
+//
+//	mux.Handle("/admin/loglevel", logging.LevelHandler())
+func LevelHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+		case http.MethodPut, http.MethodPost:
+			var body levelBody
+			if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<10)).Decode(&body); err != nil {
+				http.Error(w, fmt.Sprintf("invalid body: %v", err), http.StatusBadRequest)
+				return
+			}
+			l, ok := ParseLevel(body.Level)
+			if !ok {
+				http.Error(w, fmt.Sprintf("unknown level %q", body.Level), http.StatusBadRequest)
+				return
+			}
+			old := Level()
+			SetLevel(l)
+			slog.Info("log level changed", "from", levelName(old), "to", levelName(l))
+		default:
+			w.Header().Set("Allow", "GET, PUT")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(levelBody{Level: levelName(Level())})
+	})
+}
+
+// levelName returns the LOG_LEVEL spelling of l.
+func levelName(l slog.Level) string {
+	return strings.ToLower(l.String())
+}
@@ -0,0 +1,180 @@
+// Package logging provides a leveled, structured logger built on log/slog and
+// configured from the environment, so every service emits the same log shape.
+//
+// Output defaults to JSON using the field names the services already use with
+// logrus (timestamp, severity, message), which Cloud Logging understands:
+//
+//	{"timestamp":"...","severity":"INFO","message":"listening","service":"shippingservice","port":"50051"}
+//
+// Environment variables:
+//
+//	LOG_LEVEL   debug, info, warn or error (default info)
+//	LOG_FORMAT  json or text (default json)
+//
+// The level can be changed while the service runs with SetLevel, or over HTTP
+// by mounting LevelHandler on an admin port.
+//
+// The pod, namespace and node names from the Kubernetes downward API (see
+// package podinfo) are attached to every entry when present, and
+// entries logged with a context carrying a request ID (see package requestid)
+// get a request_id field, plus user_id, session_id, experiment_bucket and
+// tenant_id fields for the values in its baggage (see package baggage).
+// Entries logged with a context carrying an OpenTelemetry span get trace_id
+// and span_id fields, and are also exported over OTLP once an Exporter is
+// installed with SetExporter, which tracing.Init does when
+// OTEL_LOGS_EXPORTER=otlp.
+package logging
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/GoogleCloudPlatform/microservices-demo/src/shared/baggage"
+	"github.com/GoogleCloudPlatform/microservices-demo/src/shared/buildinfo"
+	"github.com/GoogleCloudPlatform/microservices-demo/src/shared/podinfo"
+	"github.com/GoogleCloudPlatform/microservices-demo/src/shared/requestid"
+)
+
+// Option configures New.
+type Option func(*options)
+
+type options struct {
+	out    io.Writer
+	format string
+	level  *slog.Level
+}
+
+// WithOutput sets the destination; the default is os.Stdout.
+func WithOutput(w io.Writer) Option {
+	return func(o *options) { o.out = w }
+}
+
+// WithFormat overrides LOG_FORMAT ("json" or "text").
+func WithFormat(format string) Option {
+	return func(o *options) { o.format = format }
+}
+
+// WithLevel overrides LOG_LEVEL.
+func WithLevel(level slog.Level) Option {
+	return func(o *options) { o.level = &level }
+}
+
+// level is shared by every logger returned from New so the process log level
+// can be changed at runtime with SetLevel.
+var level = new(slog.LevelVar)
+
+// New returns a logger tagged with the service name, its version (see package
+// buildinfo) and pod metadata.
+//
+// All loggers created by New share one level: creating a logger (re)applies
+// LOG_LEVEL or WithLevel, and SetLevel changes it for every logger at once.
+func New(service string, opts ...Option) *slog.Logger {
+	o := options{out: os.Stdout, format: os.Getenv("LOG_FORMAT")}
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	if o.level != nil {
+		level.Set(*o.level)
+	} else if l, ok := ParseLevel(os.Getenv("LOG_LEVEL")); ok {
+		level.Set(l)
+	}
+
+	hopts := &slog.HandlerOptions{Level: level, ReplaceAttr: replaceAttr}
+	var h slog.Handler
+	if strings.EqualFold(o.format, "text") {
+		h = slog.NewTextHandler(o.out, hopts)
+	} else {
+		h = slog.NewJSONHandler(o.out, hopts)
+	}
+
+	attrs := []any{slog.String("service", service), slog.String("version", buildinfo.GetInfo().Version)}
+	for _, a := range podinfo.Get().LogAttrs() {
+		attrs = append(attrs, a)
+	}
+	return slog.New(contextHandler{Handler: h}).With(attrs...)
+}
+
+// contextHandler adds request-scoped attributes found in the record's
+// context, and hands the record to the installed Exporter, if any.
+type contextHandler struct {
+	slog.Handler
+	otlp otlpAttrs
+}
+
+func (h contextHandler) Handle(ctx context.Context, r slog.Record) error {
+	if id := requestid.FromContext(ctx); id != "" {
+		r.AddAttrs(slog.String("request_id", id))
+	}
+	r.AddAttrs(baggage.LogAttrs(ctx)...)
+	if e := exporter.Load(); e != nil {
+		// Exported records carry the span in their own fields.
+		e.enqueue(h.otlp.record(ctx, r))
+	}
+	if sc := trace.SpanContextFromContext(ctx); sc.IsValid() {
+		r.AddAttrs(slog.String("trace_id", sc.TraceID().String()), slog.String("span_id", sc.SpanID().String()))
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return contextHandler{h.Handler.WithAttrs(attrs), h.otlp.withAttrs(attrs)}
+}
+
+func (h contextHandler) WithGroup(name string) slog.Handler {
+	return contextHandler{h.Handler.WithGroup(name), h.otlp.withGroup(name)}
+}
+
+// SetLevel changes the level of every logger returned by New.
+func SetLevel(l slog.Level) {
+	level.Set(l)
+}
+
+// Level returns the current level of the loggers returned by New.
+func Level() slog.Level {
+	return level.Level()
+}
+
+// ParseLevel parses a level name as used in LOG_LEVEL. Matching is
+// case-insensitive and accepts "warning" as an alias for "warn".
+func ParseLevel(s string) (slog.Level, bool) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "debug":
+		return slog.LevelDebug, true
+	case "info":
+		return slog.LevelInfo, true
+	case "warn", "warning":
+		return slog.LevelWarn, true
+	case "error":
+		return slog.LevelError, true
+	}
+	return slog.LevelInfo, false
+}
+
+// replaceAttr renames the built-in keys to the services' logrus field map.
+func replaceAttr(groups []string, a slog.Attr) slog.Attr {
+	if len(groups) > 0 {
+		return a
+	}
+	switch a.Key {
+	case slog.TimeKey:
+		a.Key = "timestamp"
+		if t, ok := a.Value.Any().(time.Time); ok {
+			a.Value = slog.StringValue(t.UTC().Format(time.RFC3339Nano))
+		}
+	case slog.LevelKey:
+		a.Key = "severity"
+		if l, ok := a.Value.Any().(slog.Level); ok && l == slog.LevelWarn {
+			a.Value = slog.StringValue("WARNING")
+		}
+	case slog.MessageKey:
+		a.Key = "message"
+	}
+	return a
+}
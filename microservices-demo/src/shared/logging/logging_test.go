@@ -0,0 +1,150 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/GoogleCloudPlatform/microservices-demo/src/shared/baggage"
+	"github.com/GoogleCloudPlatform/microservices-demo/src/shared/buildinfo"
+	"github.com/GoogleCloudPlatform/microservices-demo/src/shared/podinfo"
+	"github.com/GoogleCloudPlatform/microservices-demo/src/shared/requestid"
+)
+
+func TestNewJSON(t *testing.T) {
+	t.Setenv("POD_NAME", "shipping-abc")
+	t.Setenv("POD_NAMESPACE", "")
+	podinfo.Reload()
+	var buf bytes.Buffer
+	log := New("shippingservice", WithOutput(&buf), WithFormat("json"), WithLevel(slog.LevelInfo))
+
+	log.Debug("hidden")
+	log.Warn("quote computed", "items", 3)
+
+	var entry map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+		t.Fatalf("decoding %q: %v", buf.String(), err)
+	}
+	want := map[string]any{
+		"severity": "WARNING",
+		"message":  "quote computed",
+		"service":  "shippingservice",
+		"pod":      "shipping-abc",
+		"version":  buildinfo.GetInfo().Version,
+		"items":    float64(3),
+	}
+	for k, v := range want {
+		if entry[k] != v {
+			t.Errorf("entry[%q] = %v, want %v", k, entry[k], v)
+		}
+	}
+	if _, ok := entry["timestamp"]; !ok {
+		t.Error("entry has no timestamp")
+	}
+	if _, ok := entry["namespace"]; ok {
+		t.Error("empty POD_NAMESPACE should not be logged")
+	}
+}
+
+func TestLevelFromEnv(t *testing.T) {
+	t.Setenv("LOG_LEVEL", "DEBUG")
+	var buf bytes.Buffer
+	New("svc", WithOutput(&buf)).Debug("visible")
+	if buf.Len() == 0 {
+		t.Error("debug entry dropped with LOG_LEVEL=DEBUG")
+	}
+}
+
+func TestParseLevel(t *testing.T) {
+	tests := []struct {
+		in   string
+		want slog.Level
+		ok   bool
+	}{
+		{"debug", slog.LevelDebug, true},
+		{"Warning", slog.LevelWarn, true},
+		{" error ", slog.LevelError, true},
+		{"verbose", slog.LevelInfo, false},
+	}
+	for _, tt := range tests {
+		got, ok := ParseLevel(tt.in)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("ParseLevel(%q) = %v, %v; want %v, %v", tt.in, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestLevelHandler(t *testing.T) {
+	defer SetLevel(Level())
+	SetLevel(slog.LevelInfo)
+	h := LevelHandler()
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodPut, "/admin/loglevel", strings.NewReader(`{"level":"debug"}`)))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("PUT status = %d: %s", rec.Code, rec.Body)
+	}
+	if Level() != slog.LevelDebug {
+		t.Errorf("Level() = %v, want debug", Level())
+	}
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/admin/loglevel", nil))
+	if got := strings.TrimSpace(rec.Body.String()); got != `{"level":"debug"}` {
+		t.Errorf("GET body = %s", got)
+	}
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodPut, "/admin/loglevel", strings.NewReader(`{"level":"loud"}`)))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("invalid level status = %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+}
+
+func TestSetLevelAffectsExistingLoggers(t *testing.T) {
+	defer SetLevel(Level())
+	var buf bytes.Buffer
+	log := New("svc", WithOutput(&buf), WithLevel(slog.LevelInfo))
+	log.Debug("dropped")
+	SetLevel(slog.LevelDebug)
+	log.Debug("kept")
+	if got := strings.Count(buf.String(), "\n"); got != 1 {
+		t.Errorf("got %d entries, want 1: %s", got, buf.String())
+	}
+}
+
+func TestRequestIDFromContext(t *testing.T) {
+	var buf bytes.Buffer
+	log := New("checkoutservice", WithOutput(&buf), WithFormat("json"), WithLevel(slog.LevelInfo))
+
+	log.With("order", "o-1").InfoContext(requestid.NewContext(context.Background(), "req-42"), "order placed")
+
+	var entry map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+		t.Fatalf("decoding %q: %v", buf.String(), err)
+	}
+	if entry["request_id"] != "req-42" || entry["order"] != "o-1" {
+		t.Errorf("entry = %v, want request_id=req-42 and order=o-1", entry)
+	}
+}
+
+func TestBaggageFromContext(t *testing.T) {
+	var buf bytes.Buffer
+	log := New("checkoutservice", WithOutput(&buf), WithFormat("json"), WithLevel(slog.LevelInfo))
+
+	ctx := baggage.Set(context.Background(), baggage.UserID, "usr_1")
+	log.InfoContext(baggage.Set(ctx, baggage.Tenant, "acme"), "order placed")
+
+	var entry map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+		t.Fatalf("decoding %q: %v", buf.String(), err)
+	}
+	if entry["user_id"] != "usr_1" || entry["tenant_id"] != "acme" {
+		t.Errorf("entry = %v, want user_id=usr_1 and tenant_id=acme", entry)
+	}
+}
@@ -0,0 +1,328 @@
+package logging
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	collogspb "go.opentelemetry.io/proto/otlp/collector/logs/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	logspb "go.opentelemetry.io/proto/otlp/logs/v1"
+	resourcepb "go.opentelemetry.io/proto/otlp/resource/v1"
+	"google.golang.org/grpc"
+
+	"github.com/GoogleCloudPlatform/microservices-demo/src/shared/metrics"
+)
+
+var otlpRecords = metrics.NewCounterVec("logging_otlp_records_total",
+	"Log records handed to the OTLP exporter, by result (exported, dropped, failed).", "result")
+
+// scopeName is the instrumentation scope of exported records.
+const scopeName = "github.com/GoogleCloudPlatform/microservices-demo/src/shared/logging"
+
+// Exporter defaults.
+const (
+	DefaultExportBatchSize = 512
+	DefaultExportInterval  = time.Second
+	DefaultExportQueueSize = 2048
+	DefaultExportTimeout   = 10 * time.Second
+)
+
+// exporter is the Exporter installed with SetExporter, fed by every logger
+// returned from New.
+var exporter atomic.Pointer[Exporter]
+
+// SetExporter makes every logger returned by New, before or after the call,
+// also send its entries to e; nil stops it. tracing.Init installs one when
+// OTEL_LOGS_EXPORTER=otlp.
+func SetExporter(e *Exporter) {
+	exporter.Store(e)
+}
+
+type exporterConfig struct {
+	resource  []*commonpb.KeyValue
+	batchSize int
+	interval  time.Duration
+	queueSize int
+	timeout   time.Duration
+}
+
+// ExporterOption configures NewExporter.
+type ExporterOption func(*exporterConfig)
+
+// WithExporterResource sets the resource attributes sent with every batch,
+// normally those the tracer provider uses so logs and traces of a service
+// line up.
+func WithExporterResource(attrs ...attribute.KeyValue) ExporterOption {
+	return func(c *exporterConfig) {
+		for _, a := range attrs {
+			c.resource = append(c.resource, &commonpb.KeyValue{Key: string(a.Key), Value: otlpValue(slog.AnyValue(a.Value.AsInterface()))})
+		}
+	}
+}
+
+// WithExporterBatchSize sets how many records one export carries at most
+// (default DefaultExportBatchSize).
+func WithExporterBatchSize(n int) ExporterOption {
+	return func(c *exporterConfig) {
+		if n > 0 {
+			c.batchSize = n
+		}
+	}
+}
+
+// WithExporterInterval sets how long records wait for a batch to fill
+// before they are exported anyway (default DefaultExportInterval).
+func WithExporterInterval(d time.Duration) ExporterOption {
+	return func(c *exporterConfig) {
+		if d > 0 {
+			c.interval = d
+		}
+	}
+}
+
+// WithExporterQueueSize sets how many records may wait for export; records
+// logged while the queue is full are dropped rather than blocking the
+// caller (default DefaultExportQueueSize).
+func WithExporterQueueSize(n int) ExporterOption {
+	return func(c *exporterConfig) {
+		if n > 0 {
+			c.queueSize = n
+		}
+	}
+}
+
+// Exporter sends log records to an OpenTelemetry collector over OTLP/gRPC,
+// in batches, from a background goroutine. Records carry the trace and span
+// of the context they were logged with, so the backend can show a trace's
+// logs next to its spans.
+//
+// Install it with SetExporter; tracing.Init does both from the environment:
+//
+//	exp := logging.NewExporter(conn, logging.WithExporterResource(res.Attributes()...))
+//	logging.SetExporter(exp)
+//	defer exp.Shutdown(context.Background())
+//
+// Export failures are counted in logging_otlp_records_total rather than
+// logged, which would feed the failure back into the exporter.
+type Exporter struct {
+	client collogspb.LogsServiceClient
+	cfg    exporterConfig
+	queue  chan *logspb.LogRecord
+	stop   chan struct{}
+	done   chan struct{}
+	closed atomic.Bool
+	once   sync.Once
+}
+
+// NewExporter returns an Exporter sending over conn, and starts it.
+func NewExporter(conn grpc.ClientConnInterface, opts ...ExporterOption) *Exporter {
+	cfg := exporterConfig{
+		batchSize: DefaultExportBatchSize,
+		interval:  DefaultExportInterval,
+		queueSize: DefaultExportQueueSize,
+		timeout:   DefaultExportTimeout,
+	}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	e := &Exporter{
+		client: collogspb.NewLogsServiceClient(conn),
+		cfg:    cfg,
+		queue:  make(chan *logspb.LogRecord, cfg.queueSize),
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	go e.run()
+	return e
+}
+
+// Shutdown exports the records still queued, waiting until ctx is done, and
+// stops the exporter. Records logged afterwards are dropped.
+func (e *Exporter) Shutdown(ctx context.Context) error {
+	e.once.Do(func() {
+		e.closed.Store(true)
+		close(e.stop)
+	})
+	select {
+	case <-e.done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("logging: flushing OTLP exporter: %w", ctx.Err())
+	}
+}
+
+// enqueue queues rec for export, dropping it if the queue is full.
+func (e *Exporter) enqueue(rec *logspb.LogRecord) {
+	if e.closed.Load() {
+		otlpRecords.WithLabelValues("dropped").Inc()
+		return
+	}
+	select {
+	case e.queue <- rec:
+	default:
+		otlpRecords.WithLabelValues("dropped").Inc()
+	}
+}
+
+// run exports a batch whenever it is full or the interval has passed, and
+// everything left on shutdown.
+func (e *Exporter) run() {
+	defer close(e.done)
+	ticker := time.NewTicker(e.cfg.interval)
+	defer ticker.Stop()
+	batch := make([]*logspb.LogRecord, 0, e.cfg.batchSize)
+	flush := func() {
+		if len(batch) > 0 {
+			e.export(batch)
+			batch = make([]*logspb.LogRecord, 0, e.cfg.batchSize)
+		}
+	}
+	for {
+		select {
+		case rec := <-e.queue:
+			batch = append(batch, rec)
+			if len(batch) >= e.cfg.batchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		case <-e.stop:
+			for {
+				select {
+				case rec := <-e.queue:
+					batch = append(batch, rec)
+					if len(batch) >= e.cfg.batchSize {
+						flush()
+					}
+				default:
+					flush()
+					return
+				}
+			}
+		}
+	}
+}
+
+func (e *Exporter) export(batch []*logspb.LogRecord) {
+	ctx, cancel := context.WithTimeout(context.Background(), e.cfg.timeout)
+	defer cancel()
+	_, err := e.client.Export(ctx, &collogspb.ExportLogsServiceRequest{
+		ResourceLogs: []*logspb.ResourceLogs{{
+			Resource: &resourcepb.Resource{Attributes: e.cfg.resource},
+			ScopeLogs: []*logspb.ScopeLogs{{
+				Scope:      &commonpb.InstrumentationScope{Name: scopeName},
+				LogRecords: batch,
+			}},
+		}},
+	})
+	result := "exported"
+	if err != nil {
+		result = "failed"
+	}
+	otlpRecords.WithLabelValues(result).Add(float64(len(batch)))
+}
+
+// otlpAttrs holds the attributes a logger was given with With and the group
+// prefix from WithGroup, flattened into dotted keys for export.
+type otlpAttrs struct {
+	attrs  []*commonpb.KeyValue
+	prefix string
+}
+
+func (o otlpAttrs) withAttrs(attrs []slog.Attr) otlpAttrs {
+	out := make([]*commonpb.KeyValue, 0, len(o.attrs)+len(attrs))
+	out = append(out, o.attrs...)
+	for _, a := range attrs {
+		out = appendOTLPAttr(out, o.prefix, a)
+	}
+	return otlpAttrs{attrs: out, prefix: o.prefix}
+}
+
+func (o otlpAttrs) withGroup(name string) otlpAttrs {
+	return otlpAttrs{attrs: o.attrs, prefix: o.prefix + name + "."}
+}
+
+// record converts r, logged with ctx, to an OTLP log record.
+func (o otlpAttrs) record(ctx context.Context, r slog.Record) *logspb.LogRecord {
+	attrs := make([]*commonpb.KeyValue, 0, len(o.attrs)+r.NumAttrs())
+	attrs = append(attrs, o.attrs...)
+	r.Attrs(func(a slog.Attr) bool {
+		attrs = appendOTLPAttr(attrs, o.prefix, a)
+		return true
+	})
+	rec := &logspb.LogRecord{
+		TimeUnixNano:         uint64(r.Time.UnixNano()),
+		ObservedTimeUnixNano: uint64(time.Now().UnixNano()),
+		SeverityNumber:       severity(r.Level),
+		SeverityText:         r.Level.String(),
+		Body:                 &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: r.Message}},
+		Attributes:           attrs,
+	}
+	if sc := trace.SpanContextFromContext(ctx); sc.IsValid() {
+		tid, sid := sc.TraceID(), sc.SpanID()
+		rec.TraceId, rec.SpanId = tid[:], sid[:]
+		rec.Flags = uint32(sc.TraceFlags())
+	}
+	return rec
+}
+
+// severity maps a slog level to an OTLP severity number: DEBUG is 5, INFO
+// 9, WARN 13 and ERROR 17, with the levels between them in between.
+func severity(l slog.Level) logspb.SeverityNumber {
+	return logspb.SeverityNumber(min(max(int(l)+9, 1), 24))
+}
+
+// appendOTLPAttr appends a under prefix, flattening groups.
+func appendOTLPAttr(out []*commonpb.KeyValue, prefix string, a slog.Attr) []*commonpb.KeyValue {
+	v := a.Value.Resolve()
+	if v.Kind() == slog.KindGroup {
+		if a.Key != "" {
+			prefix += a.Key + "."
+		}
+		for _, ga := range v.Group() {
+			out = appendOTLPAttr(out, prefix, ga)
+		}
+		return out
+	}
+	if a.Key == "" {
+		return out
+	}
+	return append(out, &commonpb.KeyValue{Key: prefix + a.Key, Value: otlpValue(v)})
+}
+
+// otlpValue converts a resolved, non-group slog value.
+func otlpValue(v slog.Value) *commonpb.AnyValue {
+	switch v.Kind() {
+	case slog.KindString:
+		return &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: v.String()}}
+	case slog.KindInt64:
+		return &commonpb.AnyValue{Value: &commonpb.AnyValue_IntValue{IntValue: v.Int64()}}
+	case slog.KindUint64:
+		return &commonpb.AnyValue{Value: &commonpb.AnyValue_IntValue{IntValue: int64(v.Uint64())}}
+	case slog.KindFloat64:
+		return &commonpb.AnyValue{Value: &commonpb.AnyValue_DoubleValue{DoubleValue: v.Float64()}}
+	case slog.KindBool:
+		return &commonpb.AnyValue{Value: &commonpb.AnyValue_BoolValue{BoolValue: v.Bool()}}
+	case slog.KindDuration:
+		return &commonpb.AnyValue{Value: &commonpb.AnyValue_IntValue{IntValue: int64(v.Duration())}}
+	case slog.KindTime:
+		return &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: v.Time().UTC().Format(time.RFC3339Nano)}}
+	}
+	switch x := v.Any().(type) {
+	case []string:
+		vals := make([]*commonpb.AnyValue, len(x))
+		for i, s := range x {
+			vals[i] = &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: s}}
+		}
+		return &commonpb.AnyValue{Value: &commonpb.AnyValue_ArrayValue{ArrayValue: &commonpb.ArrayValue{Values: vals}}}
+	case error:
+		return &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: x.Error()}}
+	}
+	return &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: fmt.Sprint(v.Any())}}
+}
@@ -0,0 +1,181 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	collogspb "go.opentelemetry.io/proto/otlp/collector/logs/v1"
+	logspb "go.opentelemetry.io/proto/otlp/logs/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// fakeCollector records the requests it receives.
+type fakeCollector struct {
+	collogspb.UnimplementedLogsServiceServer
+	mu   sync.Mutex
+	reqs []*collogspb.ExportLogsServiceRequest
+}
+
+func (c *fakeCollector) Export(_ context.Context, req *collogspb.ExportLogsServiceRequest) (*collogspb.ExportLogsServiceResponse, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.reqs = append(c.reqs, req)
+	return &collogspb.ExportLogsServiceResponse{}, nil
+}
+
+func (c *fakeCollector) records() []*logspb.LogRecord {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var out []*logspb.LogRecord
+	for _, req := range c.reqs {
+		for _, rl := range req.ResourceLogs {
+			for _, sl := range rl.ScopeLogs {
+				out = append(out, sl.LogRecords...)
+			}
+		}
+	}
+	return out
+}
+
+func startCollector(t *testing.T) (*fakeCollector, *grpc.ClientConn) {
+	t.Helper()
+	lis := bufconn.Listen(1 << 20)
+	srv := grpc.NewServer()
+	c := &fakeCollector{}
+	collogspb.RegisterLogsServiceServer(srv, c)
+	go srv.Serve(lis)
+	t.Cleanup(srv.Stop)
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return c, conn
+}
+
+func spanContext(t *testing.T) (context.Context, trace.SpanContext) {
+	t.Helper()
+	tid, _ := trace.TraceIDFromHex("4bf92f3577b34da6a3ce929d0e0e4736")
+	sid, _ := trace.SpanIDFromHex("00f067aa0ba902b7")
+	sc := trace.NewSpanContext(trace.SpanContextConfig{TraceID: tid, SpanID: sid, TraceFlags: trace.FlagsSampled})
+	return trace.ContextWithSpanContext(context.Background(), sc), sc
+}
+
+func TestTraceFields(t *testing.T) {
+	ctx, sc := spanContext(t)
+	var buf bytes.Buffer
+	log := New("svc", WithOutput(&buf), WithLevel(slog.LevelInfo))
+	log.InfoContext(ctx, "charged")
+
+	var entry map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+		t.Fatal(err)
+	}
+	if entry["trace_id"] != sc.TraceID().String() || entry["span_id"] != sc.SpanID().String() {
+		t.Errorf("trace_id, span_id = %v, %v; want %s, %s", entry["trace_id"], entry["span_id"], sc.TraceID(), sc.SpanID())
+	}
+
+	buf.Reset()
+	log.Info("no span")
+	if bytes.Contains(buf.Bytes(), []byte("trace_id")) {
+		t.Errorf("entry without a span has a trace_id: %s", buf.String())
+	}
+}
+
+func TestExporter(t *testing.T) {
+	c, conn := startCollector(t)
+	exp := NewExporter(conn,
+		WithExporterResource(attribute.String("service.name", "checkoutservice")),
+		WithExporterInterval(time.Hour))
+	SetExporter(exp)
+	defer SetExporter(nil)
+
+	ctx, sc := spanContext(t)
+	log := New("checkoutservice", WithOutput(&bytes.Buffer{}), WithLevel(slog.LevelInfo))
+	log.WithGroup("order").With("id", "o-1").WarnContext(ctx, "payment slow", "took", 2*time.Second, "err", errors.New("timeout"))
+	log.Debug("hidden")
+
+	if err := exp.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	recs := c.records()
+	if len(recs) != 1 {
+		t.Fatalf("exported %d records, want 1", len(recs))
+	}
+	rec := recs[0]
+	if rec.Body.GetStringValue() != "payment slow" || rec.SeverityNumber != logspb.SeverityNumber_SEVERITY_NUMBER_WARN {
+		t.Errorf("record = %q at %v", rec.Body.GetStringValue(), rec.SeverityNumber)
+	}
+	if tid := sc.TraceID(); !bytes.Equal(rec.TraceId, tid[:]) {
+		t.Errorf("trace ID = %x, want %s", rec.TraceId, tid)
+	}
+	if sid := sc.SpanID(); !bytes.Equal(rec.SpanId, sid[:]) {
+		t.Errorf("span ID = %x, want %s", rec.SpanId, sid)
+	}
+	attrs := map[string]string{}
+	for _, kv := range rec.Attributes {
+		attrs[kv.Key] = kv.Value.String()
+	}
+	for _, key := range []string{"service", "version", "order.id", "order.took", "order.err"} {
+		if _, ok := attrs[key]; !ok {
+			t.Errorf("attribute %q missing from %v", key, attrs)
+		}
+	}
+	if _, ok := attrs["trace_id"]; ok {
+		t.Error("trace_id exported as an attribute")
+	}
+	res := c.reqs[0].ResourceLogs[0].Resource.Attributes
+	if len(res) != 1 || res[0].Key != "service.name" || res[0].Value.GetStringValue() != "checkoutservice" {
+		t.Errorf("resource = %v", res)
+	}
+
+	log.Info("after shutdown")
+	if n := len(c.records()); n != 1 {
+		t.Errorf("exported %d records after shutdown, want 1", n)
+	}
+}
+
+func TestExporterBatches(t *testing.T) {
+	c, conn := startCollector(t)
+	exp := NewExporter(conn, WithExporterBatchSize(2), WithExporterInterval(time.Hour))
+	SetExporter(exp)
+	defer SetExporter(nil)
+	log := New("svc", WithOutput(&bytes.Buffer{}), WithLevel(slog.LevelInfo))
+	for range 5 {
+		log.Info("tick")
+	}
+	if err := exp.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if len(c.reqs) != 3 || len(c.records()) != 5 {
+		t.Errorf("got %d exports of %d records, want 3 of 5", len(c.reqs), len(c.records()))
+	}
+}
+
+func TestSeverity(t *testing.T) {
+	for l, want := range map[slog.Level]logspb.SeverityNumber{
+		slog.LevelDebug: logspb.SeverityNumber_SEVERITY_NUMBER_DEBUG,
+		slog.LevelInfo:  logspb.SeverityNumber_SEVERITY_NUMBER_INFO,
+		slog.LevelWarn:  logspb.SeverityNumber_SEVERITY_NUMBER_WARN,
+		slog.LevelError: logspb.SeverityNumber_SEVERITY_NUMBER_ERROR,
+		slog.Level(-20): logspb.SeverityNumber_SEVERITY_NUMBER_TRACE,
+		slog.Level(30):  logspb.SeverityNumber_SEVERITY_NUMBER_FATAL4,
+	} {
+		if got := severity(l); got != want {
+			t.Errorf("severity(%v) = %v, want %v", l, got, want)
+		}
+	}
+}
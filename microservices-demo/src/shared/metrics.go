@@ -0,0 +1,22 @@
+package shared
+
+import (
+	"net/http"
+
+	"github.com/GoogleCloudPlatform/microservices-demo/src/shared/metrics"
+)
+
+// InitMetrics registers the Go runtime, process, build-info and pod
+// collectors for service and serves /metrics on METRICS_ADDR (default
+// :9090); see metrics.Init, to which it forwards, for the options. The
+// namespaced helpers metrics.NewCounterVec, NewGaugeVec and NewHistogramVec
+// register into the same registry.
+//
+//	srv, err := shared.InitMetrics("checkoutservice")
+//	if err != nil {
+//	    log.Fatalf("metrics: %v", err)
+//	}
+//	defer srv.Close()
+func InitMetrics(service string, opts ...metrics.Option) (*http.Server, error) {
+	return metrics.Init(service, opts...)
+}
@@ -0,0 +1,46 @@
+package metrics
+
+import (
+	"context"
+	"sync/atomic"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// ExemplarFunc returns the exemplar labels of an observation made in ctx,
+// typically the trace and span IDs, or nil for none.
+type ExemplarFunc func(ctx context.Context) prometheus.Labels
+
+var exemplarFunc atomic.Pointer[ExemplarFunc]
+
+// SetExemplarFunc makes Observe attach the exemplars fn returns.
+// tracing.Init sets it when tracing is enabled, so the package does not
+// depend on OpenTelemetry; nil stops attaching exemplars.
+func SetExemplarFunc(fn ExemplarFunc) {
+	if fn == nil {
+		exemplarFunc.Store(nil)
+		return
+	}
+	exemplarFunc.Store(&fn)
+}
+
+// Observe records v in o, attaching an exemplar when ctx carries one, such
+// as a sampled span, so a dashboard can jump from a slow bucket to a trace
+// that landed in it:
+//
+//	metrics.Observe(ctx, handlingSeconds.WithLabelValues(method), time.Since(start).Seconds())
+//
+// Exemplars are only exposed in the OpenMetrics format, which Handler
+// serves to scrapers asking for it; Prometheus does with
+// --enable-feature=exemplar-storage.
+func Observe(ctx context.Context, o prometheus.Observer, v float64) {
+	if fn := exemplarFunc.Load(); fn != nil {
+		if labels := (*fn)(ctx); len(labels) > 0 {
+			if eo, ok := o.(prometheus.ExemplarObserver); ok {
+				eo.ObserveWithExemplar(v, labels)
+				return
+			}
+		}
+	}
+	o.Observe(v)
+}
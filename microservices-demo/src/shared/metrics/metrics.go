@@ -0,0 +1,175 @@
+// Package metrics bootstraps Prometheus metrics for a service: Go runtime and
+// process collectors, a build-info gauge, a /metrics endpoint, and helpers that
+// create metrics under one naming scheme so dashboards work across services.
+//
+// All metrics created through this package are prefixed with Namespace, e.g.
+// NewCounterVec("orders_placed_total", ...) is exported as
+// boutique_orders_placed_total. The scraping target (pod) identifies the
+// service, so metric names do not repeat it.
+package metrics
+
+import (
+	"errors"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	"github.com/GoogleCloudPlatform/microservices-demo/src/shared/buildinfo"
+	"github.com/GoogleCloudPlatform/microservices-demo/src/shared/podinfo"
+)
+
+// Namespace prefixes every metric created by this package.
+const Namespace = "boutique"
+
+// DefaultAddr is the listen address used when METRICS_ADDR is not set.
+const DefaultAddr = ":9090"
+
+// Registry holds all metrics created by this package. It is separate from
+// prometheus.DefaultRegisterer so the exposed set is exactly what Init and the
+// helpers register.
+var Registry = prometheus.NewRegistry()
+
+var initOnce sync.Once
+
+// Option configures Init.
+type Option func(*options)
+
+type options struct {
+	addr    string
+	version string
+}
+
+// WithAddr overrides METRICS_ADDR. An empty address registers the collectors
+// without starting a listener, for services that mount Handler themselves.
+func WithAddr(addr string) Option {
+	return func(o *options) { o.addr = addr }
+}
+
+// WithVersion overrides the version reported by the build-info gauge, which
+// defaults to buildinfo.GetInfo().Version.
+func WithVersion(version string) Option {
+	return func(o *options) { o.version = version }
+}
+
+// Init registers the Go runtime, process and build-info collectors, and in
+// Kubernetes the pod metadata (see registerPodInfo), and, unless
+// the address is empty, serves Handler at /metrics on METRICS_ADDR (default
+// :9090). It returns the started server, or nil if none was started. Calling
+// Init more than once registers the collectors only the first time.
+//
+// Usage:
+//
+//	srv, err := metrics.Init("checkoutservice")
+//	if err != nil {
+//	    log.Fatalf("metrics: %v", err)
+//	}
+//	defer srv.Close()
+func Init(service string, opts ...Option) (*http.Server, error) {
+	bi := buildinfo.GetInfo()
+	o := options{addr: DefaultAddr, version: bi.Version}
+	if addr, ok := os.LookupEnv("METRICS_ADDR"); ok {
+		o.addr = addr
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	initOnce.Do(func() {
+		Registry.MustRegister(
+			collectors.NewGoCollector(),
+			collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+			collectors.NewBuildInfoCollector(),
+		)
+		buildInfo := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: Namespace,
+			Name:      "build_info",
+			Help:      "Build information about the running service; the value is always 1.",
+		}, []string{"service", "version", "revision", "goversion"})
+		Registry.MustRegister(buildInfo)
+		buildInfo.WithLabelValues(service, o.version, bi.ShortCommit(), bi.GoVersion).Set(1)
+		registerPodInfo(podinfo.Get())
+	})
+
+	if o.addr == "" {
+		return nil, nil
+	}
+	lis, err := net.Listen("tcp", o.addr)
+	if err != nil {
+		return nil, err
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", Handler())
+	srv := &http.Server{Handler: mux}
+	go func() {
+		if err := srv.Serve(lis); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			slog.Error("metrics server stopped", "error", err)
+		}
+	}()
+	slog.Info("serving metrics", "addr", lis.Addr().String())
+	return srv, nil
+}
+
+// registerPodInfo exports pod as boutique_pod_info, whose labels can be
+// joined onto any series of the pod, and its resource limits, so dashboards
+// can show usage against them. Outside Kubernetes it registers nothing.
+func registerPodInfo(pod podinfo.Info) {
+	if !pod.InKubernetes() {
+		return
+	}
+	NewGaugeVec("pod_info", "Kubernetes pod the service runs in; the value is always 1.", "pod", "namespace", "node").
+		WithLabelValues(pod.Name, pod.Namespace, pod.Node).Set(1)
+	if pod.CPULimitMillis > 0 {
+		NewGaugeVec("pod_cpu_limit_cores", "CPU limit of the container.").
+			WithLabelValues().Set(float64(pod.CPULimitMillis) / 1000)
+	}
+	if pod.MemoryLimitBytes > 0 {
+		NewGaugeVec("pod_memory_limit_bytes", "Memory limit of the container.").
+			WithLabelValues().Set(float64(pod.MemoryLimitBytes))
+	}
+}
+
+// Handler returns the Prometheus exposition handler for Registry. It serves
+// the OpenMetrics format, which carries exemplars (see Observe), to
+// scrapers that accept it and the text format to the others.
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{Registry: Registry, EnableOpenMetrics: true})
+}
+
+// NewCounterVec creates and registers a counter named Namespace_name.
+func NewCounterVec(name, help string, labels ...string) *prometheus.CounterVec {
+	c := prometheus.NewCounterVec(prometheus.CounterOpts{Namespace: Namespace, Name: name, Help: help}, labels)
+	return register(c).(*prometheus.CounterVec)
+}
+
+// NewGaugeVec creates and registers a gauge named Namespace_name.
+func NewGaugeVec(name, help string, labels ...string) *prometheus.GaugeVec {
+	g := prometheus.NewGaugeVec(prometheus.GaugeOpts{Namespace: Namespace, Name: name, Help: help}, labels)
+	return register(g).(*prometheus.GaugeVec)
+}
+
+// NewHistogramVec creates and registers a histogram named Namespace_name.
+// A nil buckets slice uses prometheus.DefBuckets.
+func NewHistogramVec(name, help string, buckets []float64, labels ...string) *prometheus.HistogramVec {
+	h := prometheus.NewHistogramVec(prometheus.HistogramOpts{Namespace: Namespace, Name: name, Help: help, Buckets: buckets}, labels)
+	return register(h).(*prometheus.HistogramVec)
+}
+
+// register adds c to Registry. If an identical collector is already
+// registered (e.g. two packages ask for the same metric) the existing one is
+// returned so callers share it instead of panicking.
+func register(c prometheus.Collector) prometheus.Collector {
+	if err := Registry.Register(c); err != nil {
+		var are prometheus.AlreadyRegisteredError
+		if errors.As(err, &are) {
+			return are.ExistingCollector
+		}
+		panic(err)
+	}
+	return c
+}
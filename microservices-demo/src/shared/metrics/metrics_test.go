@@ -0,0 +1,122 @@
+package metrics
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/GoogleCloudPlatform/microservices-demo/src/shared/podinfo"
+)
+
+func TestHelpersShareRegistration(t *testing.T) {
+	a := NewCounterVec("test_requests_total", "Requests.", "method")
+	b := NewCounterVec("test_requests_total", "Requests.", "method")
+	const series = `boutique_test_requests_total{method="GET"}`
+	before := sample(scrape(t), series)
+	a.WithLabelValues("GET").Inc()
+	b.WithLabelValues("GET").Inc()
+
+	body := scrape(t)
+	if got := sample(body, series) - before; got != 2 {
+		t.Errorf("counter grew by %v through both handles, want 2:\n%s", got, body)
+	}
+}
+
+func TestInitRegistersCollectors(t *testing.T) {
+	srv, err := Init("testservice", WithAddr(""), WithVersion("1.2.3"))
+	if err != nil || srv != nil {
+		t.Fatalf("Init = %v, %v; want nil server and no error", srv, err)
+	}
+	body := scrape(t)
+	for _, want := range []string{
+		"go_goroutines",
+		`boutique_build_info{goversion="`,
+		`service="testservice",version="1.2.3"} 1`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("metrics output missing %q", want)
+		}
+	}
+}
+
+func TestRegisterPodInfo(t *testing.T) {
+	registerPodInfo(podinfo.Info{Name: "cart-5c7f", Namespace: "boutique", Node: "node-1", CPULimitMillis: 250, MemoryLimitBytes: 1 << 30})
+	body := scrape(t)
+	for _, want := range []string{
+		`boutique_pod_info{namespace="boutique",node="node-1",pod="cart-5c7f"} 1`,
+		"boutique_pod_cpu_limit_cores 0.25",
+		"boutique_pod_memory_limit_bytes 1.073741824e+09",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("metrics output missing %q", want)
+		}
+	}
+}
+
+func scrape(t *testing.T) string {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	body, _ := io.ReadAll(rec.Body)
+	return string(body)
+}
+
+// findLine returns the first line of body starting with prefix.
+func findLine(body, prefix string) string {
+	for _, line := range strings.Split(body, "\n") {
+		if strings.HasPrefix(line, prefix) {
+			return line
+		}
+	}
+	return ""
+}
+
+// sample returns the value of series in an exposition body, or 0 if absent.
+func sample(body, series string) float64 {
+	if rest, ok := strings.CutPrefix(findLine(body, series+" "), series+" "); ok {
+		v, _ := strconv.ParseFloat(strings.Fields(rest)[0], 64)
+		return v
+	}
+	return 0
+}
+
+func TestObserveWithExemplar(t *testing.T) {
+	h := NewHistogramVec("test_exemplar_seconds", "Test histogram.", []float64{0.1, 1})
+	type traceKey struct{}
+	SetExemplarFunc(func(ctx context.Context) prometheus.Labels {
+		id, _ := ctx.Value(traceKey{}).(string)
+		if id == "" {
+			return nil
+		}
+		return prometheus.Labels{"trace_id": id}
+	})
+	defer SetExemplarFunc(nil)
+	before := sample(scrape(t), "boutique_test_exemplar_seconds_count")
+
+	Observe(context.WithValue(context.Background(), traceKey{}, "4bf92f3577b34da6a3ce929d0e0e4736"), h.WithLabelValues(), 0.5)
+	Observe(context.Background(), h.WithLabelValues(), 0.05)
+
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	req.Header.Set("Accept", "application/openmetrics-text; version=1.0.0")
+	Handler().ServeHTTP(rec, req)
+	body := rec.Body.String()
+	want := `# {trace_id="4bf92f3577b34da6a3ce929d0e0e4736"} 0.5`
+	if line := findLine(body, `boutique_test_exemplar_seconds_bucket{le="1.0"} `); !strings.Contains(line, want) {
+		t.Errorf("OpenMetrics output lacks %q:\n%s", want, body)
+	}
+	if strings.Contains(findLine(body, `boutique_test_exemplar_seconds_bucket{le="0.1"} `), "#") {
+		t.Error("observation without a trace got an exemplar")
+	}
+	// The text format carries no exemplars but still the observations.
+	text := scrape(t)
+	if got := sample(text, "boutique_test_exemplar_seconds_count") - before; got != 2 || strings.Contains(text, "trace_id") {
+		t.Errorf("text output, %v new observations:\n%s", got, text)
+	}
+}
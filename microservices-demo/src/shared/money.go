@@ -0,0 +1,487 @@
+package shared
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+var (
+	// ErrInvalidMoney is returned for amounts whose nanos are out of range or
+	// disagree in sign with the units, or whose currency is not a
+	// three-letter code.
+	ErrInvalidMoney = errors.New("money: invalid amount")
+	// ErrCurrencyMismatch is returned when combining different currencies.
+	ErrCurrencyMismatch = errors.New("money: currency mismatch")
+	// ErrMoneyOverflow is returned when a result does not fit in int64 units.
+	ErrMoneyOverflow = errors.New("money: overflow")
+)
+
+const nanosPerUnit = 1_000_000_000
+
+var bigNanosPerUnit = big.NewInt(nanosPerUnit)
+
+// Money is an amount in a currency, laid out like the demo's hipstershop.Money
+// proto: whole Units plus Nanos (10^-9 units) with the same sign. Values are
+// immutable; arithmetic returns a new Money or an error instead of
+// overflowing, mixing currencies or losing nanos.
+//
+// The zero Money has no currency and acts as zero in any currency, so sums
+// can start from it:
+//
+//	var total shared.Money
+//	for _, item := range items {
+//	    line, err := item.Price.Multiply(int64(item.Quantity))
+//	    ...
+//	    if total, err = total.Add(line); err != nil {
+//	        return err
+//	    }
+//	}
+//	tax, err := total.MultiplyRatio(825, 10000, shared.RoundHalfEven) // 8.25%
+type Money struct {
+	Currency string
+	Units    int64
+	Nanos    int32
+}
+
+// NewMoney validates and returns an amount.
+func NewMoney(currency string, units int64, nanos int32) (Money, error) {
+	m := Money{Currency: currency, Units: units, Nanos: nanos}
+	if !m.Valid() {
+		return Money{}, fmt.Errorf("%w: %s %d.%09d", ErrInvalidMoney, currency, units, nanos)
+	}
+	return m, nil
+}
+
+// ParseMoney parses a decimal amount such as "-12.5" or "1234.56" with up
+// to nine fractional digits.
+func ParseMoney(currency, amount string) (Money, error) {
+	s := strings.TrimSpace(amount)
+	neg := strings.HasPrefix(s, "-")
+	s = strings.TrimPrefix(strings.TrimPrefix(s, "-"), "+")
+	whole, frac, _ := strings.Cut(s, ".")
+	if whole == "" && frac == "" || len(frac) > 9 || !isDigits(whole) || !isDigits(frac) {
+		return Money{}, fmt.Errorf("%w: %q", ErrInvalidMoney, amount)
+	}
+	nanos := frac + strings.Repeat("0", 9-len(frac))
+	t, ok := new(big.Int).SetString("0"+whole+nanos, 10)
+	if !ok {
+		return Money{}, fmt.Errorf("%w: %q", ErrInvalidMoney, amount)
+	}
+	if neg {
+		t.Neg(t)
+	}
+	if !validCurrency(currency) {
+		return Money{}, fmt.Errorf("%w: currency %q", ErrInvalidMoney, currency)
+	}
+	return fromNanos(currency, t)
+}
+
+// MoneyFromMinor returns an amount given in the currency's minor unit,
+// e.g. cents for USD and yen for JPY.
+func MoneyFromMinor(currency string, minor int64) (Money, error) {
+	if !validCurrency(currency) {
+		return Money{}, fmt.Errorf("%w: currency %q", ErrInvalidMoney, currency)
+	}
+	t := new(big.Int).Mul(big.NewInt(minor), big.NewInt(minorUnitNanos(currency)))
+	return fromNanos(currency, t)
+}
+
+func isDigits(s string) bool {
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+func validCurrency(c string) bool {
+	if len(c) != 3 {
+		return false
+	}
+	for _, r := range c {
+		if r < 'A' || r > 'Z' {
+			return false
+		}
+	}
+	return true
+}
+
+// Valid reports whether m is the zero Money or has a three-letter currency,
+// nanos within ±999,999,999 and units and nanos of matching sign.
+func (m Money) Valid() bool {
+	if m == (Money{}) {
+		return true
+	}
+	if !validCurrency(m.Currency) || m.Nanos <= -nanosPerUnit || m.Nanos >= nanosPerUnit {
+		return false
+	}
+	return m.Units == 0 || m.Nanos == 0 || (m.Units < 0) == (m.Nanos < 0)
+}
+
+// nanos returns m as a count of nanos.
+func (m Money) nanos() *big.Int {
+	t := new(big.Int).Mul(big.NewInt(m.Units), bigNanosPerUnit)
+	return t.Add(t, big.NewInt(int64(m.Nanos)))
+}
+
+// fromNanos splits t into units and nanos, failing if the units overflow.
+func fromNanos(currency string, t *big.Int) (Money, error) {
+	units, nanos := new(big.Int).QuoRem(t, bigNanosPerUnit, new(big.Int))
+	if !units.IsInt64() {
+		return Money{}, ErrMoneyOverflow
+	}
+	return Money{Currency: currency, Units: units.Int64(), Nanos: int32(nanos.Int64())}, nil
+}
+
+// operands validates m and o and returns the currency of their result.
+func (m Money) operands(o Money) (string, error) {
+	if !m.Valid() || !o.Valid() {
+		return "", ErrInvalidMoney
+	}
+	switch {
+	case m.Currency == o.Currency:
+		return m.Currency, nil
+	case m == (Money{}):
+		return o.Currency, nil
+	case o == (Money{}):
+		return m.Currency, nil
+	}
+	return "", fmt.Errorf("%w: %s and %s", ErrCurrencyMismatch, m.Currency, o.Currency)
+}
+
+// Add returns m + o.
+func (m Money) Add(o Money) (Money, error) {
+	cur, err := m.operands(o)
+	if err != nil {
+		return Money{}, err
+	}
+	return fromNanos(cur, new(big.Int).Add(m.nanos(), o.nanos()))
+}
+
+// Sub returns m - o.
+func (m Money) Sub(o Money) (Money, error) {
+	cur, err := m.operands(o)
+	if err != nil {
+		return Money{}, err
+	}
+	return fromNanos(cur, new(big.Int).Sub(m.nanos(), o.nanos()))
+}
+
+// Multiply returns m × n, e.g. a unit price times a quantity.
+func (m Money) Multiply(n int64) (Money, error) {
+	if !m.Valid() {
+		return Money{}, ErrInvalidMoney
+	}
+	return fromNanos(m.Currency, new(big.Int).Mul(m.nanos(), big.NewInt(n)))
+}
+
+// MultiplyRatio returns m × num / den rounded to the currency's minor unit
+// with mode, e.g. MultiplyRatio(15, 100, RoundHalfUp) for a 15% discount.
+func (m Money) MultiplyRatio(num, den int64, mode RoundingMode) (Money, error) {
+	if !m.Valid() {
+		return Money{}, ErrInvalidMoney
+	}
+	if den == 0 {
+		return Money{}, errors.New("money: division by zero")
+	}
+	unit := big.NewInt(minorUnitNanos(m.Currency))
+	// Divide in minor units so the rounding happens at the currency's
+	// precision.
+	n := new(big.Int).Mul(m.nanos(), big.NewInt(num))
+	d := new(big.Int).Mul(big.NewInt(den), unit)
+	minor := roundQuo(n, d, mode)
+	return fromNanos(m.Currency, minor.Mul(minor, unit))
+}
+
+// Neg returns -m.
+func (m Money) Neg() Money {
+	return Money{Currency: m.Currency, Units: -m.Units, Nanos: -m.Nanos}
+}
+
+// Abs returns |m|.
+func (m Money) Abs() Money {
+	if m.IsNegative() {
+		return m.Neg()
+	}
+	return m
+}
+
+// IsZero reports whether the amount is zero, in any currency.
+func (m Money) IsZero() bool { return m.Units == 0 && m.Nanos == 0 }
+
+// IsNegative reports whether the amount is below zero.
+func (m Money) IsNegative() bool { return m.Units < 0 || (m.Units == 0 && m.Nanos < 0) }
+
+// IsPositive reports whether the amount is above zero.
+func (m Money) IsPositive() bool { return m.Units > 0 || (m.Units == 0 && m.Nanos > 0) }
+
+// Cmp compares m and o, returning -1, 0 or +1. Comparing different
+// currencies is an error.
+func (m Money) Cmp(o Money) (int, error) {
+	if _, err := m.operands(o); err != nil {
+		return 0, err
+	}
+	return m.nanos().Cmp(o.nanos()), nil
+}
+
+// Equal reports whether m and o are the same amount in the same currency,
+// treating the zero Money as zero in any currency.
+func (m Money) Equal(o Money) bool {
+	c, err := m.Cmp(o)
+	return err == nil && c == 0
+}
+
+// RoundingMode selects how Round and MultiplyRatio resolve amounts finer
+// than the currency's minor unit.
+type RoundingMode int
+
+const (
+	// RoundHalfEven rounds to the nearest unit and ties to even ("banker's
+	// rounding"), which does not bias sums of many rounded values.
+	RoundHalfEven RoundingMode = iota
+	// RoundHalfUp rounds to the nearest unit and ties away from zero.
+	RoundHalfUp
+	// RoundDown truncates toward zero.
+	RoundDown
+	// RoundUp rounds away from zero.
+	RoundUp
+)
+
+// roundQuo returns n / d rounded with mode.
+func roundQuo(n, d *big.Int, mode RoundingMode) *big.Int {
+	q, r := new(big.Int).QuoRem(n, d, new(big.Int))
+	if r.Sign() == 0 {
+		return q
+	}
+	away := int64(1)
+	if (n.Sign() < 0) != (d.Sign() < 0) {
+		away = -1
+	}
+	// Compare 2|r| with |d| to see which side of the midpoint r lies on.
+	twice := new(big.Int).Abs(r)
+	twice.Lsh(twice, 1)
+	c := twice.Cmp(new(big.Int).Abs(d))
+	up := false
+	switch mode {
+	case RoundUp:
+		up = true
+	case RoundHalfUp:
+		up = c >= 0
+	case RoundHalfEven:
+		up = c > 0 || (c == 0 && q.Bit(0) == 1)
+	}
+	if up {
+		q.Add(q, big.NewInt(away))
+	}
+	return q
+}
+
+// Round returns m rounded to the currency's minor unit.
+func (m Money) Round(mode RoundingMode) Money {
+	unit := big.NewInt(minorUnitNanos(m.Currency))
+	minor := roundQuo(m.nanos(), unit, mode)
+	r, err := fromNanos(m.Currency, minor.Mul(minor, unit))
+	if err != nil {
+		return m
+	}
+	return r
+}
+
+// Allocate splits m into parts proportional to ratios, in whole minor
+// units, handing the remainder out one minor unit at a time from the first
+// part so the parts always sum to m. Allocate(1, 1, 1) splits $10 into
+// $3.34, $3.33 and $3.33.
+func (m Money) Allocate(ratios ...int64) ([]Money, error) {
+	if !m.Valid() {
+		return nil, ErrInvalidMoney
+	}
+	var sum int64
+	for _, r := range ratios {
+		if r < 0 {
+			return nil, errors.New("money: negative allocation ratio")
+		}
+		sum += r
+	}
+	if sum <= 0 {
+		return nil, errors.New("money: allocation ratios sum to zero")
+	}
+
+	unit := big.NewInt(minorUnitNanos(m.Currency))
+	total := m.nanos()
+	sign := total.Sign()
+	total.Abs(total)
+	whole, frac := new(big.Int).QuoRem(total, unit, new(big.Int))
+
+	shares := make([]*big.Int, len(ratios))
+	left := new(big.Int).Set(whole)
+	for i, r := range ratios {
+		shares[i] = new(big.Int).Mul(whole, big.NewInt(r))
+		shares[i].Quo(shares[i], big.NewInt(sum))
+		left.Sub(left, shares[i])
+	}
+	for i := 0; left.Sign() > 0; i = (i + 1) % len(shares) {
+		if ratios[i] == 0 {
+			continue
+		}
+		shares[i].Add(shares[i], big.NewInt(1))
+		left.Sub(left, big.NewInt(1))
+	}
+
+	out := make([]Money, len(shares))
+	for i, s := range shares {
+		s.Mul(s, unit)
+		if i == 0 {
+			s.Add(s, frac)
+		}
+		if sign < 0 {
+			s.Neg(s)
+		}
+		var err error
+		if out[i], err = fromNanos(m.Currency, s); err != nil {
+			return nil, err
+		}
+	}
+	return out, nil
+}
+
+// Split divides m into n near-equal parts; see Allocate.
+func (m Money) Split(n int) ([]Money, error) {
+	if n <= 0 {
+		return nil, errors.New("money: split into fewer than one part")
+	}
+	ratios := make([]int64, n)
+	for i := range ratios {
+		ratios[i] = 1
+	}
+	return m.Allocate(ratios...)
+}
+
+// Amount returns the decimal amount with at least the currency's minor
+// digits, e.g. "12.50" for USD, "1250" for JPY and "0.125" for a sub-cent
+// USD amount.
+func (m Money) Amount() string {
+	digits := CurrencyDigits(m.Currency)
+	units, nanos := m.Units, m.Nanos
+	sign := ""
+	if m.IsNegative() {
+		sign, units, nanos = "-", -units, -nanos
+	}
+	frac := strings.TrimRight(fmt.Sprintf("%09d", nanos), "0")
+	if len(frac) < digits {
+		frac += strings.Repeat("0", digits-len(frac))
+	}
+	if frac == "" {
+		return fmt.Sprintf("%s%d", sign, units)
+	}
+	return fmt.Sprintf("%s%d.%s", sign, units, frac)
+}
+
+// String returns the currency code and amount, e.g. "USD 12.50".
+func (m Money) String() string {
+	if m.Currency == "" {
+		return m.Amount()
+	}
+	return m.Currency + " " + m.Amount()
+}
+
+// moneyJSON is Money's JSON form. The amount is a decimal string so
+// JavaScript clients do not lose precision; UnmarshalJSON also accepts the
+// proto field names.
+type moneyJSON struct {
+	Currency         string      `json:"currency"`
+	Amount           string      `json:"amount"`
+	CurrencyCode     string      `json:"currency_code,omitempty"`
+	CurrencyCodeJSON string      `json:"currencyCode,omitempty"`
+	Units            json.Number `json:"units,omitempty"`
+	Nanos            int32       `json:"nanos,omitempty"`
+}
+
+// MarshalJSON encodes m as {"currency":"USD","amount":"12.50"}.
+func (m Money) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Currency string `json:"currency"`
+		Amount   string `json:"amount"`
+	}{m.Currency, m.Amount()})
+}
+
+// UnmarshalJSON decodes the form MarshalJSON writes, or the proto JSON form
+// {"currencyCode":"USD","units":"12","nanos":500000000}.
+func (m *Money) UnmarshalJSON(b []byte) error {
+	var j moneyJSON
+	if err := json.Unmarshal(b, &j); err != nil {
+		return err
+	}
+	cur := j.Currency
+	for _, c := range []string{j.CurrencyCode, j.CurrencyCodeJSON} {
+		if cur == "" {
+			cur = c
+		}
+	}
+	if j.Amount != "" {
+		v, err := ParseMoney(cur, j.Amount)
+		if err != nil {
+			return err
+		}
+		*m = v
+		return nil
+	}
+	var units int64
+	if j.Units != "" {
+		var err error
+		if units, err = j.Units.Int64(); err != nil {
+			return fmt.Errorf("%w: units %q", ErrInvalidMoney, j.Units)
+		}
+	}
+	v, err := NewMoney(cur, units, j.Nanos)
+	if err != nil {
+		return err
+	}
+	*m = v
+	return nil
+}
+
+// moneyMessage is satisfied by every service's generated hipstershop.Money.
+type moneyMessage interface {
+	GetCurrencyCode() string
+	GetUnits() int64
+	GetNanos() int32
+}
+
+// MoneyFromProto converts a generated hipstershop.Money, validating it.
+func MoneyFromProto(p moneyMessage) (Money, error) {
+	return NewMoney(p.GetCurrencyCode(), p.GetUnits(), p.GetNanos())
+}
+
+// MoneyToProto converts m to a service's generated Money message. Each
+// service generates its own copy of the proto, so the type is named at the
+// call site:
+//
+//	price := shared.MoneyToProto[pb.Money](total)
+//
+// It panics if T lacks the currency_code, units and nanos fields.
+func MoneyToProto[T any, P interface {
+	*T
+	proto.Message
+}](m Money) P {
+	p := P(new(T))
+	r := p.ProtoReflect()
+	fields := r.Descriptor().Fields()
+	set := func(name string, v protoreflect.Value) {
+		fd := fields.ByName(protoreflect.Name(name))
+		if fd == nil {
+			panic(fmt.Sprintf("money: %s has no field %q", r.Descriptor().FullName(), name))
+		}
+		r.Set(fd, v)
+	}
+	set("currency_code", protoreflect.ValueOfString(m.Currency))
+	set("units", protoreflect.ValueOfInt64(m.Units))
+	set("nanos", protoreflect.ValueOfInt32(m.Nanos))
+	return p
+}
@@ -0,0 +1,107 @@
+package shared
+
+import (
+	"strings"
+)
+
+// zeroDigitCurrencies have no minor unit in ISO 4217 among the currencies
+// the demo converts between.
+var zeroDigitCurrencies = map[string]bool{"JPY": true, "KRW": true, "ISK": true}
+
+// currencySymbols are used by Format; other currencies show their code.
+var currencySymbols = map[string]string{
+	"USD": "$", "EUR": "€", "GBP": "£", "JPY": "¥", "CNY": "CN¥", "KRW": "₩",
+	"INR": "₹", "ILS": "₪", "BRL": "R$", "CAD": "CA$", "AUD": "A$", "NZD": "NZ$",
+	"HKD": "HK$", "MXN": "MX$", "TRY": "₺", "RUB": "₽", "THB": "฿", "PHP": "₱",
+}
+
+// CurrencyDigits returns the number of minor-unit digits of a currency, 2
+// unless it is known to have none.
+func CurrencyDigits(currency string) int {
+	if zeroDigitCurrencies[currency] {
+		return 0
+	}
+	return 2
+}
+
+// minorUnitNanos returns the size of the currency's minor unit in nanos.
+func minorUnitNanos(currency string) int64 {
+	if zeroDigitCurrencies[currency] {
+		return nanosPerUnit
+	}
+	return nanosPerUnit / 100
+}
+
+// localeFormat describes how a locale writes amounts.
+type localeFormat struct {
+	decimal, group string
+	// suffix puts the symbol after the number.
+	suffix bool
+	// space separates the symbol from the number; a no-break space where
+	// the locale uses one.
+	space string
+}
+
+// localeFormats are keyed by language subtag; others fall back to English.
+var localeFormats = map[string]localeFormat{
+	"en": {decimal: ".", group: ","},
+	"ja": {decimal: ".", group: ","},
+	"zh": {decimal: ".", group: ","},
+	"de": {decimal: ",", group: ".", suffix: true, space: "\u00a0"},
+	"es": {decimal: ",", group: ".", suffix: true, space: "\u00a0"},
+	"it": {decimal: ",", group: ".", suffix: true, space: "\u00a0"},
+	"fr": {decimal: ",", group: "\u202f", suffix: true, space: "\u00a0"},
+	"nl": {decimal: ",", group: ".", space: "\u00a0"},
+	"pt": {decimal: ",", group: ".", space: "\u00a0"},
+}
+
+// Format writes m for display in a BCP 47 locale such as "en-US" or
+// "de-DE", rounded half-even to the currency's minor unit: "$1,234.50",
+// "1.234,50 €", "¥1,235". Currencies without a known symbol show their
+// code, e.g. "CHF 12.00".
+func (m Money) Format(locale string) string {
+	lang, _, _ := strings.Cut(strings.ReplaceAll(locale, "_", "-"), "-")
+	f, ok := localeFormats[strings.ToLower(lang)]
+	if !ok {
+		f = localeFormats["en"]
+	}
+
+	r := m.Round(RoundHalfEven)
+	neg := r.IsNegative()
+	amount := strings.TrimPrefix(r.Amount(), "-")
+	whole, frac, _ := strings.Cut(amount, ".")
+	var b strings.Builder
+	for i, c := range whole {
+		if i > 0 && (len(whole)-i)%3 == 0 {
+			b.WriteString(f.group)
+		}
+		b.WriteRune(c)
+	}
+	if frac != "" {
+		b.WriteString(f.decimal)
+		b.WriteString(frac)
+	}
+	number := b.String()
+
+	symbol, ok := currencySymbols[m.Currency]
+	space := f.space
+	if !ok {
+		symbol = m.Currency
+		if space == "" {
+			space = " "
+		}
+	}
+	var out string
+	switch {
+	case symbol == "":
+		out = number
+	case f.suffix:
+		out = number + space + symbol
+	default:
+		out = symbol + space + number
+	}
+	if neg {
+		return "-" + out
+	}
+	return out
+}
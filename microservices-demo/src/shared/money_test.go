@@ -0,0 +1,205 @@
+package shared
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"testing"
+
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+func mustMoney(t *testing.T, currency, amount string) Money {
+	t.Helper()
+	m, err := ParseMoney(currency, amount)
+	if err != nil {
+		t.Fatalf("ParseMoney(%q, %q): %v", currency, amount, err)
+	}
+	return m
+}
+
+func TestParseMoney(t *testing.T) {
+	tests := []struct {
+		in    string
+		units int64
+		nanos int32
+	}{
+		{"12.34", 12, 340_000_000},
+		{"-0.5", 0, -500_000_000},
+		{"-3.000000001", -3, -1},
+		{"7", 7, 0},
+		{".25", 0, 250_000_000},
+	}
+	for _, tt := range tests {
+		m := mustMoney(t, "USD", tt.in)
+		if m.Units != tt.units || m.Nanos != tt.nanos {
+			t.Errorf("ParseMoney(%q) = %d, %d; want %d, %d", tt.in, m.Units, m.Nanos, tt.units, tt.nanos)
+		}
+	}
+	for _, in := range []string{"", "1.2.3", "abc", "1.0000000001", "-"} {
+		if _, err := ParseMoney("USD", in); !errors.Is(err, ErrInvalidMoney) {
+			t.Errorf("ParseMoney(%q) error = %v", in, err)
+		}
+	}
+	if _, err := ParseMoney("usd", "1"); err == nil {
+		t.Error("lower-case currency accepted")
+	}
+}
+
+func TestMoneyArithmetic(t *testing.T) {
+	a, b := mustMoney(t, "USD", "10.75"), mustMoney(t, "USD", "0.50")
+	if sum, _ := a.Add(b); sum != mustMoney(t, "USD", "11.25") {
+		t.Errorf("Add = %v", sum)
+	}
+	if diff, _ := b.Sub(a); diff != mustMoney(t, "USD", "-10.25") {
+		t.Errorf("Sub = %v", diff)
+	}
+	if prod, _ := a.Multiply(3); prod != mustMoney(t, "USD", "32.25") {
+		t.Errorf("Multiply = %v", prod)
+	}
+	if _, err := a.Add(mustMoney(t, "EUR", "1")); !errors.Is(err, ErrCurrencyMismatch) {
+		t.Errorf("mixed currencies: %v", err)
+	}
+	if _, err := (Money{Currency: "USD", Units: math.MaxInt64}).Add(mustMoney(t, "USD", "1")); !errors.Is(err, ErrMoneyOverflow) {
+		t.Errorf("overflow: %v", err)
+	}
+	if _, err := (Money{Currency: "USD", Units: 1, Nanos: -1}).Add(b); !errors.Is(err, ErrInvalidMoney) {
+		t.Errorf("mismatched signs: %v", err)
+	}
+
+	var total Money
+	total, _ = total.Add(a)
+	if total != a {
+		t.Errorf("zero Money + a = %v, want %v", total, a)
+	}
+	if c, err := b.Cmp(a); c != -1 || err != nil {
+		t.Errorf("Cmp = %d, %v", c, err)
+	}
+}
+
+func TestMoneyRounding(t *testing.T) {
+	tests := []struct {
+		amount string
+		mode   RoundingMode
+		want   string
+	}{
+		{"2.345", RoundHalfEven, "2.34"},
+		{"2.355", RoundHalfEven, "2.36"},
+		{"2.345", RoundHalfUp, "2.35"},
+		{"-2.345", RoundHalfUp, "-2.35"},
+		{"2.349", RoundDown, "2.34"},
+		{"2.341", RoundUp, "2.35"},
+		{"-2.341", RoundUp, "-2.35"},
+	}
+	for _, tt := range tests {
+		got := mustMoney(t, "USD", tt.amount).Round(tt.mode)
+		if got != mustMoney(t, "USD", tt.want) {
+			t.Errorf("Round(%s, %d) = %v, want %s", tt.amount, tt.mode, got, tt.want)
+		}
+	}
+	if got := mustMoney(t, "JPY", "1234.5").Round(RoundHalfEven); got.Amount() != "1234" {
+		t.Errorf("JPY round = %s", got.Amount())
+	}
+
+	tax, err := mustMoney(t, "USD", "19.99").MultiplyRatio(825, 10000, RoundHalfEven)
+	if err != nil || tax != mustMoney(t, "USD", "1.65") {
+		t.Errorf("8.25%% of 19.99 = %v, %v", tax, err)
+	}
+}
+
+func TestMoneyAllocate(t *testing.T) {
+	parts, err := mustMoney(t, "USD", "10").Split(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"3.34", "3.33", "3.33"}
+	for i, p := range parts {
+		if p.Amount() != want[i] {
+			t.Errorf("part %d = %s, want %s", i, p.Amount(), want[i])
+		}
+	}
+
+	parts, _ = mustMoney(t, "USD", "-0.05").Allocate(70, 30)
+	if parts[0].Amount() != "-0.04" || parts[1].Amount() != "-0.01" {
+		t.Errorf("negative allocation = %v", parts)
+	}
+
+	parts, _ = mustMoney(t, "USD", "1.005").Allocate(1, 1)
+	sum, _ := parts[0].Add(parts[1])
+	if sum != mustMoney(t, "USD", "1.005") {
+		t.Errorf("parts sum to %v", sum)
+	}
+	if _, err := mustMoney(t, "USD", "1").Allocate(0, 0); err == nil {
+		t.Error("zero ratios accepted")
+	}
+}
+
+func TestMoneyFormat(t *testing.T) {
+	tests := []struct {
+		m      Money
+		locale string
+		want   string
+	}{
+		{mustMoney(t, "USD", "1234.5"), "en-US", "$1,234.50"},
+		{mustMoney(t, "USD", "-0.5"), "en", "-$0.50"},
+		{mustMoney(t, "EUR", "1234.5"), "de-DE", "1.234,50\u00a0€"},
+		{mustMoney(t, "EUR", "1234567.891"), "fr_FR", "1\u202f234\u202f567,89\u00a0€"},
+		{mustMoney(t, "JPY", "1234.6"), "ja-JP", "¥1,235"},
+		{mustMoney(t, "CHF", "12"), "en-US", "CHF\u00a012.00"},
+		{mustMoney(t, "USD", "1"), "xx", "$1.00"},
+	}
+	for _, tt := range tests {
+		if got := tt.m.Format(tt.locale); got != tt.want {
+			t.Errorf("%v.Format(%q) = %q, want %q", tt.m, tt.locale, got, tt.want)
+		}
+	}
+	if s := mustMoney(t, "USD", "0.125").String(); s != "USD 0.125" {
+		t.Errorf("String = %q", s)
+	}
+}
+
+func TestMoneyJSON(t *testing.T) {
+	m := mustMoney(t, "USD", "12.5")
+	b, err := json.Marshal(m)
+	if err != nil || string(b) != `{"currency":"USD","amount":"12.50"}` {
+		t.Fatalf("Marshal = %s, %v", b, err)
+	}
+	var back Money
+	if err := json.Unmarshal(b, &back); err != nil || back != m {
+		t.Errorf("round trip = %v, %v", back, err)
+	}
+	var fromProto Money
+	if err := json.Unmarshal([]byte(`{"currencyCode":"USD","units":"12","nanos":500000000}`), &fromProto); err != nil || fromProto != m {
+		t.Errorf("proto JSON = %v, %v", fromProto, err)
+	}
+	if err := json.Unmarshal([]byte(`{"currency":"USD","units":"1","nanos":-5}`), &back); err == nil {
+		t.Error("invalid proto JSON accepted")
+	}
+}
+
+type fakeMoneyProto struct {
+	code  string
+	units int64
+	nanos int32
+}
+
+func (f fakeMoneyProto) GetCurrencyCode() string { return f.code }
+func (f fakeMoneyProto) GetUnits() int64         { return f.units }
+func (f fakeMoneyProto) GetNanos() int32         { return f.nanos }
+
+func TestMoneyProto(t *testing.T) {
+	m, err := MoneyFromProto(fakeMoneyProto{"EUR", 3, 990_000_000})
+	if err != nil || m.Amount() != "3.99" {
+		t.Errorf("MoneyFromProto = %v, %v", m, err)
+	}
+	if _, err := MoneyFromProto(fakeMoneyProto{"EUR", -3, 1}); err == nil {
+		t.Error("invalid proto accepted")
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("MoneyToProto did not panic for a message without money fields")
+		}
+	}()
+	MoneyToProto[wrapperspb.StringValue](m)
+}
@@ -0,0 +1,12 @@
+package shared
+
+import "github.com/GoogleCloudPlatform/microservices-demo/src/shared/podinfo"
+
+// PodInfo returns the Kubernetes pod the service runs in, read once from the
+// downward API (see package podinfo for the pod spec to expose it). Loggers,
+// trace resources, profiles and metrics.Init pick up the same values
+// without being told.
+//
+//	pod := shared.PodInfo()
+//	log.Printf("Running as %s/%s on %s", pod.Namespace, pod.Name, pod.Node)
+func PodInfo() podinfo.Info { return podinfo.Get() }
@@ -0,0 +1,137 @@
+// Package podinfo reports which Kubernetes pod a service runs in, from the
+// downward API. The same values tag every log entry written with a
+// logging.New logger, become k8s.* trace resource attributes, label
+// profiles, and are exported as the boutique_pod_info and limit gauges by
+// metrics.Init, so a log line, trace or dashboard can be traced back to the
+// pod and node behind it.
+//
+// Expose the values in the pod spec:
+//
+//	env:
+//	- name: POD_NAME
+//	  valueFrom: {fieldRef: {fieldPath: metadata.name}}
+//	- name: POD_NAMESPACE
+//	  valueFrom: {fieldRef: {fieldPath: metadata.namespace}}
+//	- name: NODE_NAME
+//	  valueFrom: {fieldRef: {fieldPath: spec.nodeName}}
+//	- name: POD_IP
+//	  valueFrom: {fieldRef: {fieldPath: status.podIP}}
+//	- name: CPU_LIMIT
+//	  valueFrom: {resourceFieldRef: {resource: limits.cpu, divisor: 1m}}
+//	- name: MEMORY_LIMIT
+//	  valueFrom: {resourceFieldRef: {resource: limits.memory}}
+//	volumeMounts:
+//	- {name: podinfo, mountPath: /etc/podinfo}
+//	volumes:
+//	- name: podinfo
+//	  downwardAPI:
+//	    items:
+//	    - {path: labels, fieldRef: {fieldPath: metadata.labels}}
+//
+// Labels can only be exposed as a file, read from PODINFO_DIR (default
+// /etc/podinfo). Outside Kubernetes every value is simply empty.
+package podinfo
+
+import (
+	"bufio"
+	"io/fs"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+// DefaultDir is where the downward-API volume is mounted unless PODINFO_DIR
+// says otherwise.
+const DefaultDir = "/etc/podinfo"
+
+// Info describes the pod. Fields the downward API does not provide are
+// zero.
+type Info struct {
+	Name      string            `json:"name,omitempty"`
+	Namespace string            `json:"namespace,omitempty"`
+	Node      string            `json:"node,omitempty"`
+	IP        string            `json:"ip,omitempty"`
+	Labels    map[string]string `json:"labels,omitempty"`
+	// CPULimitMillis is the container's CPU limit in millicores.
+	CPULimitMillis int64 `json:"cpuLimitMillis,omitempty"`
+	// MemoryLimitBytes is the container's memory limit.
+	MemoryLimitBytes int64 `json:"memoryLimitBytes,omitempty"`
+}
+
+var current atomic.Pointer[Info]
+
+// Get returns the pod information, read on first use and cached. The
+// Labels map is shared and must not be modified.
+func Get() Info {
+	if i := current.Load(); i != nil {
+		return *i
+	}
+	return Reload()
+}
+
+// Reload reads the pod information again, for labels changed on a running
+// pod, which the kubelet rewrites in the volume, or tests changing the
+// environment. Loggers and resources built earlier keep the old values.
+func Reload() Info {
+	dir := os.Getenv("PODINFO_DIR")
+	if dir == "" {
+		dir = DefaultDir
+	}
+	i := load(os.Getenv, os.DirFS(dir))
+	current.Store(&i)
+	return i
+}
+
+// load reads the environment through getenv and the volume from fsys.
+func load(getenv func(string) string, fsys fs.FS) Info {
+	i := Info{
+		Name:      getenv("POD_NAME"),
+		Namespace: getenv("POD_NAMESPACE"),
+		Node:      getenv("NODE_NAME"),
+		IP:        getenv("POD_IP"),
+	}
+	i.CPULimitMillis, _ = strconv.ParseInt(getenv("CPU_LIMIT"), 10, 64)
+	i.MemoryLimitBytes, _ = strconv.ParseInt(getenv("MEMORY_LIMIT"), 10, 64)
+	if b, err := fs.ReadFile(fsys, "labels"); err == nil {
+		i.Labels = parseLabels(string(b))
+	}
+	return i
+}
+
+// parseLabels parses the downward API's key="value" lines.
+func parseLabels(s string) map[string]string {
+	labels := make(map[string]string)
+	sc := bufio.NewScanner(strings.NewReader(s))
+	for sc.Scan() {
+		k, v, ok := strings.Cut(sc.Text(), "=")
+		if !ok || k == "" {
+			continue
+		}
+		if u, err := strconv.Unquote(v); err == nil {
+			v = u
+		}
+		labels[k] = v
+	}
+	return labels
+}
+
+// InKubernetes reports whether the downward API named the pod.
+func (i Info) InKubernetes() bool { return i.Name != "" }
+
+// LogAttrs returns the pod, namespace and node that are known, as attached
+// to log entries.
+func (i Info) LogAttrs() []slog.Attr {
+	var attrs []slog.Attr
+	for _, a := range []struct{ key, value string }{
+		{"pod", i.Name},
+		{"namespace", i.Namespace},
+		{"node", i.Node},
+	} {
+		if a.value != "" {
+			attrs = append(attrs, slog.String(a.key, a.value))
+		}
+	}
+	return attrs
+}
@@ -0,0 +1,53 @@
+package podinfo
+
+import (
+	"testing"
+	"testing/fstest"
+)
+
+func TestLoad(t *testing.T) {
+	env := map[string]string{
+		"POD_NAME":      "checkout-7d9f",
+		"POD_NAMESPACE": "boutique",
+		"NODE_NAME":     "gke-pool-1",
+		"CPU_LIMIT":     "500",
+		"MEMORY_LIMIT":  "134217728",
+	}
+	fsys := fstest.MapFS{"labels": {Data: []byte("app=\"checkoutservice\"\npod-template-hash=\"7d9f\"\nnote=\"a \\\"b\\\"\"\n")}}
+	i := load(func(k string) string { return env[k] }, fsys)
+
+	if i.Name != "checkout-7d9f" || i.Namespace != "boutique" || i.Node != "gke-pool-1" || !i.InKubernetes() {
+		t.Errorf("load = %+v", i)
+	}
+	if i.CPULimitMillis != 500 || i.MemoryLimitBytes != 128<<20 {
+		t.Errorf("limits = %d millicores, %d bytes", i.CPULimitMillis, i.MemoryLimitBytes)
+	}
+	for k, want := range map[string]string{"app": "checkoutservice", "pod-template-hash": "7d9f", "note": `a "b"`} {
+		if got := i.Labels[k]; got != want {
+			t.Errorf("label %s = %q, want %q", k, got, want)
+		}
+	}
+	if got := len(i.LogAttrs()); got != 3 {
+		t.Errorf("LogAttrs has %d attrs, want 3", got)
+	}
+}
+
+func TestLoadOutsideKubernetes(t *testing.T) {
+	i := load(func(string) string { return "" }, fstest.MapFS{})
+	if i.InKubernetes() || i.Labels != nil || len(i.LogAttrs()) != 0 {
+		t.Errorf("load = %+v, want zero Info", i)
+	}
+}
+
+func TestReload(t *testing.T) {
+	t.Setenv("PODINFO_DIR", t.TempDir())
+	t.Setenv("POD_NAME", "a")
+	Reload()
+	t.Setenv("POD_NAME", "b")
+	if got := Get().Name; got != "a" {
+		t.Errorf("Get().Name = %q, want the cached a", got)
+	}
+	if got := Reload().Name; got != "b" {
+		t.Errorf("Reload().Name = %q, want b", got)
+	}
+}
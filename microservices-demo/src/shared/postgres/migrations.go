@@ -0,0 +1,126 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io/fs"
+	"log"
+	"path"
+	"sort"
+	"strings"
+)
+
+// migrationLockID is the Postgres advisory lock key held while migrating, so
+// replicas starting together apply each migration exactly once.
+const migrationLockID = 0x626f7574697175 // "boutiqu"
+
+// Migration is one schema change.
+type Migration struct {
+	// Version identifies the migration and orders it: migrations run in
+	// ascending lexical order of Version. It is the file name without .sql.
+	Version string
+	SQL     string
+}
+
+// MigrationSource lists the migrations to apply.
+type MigrationSource func() ([]Migration, error)
+
+// MigrationsFS returns a source reading every *.sql file in dir of fsys,
+// typically an embed.FS. Name files so they sort in application order, e.g.
+// 0001_create_orders.sql.
+func MigrationsFS(fsys fs.FS, dir string) MigrationSource {
+	return func() ([]Migration, error) {
+		entries, err := fs.ReadDir(fsys, dir)
+		if err != nil {
+			return nil, fmt.Errorf("migrations: %w", err)
+		}
+		var ms []Migration
+		for _, e := range entries {
+			if e.IsDir() || !strings.HasSuffix(e.Name(), ".sql") {
+				continue
+			}
+			b, err := fs.ReadFile(fsys, path.Join(dir, e.Name()))
+			if err != nil {
+				return nil, fmt.Errorf("migrations: %w", err)
+			}
+			ms = append(ms, Migration{Version: strings.TrimSuffix(e.Name(), ".sql"), SQL: string(b)})
+		}
+		sort.Slice(ms, func(i, j int) bool { return ms[i].Version < ms[j].Version })
+		return ms, nil
+	}
+}
+
+// RunMigrations applies the migrations from src that are not yet recorded in
+// the schema_migrations table, each in its own transaction, while holding an
+// advisory lock so concurrent replicas do not race.
+func RunMigrations(ctx context.Context, db *sql.DB, src MigrationSource) error {
+	ms, err := src()
+	if err != nil {
+		return err
+	}
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("migrations: %w", err)
+	}
+	defer conn.Close()
+
+	if _, err := conn.ExecContext(ctx, "SELECT pg_advisory_lock($1)", migrationLockID); err != nil {
+		return fmt.Errorf("migrations: acquiring lock: %w", err)
+	}
+	defer conn.ExecContext(context.WithoutCancel(ctx), "SELECT pg_advisory_unlock($1)", migrationLockID)
+
+	if _, err := conn.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+	version    text PRIMARY KEY,
+	applied_at timestamptz NOT NULL DEFAULT now()
+)`); err != nil {
+		return fmt.Errorf("migrations: creating schema_migrations: %w", err)
+	}
+	applied, err := appliedMigrations(ctx, conn)
+	if err != nil {
+		return err
+	}
+
+	for _, m := range ms {
+		if applied[m.Version] {
+			continue
+		}
+		if err := applyMigration(ctx, conn, m); err != nil {
+			return fmt.Errorf("migrations: %s: %w", m.Version, err)
+		}
+		log.Printf("Migrations: Applied %s", m.Version)
+	}
+	return nil
+}
+
+func appliedMigrations(ctx context.Context, conn *sql.Conn) (map[string]bool, error) {
+	rows, err := conn.QueryContext(ctx, "SELECT version FROM schema_migrations")
+	if err != nil {
+		return nil, fmt.Errorf("migrations: listing applied: %w", err)
+	}
+	defer rows.Close()
+	applied := make(map[string]bool)
+	for rows.Next() {
+		var v string
+		if err := rows.Scan(&v); err != nil {
+			return nil, fmt.Errorf("migrations: listing applied: %w", err)
+		}
+		applied[v] = true
+	}
+	return applied, rows.Err()
+}
+
+func applyMigration(ctx context.Context, conn *sql.Conn, m Migration) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.ExecContext(ctx, m.SQL); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, "INSERT INTO schema_migrations (version) VALUES ($1)", m.Version); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
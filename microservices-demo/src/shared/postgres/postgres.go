@@ -0,0 +1,244 @@
+// Package postgres opens pooled database/sql connections to Postgres
+// through pgx, configured from the PG* and DB_* environment variables, with
+// OpenTelemetry spans and latency metrics per statement, and applies
+// embedded schema migrations, keeping pgx out of services without a
+// database.
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/stdlib"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	otelcodes "go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+
+	shared "github.com/GoogleCloudPlatform/microservices-demo/src/shared"
+	"github.com/GoogleCloudPlatform/microservices-demo/src/shared/health"
+	"github.com/GoogleCloudPlatform/microservices-demo/src/shared/metrics"
+)
+
+var querySeconds = metrics.NewHistogramVec("postgres_query_seconds",
+	"Postgres statement latency in seconds, by operation and result (ok, error).", nil, "operation", "result")
+
+// Config holds Postgres connection and pool settings, loaded from the
+// environment by ConfigFromEnv. DSN, when set, takes precedence over
+// the individual connection fields; either may use any form pgx accepts.
+type Config struct {
+	DSN      string `env:"DATABASE_URL"`
+	Host     string `env:"PGHOST" default:"localhost"`
+	Port     int    `env:"PGPORT" default:"5432"`
+	User     string `env:"PGUSER" default:"postgres"`
+	Password string `env:"PGPASSWORD"`
+	Database string `env:"PGDATABASE"`
+	SSLMode  string `env:"PGSSLMODE" default:"prefer"`
+
+	ConnectTimeout  time.Duration `env:"DB_CONNECT_TIMEOUT" default:"5s"`
+	MaxOpenConns    int           `env:"DB_MAX_OPEN_CONNS" default:"10"`
+	MaxIdleConns    int           `env:"DB_MAX_IDLE_CONNS" default:"5"`
+	ConnMaxLifetime time.Duration `env:"DB_CONN_MAX_LIFETIME" default:"30m"`
+	ConnMaxIdleTime time.Duration `env:"DB_CONN_MAX_IDLE_TIME" default:"5m"`
+}
+
+// ConfigFromEnv loads a Config with shared.LoadConfig.
+func ConfigFromEnv() (Config, error) {
+	var cfg Config
+	err := shared.LoadConfig(&cfg)
+	return cfg, err
+}
+
+// dsn returns the connection string for cfg.
+func (cfg Config) dsn() string {
+	if cfg.DSN != "" {
+		return cfg.DSN
+	}
+	kv := []string{
+		"host=" + quoteDSN(cfg.Host),
+		fmt.Sprintf("port=%d", cfg.Port),
+		"user=" + quoteDSN(cfg.User),
+		"sslmode=" + quoteDSN(cfg.SSLMode),
+	}
+	if cfg.Password != "" {
+		kv = append(kv, "password="+quoteDSN(cfg.Password))
+	}
+	if cfg.Database != "" {
+		kv = append(kv, "dbname="+quoteDSN(cfg.Database))
+	}
+	return strings.Join(kv, " ")
+}
+
+// quoteDSN quotes a keyword/value connection string value.
+func quoteDSN(s string) string {
+	if s != "" && !strings.ContainsAny(s, ` '\`) {
+		return s
+	}
+	return "'" + strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(s) + "'"
+}
+
+type options struct {
+	migrations MigrationSource
+	dial       func(ctx context.Context, network, addr string) (net.Conn, error)
+	noTracing  bool
+	noPing     bool
+}
+
+// Option configures Open.
+type Option func(*options)
+
+// WithMigrations runs the migrations in src before Open
+// returns; see RunMigrations.
+func WithMigrations(src MigrationSource) Option {
+	return func(o *options) { o.migrations = src }
+}
+
+// WithDialer replaces the network dialer, for connectors such as the
+// AlloyDB or Cloud SQL ones that tunnel connections themselves.
+func WithDialer(dial func(ctx context.Context, network, addr string) (net.Conn, error)) Option {
+	return func(o *options) { o.dial = dial }
+}
+
+// WithoutTracing disables OpenTelemetry spans for statements.
+func WithoutTracing() Option {
+	return func(o *options) { o.noTracing = true }
+}
+
+// WithoutPing skips the connectivity check, so Open succeeds while the
+// database is still unreachable. Incompatible with migrations.
+func WithoutPing() Option {
+	return func(o *options) { o.noPing = true }
+}
+
+// Open returns a pooled *sql.DB backed by pgx, with the pool limits from
+// cfg, OpenTelemetry spans and the boutique_postgres_query_seconds metric
+// for every statement. It pings the database and applies any
+// configured migrations before returning:
+//
+//	//go:embed migrations/*.sql
+//	var migrations embed.FS
+//
+//	cfg, err := postgres.ConfigFromEnv()
+//	...
+//	db, err := postgres.Open(ctx, cfg,
+//	    postgres.WithMigrations(postgres.MigrationsFS(migrations, "migrations")))
+//	if err != nil {
+//	    log.Fatal(err)
+//	}
+//	sm.Register("postgres", func(context.Context) error { return db.Close() })
+//	registry.Register("postgres", postgres.HealthCheck(db))
+func Open(ctx context.Context, cfg Config, opts ...Option) (*sql.DB, error) {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+	connCfg, err := pgx.ParseConfig(cfg.dsn())
+	if err != nil {
+		return nil, fmt.Errorf("postgres: parsing config: %w", err)
+	}
+	if cfg.ConnectTimeout > 0 {
+		connCfg.ConnectTimeout = cfg.ConnectTimeout
+	}
+	if o.dial != nil {
+		connCfg.DialFunc = o.dial
+	}
+	connCfg.Tracer = &pgxTracer{tracing: !o.noTracing, tracer: otel.Tracer("github.com/GoogleCloudPlatform/microservices-demo/src/shared/postgres")}
+
+	db := stdlib.OpenDB(*connCfg)
+	db.SetMaxOpenConns(cfg.MaxOpenConns)
+	db.SetMaxIdleConns(cfg.MaxIdleConns)
+	db.SetConnMaxLifetime(cfg.ConnMaxLifetime)
+	db.SetConnMaxIdleTime(cfg.ConnMaxIdleTime)
+
+	if !o.noPing {
+		pingCtx, cancel := context.WithTimeout(ctx, cfg.ConnectTimeout+time.Second)
+		defer cancel()
+		if err := db.PingContext(pingCtx); err != nil {
+			db.Close()
+			return nil, fmt.Errorf("postgres: ping %s: %w", connCfg.Host, err)
+		}
+	}
+	if o.migrations != nil {
+		if err := RunMigrations(ctx, db, o.migrations); err != nil {
+			db.Close()
+			return nil, err
+		}
+	}
+	return db, nil
+}
+
+// HealthCheck returns a readiness checker that pings db.
+func HealthCheck(db *sql.DB) health.Checker {
+	return health.Ping(db)
+}
+
+// pgxTracer records a span and a latency observation per statement.
+type pgxTracer struct {
+	tracing bool
+	tracer  trace.Tracer
+}
+
+type pgxTraceKey struct{}
+
+type pgxTraceState struct {
+	start     time.Time
+	operation string
+}
+
+func (t *pgxTracer) TraceQueryStart(ctx context.Context, _ *pgx.Conn, data pgx.TraceQueryStartData) context.Context {
+	op := sqlOperation(data.SQL)
+	if t.tracing {
+		ctx, _ = t.tracer.Start(ctx, "postgres "+op,
+			trace.WithSpanKind(trace.SpanKindClient),
+			trace.WithAttributes(
+				attribute.String("db.system", "postgresql"),
+				attribute.String("db.operation", op),
+				attribute.String("db.statement", data.SQL),
+			))
+	}
+	return context.WithValue(ctx, pgxTraceKey{}, pgxTraceState{start: time.Now(), operation: op})
+}
+
+func (t *pgxTracer) TraceQueryEnd(ctx context.Context, _ *pgx.Conn, data pgx.TraceQueryEndData) {
+	st, ok := ctx.Value(pgxTraceKey{}).(pgxTraceState)
+	if !ok {
+		return
+	}
+	result := "ok"
+	if data.Err != nil {
+		result = "error"
+	}
+	metrics.Observe(ctx, querySeconds.WithLabelValues(st.operation, result), time.Since(st.start).Seconds())
+	if !t.tracing {
+		return
+	}
+	span := trace.SpanFromContext(ctx)
+	if data.Err != nil {
+		span.RecordError(data.Err)
+		span.SetStatus(otelcodes.Error, data.Err.Error())
+	} else {
+		span.SetAttributes(attribute.Int64("db.rows_affected", data.CommandTag.RowsAffected()))
+	}
+	span.End()
+}
+
+// sqlOperation returns the upper-cased leading keyword of a statement, used
+// as a low-cardinality operation name.
+func sqlOperation(sql string) string {
+	f := strings.Fields(sql)
+	if len(f) == 0 {
+		return "UNKNOWN"
+	}
+	op := strings.ToUpper(f[0])
+	switch op {
+	case "SELECT", "INSERT", "UPDATE", "DELETE", "WITH", "BEGIN", "COMMIT", "ROLLBACK",
+		"CREATE", "ALTER", "DROP", "TRUNCATE", "COPY", "SET", "LOCK":
+		return op
+	}
+	return "OTHER"
+}
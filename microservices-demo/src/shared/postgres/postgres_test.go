@@ -0,0 +1,67 @@
+package postgres
+
+import (
+	"context"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+func TestConfigDSN(t *testing.T) {
+	t.Setenv("PGHOST", "db")
+	t.Setenv("PGPASSWORD", "it's secret")
+	t.Setenv("PGDATABASE", "orders")
+	cfg, err := ConfigFromEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `host=db port=5432 user=postgres sslmode=prefer password='it\'s secret' dbname=orders`
+	if got := cfg.dsn(); got != want {
+		t.Errorf("dsn = %q, want %q", got, want)
+	}
+	cfg.DSN = "postgres://u@h/db"
+	if got := cfg.dsn(); got != cfg.DSN {
+		t.Errorf("dsn = %q, want DATABASE_URL to win", got)
+	}
+}
+
+func TestOpenUnreachable(t *testing.T) {
+	cfg := Config{Host: "127.0.0.1", Port: 1, User: "postgres", SSLMode: "disable", ConnectTimeout: 200 * time.Millisecond}
+	if _, err := Open(context.Background(), cfg); err == nil {
+		t.Fatal("Open succeeded against a closed port")
+	}
+	db, err := Open(context.Background(), cfg, WithoutPing())
+	if err != nil {
+		t.Fatalf("Open without ping: %v", err)
+	}
+	db.Close()
+}
+
+func TestMigrationsFS(t *testing.T) {
+	fsys := fstest.MapFS{
+		"migrations/0002_add_index.sql":    {Data: []byte("CREATE INDEX ...")},
+		"migrations/0001_create_table.sql": {Data: []byte("CREATE TABLE ...")},
+		"migrations/README.md":             {Data: []byte("ignored")},
+	}
+	ms, err := MigrationsFS(fsys, "migrations")()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ms) != 2 || ms[0].Version != "0001_create_table" || ms[1].Version != "0002_add_index" {
+		t.Errorf("migrations = %+v", ms)
+	}
+}
+
+func TestSQLOperation(t *testing.T) {
+	for sql, want := range map[string]string{
+		"  select * from t":     "SELECT",
+		"INSERT INTO t":         "INSERT",
+		"VACUUM":                "OTHER",
+		"":                      "UNKNOWN",
+		"with x as (select 1) ": "WITH",
+	} {
+		if got := sqlOperation(sql); got != want {
+			t.Errorf("sqlOperation(%q) = %q, want %q", sql, got, want)
+		}
+	}
+}
@@ -0,0 +1,384 @@
+package shared
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"github.com/GoogleCloudPlatform/microservices-demo/src/shared/metrics"
+)
+
+// Priority is the class a PriorityQueue admits a request in. Lower values
+// are more important.
+type Priority int
+
+const (
+	// PriorityCritical is for requests that lose money when dropped, such
+	// as placing an order.
+	PriorityCritical Priority = iota
+	// PriorityNormal is for interactive browsing.
+	PriorityNormal
+	// PrioritySheddable is for traffic nobody waits on, such as crawlers,
+	// and is the first to go under load.
+	PrioritySheddable
+
+	numPriorities = int(PrioritySheddable) + 1
+)
+
+func (p Priority) String() string {
+	switch p {
+	case PriorityCritical:
+		return "critical"
+	case PriorityNormal:
+		return "normal"
+	case PrioritySheddable:
+		return "sheddable"
+	}
+	return fmt.Sprintf("Priority(%d)", int(p))
+}
+
+// PriorityRule assigns Priority to the requests it matches. Every non-empty
+// field must match.
+type PriorityRule struct {
+	// PathPrefix matches the start of the HTTP path or gRPC full method.
+	PathPrefix string
+	// Header names an HTTP header or gRPC metadata key whose value must
+	// contain Contains, ignoring case. An empty Contains only requires the
+	// header to be present.
+	Header   string
+	Contains string
+	Priority Priority
+}
+
+// DefaultPriorityRules puts crawlers last and checkout first; everything
+// else is PriorityNormal.
+var DefaultPriorityRules = []PriorityRule{
+	{Header: "User-Agent", Contains: "bot", Priority: PrioritySheddable},
+	{Header: "User-Agent", Contains: "crawler", Priority: PrioritySheddable},
+	{Header: "User-Agent", Contains: "spider", Priority: PrioritySheddable},
+	{PathPrefix: "/cart/checkout", Priority: PriorityCritical},
+	{PathPrefix: "/hipstershop.CheckoutService/", Priority: PriorityCritical},
+	{PathPrefix: "/hipstershop.PaymentService/", Priority: PriorityCritical},
+}
+
+var (
+	priorityAdmittedTotal = metrics.NewCounterVec("priority_queue_admitted_total",
+		"Requests admitted by a priority queue, by queue and class.", "queue", "class")
+	priorityShedTotal = metrics.NewCounterVec("priority_queue_shed_total",
+		"Requests shed by a priority queue, by queue, class and reason (full, evicted, timeout).", "queue", "class", "reason")
+	priorityWaitingGauge = metrics.NewGaugeVec("priority_queue_waiting",
+		"Requests waiting in a priority queue, by queue and class.", "queue", "class")
+	priorityInflightGauge = metrics.NewGaugeVec("priority_queue_inflight",
+		"Requests admitted by a priority queue and not yet done.", "queue")
+	priorityWaitSeconds = metrics.NewHistogramVec("priority_queue_wait_seconds",
+		"Time admitted requests spent queued, by queue and class.",
+		[]float64{.001, .005, .01, .025, .05, .1, .25, .5, 1}, "queue", "class")
+)
+
+// ErrShed is returned by PriorityQueue.Acquire when a request is turned away.
+var ErrShed = errors.New("request shed by priority queue")
+
+// PriorityQueueConfig tunes a PriorityQueue. Load it with
+// PriorityQueueConfigFromEnv or fill it in directly.
+type PriorityQueueConfig struct {
+	// MaxConcurrent is how many requests are served at once.
+	MaxConcurrent int `env:"PRIORITY_QUEUE_MAX_CONCURRENT" default:"64"`
+	// MaxQueue bounds the requests waiting for a slot, across classes.
+	MaxQueue int `env:"PRIORITY_QUEUE_MAX_QUEUE" default:"128"`
+	// MaxWait is how long a request may wait for a slot before it is shed.
+	MaxWait time.Duration `env:"PRIORITY_QUEUE_MAX_WAIT" default:"250ms"`
+	// NormalBudget and SheddableBudget are the shares of MaxConcurrent
+	// that normal and sheddable requests may hold, keeping the rest free
+	// for the classes above them.
+	NormalBudget    float64 `env:"PRIORITY_QUEUE_NORMAL_BUDGET" default:"0.8"`
+	SheddableBudget float64 `env:"PRIORITY_QUEUE_SHEDDABLE_BUDGET" default:"0.5"`
+}
+
+// PriorityQueueConfigFromEnv loads a PriorityQueueConfig with LoadConfig.
+func PriorityQueueConfigFromEnv() (PriorityQueueConfig, error) {
+	var cfg PriorityQueueConfig
+	err := LoadConfig(&cfg)
+	return cfg, err
+}
+
+// PriorityQueue admits requests by class so a service under load degrades
+// gracefully: each class may only hold its budget of the concurrency slots,
+// requests over budget wait briefly for a slot, and slots that free up go to
+// the most important waiter first. When the queue is full, a newcomer
+// evicts the least important waiter below its own class, so during a flash
+// sale crawlers are shed first, then browsing, and checkout last.
+//
+// Usage:
+//
+//	cfg, err := shared.PriorityQueueConfigFromEnv()
+//	...
+//	q, err := shared.NewPriorityQueue("frontend", cfg, shared.DefaultPriorityRules...)
+//	handler = q.Middleware(handler)
+type PriorityQueue struct {
+	name  string
+	cfg   PriorityQueueConfig
+	rules []PriorityRule
+	now   func() time.Time
+
+	mu       sync.Mutex
+	inflight int
+	limits   [numPriorities]int
+	waiting  [numPriorities][]*priorityWaiter // FIFO per class
+	queued   int
+}
+
+type priorityWaiter struct {
+	ready    chan struct{} // closed when admitted or evicted
+	admitted bool
+}
+
+// NewPriorityQueue returns a queue named name (used as the metrics label)
+// that classifies requests with the first matching rule, defaulting to
+// PriorityNormal.
+func NewPriorityQueue(name string, cfg PriorityQueueConfig, rules ...PriorityRule) (*PriorityQueue, error) {
+	if cfg.MaxConcurrent < 1 {
+		return nil, fmt.Errorf("priority queue: MaxConcurrent %d below 1", cfg.MaxConcurrent)
+	}
+	if cfg.MaxQueue < 0 {
+		cfg.MaxQueue = 0
+	}
+	for _, b := range []float64{cfg.NormalBudget, cfg.SheddableBudget} {
+		if b <= 0 || b > 1 {
+			return nil, fmt.Errorf("priority queue: budget %v outside (0, 1]", b)
+		}
+	}
+	q := &PriorityQueue{name: name, cfg: cfg, rules: rules, now: time.Now}
+	q.limits[PriorityCritical] = cfg.MaxConcurrent
+	q.limits[PriorityNormal] = max(1, int(float64(cfg.MaxConcurrent)*cfg.NormalBudget))
+	q.limits[PrioritySheddable] = max(1, int(float64(cfg.MaxConcurrent)*cfg.SheddableBudget))
+	return q, nil
+}
+
+// Acquire admits a request of class p, waiting up to MaxWait for a slot.
+// If it returns nil, the caller must call release exactly once when done;
+// otherwise the error is ErrShed or ctx's error.
+func (q *PriorityQueue) Acquire(ctx context.Context, p Priority) (release func(), err error) {
+	p = min(max(p, PriorityCritical), PrioritySheddable)
+	class := p.String()
+
+	q.mu.Lock()
+	if q.inflight < q.limits[p] && !q.waitingAtOrAbove(p) {
+		q.inflight++
+		inflight := q.inflight
+		q.mu.Unlock()
+		priorityInflightGauge.WithLabelValues(q.name).Set(float64(inflight))
+		priorityAdmittedTotal.WithLabelValues(q.name, class).Inc()
+		metrics.Observe(ctx, priorityWaitSeconds.WithLabelValues(q.name, class), 0)
+		return q.releaser(), nil
+	}
+	if q.queued >= q.cfg.MaxQueue && !q.evictBelow(p) {
+		q.mu.Unlock()
+		priorityShedTotal.WithLabelValues(q.name, class, "full").Inc()
+		return nil, ErrShed
+	}
+	w := &priorityWaiter{ready: make(chan struct{})}
+	q.waiting[p] = append(q.waiting[p], w)
+	q.queued++
+	waiting := len(q.waiting[p])
+	q.mu.Unlock()
+	priorityWaitingGauge.WithLabelValues(q.name, class).Set(float64(waiting))
+
+	start := q.now()
+	timer := time.NewTimer(q.cfg.MaxWait)
+	defer timer.Stop()
+	reason := ""
+	select {
+	case <-w.ready:
+	case <-timer.C:
+		reason = "timeout"
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	q.mu.Lock()
+	admitted := w.admitted
+	if !admitted && (reason != "" || err != nil) {
+		q.remove(p, w)
+	}
+	waiting = len(q.waiting[p])
+	q.mu.Unlock()
+	priorityWaitingGauge.WithLabelValues(q.name, class).Set(float64(waiting))
+
+	switch {
+	case admitted:
+		priorityAdmittedTotal.WithLabelValues(q.name, class).Inc()
+		metrics.Observe(ctx, priorityWaitSeconds.WithLabelValues(q.name, class), q.now().Sub(start).Seconds())
+		return q.releaser(), nil
+	case err != nil:
+		return nil, err
+	case reason == "":
+		reason = "evicted"
+	}
+	priorityShedTotal.WithLabelValues(q.name, class, reason).Inc()
+	return nil, ErrShed
+}
+
+func (q *PriorityQueue) releaser() func() {
+	var once sync.Once
+	return func() { once.Do(q.release) }
+}
+
+// release frees a slot and hands free slots to waiters, most important
+// class first.
+func (q *PriorityQueue) release() {
+	q.mu.Lock()
+	q.inflight--
+	for p := range q.waiting {
+		for len(q.waiting[p]) > 0 && q.inflight < q.limits[p] {
+			w := q.waiting[p][0]
+			q.waiting[p] = q.waiting[p][1:]
+			q.queued--
+			q.inflight++
+			w.admitted = true
+			close(w.ready)
+		}
+	}
+	inflight := q.inflight
+	q.mu.Unlock()
+	priorityInflightGauge.WithLabelValues(q.name).Set(float64(inflight))
+}
+
+// waitingAtOrAbove reports whether requests of class p or more important
+// are already waiting, so a newcomer does not jump ahead of them. q.mu must
+// be held.
+func (q *PriorityQueue) waitingAtOrAbove(p Priority) bool {
+	for c := PriorityCritical; c <= p; c++ {
+		if len(q.waiting[c]) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// evictBelow sheds the newest waiter of the least important class below p
+// to make room, reporting whether there was one. q.mu must be held.
+func (q *PriorityQueue) evictBelow(p Priority) bool {
+	for c := PrioritySheddable; c > p; c-- {
+		if n := len(q.waiting[c]); n > 0 {
+			w := q.waiting[c][n-1]
+			q.waiting[c] = q.waiting[c][:n-1]
+			q.queued--
+			close(w.ready)
+			return true
+		}
+	}
+	return false
+}
+
+// remove takes w, which gave up waiting, out of the queue. q.mu must be
+// held; w may already be gone if it was evicted.
+func (q *PriorityQueue) remove(p Priority, w *priorityWaiter) {
+	for i, x := range q.waiting[p] {
+		if x == w {
+			q.waiting[p] = append(q.waiting[p][:i], q.waiting[p][i+1:]...)
+			q.queued--
+			return
+		}
+	}
+}
+
+// classify returns the priority of the first rule matching path and the
+// header lookup, or PriorityNormal.
+func (q *PriorityQueue) classify(path string, header func(string) (string, bool)) Priority {
+	for _, r := range q.rules {
+		if r.PathPrefix != "" && !strings.HasPrefix(path, r.PathPrefix) {
+			continue
+		}
+		if r.Header != "" {
+			v, ok := header(r.Header)
+			if !ok || !strings.Contains(strings.ToLower(v), strings.ToLower(r.Contains)) {
+				continue
+			}
+		}
+		return r.Priority
+	}
+	return PriorityNormal
+}
+
+// Classify returns the priority of an HTTP request.
+func (q *PriorityQueue) Classify(r *http.Request) Priority {
+	return q.classify(r.URL.Path, func(name string) (string, bool) {
+		v := r.Header.Values(name)
+		return strings.Join(v, ","), len(v) > 0
+	})
+}
+
+// ClassifyGRPC returns the priority of a gRPC call from its method and
+// incoming metadata.
+func (q *PriorityQueue) ClassifyGRPC(ctx context.Context, fullMethod string) Priority {
+	md, _ := metadata.FromIncomingContext(ctx)
+	return q.classify(fullMethod, func(name string) (string, bool) {
+		v := md.Get(name)
+		return strings.Join(v, ","), len(v) > 0
+	})
+}
+
+// Middleware queues HTTP requests by class, shedding them with 503 Service
+// Unavailable and a Retry-After hint.
+func (q *PriorityQueue) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		release, err := q.Acquire(r.Context(), q.Classify(r))
+		if err != nil {
+			if errors.Is(err, ErrShed) {
+				w.Header().Set("Retry-After", "1")
+				http.Error(w, "server overloaded", http.StatusServiceUnavailable)
+			}
+			return // the client went away
+		}
+		defer release()
+		next.ServeHTTP(w, r)
+	})
+}
+
+// UnaryServerInterceptor queues calls by class, shedding them with
+// ResourceExhausted. Health checks are always admitted.
+func (q *PriorityQueue) UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		if strings.HasPrefix(info.FullMethod, "/grpc.health.v1.Health/") {
+			return handler(ctx, req)
+		}
+		release, err := q.Acquire(ctx, q.ClassifyGRPC(ctx, info.FullMethod))
+		if err != nil {
+			return nil, priorityStatus(err)
+		}
+		defer release()
+		return handler(ctx, req)
+	}
+}
+
+// StreamServerInterceptor queues streams by class; a stream holds its slot
+// until it ends.
+func (q *PriorityQueue) StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if strings.HasPrefix(info.FullMethod, "/grpc.health.v1.Health/") {
+			return handler(srv, ss)
+		}
+		release, err := q.Acquire(ss.Context(), q.ClassifyGRPC(ss.Context(), info.FullMethod))
+		if err != nil {
+			return priorityStatus(err)
+		}
+		defer release()
+		return handler(srv, ss)
+	}
+}
+
+func priorityStatus(err error) error {
+	if errors.Is(err, ErrShed) {
+		return status.Errorf(codes.ResourceExhausted, "server overloaded: %v", err)
+	}
+	return status.FromContextError(err).Err()
+}
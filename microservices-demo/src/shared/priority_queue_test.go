@@ -0,0 +1,189 @@
+package shared
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/metadata"
+)
+
+func newTestPriorityQueue(t *testing.T, cfg PriorityQueueConfig) *PriorityQueue {
+	t.Helper()
+	if cfg.NormalBudget == 0 {
+		cfg.NormalBudget = 1
+	}
+	if cfg.SheddableBudget == 0 {
+		cfg.SheddableBudget = 1
+	}
+	q, err := NewPriorityQueue(t.Name(), cfg, DefaultPriorityRules...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return q
+}
+
+// waitQueued blocks until n requests are waiting in q.
+func waitQueued(t *testing.T, q *PriorityQueue, n int) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for {
+		q.mu.Lock()
+		queued := q.queued
+		q.mu.Unlock()
+		if queued == n {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%d requests queued, want %d", queued, n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestPriorityQueueBudgets(t *testing.T) {
+	q := newTestPriorityQueue(t, PriorityQueueConfig{
+		MaxConcurrent: 4, MaxQueue: 10, MaxWait: 10 * time.Millisecond, NormalBudget: 0.5, SheddableBudget: 0.25,
+	})
+	ctx := context.Background()
+	if _, err := q.Acquire(ctx, PrioritySheddable); err != nil {
+		t.Fatalf("first sheddable: %v", err)
+	}
+	if _, err := q.Acquire(ctx, PrioritySheddable); !errors.Is(err, ErrShed) {
+		t.Fatalf("sheddable over its budget = %v, want ErrShed after waiting", err)
+	}
+	if _, err := q.Acquire(ctx, PriorityNormal); err != nil {
+		t.Fatalf("normal within its budget: %v", err)
+	}
+	if _, err := q.Acquire(ctx, PriorityNormal); !errors.Is(err, ErrShed) {
+		t.Fatalf("normal over its budget = %v, want ErrShed", err)
+	}
+	for i := range 2 {
+		if _, err := q.Acquire(ctx, PriorityCritical); err != nil {
+			t.Fatalf("critical %d in the reserved slots: %v", i, err)
+		}
+	}
+}
+
+func TestPriorityQueueAdmitsMostImportantFirst(t *testing.T) {
+	q := newTestPriorityQueue(t, PriorityQueueConfig{MaxConcurrent: 1, MaxQueue: 10, MaxWait: time.Second})
+	ctx := context.Background()
+	release, err := q.Acquire(ctx, PriorityNormal)
+	if err != nil {
+		t.Fatal(err)
+	}
+	order := make(chan Priority, 2)
+	for i, p := range []Priority{PrioritySheddable, PriorityCritical} {
+		go func() {
+			release, err := q.Acquire(ctx, p)
+			if err != nil {
+				t.Errorf("Acquire(%v): %v", p, err)
+				order <- p
+				return
+			}
+			order <- p
+			release()
+		}()
+		waitQueued(t, q, i+1)
+	}
+	release()
+	if first, second := <-order, <-order; first != PriorityCritical || second != PrioritySheddable {
+		t.Errorf("admitted %v then %v, want critical first", first, second)
+	}
+}
+
+func TestPriorityQueueEvictsLowerClass(t *testing.T) {
+	q := newTestPriorityQueue(t, PriorityQueueConfig{MaxConcurrent: 1, MaxQueue: 1, MaxWait: time.Second})
+	ctx := context.Background()
+	release, err := q.Acquire(ctx, PriorityNormal)
+	if err != nil {
+		t.Fatal(err)
+	}
+	shed := make(chan error, 1)
+	go func() {
+		_, err := q.Acquire(ctx, PrioritySheddable)
+		shed <- err
+	}()
+	waitQueued(t, q, 1)
+	admitted := make(chan error, 1)
+	go func() {
+		release, err := q.Acquire(ctx, PriorityCritical)
+		if err == nil {
+			release()
+		}
+		admitted <- err
+	}()
+	if err := <-shed; !errors.Is(err, ErrShed) {
+		t.Fatalf("evicted sheddable = %v, want ErrShed", err)
+	}
+	waitQueued(t, q, 1)
+	// The queue is full of a more important request: nothing to evict.
+	if _, err := q.Acquire(ctx, PriorityNormal); !errors.Is(err, ErrShed) {
+		t.Errorf("normal into a full queue = %v, want ErrShed", err)
+	}
+	release()
+	if err := <-admitted; err != nil {
+		t.Errorf("critical = %v", err)
+	}
+}
+
+func TestPriorityQueueContextCanceled(t *testing.T) {
+	q := newTestPriorityQueue(t, PriorityQueueConfig{MaxConcurrent: 1, MaxQueue: 1, MaxWait: time.Second})
+	release, _ := q.Acquire(context.Background(), PriorityNormal)
+	defer release()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Millisecond)
+	defer cancel()
+	if _, err := q.Acquire(ctx, PriorityNormal); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Acquire with an expiring context = %v", err)
+	}
+	waitQueued(t, q, 0)
+}
+
+func TestPriorityQueueClassify(t *testing.T) {
+	q := newTestPriorityQueue(t, PriorityQueueConfig{MaxConcurrent: 1})
+	for _, tc := range []struct {
+		path, ua string
+		want     Priority
+	}{
+		{"/", "Mozilla/5.0", PriorityNormal},
+		{"/cart/checkout", "Mozilla/5.0", PriorityCritical},
+		{"/product/OLJCESPC7Z", "Mozilla/5.0 (compatible; Googlebot/2.1)", PrioritySheddable},
+	} {
+		r := httptest.NewRequest(http.MethodPost, tc.path, nil)
+		r.Header.Set("User-Agent", tc.ua)
+		if got := q.Classify(r); got != tc.want {
+			t.Errorf("Classify(%s, %s) = %v, want %v", tc.path, tc.ua, got, tc.want)
+		}
+	}
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs("user-agent", "grpc-go/1.71"))
+	if got := q.ClassifyGRPC(ctx, "/hipstershop.CheckoutService/PlaceOrder"); got != PriorityCritical {
+		t.Errorf("ClassifyGRPC(PlaceOrder) = %v, want critical", got)
+	}
+	if got := q.ClassifyGRPC(ctx, "/hipstershop.ProductCatalogService/ListProducts"); got != PriorityNormal {
+		t.Errorf("ClassifyGRPC(ListProducts) = %v, want normal", got)
+	}
+}
+
+func TestPriorityQueueMiddlewareSheds(t *testing.T) {
+	q := newTestPriorityQueue(t, PriorityQueueConfig{MaxConcurrent: 1, MaxWait: time.Millisecond})
+	release, _ := q.Acquire(context.Background(), PriorityCritical)
+	defer release()
+	h := q.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	if rec.Code != http.StatusServiceUnavailable || rec.Header().Get("Retry-After") == "" {
+		t.Errorf("response = %d, Retry-After %q; want 503 with a hint", rec.Code, rec.Header().Get("Retry-After"))
+	}
+}
+
+func TestNewPriorityQueueValidates(t *testing.T) {
+	if _, err := NewPriorityQueue("q", PriorityQueueConfig{MaxConcurrent: 0, NormalBudget: 1, SheddableBudget: 1}); err == nil {
+		t.Error("accepted MaxConcurrent 0")
+	}
+	if _, err := NewPriorityQueue("q", PriorityQueueConfig{MaxConcurrent: 1, NormalBudget: 1.5, SheddableBudget: 1}); err == nil {
+		t.Error("accepted a budget above 1")
+	}
+}
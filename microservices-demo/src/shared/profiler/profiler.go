@@ -0,0 +1,303 @@
+// Package profiler runs continuous profiling against Google Cloud Profiler
+// or Pyroscope, chosen and labelled from the environment, so profiles from
+// every service land in one place with matching labels, keeping the Cloud
+// Profiler agent out of services that do not profile.
+package profiler
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"runtime/pprof"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	cloudprofiler "cloud.google.com/go/profiler"
+
+	shared "github.com/GoogleCloudPlatform/microservices-demo/src/shared"
+	"github.com/GoogleCloudPlatform/microservices-demo/src/shared/buildinfo"
+	"github.com/GoogleCloudPlatform/microservices-demo/src/shared/podinfo"
+)
+
+// Config selects and tunes the continuous profiler started by Init. Load
+// it with ConfigFromEnv or fill it in directly.
+type Config struct {
+	// Backend is "none", "cloud" (Google Cloud Profiler) or "pyroscope".
+	Backend string `env:"PROFILER_BACKEND" default:"none"`
+	// Service names the profiled program. Empty uses the name passed to
+	// Init.
+	Service string `env:"PROFILER_SERVICE"`
+	// Version labels profiles so releases can be compared. Empty uses
+	// buildinfo.GetInfo().Version.
+	Version string `env:"PROFILER_VERSION"`
+	// Tags are extra "key=value" labels. Pod and namespace are added
+	// automatically when the downward API provides them.
+	Tags []string `env:"PROFILER_TAGS"`
+
+	// ProjectID is the Google Cloud project; it may be empty on GCP.
+	ProjectID string `env:"PROFILER_PROJECT_ID"`
+	// MutexProfiling also collects contention profiles for Cloud Profiler.
+	MutexProfiling bool `env:"PROFILER_MUTEX"`
+
+	// PyroscopeURL is the Pyroscope server.
+	PyroscopeURL string `env:"PYROSCOPE_SERVER_ADDRESS" default:"http://pyroscope:4040"`
+	// PyroscopeUser and PyroscopePassword enable basic auth, as Grafana
+	// Cloud requires.
+	PyroscopeUser     string `env:"PYROSCOPE_BASIC_AUTH_USER"`
+	PyroscopePassword string `env:"PYROSCOPE_BASIC_AUTH_PASSWORD"`
+	// UploadPeriod is how much CPU time each Pyroscope upload covers; heap
+	// and goroutine snapshots are taken at the same rate.
+	UploadPeriod time.Duration `env:"PROFILER_UPLOAD_PERIOD" default:"10s"`
+}
+
+// ConfigFromEnv loads a Config with shared.LoadConfig.
+func ConfigFromEnv() (Config, error) {
+	var cfg Config
+	err := shared.LoadConfig(&cfg)
+	return cfg, err
+}
+
+// labels returns the tags attached to every profile, including version,
+// pod and namespace.
+func (c Config) labels() (map[string]string, error) {
+	labels := make(map[string]string)
+	if c.Version != "" {
+		labels["version"] = c.Version
+	}
+	pod := podinfo.Get()
+	for key, v := range map[string]string{"pod": pod.Name, "namespace": pod.Namespace} {
+		if v != "" {
+			labels[key] = v
+		}
+	}
+	for _, t := range c.Tags {
+		k, v, ok := strings.Cut(t, "=")
+		if !ok || k == "" {
+			return nil, fmt.Errorf("profiler: tag %q: want key=value", t)
+		}
+		labels[k] = v
+	}
+	return labels, nil
+}
+
+// cloudProfilerStart is replaced in tests.
+var cloudProfilerStart = cloudprofiler.Start
+
+// Init starts continuous profiling for service as configured by the
+// environment (see Config), so profiles from every service show up
+// in one place with matching labels. It returns a function that stops
+// profiling and flushes the last Pyroscope upload; register it with a
+// shared.ShutdownManager.
+//
+//	PROFILER_BACKEND          none, cloud or pyroscope (default none)
+//	PROFILER_VERSION          version label
+//	PROFILER_TAGS             extra key=value labels, comma-separated
+//	PROFILER_PROJECT_ID       Google Cloud project, when not on GCP
+//	PYROSCOPE_SERVER_ADDRESS  Pyroscope URL (default http://pyroscope:4040)
+//
+// Usage:
+//
+//	stop, err := profiler.Init(ctx, "checkoutservice")
+//	if err != nil {
+//	    log.Warnf("profiling disabled: %v", err)
+//	}
+//	sm.Register("profiler", stop)
+//
+// Cloud Profiler is started in the background and retried, since its
+// agent fails while the metadata server is still coming up.
+func Init(ctx context.Context, service string) (func(context.Context) error, error) {
+	cfg, err := ConfigFromEnv()
+	if err != nil {
+		return func(context.Context) error { return nil }, err
+	}
+	if cfg.Service == "" {
+		cfg.Service = service
+	}
+	if cfg.Version == "" {
+		cfg.Version = buildinfo.GetInfo().Version
+	}
+	return Start(ctx, cfg)
+}
+
+// Start is Init with an explicit configuration.
+func Start(ctx context.Context, cfg Config) (func(context.Context) error, error) {
+	noop := func(context.Context) error { return nil }
+	if cfg.Service == "" {
+		return noop, errors.New("profiler: service name is required")
+	}
+	labels, err := cfg.labels()
+	if err != nil {
+		return noop, err
+	}
+	switch cfg.Backend {
+	case "", "none":
+		return noop, nil
+	case "cloud":
+		go startCloudProfiler(cfg)
+		return noop, nil
+	case "pyroscope":
+		if cfg.UploadPeriod <= 0 {
+			return noop, errors.New("profiler: upload period must be positive")
+		}
+		p := &pyroscopeUploader{cfg: cfg, labels: labels, client: &http.Client{Timeout: 30 * time.Second}}
+		return p.start(ctx), nil
+	default:
+		return noop, fmt.Errorf("profiler: unknown backend %q", cfg.Backend)
+	}
+}
+
+func startCloudProfiler(cfg Config) {
+	pc := cloudprofiler.Config{
+		Service:        cfg.Service,
+		ServiceVersion: cfg.Version,
+		ProjectID:      cfg.ProjectID,
+		MutexProfiling: cfg.MutexProfiling,
+	}
+	for i := 1; i <= 3; i++ {
+		err := cloudProfilerStart(pc)
+		if err == nil {
+			log.Printf("Profiler: Started Cloud Profiler for %s", cfg.Service)
+			return
+		}
+		d := 10 * time.Second * time.Duration(i)
+		log.Printf("Profiler: Starting Cloud Profiler failed, retrying in %v: %v", d, err)
+		time.Sleep(d)
+	}
+	log.Printf("Profiler: Could not start Cloud Profiler after retrying, giving up")
+}
+
+// pyroscopeUploader pushes pprof profiles to Pyroscope's /ingest API, the
+// same protocol its Go SDK speaks.
+type pyroscopeUploader struct {
+	cfg    Config
+	labels map[string]string
+	client *http.Client
+}
+
+// start runs the upload loop until the returned stop function is called or
+// ctx ends.
+func (p *pyroscopeUploader) start(ctx context.Context) func(context.Context) error {
+	ctx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		p.run(ctx)
+	}()
+	log.Printf("Profiler: Uploading profiles for %s to %s every %v", p.cfg.Service, p.cfg.PyroscopeURL, p.cfg.UploadPeriod)
+	return func(stopCtx context.Context) error {
+		cancel()
+		select {
+		case <-done:
+			return nil
+		case <-stopCtx.Done():
+			return fmt.Errorf("profiler: final upload: %w", stopCtx.Err())
+		}
+	}
+}
+
+func (p *pyroscopeUploader) run(ctx context.Context) {
+	// The runtime allows one CPU profile at a time; hold the lock so an
+	// on-demand profile from shared.SetupProfileSignalHandler reports a
+	// clash instead of corrupting this one.
+	defer shared.LockCPUProfile()()
+	for {
+		from := time.Now()
+		var cpu bytes.Buffer
+		cpuErr := pprof.StartCPUProfile(&cpu)
+		var stopped bool
+		select {
+		case <-ctx.Done():
+			stopped = true
+		case <-time.After(p.cfg.UploadPeriod):
+		}
+		if cpuErr == nil {
+			pprof.StopCPUProfile()
+		}
+		until := time.Now()
+
+		// Uploads must outlive a cancelled ctx so the final period is kept.
+		uctx, ucancel := context.WithTimeout(context.WithoutCancel(ctx), p.client.Timeout)
+		if cpuErr != nil {
+			log.Printf("Profiler: CPU profile unavailable: %v", cpuErr)
+		} else {
+			p.upload(uctx, "cpu", cpu.Bytes(), from, until, 100)
+		}
+		for _, name := range []string{"heap", "goroutine"} {
+			var buf bytes.Buffer
+			if err := pprof.Lookup(name).WriteTo(&buf, 0); err == nil {
+				p.upload(uctx, name, buf.Bytes(), from, until, 0)
+			}
+		}
+		ucancel()
+		if stopped {
+			return
+		}
+	}
+}
+
+// upload sends one profile, logging rather than returning failures so a
+// Pyroscope outage only costs the profiles taken meanwhile.
+func (p *pyroscopeUploader) upload(ctx context.Context, kind string, profile []byte, from, until time.Time, sampleRate int) {
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	fw, _ := mw.CreateFormFile("profile", "profile.pprof")
+	fw.Write(profile)
+	mw.Close()
+
+	q := url.Values{}
+	q.Set("name", p.appName(kind))
+	q.Set("from", strconv.FormatInt(from.Unix(), 10))
+	q.Set("until", strconv.FormatInt(until.Unix(), 10))
+	q.Set("spyName", "gospy")
+	if sampleRate > 0 {
+		q.Set("sampleRate", strconv.Itoa(sampleRate))
+	}
+	u := strings.TrimSuffix(p.cfg.PyroscopeURL, "/") + "/ingest?" + q.Encode()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u, &body)
+	if err != nil {
+		log.Printf("Profiler: Building %s upload: %v", kind, err)
+		return
+	}
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	if p.cfg.PyroscopeUser != "" {
+		req.SetBasicAuth(p.cfg.PyroscopeUser, p.cfg.PyroscopePassword)
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		log.Printf("Profiler: Uploading %s profile failed: %v", kind, err)
+		return
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		log.Printf("Profiler: Uploading %s profile failed: %s: %s", kind, resp.Status, bytes.TrimSpace(msg))
+	}
+}
+
+// appName renders Pyroscope's application name, e.g.
+// "checkoutservice.cpu{namespace=demo,version=1.2.0}".
+func (p *pyroscopeUploader) appName(kind string) string {
+	keys := make([]string, 0, len(p.labels))
+	for k := range p.labels {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	var b strings.Builder
+	b.WriteString(p.cfg.Service + "." + kind + "{")
+	for i, k := range keys {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(k + "=" + p.labels[k])
+	}
+	b.WriteString("}")
+	return b.String()
+}
@@ -0,0 +1,120 @@
+package profiler
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	cloudprofiler "cloud.google.com/go/profiler"
+	"google.golang.org/api/option"
+
+	"github.com/GoogleCloudPlatform/microservices-demo/src/shared/podinfo"
+)
+
+func TestStartProfilerPyroscope(t *testing.T) {
+	var (
+		mu    sync.Mutex
+		names []string
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/ingest" {
+			t.Errorf("upload to %s, want /ingest", r.URL.Path)
+		}
+		if _, _, err := r.FormFile("profile"); err != nil {
+			t.Errorf("upload without a profile: %v", err)
+		}
+		if u, _, _ := r.BasicAuth(); u != "svc" {
+			t.Errorf("basic auth user = %q, want svc", u)
+		}
+		mu.Lock()
+		names = append(names, r.URL.Query().Get("name"))
+		mu.Unlock()
+	}))
+	defer srv.Close()
+	t.Setenv("POD_NAMESPACE", "demo")
+	podinfo.Reload()
+
+	stop, err := Start(context.Background(), Config{
+		Backend:       "pyroscope",
+		Service:       "checkoutservice",
+		Version:       "1.2.0",
+		Tags:          []string{"region=eu"},
+		PyroscopeURL:  srv.URL,
+		PyroscopeUser: "svc",
+		UploadPeriod:  20 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(30 * time.Millisecond)
+	if err := stop(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	want := map[string]bool{
+		"checkoutservice.cpu{namespace=demo,region=eu,version=1.2.0}":       false,
+		"checkoutservice.heap{namespace=demo,region=eu,version=1.2.0}":      false,
+		"checkoutservice.goroutine{namespace=demo,region=eu,version=1.2.0}": false,
+	}
+	for _, n := range names {
+		if _, ok := want[n]; !ok {
+			t.Errorf("unexpected upload %q", n)
+		}
+		want[n] = true
+	}
+	for n, seen := range want {
+		if !seen {
+			t.Errorf("no upload named %q in %v", n, names)
+		}
+	}
+}
+
+func TestStartProfilerCloud(t *testing.T) {
+	started := make(chan cloudprofiler.Config, 1)
+	cloudProfilerStart = func(cfg cloudprofiler.Config, _ ...option.ClientOption) error {
+		started <- cfg
+		return nil
+	}
+	defer func() { cloudProfilerStart = cloudprofiler.Start }()
+
+	if _, err := Start(context.Background(), Config{Backend: "cloud", Service: "cartservice", Version: "2"}); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case cfg := <-started:
+		if cfg.Service != "cartservice" || cfg.ServiceVersion != "2" {
+			t.Errorf("cloudprofiler.Config = %+v, want service cartservice version 2", cfg)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Cloud Profiler not started")
+	}
+}
+
+func TestStartProfilerErrors(t *testing.T) {
+	for _, cfg := range []Config{
+		{Backend: "pyroscope"},
+		{Backend: "datadog", Service: "svc"},
+		{Backend: "cloud", Service: "svc", Tags: []string{"novalue"}},
+	} {
+		if _, err := Start(context.Background(), cfg); err == nil {
+			t.Errorf("Start(%+v) succeeded", cfg)
+		}
+	}
+	stop, err := Start(context.Background(), Config{Backend: "none", Service: "svc"})
+	if err != nil || stop(context.Background()) != nil {
+		t.Errorf("backend none: %v", err)
+	}
+}
+
+func TestInitProfilerFromEnv(t *testing.T) {
+	t.Setenv("PROFILER_BACKEND", "bogus")
+	if _, err := Init(context.Background(), "svc"); err == nil || !strings.Contains(err.Error(), "bogus") {
+		t.Errorf("Init with PROFILER_BACKEND=bogus = %v, want unknown backend error", err)
+	}
+}
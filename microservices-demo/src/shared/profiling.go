@@ -0,0 +1,247 @@
+package shared
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/pprof"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// ErrProfilingDisabled is returned when a profile dump is requested but no
+// output directory is available (PPROFDIR unset and no dir given).
+var ErrProfilingDisabled = errors.New("profiling: PPROFDIR is not set")
+
+// dumpProfiles lists the runtime profiles written by DumpProfiles, with the
+// pprof debug level of each file. goroutines.txt carries full stacks in the
+// same format as a panic, readable without go tool pprof.
+var dumpProfiles = []struct {
+	name, file string
+	debug      int
+}{
+	{"goroutine", "goroutine.pprof", 0},
+	{"goroutine", "goroutines.txt", 2},
+	{"heap", "heap.pprof", 0},
+	{"mutex", "mutex.pprof", 0},
+	{"block", "block.pprof", 0},
+	{"threadcreate", "threadcreate.pprof", 0},
+}
+
+// cpuProfileMu serializes CPU profiles; the runtime allows only one at a
+// time.
+var cpuProfileMu sync.Mutex
+
+// LockCPUProfile waits for any CPU profile in progress, such as one taken by
+// SetupProfileSignalHandler, and keeps others from starting until the
+// returned func is called. Code running its own CPU profiles holds it meanwhile, so the
+// profiles do not clash.
+func LockCPUProfile() (unlock func()) {
+	cpuProfileMu.Lock()
+	return cpuProfileMu.Unlock
+}
+
+// ProfileOption configures SetupProfileSignalHandler.
+type ProfileOption func(*profileConfig)
+
+type profileConfig struct {
+	signals       []os.Signal
+	cpu           time.Duration
+	mutexFraction int
+	blockRate     int
+}
+
+// WithProfileSignal registers sig as a dump trigger instead of the SIGUSR2
+// default. It can be called several times.
+func WithProfileSignal(sig os.Signal) ProfileOption {
+	return func(c *profileConfig) { c.signals = append(c.signals, sig) }
+}
+
+// WithProfileCPU also records a CPU profile of duration d after each
+// signal, written as cpu.pprof once it completes.
+func WithProfileCPU(d time.Duration) ProfileOption {
+	return func(c *profileConfig) { c.cpu = d }
+}
+
+// WithMutexProfileFraction enables mutex contention sampling, reporting on
+// average 1 in rate events (see runtime.SetMutexProfileFraction). Without
+// it, or another call to the runtime, mutex.pprof is empty.
+func WithMutexProfileFraction(rate int) ProfileOption {
+	return func(c *profileConfig) { c.mutexFraction = rate }
+}
+
+// WithBlockProfileRate enables blocking profiling, sampling one event per
+// rate nanoseconds spent blocked (see runtime.SetBlockProfileRate). Without
+// it, or another call to the runtime, block.pprof is empty.
+func WithBlockProfileRate(rate int) ProfileOption {
+	return func(c *profileConfig) { c.blockRate = rate }
+}
+
+// SetupProfileSignalHandler enables on-demand profile dumps via SIGUSR2, for
+// diagnosing a stuck or leaking pod where port-forwarding to the admin
+// server's /debug/pprof/ is not allowed.
+//
+// When the PPROFDIR environment variable is set, each signal writes the
+// goroutine, heap, mutex, block and threadcreate profiles, plus a plain-text
+// dump of every goroutine's stack, to a new $PPROFDIR/<host>-<time>/
+// directory. Mount PPROFDIR on a volume that outlives the pod to read the
+// results after a restart.
+//
+// Usage:
+//
+//	func main() {
+//	    shared.SetupCoverageSignalHandler()
+//	    shared.SetupProfileSignalHandler(shared.WithMutexProfileFraction(10))
+//	    // ... rest of your service initialization
+//	}
+//
+// To trigger a dump from outside the process:
+//
+//	kubectl exec <pod-name> -- kill -SIGUSR2 1
+//
+// Like SetupCoverageSignalHandler, this is a no-op if PPROFDIR is not set.
+// If SIGUSR2 is already used, for example by WithDumpSignal, pick another
+// signal with WithProfileSignal.
+func SetupProfileSignalHandler(opts ...ProfileOption) {
+	dir, ok := os.LookupEnv("PPROFDIR")
+	if !ok || dir == "" {
+		return
+	}
+	var cfg profileConfig
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	if len(cfg.signals) == 0 {
+		cfg.signals = []os.Signal{syscall.SIGUSR2}
+	}
+	if cfg.mutexFraction > 0 {
+		runtime.SetMutexProfileFraction(cfg.mutexFraction)
+	}
+	if cfg.blockRate > 0 {
+		runtime.SetBlockProfileRate(cfg.blockRate)
+	}
+
+	c := make(chan os.Signal, 1)
+	DefaultSignalRouter().Notify("profiling", c, cfg.signals...)
+	go func() {
+		for sig := range c {
+			log.Printf("Profiling: Received %v signal, dumping profiles...", sig)
+			out, err := DumpProfiles(dir)
+			if err != nil {
+				log.Printf("Profiling: Error writing profiles: %v", err)
+				continue
+			}
+			log.Printf("Profiling: Successfully wrote profiles to %s", out)
+			if cfg.cpu > 0 {
+				go writeCPUProfile(out, cfg.cpu)
+			}
+		}
+	}()
+	log.Printf("Profiling: Signal handler registered (PPROFDIR=%s)", dir)
+	for _, sig := range cfg.signals {
+		log.Printf("Profiling: Send %v to dump goroutine, heap and mutex profiles", sig)
+	}
+}
+
+// DumpProfiles writes the runtime profiles to a new subdirectory of dir,
+// named after the host and the current time, and returns its path. If dir
+// is empty, the directory named by PPROFDIR is used.
+func DumpProfiles(dir string) (string, error) {
+	if dir == "" {
+		dir = os.Getenv("PPROFDIR")
+	}
+	if dir == "" {
+		return "", ErrProfilingDisabled
+	}
+	host, _ := os.Hostname()
+	out := filepath.Join(dir, fmt.Sprintf("%s-%s", host, time.Now().UTC().Format("20060102T150405.000Z")))
+	if err := os.MkdirAll(out, 0o755); err != nil {
+		return "", fmt.Errorf("profiling: creating %s: %w", out, err)
+	}
+	var errs []error
+	for _, p := range dumpProfiles {
+		if err := writeProfile(filepath.Join(out, p.file), p.name, p.debug); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return out, errors.Join(errs...)
+}
+
+func writeProfile(path, name string, debug int) error {
+	prof := pprof.Lookup(name)
+	if prof == nil {
+		return fmt.Errorf("profiling: no %s profile", name)
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("profiling: %w", err)
+	}
+	if name == "heap" {
+		// Account for allocations since the last GC, as /debug/pprof/heap?gc=1
+		// does, so the dump reflects live memory.
+		runtime.GC()
+	}
+	err = prof.WriteTo(f, debug)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return fmt.Errorf("profiling: writing %s: %w", path, err)
+	}
+	return nil
+}
+
+// writeCPUProfile records a CPU profile for d into dir/cpu.pprof, skipping
+// it if another CPU profile is in progress.
+func writeCPUProfile(dir string, d time.Duration) {
+	if !cpuProfileMu.TryLock() {
+		log.Printf("Profiling: CPU profile already in progress, skipping")
+		return
+	}
+	defer cpuProfileMu.Unlock()
+	path := filepath.Join(dir, "cpu.pprof")
+	f, err := os.Create(path)
+	if err != nil {
+		log.Printf("Profiling: Error creating CPU profile: %v", err)
+		return
+	}
+	defer f.Close()
+	if err := pprof.StartCPUProfile(f); err != nil {
+		log.Printf("Profiling: Error starting CPU profile: %v", err)
+		return
+	}
+	time.Sleep(d)
+	pprof.StopCPUProfile()
+	log.Printf("Profiling: Wrote %v CPU profile to %s", d, path)
+}
+
+// ProfileHandler returns an http.Handler that dumps profiles to PPROFDIR,
+// the HTTP counterpart of SetupProfileSignalHandler for triggering a dump
+// that is read later, e.g. from a test harness:
+//
+//	POST /debug/profiles/dump   writes profiles to $PPROFDIR/<host>-<time>/
+//
+// StartAdminServer mounts it. If PPROFDIR is not set it responds with
+// 503 Service Unavailable.
+func ProfileHandler() http.Handler {
+	return coveragePostOnly(func(w http.ResponseWriter, r *http.Request) {
+		if os.Getenv("PPROFDIR") == "" {
+			http.Error(w, "profiling dumps not enabled: PPROFDIR is not set", http.StatusServiceUnavailable)
+			return
+		}
+		log.Println("Profiling: Received HTTP dump request, dumping profiles...")
+		out, err := DumpProfiles("")
+		if err != nil {
+			log.Printf("Profiling: Error writing profiles: %v", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		log.Printf("Profiling: Successfully wrote profiles to %s", out)
+		fmt.Fprintf(w, "profiles written to %s\n", out)
+	})
+}
@@ -0,0 +1,69 @@
+package shared
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDumpProfiles(t *testing.T) {
+	out, err := DumpProfiles(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range dumpProfiles {
+		fi, err := os.Stat(filepath.Join(out, p.file))
+		if err != nil {
+			t.Errorf("%s: %v", p.file, err)
+		} else if fi.Size() == 0 {
+			t.Errorf("%s is empty", p.file)
+		}
+	}
+	stacks, _ := os.ReadFile(filepath.Join(out, "goroutines.txt"))
+	if !strings.Contains(string(stacks), "TestDumpProfiles") {
+		t.Errorf("goroutines.txt does not include the test's own stack")
+	}
+}
+
+func TestDumpProfilesDisabled(t *testing.T) {
+	t.Setenv("PPROFDIR", "")
+	if _, err := DumpProfiles(""); !errors.Is(err, ErrProfilingDisabled) {
+		t.Errorf("DumpProfiles without PPROFDIR = %v, want ErrProfilingDisabled", err)
+	}
+}
+
+func TestWriteCPUProfile(t *testing.T) {
+	dir := t.TempDir()
+	writeCPUProfile(dir, 10*time.Millisecond)
+	if fi, err := os.Stat(filepath.Join(dir, "cpu.pprof")); err != nil || fi.Size() == 0 {
+		t.Errorf("cpu.pprof not written: %v", err)
+	}
+}
+
+func TestProfileHandler(t *testing.T) {
+	t.Setenv("PPROFDIR", "")
+	rec := httptest.NewRecorder()
+	ProfileHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/debug/profiles/dump", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("without PPROFDIR: status %d, want 503", rec.Code)
+	}
+
+	dir := t.TempDir()
+	t.Setenv("PPROFDIR", dir)
+	rec = httptest.NewRecorder()
+	ProfileHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/debug/profiles/dump", nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("GET: status %d, want 405", rec.Code)
+	}
+	rec = httptest.NewRecorder()
+	ProfileHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/debug/profiles/dump", nil))
+	entries, _ := os.ReadDir(dir)
+	if rec.Code != http.StatusOK || len(entries) != 1 {
+		t.Errorf("POST: status %d, %d dump directories; want 200 and 1", rec.Code, len(entries))
+	}
+}
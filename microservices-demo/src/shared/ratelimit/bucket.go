@@ -0,0 +1,85 @@
+package ratelimit
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	shared "github.com/GoogleCloudPlatform/microservices-demo/src/shared"
+)
+
+// Limit is a token bucket refilled at Rate tokens per second and holding at
+// most Burst tokens. A zero Rate means unlimited.
+type Limit struct {
+	Rate  float64
+	Burst int
+}
+
+// Unlimited reports whether l imposes no limit.
+func (l Limit) Unlimited() bool { return l.Rate <= 0 }
+
+// Bucket is a thread-safe token bucket.
+type Bucket struct {
+	limit Limit
+	clock shared.Clock
+
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+// NewBucket returns a full bucket. A nil clock uses shared.RealClock.
+func NewBucket(l Limit, clock shared.Clock) *Bucket {
+	if clock == nil {
+		clock = shared.RealClock()
+	}
+	if l.Burst < 1 {
+		l.Burst = 1
+	}
+	return &Bucket{limit: l, clock: clock, tokens: float64(l.Burst), last: clock.Now()}
+}
+
+// Allow takes a token if one is available. Otherwise it reports how long
+// until one will be.
+func (b *Bucket) Allow() (ok bool, retryAfter time.Duration) {
+	if b.limit.Unlimited() {
+		return true, 0
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.refill()
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	wait := (1 - b.tokens) / b.limit.Rate
+	return false, time.Duration(math.Ceil(wait * float64(time.Second)))
+}
+
+// refund returns a token taken by Allow for a request that did not proceed.
+func (b *Bucket) refund() {
+	if b.limit.Unlimited() {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.refill()
+	b.tokens = math.Min(float64(b.limit.Burst), b.tokens+1)
+}
+
+// idle reports whether the bucket has refilled completely, meaning dropping
+// it loses no state.
+func (b *Bucket) idle() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.refill()
+	return b.tokens >= float64(b.limit.Burst)
+}
+
+func (b *Bucket) refill() {
+	now := b.clock.Now()
+	if elapsed := now.Sub(b.last).Seconds(); elapsed > 0 {
+		b.tokens = math.Min(float64(b.limit.Burst), b.tokens+elapsed*b.limit.Rate)
+	}
+	b.last = now
+}
@@ -0,0 +1,97 @@
+package ratelimit
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"strconv"
+
+	shared "github.com/GoogleCloudPlatform/microservices-demo/src/shared"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// ClientKeyHeader identifies a caller for per-client limits when
+// Config.TrustClientKey is set. Otherwise, or when it is absent, the caller's
+// IP address is used.
+const ClientKeyHeader = "X-Client-Id"
+
+// DefaultHTTPMethod is the method HTTP requests to paths without an override
+// are limited and counted as.
+const DefaultHTTPMethod = "*"
+
+// clientKeyMetadata is ClientKeyHeader as gRPC metadata.
+const clientKeyMetadata = "x-client-id"
+
+// grpcClientKey returns the client key of an incoming RPC.
+func grpcClientKey(ctx context.Context, trusted bool) string {
+	if md, ok := metadata.FromIncomingContext(ctx); ok && trusted {
+		if v := md.Get(clientKeyMetadata); len(v) > 0 && v[0] != "" {
+			return v[0]
+		}
+	}
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		if host, _, err := net.SplitHostPort(p.Addr.String()); err == nil {
+			return host
+		}
+		return p.Addr.String()
+	}
+	return ""
+}
+
+// grpcCheck returns a ResourceExhausted error if the call must be rejected.
+func grpcCheck(ctx context.Context, l *Limiter, method string) error {
+	ok, wait := l.Allow(method, grpcClientKey(ctx, l.trustClientKey()))
+	if ok {
+		return nil
+	}
+	secs := retryAfterSeconds(wait)
+	grpc.SetHeader(ctx, metadata.Pairs("retry-after", strconv.Itoa(secs)))
+	return status.Errorf(codes.ResourceExhausted, "rate limit exceeded for %s, retry after %ds", method, secs)
+}
+
+// UnaryServerInterceptor rejects calls over the limiter's limits.
+func UnaryServerInterceptor(l *Limiter) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		if err := grpcCheck(ctx, l, info.FullMethod); err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+// StreamServerInterceptor rejects streams over the limiter's limits. Only
+// stream creation is limited, not individual messages.
+func StreamServerInterceptor(l *Limiter) grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if err := grpcCheck(ss.Context(), l, info.FullMethod); err != nil {
+			return err
+		}
+		return handler(srv, ss)
+	}
+}
+
+// Middleware rejects HTTP requests over the limiter's limits with 429. The
+// URL path is used as the method when it has an override; all other paths
+// share the DefaultHTTPMethod limit. Clients are keyed by shared.ClientIP
+// unless Config.TrustClientKey is set.
+func Middleware(l *Limiter, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var client string
+		if l.trustClientKey() {
+			client = r.Header.Get(ClientKeyHeader)
+		}
+		if client == "" {
+			client = shared.ClientIP(r)
+		}
+		if ok, wait := l.Allow(l.httpMethod(r.URL.Path), client); !ok {
+			w.Header().Set("Retry-After", strconv.Itoa(retryAfterSeconds(wait)))
+			http.Error(w, "rate limit exceeded", http.StatusTooManyRequests)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,231 @@
+// Package ratelimit protects servers with token-bucket limits applied per
+// method and per client. Rejected gRPC calls fail with ResourceExhausted and
+// rejected HTTP requests get 429 Too Many Requests; both carry a Retry-After
+// hint in seconds.
+//
+// Limits are read from the environment with shared.LoadConfig:
+//
+//	RATE_LIMIT_RPS           default per-method rate, all clients combined (0 = unlimited)
+//	RATE_LIMIT_BURST         default per-method burst
+//	RATE_LIMIT_CLIENT_RPS    rate per client key and method (0 = unlimited)
+//	RATE_LIMIT_CLIENT_BURST  burst per client key and method
+//	RATE_LIMIT_METHODS       per-method overrides, e.g.
+//	                         "/hipstershop.CheckoutService/PlaceOrder=5:10,/cart=50:100"
+//	RATE_LIMIT_TRUST_CLIENT_ID  key clients by the X-Client-Id header instead of
+//	                         their address; set it only when an authenticated
+//	                         hop in front of the server sets the header
+//
+// The same Config can be loaded from a mounted file with shared.WatchConfig
+// and applied to a running Limiter with SetConfig.
+package ratelimit
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	shared "github.com/GoogleCloudPlatform/microservices-demo/src/shared"
+	"github.com/GoogleCloudPlatform/microservices-demo/src/shared/metrics"
+)
+
+// Config holds the limits applied by a Limiter.
+type Config struct {
+	Rate        float64  `env:"RATE_LIMIT_RPS"`
+	Burst       int      `env:"RATE_LIMIT_BURST"`
+	ClientRate  float64  `env:"RATE_LIMIT_CLIENT_RPS"`
+	ClientBurst int      `env:"RATE_LIMIT_CLIENT_BURST"`
+	Methods     []string `env:"RATE_LIMIT_METHODS"`
+	// TrustClientKey keys clients by ClientKeyHeader. Callers choose that
+	// header freely, so it is ignored unless the server sits behind a hop
+	// that authenticates callers and sets it.
+	TrustClientKey bool `env:"RATE_LIMIT_TRUST_CLIENT_ID"`
+}
+
+// ConfigFromEnv loads a Config from the environment.
+func ConfigFromEnv() (Config, error) {
+	var c Config
+	err := shared.LoadConfig(&c)
+	return c, err
+}
+
+// methodLimits parses the Methods overrides.
+func (c Config) methodLimits() (map[string]Limit, error) {
+	limits := make(map[string]Limit, len(c.Methods))
+	for _, spec := range c.Methods {
+		method, lim, ok := strings.Cut(spec, "=")
+		if !ok {
+			return nil, fmt.Errorf("ratelimit: %q: expected method=rate:burst", spec)
+		}
+		rateStr, burstStr, _ := strings.Cut(lim, ":")
+		rate, err := strconv.ParseFloat(rateStr, 64)
+		if err != nil {
+			return nil, fmt.Errorf("ratelimit: %q: rate: %w", spec, err)
+		}
+		burst := int(rate)
+		if burstStr != "" {
+			if burst, err = strconv.Atoi(burstStr); err != nil {
+				return nil, fmt.Errorf("ratelimit: %q: burst: %w", spec, err)
+			}
+		}
+		limits[method] = Limit{Rate: rate, Burst: burst}
+	}
+	return limits, nil
+}
+
+var rejectedTotal = metrics.NewCounterVec("ratelimit_rejected_total",
+	"Requests rejected by the rate limiter, by method and scope (method or client).", "method", "scope")
+
+// sweepInterval is how often idle buckets are dropped.
+const sweepInterval = time.Minute
+
+// Limiter decides whether a request may proceed.
+type Limiter struct {
+	clock shared.Clock
+
+	mu        sync.Mutex
+	cfg       Config
+	methods   map[string]Limit
+	global    map[string]*Bucket
+	clients   map[string]*Bucket
+	lastSweep time.Time
+}
+
+// Option configures New.
+type Option func(*Limiter)
+
+// WithClock sets the clock that refills the buckets.
+func WithClock(c shared.Clock) Option {
+	return func(l *Limiter) { l.clock = c }
+}
+
+// New creates a Limiter. It returns an error if a method override is
+// malformed.
+func New(cfg Config, opts ...Option) (*Limiter, error) {
+	l := &Limiter{clock: shared.RealClock()}
+	for _, opt := range opts {
+		opt(l)
+	}
+	if err := l.SetConfig(cfg); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// SetConfig replaces the limits. Buckets restart full.
+func (l *Limiter) SetConfig(cfg Config) error {
+	methods, err := cfg.methodLimits()
+	if err != nil {
+		return err
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.cfg = cfg
+	l.methods = methods
+	l.global = make(map[string]*Bucket)
+	l.clients = make(map[string]*Bucket)
+	l.lastSweep = l.clock.Now()
+	return nil
+}
+
+// Allow reports whether a request to method from client may proceed and, if
+// not, when to retry. An empty client skips the per-client limit.
+func (l *Limiter) Allow(method, client string) (ok bool, retryAfter time.Duration) {
+	global, perClient := l.buckets(method, client)
+	// The client's own bucket goes first so a client over its limit cannot
+	// drain the method-wide bucket that every other client shares.
+	if perClient != nil {
+		if ok, wait := perClient.Allow(); !ok {
+			rejectedTotal.WithLabelValues(method, "client").Inc()
+			return false, wait
+		}
+	}
+	if global != nil {
+		if ok, wait := global.Allow(); !ok {
+			if perClient != nil {
+				perClient.refund()
+			}
+			rejectedTotal.WithLabelValues(method, "method").Inc()
+			return false, wait
+		}
+	}
+	return true, 0
+}
+
+// buckets returns the method-wide and per-client buckets for a request,
+// either of which is nil when unlimited.
+func (l *Limiter) buckets(method, client string) (global, perClient *Bucket) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.sweep()
+
+	lim, ok := l.methods[method]
+	if !ok {
+		lim = Limit{Rate: l.cfg.Rate, Burst: l.cfg.Burst}
+	}
+	if !lim.Unlimited() {
+		if global = l.global[method]; global == nil {
+			global = NewBucket(lim, l.clock)
+			l.global[method] = global
+		}
+	}
+
+	clim := Limit{Rate: l.cfg.ClientRate, Burst: l.cfg.ClientBurst}
+	if client != "" && !clim.Unlimited() {
+		key := method + "\x00" + client
+		if perClient = l.clients[key]; perClient == nil {
+			perClient = NewBucket(clim, l.clock)
+			l.clients[key] = perClient
+		}
+	}
+	return global, perClient
+}
+
+// sweep drops buckets that have refilled so the maps do not grow with every
+// method and client ever seen. l.mu must be held.
+func (l *Limiter) sweep() {
+	now := l.clock.Now()
+	if now.Sub(l.lastSweep) < sweepInterval {
+		return
+	}
+	l.lastSweep = now
+	for k, b := range l.global {
+		if b.idle() {
+			delete(l.global, k)
+		}
+	}
+	for k, b := range l.clients {
+		if b.idle() {
+			delete(l.clients, k)
+		}
+	}
+}
+
+// httpMethod returns the method an HTTP request to path is limited as: path
+// itself when it has an override, otherwise DefaultHTTPMethod, so arbitrary
+// paths share one bucket rather than adding one each.
+func (l *Limiter) httpMethod(path string) string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, ok := l.methods[path]; ok {
+		return path
+	}
+	return DefaultHTTPMethod
+}
+
+// trustClientKey reports whether ClientKeyHeader identifies clients.
+func (l *Limiter) trustClientKey() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.cfg.TrustClientKey
+}
+
+// retryAfterSeconds rounds d up to whole seconds, at least 1.
+func retryAfterSeconds(d time.Duration) int {
+	s := int((d + time.Second - 1) / time.Second)
+	if s < 1 {
+		s = 1
+	}
+	return s
+}
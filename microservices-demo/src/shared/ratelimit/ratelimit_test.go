@@ -0,0 +1,187 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	shared "github.com/GoogleCloudPlatform/microservices-demo/src/shared"
+)
+
+func TestBucket(t *testing.T) {
+	clk := shared.NewFakeClock(time.Unix(0, 0))
+	b := NewBucket(Limit{Rate: 2, Burst: 2}, clk)
+
+	for i := 0; i < 2; i++ {
+		if ok, _ := b.Allow(); !ok {
+			t.Fatalf("request %d within burst rejected", i)
+		}
+	}
+	ok, wait := b.Allow()
+	if ok || wait != 500*time.Millisecond {
+		t.Errorf("Allow over burst = %v, %v; want false, 500ms", ok, wait)
+	}
+	clk.Advance(500 * time.Millisecond)
+	if ok, _ := b.Allow(); !ok {
+		t.Error("request after refill rejected")
+	}
+}
+
+func TestLimiterMethodOverridesAndClients(t *testing.T) {
+	l, err := New(Config{
+		ClientRate:  1,
+		ClientBurst: 1,
+		Methods:     []string{"/checkout=1:1"},
+	}, WithClock(shared.NewFakeClock(time.Unix(0, 0))))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if ok, _ := l.Allow("/checkout", "a"); !ok {
+		t.Fatal("first checkout rejected")
+	}
+	if ok, _ := l.Allow("/checkout", "b"); ok {
+		t.Error("second checkout allowed despite method limit 1:1")
+	}
+	if ok, _ := l.Allow("/browse", "a"); !ok {
+		t.Error("client a rejected on a different method")
+	}
+	if ok, _ := l.Allow("/browse", "a"); ok {
+		t.Error("client a allowed twice despite client limit 1:1")
+	}
+	if ok, _ := l.Allow("/browse", "b"); !ok {
+		t.Error("client b limited by client a's usage")
+	}
+}
+
+func TestLimiterFloodingClientSparesOthers(t *testing.T) {
+	clk := shared.NewFakeClock(time.Unix(0, 0))
+	l, err := New(Config{Rate: 10, Burst: 10, ClientRate: 1, ClientBurst: 2}, WithClock(clk))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Only the flooder's first two requests fit its own limit; the rest
+	// must not take tokens from the method-wide bucket.
+	for i := 0; i < 100; i++ {
+		l.Allow("/checkout", "flooder")
+	}
+	for i := 0; i < 2; i++ {
+		if ok, _ := l.Allow("/checkout", "other"); !ok {
+			t.Fatalf("other client's request %d rejected", i)
+		}
+	}
+
+	// Requests the method-wide bucket rejects give back the client's tokens.
+	for i := 0; i < 6; i++ {
+		l.Allow("/checkout", fmt.Sprint("client", i))
+	}
+	for i := 0; i < 2; i++ {
+		if ok, _ := l.Allow("/checkout", "late"); ok {
+			t.Fatal("request allowed with the method-wide bucket empty")
+		}
+	}
+	clk.Advance(100 * time.Millisecond)
+	if ok, _ := l.Allow("/checkout", "late"); !ok {
+		t.Error("client charged for a request the method limit rejected")
+	}
+}
+
+func TestConfigRejectsBadMethodSpec(t *testing.T) {
+	if _, err := New(Config{Methods: []string{"/checkout"}}); err == nil {
+		t.Error("New accepted a method override without a limit")
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	l, _ := New(Config{Rate: 1, Burst: 1})
+	h := Middleware(l, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/cart/checkout", nil))
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/cart/checkout", nil))
+	if rec.Code != http.StatusTooManyRequests || rec.Header().Get("Retry-After") != "1" {
+		t.Errorf("second request = %d with Retry-After %q, want 429 with 1", rec.Code, rec.Header().Get("Retry-After"))
+	}
+}
+
+func TestMiddlewareSharesDefaultLimitAcrossPaths(t *testing.T) {
+	l, _ := New(Config{Rate: 1, Burst: 1, Methods: []string{"/cart=1:1"}})
+	h := Middleware(l, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	serve := func(path string) int {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		return rec.Code
+	}
+
+	if code := serve("/product/1"); code != http.StatusOK {
+		t.Fatalf("first request = %d, want 200", code)
+	}
+	if code := serve("/product/2"); code != http.StatusTooManyRequests {
+		t.Errorf("request to a new path = %d, want 429 from the shared default limit", code)
+	}
+	if code := serve("/cart"); code != http.StatusOK {
+		t.Errorf("request to an overridden path = %d, want 200 from its own limit", code)
+	}
+	if n := len(l.global); n != 2 {
+		t.Errorf("%d method buckets, want 2", n)
+	}
+}
+
+func TestMiddlewareClientKey(t *testing.T) {
+	for _, trust := range []bool{false, true} {
+		l, _ := New(Config{ClientRate: 1, ClientBurst: 1, TrustClientKey: trust})
+		h := Middleware(l, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+		codes := make([]int, 2)
+		for i := range codes {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.Header.Set(ClientKeyHeader, fmt.Sprint("client", i))
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+			codes[i] = rec.Code
+		}
+		// Without trust both requests come from the same address whatever
+		// the header claims, so the second is over the client limit.
+		want := http.StatusTooManyRequests
+		if trust {
+			want = http.StatusOK
+		}
+		if codes[1] != want {
+			t.Errorf("TrustClientKey=%v: second client's request = %d, want %d", trust, codes[1], want)
+		}
+	}
+}
+
+func TestLimiterSweepsIdleBuckets(t *testing.T) {
+	clk := shared.NewFakeClock(time.Unix(0, 0))
+	l, _ := New(Config{Rate: 10, Burst: 10, ClientRate: 1, ClientBurst: 1}, WithClock(clk))
+	for i := 0; i < 5; i++ {
+		l.Allow(fmt.Sprint("/method", i), "a")
+	}
+	clk.Advance(sweepInterval)
+	l.Allow("/method0", "")
+	if len(l.global) != 1 || len(l.clients) != 0 {
+		t.Errorf("after sweep: %d method and %d client buckets, want 1 and 0", len(l.global), len(l.clients))
+	}
+}
+
+func TestUnaryServerInterceptor(t *testing.T) {
+	l, _ := New(Config{Rate: 1, Burst: 1})
+	intercept := UnaryServerInterceptor(l)
+	info := &grpc.UnaryServerInfo{FullMethod: "/hipstershop.CheckoutService/PlaceOrder"}
+	handler := func(ctx context.Context, req any) (any, error) { return nil, nil }
+
+	if _, err := intercept(context.Background(), nil, info, handler); err != nil {
+		t.Fatalf("first call: %v", err)
+	}
+	if _, err := intercept(context.Background(), nil, info, handler); status.Code(err) != codes.ResourceExhausted {
+		t.Errorf("second call = %v, want ResourceExhausted", err)
+	}
+}
@@ -0,0 +1,141 @@
+// Package recovery keeps a panicking request handler from taking the whole
+// service down. The gRPC interceptors and HTTP middleware recover the panic,
+// log a structured crash report (panic value, stack frames, method, and the
+// request ID when known), count it in boutique_panics_recovered_total, and
+// answer with codes.Internal or 500 Internal Server Error.
+package recovery
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"runtime"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/GoogleCloudPlatform/microservices-demo/src/shared/metrics"
+)
+
+var panicsTotal = metrics.NewCounterVec("panics_recovered_total",
+	"Panics recovered in request handlers, by transport.", "transport")
+
+// Frame is one entry of a crash report's stack trace.
+type Frame struct {
+	Function string `json:"function"`
+	File     string `json:"file"`
+	Line     int    `json:"line"`
+}
+
+// Stack returns the calling goroutine's stack, skipping the given number of
+// frames above Stack's caller and the panic machinery of the runtime.
+func Stack(skip int) []Frame {
+	pcs := make([]uintptr, 64)
+	n := runtime.Callers(skip+2, pcs)
+	frames := runtime.CallersFrames(pcs[:n])
+
+	var out []Frame
+	for {
+		f, more := frames.Next()
+		if !strings.HasPrefix(f.Function, "runtime.") {
+			out = append(out, Frame{Function: f.Function, File: f.File, Line: f.Line})
+		}
+		if !more {
+			break
+		}
+	}
+	return out
+}
+
+// report logs a recovered panic and counts it.
+func report(ctx context.Context, log *slog.Logger, transport, msg string, p any, attrs ...any) {
+	if log == nil {
+		log = slog.Default()
+	}
+	panicsTotal.WithLabelValues(transport).Inc()
+	attrs = append(attrs,
+		slog.String("panic", fmt.Sprint(p)),
+		slog.String("panic_type", fmt.Sprintf("%T", p)),
+		slog.Any("stack", Stack(2)),
+	)
+	log.ErrorContext(ctx, msg, attrs...)
+}
+
+// UnaryServerInterceptor turns handler panics into codes.Internal errors. A
+// nil log uses slog.Default().
+func UnaryServerInterceptor(log *slog.Logger) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp any, err error) {
+		defer func() {
+			if p := recover(); p != nil {
+				report(ctx, log, "grpc", "panic in gRPC handler", p, slog.String("method", info.FullMethod))
+				err = status.Errorf(codes.Internal, "internal error")
+			}
+		}()
+		return handler(ctx, req)
+	}
+}
+
+// StreamServerInterceptor turns stream handler panics into codes.Internal
+// errors. A nil log uses slog.Default().
+func StreamServerInterceptor(log *slog.Logger) grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) (err error) {
+		defer func() {
+			if p := recover(); p != nil {
+				report(ss.Context(), log, "grpc", "panic in gRPC stream handler", p, slog.String("method", info.FullMethod))
+				err = status.Errorf(codes.Internal, "internal error")
+			}
+		}()
+		return handler(srv, ss)
+	}
+}
+
+// Middleware turns handler panics into 500 responses. If the handler has
+// already started the response, the connection is left as is since the
+// status can no longer change. http.ErrAbortHandler is re-raised so net/http
+// can abort the connection as the handler intended. A nil log uses
+// slog.Default().
+func Middleware(log *slog.Logger, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rw := &responseWriter{ResponseWriter: w}
+		defer func() {
+			p := recover()
+			if p == nil {
+				return
+			}
+			if err, ok := p.(error); ok && errors.Is(err, http.ErrAbortHandler) {
+				panic(p)
+			}
+			report(r.Context(), log, "http", "panic in HTTP handler", p,
+				slog.String("method", r.Method), slog.String("path", r.URL.Path))
+			if !rw.wroteHeader {
+				http.Error(w, "internal error", http.StatusInternalServerError)
+			}
+		}()
+		next.ServeHTTP(rw, r)
+	})
+}
+
+// responseWriter records whether the response has started.
+type responseWriter struct {
+	http.ResponseWriter
+	wroteHeader bool
+}
+
+func (w *responseWriter) WriteHeader(code int) {
+	w.wroteHeader = true
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *responseWriter) Write(b []byte) (int, error) {
+	w.wroteHeader = true
+	return w.ResponseWriter.Write(b)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (w *responseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
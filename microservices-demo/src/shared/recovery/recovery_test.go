@@ -0,0 +1,69 @@
+package recovery
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestUnaryServerInterceptor(t *testing.T) {
+	var buf bytes.Buffer
+	log := slog.New(slog.NewJSONHandler(&buf, nil))
+	_, err := UnaryServerInterceptor(log)(context.Background(), nil, &grpc.UnaryServerInfo{FullMethod: "/svc/Boom"},
+		func(ctx context.Context, req any) (any, error) { panic("boom") })
+	if status.Code(err) != codes.Internal {
+		t.Fatalf("err = %v, want code Internal", err)
+	}
+
+	var entry struct {
+		Msg    string  `json:"msg"`
+		Method string  `json:"method"`
+		Panic  string  `json:"panic"`
+		Stack  []Frame `json:"stack"`
+	}
+	if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+		t.Fatalf("decoding %q: %v", buf.String(), err)
+	}
+	if entry.Method != "/svc/Boom" || entry.Panic != "boom" {
+		t.Errorf("entry = %+v", entry)
+	}
+	if len(entry.Stack) == 0 || !strings.Contains(entry.Stack[0].Function, "TestUnaryServerInterceptor") {
+		t.Errorf("stack does not start at the panicking function: %+v", entry.Stack)
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	var buf bytes.Buffer
+	h := Middleware(slog.New(slog.NewJSONHandler(&buf, nil)), http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic("boom")
+	}))
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/cart", nil))
+	if rec.Code != http.StatusInternalServerError {
+		t.Errorf("status = %d, want 500", rec.Code)
+	}
+	if !strings.Contains(buf.String(), `"path":"/cart"`) {
+		t.Errorf("crash report missing path:\n%s", buf.String())
+	}
+}
+
+func TestMiddlewareReraisesAbort(t *testing.T) {
+	h := Middleware(slog.New(slog.NewJSONHandler(&bytes.Buffer{}, nil)), http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic(http.ErrAbortHandler)
+	}))
+	defer func() {
+		if p := recover(); p != http.ErrAbortHandler {
+			t.Errorf("recovered %v, want http.ErrAbortHandler", p)
+		}
+	}()
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+}
@@ -0,0 +1,442 @@
+package shared
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"mime"
+	"net/url"
+	"reflect"
+	"regexp"
+	"strings"
+
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// Redacted replaces the values removed by a Redactor.
+const Redacted = "[REDACTED]"
+
+// Matcher finds one kind of PII in free text.
+type Matcher struct {
+	// Name identifies the matcher, e.g. in WithMatchers calls.
+	Name    string
+	Pattern *regexp.Regexp
+	// Valid, if set, filters the matches, e.g. by checksum.
+	Valid func(match string) bool
+	// Mask returns the replacement of a match; nil replaces it with
+	// Redacted.
+	Mask func(match string) string
+}
+
+var (
+	// CardMatcher masks payment card numbers passing the Luhn check,
+	// keeping their last four digits.
+	CardMatcher = Matcher{
+		Name:    "card",
+		Pattern: regexp.MustCompile(`\b\d(?:[ -]?\d){12,18}\b`),
+		Valid:   func(m string) bool { return luhn(digitsOf(m)) },
+		Mask: func(m string) string {
+			d := digitsOf(m)
+			return Redacted + d[len(d)-4:]
+		},
+	}
+	// EmailMatcher masks email addresses.
+	EmailMatcher = Matcher{
+		Name:    "email",
+		Pattern: regexp.MustCompile(`[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}`),
+	}
+	// PhoneMatcher masks phone numbers in E.164 form (+14155550100) or
+	// written in groups, such as (415) 555-0100 or +44 20 7946 0958. Bare
+	// digit runs are left alone, since order and tracking numbers look the
+	// same.
+	PhoneMatcher = Matcher{
+		Name:    "phone",
+		Pattern: regexp.MustCompile(`\+\d{8,15}\b|(?:\+\d{1,3}[ .-]?)?(?:\(\d{2,4}\)[ .-]?|\b\d{2,4}[ .-])\d{3,4}[ .-]\d{4}\b`),
+	}
+)
+
+// DefaultMatchers are the matchers of Redact and of a Redactor without
+// WithMatchers: cards first, so their digits are not taken for a phone.
+var DefaultMatchers = []Matcher{CardMatcher, EmailMatcher, PhoneMatcher}
+
+// DefaultRedactedKeys are the field names whose values are always redacted:
+// the card details of PaymentService and CheckoutService requests and the
+// customer's contact details. Keys match regardless of case, underscores and
+// dashes, so "credit_card_number" also covers JSON's "creditCardNumber".
+var DefaultRedactedKeys = []string{
+	"credit_card_number",
+	"credit_card_cvv",
+	"credit_card_expiration_year",
+	"credit_card_expiration_month",
+	"email",
+	"street_address",
+	"phone_number",
+	"password",
+}
+
+// Redactor removes PII from text, JSON and form payloads, protos and
+// structs before they are logged. Fields named by its keys are redacted
+// whole; every other string is scrubbed by its matchers. A Redactor is safe
+// for concurrent use.
+//
+//	log.Info("order placed", "customer", shared.Masked(customer))
+//	log.Debug("upstream replied", "body", shared.DefaultRedactor().Payload(resp.Header.Get("Content-Type"), body))
+type Redactor struct {
+	matchers []Matcher
+	keys     map[string]bool
+}
+
+// RedactOption configures NewRedactor.
+type RedactOption func(*Redactor)
+
+// WithMatchers replaces DefaultMatchers.
+func WithMatchers(ms ...Matcher) RedactOption {
+	return func(r *Redactor) { r.matchers = ms }
+}
+
+// WithRedactedKeys replaces DefaultRedactedKeys.
+func WithRedactedKeys(keys ...string) RedactOption {
+	return func(r *Redactor) {
+		r.keys = make(map[string]bool, len(keys))
+		for _, k := range keys {
+			r.keys[normalizeKey(k)] = true
+		}
+	}
+}
+
+// NewRedactor returns a Redactor using DefaultMatchers and
+// DefaultRedactedKeys unless the options replace them.
+func NewRedactor(opts ...RedactOption) *Redactor {
+	r := &Redactor{matchers: DefaultMatchers}
+	WithRedactedKeys(DefaultRedactedKeys...)(r)
+	for _, opt := range opts {
+		opt(r)
+	}
+	return r
+}
+
+var defaultRedactor = NewRedactor()
+
+// DefaultRedactor returns the Redactor used by Redact and Masked.
+func DefaultRedactor() *Redactor { return defaultRedactor }
+
+// Redact scrubs s with DefaultMatchers.
+func Redact(s string) string { return defaultRedactor.String(s) }
+
+// WithKeys returns a copy of r that also redacts the given keys.
+func (r *Redactor) WithKeys(keys ...string) *Redactor {
+	c := &Redactor{matchers: r.matchers, keys: make(map[string]bool, len(r.keys)+len(keys))}
+	for k := range r.keys {
+		c.keys[k] = true
+	}
+	for _, k := range keys {
+		c.keys[normalizeKey(k)] = true
+	}
+	return c
+}
+
+// IsRedactedKey reports whether values under key are redacted whole.
+func (r *Redactor) IsRedactedKey(key string) bool { return r.keys[normalizeKey(key)] }
+
+func normalizeKey(k string) string {
+	return strings.Map(func(c rune) rune {
+		if c == '_' || c == '-' {
+			return -1
+		}
+		return c
+	}, strings.ToLower(k))
+}
+
+// String masks every match of r's matchers in s.
+func (r *Redactor) String(s string) string {
+	for _, m := range r.matchers {
+		s = m.Pattern.ReplaceAllStringFunc(s, func(match string) string {
+			if m.Valid != nil && !m.Valid(match) {
+				return match
+			}
+			if m.Mask != nil {
+				return m.Mask(match)
+			}
+			return Redacted
+		})
+	}
+	return s
+}
+
+// Payload renders an HTTP request or response body for a log entry: JSON
+// and form bodies with their redacted keys removed and strings scrubbed,
+// anything else scrubbed as text.
+func (r *Redactor) Payload(contentType string, body []byte) string {
+	mt, _, _ := mime.ParseMediaType(contentType)
+	switch {
+	case mt == "application/x-www-form-urlencoded":
+		if form, err := url.ParseQuery(string(body)); err == nil {
+			return r.Values(form).Encode()
+		}
+	case mt == "application/json" || strings.HasSuffix(mt, "+json"):
+		if b, err := r.JSON(body); err == nil {
+			return string(b)
+		}
+	}
+	return r.String(string(body))
+}
+
+// Values returns a redacted copy of query or form values.
+func (r *Redactor) Values(v url.Values) url.Values {
+	out := make(url.Values, len(v))
+	for k, vs := range v {
+		for _, s := range vs {
+			if r.IsRedactedKey(k) {
+				s = Redacted
+			} else {
+				s = r.String(s)
+			}
+			out[k] = append(out[k], s)
+		}
+	}
+	return out
+}
+
+// JSON returns a redacted copy of a JSON document, or an error if it does
+// not parse.
+func (r *Redactor) JSON(b []byte) ([]byte, error) {
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	var v any
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+	return json.Marshal(r.jsonValue(v))
+}
+
+func (r *Redactor) jsonValue(v any) any {
+	switch v := v.(type) {
+	case map[string]any:
+		for k, e := range v {
+			if r.IsRedactedKey(k) {
+				v[k] = Redacted
+			} else {
+				v[k] = r.jsonValue(e)
+			}
+		}
+	case []any:
+		for i, e := range v {
+			v[i] = r.jsonValue(e)
+		}
+	case string:
+		return r.String(v)
+	case json.Number:
+		// A card number sent as a number is still a card number.
+		if s := r.String(v.String()); s != v.String() {
+			return s
+		}
+	}
+	return v
+}
+
+// Proto returns a copy of m in which the fields named by r's keys are
+// replaced by Redacted, or cleared if they are not strings, and every other
+// string is scrubbed. It recurses into nested messages, lists and maps; m
+// itself is not modified.
+func (r *Redactor) Proto(m proto.Message) proto.Message {
+	c := proto.Clone(m)
+	r.protoMessage(c.ProtoReflect())
+	return c
+}
+
+func (r *Redactor) protoMessage(m protoreflect.Message) {
+	// Collect first: the message must not be modified while ranging over it.
+	var populated []protoreflect.FieldDescriptor
+	m.Range(func(fd protoreflect.FieldDescriptor, _ protoreflect.Value) bool {
+		populated = append(populated, fd)
+		return true
+	})
+	for _, fd := range populated {
+		v := m.Get(fd)
+		isMessage := fd.Kind() == protoreflect.MessageKind || fd.Kind() == protoreflect.GroupKind
+		if r.IsRedactedKey(string(fd.Name())) {
+			if fd.Kind() == protoreflect.StringKind && !fd.IsList() && !fd.IsMap() {
+				m.Set(fd, protoreflect.ValueOfString(Redacted))
+			} else {
+				m.Clear(fd)
+			}
+			continue
+		}
+		switch {
+		case fd.IsList():
+			l := v.List()
+			for i := 0; i < l.Len(); i++ {
+				if isMessage {
+					r.protoMessage(l.Get(i).Message())
+				} else if fd.Kind() == protoreflect.StringKind {
+					l.Set(i, protoreflect.ValueOfString(r.String(l.Get(i).String())))
+				}
+			}
+		case fd.IsMap():
+			mv := v.Map()
+			vd := fd.MapValue()
+			mv.Range(func(k protoreflect.MapKey, e protoreflect.Value) bool {
+				switch vd.Kind() {
+				case protoreflect.MessageKind, protoreflect.GroupKind:
+					r.protoMessage(e.Message())
+				case protoreflect.StringKind:
+					mv.Set(k, protoreflect.ValueOfString(r.String(e.String())))
+				}
+				return true
+			})
+		case isMessage:
+			r.protoMessage(v.Message())
+		case fd.Kind() == protoreflect.StringKind:
+			m.Set(fd, protoreflect.ValueOfString(r.String(v.String())))
+		}
+	}
+}
+
+// Mask returns a copy of v safe to log. Structs become maps keyed by their
+// JSON names, with each field masked according to its `redact` tag:
+//
+//	redact:""       replaced by Redacted
+//	redact:"last4"  Redacted followed by the last four characters
+//	redact:"hash"   a short SHA-256 prefix, so entries can still be correlated
+//	redact:"-"      left out
+//
+// Untagged fields named by r's keys are replaced by Redacted, and other
+// strings are scrubbed; nested structs, pointers, slices and maps are masked
+// recursively. Protos are rendered as redacted JSON.
+func (r *Redactor) Mask(v any) any {
+	if m, ok := v.(proto.Message); ok {
+		b, err := protojson.Marshal(r.Proto(m))
+		if err != nil {
+			return fmt.Sprintf("<%T: %v>", v, err)
+		}
+		return json.RawMessage(b)
+	}
+	return r.maskValue(reflect.ValueOf(v))
+}
+
+func (r *Redactor) maskValue(v reflect.Value) any {
+	switch v.Kind() {
+	case reflect.Invalid:
+		return nil
+	case reflect.Pointer, reflect.Interface:
+		if v.IsNil() {
+			return nil
+		}
+		if m, ok := v.Interface().(proto.Message); ok {
+			return r.Mask(m)
+		}
+		return r.maskValue(v.Elem())
+	case reflect.String:
+		return r.String(v.String())
+	case reflect.Slice, reflect.Array:
+		if v.Kind() == reflect.Slice && v.Type().Elem().Kind() == reflect.Uint8 {
+			return fmt.Sprintf("<%d bytes>", v.Len())
+		}
+		out := make([]any, v.Len())
+		for i := range out {
+			out[i] = r.maskValue(v.Index(i))
+		}
+		return out
+	case reflect.Map:
+		out := make(map[string]any, v.Len())
+		for it := v.MapRange(); it.Next(); {
+			k := fmt.Sprint(it.Key().Interface())
+			if r.IsRedactedKey(k) {
+				out[k] = Redacted
+			} else {
+				out[k] = r.maskValue(it.Value())
+			}
+		}
+		return out
+	case reflect.Struct:
+		return r.maskStruct(v)
+	}
+	return v.Interface()
+}
+
+func (r *Redactor) maskStruct(v reflect.Value) any {
+	t := v.Type()
+	out := make(map[string]any, t.NumField())
+	for i := range t.NumField() {
+		sf := t.Field(i)
+		if !sf.IsExported() {
+			continue
+		}
+		name := sf.Name
+		if tag, _, _ := strings.Cut(sf.Tag.Get("json"), ","); tag == "-" {
+			continue
+		} else if tag != "" {
+			name = tag
+		}
+		f := v.Field(i)
+		mode, tagged := sf.Tag.Lookup("redact")
+		switch {
+		case mode == "-":
+		case tagged:
+			out[name] = maskField(mode, fmt.Sprint(f.Interface()))
+		case r.IsRedactedKey(sf.Name) || r.IsRedactedKey(name):
+			out[name] = Redacted
+		default:
+			out[name] = r.maskValue(f)
+		}
+	}
+	return out
+}
+
+// maskField applies a redact tag mode to the formatted value s.
+func maskField(mode, s string) string {
+	switch mode {
+	case "last4":
+		if len(s) <= 4 {
+			return Redacted
+		}
+		return Redacted + s[len(s)-4:]
+	case "hash":
+		sum := sha256.Sum256([]byte(s))
+		return "sha256:" + hex.EncodeToString(sum[:6])
+	}
+	return Redacted
+}
+
+// Masked defers DefaultRedactor().Mask(v) to when a log entry is written:
+//
+//	log.Info("creating order", "request", shared.Masked(req))
+func Masked(v any) slog.LogValuer { return masked{v} }
+
+type masked struct{ v any }
+
+func (m masked) LogValue() slog.Value {
+	return slog.AnyValue(defaultRedactor.Mask(m.v))
+}
+
+func digitsOf(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= '0' && r <= '9' {
+			return r
+		}
+		return -1
+	}, s)
+}
+
+// luhn reports whether digits passes the Luhn checksum card numbers carry.
+func luhn(digits string) bool {
+	sum := 0
+	double := false
+	for i := len(digits) - 1; i >= 0; i-- {
+		d := int(digits[i] - '0')
+		if double {
+			d *= 2
+			if d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+		double = !double
+	}
+	return sum%10 == 0
+}
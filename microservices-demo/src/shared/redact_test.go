@@ -0,0 +1,123 @@
+package shared
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestRedact(t *testing.T) {
+	for in, want := range map[string]string{
+		"card 4432 8015 6152 0454 declined":    "card " + Redacted + "0454 declined",
+		"order 1234567890123 shipped":          "order 1234567890123 shipped",
+		"mail a.b+c@shop.example.org now":      "mail " + Redacted + " now",
+		"call (415) 555-0100 or +14155550100":  "call " + Redacted + " or " + Redacted,
+		"ring +44 20 7946 0958 after 6":        "ring " + Redacted + " after 6",
+		"tracking 1Z999AA10123456784 attached": "tracking 1Z999AA10123456784 attached",
+	} {
+		if got := Redact(in); got != want {
+			t.Errorf("Redact(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestRedactorMatchersAndKeys(t *testing.T) {
+	r := NewRedactor(WithMatchers(EmailMatcher), WithRedactedKeys("ssn"))
+	if got := r.String("4432 8015 6152 0454 jane@example.com"); got != "4432 8015 6152 0454 "+Redacted {
+		t.Errorf("String = %q", got)
+	}
+	if !r.IsRedactedKey("SSN") || r.IsRedactedKey("email") {
+		t.Error("WithRedactedKeys did not replace the default keys")
+	}
+	if r.WithKeys("api-key"); r.IsRedactedKey("api_key") {
+		t.Error("WithKeys modified the receiver")
+	}
+	if !r.WithKeys("api-key").IsRedactedKey("apiKey") {
+		t.Error("WithKeys key not matched in camel case")
+	}
+}
+
+func TestRedactorPayload(t *testing.T) {
+	r := DefaultRedactor()
+	got := r.Payload("application/json; charset=utf-8",
+		[]byte(`{"email":"jane@example.com","creditCard":{"creditCardNumber":4432801561520454,"creditCardCvv":672},"note":"call (415) 555-0100","items":[{"quantity":2}]}`))
+	var doc map[string]any
+	if err := json.Unmarshal([]byte(got), &doc); err != nil {
+		t.Fatalf("Payload returned invalid JSON %q: %v", got, err)
+	}
+	for _, leak := range []string{"jane", "4432", "672", "555-0100"} {
+		if strings.Contains(got, leak) {
+			t.Errorf("JSON payload leaks %q: %s", leak, got)
+		}
+	}
+	if !strings.Contains(got, `"quantity":2`) {
+		t.Errorf("JSON payload lost fields: %s", got)
+	}
+
+	form, _ := url.ParseQuery(r.Payload("application/x-www-form-urlencoded", []byte("email=jane%40example.com&street_address=1600+Amphitheatre&zip=94043")))
+	if form.Get("email") != Redacted || form.Get("street_address") != Redacted || form.Get("zip") != "94043" {
+		t.Errorf("form payload = %v", form)
+	}
+	if got := r.Payload("text/plain", []byte("from jane@example.com")); got != "from "+Redacted {
+		t.Errorf("text payload = %q", got)
+	}
+	if got := r.Payload("application/json", []byte(`{"email": "jane@example.com"`)); strings.Contains(got, "jane") {
+		t.Errorf("truncated JSON payload leaks: %q", got)
+	}
+}
+
+type maskedAddress struct {
+	StreetAddress string `json:"street_address"`
+	City          string `json:"city"`
+}
+
+type maskedCustomer struct {
+	ID       string          `json:"id" redact:"hash"`
+	Email    string          `json:"email"`
+	Phone    string          `json:"phone" redact:"last4"`
+	Password string          `json:"-"`
+	Token    string          `redact:"-"`
+	Note     string          `json:"note"`
+	Address  *maskedAddress  `json:"address"`
+	Previous []maskedAddress `json:"previous"`
+	Tags     map[string]string
+}
+
+func TestMasked(t *testing.T) {
+	var buf bytes.Buffer
+	log := slog.New(slog.NewJSONHandler(&buf, nil))
+	c := maskedCustomer{
+		ID: "user-1", Email: "jane@example.com", Phone: "+14155550100", Password: "hunter2", Token: "t0k3n",
+		Note:     "prefers jane.doe@example.org",
+		Address:  &maskedAddress{StreetAddress: "1600 Amphitheatre Parkway", City: "Mountain View"},
+		Previous: []maskedAddress{{StreetAddress: "345 Spear St", City: "San Francisco"}},
+		Tags:     map[string]string{"email": "x@example.com", "tier": "gold"},
+	}
+	log.Info("order placed", "customer", Masked(c))
+
+	var entry struct{ Customer map[string]any }
+	if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+		t.Fatalf("decoding %q: %v", buf.String(), err)
+	}
+	for _, leak := range []string{"user-1", "jane", "hunter2", "t0k3n", "1600", "345 Spear", "x@example.com"} {
+		if strings.Contains(buf.String(), leak) {
+			t.Errorf("log entry leaks %q: %s", leak, buf.String())
+		}
+	}
+	got := entry.Customer
+	if !strings.HasPrefix(got["id"].(string), "sha256:") || got["phone"] != Redacted+"0100" || got["email"] != Redacted {
+		t.Errorf("tagged fields = %v", got)
+	}
+	if got["address"].(map[string]any)["city"] != "Mountain View" || got["Tags"].(map[string]any)["tier"] != "gold" {
+		t.Errorf("untagged fields = %v", got)
+	}
+	if _, ok := got["Token"]; ok {
+		t.Error(`redact:"-" field was logged`)
+	}
+	if c.Email != "jane@example.com" {
+		t.Error("Mask modified its argument")
+	}
+}
@@ -0,0 +1,63 @@
+package redis
+
+import (
+	"context"
+	"time"
+
+	goredis "github.com/redis/go-redis/v9"
+
+	shared "github.com/GoogleCloudPlatform/microservices-demo/src/shared"
+)
+
+// redisAcquireScript renews the key if ARGV[1] holds it and otherwise takes
+// it only if it is free.
+var redisAcquireScript = goredis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("PEXPIRE", KEYS[1], ARGV[2])
+end
+if redis.call("SET", KEYS[1], ARGV[1], "NX", "PX", ARGV[2]) then
+	return 1
+end
+return 0
+`)
+
+// redisReleaseScript deletes the key only if ARGV[1] holds it.
+var redisReleaseScript = goredis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0
+`)
+
+type leaderLock struct {
+	client goredis.UniversalClient
+	key    string
+}
+
+// NewLeaderLock returns a shared.LeaderLock held as the key
+// "leader:<name>", whose value is the holder's identity and whose expiry is
+// the lease. It suits single-node or replicated Redis; a failover that loses
+// the latest write can briefly allow two leaders.
+func NewLeaderLock(client goredis.UniversalClient, name string) shared.LeaderLock {
+	return &leaderLock{client: client, key: "leader:" + name}
+}
+
+// LeaderBackend registers client as the "redis" backend of a
+// shared.LeaderElector, which "auto" uses outside Kubernetes.
+func LeaderBackend(client goredis.UniversalClient) shared.LeaderOption {
+	return shared.WithLeaderBackend("redis", func(cfg shared.LeaderElectionConfig) (shared.LeaderLock, error) {
+		return NewLeaderLock(client, cfg.Name), nil
+	})
+}
+
+func (l *leaderLock) TryAcquire(ctx context.Context, identity string, ttl time.Duration) (bool, error) {
+	n, err := redisAcquireScript.Run(ctx, l.client, []string{l.key}, identity, ttl.Milliseconds()).Int()
+	if err != nil {
+		return false, err
+	}
+	return n == 1, nil
+}
+
+func (l *leaderLock) Release(ctx context.Context, identity string) error {
+	return redisReleaseScript.Run(ctx, l.client, []string{l.key}, identity).Err()
+}
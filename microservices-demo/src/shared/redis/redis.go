@@ -0,0 +1,193 @@
+// Package redis builds go-redis clients configured from the REDIS_*
+// environment variables, with OpenTelemetry tracing and Prometheus command
+// metrics, so services that cache in Redis share one setup, and keeps
+// go-redis out of services that do not.
+package redis
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/redis/go-redis/extra/redisotel/v9"
+	goredis "github.com/redis/go-redis/v9"
+
+	shared "github.com/GoogleCloudPlatform/microservices-demo/src/shared"
+	"github.com/GoogleCloudPlatform/microservices-demo/src/shared/health"
+	"github.com/GoogleCloudPlatform/microservices-demo/src/shared/metrics"
+)
+
+var (
+	commandsTotal = metrics.NewCounterVec("redis_commands_total",
+		"Redis commands sent, by command and result (ok, nil, error).", "command", "result")
+	commandSeconds = metrics.NewHistogramVec("redis_command_seconds",
+		"Redis command latency in seconds, by command.", nil, "command")
+)
+
+// Config holds Redis connection settings, loaded from the environment by
+// ConfigFromEnv.
+type Config struct {
+	Addr     string `env:"REDIS_ADDR" default:"localhost:6379"`
+	Username string `env:"REDIS_USERNAME"`
+	Password string `env:"REDIS_PASSWORD"`
+	DB       int    `env:"REDIS_DB"`
+	// TLS enables TLS using the system roots.
+	TLS bool `env:"REDIS_TLS"`
+
+	PoolSize     int           `env:"REDIS_POOL_SIZE" default:"20"`
+	MinIdleConns int           `env:"REDIS_MIN_IDLE_CONNS" default:"2"`
+	PoolTimeout  time.Duration `env:"REDIS_POOL_TIMEOUT" default:"4s"`
+	DialTimeout  time.Duration `env:"REDIS_DIAL_TIMEOUT" default:"5s"`
+	ReadTimeout  time.Duration `env:"REDIS_READ_TIMEOUT" default:"3s"`
+	WriteTimeout time.Duration `env:"REDIS_WRITE_TIMEOUT" default:"3s"`
+	MaxRetries   int           `env:"REDIS_MAX_RETRIES" default:"3"`
+}
+
+// ConfigFromEnv loads a Config with shared.LoadConfig.
+func ConfigFromEnv() (Config, error) {
+	var cfg Config
+	err := shared.LoadConfig(&cfg)
+	return cfg, err
+}
+
+type options struct {
+	cfg       *Config
+	tls       *tls.Config
+	noTracing bool
+	ping      bool
+}
+
+// Option configures NewClient.
+type Option func(*options)
+
+// WithConfig uses cfg instead of reading the environment.
+func WithConfig(cfg Config) Option {
+	return func(o *options) { o.cfg = &cfg }
+}
+
+// WithTLS connects with c, for example one from shared.LoadClientTLS for
+// mTLS.
+func WithTLS(c *tls.Config) Option {
+	return func(o *options) { o.tls = c }
+}
+
+// WithoutTracing disables OpenTelemetry spans for commands.
+func WithoutTracing() Option {
+	return func(o *options) { o.noTracing = true }
+}
+
+// WithPing makes NewClient fail unless the server answers a PING
+// within the dial timeout.
+func WithPing() Option {
+	return func(o *options) { o.ping = true }
+}
+
+// NewClient returns a Redis client configured from the REDIS_*
+// environment variables (see Config), with OpenTelemetry tracing and
+// Prometheus command metrics attached:
+//
+//	rdb, err := redis.NewClient(redis.WithPing())
+//	if err != nil {
+//	    log.Fatal(err)
+//	}
+//	sm.Register("redis", func(context.Context) error { return rdb.Close() })
+//	registry.Register("redis", redis.HealthCheck(rdb))
+func NewClient(opts ...Option) (*goredis.Client, error) {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.cfg == nil {
+		cfg, err := ConfigFromEnv()
+		if err != nil {
+			return nil, err
+		}
+		o.cfg = &cfg
+	}
+	cfg := o.cfg
+	if o.tls == nil && cfg.TLS {
+		host, _, _ := net.SplitHostPort(cfg.Addr)
+		o.tls = &tls.Config{ServerName: host, MinVersion: tls.VersionTLS12}
+	}
+
+	client := goredis.NewClient(&goredis.Options{
+		Addr:         cfg.Addr,
+		Username:     cfg.Username,
+		Password:     cfg.Password,
+		DB:           cfg.DB,
+		TLSConfig:    o.tls,
+		PoolSize:     cfg.PoolSize,
+		MinIdleConns: cfg.MinIdleConns,
+		PoolTimeout:  cfg.PoolTimeout,
+		DialTimeout:  cfg.DialTimeout,
+		ReadTimeout:  cfg.ReadTimeout,
+		WriteTimeout: cfg.WriteTimeout,
+		MaxRetries:   cfg.MaxRetries,
+	})
+	client.AddHook(metricsHook{})
+	if !o.noTracing {
+		if err := redisotel.InstrumentTracing(client); err != nil {
+			client.Close()
+			return nil, fmt.Errorf("redis: instrumenting tracing: %w", err)
+		}
+	}
+
+	if o.ping {
+		ctx, cancel := context.WithTimeout(context.Background(), cfg.DialTimeout)
+		defer cancel()
+		if err := client.Ping(ctx).Err(); err != nil {
+			client.Close()
+			return nil, fmt.Errorf("redis: ping %s: %w", cfg.Addr, err)
+		}
+	}
+	return client, nil
+}
+
+// HealthCheck returns a checker that PINGs the server.
+func HealthCheck(c goredis.UniversalClient) health.Checker {
+	return func(ctx context.Context) error {
+		return c.Ping(ctx).Err()
+	}
+}
+
+// metricsHook records redis_commands_total and redis_command_seconds.
+type metricsHook struct{}
+
+func (metricsHook) DialHook(next goredis.DialHook) goredis.DialHook { return next }
+
+func (metricsHook) ProcessHook(next goredis.ProcessHook) goredis.ProcessHook {
+	return func(ctx context.Context, cmd goredis.Cmder) error {
+		start := time.Now()
+		err := next(ctx, cmd)
+		observeCommand(ctx, cmd, time.Since(start))
+		return err
+	}
+}
+
+func (metricsHook) ProcessPipelineHook(next goredis.ProcessPipelineHook) goredis.ProcessPipelineHook {
+	return func(ctx context.Context, cmds []goredis.Cmder) error {
+		start := time.Now()
+		err := next(ctx, cmds)
+		elapsed := time.Since(start)
+		for _, cmd := range cmds {
+			observeCommand(ctx, cmd, elapsed)
+		}
+		return err
+	}
+}
+
+func observeCommand(ctx context.Context, cmd goredis.Cmder, elapsed time.Duration) {
+	name := cmd.Name()
+	result := "ok"
+	switch err := cmd.Err(); {
+	case errors.Is(err, goredis.Nil):
+		result = "nil"
+	case err != nil:
+		result = "error"
+	}
+	commandsTotal.WithLabelValues(name, result).Inc()
+	metrics.Observe(ctx, commandSeconds.WithLabelValues(name), elapsed.Seconds())
+}
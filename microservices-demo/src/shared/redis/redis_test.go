@@ -0,0 +1,113 @@
+package redis
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeRedis answers PING with PONG, GET with a nil reply and everything else
+// with an error, which is enough for go-redis to connect over RESP2.
+func fakeRedis(t *testing.T) string {
+	t.Helper()
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { lis.Close() })
+	go func() {
+		for {
+			conn, err := lis.Accept()
+			if err != nil {
+				return
+			}
+			go serveFakeRedis(conn)
+		}
+	}()
+	return lis.Addr().String()
+}
+
+func serveFakeRedis(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	for {
+		args, err := readRESPArray(r)
+		if err != nil {
+			return
+		}
+		switch strings.ToUpper(args[0]) {
+		case "PING":
+			fmt.Fprint(conn, "+PONG\r\n")
+		case "GET":
+			fmt.Fprint(conn, "$-1\r\n")
+		default:
+			fmt.Fprint(conn, "-ERR unknown command\r\n")
+		}
+	}
+}
+
+func readRESPArray(r *bufio.Reader) ([]string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(line[1:]))
+	if err != nil || n < 1 {
+		return nil, fmt.Errorf("bad array header %q", line)
+	}
+	args := make([]string, n)
+	for i := range args {
+		if _, err := r.ReadString('\n'); err != nil { // $len
+			return nil, err
+		}
+		arg, err := r.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		args[i] = strings.TrimSuffix(arg, "\r\n")
+	}
+	return args, nil
+}
+
+func TestRedisConfigFromEnv(t *testing.T) {
+	t.Setenv("REDIS_ADDR", "redis-cart:6379")
+	t.Setenv("REDIS_POOL_SIZE", "50")
+	cfg, err := ConfigFromEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Addr != "redis-cart:6379" || cfg.PoolSize != 50 || cfg.ReadTimeout != 3*time.Second {
+		t.Errorf("cfg = %+v", cfg)
+	}
+}
+
+func TestNewRedisClient(t *testing.T) {
+	addr := fakeRedis(t)
+	rdb, err := NewClient(WithConfig(Config{Addr: addr, DialTimeout: time.Second, ReadTimeout: time.Second}), WithPing())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rdb.Close()
+
+	if err := HealthCheck(rdb)(context.Background()); err != nil {
+		t.Errorf("health check: %v", err)
+	}
+	if err := rdb.Get(context.Background(), "missing").Err(); err == nil || err.Error() != "redis: nil" {
+		t.Errorf("Get(missing) = %v, want redis: nil", err)
+	}
+}
+
+func TestNewRedisClientPingFails(t *testing.T) {
+	lis, _ := net.Listen("tcp", "127.0.0.1:0")
+	addr := lis.Addr().String()
+	lis.Close()
+	_, err := NewClient(WithConfig(Config{Addr: addr, DialTimeout: 200 * time.Millisecond}), WithPing())
+	if err == nil {
+		t.Fatal("NewClient succeeded against a closed port")
+	}
+}
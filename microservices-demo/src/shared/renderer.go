@@ -0,0 +1,264 @@
+package shared
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+// EnvTemplateDevDir names a directory to read templates from instead of the
+// embedded ones, re-parsing them whenever a file changes, so template edits
+// show up on the next page load without a rebuild:
+//
+//	TEMPLATE_DEV_DIR=./templates go run .
+const EnvTemplateDevDir = "TEMPLATE_DEV_DIR"
+
+// templateCheckInterval is how often, at most, a dev-mode Renderer looks
+// for changed templates.
+const templateCheckInterval = 500 * time.Millisecond
+
+// Translator translates messages for one locale. *i18n.Localizer
+// implements it.
+type Translator interface {
+	T(key string, args ...any) string
+	Plural(key string, n int, args ...any) string
+}
+
+// RendererOption configures NewRenderer.
+type RendererOption func(*rendererConfig)
+
+type rendererConfig struct {
+	patterns   []string
+	funcs      template.FuncMap
+	assets     *Assets
+	localeOf   func(*http.Request) string
+	translator func(locale string) Translator
+	devDir     string
+}
+
+// WithTemplatePatterns sets the glob patterns, relative to the FS root, of
+// the template files to parse. The default is "*.html".
+func WithTemplatePatterns(patterns ...string) RendererOption {
+	return func(c *rendererConfig) { c.patterns = patterns }
+}
+
+// WithTemplateFuncs adds functions to the templates, overriding built-in
+// ones of the same name.
+func WithTemplateFuncs(funcs template.FuncMap) RendererOption {
+	return func(c *rendererConfig) {
+		for name, fn := range funcs {
+			c.funcs[name] = fn
+		}
+	}
+}
+
+// WithRendererAssets makes the "asset" function return fingerprinted URLs
+// from assets.
+func WithRendererAssets(assets *Assets) RendererOption {
+	return func(c *rendererConfig) { c.assets = assets }
+}
+
+// WithTranslations renders each request in the locale localeOf returns
+// for it, translating with translator(locale). For example, with an
+// i18n.Bundle installed as middleware:
+//
+//	shared.WithTranslations(
+//	    func(r *http.Request) string { return i18n.LocaleFromContext(r.Context()) },
+//	    func(locale string) shared.Translator { return bundle.For(locale) })
+//
+// localeOf should only return supported locales, as negotiated by the
+// bundle's middleware, since each one gets its own parsed template set.
+func WithTranslations(localeOf func(*http.Request) string, translator func(locale string) Translator) RendererOption {
+	return func(c *rendererConfig) { c.localeOf, c.translator = localeOf, translator }
+}
+
+// WithTemplateDevDir overrides EnvTemplateDevDir.
+func WithTemplateDevDir(dir string) RendererOption {
+	return func(c *rendererConfig) { c.devDir = dir }
+}
+
+// Renderer executes html/template sets. It is safe for concurrent use.
+//
+// In production the templates are parsed once from the FS given to
+// NewRenderer, usually an embed.FS. In dev mode, enabled by
+// TEMPLATE_DEV_DIR, they are read from that directory instead and
+// re-parsed when any of them changes.
+//
+// Besides the functions added with WithTemplateFuncs, templates can call:
+//
+//	{{ money .Total }}                 a Money formatted for the locale
+//	{{ asset "styles/cart.css" }}      the fingerprinted URL of an asset
+//	{{ t "cart.title" }}               a translated message
+//	{{ plural "cart.items" .N "count" .N }}
+//	{{ locale }}                       the locale, e.g. for <html lang>
+//
+// Since the translation functions are bound when a set is parsed, a
+// Renderer keeps one parsed set per locale.
+type Renderer struct {
+	cfg  rendererConfig
+	fsys fs.FS
+	dev  bool
+
+	mu        sync.Mutex
+	sets      map[string]*template.Template
+	stamp     string
+	lastCheck time.Time
+}
+
+// NewRenderer parses the templates of fsys and returns a Renderer for them.
+// Parse errors are returned right away, so a bad template fails startup
+// rather than the first request.
+//
+// Usage:
+//
+//	//go:embed templates
+//	var templates embed.FS
+//
+//	sub, _ := fs.Sub(templates, "templates")
+//	renderer, err := shared.NewRenderer(sub, shared.WithRendererAssets(assets))
+//	...
+//	if err := renderer.Render(w, r, "cart", data); err != nil { ... }
+func NewRenderer(fsys fs.FS, opts ...RendererOption) (*Renderer, error) {
+	cfg := rendererConfig{patterns: []string{"*.html"}, funcs: template.FuncMap{}, devDir: os.Getenv(EnvTemplateDevDir)}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	r := &Renderer{cfg: cfg, fsys: fsys, sets: make(map[string]*template.Template)}
+	if cfg.devDir != "" {
+		r.fsys, r.dev = os.DirFS(cfg.devDir), true
+		log.Printf("Renderer: dev mode, reloading templates from %s", cfg.devDir)
+		stamp, err := r.stampFiles()
+		if err != nil {
+			return nil, fmt.Errorf("renderer: %w", err)
+		}
+		r.stamp = stamp
+	}
+	if _, err := r.set(""); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Render executes the template name with data in the locale of req and
+// writes it to w as HTML. The page is rendered to a buffer first, so a
+// failing template leaves w untouched for the caller to report the error.
+func (r *Renderer) Render(w http.ResponseWriter, req *http.Request, name string, data any) error {
+	locale := ""
+	if r.cfg.localeOf != nil {
+		locale = r.cfg.localeOf(req)
+	}
+	t, err := r.set(locale)
+	if err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	if err := t.ExecuteTemplate(&buf, name, data); err != nil {
+		return fmt.Errorf("renderer: %w", err)
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	_, err = buf.WriteTo(w)
+	return err
+}
+
+// set returns the template set of locale, parsing it if needed.
+func (r *Renderer) set(locale string) (*template.Template, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.dev {
+		r.checkChanges()
+	}
+	if t, ok := r.sets[locale]; ok {
+		return t, nil
+	}
+	t, err := template.New("").Funcs(r.funcs(locale)).ParseFS(r.fsys, r.cfg.patterns...)
+	if err != nil {
+		return nil, fmt.Errorf("renderer: %w", err)
+	}
+	r.sets[locale] = t
+	return t, nil
+}
+
+// checkChanges drops the parsed sets if a template changed since they were
+// parsed. r.mu must be held.
+func (r *Renderer) checkChanges() {
+	now := time.Now()
+	if now.Sub(r.lastCheck) < templateCheckInterval {
+		return
+	}
+	r.lastCheck = now
+	stamp, err := r.stampFiles()
+	if err != nil {
+		log.Printf("Renderer: checking templates: %v", err)
+		return
+	}
+	if stamp != r.stamp {
+		if r.stamp != "" {
+			log.Printf("Renderer: templates changed, reloading")
+		}
+		r.stamp = stamp
+		clear(r.sets)
+	}
+}
+
+// stampFiles summarizes the names, sizes and modification times of the
+// template files.
+func (r *Renderer) stampFiles() (string, error) {
+	var b strings.Builder
+	err := fs.WalkDir(r.fsys, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || !r.matches(name) {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(&b, "%s:%d:%d;", name, info.Size(), info.ModTime().UnixNano())
+		return nil
+	})
+	return b.String(), err
+}
+
+func (r *Renderer) matches(name string) bool {
+	for _, p := range r.cfg.patterns {
+		if ok, _ := path.Match(p, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// funcs returns the template functions for locale.
+func (r *Renderer) funcs(locale string) template.FuncMap {
+	moneyLocale := locale
+	if moneyLocale == "" {
+		moneyLocale = "en"
+	}
+	funcs := template.FuncMap{
+		"money":  func(m Money) string { return m.Format(moneyLocale) },
+		"locale": func() string { return moneyLocale },
+		"asset": func(name string) string {
+			if r.cfg.assets == nil {
+				return name
+			}
+			return r.cfg.assets.Path(name)
+		},
+		"t":      func(key string, args ...any) string { return key },
+		"plural": func(key string, _ int, args ...any) string { return key },
+	}
+	if r.cfg.translator != nil {
+		tr := r.cfg.translator(locale)
+		funcs["t"], funcs["plural"] = tr.T, tr.Plural
+	}
+	for name, fn := range r.cfg.funcs {
+		funcs[name] = fn
+	}
+	return funcs
+}
@@ -0,0 +1,100 @@
+package shared
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+type fakeTranslator string
+
+func (f fakeTranslator) T(key string, args ...any) string { return string(f) + ":" + key }
+func (f fakeTranslator) Plural(key string, n int, args ...any) string {
+	return string(f) + ":" + key + ":" + map[bool]string{true: "one", false: "other"}[n == 1]
+}
+
+func TestRenderer(t *testing.T) {
+	t.Setenv(EnvTemplateDevDir, "")
+	assets, err := AssetHandler(fstest.MapFS{"app.css": {Data: []byte("body{}")}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := NewRenderer(fstest.MapFS{
+		"page.html":   {Data: []byte(`{{ define "page" }}<html lang="{{ locale }}">{{ template "header" . }}{{ money .Total }}|{{ t "title" }}|{{ plural "items" .N }}|{{ shout "hi" }}</html>{{ end }}`)},
+		"header.html": {Data: []byte(`{{ define "header" }}<link href="{{ asset "app.css" }}">{{ end }}`)},
+	}, WithRendererAssets(assets),
+		WithTemplateFuncs(map[string]any{"shout": strings.ToUpper}),
+		WithTranslations(func(r *http.Request) string { return r.URL.Query().Get("lang") },
+			func(locale string) Translator { return fakeTranslator(locale) }))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data := map[string]any{"Total": Money{Currency: "EUR", Units: 1234, Nanos: 500_000_000}, "N": 1}
+	rec := httptest.NewRecorder()
+	if err := r.Render(rec, httptest.NewRequest(http.MethodGet, "/?lang=de-DE", nil), "page", data); err != nil {
+		t.Fatal(err)
+	}
+	want := `<html lang="de-DE"><link href="` + assets.Path("app.css") + "\">1.234,50\u00a0€|de-DE:title|de-DE:items:one|HI</html>"
+	if rec.Body.String() != want || rec.Header().Get("Content-Type") != "text/html; charset=utf-8" {
+		t.Errorf("rendered %q (%s), want %q", rec.Body.String(), rec.Header().Get("Content-Type"), want)
+	}
+
+	rec = httptest.NewRecorder()
+	r.Render(rec, httptest.NewRequest(http.MethodGet, "/", nil), "page", data)
+	if !strings.Contains(rec.Body.String(), `lang="en">`) || !strings.Contains(rec.Body.String(), "€1,234.50|:title") {
+		t.Errorf("default locale rendered %q", rec.Body.String())
+	}
+
+	rec = httptest.NewRecorder()
+	if err := r.Render(rec, httptest.NewRequest(http.MethodGet, "/", nil), "missing", nil); err == nil || rec.Body.Len() != 0 {
+		t.Errorf("missing template: err %v, body %q", err, rec.Body.String())
+	}
+}
+
+func TestRendererRejectsBadTemplates(t *testing.T) {
+	t.Setenv(EnvTemplateDevDir, "")
+	if _, err := NewRenderer(fstest.MapFS{"bad.html": {Data: []byte(`{{ define "x" }}{{ nosuchfunc }}{{ end }}`)}}); err == nil {
+		t.Error("NewRenderer accepted a template calling an undefined function")
+	}
+}
+
+func TestRendererDevModeReloads(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "page.html")
+	if err := os.WriteFile(file, []byte(`{{ define "page" }}v1{{ end }}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv(EnvTemplateDevDir, dir)
+	// The embedded FS is ignored in dev mode.
+	r, err := NewRenderer(fstest.MapFS{"page.html": {Data: []byte(`{{ define "page" }}embedded{{ end }}`)}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	render := func() string {
+		rec := httptest.NewRecorder()
+		if err := r.Render(rec, httptest.NewRequest(http.MethodGet, "/", nil), "page", nil); err != nil {
+			t.Fatal(err)
+		}
+		return rec.Body.String()
+	}
+	if got := render(); got != "v1" {
+		t.Fatalf("rendered %q, want v1 from disk", got)
+	}
+
+	if err := os.WriteFile(file, []byte(`{{ define "page" }}v2, edited{{ end }}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	os.Chtimes(file, time.Now(), time.Now().Add(time.Second))
+	r.mu.Lock()
+	r.lastCheck = time.Time{}
+	r.mu.Unlock()
+	if got := render(); got != "v2, edited" {
+		t.Errorf("rendered %q after edit, want v2", got)
+	}
+}
@@ -0,0 +1,59 @@
+package requestid
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+func TestMiddleware(t *testing.T) {
+	var got string
+	h := Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = FromContext(r.Context())
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set(Header, "abc-123")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if got != "abc-123" || rec.Header().Get(Header) != "abc-123" {
+		t.Errorf("propagated id = %q, response header = %q, want abc-123", got, rec.Header().Get(Header))
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set(Header, strings.Repeat("x", maxLen+1))
+	h.ServeHTTP(httptest.NewRecorder(), req)
+	if len(got) != 32 {
+		t.Errorf("oversized id not replaced, got %q", got)
+	}
+}
+
+func TestUnaryServerInterceptor(t *testing.T) {
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(MetadataKey, "from-caller"))
+	var got string
+	UnaryServerInterceptor()(ctx, nil, &grpc.UnaryServerInfo{}, func(ctx context.Context, req any) (any, error) {
+		got = FromContext(ctx)
+		return nil, nil
+	})
+	if got != "from-caller" {
+		t.Errorf("FromContext = %q, want from-caller", got)
+	}
+}
+
+func TestUnaryClientInterceptor(t *testing.T) {
+	ctx := NewContext(context.Background(), "req-1")
+	var got []string
+	UnaryClientInterceptor()(ctx, "/svc/M", nil, nil, nil, func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+		md, _ := metadata.FromOutgoingContext(ctx)
+		got = md.Get(MetadataKey)
+		return nil
+	})
+	if len(got) != 1 || got[0] != "req-1" {
+		t.Errorf("outgoing %s = %q, want [req-1]", MetadataKey, got)
+	}
+}
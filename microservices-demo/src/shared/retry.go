@@ -0,0 +1,154 @@
+package shared
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// RetryPolicy controls how Retry spaces and bounds attempts.
+type RetryPolicy struct {
+	// MaxAttempts is the total number of calls, including the first. Zero or
+	// less means retry until ctx is done.
+	MaxAttempts int
+	// InitialBackoff is the delay before the second attempt.
+	InitialBackoff time.Duration
+	// MaxBackoff caps the delay between attempts, jitter included. Zero
+	// means no cap.
+	MaxBackoff time.Duration
+	// Multiplier grows the delay after each attempt; values below 1 are
+	// treated as 1.
+	Multiplier float64
+	// Jitter randomizes each delay by up to this fraction in either
+	// direction, e.g. 0.2 turns 1s into 800ms–1.2s, so clients that failed
+	// together do not retry together.
+	Jitter float64
+	// Retryable reports whether err is worth another attempt. Nil uses
+	// IsRetryable.
+	Retryable func(error) bool
+	// OnRetry, if set, is called before sleeping ahead of each retry.
+	OnRetry func(attempt int, delay time.Duration, err error)
+	// Clock times the waits between attempts. Nil uses RealClock.
+	Clock Clock
+}
+
+// DefaultRetryPolicy makes up to 4 attempts, waiting roughly 100ms, 200ms and
+// 400ms in between.
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts:    4,
+	InitialBackoff: 100 * time.Millisecond,
+	MaxBackoff:     5 * time.Second,
+	Multiplier:     2,
+	Jitter:         0.2,
+}
+
+// permanentError marks an error as not retryable.
+type permanentError struct{ err error }
+
+func (e permanentError) Error() string { return e.err.Error() }
+func (e permanentError) Unwrap() error { return e.err }
+
+// Permanent wraps err so Retry returns it immediately regardless of the
+// policy's classifier.
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return permanentError{err}
+}
+
+// retryableCodes are the gRPC codes that indicate a transient failure.
+var retryableCodes = map[codes.Code]bool{
+	codes.Unavailable:       true,
+	codes.ResourceExhausted: true,
+	codes.Aborted:           true,
+}
+
+// IsRetryable is the default classifier. Errors carrying a gRPC status are
+// retryable when the code is Unavailable, ResourceExhausted or Aborted;
+// context cancellation and expiry are not; any other error is.
+func IsRetryable(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	if s, ok := status.FromError(err); ok {
+		return retryableCodes[s.Code()]
+	}
+	return true
+}
+
+// RetryableCodes returns a classifier that retries only gRPC errors with one
+// of the given codes.
+func RetryableCodes(cs ...codes.Code) func(error) bool {
+	set := make(map[codes.Code]bool, len(cs))
+	for _, c := range cs {
+		set[c] = true
+	}
+	return func(err error) bool {
+		return set[status.Code(err)]
+	}
+}
+
+// Retry calls fn until it succeeds, returns a non-retryable error, runs out of
+// attempts, or ctx is done, sleeping with exponential backoff and jitter in
+// between. It returns nil on success and otherwise the last error from fn,
+// or ctx's error wrapping it if ctx ended the wait.
+//
+// Usage:
+//
+//	err := shared.Retry(ctx, shared.DefaultRetryPolicy, func(ctx context.Context) error {
+//	    _, err := client.GetQuote(ctx, req)
+//	    return err
+//	})
+func Retry(ctx context.Context, policy RetryPolicy, fn func(ctx context.Context) error) error {
+	retryable := policy.Retryable
+	if retryable == nil {
+		retryable = IsRetryable
+	}
+	clock := clockOrReal(policy.Clock)
+
+	for attempt := 1; ; attempt++ {
+		err := fn(ctx)
+		if err == nil {
+			return nil
+		}
+		var perm permanentError
+		if errors.As(err, &perm) {
+			return perm.err
+		}
+		if !retryable(err) || (policy.MaxAttempts > 0 && attempt >= policy.MaxAttempts) {
+			return err
+		}
+		if ctx.Err() != nil {
+			return fmt.Errorf("%w (last error: %w)", ctx.Err(), err)
+		}
+
+		delay := policy.backoff(attempt)
+		if policy.OnRetry != nil {
+			policy.OnRetry(attempt, delay, err)
+		}
+		if clock.Sleep(ctx, delay) != nil {
+			return fmt.Errorf("%w (last error: %w)", ctx.Err(), err)
+		}
+	}
+}
+
+// backoff returns the delay after the given (1-based) attempt.
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	mult := math.Max(p.Multiplier, 1)
+	d := float64(p.InitialBackoff) * math.Pow(mult, float64(attempt-1))
+	if p.Jitter > 0 {
+		d *= 1 + p.Jitter*(2*rand.Float64()-1)
+	}
+	// Clamp after jittering, so MaxBackoff holds for every delay.
+	if p.MaxBackoff > 0 && d > float64(p.MaxBackoff) {
+		d = float64(p.MaxBackoff)
+	}
+	return time.Duration(d)
+}
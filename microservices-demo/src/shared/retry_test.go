@@ -0,0 +1,110 @@
+package shared
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+var fastRetry = RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond, Multiplier: 2}
+
+func TestRetrySucceedsAfterTransientErrors(t *testing.T) {
+	calls := 0
+	err := Retry(context.Background(), fastRetry, func(context.Context) error {
+		calls++
+		if calls < 3 {
+			return status.Error(codes.Unavailable, "down")
+		}
+		return nil
+	})
+	if err != nil || calls != 3 {
+		t.Errorf("Retry = %v after %d calls, want nil after 3", err, calls)
+	}
+}
+
+func TestRetryStopsOnNonRetryable(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+	}{
+		{"grpc code", status.Error(codes.InvalidArgument, "bad")},
+		{"permanent", Permanent(errors.New("fatal"))},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls := 0
+			err := Retry(context.Background(), fastRetry, func(context.Context) error {
+				calls++
+				return tt.err
+			})
+			if calls != 1 || err == nil {
+				t.Errorf("Retry = %v after %d calls, want error after 1", err, calls)
+			}
+		})
+	}
+}
+
+func TestRetryExhaustsAttempts(t *testing.T) {
+	calls := 0
+	want := errors.New("flaky")
+	err := Retry(context.Background(), fastRetry, func(context.Context) error {
+		calls++
+		return want
+	})
+	if !errors.Is(err, want) || calls != 3 {
+		t.Errorf("Retry = %v after %d calls, want %v after 3", err, calls, want)
+	}
+}
+
+func TestRetryRespectsContext(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	err := Retry(ctx, RetryPolicy{InitialBackoff: time.Hour}, func(context.Context) error {
+		return errors.New("flaky")
+	})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Retry = %v, want DeadlineExceeded", err)
+	}
+}
+
+func TestRetryBackoff(t *testing.T) {
+	p := RetryPolicy{InitialBackoff: 100 * time.Millisecond, MaxBackoff: 300 * time.Millisecond, Multiplier: 2}
+	for attempt, want := range map[int]time.Duration{1: 100 * time.Millisecond, 2: 200 * time.Millisecond, 3: 300 * time.Millisecond, 10: 300 * time.Millisecond} {
+		if got := p.backoff(attempt); got != want {
+			t.Errorf("backoff(%d) = %v, want %v", attempt, got, want)
+		}
+	}
+
+	p.Jitter = 0.5
+	for i := 0; i < 100; i++ {
+		if d := p.backoff(1); d < 50*time.Millisecond || d > 150*time.Millisecond {
+			t.Fatalf("jittered backoff %v outside [50ms, 150ms]", d)
+		}
+		for _, attempt := range []int{2, 3, 10} {
+			if d := p.backoff(attempt); d > p.MaxBackoff {
+				t.Fatalf("jittered backoff(%d) = %v over MaxBackoff %v", attempt, d, p.MaxBackoff)
+			}
+		}
+	}
+}
+
+func TestIsRetryable(t *testing.T) {
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{errors.New("io"), true},
+		{status.Error(codes.Unavailable, ""), true},
+		{status.Error(codes.NotFound, ""), false},
+		{context.Canceled, false},
+	}
+	for _, tt := range tests {
+		if got := IsRetryable(tt.err); got != tt.want {
+			t.Errorf("IsRetryable(%v) = %v, want %v", tt.err, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,287 @@
+package shared
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand/v2"
+	"sync"
+	"time"
+
+	"github.com/GoogleCloudPlatform/microservices-demo/src/shared/metrics"
+)
+
+var (
+	schedulerRunsTotal = metrics.NewCounterVec("scheduler_runs_total",
+		"Scheduled job activations, by job and result (ok, error, panic, timeout, skipped).", "job", "result")
+	schedulerRunSeconds = metrics.NewHistogramVec("scheduler_run_seconds",
+		"Scheduled job run time in seconds.", nil, "job")
+	schedulerLastSuccess = metrics.NewGaugeVec("scheduler_last_success_timestamp_seconds",
+		"Unix time of each job's last successful run.", "job")
+)
+
+// DefaultSchedulerStopTimeout bounds Scheduler.RegisterShutdown's hook.
+const DefaultSchedulerStopTimeout = 30 * time.Second
+
+// Job is a unit of scheduled work. The context is cancelled when the run's
+// timeout expires or the scheduler stops.
+type Job func(ctx context.Context) error
+
+type jobConfig struct {
+	jitter     time.Duration
+	timeout    time.Duration
+	overlap    bool
+	runOnStart bool
+}
+
+// JobOption configures a scheduled job.
+type JobOption func(*jobConfig)
+
+// WithJitter delays each activation by a random duration in [0, d), so
+// replicas sharing a schedule do not all hit a dependency at once.
+func WithJitter(d time.Duration) JobOption {
+	return func(c *jobConfig) { c.jitter = d }
+}
+
+// WithJobTimeout cancels a run's context after d.
+func WithJobTimeout(d time.Duration) JobOption {
+	return func(c *jobConfig) { c.timeout = d }
+}
+
+// WithOverlap lets a new run start while the previous one is still going.
+// By default such activations are skipped.
+func WithOverlap() JobOption {
+	return func(c *jobConfig) { c.overlap = true }
+}
+
+// WithRunOnStart also runs the job as soon as the scheduler starts, e.g. to
+// warm a cache before the first scheduled refresh.
+func WithRunOnStart() JobOption {
+	return func(c *jobConfig) { c.runOnStart = true }
+}
+
+type schedulerConfig struct {
+	clock    Clock
+	location *time.Location
+}
+
+// SchedulerOption configures a Scheduler.
+type SchedulerOption func(*schedulerConfig)
+
+// WithSchedulerClock sets the clock driving activations, for tests.
+func WithSchedulerClock(c Clock) SchedulerOption {
+	return func(cfg *schedulerConfig) { cfg.clock = c }
+}
+
+// WithSchedulerLocation sets the time zone cron specs are evaluated in
+// (default time.Local).
+func WithSchedulerLocation(loc *time.Location) SchedulerOption {
+	return func(cfg *schedulerConfig) { cfg.location = loc }
+}
+
+type scheduledJob struct {
+	name     string
+	schedule Schedule
+	fn       Job
+	cfg      jobConfig
+
+	mu      sync.Mutex
+	running int
+}
+
+// Scheduler runs recurring jobs on cron or interval schedules. Each run is
+// recovered from panics, bounded by its timeout and recorded in metrics.
+//
+// Usage:
+//
+//	s := shared.NewScheduler()
+//	s.Every("refresh-model", 10*time.Minute, refreshModel,
+//	    shared.WithJitter(time.Minute), shared.WithJobTimeout(5*time.Minute), shared.WithRunOnStart())
+//	s.Cron("warm-cache", "0 */6 * * *", warmCache)
+//	s.Start()
+//	s.RegisterShutdown(sm)
+type Scheduler struct {
+	cfg schedulerConfig
+
+	mu      sync.Mutex
+	jobs    map[string]*scheduledJob
+	started bool
+	ctx     context.Context
+	cancel  context.CancelFunc
+	loops   sync.WaitGroup
+	runs    sync.WaitGroup
+}
+
+// NewScheduler returns a Scheduler with no jobs.
+func NewScheduler(opts ...SchedulerOption) *Scheduler {
+	var cfg schedulerConfig
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	cfg.clock = clockOrReal(cfg.clock)
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Scheduler{cfg: cfg, jobs: make(map[string]*scheduledJob), ctx: ctx, cancel: cancel}
+}
+
+// Every schedules fn to run every interval.
+func (s *Scheduler) Every(name string, interval time.Duration, fn Job, opts ...JobOption) error {
+	if interval <= 0 {
+		return fmt.Errorf("scheduler: job %q: interval must be positive", name)
+	}
+	return s.Schedule(name, Every(interval), fn, opts...)
+}
+
+// Cron schedules fn on a cron spec; see ParseCron for the syntax.
+func (s *Scheduler) Cron(name, spec string, fn Job, opts ...JobOption) error {
+	sched, err := ParseCron(spec, s.cfg.location)
+	if err != nil {
+		return fmt.Errorf("scheduler: job %q: %w", name, err)
+	}
+	return s.Schedule(name, sched, fn, opts...)
+}
+
+// Schedule runs fn at the activations of sched. Jobs added after Start
+// begin immediately.
+func (s *Scheduler) Schedule(name string, sched Schedule, fn Job, opts ...JobOption) error {
+	j := &scheduledJob{name: name, schedule: sched, fn: fn}
+	for _, opt := range opts {
+		opt(&j.cfg)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.jobs[name]; ok {
+		return fmt.Errorf("scheduler: job %q already registered", name)
+	}
+	if s.ctx.Err() != nil {
+		return errors.New("scheduler: stopped")
+	}
+	s.jobs[name] = j
+	if s.started {
+		s.startJob(j)
+	}
+	return nil
+}
+
+// Start begins running the registered jobs. Calling it again is a no-op.
+func (s *Scheduler) Start() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.started || s.ctx.Err() != nil {
+		return
+	}
+	s.started = true
+	for _, j := range s.jobs {
+		s.startJob(j)
+	}
+}
+
+// startJob launches j's loop. s.mu must be held.
+func (s *Scheduler) startJob(j *scheduledJob) {
+	s.loops.Add(1)
+	go s.loop(j)
+}
+
+func (s *Scheduler) loop(j *scheduledJob) {
+	defer s.loops.Done()
+	clock := s.cfg.clock
+	if j.cfg.runOnStart {
+		s.activate(j)
+	}
+	for {
+		now := clock.Now()
+		next := j.schedule.Next(now)
+		if next.IsZero() {
+			log.Printf("Scheduler: Job %q has no future activations", j.name)
+			return
+		}
+		wait := next.Sub(now)
+		if j.cfg.jitter > 0 {
+			wait += rand.N(j.cfg.jitter)
+		}
+		t := clock.NewTimer(wait)
+		select {
+		case <-t.C():
+			s.activate(j)
+		case <-s.ctx.Done():
+			t.Stop()
+			return
+		}
+	}
+}
+
+// activate starts a run of j unless one is going and overlap is off.
+func (s *Scheduler) activate(j *scheduledJob) {
+	j.mu.Lock()
+	if j.running > 0 && !j.cfg.overlap {
+		j.mu.Unlock()
+		schedulerRunsTotal.WithLabelValues(j.name, "skipped").Inc()
+		log.Printf("Scheduler: Skipping %q, previous run still in progress", j.name)
+		return
+	}
+	j.running++
+	j.mu.Unlock()
+
+	s.runs.Add(1)
+	go func() {
+		defer s.runs.Done()
+		defer func() {
+			j.mu.Lock()
+			j.running--
+			j.mu.Unlock()
+		}()
+		s.run(j)
+	}()
+}
+
+func (s *Scheduler) run(j *scheduledJob) {
+	ctx := s.ctx
+	if j.cfg.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, j.cfg.timeout)
+		defer cancel()
+	}
+	start := s.cfg.clock.Now()
+	result := "ok"
+	defer func() {
+		if r := recover(); r != nil {
+			result = "panic"
+			log.Printf("Scheduler: Job %q panicked: %v", j.name, r)
+		}
+		schedulerRunSeconds.WithLabelValues(j.name).Observe(s.cfg.clock.Now().Sub(start).Seconds())
+		schedulerRunsTotal.WithLabelValues(j.name, result).Inc()
+		if result == "ok" {
+			schedulerLastSuccess.WithLabelValues(j.name).Set(float64(s.cfg.clock.Now().Unix()))
+		}
+	}()
+	if err := j.fn(ctx); err != nil {
+		result = "error"
+		if errors.Is(err, context.DeadlineExceeded) && ctx.Err() != nil && s.ctx.Err() == nil {
+			result = "timeout"
+		}
+		log.Printf("Scheduler: Job %q failed: %v", j.name, err)
+	}
+}
+
+// Stop stops scheduling new runs, cancels the context of running ones and
+// waits for them to return or for ctx to end.
+func (s *Scheduler) Stop(ctx context.Context) error {
+	s.cancel()
+	done := make(chan struct{})
+	go func() {
+		s.loops.Wait()
+		s.runs.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("scheduler: jobs still running: %w", ctx.Err())
+	}
+}
+
+// RegisterShutdown registers Stop with m.
+func (s *Scheduler) RegisterShutdown(m *ShutdownManager) {
+	m.RegisterWithTimeout("scheduler", DefaultSchedulerStopTimeout, s.Stop)
+}
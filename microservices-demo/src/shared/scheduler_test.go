@@ -0,0 +1,121 @@
+package shared
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestSchedulerEvery(t *testing.T) {
+	clk := NewFakeClock(time.Unix(0, 0))
+	s := NewScheduler(WithSchedulerClock(clk))
+	runs := make(chan struct{}, 10)
+	if err := s.Every("tick", time.Minute, func(ctx context.Context) error {
+		runs <- struct{}{}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	s.Start()
+	defer s.Stop(context.Background())
+
+	for i := range 3 {
+		clk.BlockUntil(1)
+		clk.Advance(time.Minute)
+		select {
+		case <-runs:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("run %d did not happen", i+1)
+		}
+	}
+}
+
+func TestSchedulerSkipsOverlappingRuns(t *testing.T) {
+	clk := NewFakeClock(time.Unix(0, 0))
+	s := NewScheduler(WithSchedulerClock(clk))
+	var started atomic.Int32
+	release := make(chan struct{})
+	s.Every("slow", time.Second, func(ctx context.Context) error {
+		started.Add(1)
+		<-release
+		return nil
+	})
+	s.Start()
+
+	clk.BlockUntil(1)
+	clk.Advance(time.Second) // first run starts and blocks
+	for started.Load() != 1 {
+		time.Sleep(time.Millisecond)
+	}
+	clk.BlockUntil(1)
+	clk.Advance(time.Second) // skipped: still running
+	clk.BlockUntil(1)
+	close(release)
+	s.Stop(context.Background())
+	if n := started.Load(); n != 1 {
+		t.Errorf("runs started = %d, want 1", n)
+	}
+}
+
+func TestSchedulerTimeoutAndStop(t *testing.T) {
+	s := NewScheduler()
+	deadline := make(chan bool, 1)
+	s.Every("bounded", time.Hour, func(ctx context.Context) error {
+		_, ok := ctx.Deadline()
+		deadline <- ok
+		<-ctx.Done()
+		return ctx.Err()
+	}, WithJobTimeout(time.Minute), WithRunOnStart())
+	s.Start()
+
+	select {
+	case ok := <-deadline:
+		if !ok {
+			t.Error("run context has no deadline")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("WithRunOnStart did not run the job")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := s.Stop(ctx); err != nil {
+		t.Errorf("Stop = %v; running job should have been cancelled", err)
+	}
+	if err := s.Every("late", time.Minute, func(context.Context) error { return nil }); err == nil {
+		t.Error("scheduling after Stop succeeded")
+	}
+}
+
+func TestSchedulerRejectsDuplicatesAndBadSpecs(t *testing.T) {
+	s := NewScheduler()
+	noop := func(context.Context) error { return nil }
+	if err := s.Cron("a", "@hourly", noop); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Cron("a", "@hourly", noop); err == nil {
+		t.Error("duplicate job name accepted")
+	}
+	if err := s.Cron("b", "not a spec", noop); err == nil {
+		t.Error("invalid cron spec accepted")
+	}
+	if err := s.Every("c", 0, noop); err == nil {
+		t.Error("zero interval accepted")
+	}
+	s.Stop(context.Background())
+}
+
+func TestSchedulerRecoversPanics(t *testing.T) {
+	s := NewScheduler()
+	done := make(chan struct{})
+	s.Every("panics", time.Hour, func(ctx context.Context) error {
+		defer close(done)
+		panic(errors.New("boom"))
+	}, WithRunOnStart())
+	s.Start()
+	<-done
+	if err := s.Stop(context.Background()); err != nil {
+		t.Error(err)
+	}
+}
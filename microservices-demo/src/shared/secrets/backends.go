@@ -0,0 +1,61 @@
+package secrets
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// EnvBackend reads secrets from environment variables named prefix followed
+// by the secret name upper-cased, with '-', '.' and '/' replaced by '_'.
+func EnvBackend(prefix string) Backend {
+	return envBackend{prefix}
+}
+
+type envBackend struct{ prefix string }
+
+func (b envBackend) Lookup(_ context.Context, name string) (string, time.Duration, error) {
+	key := b.prefix + strings.NewReplacer("-", "_", ".", "_", "/", "_").Replace(strings.ToUpper(name))
+	if v, ok := os.LookupEnv(key); ok {
+		return v, 0, nil
+	}
+	return "", 0, ErrNotFound
+}
+
+// FileBackend reads secrets from a mounted Kubernetes Secret volume, where
+// each key is a file in dir. Trailing newlines are trimmed. The kubelet
+// updates the files in place when the Secret changes, so the store's TTL
+// bounds how long a rotated value takes to be picked up.
+func FileBackend(dir string) Backend {
+	return fileBackend{dir}
+}
+
+type fileBackend struct{ dir string }
+
+func (b fileBackend) Lookup(_ context.Context, name string) (string, time.Duration, error) {
+	if name == "" || strings.Contains(name, "..") || filepath.IsAbs(name) {
+		return "", 0, fmt.Errorf("secrets: invalid secret name %q", name)
+	}
+	data, err := os.ReadFile(filepath.Join(b.dir, filepath.FromSlash(name)))
+	if os.IsNotExist(err) {
+		return "", 0, ErrNotFound
+	}
+	if err != nil {
+		return "", 0, err
+	}
+	return strings.TrimRight(string(data), "\r\n"), 0, nil
+}
+
+// MapBackend serves fixed values, for tests.
+type MapBackend map[string]string
+
+// Lookup returns m[name].
+func (m MapBackend) Lookup(_ context.Context, name string) (string, time.Duration, error) {
+	if v, ok := m[name]; ok {
+		return v, 0, nil
+	}
+	return "", 0, ErrNotFound
+}
@@ -0,0 +1,200 @@
+// Package secrets resolves credentials from pluggable backends — mounted
+// Kubernetes secret files, environment variables and HashiCorp Vault — with
+// caching and refresh so rotated secrets are picked up without a restart.
+//
+// Secret values are returned as Value, which refuses to print itself: it
+// formats, logs and marshals as [REDACTED], and the plaintext is only
+// available through Reveal. Store.Redact scrubs known secret values from
+// arbitrary text, such as error messages from a client library.
+//
+// The package-level Get uses a default store configured from the
+// environment:
+//
+//	SECRETS_DIR   directory of mounted secret files (default /etc/secrets)
+//	VAULT_ADDR    enables the Vault backend (see VaultConfigFromEnv)
+//
+// Environment variables are consulted first, then files, then Vault.
+package secrets
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrNotFound is returned when no backend has the secret.
+var ErrNotFound = errors.New("secret not found")
+
+// DefaultTTL is how long a secret is cached before it is re-read.
+const DefaultTTL = 5 * time.Minute
+
+const redacted = "[REDACTED]"
+
+// Value is a secret that cannot be printed or logged by accident.
+type Value struct {
+	s string
+}
+
+// NewValue wraps s.
+func NewValue(s string) Value { return Value{s} }
+
+// Reveal returns the plaintext.
+func (v Value) Reveal() string { return v.s }
+
+// String returns [REDACTED].
+func (v Value) String() string { return redacted }
+
+// GoString returns [REDACTED], so %#v is safe too.
+func (v Value) GoString() string { return redacted }
+
+// LogValue keeps the secret out of slog output.
+func (v Value) LogValue() slog.Value { return slog.StringValue(redacted) }
+
+// MarshalJSON keeps the secret out of JSON output.
+func (v Value) MarshalJSON() ([]byte, error) { return json.Marshal(redacted) }
+
+// Backend looks up a secret by name. It returns ErrNotFound if it does not
+// hold the secret; any other error is treated as a backend failure. A
+// positive ttl overrides the store's cache TTL for this value, e.g. a Vault
+// lease.
+type Backend interface {
+	Lookup(ctx context.Context, name string) (value string, ttl time.Duration, err error)
+}
+
+type entry struct {
+	value   Value
+	expires time.Time
+}
+
+// Store caches secrets from a chain of backends.
+type Store struct {
+	backends []Backend
+	ttl      time.Duration
+	now      func() time.Time
+
+	mu    sync.RWMutex
+	cache map[string]entry
+}
+
+// Option configures a Store.
+type Option func(*Store)
+
+// WithTTL sets how long values are cached before being re-read.
+func WithTTL(d time.Duration) Option {
+	return func(s *Store) { s.ttl = d }
+}
+
+// New returns a Store consulting backends in order.
+func New(backends []Backend, opts ...Option) *Store {
+	s := &Store{backends: backends, ttl: DefaultTTL, now: time.Now, cache: make(map[string]entry)}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// Get returns the named secret, from the cache if it is fresh. When a
+// refresh fails because a backend is unavailable, the stale value is
+// returned rather than failing requests during a Vault outage.
+func (s *Store) Get(ctx context.Context, name string) (Value, error) {
+	s.mu.RLock()
+	e, cached := s.cache[name]
+	s.mu.RUnlock()
+	if cached && s.now().Before(e.expires) {
+		return e.value, nil
+	}
+
+	v, ttl, err := s.lookup(ctx, name)
+	if err != nil {
+		if cached && !errors.Is(err, ErrNotFound) {
+			slog.WarnContext(ctx, "secret refresh failed, using cached value", "secret", name, "error", err)
+			return e.value, nil
+		}
+		return Value{}, err
+	}
+	if ttl <= 0 || ttl > s.ttl {
+		ttl = s.ttl
+	}
+	s.mu.Lock()
+	s.cache[name] = entry{value: v, expires: s.now().Add(ttl)}
+	s.mu.Unlock()
+	return v, nil
+}
+
+// lookup asks each backend in turn.
+func (s *Store) lookup(ctx context.Context, name string) (Value, time.Duration, error) {
+	var errs []error
+	for _, b := range s.backends {
+		v, ttl, err := b.Lookup(ctx, name)
+		if err == nil {
+			return NewValue(v), ttl, nil
+		}
+		if !errors.Is(err, ErrNotFound) {
+			errs = append(errs, err)
+		}
+	}
+	if len(errs) > 0 {
+		return Value{}, 0, fmt.Errorf("secrets: %s: %w", name, errors.Join(errs...))
+	}
+	return Value{}, 0, fmt.Errorf("secrets: %s: %w", name, ErrNotFound)
+}
+
+// Invalidate drops name from the cache so the next Get re-reads it.
+func (s *Store) Invalidate(name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.cache, name)
+}
+
+// Redact replaces every cached secret value occurring in text with
+// [REDACTED]. Longer secrets are replaced first so one secret that contains
+// another is fully masked.
+func (s *Store) Redact(text string) string {
+	s.mu.RLock()
+	values := make([]string, 0, len(s.cache))
+	for _, e := range s.cache {
+		if len(e.value.s) >= 4 {
+			values = append(values, e.value.s)
+		}
+	}
+	s.mu.RUnlock()
+	sort.Slice(values, func(i, j int) bool { return len(values[i]) > len(values[j]) })
+	for _, v := range values {
+		text = strings.ReplaceAll(text, v, redacted)
+	}
+	return text
+}
+
+var (
+	defaultOnce  sync.Once
+	defaultStore *Store
+)
+
+// Default returns the store used by Get, building it from the environment on
+// first use.
+func Default() *Store {
+	defaultOnce.Do(func() {
+		dir := os.Getenv("SECRETS_DIR")
+		if dir == "" {
+			dir = "/etc/secrets"
+		}
+		backends := []Backend{EnvBackend(""), FileBackend(dir)}
+		if cfg, ok := VaultConfigFromEnv(); ok {
+			backends = append(backends, NewVaultBackend(cfg))
+		}
+		defaultStore = New(backends)
+	})
+	return defaultStore
+}
+
+// Get returns the named secret from the default store.
+func Get(ctx context.Context, name string) (Value, error) {
+	return Default().Get(ctx, name)
+}
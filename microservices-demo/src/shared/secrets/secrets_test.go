@@ -0,0 +1,135 @@
+package secrets
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestValueIsRedacted(t *testing.T) {
+	v := NewValue("hunter2")
+	for _, s := range []string{fmt.Sprint(v), fmt.Sprintf("%v %+v %#v %s", v, v, v, v)} {
+		if bytes.Contains([]byte(s), []byte("hunter2")) {
+			t.Errorf("formatted value leaks secret: %q", s)
+		}
+	}
+	b, _ := json.Marshal(map[string]Value{"password": v})
+	if bytes.Contains(b, []byte("hunter2")) {
+		t.Errorf("JSON leaks secret: %s", b)
+	}
+	var buf bytes.Buffer
+	slog.New(slog.NewJSONHandler(&buf, nil)).Info("connecting", "password", v)
+	if bytes.Contains(buf.Bytes(), []byte("hunter2")) {
+		t.Errorf("log leaks secret: %s", buf.String())
+	}
+	if v.Reveal() != "hunter2" {
+		t.Error("Reveal lost the plaintext")
+	}
+}
+
+func TestStoreOrderAndCache(t *testing.T) {
+	first := MapBackend{"db-password": "from-first"}
+	s := New([]Backend{first, MapBackend{"db-password": "from-second", "api-key": "k-123"}})
+	now := time.Unix(0, 0)
+	s.now = func() time.Time { return now }
+
+	v, err := s.Get(context.Background(), "db-password")
+	if err != nil || v.Reveal() != "from-first" {
+		t.Fatalf("Get = %q, %v", v.Reveal(), err)
+	}
+	first["db-password"] = "rotated"
+	if v, _ := s.Get(context.Background(), "db-password"); v.Reveal() != "from-first" {
+		t.Errorf("cached value not used, got %q", v.Reveal())
+	}
+	now = now.Add(DefaultTTL)
+	if v, _ := s.Get(context.Background(), "db-password"); v.Reveal() != "rotated" {
+		t.Errorf("rotation not picked up after TTL, got %q", v.Reveal())
+	}
+	if _, err := s.Get(context.Background(), "missing"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get(missing) = %v, want ErrNotFound", err)
+	}
+}
+
+type failingBackend struct{}
+
+func (failingBackend) Lookup(context.Context, string) (string, time.Duration, error) {
+	return "", 0, errors.New("vault unavailable")
+}
+
+func TestStoreServesStaleOnBackendFailure(t *testing.T) {
+	backends := []Backend{MapBackend{"k": "v1"}}
+	s := New(backends, WithTTL(time.Minute))
+	now := time.Unix(0, 0)
+	s.now = func() time.Time { return now }
+	s.Get(context.Background(), "k")
+
+	s.backends = []Backend{failingBackend{}}
+	now = now.Add(time.Hour)
+	if v, err := s.Get(context.Background(), "k"); err != nil || v.Reveal() != "v1" {
+		t.Errorf("Get during outage = %q, %v; want stale v1", v.Reveal(), err)
+	}
+}
+
+func TestRedact(t *testing.T) {
+	s := New([]Backend{MapBackend{"token": "abcd1234"}})
+	s.Get(context.Background(), "token")
+	if got := s.Redact("auth failed for token abcd1234"); got != "auth failed for token [REDACTED]" {
+		t.Errorf("Redact = %q", got)
+	}
+}
+
+func TestEnvAndFileBackends(t *testing.T) {
+	t.Setenv("APP_DB_PASSWORD", "env-secret")
+	if v, _, err := EnvBackend("APP_").Lookup(context.Background(), "db-password"); err != nil || v != "env-secret" {
+		t.Errorf("EnvBackend = %q, %v", v, err)
+	}
+
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "api-key"), []byte("file-secret\n"), 0o600)
+	b := FileBackend(dir)
+	if v, _, err := b.Lookup(context.Background(), "api-key"); err != nil || v != "file-secret" {
+		t.Errorf("FileBackend = %q, %v", v, err)
+	}
+	if _, _, err := b.Lookup(context.Background(), "../etc/passwd"); err == nil || errors.Is(err, ErrNotFound) {
+		t.Errorf("FileBackend accepted a path traversal: %v", err)
+	}
+}
+
+func TestVaultBackendKubernetesAuth(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v1/auth/kubernetes/login":
+			fmt.Fprint(w, `{"auth":{"client_token":"s.abc","lease_duration":3600}}`)
+		case "/v1/secret/data/payment/stripe":
+			if r.Header.Get("X-Vault-Token") != "s.abc" {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+			fmt.Fprint(w, `{"data":{"data":{"api_key":"sk_test"}},"lease_duration":60}`)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	jwt := filepath.Join(t.TempDir(), "token")
+	os.WriteFile(jwt, []byte("jwt"), 0o600)
+	b := NewVaultBackend(VaultConfig{Addr: srv.URL, Role: "payment", JWTPath: jwt})
+
+	v, ttl, err := b.Lookup(context.Background(), "payment/stripe#api_key")
+	if err != nil || v != "sk_test" || ttl != time.Minute {
+		t.Errorf("Lookup = %q, %v, %v", v, ttl, err)
+	}
+	if _, _, err := b.Lookup(context.Background(), "other"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Lookup(other) = %v, want ErrNotFound", err)
+	}
+}
@@ -0,0 +1,179 @@
+package secrets
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultServiceAccountTokenPath is where Kubernetes mounts the pod's
+// service account token.
+const DefaultServiceAccountTokenPath = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+
+// VaultConfig configures the Vault backend.
+type VaultConfig struct {
+	// Addr is the Vault server, e.g. https://vault.vault:8200.
+	Addr string
+	// Mount is the KV version 2 mount (default "secret").
+	Mount string
+	// Token authenticates directly. If empty, Kubernetes auth is used.
+	Token string
+	// Role is the Vault role for Kubernetes auth.
+	Role string
+	// AuthPath is the Kubernetes auth mount (default "kubernetes").
+	AuthPath string
+	// JWTPath is the service account token (default
+	// DefaultServiceAccountTokenPath).
+	JWTPath string
+	// Client is the HTTP client; nil uses one with a 10s timeout.
+	Client *http.Client
+}
+
+// VaultConfigFromEnv reads VAULT_ADDR, VAULT_TOKEN, VAULT_ROLE,
+// VAULT_KV_MOUNT and VAULT_AUTH_PATH. It reports false if VAULT_ADDR is
+// unset.
+func VaultConfigFromEnv() (VaultConfig, bool) {
+	addr := os.Getenv("VAULT_ADDR")
+	if addr == "" {
+		return VaultConfig{}, false
+	}
+	return VaultConfig{
+		Addr:     addr,
+		Token:    os.Getenv("VAULT_TOKEN"),
+		Role:     os.Getenv("VAULT_ROLE"),
+		Mount:    os.Getenv("VAULT_KV_MOUNT"),
+		AuthPath: os.Getenv("VAULT_AUTH_PATH"),
+	}, true
+}
+
+// NewVaultBackend returns a backend reading from a KV version 2 engine.
+// Secret names have the form "path#field", e.g. "payment/stripe#api_key"; a
+// name without a field reads the field "value".
+func NewVaultBackend(cfg VaultConfig) Backend {
+	if cfg.Mount == "" {
+		cfg.Mount = "secret"
+	}
+	if cfg.AuthPath == "" {
+		cfg.AuthPath = "kubernetes"
+	}
+	if cfg.JWTPath == "" {
+		cfg.JWTPath = DefaultServiceAccountTokenPath
+	}
+	if cfg.Client == nil {
+		cfg.Client = &http.Client{Timeout: 10 * time.Second}
+	}
+	cfg.Addr = strings.TrimSuffix(cfg.Addr, "/")
+	return &vaultBackend{cfg: cfg}
+}
+
+type vaultBackend struct {
+	cfg VaultConfig
+
+	mu           sync.Mutex
+	token        string
+	tokenExpires time.Time
+}
+
+func (b *vaultBackend) Lookup(ctx context.Context, name string) (string, time.Duration, error) {
+	path, field, ok := strings.Cut(name, "#")
+	if !ok {
+		field = "value"
+	}
+	token, err := b.authToken(ctx)
+	if err != nil {
+		return "", 0, err
+	}
+
+	var body struct {
+		Data struct {
+			Data map[string]any `json:"data"`
+		} `json:"data"`
+		LeaseDuration int `json:"lease_duration"`
+	}
+	status, err := b.do(ctx, http.MethodGet, "/v1/"+b.cfg.Mount+"/data/"+strings.TrimPrefix(path, "/"), token, nil, &body)
+	if status == http.StatusNotFound {
+		return "", 0, ErrNotFound
+	}
+	if status == http.StatusForbidden && b.cfg.Token == "" {
+		// The login token may have been revoked; log in again next time.
+		b.mu.Lock()
+		b.token = ""
+		b.mu.Unlock()
+	}
+	if err != nil {
+		return "", 0, err
+	}
+	v, ok := body.Data.Data[field]
+	if !ok {
+		return "", 0, ErrNotFound
+	}
+	s, ok := v.(string)
+	if !ok {
+		return "", 0, fmt.Errorf("secrets: vault field %q of %s is not a string", field, path)
+	}
+	return s, time.Duration(body.LeaseDuration) * time.Second, nil
+}
+
+// authToken returns the static token or a (cached) Kubernetes auth token.
+func (b *vaultBackend) authToken(ctx context.Context) (string, error) {
+	if b.cfg.Token != "" {
+		return b.cfg.Token, nil
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.token != "" && time.Now().Before(b.tokenExpires) {
+		return b.token, nil
+	}
+
+	jwt, err := os.ReadFile(b.cfg.JWTPath)
+	if err != nil {
+		return "", fmt.Errorf("secrets: reading service account token: %w", err)
+	}
+	login, _ := json.Marshal(map[string]string{"role": b.cfg.Role, "jwt": strings.TrimSpace(string(jwt))})
+	var body struct {
+		Auth struct {
+			ClientToken   string `json:"client_token"`
+			LeaseDuration int    `json:"lease_duration"`
+		} `json:"auth"`
+	}
+	if _, err := b.do(ctx, http.MethodPost, "/v1/auth/"+b.cfg.AuthPath+"/login", "", login, &body); err != nil {
+		return "", fmt.Errorf("secrets: vault kubernetes login: %w", err)
+	}
+	b.token = body.Auth.ClientToken
+	// Renew a little before the lease runs out.
+	lease := time.Duration(body.Auth.LeaseDuration) * time.Second
+	b.tokenExpires = time.Now().Add(lease - lease/10)
+	return b.token, nil
+}
+
+// do performs a Vault API call and decodes the JSON response into out.
+func (b *vaultBackend) do(ctx context.Context, method, path, token string, body []byte, out any) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, method, b.cfg.Addr+path, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	if token != "" {
+		req.Header.Set("X-Vault-Token", token)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := b.cfg.Client.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("secrets: vault %s %s: %w", method, path, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return resp.StatusCode, fmt.Errorf("secrets: vault %s %s: %s", method, path, resp.Status)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return resp.StatusCode, fmt.Errorf("secrets: decoding vault response: %w", err)
+	}
+	return resp.StatusCode, nil
+}
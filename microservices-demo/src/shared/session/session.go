@@ -0,0 +1,464 @@
+// Package session keeps per-visitor state for HTTP services in a signed
+// cookie, optionally backed by server-side storage.
+//
+// Without a Store the session's values travel inside the cookie, signed
+// with HMAC-SHA256 so clients cannot forge or alter them. With a Store,
+// such as NewRedis, the cookie only carries the signed session ID and the
+// values stay on the server, which suits larger or sensitive state and
+// lets sessions be revoked.
+//
+// Usage:
+//
+//	m := session.NewManager([][]byte{secret}, session.WithStore(session.NewRedis(rdb)))
+//	mux.Handle("/cart", m.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+//	    s := session.FromContext(r.Context())
+//	    cart, err := carts.GetCart(r.Context(), &pb.GetCartRequest{UserId: s.CartID()})
+//	    ...
+//	    s.SetCurrency("EUR")
+//	})))
+//
+// Changes are saved, and the cookie set, before the handler's response
+// headers are written.
+package session
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Defaults matching the frontend's cookies.
+const (
+	DefaultCookieName = "shop_session-id"
+	DefaultMaxAge     = 48 * time.Hour
+)
+
+// Keys of the values with typed accessors.
+const (
+	KeyCartID   = "cart_id"
+	KeyUserID   = "user_id"
+	KeyCurrency = "currency"
+)
+
+// ErrInvalidCookie is returned for cookies that are malformed, carry a bad
+// signature or have expired.
+var ErrInvalidCookie = errors.New("session: invalid cookie")
+
+// Session is one visitor's state. It is safe for concurrent use.
+type Session struct {
+	mu      sync.Mutex
+	id      string
+	values  map[string]string
+	expires time.Time
+	isNew   bool
+	dirty   bool
+	// oldID is the ID replaced by Renew, whose stored values are deleted
+	// on save.
+	oldID     string
+	destroyed bool
+}
+
+// ID identifies the session. It is random, unguessable and stable until
+// Renew.
+func (s *Session) ID() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.id
+}
+
+// IsNew reports whether the session was created by this request.
+func (s *Session) IsNew() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.isNew
+}
+
+// Expires returns when the session lapses unless renewed by activity.
+func (s *Session) Expires() time.Time {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.expires
+}
+
+// Get returns the value of key, or "" if it is unset.
+func (s *Session) Get(key string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.values[key]
+}
+
+// Set stores value under key; an empty value deletes it.
+func (s *Session) Set(key, value string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.values[key] == value {
+		return
+	}
+	if value == "" {
+		delete(s.values, key)
+	} else {
+		s.values[key] = value
+	}
+	s.dirty = true
+}
+
+// CartID returns the ID the visitor's cart is stored under. It defaults to
+// the session ID, which is how the frontend keys carts of anonymous
+// visitors.
+func (s *Session) CartID() string {
+	if v := s.Get(KeyCartID); v != "" {
+		return v
+	}
+	return s.ID()
+}
+
+// SetCartID overrides the cart key, e.g. with the user ID after login.
+func (s *Session) SetCartID(id string) { s.Set(KeyCartID, id) }
+
+// UserID returns the signed-in user, or "" for anonymous visitors.
+func (s *Session) UserID() string { return s.Get(KeyUserID) }
+
+// SetUserID records the signed-in user. Call Renew as well after a login
+// so a session ID planted before it cannot be reused.
+func (s *Session) SetUserID(id string) { s.Set(KeyUserID, id) }
+
+// Currency returns the preferred currency, or def if none was chosen.
+func (s *Session) Currency(def string) string {
+	if v := s.Get(KeyCurrency); v != "" {
+		return v
+	}
+	return def
+}
+
+// SetCurrency records the preferred currency code.
+func (s *Session) SetCurrency(code string) { s.Set(KeyCurrency, code) }
+
+// Renew gives the session a new ID, keeping its values, to prevent session
+// fixation when the visitor's privileges change.
+func (s *Session) Renew() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.oldID == "" && !s.isNew {
+		s.oldID = s.id
+	}
+	s.id = newID()
+	s.dirty = true
+}
+
+// Destroy ends the session: its values are cleared, the stored copy is
+// deleted and the cookie removed.
+func (s *Session) Destroy() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.values = make(map[string]string)
+	s.destroyed, s.dirty = true, true
+}
+
+func newID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(fmt.Sprintf("session: reading random bytes: %v", err))
+	}
+	return hex.EncodeToString(b)
+}
+
+type ctxKey struct{}
+
+// NewContext returns a copy of ctx carrying s.
+func NewContext(ctx context.Context, s *Session) context.Context {
+	return context.WithValue(ctx, ctxKey{}, s)
+}
+
+// FromContext returns the session stored by Manager.Middleware, or nil.
+func FromContext(ctx context.Context) *Session {
+	s, _ := ctx.Value(ctxKey{}).(*Session)
+	return s
+}
+
+// Option configures NewManager.
+type Option func(*Manager)
+
+// WithStore keeps session values server-side in st instead of the cookie.
+func WithStore(st Store) Option {
+	return func(m *Manager) { m.store = st }
+}
+
+// WithCookieName overrides DefaultCookieName.
+func WithCookieName(name string) Option {
+	return func(m *Manager) { m.name = name }
+}
+
+// WithMaxAge sets how long a session lasts without activity (default
+// DefaultMaxAge).
+func WithMaxAge(d time.Duration) Option {
+	return func(m *Manager) { m.maxAge = d }
+}
+
+// WithRenewAfter sets how much of MaxAge may elapse before a request
+// extends the session; the default is half of it. Renewing on every request
+// would rewrite the cookie and the stored copy each time.
+func WithRenewAfter(d time.Duration) Option {
+	return func(m *Manager) { m.renewAfter = d }
+}
+
+// WithSecure marks the cookie Secure, so browsers only send it over HTTPS.
+func WithSecure(secure bool) Option {
+	return func(m *Manager) { m.secure = secure }
+}
+
+// WithLogger sets the logger for store errors (default slog.Default()).
+func WithLogger(l *slog.Logger) Option {
+	return func(m *Manager) { m.log = l }
+}
+
+// Manager creates, loads and saves sessions.
+type Manager struct {
+	secrets    [][]byte
+	store      Store
+	name       string
+	maxAge     time.Duration
+	renewAfter time.Duration
+	secure     bool
+	log        *slog.Logger
+	now        func() time.Time
+}
+
+// NewManager returns a Manager signing cookies with the first of secrets
+// and accepting any of them, so a secret can be rotated by prepending the
+// new one and dropping the old one once its cookies have expired. Secrets
+// should be at least 32 random bytes. NewManager panics without one.
+func NewManager(secrets [][]byte, opts ...Option) *Manager {
+	if len(secrets) == 0 || len(secrets[0]) == 0 {
+		panic("session: NewManager needs a signing secret")
+	}
+	m := &Manager{
+		secrets: secrets,
+		name:    DefaultCookieName,
+		maxAge:  DefaultMaxAge,
+		log:     slog.Default(),
+		now:     time.Now,
+	}
+	for _, opt := range opts {
+		opt(m)
+	}
+	if m.renewAfter <= 0 {
+		m.renewAfter = m.maxAge / 2
+	}
+	return m
+}
+
+// cookiePayload is the signed content of the cookie.
+type cookiePayload struct {
+	ID      string            `json:"id"`
+	Expires int64             `json:"exp"`
+	Values  map[string]string `json:"v,omitempty"`
+}
+
+// Load returns the session of r, or a new one if r has no valid session
+// cookie.
+func (m *Manager) Load(r *http.Request) *Session {
+	s, err := m.load(r)
+	if err != nil {
+		if !errors.Is(err, http.ErrNoCookie) && !errors.Is(err, ErrInvalidCookie) {
+			m.log.WarnContext(r.Context(), "loading session failed, starting a new one", "error", err)
+		}
+		return &Session{id: newID(), values: make(map[string]string), expires: m.now().Add(m.maxAge), isNew: true, dirty: true}
+	}
+	return s
+}
+
+func (m *Manager) load(r *http.Request) (*Session, error) {
+	c, err := r.Cookie(m.name)
+	if err != nil {
+		return nil, err
+	}
+	p, err := m.decode(c.Value)
+	if err != nil {
+		return nil, err
+	}
+	expires := time.Unix(p.Expires, 0)
+	if !m.now().Before(expires) {
+		return nil, ErrInvalidCookie
+	}
+	s := &Session{id: p.ID, values: p.Values, expires: expires}
+	if m.store != nil {
+		values, ok, err := m.store.Load(r.Context(), p.ID)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			// Revoked or expired server-side.
+			return nil, ErrInvalidCookie
+		}
+		s.values = values
+	}
+	if s.values == nil {
+		s.values = make(map[string]string)
+	}
+	if expires.Sub(m.now()) < m.maxAge-m.renewAfter {
+		s.dirty = true
+	}
+	return s, nil
+}
+
+// Save writes s to the store, if any, and sets the cookie on w when s
+// changed or is due for renewal. It must be called before the response
+// headers are written; Middleware does so automatically.
+func (m *Manager) Save(ctx context.Context, w http.ResponseWriter, s *Session) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.dirty {
+		return nil
+	}
+	s.dirty = false
+	if m.store != nil && s.oldID != "" {
+		if err := m.store.Delete(ctx, s.oldID); err != nil {
+			return err
+		}
+		s.oldID = ""
+	}
+	if s.destroyed {
+		if m.store != nil {
+			if err := m.store.Delete(ctx, s.id); err != nil {
+				return err
+			}
+		}
+		http.SetCookie(w, m.cookie("", -1))
+		return nil
+	}
+
+	s.expires = m.now().Add(m.maxAge)
+	p := cookiePayload{ID: s.id, Expires: s.expires.Unix()}
+	if m.store != nil {
+		if err := m.store.Save(ctx, s.id, s.values, m.maxAge); err != nil {
+			return err
+		}
+	} else {
+		p.Values = s.values
+	}
+	v, err := m.encode(p)
+	if err != nil {
+		return err
+	}
+	http.SetCookie(w, m.cookie(v, int(m.maxAge/time.Second)))
+	s.isNew = false
+	return nil
+}
+
+func (m *Manager) cookie(value string, maxAge int) *http.Cookie {
+	return &http.Cookie{
+		Name:     m.name,
+		Value:    value,
+		Path:     "/",
+		MaxAge:   maxAge,
+		HttpOnly: true,
+		Secure:   m.secure,
+		SameSite: http.SameSiteLaxMode,
+	}
+}
+
+// encode returns base64(payload) "." base64(HMAC-SHA256(payload)).
+func (m *Manager) encode(p cookiePayload) (string, error) {
+	b, err := json.Marshal(p)
+	if err != nil {
+		return "", fmt.Errorf("session: %w", err)
+	}
+	payload := base64.RawURLEncoding.EncodeToString(b)
+	return payload + "." + base64.RawURLEncoding.EncodeToString(m.sign(m.secrets[0], payload)), nil
+}
+
+func (m *Manager) decode(v string) (cookiePayload, error) {
+	var p cookiePayload
+	payload, sig, ok := bytes.Cut([]byte(v), []byte("."))
+	if !ok {
+		return p, ErrInvalidCookie
+	}
+	mac, err := base64.RawURLEncoding.DecodeString(string(sig))
+	if err != nil {
+		return p, ErrInvalidCookie
+	}
+	valid := false
+	for _, secret := range m.secrets {
+		if hmac.Equal(mac, m.sign(secret, string(payload))) {
+			valid = true
+			break
+		}
+	}
+	if !valid {
+		return p, ErrInvalidCookie
+	}
+	b, err := base64.RawURLEncoding.DecodeString(string(payload))
+	if err != nil || json.Unmarshal(b, &p) != nil || p.ID == "" {
+		return p, ErrInvalidCookie
+	}
+	return p, nil
+}
+
+func (m *Manager) sign(secret []byte, payload string) []byte {
+	h := hmac.New(sha256.New, secret)
+	h.Write([]byte(m.name))
+	h.Write([]byte{0})
+	h.Write([]byte(payload))
+	return h.Sum(nil)
+}
+
+// Middleware loads the request's session into its context and saves it
+// just before the response headers are written.
+func (m *Manager) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s := m.Load(r)
+		r = r.WithContext(NewContext(r.Context(), s))
+		sw := &saveWriter{ResponseWriter: w, save: func() {
+			if err := m.Save(r.Context(), w, s); err != nil {
+				m.log.ErrorContext(r.Context(), "saving session failed", "error", err)
+			}
+		}}
+		next.ServeHTTP(sw, r)
+		sw.commit()
+	})
+}
+
+// saveWriter saves the session once, before the first header write.
+type saveWriter struct {
+	http.ResponseWriter
+	save func()
+	done bool
+}
+
+func (w *saveWriter) commit() {
+	if !w.done {
+		w.done = true
+		w.save()
+	}
+}
+
+func (w *saveWriter) WriteHeader(code int) {
+	w.commit()
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *saveWriter) Write(p []byte) (int, error) {
+	w.commit()
+	return w.ResponseWriter.Write(p)
+}
+
+func (w *saveWriter) Flush() {
+	w.commit()
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (w *saveWriter) Unwrap() http.ResponseWriter { return w.ResponseWriter }
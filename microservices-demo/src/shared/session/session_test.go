@@ -0,0 +1,155 @@
+package session
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+var secret = []byte("0123456789abcdef0123456789abcdef")
+
+// roundTrip serves one request through m with the cookies of prev and
+// returns the response.
+func roundTrip(t *testing.T, m *Manager, prev *http.Response, h http.HandlerFunc) *http.Response {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	if prev != nil {
+		for _, c := range prev.Cookies() {
+			req.AddCookie(c)
+		}
+	}
+	rec := httptest.NewRecorder()
+	m.Middleware(h).ServeHTTP(rec, req)
+	return rec.Result()
+}
+
+func TestCookieSessionRoundTrip(t *testing.T) {
+	m := NewManager([][]byte{secret})
+	var id string
+	resp := roundTrip(t, m, nil, func(w http.ResponseWriter, r *http.Request) {
+		s := FromContext(r.Context())
+		if !s.IsNew() || s.CartID() != s.ID() {
+			t.Errorf("new session: isNew=%v cart=%q id=%q", s.IsNew(), s.CartID(), s.ID())
+		}
+		id = s.ID()
+		s.SetCurrency("EUR")
+		io.WriteString(w, "ok")
+	})
+	c := resp.Cookies()
+	if len(c) != 1 || c[0].Name != DefaultCookieName || !c[0].HttpOnly || c[0].MaxAge != int(DefaultMaxAge/time.Second) {
+		t.Fatalf("cookies = %v", c)
+	}
+
+	resp = roundTrip(t, m, resp, func(w http.ResponseWriter, r *http.Request) {
+		s := FromContext(r.Context())
+		if s.IsNew() || s.ID() != id || s.Currency("USD") != "EUR" || s.UserID() != "" {
+			t.Errorf("loaded session: isNew=%v id=%q currency=%q", s.IsNew(), s.ID(), s.Currency("USD"))
+		}
+	})
+	if len(resp.Cookies()) != 0 {
+		t.Errorf("unchanged session rewrote the cookie: %v", resp.Cookies())
+	}
+}
+
+func TestTamperedAndRotatedSecrets(t *testing.T) {
+	old := NewManager([][]byte{[]byte("old secret")})
+	resp := roundTrip(t, old, nil, func(_ http.ResponseWriter, r *http.Request) {
+		FromContext(r.Context()).SetUserID("alice")
+	})
+	c := resp.Cookies()[0]
+
+	// A rotated manager still accepts cookies signed with the old secret.
+	rotated := NewManager([][]byte{secret, []byte("old secret")})
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.AddCookie(c)
+	if s := rotated.Load(req); s.IsNew() || s.UserID() != "alice" {
+		t.Errorf("rotated manager rejected old cookie")
+	}
+
+	payload, sig, _ := strings.Cut(c.Value, ".")
+	for name, v := range map[string]string{
+		"other secret": "",
+		"tampered":     payload[:len(payload)-2] + "xx." + sig,
+		"unsigned":     payload,
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		if v == "" {
+			v = c.Value
+		}
+		req.AddCookie(&http.Cookie{Name: c.Name, Value: v})
+		if s := NewManager([][]byte{secret}).Load(req); !s.IsNew() || s.UserID() != "" {
+			t.Errorf("%s: cookie accepted", name)
+		}
+	}
+}
+
+func TestStoreRenewAndDestroy(t *testing.T) {
+	st := NewMemory()
+	m := NewManager([][]byte{secret}, WithStore(st))
+	var first string
+	resp := roundTrip(t, m, nil, func(_ http.ResponseWriter, r *http.Request) {
+		s := FromContext(r.Context())
+		first = s.ID()
+		s.SetCartID("cart-1")
+	})
+	if v := resp.Cookies()[0].Value; strings.Contains(v, "cart-1") {
+		t.Errorf("values leaked into the cookie: %s", v)
+	}
+	if values, ok, _ := st.Load(context.Background(), first); !ok || values[KeyCartID] != "cart-1" {
+		t.Fatalf("store = %v, %v", values, ok)
+	}
+
+	// Logging in rotates the ID and drops the old stored copy.
+	var second string
+	resp = roundTrip(t, m, resp, func(_ http.ResponseWriter, r *http.Request) {
+		s := FromContext(r.Context())
+		s.SetUserID("alice")
+		s.Renew()
+		second = s.ID()
+	})
+	if second == first {
+		t.Fatal("Renew kept the session ID")
+	}
+	if _, ok, _ := st.Load(context.Background(), first); ok {
+		t.Error("old session still stored after Renew")
+	}
+	resp = roundTrip(t, m, resp, func(_ http.ResponseWriter, r *http.Request) {
+		s := FromContext(r.Context())
+		if s.ID() != second || s.CartID() != "cart-1" || s.UserID() != "alice" {
+			t.Errorf("renewed session = %q cart=%q user=%q", s.ID(), s.CartID(), s.UserID())
+		}
+		s.Destroy()
+	})
+	if c := resp.Cookies(); len(c) != 1 || c[0].MaxAge >= 0 {
+		t.Errorf("Destroy did not clear the cookie: %v", c)
+	}
+	if _, ok, _ := st.Load(context.Background(), second); ok {
+		t.Error("destroyed session still stored")
+	}
+}
+
+func TestSlidingRenewal(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+	m := NewManager([][]byte{secret}, WithMaxAge(time.Hour))
+	m.now = func() time.Time { return now }
+	resp := roundTrip(t, m, nil, func(http.ResponseWriter, *http.Request) {})
+
+	now = now.Add(20 * time.Minute)
+	if r := roundTrip(t, m, resp, func(http.ResponseWriter, *http.Request) {}); len(r.Cookies()) != 0 {
+		t.Error("session renewed before half its age")
+	}
+	now = now.Add(20 * time.Minute)
+	if r := roundTrip(t, m, resp, func(http.ResponseWriter, *http.Request) {}); len(r.Cookies()) != 1 {
+		t.Error("session not renewed after half its age")
+	}
+	now = now.Add(time.Hour)
+	roundTrip(t, m, resp, func(_ http.ResponseWriter, r *http.Request) {
+		if !FromContext(r.Context()).IsNew() {
+			t.Error("expired session accepted")
+		}
+	})
+}
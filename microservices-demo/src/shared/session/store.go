@@ -0,0 +1,125 @@
+package session
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Store keeps session values server-side, keyed by session ID.
+type Store interface {
+	// Load returns the values of id; ok is false if there are none or they
+	// have expired.
+	Load(ctx context.Context, id string) (values map[string]string, ok bool, err error)
+	// Save replaces the values of id for ttl.
+	Save(ctx context.Context, id string, values map[string]string, ttl time.Duration) error
+	// Delete drops id.
+	Delete(ctx context.Context, id string) error
+}
+
+// Memory is a Store for a single replica and for tests.
+type Memory struct {
+	mu       sync.Mutex
+	sessions map[string]memSession
+	now      func() time.Time
+}
+
+type memSession struct {
+	values  map[string]string
+	expires time.Time
+}
+
+// NewMemory returns an empty in-memory store.
+func NewMemory() *Memory {
+	return &Memory{sessions: make(map[string]memSession), now: time.Now}
+}
+
+// Load implements Store.
+func (m *Memory) Load(_ context.Context, id string) (map[string]string, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e, ok := m.sessions[id]
+	if !ok || !m.now().Before(e.expires) {
+		return nil, false, nil
+	}
+	return maps.Clone(e.values), true, nil
+}
+
+// Save implements Store.
+func (m *Memory) Save(_ context.Context, id string, values map[string]string, ttl time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := m.now()
+	m.sessions[id] = memSession{values: maps.Clone(values), expires: now.Add(ttl)}
+	// Opportunistically drop expired entries so the map stays bounded.
+	for k, e := range m.sessions {
+		if !now.Before(e.expires) {
+			delete(m.sessions, k)
+		}
+	}
+	return nil
+}
+
+// Delete implements Store.
+func (m *Memory) Delete(_ context.Context, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.sessions, id)
+	return nil
+}
+
+// Redis is a Store shared by every replica through Redis. Values are JSON
+// objects under Prefix+id.
+type Redis struct {
+	Client redis.UniversalClient
+	// Prefix namespaces the keys (default "session:").
+	Prefix string
+}
+
+// NewRedis returns a Store backed by c, for example one from NewClient in
+// the shared redis package.
+func NewRedis(c redis.UniversalClient) *Redis {
+	return &Redis{Client: c, Prefix: "session:"}
+}
+
+// Load implements Store.
+func (r *Redis) Load(ctx context.Context, id string) (map[string]string, bool, error) {
+	b, err := r.Client.Get(ctx, r.Prefix+id).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, fmt.Errorf("session: redis: %w", err)
+	}
+	var values map[string]string
+	if err := json.Unmarshal(b, &values); err != nil {
+		return nil, false, fmt.Errorf("session: decoding session %s: %w", id, err)
+	}
+	return values, true, nil
+}
+
+// Save implements Store.
+func (r *Redis) Save(ctx context.Context, id string, values map[string]string, ttl time.Duration) error {
+	b, err := json.Marshal(values)
+	if err != nil {
+		return err
+	}
+	if err := r.Client.Set(ctx, r.Prefix+id, b, ttl).Err(); err != nil {
+		return fmt.Errorf("session: redis: %w", err)
+	}
+	return nil
+}
+
+// Delete implements Store.
+func (r *Redis) Delete(ctx context.Context, id string) error {
+	if err := r.Client.Del(ctx, r.Prefix+id).Err(); err != nil {
+		return fmt.Errorf("session: redis: %w", err)
+	}
+	return nil
+}
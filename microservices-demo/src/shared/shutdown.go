@@ -0,0 +1,184 @@
+package shared
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// DefaultShutdownHookTimeout bounds each shutdown hook unless overridden.
+const DefaultShutdownHookTimeout = 10 * time.Second
+
+// shutdownManagerActive is set once a ShutdownManager owns SIGTERM/SIGINT, so
+// the coverage dump-on-shutdown handler defers to it instead of re-raising.
+var shutdownManagerActive atomic.Bool
+
+// ShutdownHook releases a resource during shutdown. The context is cancelled
+// when the hook's timeout expires.
+type ShutdownHook func(ctx context.Context) error
+
+type shutdownHook struct {
+	name    string
+	timeout time.Duration
+	fn      ShutdownHook
+}
+
+// ShutdownManager owns process termination: it traps SIGTERM and SIGINT and
+// runs registered hooks in LIFO order, each bounded by its own timeout, so
+// resources are released in the reverse order they were acquired.
+//
+// When GOCOVERDIR is set, a coverage dump is registered as the very first hook
+// and therefore runs last, after every other hook has flushed.
+//
+// Usage:
+//
+//	sm := shared.NewShutdownManager()
+//	sm.Register("grpc-server", func(ctx context.Context) error {
+//	    srv.GracefulStop()
+//	    return nil
+//	})
+//	go srv.Serve(lis)
+//	if err := sm.Wait(); err != nil {
+//	    log.Printf("shutdown: %v", err)
+//	}
+type ShutdownManager struct {
+	mu      sync.Mutex
+	hooks   []shutdownHook
+	timeout time.Duration
+
+	signals chan os.Signal
+	once    sync.Once
+	done    chan struct{}
+	err     error
+}
+
+// ShutdownOption configures a ShutdownManager.
+type ShutdownOption func(*ShutdownManager)
+
+// WithShutdownHookTimeout sets the default timeout applied to hooks registered
+// with Register.
+func WithShutdownHookTimeout(d time.Duration) ShutdownOption {
+	return func(m *ShutdownManager) {
+		m.timeout = d
+	}
+}
+
+// NewShutdownManager creates a ShutdownManager and starts trapping SIGTERM and
+// SIGINT.
+func NewShutdownManager(opts ...ShutdownOption) *ShutdownManager {
+	m := &ShutdownManager{
+		timeout: DefaultShutdownHookTimeout,
+		signals: make(chan os.Signal, 2),
+		done:    make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(m)
+	}
+
+	if coverDir, ok := os.LookupEnv("GOCOVERDIR"); ok {
+		m.Register("coverage", func(ctx context.Context) error {
+			res := dumpCoverageLabeled("shutdown", coverDir, "", false)
+			if res.Err == nil {
+				log.Printf("Coverage: Wrote coverage data to %s before exit", res.Dir)
+			}
+			return res.Err
+		})
+	}
+
+	shutdownManagerActive.Store(true)
+	DefaultSignalRouter().Notify("shutdown", m.signals, syscall.SIGTERM, syscall.SIGINT)
+	return m
+}
+
+// Register adds a hook run on shutdown with the manager's default timeout.
+func (m *ShutdownManager) Register(name string, fn ShutdownHook) {
+	m.RegisterWithTimeout(name, m.timeout, fn)
+}
+
+// RegisterWithTimeout adds a hook run on shutdown with its own timeout.
+func (m *ShutdownManager) RegisterWithTimeout(name string, timeout time.Duration, fn ShutdownHook) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.hooks = append(m.hooks, shutdownHook{name: name, timeout: timeout, fn: fn})
+}
+
+// Wait blocks until SIGTERM or SIGINT is received, runs the shutdown hooks and
+// returns their aggregated error. A second signal while hooks are running
+// aborts the process immediately.
+func (m *ShutdownManager) Wait() error {
+	select {
+	case sig := <-m.signals:
+		log.Printf("Shutdown: Received %v signal, running shutdown hooks...", sig)
+		go func() {
+			select {
+			case sig := <-m.signals:
+				log.Printf("Shutdown: Received second %v signal, exiting immediately", sig)
+				os.Exit(1)
+			case <-m.done:
+			}
+		}()
+		return m.Shutdown(context.Background())
+	case <-m.done:
+		return m.err
+	}
+}
+
+// Shutdown runs the hooks in LIFO order and returns their aggregated error.
+// Only the first call runs the hooks; later calls wait for it and return the
+// same result. Cancelling ctx abandons the remaining hooks.
+func (m *ShutdownManager) Shutdown(ctx context.Context) error {
+	m.once.Do(func() {
+		defer close(m.done)
+		defer DefaultSignalRouter().Stop(m.signals)
+
+		m.mu.Lock()
+		hooks := make([]shutdownHook, len(m.hooks))
+		copy(hooks, m.hooks)
+		m.mu.Unlock()
+
+		var errs []error
+		for i := len(hooks) - 1; i >= 0; i-- {
+			if ctx.Err() != nil {
+				errs = append(errs, fmt.Errorf("shutdown aborted before %q: %w", hooks[i].name, ctx.Err()))
+				break
+			}
+			if err := runShutdownHook(ctx, hooks[i]); err != nil {
+				log.Printf("Shutdown: Hook %q failed: %v", hooks[i].name, err)
+				errs = append(errs, fmt.Errorf("%s: %w", hooks[i].name, err))
+			}
+		}
+		m.err = errors.Join(errs...)
+		log.Println("Shutdown: Complete")
+	})
+	<-m.done
+	return m.err
+}
+
+// runShutdownHook runs h, returning early if its timeout expires.
+func runShutdownHook(ctx context.Context, h shutdownHook) error {
+	ctx, cancel := context.WithTimeout(ctx, h.timeout)
+	defer cancel()
+
+	errc := make(chan error, 1)
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				errc <- fmt.Errorf("panic: %v", r)
+			}
+		}()
+		errc <- h.fn(ctx)
+	}()
+
+	select {
+	case err := <-errc:
+		return err
+	case <-ctx.Done():
+		return fmt.Errorf("did not finish within %v: %w", h.timeout, ctx.Err())
+	}
+}
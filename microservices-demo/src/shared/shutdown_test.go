@@ -0,0 +1,74 @@
+package shared
+
+import (
+	"context"
+	"errors"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestShutdownManager(t *testing.T, opts ...ShutdownOption) *ShutdownManager {
+	t.Helper()
+	if dir, ok := os.LookupEnv("GOCOVERDIR"); ok {
+		t.Setenv("GOCOVERDIR", dir)
+		os.Unsetenv("GOCOVERDIR")
+	}
+	return NewShutdownManager(opts...)
+}
+
+func TestShutdownManagerRunsHooksLIFO(t *testing.T) {
+	m := newTestShutdownManager(t)
+	var order []string
+	for _, name := range []string{"db", "cache", "server"} {
+		name := name
+		m.Register(name, func(ctx context.Context) error {
+			order = append(order, name)
+			return nil
+		})
+	}
+
+	if err := m.Shutdown(context.Background()); err != nil {
+		t.Fatalf("Shutdown: %v", err)
+	}
+	if want := []string{"server", "cache", "db"}; !reflect.DeepEqual(order, want) {
+		t.Errorf("hook order = %v, want %v", order, want)
+	}
+
+	// A second call must not rerun the hooks.
+	if err := m.Shutdown(context.Background()); err != nil || len(order) != 3 {
+		t.Errorf("second Shutdown ran hooks again: %v, %v", order, err)
+	}
+}
+
+func TestShutdownManagerHookTimeoutAndErrors(t *testing.T) {
+	m := newTestShutdownManager(t, WithShutdownHookTimeout(20*time.Millisecond))
+	ran := false
+	m.Register("last", func(ctx context.Context) error {
+		ran = true
+		return nil
+	})
+	m.Register("failing", func(ctx context.Context) error {
+		return errors.New("boom")
+	})
+	m.Register("slow", func(ctx context.Context) error {
+		<-ctx.Done()
+		time.Sleep(time.Second)
+		return nil
+	})
+
+	err := m.Shutdown(context.Background())
+	if err == nil {
+		t.Fatal("expected aggregated error")
+	}
+	for _, want := range []string{"slow: did not finish", "failing: boom"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error %q does not mention %q", err, want)
+		}
+	}
+	if !ran {
+		t.Error("hooks after a failing hook did not run")
+	}
+}
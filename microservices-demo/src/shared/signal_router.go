@@ -0,0 +1,172 @@
+package shared
+
+import (
+	"log"
+	"os"
+	"os/signal"
+	"sort"
+	"sync"
+	"syscall"
+)
+
+// SignalRouter owns the process's signal handling. It calls signal.Notify
+// once per signal and fans every delivery out to the subscribers registered
+// for it, so coverage dumps, profiling, config and TLS reloads, log reopening
+// and shutdown can share signals without one package's signal.Stop or
+// signal.Reset silently disabling another's handler.
+//
+// The helpers of this package register with DefaultSignalRouter. Services
+// should do the same rather than calling signal.Notify themselves:
+//
+//	logs, _ := logging.OpenFile("/var/log/checkout.log")
+//	shared.DefaultSignalRouter().OnReload("logs", logs.Reopen)
+//
+//	stop := shared.DefaultSignalRouter().Handle("drain", func(os.Signal) {
+//	    pool.Drain()
+//	}, syscall.SIGUSR2)
+//	defer stop()
+//
+// Like signal.Notify, the router never blocks on a subscriber: a delivery to
+// a channel that is full is dropped, so a buffer of one coalesces bursts.
+type SignalRouter struct {
+	mu     sync.Mutex
+	inputs map[os.Signal]chan os.Signal
+	subs   map[os.Signal][]signalSub
+}
+
+type signalSub struct {
+	name string
+	c    chan<- os.Signal
+}
+
+// NewSignalRouter returns a router without subscribers. Most code should use
+// DefaultSignalRouter; separate routers are for tests.
+func NewSignalRouter() *SignalRouter {
+	return &SignalRouter{
+		inputs: make(map[os.Signal]chan os.Signal),
+		subs:   make(map[os.Signal][]signalSub),
+	}
+}
+
+var defaultSignalRouter = NewSignalRouter()
+
+// DefaultSignalRouter returns the process-wide router.
+func DefaultSignalRouter() *SignalRouter { return defaultSignalRouter }
+
+// Notify relays sigs to c, like signal.Notify. name identifies the
+// subscriber in Routes and in logs.
+func (r *SignalRouter) Notify(name string, c chan<- os.Signal, sigs ...os.Signal) {
+	if c == nil {
+		panic("shared: SignalRouter.Notify using nil channel")
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, sig := range sigs {
+		r.subs[sig] = append(r.subs[sig], signalSub{name: name, c: c})
+		if _, ok := r.inputs[sig]; !ok {
+			in := make(chan os.Signal, 1)
+			r.inputs[sig] = in
+			signal.Notify(in, sig)
+			go r.dispatch(in)
+		}
+	}
+}
+
+// dispatch relays the deliveries of one signal until it is reset.
+func (r *SignalRouter) dispatch(in chan os.Signal) {
+	for sig := range in {
+		r.mu.Lock()
+		for _, s := range r.subs[sig] {
+			select {
+			case s.c <- sig:
+			default:
+			}
+		}
+		r.mu.Unlock()
+	}
+}
+
+// Stop ends all relaying to c, like signal.Stop. When it returns, c receives
+// no more signals. Signals left without subscribers stay caught by the
+// router, and ignored, so that stopping one handler never restores a
+// default disposition that terminates the process; use Reset for that.
+func (r *SignalRouter) Stop(c chan<- os.Signal) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for sig, subs := range r.subs {
+		kept := subs[:0]
+		for _, s := range subs {
+			if s.c != c {
+				kept = append(kept, s)
+			}
+		}
+		r.subs[sig] = kept
+	}
+}
+
+// Reset drops every subscriber of sigs and restores their default
+// behaviour, like signal.Reset.
+func (r *SignalRouter) Reset(sigs ...os.Signal) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, sig := range sigs {
+		delete(r.subs, sig)
+		if in, ok := r.inputs[sig]; ok {
+			signal.Stop(in)
+			close(in)
+			delete(r.inputs, sig)
+		}
+		signal.Reset(sig)
+	}
+}
+
+// Handle calls fn, on a goroutine of its own, for each of sigs received.
+// Deliveries arriving while fn runs are coalesced into one more call. The
+// returned function unregisters fn; it does not wait for a running call.
+func (r *SignalRouter) Handle(name string, fn func(os.Signal), sigs ...os.Signal) (stop func()) {
+	c := make(chan os.Signal, 1)
+	r.Notify(name, c, sigs...)
+	go func() {
+		for sig := range c {
+			fn(sig)
+		}
+	}()
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			r.Stop(c)
+			close(c)
+		})
+	}
+}
+
+// OnReload calls reload on SIGHUP, the conventional signal to re-read
+// configuration and reopen log files, logging its error if it fails.
+func (r *SignalRouter) OnReload(name string, reload func() error) (stop func()) {
+	return r.Handle(name, func(sig os.Signal) {
+		log.Printf("Signals: Received %v, reloading %s", sig, name)
+		if err := reload(); err != nil {
+			log.Printf("Signals: Reloading %s failed: %v", name, err)
+		}
+	}, syscall.SIGHUP)
+}
+
+// Routes returns the names subscribed to each signal, keyed by the signal's
+// String form, so a service can log or expose who handles what.
+func (r *SignalRouter) Routes() map[string][]string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	routes := make(map[string][]string, len(r.subs))
+	for sig, subs := range r.subs {
+		if len(subs) == 0 {
+			continue
+		}
+		names := make([]string, 0, len(subs))
+		for _, s := range subs {
+			names = append(names, s.name)
+		}
+		sort.Strings(names)
+		routes[sig.String()] = names
+	}
+	return routes
+}
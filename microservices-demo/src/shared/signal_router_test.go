@@ -0,0 +1,70 @@
+package shared
+
+import (
+	"os"
+	"reflect"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// SIGWINCH is ignored by default, so delivering it to the test process is
+// harmless even if a router has stopped catching it.
+func raiseWinch(t *testing.T) {
+	t.Helper()
+	if err := syscall.Kill(os.Getpid(), syscall.SIGWINCH); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func receive(t *testing.T, c <-chan os.Signal) {
+	t.Helper()
+	select {
+	case <-c:
+	case <-time.After(5 * time.Second):
+		t.Fatal("signal not delivered")
+	}
+}
+
+func TestSignalRouterFansOut(t *testing.T) {
+	r := NewSignalRouter()
+	defer r.Reset(syscall.SIGWINCH)
+	a, b := make(chan os.Signal, 1), make(chan os.Signal, 1)
+	r.Notify("a", a, syscall.SIGWINCH)
+	r.Notify("b", b, syscall.SIGWINCH)
+
+	want := map[string][]string{syscall.SIGWINCH.String(): {"a", "b"}}
+	if got := r.Routes(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Routes = %v, want %v", got, want)
+	}
+
+	raiseWinch(t)
+	receive(t, a)
+	receive(t, b)
+
+	// Stopping one subscriber leaves the other in place.
+	r.Stop(a)
+	raiseWinch(t)
+	receive(t, b)
+	select {
+	case <-a:
+		t.Error("stopped channel still receives signals")
+	default:
+	}
+}
+
+func TestSignalRouterHandle(t *testing.T) {
+	r := NewSignalRouter()
+	defer r.Reset(syscall.SIGWINCH)
+	got := make(chan os.Signal, 1)
+	stop := r.Handle("winch", func(sig os.Signal) { got <- sig }, syscall.SIGWINCH)
+
+	raiseWinch(t)
+	receive(t, got)
+
+	stop()
+	stop()
+	if routes := r.Routes(); len(routes) != 0 {
+		t.Errorf("Routes after stop = %v, want none", routes)
+	}
+}
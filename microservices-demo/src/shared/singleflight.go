@@ -0,0 +1,194 @@
+package shared
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/GoogleCloudPlatform/microservices-demo/src/shared/metrics"
+)
+
+var singleflightCalls = metrics.NewCounterVec("singleflight_calls_total",
+	"Singleflight calls, by group and how they were served (called, shared, cached).", "group", "result")
+
+// DefaultSingleflightTTL is how long a Singleflight keeps a successful
+// result.
+const DefaultSingleflightTTL = time.Second
+
+type singleflightConfig struct {
+	name   string
+	ttl    time.Duration
+	errTTL time.Duration
+	clock  Clock
+}
+
+// SingleflightOption configures a Singleflight.
+type SingleflightOption func(*singleflightConfig)
+
+// WithSingleflightName sets the group label on the metrics (default
+// "default").
+func WithSingleflightName(name string) SingleflightOption {
+	return func(c *singleflightConfig) { c.name = name }
+}
+
+// WithSingleflightTTL sets how long a successful result is kept after the
+// call returns; zero keeps none, so only concurrent callers share it.
+func WithSingleflightTTL(d time.Duration) SingleflightOption {
+	return func(c *singleflightConfig) { c.ttl = d }
+}
+
+// WithSingleflightErrorTTL keeps failures for d too (default 0), so callers
+// arriving right after a failed call do not each retry it against a
+// struggling backend.
+func WithSingleflightErrorTTL(d time.Duration) SingleflightOption {
+	return func(c *singleflightConfig) { c.errTTL = d }
+}
+
+// WithSingleflightClock sets the clock used to expire results, for tests.
+func WithSingleflightClock(clk Clock) SingleflightOption {
+	return func(c *singleflightConfig) { c.clock = clk }
+}
+
+// sfCall is one call of fn, shared by its concurrent callers and, until it
+// expires, the ones after.
+type sfCall[V any] struct {
+	done    chan struct{}
+	value   V
+	err     error
+	expires time.Time // set before done is closed; zero if not kept
+}
+
+// Singleflight deduplicates calls by key: concurrent callers share one call
+// of fn, and its result is kept for a short TTL so the callers right behind
+// them share it too. Put one in front of a backend that every request needs
+// after a cache expires, so the expiry sends it one call instead of a
+// thundering herd:
+//
+//	rates := shared.NewSingleflight[string, *pb.GetSupportedCurrenciesResponse](
+//	    shared.WithSingleflightName("currencies"),
+//	    shared.WithSingleflightTTL(2*time.Second),
+//	    shared.WithSingleflightErrorTTL(500*time.Millisecond))
+//	resp, err := rates.Do(ctx, "all", func(ctx context.Context) (*pb.GetSupportedCurrenciesResponse, error) {
+//	    return currency.GetSupportedCurrencies(ctx, &pb.Empty{})
+//	})
+//
+// Unlike Cache, it holds results only briefly and keeps no size bound
+// beyond dropping expired ones, so it suits hot keys rather than large key
+// spaces.
+type Singleflight[K comparable, V any] struct {
+	cfg singleflightConfig
+
+	mu      sync.Mutex
+	calls   map[K]*sfCall[V]
+	sweepAt int
+}
+
+// NewSingleflight returns a Singleflight keeping results for
+// DefaultSingleflightTTL unless overridden.
+func NewSingleflight[K comparable, V any](opts ...SingleflightOption) *Singleflight[K, V] {
+	cfg := singleflightConfig{name: "default", ttl: DefaultSingleflightTTL}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	cfg.clock = clockOrReal(cfg.clock)
+	return &Singleflight[K, V]{cfg: cfg, calls: make(map[K]*sfCall[V]), sweepAt: 64}
+}
+
+// Do returns the result of fn for key: a kept result, the result of a call
+// already in flight, or that of a new call. Each caller waits until the
+// result is in or its own ctx is done. fn runs detached from the
+// cancellation of the caller that started it, keeping its values, so one
+// caller giving up does not fail the others; bound it with its own timeout.
+func (s *Singleflight[K, V]) Do(ctx context.Context, key K, fn func(ctx context.Context) (V, error)) (V, error) {
+	s.mu.Lock()
+	c, ok := s.calls[key]
+	if ok {
+		select {
+		case <-c.done:
+			if s.cfg.clock.Now().Before(c.expires) {
+				s.mu.Unlock()
+				singleflightCalls.WithLabelValues(s.cfg.name, "cached").Inc()
+				return c.value, c.err
+			}
+			ok = false
+		default:
+		}
+	}
+	if ok {
+		s.mu.Unlock()
+		singleflightCalls.WithLabelValues(s.cfg.name, "shared").Inc()
+		return c.wait(ctx)
+	}
+	c = &sfCall[V]{done: make(chan struct{})}
+	s.calls[key] = c
+	s.sweep()
+	s.mu.Unlock()
+	singleflightCalls.WithLabelValues(s.cfg.name, "called").Inc()
+	go s.run(context.WithoutCancel(ctx), key, c, fn)
+	return c.wait(ctx)
+}
+
+func (c *sfCall[V]) wait(ctx context.Context) (V, error) {
+	select {
+	case <-c.done:
+		return c.value, c.err
+	case <-ctx.Done():
+		var zero V
+		return zero, ctx.Err()
+	}
+}
+
+// run calls fn and publishes its result.
+func (s *Singleflight[K, V]) run(ctx context.Context, key K, c *sfCall[V], fn func(ctx context.Context) (V, error)) {
+	defer func() {
+		if r := recover(); r != nil {
+			c.err = fmt.Errorf("%w: %v", errSingleflightPanicked, r)
+		}
+		ttl := s.cfg.ttl
+		if c.err != nil {
+			ttl = s.cfg.errTTL
+		}
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if ttl > 0 {
+			c.expires = s.cfg.clock.Now().Add(ttl)
+		} else if s.calls[key] == c {
+			delete(s.calls, key)
+		}
+		close(c.done)
+	}()
+	c.value, c.err = fn(ctx)
+}
+
+// errSingleflightPanicked is returned to the callers of a call that
+// panicked.
+var errSingleflightPanicked = errors.New("singleflight: call panicked")
+
+// sweep drops expired results once the map has doubled since the last
+// sweep. s.mu must be held.
+func (s *Singleflight[K, V]) sweep() {
+	if len(s.calls) < s.sweepAt {
+		return
+	}
+	now := s.cfg.clock.Now()
+	for k, c := range s.calls {
+		select {
+		case <-c.done:
+			if !now.Before(c.expires) {
+				delete(s.calls, k)
+			}
+		default:
+		}
+	}
+	s.sweepAt = max(64, 2*len(s.calls))
+}
+
+// Forget drops the kept result for key, so the next Do calls fn. A call in
+// flight is not interrupted, but later callers no longer share it.
+func (s *Singleflight[K, V]) Forget(key K) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.calls, key)
+}
@@ -0,0 +1,120 @@
+package shared
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestSingleflightSharesAndKeepsResults(t *testing.T) {
+	clock := NewFakeClock(time.Unix(0, 0))
+	sf := NewSingleflight[string, int](WithSingleflightTTL(time.Second), WithSingleflightClock(clock))
+	var calls atomic.Int32
+	release := make(chan struct{})
+	fn := func(context.Context) (int, error) {
+		<-release
+		return int(calls.Add(1)), nil
+	}
+
+	var wg sync.WaitGroup
+	for range 20 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if v, err := sf.Do(context.Background(), "rates", fn); err != nil || v != 1 {
+				t.Errorf("Do = %d, %v, want the shared first result", v, err)
+			}
+		}()
+	}
+	time.Sleep(10 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	// Within the TTL the result is reused; after it, fn runs again.
+	if v, _ := sf.Do(context.Background(), "rates", fn); v != 1 {
+		t.Errorf("Do within the TTL = %d, want the kept 1", v)
+	}
+	clock.Advance(time.Second)
+	if v, _ := sf.Do(context.Background(), "rates", fn); v != 2 {
+		t.Errorf("Do after the TTL = %d, want a new call", v)
+	}
+	sf.Forget("rates")
+	if v, _ := sf.Do(context.Background(), "rates", fn); v != 3 {
+		t.Errorf("Do after Forget = %d, want a new call", v)
+	}
+	if v, _ := sf.Do(context.Background(), "other", fn); v != 4 {
+		t.Errorf("Do for another key = %d, want its own call", v)
+	}
+}
+
+func TestSingleflightErrors(t *testing.T) {
+	clock := NewFakeClock(time.Unix(0, 0))
+	boom := errors.New("currencyservice unavailable")
+	var calls atomic.Int32
+	fail := func(context.Context) (int, error) {
+		calls.Add(1)
+		return 0, boom
+	}
+
+	sf := NewSingleflight[string, int](WithSingleflightClock(clock))
+	sf.Do(context.Background(), "k", fail)
+	sf.Do(context.Background(), "k", fail)
+	if calls.Load() != 2 {
+		t.Errorf("fn called %d times, want failures not kept by default", calls.Load())
+	}
+
+	calls.Store(0)
+	sf = NewSingleflight[string, int](WithSingleflightErrorTTL(time.Second), WithSingleflightClock(clock))
+	for range 3 {
+		if _, err := sf.Do(context.Background(), "k", fail); !errors.Is(err, boom) {
+			t.Errorf("Do = %v, want the kept failure", err)
+		}
+	}
+	if calls.Load() != 1 {
+		t.Errorf("fn called %d times within the error TTL, want 1", calls.Load())
+	}
+
+	if _, err := sf.Do(context.Background(), "panic", func(context.Context) (int, error) { panic("bad") }); !errors.Is(err, errSingleflightPanicked) {
+		t.Errorf("Do of a panicking call = %v", err)
+	}
+}
+
+func TestSingleflightCallerCancellation(t *testing.T) {
+	sf := NewSingleflight[string, string]()
+	release := make(chan struct{})
+	fn := func(ctx context.Context) (string, error) {
+		select {
+		case <-release:
+			return "ok", nil
+		case <-ctx.Done():
+			return "", ctx.Err()
+		}
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	first := make(chan error, 1)
+	go func() {
+		_, err := sf.Do(ctx, "k", fn)
+		first <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+	second := make(chan string, 1)
+	go func() {
+		v, _ := sf.Do(context.Background(), "k", fn)
+		second <- v
+	}()
+	time.Sleep(10 * time.Millisecond)
+
+	// The caller that started the call leaves; the call goes on for the
+	// other.
+	cancel()
+	if err := <-first; !errors.Is(err, context.Canceled) {
+		t.Errorf("canceled caller got %v", err)
+	}
+	close(release)
+	if v := <-second; v != "ok" {
+		t.Errorf("remaining caller got %q, want the call's result", v)
+	}
+}
@@ -0,0 +1,164 @@
+package sketch
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"math/bits"
+	"sync"
+)
+
+// BloomFilter is a set that can answer "definitely not added" or "probably
+// added".
+type BloomFilter struct {
+	mu   sync.RWMutex
+	bits []uint64
+	m    uint64 // number of bits
+	k    uint32 // hashes per item
+}
+
+// NewBloomFilter sizes a filter to hold n items with a false-positive rate
+// of p, e.g. 0.01. Adding more than n items raises the rate.
+func NewBloomFilter(n uint64, p float64) *BloomFilter {
+	n = max(n, 1)
+	if p <= 0 || p >= 1 {
+		p = 0.01
+	}
+	m := uint64(math.Ceil(-float64(n) * math.Log(p) / (math.Ln2 * math.Ln2)))
+	k := uint32(math.Round(float64(m) / float64(n) * math.Ln2))
+	return newBloomFilter(m, max(k, 1))
+}
+
+func newBloomFilter(m uint64, k uint32) *BloomFilter {
+	m = (max(m, 64) + 63) / 64 * 64
+	return &BloomFilter{bits: make([]uint64, m/64), m: m, k: k}
+}
+
+// Add adds item.
+func (f *BloomFilter) Add(item []byte) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.add(item)
+}
+
+// Test reports whether item was probably added. False means it certainly
+// was not.
+func (f *BloomFilter) Test(item []byte) bool {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return f.test(item)
+}
+
+// TestAndAdd adds item, reporting whether it was probably added before:
+// one step for deduplication.
+func (f *BloomFilter) TestAndAdd(item []byte) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.add(item)
+}
+
+// add sets the bits of item, reporting whether all were set already.
+func (f *BloomFilter) add(item []byte) bool {
+	present := true
+	f.each(item, func(i uint64) {
+		w, b := i/64, uint64(1)<<(i%64)
+		if f.bits[w]&b == 0 {
+			present = false
+			f.bits[w] |= b
+		}
+	})
+	return present
+}
+
+func (f *BloomFilter) test(item []byte) bool {
+	present := true
+	f.each(item, func(i uint64) {
+		if f.bits[i/64]&(1<<(i%64)) == 0 {
+			present = false
+		}
+	})
+	return present
+}
+
+// each calls fn with the k bit positions of item, by double hashing.
+func (f *BloomFilter) each(item []byte, fn func(uint64)) {
+	h1, h2 := hash128(item)
+	for i := range uint64(f.k) {
+		fn((h1 + i*h2) % f.m)
+	}
+}
+
+// Len estimates the number of distinct items added from the share of bits
+// set, so it stays accurate across merges. A saturated filter reports its
+// size in bits.
+func (f *BloomFilter) Len() uint64 {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	set := 0
+	for _, w := range f.bits {
+		set += bits.OnesCount64(w)
+	}
+	if set == int(f.m) {
+		return f.m
+	}
+	m := float64(f.m)
+	return uint64(math.Round(-m / float64(f.k) * math.Log(1-float64(set)/m)))
+}
+
+// FalsePositiveRate estimates the current false-positive rate.
+func (f *BloomFilter) FalsePositiveRate() float64 {
+	n := float64(f.Len())
+	return math.Pow(1-math.Exp(-float64(f.k)*n/float64(f.m)), float64(f.k))
+}
+
+// Merge adds every item of other, which must have the same size.
+func (f *BloomFilter) Merge(other *BloomFilter) error {
+	other.mu.RLock()
+	defer other.mu.RUnlock()
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.m != other.m || f.k != other.k {
+		return ErrIncompatible
+	}
+	for i, w := range other.bits {
+		f.bits[i] |= w
+	}
+	return nil
+}
+
+// MarshalBinary encodes the filter.
+func (f *BloomFilter) MarshalBinary() ([]byte, error) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	b := header(tagBloom)
+	b = binary.BigEndian.AppendUint64(b, f.m)
+	b = binary.BigEndian.AppendUint32(b, f.k)
+	for _, w := range f.bits {
+		b = binary.BigEndian.AppendUint64(b, w)
+	}
+	return b, nil
+}
+
+// UnmarshalBinary replaces the filter with one encoded by MarshalBinary.
+func (f *BloomFilter) UnmarshalBinary(data []byte) error {
+	data, err := checkHeader(data, tagBloom, "bloom filter")
+	if err != nil {
+		return err
+	}
+	if len(data) < 12 {
+		return fmt.Errorf("%w: truncated bloom filter", ErrEncoding)
+	}
+	m, k := binary.BigEndian.Uint64(data), binary.BigEndian.Uint32(data[8:])
+	data = data[12:]
+	if m == 0 || m%64 != 0 || k == 0 || uint64(len(data)) != m/8 {
+		return fmt.Errorf("%w: bloom filter of %d bits with %d bytes", ErrEncoding, m, len(data))
+	}
+	words := make([]uint64, m/64)
+	for i := range words {
+		words[i] = binary.BigEndian.Uint64(data[8*i:])
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.bits, f.m, f.k = words, m, k
+	return nil
+}
@@ -0,0 +1,133 @@
+package sketch
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"sync"
+)
+
+// CountMinSketch estimates item frequencies. Estimates never fall below the
+// true count and exceed it by at most epsilon times the total count, with
+// probability 1-delta.
+type CountMinSketch struct {
+	mu     sync.RWMutex
+	width  uint32
+	depth  uint32
+	counts []uint64 // depth rows of width counters
+	total  uint64
+}
+
+// NewCountMinSketch sizes a sketch for the error bound epsilon (e.g. 0.001)
+// holding with probability 1-delta (e.g. delta 0.01): width ⌈e/epsilon⌉ and
+// depth ⌈ln(1/delta)⌉.
+func NewCountMinSketch(epsilon, delta float64) *CountMinSketch {
+	if epsilon <= 0 || epsilon >= 1 {
+		epsilon = 0.001
+	}
+	if delta <= 0 || delta >= 1 {
+		delta = 0.01
+	}
+	width := uint32(math.Ceil(math.E / epsilon))
+	depth := uint32(math.Ceil(math.Log(1 / delta)))
+	return newCountMinSketch(width, max(depth, 1))
+}
+
+func newCountMinSketch(width, depth uint32) *CountMinSketch {
+	return &CountMinSketch{width: width, depth: depth, counts: make([]uint64, uint64(width)*uint64(depth))}
+}
+
+// Add counts n occurrences of item.
+func (s *CountMinSketch) Add(item []byte, n uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.each(item, func(i int) { s.counts[i] += n })
+	s.total += n
+}
+
+// Count estimates the occurrences of item.
+func (s *CountMinSketch) Count(item []byte) uint64 {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	est := uint64(math.MaxUint64)
+	s.each(item, func(i int) { est = min(est, s.counts[i]) })
+	return est
+}
+
+// Total returns the occurrences counted of all items.
+func (s *CountMinSketch) Total() uint64 {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.total
+}
+
+// each calls fn with the counter index of item in every row.
+func (s *CountMinSketch) each(item []byte, fn func(int)) {
+	h1, h2 := hash128(item)
+	for row := range uint64(s.depth) {
+		fn(int(row*uint64(s.width) + (h1+row*h2)%uint64(s.width)))
+	}
+}
+
+// Merge adds the counts of other, which must have the same shape.
+func (s *CountMinSketch) Merge(other *CountMinSketch) error {
+	other.mu.RLock()
+	defer other.mu.RUnlock()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.width != other.width || s.depth != other.depth {
+		return ErrIncompatible
+	}
+	for i, c := range other.counts {
+		s.counts[i] += c
+	}
+	s.total += other.total
+	return nil
+}
+
+// MarshalBinary encodes the sketch.
+func (s *CountMinSketch) MarshalBinary() ([]byte, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	b := header(tagCountMin)
+	b = binary.BigEndian.AppendUint32(b, s.width)
+	b = binary.BigEndian.AppendUint32(b, s.depth)
+	b = binary.BigEndian.AppendUint64(b, s.total)
+	for _, c := range s.counts {
+		b = binary.AppendUvarint(b, c)
+	}
+	return b, nil
+}
+
+// UnmarshalBinary replaces the sketch with one encoded by MarshalBinary.
+func (s *CountMinSketch) UnmarshalBinary(data []byte) error {
+	data, err := checkHeader(data, tagCountMin, "count-min sketch")
+	if err != nil {
+		return err
+	}
+	if len(data) < 16 {
+		return fmt.Errorf("%w: truncated count-min sketch", ErrEncoding)
+	}
+	width, depth, total := binary.BigEndian.Uint32(data), binary.BigEndian.Uint32(data[4:]), binary.BigEndian.Uint64(data[8:])
+	data = data[16:]
+	n := uint64(width) * uint64(depth)
+	// Every counter takes at least a byte, which bounds the allocation.
+	if n == 0 || n > uint64(len(data)) {
+		return fmt.Errorf("%w: count-min sketch of %dx%d with %d bytes", ErrEncoding, width, depth, len(data))
+	}
+	counts := make([]uint64, n)
+	for i := range counts {
+		c, k := binary.Uvarint(data)
+		if k <= 0 {
+			return fmt.Errorf("%w: truncated count-min sketch", ErrEncoding)
+		}
+		counts[i], data = c, data[k:]
+	}
+	if len(data) != 0 {
+		return fmt.Errorf("%w: trailing bytes after count-min sketch", ErrEncoding)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.width, s.depth, s.counts, s.total = width, depth, counts, total
+	return nil
+}
@@ -0,0 +1,120 @@
+package sketch
+
+import (
+	"fmt"
+	"math"
+	"math/bits"
+	"sync"
+)
+
+// DefaultPrecision gives 2^14 registers: 16 KiB and a standard error of
+// about 0.8%.
+const DefaultPrecision = 14
+
+// HyperLogLog estimates the number of distinct items added.
+type HyperLogLog struct {
+	mu   sync.RWMutex
+	p    uint8
+	regs []uint8
+}
+
+// NewHyperLogLog returns an estimator with 2^precision registers, from 4 to
+// 18; its standard error is 1.04/√(2^precision).
+func NewHyperLogLog(precision uint8) (*HyperLogLog, error) {
+	if precision < 4 || precision > 18 {
+		return nil, fmt.Errorf("sketch: HyperLogLog precision %d outside 4..18", precision)
+	}
+	return &HyperLogLog{p: precision, regs: make([]uint8, 1<<precision)}, nil
+}
+
+// Add adds item.
+func (h *HyperLogLog) Add(item []byte) {
+	x, _ := hash128(item)
+	idx := x >> (64 - h.p)
+	// The rank is the position of the first set bit after the index bits;
+	// the guard bit caps it when they are all zero.
+	rank := uint8(bits.LeadingZeros64(x<<h.p|1<<(h.p-1))) + 1
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if rank > h.regs[idx] {
+		h.regs[idx] = rank
+	}
+}
+
+// Count estimates the number of distinct items added.
+func (h *HyperLogLog) Count() uint64 {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	m := float64(len(h.regs))
+	sum, zeros := 0.0, 0
+	for _, r := range h.regs {
+		sum += math.Ldexp(1, -int(r))
+		if r == 0 {
+			zeros++
+		}
+	}
+	est := alpha(len(h.regs)) * m * m / sum
+	// Small cardinalities are estimated better by linear counting of the
+	// empty registers.
+	if est <= 2.5*m && zeros > 0 {
+		est = m * math.Log(m/float64(zeros))
+	}
+	return uint64(math.Round(est))
+}
+
+func alpha(m int) float64 {
+	switch m {
+	case 16:
+		return 0.673
+	case 32:
+		return 0.697
+	case 64:
+		return 0.709
+	}
+	return 0.7213 / (1 + 1.079/float64(m))
+}
+
+// Merge adds the items of other, which must have the same precision; the
+// result estimates the size of the union.
+func (h *HyperLogLog) Merge(other *HyperLogLog) error {
+	other.mu.RLock()
+	defer other.mu.RUnlock()
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.p != other.p {
+		return ErrIncompatible
+	}
+	for i, r := range other.regs {
+		h.regs[i] = max(h.regs[i], r)
+	}
+	return nil
+}
+
+// MarshalBinary encodes the estimator.
+func (h *HyperLogLog) MarshalBinary() ([]byte, error) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	b := append(header(tagHyperLogLog), h.p)
+	return append(b, h.regs...), nil
+}
+
+// UnmarshalBinary replaces the estimator with one encoded by MarshalBinary.
+func (h *HyperLogLog) UnmarshalBinary(data []byte) error {
+	data, err := checkHeader(data, tagHyperLogLog, "HyperLogLog")
+	if err != nil {
+		return err
+	}
+	if len(data) < 1 || data[0] < 4 || data[0] > 18 || len(data)-1 != 1<<data[0] {
+		return fmt.Errorf("%w: malformed HyperLogLog", ErrEncoding)
+	}
+	p, regs := data[0], append([]uint8(nil), data[1:]...)
+	for _, r := range regs {
+		if int(r) > 65-int(p) {
+			return fmt.Errorf("%w: HyperLogLog register out of range", ErrEncoding)
+		}
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.p, h.regs = p, regs
+	return nil
+}
@@ -0,0 +1,79 @@
+// Package sketch provides probabilistic data structures that answer set
+// and counting questions in fixed memory, trading exactness for size:
+//
+//   - BloomFilter tests membership with no false negatives and a chosen
+//     false-positive rate, e.g. to skip seen-product events already
+//     processed.
+//   - CountMinSketch estimates how often each item occurred, never
+//     under-counting, e.g. to find the products viewed most.
+//   - HyperLogLog estimates the number of distinct items, e.g. unique
+//     visitors, to within about 1% in 16 KiB.
+//
+// All three are safe for concurrent use, merge with another of the same
+// shape (so per-pod structures can be combined), and serialize with
+// MarshalBinary for storage in Redis or exchange between services. Items
+// hash the same way in every process, so structures built on different
+// pods agree.
+//
+//	seen := sketch.NewBloomFilter(1_000_000, 0.001)
+//	if seen.TestAndAdd([]byte(event.ID)) {
+//	    return // probably a duplicate
+//	}
+package sketch
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/fnv"
+)
+
+var (
+	// ErrIncompatible is returned when merging structures of different
+	// shapes.
+	ErrIncompatible = errors.New("sketch: structures have different parameters")
+	// ErrEncoding is returned by UnmarshalBinary for data it cannot decode.
+	ErrEncoding = errors.New("sketch: invalid encoding")
+)
+
+// Encoding tags, the first byte of each serialized structure.
+const (
+	tagBloom       = 'B'
+	tagCountMin    = 'C'
+	tagHyperLogLog = 'H'
+	version        = 1
+)
+
+// hash128 returns two independent 64-bit hashes of b: the halves of FNV-1a
+// 128, each passed through the splitmix64 finalizer to spread FNV's weak
+// low bits.
+func hash128(b []byte) (uint64, uint64) {
+	h := fnv.New128a()
+	h.Write(b)
+	var sum [16]byte
+	h.Sum(sum[:0])
+	return mix(binary.BigEndian.Uint64(sum[:8])), mix(binary.BigEndian.Uint64(sum[8:]))
+}
+
+func mix(x uint64) uint64 {
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
+
+// header starts an encoding with its tag and version.
+func header(tag byte) []byte { return []byte{tag, version} }
+
+// checkHeader strips and checks the header of data.
+func checkHeader(data []byte, tag byte, name string) ([]byte, error) {
+	if len(data) < 2 || data[0] != tag {
+		return nil, fmt.Errorf("%w: not a %s", ErrEncoding, name)
+	}
+	if data[1] != version {
+		return nil, fmt.Errorf("%w: %s version %d", ErrEncoding, name, data[1])
+	}
+	return data[2:], nil
+}
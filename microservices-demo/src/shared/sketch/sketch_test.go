@@ -0,0 +1,148 @@
+package sketch
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"testing"
+)
+
+func item(i int) []byte { return []byte(fmt.Sprintf("product-%d", i)) }
+
+func TestBloomFilter(t *testing.T) {
+	const n = 10000
+	f := NewBloomFilter(n, 0.01)
+	for i := range n {
+		if f.TestAndAdd(item(i)) && i < 10 {
+			t.Errorf("item %d reported as seen before it was added", i)
+		}
+	}
+	for i := range n {
+		if !f.Test(item(i)) {
+			t.Fatalf("false negative for item %d", i)
+		}
+	}
+	fp := 0
+	for i := n; i < 2*n; i++ {
+		if f.Test(item(i)) {
+			fp++
+		}
+	}
+	if rate := float64(fp) / n; rate > 0.02 {
+		t.Errorf("false-positive rate %.4f, want about 0.01", rate)
+	}
+	if got := f.Len(); math.Abs(float64(got)-n) > n*0.03 {
+		t.Errorf("Len = %d, want about %d", got, n)
+	}
+	if r := f.FalsePositiveRate(); r > 0.015 {
+		t.Errorf("FalsePositiveRate = %.4f", r)
+	}
+}
+
+func TestBloomFilterMergeAndEncoding(t *testing.T) {
+	a, b := NewBloomFilter(1000, 0.01), NewBloomFilter(1000, 0.01)
+	a.Add([]byte("a"))
+	b.Add([]byte("b"))
+	if err := a.Merge(b); err != nil || !a.Test([]byte("b")) {
+		t.Fatalf("Merge = %v, Test(b) = %v", err, a.Test([]byte("b")))
+	}
+	if err := a.Merge(NewBloomFilter(10, 0.01)); !errors.Is(err, ErrIncompatible) {
+		t.Errorf("Merge of another size = %v", err)
+	}
+
+	data, _ := a.MarshalBinary()
+	var c BloomFilter
+	if err := c.UnmarshalBinary(data); err != nil {
+		t.Fatal(err)
+	}
+	if !c.Test([]byte("a")) || !c.Test([]byte("b")) || c.Test([]byte("c")) {
+		t.Error("decoded filter does not match")
+	}
+	for _, bad := range [][]byte{nil, data[:len(data)-1], append([]byte{'H'}, data[1:]...)} {
+		if err := c.UnmarshalBinary(bad); !errors.Is(err, ErrEncoding) {
+			t.Errorf("UnmarshalBinary of %d bytes = %v", len(bad), err)
+		}
+	}
+}
+
+func TestCountMinSketch(t *testing.T) {
+	s := NewCountMinSketch(0.001, 0.01)
+	for i := range 1000 {
+		s.Add(item(i), uint64(i%10+1))
+	}
+	s.Add([]byte("popular"), 5000)
+	total := s.Total()
+	if total != 5500+5000 {
+		t.Fatalf("Total = %d", total)
+	}
+	for i := range 1000 {
+		got, want := s.Count(item(i)), uint64(i%10+1)
+		if got < want || float64(got-want) > 0.001*math.E*float64(total) {
+			t.Fatalf("Count(item %d) = %d, want %d within the error bound", i, got, want)
+		}
+	}
+
+	other := NewCountMinSketch(0.001, 0.01)
+	other.Add([]byte("popular"), 1)
+	if err := s.Merge(other); err != nil || s.Count([]byte("popular")) < 5001 {
+		t.Errorf("Merge = %v, Count(popular) = %d", err, s.Count([]byte("popular")))
+	}
+	if err := s.Merge(NewCountMinSketch(0.1, 0.01)); !errors.Is(err, ErrIncompatible) {
+		t.Errorf("Merge of another shape = %v", err)
+	}
+
+	data, _ := s.MarshalBinary()
+	var d CountMinSketch
+	if err := d.UnmarshalBinary(data); err != nil {
+		t.Fatal(err)
+	}
+	if d.Count([]byte("popular")) != s.Count([]byte("popular")) || d.Total() != s.Total() {
+		t.Error("decoded sketch does not match")
+	}
+	if err := d.UnmarshalBinary(data[:len(data)-1]); !errors.Is(err, ErrEncoding) {
+		t.Errorf("UnmarshalBinary of a truncated sketch = %v", err)
+	}
+}
+
+func TestHyperLogLog(t *testing.T) {
+	if _, err := NewHyperLogLog(3); err == nil {
+		t.Error("NewHyperLogLog accepted precision 3")
+	}
+	for _, n := range []int{10, 1000, 100000} {
+		h, _ := NewHyperLogLog(DefaultPrecision)
+		for i := range n {
+			h.Add(item(i))
+			h.Add(item(i)) // duplicates do not count
+		}
+		if got := h.Count(); math.Abs(float64(got)-float64(n)) > float64(n)*0.03+1 {
+			t.Errorf("Count after %d distinct items = %d", n, got)
+		}
+	}
+
+	a, _ := NewHyperLogLog(DefaultPrecision)
+	b, _ := NewHyperLogLog(DefaultPrecision)
+	for i := range 20000 {
+		a.Add(item(i))
+		b.Add(item(i + 10000))
+	}
+	if err := a.Merge(b); err != nil {
+		t.Fatal(err)
+	}
+	if got := a.Count(); math.Abs(float64(got)-30000) > 900 {
+		t.Errorf("Count of the union = %d, want about 30000", got)
+	}
+	small, _ := NewHyperLogLog(10)
+	if err := a.Merge(small); !errors.Is(err, ErrIncompatible) {
+		t.Errorf("Merge of another precision = %v", err)
+	}
+
+	data, _ := a.MarshalBinary()
+	var c HyperLogLog
+	if err := c.UnmarshalBinary(data); err != nil || c.Count() != a.Count() {
+		t.Errorf("decoded Count = %d, %v, want %d", c.Count(), err, a.Count())
+	}
+	data[len(data)-1] = 200
+	if err := c.UnmarshalBinary(data); !errors.Is(err, ErrEncoding) {
+		t.Errorf("UnmarshalBinary with a bad register = %v", err)
+	}
+}
@@ -0,0 +1,285 @@
+package slo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/GoogleCloudPlatform/microservices-demo/src/shared/health"
+)
+
+// shortSpan is how far back per-minute counts are kept; longer windows are
+// summed from per-hour counts.
+const shortSpan = 6 * time.Hour
+
+// BurnWindow is one burn-rate alert: it fires while the budget burns faster
+// than Burn over both Long and Short. The long window keeps a brief spike
+// from alerting; the short one makes the alert stop soon after the burn
+// does.
+type BurnWindow struct {
+	Long, Short time.Duration
+	// Burn is the rate that fires the alert: 14.4 over an hour spends 2%
+	// of a 30-day budget.
+	Burn float64
+	// Severity is "page" or "ticket", copied to the alert.
+	Severity string
+}
+
+// Name identifies the window in metrics and alerts, e.g. "1h/5m".
+func (w BurnWindow) Name() string {
+	return promDuration(w.Long) + "/" + promDuration(w.Short)
+}
+
+// DefaultBurnWindows are the SRE workbook's recommendation for a 30-day
+// budget: page on 2% of it spent in an hour or 5% in six hours, open a
+// ticket on 10% spent in a day or three.
+var DefaultBurnWindows = []BurnWindow{
+	{Long: time.Hour, Short: 5 * time.Minute, Burn: 14.4, Severity: "page"},
+	{Long: 6 * time.Hour, Short: 30 * time.Minute, Burn: 6, Severity: "page"},
+	{Long: 24 * time.Hour, Short: 2 * time.Hour, Burn: 3, Severity: "ticket"},
+	{Long: 72 * time.Hour, Short: 6 * time.Hour, Burn: 1, Severity: "ticket"},
+}
+
+// budgetAlert is the name of the alert firing while an SLO's budget is
+// exhausted.
+const budgetAlert = "budget"
+
+// Alert is a change of an SLO alert: a burn-rate window starting or
+// stopping to fire, or the error budget running out or recovering.
+type Alert struct {
+	SLO string `json:"slo"`
+	// Name is the burn window's name, or "budget".
+	Name     string `json:"name"`
+	Severity string `json:"severity"`
+	Firing   bool   `json:"firing"`
+	// BurnRate is the long window's rate, zero for the budget alert.
+	BurnRate        float64   `json:"burnRate,omitempty"`
+	BudgetRemaining float64   `json:"budgetRemaining"`
+	At              time.Time `json:"at"`
+}
+
+// Status is the state of one SLO.
+type Status struct {
+	Name      string
+	Objective float64
+	// Total and Bad count the requests over the SLO window.
+	Total, Bad uint64
+	// BudgetRemaining is the fraction of the error budget left; negative
+	// once overspent.
+	BudgetRemaining float64
+	// BurnRates maps the durations of the burn windows, such as "1h" or
+	// "5m", to burn rates.
+	BurnRates map[string]float64
+	// Firing lists the names of the alerts firing, "budget" included.
+	Firing []string
+}
+
+// Exhausted reports whether the error budget is spent.
+func (s Status) Exhausted() bool { return s.BudgetRemaining <= 0 }
+
+// Run checks the SLOs every check interval until ctx is done.
+func (t *Tracker) Run(ctx context.Context) error {
+	ticker := t.cfg.clock.NewTicker(t.cfg.interval)
+	defer ticker.Stop()
+	for {
+		t.Check(ctx)
+		select {
+		case <-ticker.C():
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+// Check computes the budgets and burn rates of every SLO, updates the
+// metrics, and reports alerts that started or stopped firing since the
+// last check to the logger and the alert function.
+func (t *Tracker) Check(ctx context.Context) []Status {
+	now := t.cfg.clock.Now()
+	out := make([]Status, 0, len(t.order))
+	for _, s := range t.order {
+		st, changed := t.check(s, now)
+		out = append(out, st)
+		for _, a := range changed {
+			t.alert(ctx, a)
+		}
+	}
+	return out
+}
+
+// evaluate computes the counts, budget and burn rates of s. s.mu must be
+// held.
+func (t *Tracker) evaluate(s *tracked, now time.Time) Status {
+	budget := 1 - s.Objective
+	st := Status{Name: s.Name, Objective: s.Objective, BurnRates: make(map[string]float64), BudgetRemaining: 1}
+	st.Total, st.Bad = s.hours.sum(now, s.Window)
+	if st.Total > 0 {
+		st.BudgetRemaining = 1 - float64(st.Bad)/(float64(st.Total)*budget)
+	}
+	for _, w := range t.cfg.windows {
+		for _, d := range []time.Duration{w.Long, w.Short} {
+			st.BurnRates[promDuration(d)] = s.errorRatio(now, d) / budget
+		}
+	}
+	return st
+}
+
+// check evaluates s, updating its metrics, and returns its status and the
+// alerts that changed.
+func (t *Tracker) check(s *tracked, now time.Time) (Status, []Alert) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	st := t.evaluate(s, now)
+	budgetGauge.WithLabelValues(s.Name).Set(st.BudgetRemaining)
+	for window, r := range st.BurnRates {
+		burnGauge.WithLabelValues(s.Name, window).Set(r)
+	}
+
+	var changed []Alert
+	set := func(name, severity string, firing bool, rate float64) {
+		if firing {
+			st.Firing = append(st.Firing, name)
+		}
+		if s.firing[name] == firing {
+			return
+		}
+		s.firing[name] = firing
+		v := 0.0
+		if firing {
+			v = 1
+		}
+		alertGauge.WithLabelValues(s.Name, name).Set(v)
+		changed = append(changed, Alert{SLO: s.Name, Name: name, Severity: severity, Firing: firing,
+			BurnRate: rate, BudgetRemaining: st.BudgetRemaining, At: now})
+	}
+	for _, w := range t.cfg.windows {
+		long, short := st.BurnRates[promDuration(w.Long)], st.BurnRates[promDuration(w.Short)]
+		set(w.Name(), w.Severity, long > w.Burn && short > w.Burn, long)
+	}
+	set(budgetAlert, "page", st.Exhausted(), 0)
+	return st, changed
+}
+
+// errorRatio returns the share of bad requests over the last d. s.mu must
+// be held.
+func (s *tracked) errorRatio(now time.Time, d time.Duration) float64 {
+	r := s.minutes
+	if d > shortSpan {
+		r = s.hours
+	}
+	total, bad := r.sum(now, d)
+	if total == 0 {
+		return 0
+	}
+	return float64(bad) / float64(total)
+}
+
+func (t *Tracker) alert(ctx context.Context, a Alert) {
+	log := t.cfg.log.With("slo", a.SLO, "alert", a.Name, "severity", a.Severity, "budget_remaining", a.BudgetRemaining)
+	if a.Name != budgetAlert {
+		log = log.With("burn_rate", a.BurnRate)
+	}
+	if a.Firing {
+		log.WarnContext(ctx, "SLO alert firing")
+	} else {
+		log.InfoContext(ctx, "SLO alert resolved")
+	}
+	if t.cfg.onAlert != nil {
+		t.cfg.onAlert(ctx, a)
+	}
+}
+
+// Checker returns a readiness check failing while the budget of an SLO
+// declared with GateReadiness is exhausted, as of the last check:
+//
+//	hr.Register("slo", tracker.Checker(), health.Readiness)
+func (t *Tracker) Checker() health.Checker {
+	return func(context.Context) error {
+		var exhausted []string
+		for _, s := range t.order {
+			if !s.GateReadiness {
+				continue
+			}
+			s.mu.Lock()
+			if s.firing[budgetAlert] {
+				exhausted = append(exhausted, s.Name)
+			}
+			s.mu.Unlock()
+		}
+		if len(exhausted) > 0 {
+			return fmt.Errorf("slo: error budget exhausted: %s", strings.Join(exhausted, ", "))
+		}
+		return nil
+	}
+}
+
+// ErrUnknownSLO is returned by Status for a name no SLO was declared with.
+var ErrUnknownSLO = errors.New("slo: unknown SLO")
+
+// Status evaluates the SLO name now, listing the alerts firing as of the
+// last check.
+func (t *Tracker) Status(name string) (Status, error) {
+	s := t.slos[name]
+	if s == nil {
+		return Status{}, fmt.Errorf("%w %q", ErrUnknownSLO, name)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	st := t.evaluate(s, t.cfg.clock.Now())
+	for _, w := range t.cfg.windows {
+		if s.firing[w.Name()] {
+			st.Firing = append(st.Firing, w.Name())
+		}
+	}
+	if s.firing[budgetAlert] {
+		st.Firing = append(st.Firing, budgetAlert)
+	}
+	return st, nil
+}
+
+// ring counts requests in fixed-width time buckets, overwriting the oldest.
+type ring struct {
+	width   time.Duration
+	buckets []bucket
+}
+
+type bucket struct {
+	slot       int64 // time / width of the counts; stale if behind
+	total, bad uint64
+}
+
+// newRing returns a ring keeping at least span of width-wide buckets.
+func newRing(width, span time.Duration) *ring {
+	n := int((span+width-1)/width) + 1
+	return &ring{width: width, buckets: make([]bucket, n)}
+}
+
+func (r *ring) slot(t time.Time) int64 { return t.UnixNano() / int64(r.width) }
+
+func (r *ring) add(now time.Time, bad bool) {
+	slot := r.slot(now)
+	b := &r.buckets[slot%int64(len(r.buckets))]
+	if b.slot != slot {
+		*b = bucket{slot: slot}
+	}
+	b.total++
+	if bad {
+		b.bad++
+	}
+}
+
+// sum returns the counts of the buckets within d of now, the current,
+// partial one included.
+func (r *ring) sum(now time.Time, d time.Duration) (total, bad uint64) {
+	cur := r.slot(now)
+	n := min(int64((d+r.width-1)/r.width), int64(len(r.buckets)))
+	for _, b := range r.buckets {
+		if b.slot > cur-n && b.slot <= cur {
+			total += b.total
+			bad += b.bad
+		}
+	}
+	return total, bad
+}
@@ -0,0 +1,77 @@
+package slo
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/GoogleCloudPlatform/microservices-demo/src/shared/metrics"
+)
+
+// PrometheusRules renders a Prometheus rule file computing the same burn
+// rates as Check from slo_events_total: a slo:error_ratio:rate<window>
+// recording rule per window, and for each SLO a SLOErrorBudgetBurn alert
+// per burn window and a SLOErrorBudgetExhausted alert. Load it into
+// Prometheus, or a PrometheusRule resource, to alert from the scraped
+// metrics as well:
+//
+//	os.WriteFile("slo-rules.yaml", []byte(tracker.PrometheusRules()), 0o644)
+func (t *Tracker) PrometheusRules() string {
+	events := metrics.Namespace + "_slo_events_total"
+	var durations []time.Duration
+	for _, w := range t.cfg.windows {
+		durations = append(durations, w.Long, w.Short)
+	}
+	for _, s := range t.order {
+		durations = append(durations, s.Window)
+	}
+	slices.Sort(durations)
+	durations = slices.Compact(durations)
+
+	var b strings.Builder
+	b.WriteString("groups:\n- name: slo-recording\n  rules:\n")
+	for _, d := range durations {
+		w := promDuration(d)
+		fmt.Fprintf(&b, "  - record: slo:error_ratio:rate%s\n", w)
+		fmt.Fprintf(&b, "    expr: sum by (slo) (rate(%s{result=\"bad\"}[%s])) / sum by (slo) (rate(%s[%s]))\n",
+			events, w, events, w)
+	}
+	b.WriteString("- name: slo-alerts\n  rules:\n")
+	for _, s := range t.order {
+		budget := strconv.FormatFloat(1-s.Objective, 'g', 6, 64)
+		for _, w := range t.cfg.windows {
+			burn := strconv.FormatFloat(w.Burn, 'g', -1, 64)
+			b.WriteString("  - alert: SLOErrorBudgetBurn\n")
+			fmt.Fprintf(&b, "    expr: slo:error_ratio:rate%s{slo=%q} > (%s * %s) and slo:error_ratio:rate%s{slo=%q} > (%s * %s)\n",
+				promDuration(w.Long), s.Name, burn, budget, promDuration(w.Short), s.Name, burn, budget)
+			fmt.Fprintf(&b, "    labels:\n      severity: %s\n      slo: %q\n      window: %s\n", w.Severity, s.Name, w.Name())
+			fmt.Fprintf(&b, "    annotations:\n      summary: %q\n",
+				fmt.Sprintf("%s is burning its error budget %sx too fast over %s", s.Name, burn, promDuration(w.Long)))
+		}
+		b.WriteString("  - alert: SLOErrorBudgetExhausted\n")
+		fmt.Fprintf(&b, "    expr: slo:error_ratio:rate%s{slo=%q} >= %s\n", promDuration(s.Window), s.Name, budget)
+		fmt.Fprintf(&b, "    labels:\n      severity: page\n      slo: %q\n", s.Name)
+		fmt.Fprintf(&b, "    annotations:\n      summary: %q\n",
+			fmt.Sprintf("%s has spent its error budget for the last %s", s.Name, promDuration(s.Window)))
+	}
+	return b.String()
+}
+
+// promDuration formats d the way Prometheus reads durations, in the
+// largest unit dividing it: 5m, 6h, 28d.
+func promDuration(d time.Duration) string {
+	const day = 24 * time.Hour
+	switch {
+	case d >= day && d%day == 0:
+		return strconv.FormatInt(int64(d/day), 10) + "d"
+	case d >= time.Hour && d%time.Hour == 0:
+		return strconv.FormatInt(int64(d/time.Hour), 10) + "h"
+	case d >= time.Minute && d%time.Minute == 0:
+		return strconv.FormatInt(int64(d/time.Minute), 10) + "m"
+	case d >= time.Second && d%time.Second == 0:
+		return strconv.FormatInt(int64(d/time.Second), 10) + "s"
+	}
+	return strconv.FormatInt(d.Milliseconds(), 10) + "ms"
+}
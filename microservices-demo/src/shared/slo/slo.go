@@ -0,0 +1,296 @@
+// Package slo lets a service declare its service level objectives in code
+// and tracks them: every request is counted as good or bad against each SLO
+// it falls under, the counts are exported as Prometheus metrics, and an
+// in-process check computes error budget burn rates over the multiwindow,
+// multi-burn-rate scheme of the SRE workbook, so the service can raise
+// alerts, and optionally fail readiness, without waiting for a Prometheus
+// rule evaluation.
+//
+//	tracker, err := slo.New([]slo.SLO{
+//	    {Name: "checkout-availability", Objective: 0.999,
+//	        Methods: []string{"/hipstershop.CheckoutService/PlaceOrder"}},
+//	    {Name: "checkout-latency", Objective: 0.99, Latency: 500 * time.Millisecond,
+//	        Methods: []string{"/hipstershop.CheckoutService/PlaceOrder"}},
+//	}, slo.WithAlertFunc(func(ctx context.Context, a slo.Alert) {
+//	    if m, err := eventbus.NewMessage(eventbus.JSON, a); err == nil {
+//	        bus.Publish(ctx, "slo.alerts", m)
+//	    }
+//	}))
+//	srv := grpcserver.New(grpcserver.WithUnaryInterceptors(slo.UnaryServerInterceptor(tracker)))
+//	go tracker.Run(ctx)
+//
+// PrometheusRules renders the matching recording and alerting rules, so
+// dashboards and Alertmanager see the same numbers as the in-process check.
+package slo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+
+	shared "github.com/GoogleCloudPlatform/microservices-demo/src/shared"
+	apperrors "github.com/GoogleCloudPlatform/microservices-demo/src/shared/errors"
+	"github.com/GoogleCloudPlatform/microservices-demo/src/shared/metrics"
+)
+
+var (
+	eventsTotal = metrics.NewCounterVec("slo_events_total",
+		"Requests counted against an SLO, by SLO and result (good, bad).", "slo", "result")
+	objectiveGauge = metrics.NewGaugeVec("slo_objective",
+		"Target ratio of good requests, by SLO.", "slo")
+	budgetGauge = metrics.NewGaugeVec("slo_error_budget_remaining",
+		"Fraction of the error budget left over the SLO window, by SLO; negative once overspent.", "slo")
+	burnGauge = metrics.NewGaugeVec("slo_burn_rate",
+		"Error budget burn rate over a window, by SLO and window; 1 spends the budget exactly over the SLO window.", "slo", "window")
+	alertGauge = metrics.NewGaugeVec("slo_alert_firing",
+		"Whether an SLO alert is firing (1) or not (0), by SLO and alert.", "slo", "alert")
+)
+
+// DefaultWindow is the period an SLO's error budget covers unless set.
+const DefaultWindow = 28 * 24 * time.Hour
+
+// SLO is one objective: the share of requests that must be good over
+// Window.
+type SLO struct {
+	// Name identifies the SLO in metrics and alerts, e.g.
+	// "checkout-availability".
+	Name string
+	// Objective is the target ratio of good requests, e.g. 0.999.
+	Objective float64
+	// Latency makes this a latency SLO: a request is good if it took at
+	// most Latency, whatever its outcome, so "99% of calls within 500ms"
+	// is Objective 0.99, Latency 500ms. Zero makes it an availability SLO,
+	// where a request is good unless IsBad says otherwise.
+	Latency time.Duration
+	// Window is the period the error budget covers (default DefaultWindow).
+	Window time.Duration
+	// Methods lists the full gRPC method names, or HTTP paths, that
+	// UnaryServerInterceptor and Middleware count against the SLO. Empty
+	// counts every request.
+	Methods []string
+	// IsBad classifies the error of a request for an availability SLO. Nil
+	// uses ServerFault.
+	IsBad func(error) bool
+	// GateReadiness makes Checker fail while the SLO's budget is
+	// exhausted. Use it only when taking the replica out of rotation lets
+	// the others serve, e.g. a fault local to one pod.
+	GateReadiness bool
+}
+
+// ServerFault reports whether err is the service's fault rather than the
+// caller's: Unknown, DeadlineExceeded, ResourceExhausted, Internal,
+// Unavailable and DataLoss count against availability, while errors such as
+// InvalidArgument or NotFound do not. Codes are read as by package errors.
+func ServerFault(err error) bool {
+	switch apperrors.CodeOf(err) {
+	case codes.Unknown, codes.DeadlineExceeded, codes.ResourceExhausted, codes.Internal, codes.Unavailable, codes.DataLoss:
+		return true
+	}
+	return false
+}
+
+type config struct {
+	clock    shared.Clock
+	log      *slog.Logger
+	interval time.Duration
+	windows  []BurnWindow
+	onAlert  func(context.Context, Alert)
+}
+
+// Option configures a Tracker.
+type Option func(*config)
+
+// WithClock sets the clock bucketing requests and timing checks, for tests.
+func WithClock(clk shared.Clock) Option {
+	return func(c *config) { c.clock = clk }
+}
+
+// WithLogger sets the logger alerts are logged to (default slog.Default()).
+func WithLogger(l *slog.Logger) Option {
+	return func(c *config) { c.log = l }
+}
+
+// WithCheckInterval sets how often Run evaluates the SLOs (default 30s).
+func WithCheckInterval(d time.Duration) Option {
+	return func(c *config) {
+		if d > 0 {
+			c.interval = d
+		}
+	}
+}
+
+// WithBurnWindows replaces DefaultBurnWindows.
+func WithBurnWindows(w ...BurnWindow) Option {
+	return func(c *config) { c.windows = w }
+}
+
+// WithAlertFunc calls fn whenever an alert starts or stops firing, e.g. to
+// publish it as an event. fn runs on the checking goroutine and should not
+// block.
+func WithAlertFunc(fn func(context.Context, Alert)) Option {
+	return func(c *config) { c.onAlert = fn }
+}
+
+// Tracker counts requests against a set of SLOs and checks their error
+// budgets.
+type Tracker struct {
+	cfg  config
+	slos map[string]*tracked
+	// order is the declaration order, for Status and the rules.
+	order []*tracked
+}
+
+// tracked is the state of one SLO.
+type tracked struct {
+	SLO
+	good, bad func() // metric increments, resolved once
+
+	mu      sync.Mutex
+	minutes *ring // short windows
+	hours   *ring // long windows, up to the SLO window
+	firing  map[string]bool
+}
+
+// New returns a Tracker for slos, which must have unique names and
+// objectives strictly between 0 and 1.
+func New(slos []SLO, opts ...Option) (*Tracker, error) {
+	cfg := config{log: slog.Default(), interval: 30 * time.Second, windows: DefaultBurnWindows}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	if cfg.clock == nil {
+		cfg.clock = shared.RealClock()
+	}
+	t := &Tracker{cfg: cfg, slos: make(map[string]*tracked, len(slos))}
+	var errs []error
+	for _, s := range slos {
+		switch {
+		case s.Name == "":
+			errs = append(errs, errors.New("slo: SLO without a name"))
+			continue
+		case t.slos[s.Name] != nil:
+			errs = append(errs, fmt.Errorf("slo: %s declared twice", s.Name))
+			continue
+		case s.Objective <= 0 || s.Objective >= 1:
+			errs = append(errs, fmt.Errorf("slo: %s: objective %v is not between 0 and 1", s.Name, s.Objective))
+			continue
+		}
+		if s.Window <= 0 {
+			s.Window = DefaultWindow
+		}
+		if s.IsBad == nil {
+			s.IsBad = ServerFault
+		}
+		good, bad := eventsTotal.WithLabelValues(s.Name, "good"), eventsTotal.WithLabelValues(s.Name, "bad")
+		ts := &tracked{
+			SLO:     s,
+			good:    good.Inc,
+			bad:     bad.Inc,
+			minutes: newRing(time.Minute, shortSpan),
+			hours:   newRing(time.Hour, s.Window),
+			firing:  make(map[string]bool),
+		}
+		t.slos[s.Name] = ts
+		t.order = append(t.order, ts)
+		objectiveGauge.WithLabelValues(s.Name).Set(s.Objective)
+		budgetGauge.WithLabelValues(s.Name).Set(1)
+	}
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// Record counts a request against the SLO name, taking d and failing with
+// err (nil for success). Unknown names are ignored.
+func (t *Tracker) Record(name string, d time.Duration, err error) {
+	if s := t.slos[name]; s != nil {
+		t.record(s, d, err)
+	}
+}
+
+func (t *Tracker) record(s *tracked, d time.Duration, err error) {
+	bad := d > s.Latency
+	if s.Latency == 0 {
+		bad = err != nil && s.IsBad(err)
+	}
+	if bad {
+		s.bad()
+	} else {
+		s.good()
+	}
+	now := t.cfg.clock.Now()
+	s.mu.Lock()
+	s.minutes.add(now, bad)
+	s.hours.add(now, bad)
+	s.mu.Unlock()
+}
+
+// recordMethod counts a request against every SLO covering method.
+func (t *Tracker) recordMethod(method string, d time.Duration, err error) {
+	for _, s := range t.order {
+		if len(s.Methods) == 0 || slices.Contains(s.Methods, method) {
+			t.record(s, d, err)
+		}
+	}
+}
+
+// UnaryServerInterceptor counts every call against the SLOs covering its
+// method.
+func UnaryServerInterceptor(t *Tracker) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		start := t.cfg.clock.Now()
+		resp, err := handler(ctx, req)
+		t.recordMethod(info.FullMethod, t.cfg.clock.Now().Sub(start), err)
+		return resp, err
+	}
+}
+
+// errServerStatus stands for a 5xx response in an SLO's IsBad.
+var errServerStatus = apperrors.Internal("HTTP_SERVER_ERROR", "slo: server error status")
+
+// Middleware counts every HTTP request against the SLOs covering its URL
+// path. A response with a 5xx status reaches IsBad as an Internal error;
+// other statuses count as successes.
+func Middleware(t *Tracker, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := t.cfg.clock.Now()
+		sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(sw, r)
+		var err error
+		if sw.status >= 500 {
+			err = errServerStatus
+		}
+		t.recordMethod(r.URL.Path, t.cfg.clock.Now().Sub(start), err)
+	})
+}
+
+// statusWriter remembers the status code of a response.
+type statusWriter struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+}
+
+func (w *statusWriter) WriteHeader(code int) {
+	if !w.wroteHeader {
+		w.status, w.wroteHeader = code, true
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *statusWriter) Write(b []byte) (int, error) {
+	w.wroteHeader = true
+	return w.ResponseWriter.Write(b)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (w *statusWriter) Unwrap() http.ResponseWriter { return w.ResponseWriter }
@@ -0,0 +1,206 @@
+package slo
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	shared "github.com/GoogleCloudPlatform/microservices-demo/src/shared"
+)
+
+var quiet = slog.New(slog.NewTextHandler(io.Discard, nil))
+
+func newTracker(t *testing.T, slos []SLO, opts ...Option) (*Tracker, *shared.FakeClock) {
+	t.Helper()
+	clk := shared.NewFakeClock(time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC))
+	tr, err := New(slos, append([]Option{WithClock(clk), WithLogger(quiet)}, opts...)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tr, clk
+}
+
+func TestNewValidates(t *testing.T) {
+	_, err := New([]SLO{
+		{Name: "ok", Objective: 0.99},
+		{Name: "ok", Objective: 0.99},
+		{Name: "", Objective: 0.99},
+		{Name: "perfect", Objective: 1},
+	})
+	if err == nil {
+		t.Fatal("New accepted invalid SLOs")
+	}
+	for _, want := range []string{"declared twice", "without a name", "perfect"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error %q does not mention %q", err, want)
+		}
+	}
+}
+
+func TestServerFault(t *testing.T) {
+	for err, want := range map[error]bool{
+		status.Error(codes.Unavailable, "down"):    true,
+		context.DeadlineExceeded:                   true,
+		errors.New("boom"):                         true,
+		status.Error(codes.NotFound, "no product"): false,
+		status.Error(codes.InvalidArgument, "bad"): false,
+	} {
+		if got := ServerFault(err); got != want {
+			t.Errorf("ServerFault(%v) = %v, want %v", err, got, want)
+		}
+	}
+}
+
+func TestBurnRateAlerts(t *testing.T) {
+	var alerts []Alert
+	tr, clk := newTracker(t, []SLO{{Name: "checkout", Objective: 0.99, GateReadiness: true}},
+		WithAlertFunc(func(_ context.Context, a Alert) { alerts = append(alerts, a) }))
+	ctx := context.Background()
+	check := tr.Checker()
+
+	for range 990 {
+		tr.Record("checkout", time.Millisecond, nil)
+	}
+	for range 5 {
+		tr.Record("checkout", time.Millisecond, status.Error(codes.NotFound, "no such order"))
+	}
+	st := tr.Check(ctx)[0]
+	if st.Total != 995 || st.Bad != 0 || st.BudgetRemaining != 1 || len(alerts) != 0 {
+		t.Fatalf("healthy SLO: %+v, alerts %v", st, alerts)
+	}
+
+	// 200 server errors among about 2000 requests burn the 1% budget ten
+	// times too fast over every window.
+	clk.Advance(time.Minute)
+	for i := range 1000 {
+		var err error
+		if i%5 == 0 {
+			err = status.Error(codes.Unavailable, "payment down")
+		}
+		tr.Record("checkout", time.Millisecond, err)
+	}
+	st = tr.Check(ctx)[0]
+	if r := st.BurnRates["5m"]; r < 10 || r > 11 {
+		t.Errorf("5m burn rate = %v, want about 10", r)
+	}
+	if !st.Exhausted() {
+		t.Errorf("budget remaining %v, want exhausted", st.BudgetRemaining)
+	}
+	var names []string
+	for _, a := range alerts {
+		if !a.Firing {
+			t.Errorf("alert %s resolved before it fired", a.Name)
+		}
+		names = append(names, a.Name)
+	}
+	// A burn of 10 trips the 6h, 1d and 3d windows but not 1h/5m's 14.4.
+	want := []string{"6h/30m", "1d/2h", "3d/6h", "budget"}
+	if !slices.Equal(names, want) {
+		t.Errorf("alerts fired = %v, want %v", names, want)
+	}
+	if err := check(ctx); err == nil || !strings.Contains(err.Error(), "checkout") {
+		t.Errorf("readiness check = %v, want the exhausted budget", err)
+	}
+	if got, _ := tr.Status("checkout"); !slices.Equal(got.Firing, want) {
+		t.Errorf("Status().Firing = %v, want %v", got.Firing, want)
+	}
+
+	// Once the window has passed, everything resolves.
+	alerts = nil
+	clk.Advance(DefaultWindow + time.Hour)
+	st = tr.Check(ctx)[0]
+	if st.Total != 0 || len(st.Firing) != 0 || len(alerts) != len(want) {
+		t.Errorf("after the window: %+v, alerts %v", st, alerts)
+	}
+	if err := check(ctx); err != nil {
+		t.Errorf("readiness check after recovery = %v", err)
+	}
+	if _, err := tr.Status("unknown"); !errors.Is(err, ErrUnknownSLO) {
+		t.Errorf("Status(unknown) = %v, want ErrUnknownSLO", err)
+	}
+}
+
+func TestLatencySLO(t *testing.T) {
+	tr, _ := newTracker(t, []SLO{{Name: "latency", Objective: 0.9, Latency: 100 * time.Millisecond}})
+	tr.Record("latency", 50*time.Millisecond, nil)
+	tr.Record("latency", 50*time.Millisecond, status.Error(codes.Internal, "fast failure"))
+	tr.Record("latency", 200*time.Millisecond, nil)
+	tr.Record("nonexistent", time.Hour, nil)
+	st, err := tr.Status("latency")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st.Total != 3 || st.Bad != 1 {
+		t.Errorf("total, bad = %d, %d; want 3, 1", st.Total, st.Bad)
+	}
+}
+
+func TestInterceptorAndMiddleware(t *testing.T) {
+	tr, _ := newTracker(t, []SLO{
+		{Name: "orders", Objective: 0.99, Methods: []string{"/shop.Checkout/PlaceOrder", "/cart/checkout"}},
+		{Name: "all", Objective: 0.99},
+	})
+	intercept := UnaryServerInterceptor(tr)
+	for _, method := range []string{"/shop.Checkout/PlaceOrder", "/shop.Catalog/ListProducts"} {
+		intercept(context.Background(), nil, &grpc.UnaryServerInfo{FullMethod: method},
+			func(context.Context, any) (any, error) { return nil, status.Error(codes.Unavailable, "down") })
+	}
+	h := Middleware(tr, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/cart/checkout" {
+			w.WriteHeader(http.StatusBadGateway)
+		}
+	}))
+	for _, path := range []string{"/cart/checkout", "/"} {
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}
+	for name, want := range map[string][2]uint64{"orders": {2, 2}, "all": {4, 3}} {
+		st, _ := tr.Status(name)
+		if st.Total != want[0] || st.Bad != want[1] {
+			t.Errorf("%s: total, bad = %d, %d; want %d, %d", name, st.Total, st.Bad, want[0], want[1])
+		}
+	}
+}
+
+func TestPrometheusRules(t *testing.T) {
+	tr, _ := newTracker(t, []SLO{{Name: "checkout", Objective: 0.999}},
+		WithBurnWindows(BurnWindow{Long: time.Hour, Short: 5 * time.Minute, Burn: 14.4, Severity: "page"}))
+	got := tr.PrometheusRules()
+	for _, want := range []string{
+		"- record: slo:error_ratio:rate5m\n",
+		`expr: sum by (slo) (rate(boutique_slo_events_total{result="bad"}[1h])) / sum by (slo) (rate(boutique_slo_events_total[1h]))`,
+		"- record: slo:error_ratio:rate28d\n",
+		`expr: slo:error_ratio:rate1h{slo="checkout"} > (14.4 * 0.001) and slo:error_ratio:rate5m{slo="checkout"} > (14.4 * 0.001)`,
+		"      window: 1h/5m\n",
+		`expr: slo:error_ratio:rate28d{slo="checkout"} >= 0.001`,
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("rules lack %q:\n%s", want, got)
+		}
+	}
+}
+
+func TestPromDuration(t *testing.T) {
+	for d, want := range map[time.Duration]string{
+		5 * time.Minute:        "5m",
+		90 * time.Minute:       "90m",
+		6 * time.Hour:          "6h",
+		DefaultWindow:          "28d",
+		30 * time.Second:       "30s",
+		250 * time.Millisecond: "250ms",
+	} {
+		if got := promDuration(d); got != want {
+			t.Errorf("promDuration(%v) = %q, want %q", d, got, want)
+		}
+	}
+}
@@ -0,0 +1,162 @@
+package shared
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel/attribute"
+	otelcodes "go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+
+	apperrors "github.com/GoogleCloudPlatform/microservices-demo/src/shared/errors"
+	"github.com/GoogleCloudPlatform/microservices-demo/src/shared/metrics"
+)
+
+var spanEventsTotal = metrics.NewCounterVec("span_events_total",
+	"Domain events recorded on spans, by event.", "event")
+
+// SamplingPriorityKey is the span attribute carrying a SamplingPriority.
+// Point a numeric_attribute policy of the collector's tail sampler at it:
+//
+//	policies:
+//	  - name: keep-prioritized
+//	    type: numeric_attribute
+//	    numeric_attribute: {key: sampling.priority, min_value: 1}
+const SamplingPriorityKey = attribute.Key("sampling.priority")
+
+// SamplingPriority hints to a tail sampler whether a trace is worth
+// keeping.
+type SamplingPriority int
+
+// Sampling priorities. A trace is kept if any of its spans asks for it.
+const (
+	// SamplingPriorityAuto leaves the decision to the sampler's other
+	// policies.
+	SamplingPriorityAuto SamplingPriority = 0
+	// SamplingPriorityKeep asks for the trace to be kept, e.g. because it
+	// shows a declined payment.
+	SamplingPriorityKeep SamplingPriority = 1
+	// SamplingPriorityDebug asks for the trace to be kept while debugging a
+	// request, such as one sent with a debug header.
+	SamplingPriorityDebug SamplingPriority = 2
+)
+
+// SetSamplingPriority sets the sampling priority of the span in ctx. The
+// span must have been sampled by the head sampler to reach the tail
+// sampler at all, so this raises a trace's odds of being kept but cannot
+// rescue one dropped at its root.
+func SetSamplingPriority(ctx context.Context, p SamplingPriority) {
+	trace.SpanFromContext(ctx).SetAttributes(SamplingPriorityKey.Int(int(p)))
+}
+
+// MarkSpanError records err on the span in ctx and sets the span's status
+// to error, with error.type set to err's reason, or else its gRPC code
+// name (see package errors), and rpc.grpc.status_code to the code, so the
+// tail sampler's status_code policy keeps the trace and its spans can be
+// grouped by cause. It returns err, to use in return statements:
+//
+//	if err := charge(ctx, card); err != nil {
+//	    return nil, shared.MarkSpanError(ctx, err)
+//	}
+//
+// A nil err leaves the span alone.
+func MarkSpanError(ctx context.Context, err error) error {
+	if err == nil {
+		return nil
+	}
+	span := trace.SpanFromContext(ctx)
+	if !span.IsRecording() {
+		return err
+	}
+	e := apperrors.From(err)
+	errType := e.Reason
+	if errType == "" {
+		errType = e.Code.String()
+	}
+	span.RecordError(err)
+	span.SetStatus(otelcodes.Error, err.Error())
+	span.SetAttributes(
+		attribute.String("error.type", errType),
+		attribute.Int("rpc.grpc.status_code", int(e.Code)),
+	)
+	return err
+}
+
+// Domain events recorded by the services. Use these names rather than ad
+// hoc ones so traces from every service can be searched the same way.
+const (
+	EventCartUpdated     = "cart_updated"
+	EventCartEmptied     = "cart_emptied"
+	EventCurrencyChanged = "currency_changed"
+	EventShippingQuoted  = "shipping_quoted"
+	EventPaymentCharged  = "payment_charged"
+	EventPaymentDeclined = "payment_declined"
+	EventOrderPlaced     = "order_placed"
+	EventOrderFailed     = "order_failed"
+)
+
+type spanEventConfig struct {
+	keep map[string]bool
+}
+
+// SpanEventOption configures a SpanEventRecorder.
+type SpanEventOption func(*spanEventConfig)
+
+// WithSpanEventKeep makes recording any of the named events set the
+// span's sampling priority to SamplingPriorityKeep, replacing the default
+// set of EventPaymentDeclined and EventOrderFailed.
+func WithSpanEventKeep(names ...string) SpanEventOption {
+	return func(c *spanEventConfig) {
+		c.keep = make(map[string]bool, len(names))
+		for _, n := range names {
+			c.keep[n] = true
+		}
+	}
+}
+
+// SpanEventRecorder adds domain events to the span in the context, counts
+// them in span_events_total, and marks the traces of the interesting ones
+// for the tail sampler to keep:
+//
+//	events := shared.NewSpanEventRecorder()
+//	events.Record(ctx, shared.EventPaymentDeclined,
+//	    attribute.String("payment.reason", reason),
+//	    attribute.String("card.type", cardType))
+//
+// Attributes must not carry personal data such as card numbers or
+// addresses; traces are kept longer and seen more widely than the
+// services' databases.
+type SpanEventRecorder struct {
+	cfg spanEventConfig
+}
+
+// NewSpanEventRecorder returns a recorder keeping the traces of
+// EventPaymentDeclined and EventOrderFailed unless overridden.
+func NewSpanEventRecorder(opts ...SpanEventOption) *SpanEventRecorder {
+	cfg := spanEventConfig{keep: map[string]bool{EventPaymentDeclined: true, EventOrderFailed: true}}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	return &SpanEventRecorder{cfg: cfg}
+}
+
+// Record adds the event name with attrs to the span in ctx. The event is
+// counted even when the span is not recording.
+func (r *SpanEventRecorder) Record(ctx context.Context, name string, attrs ...attribute.KeyValue) {
+	spanEventsTotal.WithLabelValues(name).Inc()
+	span := trace.SpanFromContext(ctx)
+	if !span.IsRecording() {
+		return
+	}
+	span.AddEvent(name, trace.WithAttributes(attrs...))
+	if r.cfg.keep[name] {
+		span.SetAttributes(SamplingPriorityKey.Int(int(SamplingPriorityKeep)))
+	}
+}
+
+var defaultSpanEvents = NewSpanEventRecorder()
+
+// RecordSpanEvent records the event name on the span in ctx with the
+// default SpanEventRecorder.
+func RecordSpanEvent(ctx context.Context, name string, attrs ...attribute.KeyValue) {
+	defaultSpanEvents.Record(ctx, name, attrs...)
+}
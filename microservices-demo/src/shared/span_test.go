@@ -0,0 +1,118 @@
+package shared
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"go.opentelemetry.io/otel/attribute"
+	otelcodes "go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+
+	apperrors "github.com/GoogleCloudPlatform/microservices-demo/src/shared/errors"
+)
+
+// recordSpan runs fn inside a span and returns the ended span.
+func recordSpan(t *testing.T, fn func(ctx context.Context)) sdktrace.ReadOnlySpan {
+	t.Helper()
+	rec := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(rec))
+	ctx, span := tp.Tracer("test").Start(context.Background(), "op")
+	fn(ctx)
+	span.End()
+	ended := rec.Ended()
+	if len(ended) != 1 {
+		t.Fatalf("recorded %d spans, want 1", len(ended))
+	}
+	return ended[0]
+}
+
+func spanAttrs(s sdktrace.ReadOnlySpan) map[attribute.Key]attribute.Value {
+	out := map[attribute.Key]attribute.Value{}
+	for _, a := range s.Attributes() {
+		out[a.Key] = a.Value
+	}
+	return out
+}
+
+func TestMarkSpanError(t *testing.T) {
+	tests := []struct {
+		err      error
+		wantType string
+		wantCode int64
+	}{
+		{apperrors.FailedPrecondition("CARD_DECLINED", "card declined"), "CARD_DECLINED", 9},
+		{context.DeadlineExceeded, "DeadlineExceeded", 4},
+		{errors.New("boom"), "Unknown", 2},
+	}
+	for _, tt := range tests {
+		var got error
+		s := recordSpan(t, func(ctx context.Context) { got = MarkSpanError(ctx, tt.err) })
+		if got != tt.err {
+			t.Errorf("MarkSpanError returned %v, want %v", got, tt.err)
+		}
+		if s.Status().Code != otelcodes.Error {
+			t.Errorf("%v: status %v, want Error", tt.err, s.Status().Code)
+		}
+		attrs := spanAttrs(s)
+		if v := attrs["error.type"].AsString(); v != tt.wantType {
+			t.Errorf("%v: error.type = %q, want %q", tt.err, v, tt.wantType)
+		}
+		if v := attrs["rpc.grpc.status_code"].AsInt64(); v != tt.wantCode {
+			t.Errorf("%v: rpc.grpc.status_code = %d, want %d", tt.err, v, tt.wantCode)
+		}
+		if len(s.Events()) != 1 || s.Events()[0].Name != "exception" {
+			t.Errorf("%v: events %v, want one exception", tt.err, s.Events())
+		}
+	}
+
+	s := recordSpan(t, func(ctx context.Context) {
+		if err := MarkSpanError(ctx, nil); err != nil {
+			t.Errorf("MarkSpanError(nil) = %v", err)
+		}
+	})
+	if s.Status().Code != otelcodes.Unset || len(s.Attributes()) != 0 {
+		t.Errorf("nil error changed the span: %v %v", s.Status(), s.Attributes())
+	}
+	if err := MarkSpanError(context.Background(), errors.New("no span")); err == nil {
+		t.Error("MarkSpanError without a span lost the error")
+	}
+}
+
+func TestSetSamplingPriority(t *testing.T) {
+	s := recordSpan(t, func(ctx context.Context) { SetSamplingPriority(ctx, SamplingPriorityDebug) })
+	if v := spanAttrs(s)[SamplingPriorityKey].AsInt64(); v != 2 {
+		t.Errorf("sampling.priority = %d, want 2", v)
+	}
+}
+
+func TestSpanEventRecorder(t *testing.T) {
+	s := recordSpan(t, func(ctx context.Context) {
+		RecordSpanEvent(ctx, EventCartUpdated, attribute.Int("cart.items", 3))
+	})
+	if len(s.Events()) != 1 || s.Events()[0].Name != EventCartUpdated {
+		t.Fatalf("events = %v", s.Events())
+	}
+	if attrs := s.Events()[0].Attributes; len(attrs) != 1 || attrs[0].Value.AsInt64() != 3 {
+		t.Errorf("event attributes = %v", attrs)
+	}
+	if _, ok := spanAttrs(s)[SamplingPriorityKey]; ok {
+		t.Error("cart_updated raised the sampling priority")
+	}
+
+	s = recordSpan(t, func(ctx context.Context) { RecordSpanEvent(ctx, EventPaymentDeclined) })
+	if v := spanAttrs(s)[SamplingPriorityKey].AsInt64(); v != int64(SamplingPriorityKeep) {
+		t.Errorf("payment_declined: sampling.priority = %d, want %d", v, SamplingPriorityKeep)
+	}
+
+	r := NewSpanEventRecorder(WithSpanEventKeep(EventCartEmptied))
+	s = recordSpan(t, func(ctx context.Context) { r.Record(ctx, EventPaymentDeclined) })
+	if _, ok := spanAttrs(s)[SamplingPriorityKey]; ok {
+		t.Error("payment_declined raised the priority outside the custom keep set")
+	}
+	s = recordSpan(t, func(ctx context.Context) { r.Record(ctx, EventCartEmptied) })
+	if v := spanAttrs(s)[SamplingPriorityKey].AsInt64(); v != int64(SamplingPriorityKeep) {
+		t.Errorf("cart_emptied: sampling.priority = %d, want %d", v, SamplingPriorityKeep)
+	}
+}
@@ -0,0 +1,127 @@
+// Package spiffe gives services a SPIFFE workload identity without a
+// service mesh: Source streams X.509 SVIDs and trust bundles from the SPIFFE
+// Workload API served by a SPIRE agent, following every rotation, and ID,
+// Allowlist and Verify check the identity of peers presenting SVIDs.
+//
+// The TLS helpers in package shared use a Source through
+// shared.WithSPIFFE, or from the environment with shared.TLSOptionsFromEnv:
+//
+//	SPIFFE_ENDPOINT_SOCKET  Workload API address, e.g. unix:///run/spire/sockets/agent.sock
+//	SPIFFE_ALLOWED_IDS      comma-separated peer allowlist (see Allowlist)
+package spiffe
+
+import (
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// ErrNotAllowed is returned when a peer's ID is not on the allowlist.
+var ErrNotAllowed = errors.New("spiffe: peer ID not allowed")
+
+// ID is a SPIFFE ID such as spiffe://cluster.local/ns/default/sa/frontend.
+type ID struct {
+	TrustDomain string
+	// Path is empty or starts with a slash.
+	Path string
+}
+
+// ParseID parses a SPIFFE ID.
+func ParseID(s string) (ID, error) {
+	u, err := url.Parse(s)
+	if err != nil {
+		return ID{}, fmt.Errorf("spiffe: invalid ID %q: %w", s, err)
+	}
+	switch {
+	case u.Scheme != "spiffe":
+		return ID{}, fmt.Errorf("spiffe: invalid ID %q: scheme must be spiffe", s)
+	case u.Host == "" || u.Host != strings.ToLower(u.Host) || u.Port() != "":
+		return ID{}, fmt.Errorf("spiffe: invalid ID %q: trust domain must be a lower-case name", s)
+	case u.User != nil || u.RawQuery != "" || u.Fragment != "" || u.Opaque != "":
+		return ID{}, fmt.Errorf("spiffe: invalid ID %q: no user, query or fragment allowed", s)
+	case strings.HasSuffix(u.Path, "/") || strings.Contains(u.Path, "//"):
+		return ID{}, fmt.Errorf("spiffe: invalid ID %q: empty path segment", s)
+	}
+	return ID{TrustDomain: u.Host, Path: u.Path}, nil
+}
+
+func (id ID) String() string {
+	return "spiffe://" + id.TrustDomain + id.Path
+}
+
+// IsZero reports whether id is the zero ID.
+func (id ID) IsZero() bool { return id.TrustDomain == "" }
+
+// IDFromCert returns the SPIFFE ID in the single URI SAN of an SVID.
+func IDFromCert(cert *x509.Certificate) (ID, error) {
+	if len(cert.URIs) != 1 {
+		return ID{}, fmt.Errorf("spiffe: certificate has %d URI SANs, want exactly one SPIFFE ID", len(cert.URIs))
+	}
+	return ParseID(cert.URIs[0].String())
+}
+
+// Verify checks that certs, leaf first, form an X.509 SVID chaining up to
+// bundle and returns its ID. Host names play no part: SVIDs are verified by
+// identity, not address.
+func Verify(certs []*x509.Certificate, bundle *x509.CertPool) (ID, error) {
+	if len(certs) == 0 {
+		return ID{}, errors.New("spiffe: peer presented no certificate")
+	}
+	if bundle == nil {
+		return ID{}, errors.New("spiffe: no trust bundle")
+	}
+	leaf := certs[0]
+	if leaf.IsCA {
+		return ID{}, errors.New("spiffe: peer certificate is a CA")
+	}
+	id, err := IDFromCert(leaf)
+	if err != nil {
+		return ID{}, err
+	}
+	inter := x509.NewCertPool()
+	for _, c := range certs[1:] {
+		inter.AddCert(c)
+	}
+	if _, err := leaf.Verify(x509.VerifyOptions{
+		Roots:         bundle,
+		Intermediates: inter,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	}); err != nil {
+		return ID{}, fmt.Errorf("spiffe: verifying %s: %w", id, err)
+	}
+	return id, nil
+}
+
+// Allowlist admits peers by SPIFFE ID. An entry with a path admits exactly
+// that ID; an entry naming only a trust domain, such as
+// "spiffe://cluster.local", admits every ID in it.
+type Allowlist []ID
+
+// ParseAllowlist parses allowlist entries, skipping empty ones.
+func ParseAllowlist(entries ...string) (Allowlist, error) {
+	var a Allowlist
+	for _, e := range entries {
+		if e = strings.TrimSpace(e); e == "" {
+			continue
+		}
+		id, err := ParseID(e)
+		if err != nil {
+			return nil, err
+		}
+		a = append(a, id)
+	}
+	return a, nil
+}
+
+// Allow returns nil if id is on the list, or an error wrapping
+// ErrNotAllowed.
+func (a Allowlist) Allow(id ID) error {
+	for _, e := range a {
+		if e.TrustDomain == id.TrustDomain && (e.Path == "" || e.Path == id.Path) {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %s", ErrNotAllowed, id)
+}
@@ -0,0 +1,35 @@
+package spiffe
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+)
+
+var errNoPeerCert = errors.New("spiffe: connection is not authenticated with TLS client certificates")
+
+// PeerIDFromContext returns the SPIFFE ID of the client of a gRPC call
+// served over mutual TLS.
+func PeerIDFromContext(ctx context.Context) (ID, error) {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return ID{}, errNoPeerCert
+	}
+	info, ok := p.AuthInfo.(credentials.TLSInfo)
+	if !ok || len(info.State.PeerCertificates) == 0 {
+		return ID{}, errNoPeerCert
+	}
+	return IDFromCert(info.State.PeerCertificates[0])
+}
+
+// PeerIDFromRequest returns the SPIFFE ID of the client of an HTTP request
+// served over mutual TLS.
+func PeerIDFromRequest(r *http.Request) (ID, error) {
+	if r.TLS == nil || len(r.TLS.PeerCertificates) == 0 {
+		return ID{}, errNoPeerCert
+	}
+	return IDFromCert(r.TLS.PeerCertificates[0])
+}
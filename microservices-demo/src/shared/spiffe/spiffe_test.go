@@ -0,0 +1,246 @@
+package spiffe
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"io"
+	"log/slog"
+	"math/big"
+	"net"
+	"net/url"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+var quiet = slog.New(slog.NewTextHandler(io.Discard, nil))
+
+func TestParseID(t *testing.T) {
+	id, err := ParseID("spiffe://cluster.local/ns/default/sa/frontend")
+	if err != nil || id.TrustDomain != "cluster.local" || id.Path != "/ns/default/sa/frontend" {
+		t.Fatalf("ParseID = %+v, %v", id, err)
+	}
+	if id.String() != "spiffe://cluster.local/ns/default/sa/frontend" {
+		t.Errorf("String = %s", id)
+	}
+	for _, bad := range []string{
+		"https://cluster.local/x",
+		"spiffe:///x",
+		"spiffe://Cluster.local/x",
+		"spiffe://cluster.local:8080/x",
+		"spiffe://cluster.local/x/",
+		"spiffe://cluster.local/x?y=1",
+	} {
+		if _, err := ParseID(bad); err == nil {
+			t.Errorf("ParseID(%q) succeeded", bad)
+		}
+	}
+}
+
+func TestAllowlist(t *testing.T) {
+	a, err := ParseAllowlist("spiffe://cluster.local/ns/default/sa/frontend", " ", "spiffe://partner.example")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for id, want := range map[string]bool{
+		"spiffe://cluster.local/ns/default/sa/frontend": true,
+		"spiffe://cluster.local/ns/default/sa/checkout": false,
+		"spiffe://partner.example/anything":             true,
+	} {
+		parsed, _ := ParseID(id)
+		if err := a.Allow(parsed); (err == nil) != want {
+			t.Errorf("Allow(%s) = %v, want allowed %v", id, err, want)
+		}
+	}
+	if err := a.Allow(ID{TrustDomain: "evil.example"}); !errors.Is(err, ErrNotAllowed) {
+		t.Errorf("Allow of another domain = %v, want ErrNotAllowed", err)
+	}
+}
+
+// testCA issues SVIDs for one trust domain.
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+func newTestCA(t *testing.T) *testCA {
+	t.Helper()
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "spire"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	return &testCA{cert: cert, key: key}
+}
+
+// issue returns the DER certificate and PKCS#8 key of an SVID for id.
+func (ca *testCA) issue(t *testing.T, id string, serial int64) (certDER, keyDER []byte) {
+	t.Helper()
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	u, _ := url.Parse(id)
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		URIs:         []*url.URL{u},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	certDER, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, _ = x509.MarshalPKCS8PrivateKey(key)
+	return certDER, keyDER
+}
+
+func TestVerify(t *testing.T) {
+	ca, other := newTestCA(t), newTestCA(t)
+	der, _ := ca.issue(t, "spiffe://cluster.local/ns/default/sa/frontend", 2)
+	leaf, _ := x509.ParseCertificate(der)
+	bundle := x509.NewCertPool()
+	bundle.AddCert(ca.cert)
+	if id, err := Verify([]*x509.Certificate{leaf}, bundle); err != nil || id.Path != "/ns/default/sa/frontend" {
+		t.Errorf("Verify = %v, %v", id, err)
+	}
+	otherBundle := x509.NewCertPool()
+	otherBundle.AddCert(other.cert)
+	if _, err := Verify([]*x509.Certificate{leaf}, otherBundle); err == nil {
+		t.Error("Verify accepted an SVID from another CA")
+	}
+	if _, err := Verify([]*x509.Certificate{ca.cert}, bundle); err == nil {
+		t.Error("Verify accepted a CA certificate")
+	}
+}
+
+// x509SVIDResponse encodes a Workload API update.
+func x509SVIDResponse(id string, cert, key, bundle []byte) []byte {
+	var svid []byte
+	svid = protowire.AppendTag(svid, 1, protowire.BytesType)
+	svid = protowire.AppendString(svid, id)
+	svid = protowire.AppendTag(svid, 2, protowire.BytesType)
+	svid = protowire.AppendBytes(svid, cert)
+	svid = protowire.AppendTag(svid, 3, protowire.BytesType)
+	svid = protowire.AppendBytes(svid, key)
+	svid = protowire.AppendTag(svid, 4, protowire.BytesType)
+	svid = protowire.AppendBytes(svid, bundle)
+	svid = protowire.AppendTag(svid, 5, protowire.BytesType)
+	svid = protowire.AppendString(svid, "internal")
+	var resp []byte
+	resp = protowire.AppendTag(resp, 1, protowire.BytesType)
+	return protowire.AppendBytes(resp, svid)
+}
+
+// fakeAgent serves the Workload API on a unix socket, streaming every
+// update sent on its channel.
+func fakeAgent(t *testing.T, updates <-chan []byte) string {
+	t.Helper()
+	socket := filepath.Join(t.TempDir(), "agent.sock")
+	lis, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := grpc.NewServer(grpc.ForceServerCodec(rawCodec{}), grpc.UnknownServiceHandler(func(_ any, stream grpc.ServerStream) error {
+		if m, _ := grpc.MethodFromServerStream(stream); m != fetchX509SVID {
+			return status.Errorf(codes.Unimplemented, "unexpected method %s", m)
+		}
+		md, _ := metadata.FromIncomingContext(stream.Context())
+		if v := md.Get("workload.spiffe.io"); len(v) != 1 || v[0] != "true" {
+			return status.Error(codes.InvalidArgument, "security header missing from request")
+		}
+		var req []byte
+		if err := stream.RecvMsg(&req); err != nil {
+			return err
+		}
+		for {
+			select {
+			case u := <-updates:
+				if err := stream.SendMsg(u); err != nil {
+					return err
+				}
+			case <-stream.Context().Done():
+				return nil
+			}
+		}
+	}))
+	go srv.Serve(lis)
+	t.Cleanup(srv.Stop)
+	return "unix://" + socket
+}
+
+func TestSourceFollowsRotation(t *testing.T) {
+	ca := newTestCA(t)
+	updates := make(chan []byte, 1)
+	socket := fakeAgent(t, updates)
+	const id = "spiffe://cluster.local/ns/default/sa/frontend"
+
+	cert, key := ca.issue(t, id, 2)
+	updates <- x509SVIDResponse(id, cert, key, ca.cert.Raw)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	src, err := NewSource(ctx, SourceConfig{Socket: socket, Logger: quiet})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer src.Close()
+	if src.ID().String() != id {
+		t.Errorf("ID = %s", src.ID())
+	}
+	svid, _ := src.X509SVID()
+	if svid.Leaf.SerialNumber.Int64() != 2 {
+		t.Fatalf("serial = %d, want 2", svid.Leaf.SerialNumber.Int64())
+	}
+	if _, err := Verify([]*x509.Certificate{svid.Leaf}, src.X509Bundle()); err != nil {
+		t.Errorf("SVID does not verify against the bundle: %v", err)
+	}
+
+	// A malformed update is skipped; the next good one replaces the SVID.
+	updates <- []byte{0xff}
+	cert, key = ca.issue(t, id, 3)
+	updates <- x509SVIDResponse(id, cert, key, ca.cert.Raw)
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		svid, _ := src.X509SVID()
+		if svid.Leaf.SerialNumber.Int64() == 3 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("rotated SVID never arrived")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestNewSourceWithoutAgent(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	socket := "unix://" + filepath.Join(t.TempDir(), "missing.sock")
+	if _, err := NewSource(ctx, SourceConfig{Socket: socket, Logger: quiet}); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("NewSource without an agent = %v, want DeadlineExceeded", err)
+	}
+	t.Setenv(EndpointSocketEnv, "")
+	if _, err := NewSource(context.Background(), SourceConfig{}); err == nil {
+		t.Error("NewSource without an address succeeded")
+	}
+}
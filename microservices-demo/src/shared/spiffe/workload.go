@@ -0,0 +1,362 @@
+package spiffe
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/encoding/protowire"
+
+	"github.com/GoogleCloudPlatform/microservices-demo/src/shared/metrics"
+)
+
+// EndpointSocketEnv names the variable holding the Workload API address,
+// as read by every SPIFFE library.
+const EndpointSocketEnv = "SPIFFE_ENDPOINT_SOCKET"
+
+// fetchX509SVID is the Workload API's streaming X.509 method.
+const fetchX509SVID = "/SpiffeWorkloadAPI/FetchX509SVID"
+
+var svidExpiry = metrics.NewGaugeVec("spiffe_svid_expiry_timestamp_seconds",
+	"Expiry of the X.509 SVID currently served, as a Unix timestamp.", "spiffe_id")
+
+// X509Source supplies the current SVID and trust bundle. Both may change
+// between calls as they are rotated.
+type X509Source interface {
+	X509SVID() (*tls.Certificate, error)
+	X509Bundle() *x509.CertPool
+}
+
+// SourceConfig configures NewSource.
+type SourceConfig struct {
+	// Socket is the Workload API address: unix:///path, a bare /path, or
+	// tcp://host:port. Empty reads SPIFFE_ENDPOINT_SOCKET.
+	Socket string
+	// Logger receives stream failures and rotations. Nil uses
+	// slog.Default().
+	Logger *slog.Logger
+}
+
+// Source is an X509Source fed by the Workload API. The agent pushes a new
+// SVID well before the old one expires, so connections made through TLS
+// configs reading the Source never see an expired certificate.
+type Source struct {
+	log    *slog.Logger
+	conn   *grpc.ClientConn
+	cancel context.CancelFunc
+	done   chan struct{}
+
+	current atomic.Pointer[x509Set]
+	ready   chan struct{} // closed on the first update
+	once    sync.Once
+
+	mu      sync.Mutex
+	lastErr error
+}
+
+// x509Set is one Workload API update.
+type x509Set struct {
+	id     ID
+	cert   *tls.Certificate
+	bundle *x509.CertPool
+}
+
+// NewSource connects to the Workload API and waits, until ctx is done, for
+// the first SVID. The Source keeps following updates until Close.
+func NewSource(ctx context.Context, cfg SourceConfig) (*Source, error) {
+	if cfg.Socket == "" {
+		cfg.Socket = os.Getenv(EndpointSocketEnv)
+	}
+	if cfg.Logger == nil {
+		cfg.Logger = slog.Default()
+	}
+	target, err := grpcTarget(cfg.Socket)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := grpc.NewClient(target, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		return nil, fmt.Errorf("spiffe: dialing the Workload API: %w", err)
+	}
+	runCtx, cancel := context.WithCancel(context.Background())
+	s := &Source{log: cfg.Logger, conn: conn, cancel: cancel, done: make(chan struct{}), ready: make(chan struct{})}
+	go s.run(runCtx)
+
+	select {
+	case <-s.ready:
+		return s, nil
+	case <-ctx.Done():
+		s.mu.Lock()
+		last := s.lastErr
+		s.mu.Unlock()
+		s.Close()
+		if last != nil {
+			return nil, fmt.Errorf("spiffe: no SVID from %s: %w (last error: %v)", cfg.Socket, ctx.Err(), last)
+		}
+		return nil, fmt.Errorf("spiffe: no SVID from %s: %w", cfg.Socket, ctx.Err())
+	}
+}
+
+// grpcTarget turns a Workload API address into a gRPC target.
+func grpcTarget(socket string) (string, error) {
+	switch {
+	case socket == "":
+		return "", fmt.Errorf("spiffe: no Workload API address; set %s", EndpointSocketEnv)
+	case strings.HasPrefix(socket, "unix://"):
+		return socket, nil
+	case strings.HasPrefix(socket, "/"):
+		return "unix://" + socket, nil
+	case strings.HasPrefix(socket, "tcp://"):
+		return "passthrough:///" + strings.TrimPrefix(socket, "tcp://"), nil
+	}
+	return "", fmt.Errorf("spiffe: unsupported Workload API address %q", socket)
+}
+
+// X509SVID returns the current SVID as a certificate chain and key.
+func (s *Source) X509SVID() (*tls.Certificate, error) {
+	return s.current.Load().cert, nil
+}
+
+// X509Bundle returns the trust bundle, including federated trust domains.
+func (s *Source) X509Bundle() *x509.CertPool {
+	return s.current.Load().bundle
+}
+
+// ID returns the workload's own SPIFFE ID.
+func (s *Source) ID() ID {
+	return s.current.Load().id
+}
+
+// Close stops following updates.
+func (s *Source) Close() error {
+	s.cancel()
+	<-s.done
+	return s.conn.Close()
+}
+
+// run follows the update stream, reconnecting with backoff when it fails.
+func (s *Source) run(ctx context.Context) {
+	defer close(s.done)
+	backoff := time.Second
+	for {
+		received, err := s.watch(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		if received {
+			backoff = time.Second
+		}
+		s.mu.Lock()
+		s.lastErr = err
+		s.mu.Unlock()
+		s.log.Warn("SPIFFE Workload API stream failed", "error", err, "retry", backoff)
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return
+		}
+		backoff = min(2*backoff, 30*time.Second)
+	}
+}
+
+// watch reads one FetchX509SVID stream, reporting whether it delivered any
+// update.
+func (s *Source) watch(ctx context.Context) (bool, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	// The agent refuses calls without this header, which proxies and
+	// browsers cannot forge.
+	ctx = metadata.AppendToOutgoingContext(ctx, "workload.spiffe.io", "true")
+	stream, err := s.conn.NewStream(ctx, &grpc.StreamDesc{ServerStreams: true}, fetchX509SVID,
+		grpc.ForceCodec(rawCodec{}), grpc.WaitForReady(true))
+	if err != nil {
+		return false, err
+	}
+	if err := stream.SendMsg([]byte{}); err != nil {
+		return false, err
+	}
+	if err := stream.CloseSend(); err != nil {
+		return false, err
+	}
+	received := false
+	for {
+		var msg []byte
+		if err := stream.RecvMsg(&msg); err != nil {
+			return received, err
+		}
+		set, err := parseX509SVIDResponse(msg)
+		if err != nil {
+			s.log.Warn("ignoring unusable SPIFFE Workload API update", "error", err)
+			continue
+		}
+		received = true
+		s.update(set)
+	}
+}
+
+func (s *Source) update(set *x509Set) {
+	prev := s.current.Swap(set)
+	leaf := set.cert.Leaf
+	if prev != nil {
+		svidExpiry.DeleteLabelValues(prev.id.String())
+	}
+	svidExpiry.WithLabelValues(set.id.String()).Set(float64(leaf.NotAfter.Unix()))
+	s.log.Info("SPIFFE SVID updated", "spiffe_id", set.id.String(), "expires", leaf.NotAfter)
+	s.once.Do(func() { close(s.ready) })
+}
+
+// parseX509SVIDResponse decodes an X509SVIDResponse, using its first SVID,
+// whose bundle and the federated ones make up the trust bundle:
+//
+//	message X509SVIDResponse {
+//	  repeated X509SVID svids = 1;
+//	  repeated bytes crl = 2;
+//	  map<string, bytes> federated_bundles = 3;
+//	}
+//	message X509SVID {
+//	  string spiffe_id = 1;
+//	  bytes x509_svid = 2;      // ASN.1 DER certificates, leaf first
+//	  bytes x509_svid_key = 3;  // PKCS#8 DER private key
+//	  bytes bundle = 4;         // ASN.1 DER CA certificates
+//	}
+func parseX509SVIDResponse(b []byte) (*x509Set, error) {
+	var svid []byte
+	var bundles [][]byte
+	err := eachField(b, func(num protowire.Number, v []byte) error {
+		switch num {
+		case 1:
+			if svid == nil {
+				svid = v
+			}
+		case 3:
+			return eachField(v, func(num protowire.Number, v []byte) error {
+				if num == 2 {
+					bundles = append(bundles, v)
+				}
+				return nil
+			})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if svid == nil {
+		return nil, errors.New("spiffe: update carries no SVID")
+	}
+	var rawID string
+	var chain, key []byte
+	err = eachField(svid, func(num protowire.Number, v []byte) error {
+		switch num {
+		case 1:
+			rawID = string(v)
+		case 2:
+			chain = v
+		case 3:
+			key = v
+		case 4:
+			bundles = append(bundles, v)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return newX509Set(rawID, chain, key, bundles)
+}
+
+func newX509Set(rawID string, chain, key []byte, bundles [][]byte) (*x509Set, error) {
+	id, err := ParseID(rawID)
+	if err != nil {
+		return nil, err
+	}
+	certs, err := x509.ParseCertificates(chain)
+	if err != nil || len(certs) == 0 {
+		return nil, fmt.Errorf("spiffe: SVID %s: parsing certificates: %v", id, err)
+	}
+	if certID, err := IDFromCert(certs[0]); err != nil || certID != id {
+		return nil, fmt.Errorf("spiffe: SVID certificate does not carry %s", id)
+	}
+	priv, err := x509.ParsePKCS8PrivateKey(key)
+	if err != nil {
+		return nil, fmt.Errorf("spiffe: SVID %s: parsing key: %w", id, err)
+	}
+	pool := x509.NewCertPool()
+	for _, b := range bundles {
+		cas, err := x509.ParseCertificates(b)
+		if err != nil {
+			return nil, fmt.Errorf("spiffe: parsing trust bundle: %w", err)
+		}
+		for _, ca := range cas {
+			pool.AddCert(ca)
+		}
+	}
+	cert := &tls.Certificate{PrivateKey: priv, Leaf: certs[0]}
+	for _, c := range certs {
+		cert.Certificate = append(cert.Certificate, c.Raw)
+	}
+	return &x509Set{id: id, cert: cert, bundle: pool}, nil
+}
+
+// eachField calls fn with the number and contents of every length-delimited
+// field in b, skipping the others.
+func eachField(b []byte, fn func(protowire.Number, []byte) error) error {
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return fmt.Errorf("spiffe: malformed Workload API message: %w", protowire.ParseError(n))
+		}
+		b = b[n:]
+		if typ != protowire.BytesType {
+			n = protowire.ConsumeFieldValue(num, typ, b)
+			if n < 0 {
+				return fmt.Errorf("spiffe: malformed Workload API message: %w", protowire.ParseError(n))
+			}
+			b = b[n:]
+			continue
+		}
+		v, n := protowire.ConsumeBytes(b)
+		if n < 0 {
+			return fmt.Errorf("spiffe: malformed Workload API message: %w", protowire.ParseError(n))
+		}
+		b = b[n:]
+		if err := fn(num, v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// rawCodec passes messages through as bytes, which the package encodes and
+// decodes with protowire instead of depending on generated code.
+type rawCodec struct{}
+
+func (rawCodec) Marshal(v any) ([]byte, error) {
+	b, ok := v.([]byte)
+	if !ok {
+		return nil, fmt.Errorf("spiffe: cannot marshal %T", v)
+	}
+	return b, nil
+}
+
+func (rawCodec) Unmarshal(data []byte, v any) error {
+	p, ok := v.(*[]byte)
+	if !ok {
+		return fmt.Errorf("spiffe: cannot unmarshal into %T", v)
+	}
+	*p = append((*p)[:0], data...)
+	return nil
+}
+
+func (rawCodec) Name() string { return "proto" }
@@ -0,0 +1,241 @@
+package shared
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/GoogleCloudPlatform/microservices-demo/src/shared/metrics"
+)
+
+// DefaultSSEHeartbeat is how often an idle event stream sends a comment
+// unless overridden with WithSSEHeartbeat. It keeps proxies and load
+// balancers, which drop connections idle for a minute or so, from cutting
+// the stream.
+const DefaultSSEHeartbeat = 15 * time.Second
+
+var (
+	sseStreams = metrics.NewGaugeVec("sse_streams",
+		"Open server-sent event streams.", "handler")
+	sseEvents = metrics.NewCounterVec("sse_events_total",
+		"Server-sent events written.", "handler")
+)
+
+// SSEEvent is one server-sent event.
+type SSEEvent struct {
+	// ID is sent back by the browser as Last-Event-ID when it reconnects.
+	ID string
+	// Event is the event type the page listens for with addEventListener;
+	// empty dispatches a "message" event.
+	Event string
+	// Data is encoded as JSON.
+	Data any
+}
+
+type sseConfig struct {
+	name      string
+	heartbeat time.Duration
+	retry     time.Duration
+}
+
+// SSEOption configures SSEHandler.
+type SSEOption func(*sseConfig)
+
+// WithSSEName sets the handler label on metrics (default "sse").
+func WithSSEName(name string) SSEOption {
+	return func(c *sseConfig) { c.name = name }
+}
+
+// WithSSEHeartbeat sets how often an idle stream sends a comment (default
+// DefaultSSEHeartbeat); 0 disables heartbeats.
+func WithSSEHeartbeat(d time.Duration) SSEOption {
+	return func(c *sseConfig) { c.heartbeat = d }
+}
+
+// WithSSERetry tells browsers how long to wait before reconnecting after
+// the stream ends (by default they pick, typically a few seconds).
+func WithSSERetry(d time.Duration) SSEOption {
+	return func(c *sseConfig) { c.retry = d }
+}
+
+// SSEStream is an open event stream, passed to the function of SSEHandler.
+// Send may be called from several goroutines.
+type SSEStream struct {
+	ctx         context.Context
+	cancel      context.CancelFunc
+	lastEventID string
+	name        string
+
+	mu  sync.Mutex
+	w   http.ResponseWriter
+	rc  *http.ResponseController
+	err error
+}
+
+// SSEHandler streams the events fn sends to browsers as text/event-stream,
+// a lighter alternative to websockets for one-way updates. The handler
+// sends heartbeat comments while fn is idle and cancels fn's stream
+// context once the client goes away. On reconnect the browser's
+// Last-Event-ID is available to fn, so it can replay what was missed.
+//
+//	mux.Handle("/orders/events", shared.SSEHandler(func(s *shared.SSEStream) error {
+//	    sub := orderUpdates.Subscribe()
+//	    defer sub.Close()
+//	    for _, u := range history.Since(s.LastEventID()) {
+//	        s.Send(shared.SSEEvent{ID: u.Seq, Event: "status", Data: u})
+//	    }
+//	    for {
+//	        select {
+//	        case u := <-sub.C():
+//	            if err := s.Send(shared.SSEEvent{ID: u.Seq, Event: "status", Data: u}); err != nil {
+//	                return err
+//	            }
+//	        case <-s.Context().Done():
+//	            return nil
+//	        }
+//	    }
+//	}, shared.WithSSEName("order-status")))
+//
+// The stream is exempt from the server's write timeout. An error returned
+// by fn, other than the client going away, is logged.
+func SSEHandler(fn func(s *SSEStream) error, opts ...SSEOption) http.Handler {
+	cfg := sseConfig{name: "sse", heartbeat: DefaultSSEHeartbeat}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rc := http.NewResponseController(w)
+		// Streams outlive the server's WriteTimeout; servers without
+		// one report ErrNotSupported, which is fine.
+		rc.SetWriteDeadline(time.Time{})
+
+		h := w.Header()
+		h.Set("Content-Type", "text/event-stream")
+		h.Set("Cache-Control", "no-cache")
+		// Keep nginx-style proxies from buffering the stream.
+		h.Set("X-Accel-Buffering", "no")
+		w.WriteHeader(http.StatusOK)
+		if cfg.retry > 0 {
+			fmt.Fprintf(w, "retry: %d\n\n", cfg.retry.Milliseconds())
+		}
+		if err := rc.Flush(); err != nil {
+			log.Printf("SSE: Cannot stream to %s: %v", r.URL.Path, err)
+			return
+		}
+
+		ctx, cancel := context.WithCancel(r.Context())
+		defer cancel()
+		s := &SSEStream{
+			ctx:         ctx,
+			cancel:      cancel,
+			lastEventID: lastEventID(r),
+			name:        cfg.name,
+			w:           w,
+			rc:          rc,
+		}
+		sseStreams.WithLabelValues(cfg.name).Inc()
+		defer sseStreams.WithLabelValues(cfg.name).Dec()
+
+		var wg sync.WaitGroup
+		if cfg.heartbeat > 0 {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				s.heartbeat(cfg.heartbeat)
+			}()
+		}
+		err := fn(s)
+		cancel()
+		wg.Wait()
+		if err != nil && r.Context().Err() == nil && !errors.Is(err, context.Canceled) {
+			log.Printf("SSE: Stream %s ended: %v", r.URL.Path, err)
+		}
+	})
+}
+
+// lastEventID returns the ID the browser resumes after. EventSource sends
+// it as a header; the lastEventId query parameter serves clients that open
+// a fresh stream with a remembered ID.
+func lastEventID(r *http.Request) string {
+	if id := r.Header.Get("Last-Event-ID"); id != "" {
+		return id
+	}
+	return r.URL.Query().Get("lastEventId")
+}
+
+// Context is cancelled when the client goes away or the handler returns.
+func (s *SSEStream) Context() context.Context { return s.ctx }
+
+// LastEventID returns the ID of the last event the browser received before
+// reconnecting, or "" on a first connection.
+func (s *SSEStream) LastEventID() string { return s.lastEventID }
+
+// Send writes ev and flushes it to the client. After a failed write the
+// stream is dead: Send keeps returning the error and Context is cancelled.
+func (s *SSEStream) Send(ev SSEEvent) error {
+	if strings.ContainsAny(ev.ID, "\r\n\x00") || strings.ContainsAny(ev.Event, "\r\n") {
+		return fmt.Errorf("sse: invalid event id %q or type %q", ev.ID, ev.Event)
+	}
+	// JSON escapes newlines, so the data fits on one line.
+	data, err := json.Marshal(ev.Data)
+	if err != nil {
+		return fmt.Errorf("sse: encoding event: %w", err)
+	}
+	var b strings.Builder
+	if ev.ID != "" {
+		b.WriteString("id: " + ev.ID + "\n")
+	}
+	if ev.Event != "" {
+		b.WriteString("event: " + ev.Event + "\n")
+	}
+	b.WriteString("data: ")
+	b.Write(data)
+	b.WriteString("\n\n")
+	if err := s.write(b.String()); err != nil {
+		return err
+	}
+	sseEvents.WithLabelValues(s.name).Inc()
+	return nil
+}
+
+// heartbeat writes a comment every interval until the stream ends.
+func (s *SSEStream) heartbeat(interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+			if s.write(": heartbeat\n\n") != nil {
+				return
+			}
+		case <-s.ctx.Done():
+			return
+		}
+	}
+}
+
+func (s *SSEStream) write(msg string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return s.err
+	}
+	if err := s.ctx.Err(); err != nil {
+		return err
+	}
+	_, err := s.w.Write([]byte(msg))
+	if err == nil {
+		err = s.rc.Flush()
+	}
+	if err != nil {
+		s.err = fmt.Errorf("sse: %w", err)
+		s.cancel()
+	}
+	return s.err
+}
@@ -0,0 +1,80 @@
+package shared
+
+import (
+	"bufio"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSSEHandler(t *testing.T) {
+	ended := make(chan struct{})
+	h := SSEHandler(func(s *SSEStream) error {
+		defer close(ended)
+		if err := s.Send(SSEEvent{ID: "7", Event: "status", Data: map[string]string{"order": "a\nb"}}); err != nil {
+			return err
+		}
+		if err := s.Send(SSEEvent{Data: "after " + s.LastEventID()}); err != nil {
+			return err
+		}
+		if err := s.Send(SSEEvent{ID: "bad\nid"}); err == nil {
+			t.Error("Send accepted an ID with a newline")
+		}
+		<-s.Context().Done()
+		return nil
+	}, WithSSEHeartbeat(20*time.Millisecond), WithSSERetry(3*time.Second))
+	srv := httptest.NewServer(GzipMiddleware(h))
+	defer srv.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL, nil)
+	req.Header.Set("Last-Event-ID", "6")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Errorf("Content-Type = %q", ct)
+	}
+
+	br := bufio.NewReader(resp.Body)
+	var lines []string
+	for len(lines) < 10 {
+		line, err := br.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		lines = append(lines, strings.TrimSuffix(line, "\n"))
+	}
+	want := []string{
+		"retry: 3000", "",
+		"id: 7", "event: status", `data: {"order":"a\nb"}`, "",
+		`data: "after 6"`, "",
+		": heartbeat", "",
+	}
+	if strings.Join(lines, "|") != strings.Join(want, "|") {
+		t.Errorf("stream =\n%q\nwant\n%q", lines, want)
+	}
+
+	cancel()
+	select {
+	case <-ended:
+	case <-time.After(2 * time.Second):
+		t.Fatal("stream context not cancelled after the client went away")
+	}
+}
+
+func TestSSELastEventIDQuery(t *testing.T) {
+	h := SSEHandler(func(s *SSEStream) error {
+		return s.Send(SSEEvent{Data: s.LastEventID()})
+	})
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/?lastEventId=42", nil))
+	if got := rec.Body.String(); got != "data: \"42\"\n\n" {
+		t.Errorf("body = %q", got)
+	}
+}
@@ -0,0 +1,254 @@
+package shared
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/GoogleCloudPlatform/microservices-demo/src/shared/health"
+	"github.com/GoogleCloudPlatform/microservices-demo/src/shared/metrics"
+)
+
+var startupStepDuration = metrics.NewHistogramVec("startup_step_duration_seconds",
+	"Duration of startup steps, by step and result (ok, error, skipped).",
+	[]float64{.01, .05, .1, .5, 1, 2.5, 5, 10, 30, 60, 120}, "step", "result")
+
+// DefaultStartupStepTimeout bounds each attempt of a step unless
+// WithStepTimeout says otherwise.
+const DefaultStartupStepTimeout = 30 * time.Second
+
+// StepOption configures one step of a StartupPlan.
+type StepOption func(*startupStep)
+
+// WithStepTimeout bounds each attempt of the step (default
+// DefaultStartupStepTimeout). Zero or less means no per-attempt bound.
+func WithStepTimeout(d time.Duration) StepOption {
+	return func(s *startupStep) { s.timeout = d }
+}
+
+// WithStepRetry retries the step with policy. Without it a step runs once.
+func WithStepRetry(policy RetryPolicy) StepOption {
+	return func(s *startupStep) { s.retry = &policy }
+}
+
+// WithStepOptional lets startup continue if the step fails, e.g. for a
+// cache warm-up the service can do without. The failure is logged and
+// reported by Run's error only if a required step fails too.
+func WithStepOptional() StepOption {
+	return func(s *startupStep) { s.optional = true }
+}
+
+type startupStep struct {
+	name     string
+	fn       func(ctx context.Context) error
+	timeout  time.Duration
+	retry    *RetryPolicy
+	optional bool
+}
+
+// StepResult is the outcome of one startup step.
+type StepResult struct {
+	Name     string
+	Duration time.Duration
+	Attempts int
+	// Err is the step's last error, or nil if it succeeded or was skipped.
+	Err error
+	// Skipped marks a step not run because an earlier required step failed.
+	Skipped  bool
+	Optional bool
+}
+
+// StartupError reports a failed startup: the step that failed, and every
+// step's outcome so the log shows what was and was not initialized.
+type StartupError struct {
+	Failed string
+	Steps  []StepResult
+}
+
+func (e *StartupError) Error() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "startup: step %q failed", e.Failed)
+	for _, s := range e.Steps {
+		switch {
+		case s.Skipped:
+			fmt.Fprintf(&b, "\n  %s: skipped", s.Name)
+		case s.Err != nil:
+			kind := "failed"
+			if s.Optional {
+				kind = "failed (optional)"
+			}
+			fmt.Fprintf(&b, "\n  %s: %s after %d attempt(s) in %v: %v", s.Name, kind, s.Attempts, s.Duration.Round(time.Millisecond), s.Err)
+		default:
+			fmt.Fprintf(&b, "\n  %s: ok in %v", s.Name, s.Duration.Round(time.Millisecond))
+		}
+	}
+	return b.String()
+}
+
+// Unwrap returns the failed step's error.
+func (e *StartupError) Unwrap() error {
+	for _, s := range e.Steps {
+		if s.Name == e.Failed {
+			return s.Err
+		}
+	}
+	return nil
+}
+
+// StartupOption configures Startup.
+type StartupOption func(*StartupPlan)
+
+// WithStartupHealth registers the plan as a startup check named "startup"
+// on r, so readiness stays false until every required step has succeeded.
+func WithStartupHealth(r *health.Registry) StartupOption {
+	return func(p *StartupPlan) { r.Register("startup", p.Checker(), health.Startup) }
+}
+
+// StartupPlan runs a service's initialization steps in order. Build it with
+// Startup.
+type StartupPlan struct {
+	ctx   context.Context
+	steps []startupStep
+
+	mu      sync.Mutex
+	current string
+	done    bool
+	err     error
+}
+
+// Startup returns an empty plan whose steps run under ctx. Steps run in the
+// order they are added; a failing required step stops the plan, and Run
+// returns a StartupError listing every step's outcome, so the service exits
+// with a clear reason instead of serving half-initialized.
+//
+// Usage:
+//
+//	hr := health.New()
+//	err := shared.Startup(ctx, shared.WithStartupHealth(hr)).
+//	    Step("postgres", func(ctx context.Context) error { return db.PingContext(ctx) },
+//	        shared.WithStepRetry(shared.DefaultRetryPolicy), shared.WithStepTimeout(5*time.Second)).
+//	    Step("migrations", func(ctx context.Context) error { return postgres.RunMigrations(ctx, db, migrations) }).
+//	    Step("warm catalog cache", warmCache, shared.WithStepOptional()).
+//	    Step("subscribe orders", func(ctx context.Context) error { return bus.Subscribe(ctx, "orders", "shipping", h) }).
+//	    Run()
+//	if err != nil {
+//	    log.Fatal(err)
+//	}
+func Startup(ctx context.Context, opts ...StartupOption) *StartupPlan {
+	p := &StartupPlan{ctx: ctx}
+	for _, opt := range opts {
+		opt(p)
+	}
+	return p
+}
+
+// Step appends a step and returns p for chaining.
+func (p *StartupPlan) Step(name string, fn func(ctx context.Context) error, opts ...StepOption) *StartupPlan {
+	s := startupStep{name: name, fn: fn, timeout: DefaultStartupStepTimeout}
+	for _, opt := range opts {
+		opt(&s)
+	}
+	p.steps = append(p.steps, s)
+	return p
+}
+
+// Run executes the steps. It returns nil once every required step has
+// succeeded, and otherwise a *StartupError.
+func (p *StartupPlan) Run() error {
+	start := time.Now()
+	results := make([]StepResult, 0, len(p.steps))
+	var failed string
+	for _, s := range p.steps {
+		if failed != "" {
+			results = append(results, StepResult{Name: s.name, Skipped: true, Optional: s.optional})
+			startupStepDuration.WithLabelValues(s.name, "skipped").Observe(0)
+			continue
+		}
+		p.setCurrent(s.name)
+		r := p.runStep(s)
+		results = append(results, r)
+		switch {
+		case r.Err == nil:
+			startupStepDuration.WithLabelValues(s.name, "ok").Observe(r.Duration.Seconds())
+			log.Printf("Startup: Step %q done in %v", s.name, r.Duration.Round(time.Millisecond))
+		case s.optional:
+			startupStepDuration.WithLabelValues(s.name, "error").Observe(r.Duration.Seconds())
+			log.Printf("Startup: Optional step %q failed, continuing: %v", s.name, r.Err)
+		default:
+			startupStepDuration.WithLabelValues(s.name, "error").Observe(r.Duration.Seconds())
+			failed = s.name
+		}
+	}
+
+	var err error
+	if failed != "" {
+		err = &StartupError{Failed: failed, Steps: results}
+	} else {
+		log.Printf("Startup: %d steps done in %v", len(p.steps), time.Since(start).Round(time.Millisecond))
+	}
+	p.mu.Lock()
+	p.done, p.err, p.current = true, err, ""
+	p.mu.Unlock()
+	return err
+}
+
+func (p *StartupPlan) runStep(s startupStep) StepResult {
+	r := StepResult{Name: s.name, Optional: s.optional}
+	start := time.Now()
+	attempt := func(ctx context.Context) error {
+		r.Attempts++
+		if s.timeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, s.timeout)
+			defer cancel()
+		}
+		return s.fn(ctx)
+	}
+	if s.retry != nil {
+		policy := *s.retry
+		if policy.OnRetry == nil {
+			policy.OnRetry = func(n int, delay time.Duration, err error) {
+				log.Printf("Startup: Step %q attempt %d failed, retrying in %v: %v", s.name, n, delay.Round(time.Millisecond), err)
+			}
+		}
+		// A per-attempt timeout is worth retrying even though Retry's
+		// default classifier gives up on deadline errors.
+		if policy.Retryable == nil {
+			policy.Retryable = func(err error) bool {
+				return errors.Is(err, context.DeadlineExceeded) || IsRetryable(err)
+			}
+		}
+		r.Err = Retry(p.ctx, policy, attempt)
+	} else {
+		r.Err = attempt(p.ctx)
+	}
+	r.Duration = time.Since(start)
+	return r
+}
+
+func (p *StartupPlan) setCurrent(name string) {
+	p.mu.Lock()
+	p.current = name
+	p.mu.Unlock()
+}
+
+// Checker reports startup progress as a health check: an error naming the
+// running step until Run finishes, then nil, or Run's error if it failed.
+func (p *StartupPlan) Checker() health.Checker {
+	return func(context.Context) error {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		switch {
+		case p.done:
+			return p.err
+		case p.current != "":
+			return fmt.Errorf("startup: running step %q", p.current)
+		default:
+			return errors.New("startup: not started")
+		}
+	}
+}
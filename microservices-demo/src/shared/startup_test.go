@@ -0,0 +1,109 @@
+package shared
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/GoogleCloudPlatform/microservices-demo/src/shared/health"
+)
+
+func TestStartupRunsStepsInOrder(t *testing.T) {
+	var order []string
+	step := func(name string) func(context.Context) error {
+		return func(context.Context) error {
+			order = append(order, name)
+			return nil
+		}
+	}
+	err := Startup(context.Background()).
+		Step("db", step("db")).
+		Step("cache", step("cache")).
+		Step("bus", step("bus")).
+		Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(order, ",") != "db,cache,bus" {
+		t.Errorf("order = %v", order)
+	}
+}
+
+func TestStartupFailsFast(t *testing.T) {
+	boom := errors.New("connection refused")
+	ran := false
+	err := Startup(context.Background()).
+		Step("db", func(context.Context) error { return nil }).
+		Step("warm cache", func(context.Context) error { return errors.New("cold") }, WithStepOptional()).
+		Step("bus", func(context.Context) error { return boom }).
+		Step("grpc", func(context.Context) error { ran = true; return nil }).
+		Run()
+
+	var se *StartupError
+	if !errors.As(err, &se) || se.Failed != "bus" || !errors.Is(err, boom) {
+		t.Fatalf("Run = %v, want a StartupError for bus wrapping the cause", err)
+	}
+	if ran {
+		t.Error("step after the failure ran")
+	}
+	if !se.Steps[3].Skipped || se.Steps[1].Err == nil || !se.Steps[1].Optional {
+		t.Errorf("results = %+v", se.Steps)
+	}
+	for _, want := range []string{`step "bus" failed`, "db: ok", "warm cache: failed (optional)", "grpc: skipped"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error %q does not mention %q", err, want)
+		}
+	}
+}
+
+func TestStartupStepRetryAndTimeout(t *testing.T) {
+	attempts := 0
+	err := Startup(context.Background()).
+		Step("slow dependency", func(ctx context.Context) error {
+			attempts++
+			if attempts < 3 {
+				<-ctx.Done()
+				return ctx.Err()
+			}
+			return nil
+		}, WithStepTimeout(10*time.Millisecond), WithStepRetry(RetryPolicy{MaxAttempts: 5, InitialBackoff: time.Millisecond})).
+		Run()
+	if err != nil || attempts != 3 {
+		t.Errorf("Run = %v after %d attempts, want success on the third", err, attempts)
+	}
+
+	err = Startup(context.Background()).
+		Step("db", func(context.Context) error { return errors.New("down") },
+			WithStepRetry(RetryPolicy{MaxAttempts: 2, InitialBackoff: time.Millisecond})).
+		Run()
+	var se *StartupError
+	if !errors.As(err, &se) || se.Steps[0].Attempts != 2 {
+		t.Errorf("Run = %v, want failure after 2 attempts", err)
+	}
+}
+
+func TestStartupGatesReadiness(t *testing.T) {
+	hr := health.New()
+	release := make(chan struct{})
+	plan := Startup(context.Background(), WithStartupHealth(hr)).
+		Step("db", func(context.Context) error { <-release; return nil })
+
+	if rep := hr.Run(context.Background(), health.Readiness); rep.Healthy {
+		t.Error("ready before startup ran")
+	}
+	done := make(chan error)
+	go func() { done <- plan.Run() }()
+	time.Sleep(10 * time.Millisecond)
+	if err := plan.Checker()(context.Background()); err == nil || !strings.Contains(err.Error(), `"db"`) {
+		t.Errorf("Checker during step = %v, want it to name the step", err)
+	}
+	close(release)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if rep := hr.Run(context.Background(), health.Readiness); !rep.Healthy {
+		t.Errorf("not ready after startup: %+v", rep)
+	}
+}
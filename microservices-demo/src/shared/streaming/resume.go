@@ -0,0 +1,234 @@
+package streaming
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"sync/atomic"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	shared "github.com/GoogleCloudPlatform/microservices-demo/src/shared"
+)
+
+// Stream is the receiving side of a server stream, such as a generated
+// grpc.ServerStreamingClient.
+type Stream[T any] interface {
+	Recv() (T, error)
+}
+
+// OpenFunc opens a stream, resuming after token unless it is empty.
+type OpenFunc[T any] func(ctx context.Context, token string) (Stream[T], error)
+
+// DefaultResumePolicy reconnects up to 8 times in a row, backing off from
+// 200ms to 10s, before giving up on a stream that delivers nothing.
+var DefaultResumePolicy = shared.RetryPolicy{
+	MaxAttempts:    8,
+	InitialBackoff: 200 * time.Millisecond,
+	MaxBackoff:     10 * time.Second,
+	Multiplier:     2,
+	Jitter:         0.2,
+}
+
+// ResumeOption configures Resume.
+type ResumeOption func(*resumeConfig)
+
+type resumeConfig struct {
+	policy      shared.RetryPolicy
+	idle        time.Duration
+	isHeartbeat func(any) bool
+	log         *slog.Logger
+}
+
+// WithResumePolicy replaces DefaultResumePolicy. Its Retryable classifier
+// also decides which stream errors are worth a reconnect.
+func WithResumePolicy(p shared.RetryPolicy) ResumeOption {
+	return func(c *resumeConfig) { c.policy = p }
+}
+
+// WithIdleTimeout reconnects when no message, heartbeats included, arrives
+// for d. Set it to a few heartbeat intervals of the server.
+func WithIdleTimeout(d time.Duration) ResumeOption {
+	return func(c *resumeConfig) { c.idle = d }
+}
+
+// WithHeartbeatFilter drops the messages fn reports as heartbeats instead
+// of returning them from Recv. Their resume tokens are still recorded.
+func WithHeartbeatFilter[T any](fn func(T) bool) ResumeOption {
+	return func(c *resumeConfig) {
+		c.isHeartbeat = func(v any) bool {
+			t, _ := v.(T)
+			return fn(t)
+		}
+	}
+}
+
+// WithResumeLogger sets the logger of reconnects (default slog.Default()).
+func WithResumeLogger(l *slog.Logger) ResumeOption {
+	return func(c *resumeConfig) { c.log = l }
+}
+
+// Resumable is a server stream that reopens itself after transient
+// failures, asking the server to resume after the token of the last message
+// received. It is not safe for concurrent use, like the streams it wraps.
+type Resumable[T any] struct {
+	ctx     context.Context
+	open    OpenFunc[T]
+	tokenOf func(T) string
+	cfg     resumeConfig
+
+	cur        Stream[T]
+	cancel     context.CancelFunc
+	idleTimer  *time.Timer
+	idled      atomic.Bool
+	first      *T
+	token      string
+	reconnects int
+	closed     bool
+}
+
+// Resume returns a Resumable opening its streams with open. tokenOf
+// returns a message's resume token, or "" if it has none. The stream is
+// opened by the first Recv.
+func Resume[T any](ctx context.Context, open OpenFunc[T], tokenOf func(T) string, opts ...ResumeOption) *Resumable[T] {
+	cfg := resumeConfig{policy: DefaultResumePolicy, log: slog.Default()}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	return &Resumable[T]{ctx: ctx, open: open, tokenOf: tokenOf, cfg: cfg}
+}
+
+// Recv returns the next message. It returns io.EOF when the server ends the
+// stream, and otherwise the error that made it give up reconnecting.
+func (r *Resumable[T]) Recv() (T, error) {
+	var zero T
+	for {
+		if r.closed {
+			return zero, status.Error(codes.Canceled, "streaming: stream closed")
+		}
+		if r.cur == nil {
+			if err := r.connect(); err != nil {
+				return zero, err
+			}
+		}
+		var m T
+		var err error
+		if r.first != nil {
+			m, r.first = *r.first, nil
+		} else {
+			m, err = r.recv()
+		}
+		if err != nil {
+			r.drop()
+			if errors.Is(err, io.EOF) {
+				return zero, io.EOF
+			}
+			if !r.retryable(err) {
+				return zero, err
+			}
+			r.reconnects++
+			r.cfg.log.WarnContext(r.ctx, "stream broken, resuming", "error", err, "token", r.token)
+			continue
+		}
+		if r.cfg.isHeartbeat != nil && r.cfg.isHeartbeat(m) {
+			continue
+		}
+		return m, nil
+	}
+}
+
+// Token returns the resume token of the last message received.
+func (r *Resumable[T]) Token() string { return r.token }
+
+// Reconnects returns how many times the stream was reopened after breaking.
+func (r *Resumable[T]) Reconnects() int { return r.reconnects }
+
+// Close cancels the current stream. Recv fails afterwards.
+func (r *Resumable[T]) Close() {
+	r.closed = true
+	r.drop()
+}
+
+// connect opens a stream and receives its first message, retrying with
+// backoff, so that a server failing every stream right away is not hammered.
+// A stream counts as established once it delivered a message.
+func (r *Resumable[T]) connect() error {
+	return shared.Retry(r.ctx, r.policyWithStreamErrors(), func(ctx context.Context) error {
+		sctx, cancel := context.WithCancel(ctx)
+		s, err := r.open(sctx, r.token)
+		if err != nil {
+			cancel()
+			return err
+		}
+		r.cur, r.cancel = s, cancel
+		if r.cfg.idle > 0 {
+			r.idled.Store(false)
+			r.idleTimer = time.AfterFunc(r.cfg.idle, func() {
+				r.idled.Store(true)
+				cancel()
+			})
+		}
+		m, err := r.recv()
+		if err != nil {
+			r.drop()
+			if errors.Is(err, io.EOF) {
+				return shared.Permanent(err)
+			}
+			return err
+		}
+		r.first = &m
+		return nil
+	})
+}
+
+// recv receives from the current stream, recording the message's token and
+// pushing back the idle deadline.
+func (r *Resumable[T]) recv() (T, error) {
+	m, err := r.cur.Recv()
+	if err != nil {
+		if r.idled.Load() {
+			err = status.Errorf(codes.Unavailable, "streaming: no message for %s", r.cfg.idle)
+		}
+		return m, err
+	}
+	if r.idleTimer != nil {
+		r.idleTimer.Reset(r.cfg.idle)
+	}
+	if tok := r.tokenOf(m); tok != "" {
+		r.token = tok
+	}
+	return m, nil
+}
+
+func (r *Resumable[T]) drop() {
+	if r.idleTimer != nil {
+		r.idleTimer.Stop()
+		r.idleTimer = nil
+	}
+	if r.cancel != nil {
+		r.cancel()
+		r.cancel = nil
+	}
+	r.cur = nil
+}
+
+func (r *Resumable[T]) retryable(err error) bool {
+	if r.ctx.Err() != nil {
+		return false
+	}
+	if r.cfg.policy.Retryable != nil {
+		return r.cfg.policy.Retryable(err)
+	}
+	return shared.IsRetryable(err)
+}
+
+// policyWithStreamErrors is the policy with the classifier Recv uses, so
+// connect stops on the same errors.
+func (r *Resumable[T]) policyWithStreamErrors() shared.RetryPolicy {
+	p := r.cfg.policy
+	p.Retryable = r.retryable
+	return p
+}
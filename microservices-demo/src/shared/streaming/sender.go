@@ -0,0 +1,221 @@
+// Package streaming provides plumbing for long-lived gRPC server streams:
+// a Sender that paces messages to the client with heartbeats and
+// backpressure, and a client-side Resumable that reconnects a broken
+// stream where it left off using resume tokens.
+//
+// Server:
+//
+//	func (s *server) StreamRecommendations(req *pb.StreamRequest, stream pb.RecommendationService_StreamRecommendationsServer) error {
+//	    sender := streaming.NewSender(stream, streaming.WithHeartbeat(15*time.Second,
+//	        func() *pb.Recommendation { return &pb.Recommendation{Heartbeat: true} }))
+//	    for rec := range s.feed(stream.Context(), streaming.ResumeToken(stream.Context())) {
+//	        ctx, cancel := context.WithTimeout(stream.Context(), 5*time.Second)
+//	        err := sender.Send(ctx, rec)
+//	        cancel()
+//	        if err != nil {
+//	            return err
+//	        }
+//	    }
+//	    return sender.Close()
+//	}
+//
+// Client:
+//
+//	recs := streaming.Resume(ctx, func(ctx context.Context, token string) (streaming.Stream[*pb.Recommendation], error) {
+//	    return client.StreamRecommendations(streaming.WithResumeToken(ctx, token), req)
+//	}, func(r *pb.Recommendation) string { return r.Cursor },
+//	    streaming.WithIdleTimeout(45*time.Second),
+//	    streaming.WithHeartbeatFilter(func(r *pb.Recommendation) bool { return r.Heartbeat }))
+//	defer recs.Close()
+//	for {
+//	    rec, err := recs.Recv()
+//	    ...
+//	}
+package streaming
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"github.com/GoogleCloudPlatform/microservices-demo/src/shared/metrics"
+)
+
+// ResumeTokenHeader is the metadata key carrying a client's resume token.
+const ResumeTokenHeader = "x-resume-token"
+
+// DefaultSendBuffer is how many messages a Sender queues before Send blocks.
+const DefaultSendBuffer = 16
+
+var slowConsumers = metrics.NewCounterVec("grpc_stream_slow_consumers_total",
+	"Server streams aborted because the client stopped reading, by method.", "method")
+
+// ResumeToken returns the resume token the client sent with the stream, or
+// "" for a fresh stream.
+func ResumeToken(ctx context.Context) string {
+	if v := metadata.ValueFromIncomingContext(ctx, ResumeTokenHeader); len(v) > 0 {
+		return v[0]
+	}
+	return ""
+}
+
+// WithResumeToken returns a copy of ctx sending token to the server, unless
+// it is empty.
+func WithResumeToken(ctx context.Context, token string) context.Context {
+	if token == "" {
+		return ctx
+	}
+	return metadata.AppendToOutgoingContext(ctx, ResumeTokenHeader, token)
+}
+
+// SenderOption configures NewSender.
+type SenderOption[T any] func(*Sender[T])
+
+// WithHeartbeat sends beat() whenever nothing else was sent for interval,
+// so proxies keep the stream open and the client can tell a quiet stream
+// from a dead one.
+func WithHeartbeat[T any](interval time.Duration, beat func() T) SenderOption[T] {
+	return func(s *Sender[T]) { s.heartbeat, s.beat = interval, beat }
+}
+
+// WithSendBuffer sets how many messages are queued before Send blocks
+// (default DefaultSendBuffer).
+func WithSendBuffer[T any](n int) SenderOption[T] {
+	return func(s *Sender[T]) { s.buffer = n }
+}
+
+// WithSendTimeout fails the stream with ResourceExhausted if a single
+// message cannot be handed to the transport within d, which means the
+// client stopped reading and gRPC flow control is holding the message. The
+// default is no limit.
+func WithSendTimeout[T any](d time.Duration) SenderOption[T] {
+	return func(s *Sender[T]) { s.sendTimeout = d }
+}
+
+// Sender sends messages on a server stream from its own goroutine, so
+// callers can bound how long they wait on a slow client. Send and Close may
+// be called from any goroutine, but the handler must not call the stream's
+// SendMsg itself once it has a Sender.
+type Sender[T any] struct {
+	stream      grpc.ServerStream
+	heartbeat   time.Duration
+	beat        func() T
+	buffer      int
+	sendTimeout time.Duration
+
+	queue     chan T
+	done      chan struct{}
+	failed    chan struct{}
+	closeOnce sync.Once
+	failOnce  sync.Once
+	err       error
+}
+
+// NewSender starts a Sender for stream. It stops when Close is called or
+// the stream's context ends.
+func NewSender[T any](stream grpc.ServerStream, opts ...SenderOption[T]) *Sender[T] {
+	s := &Sender[T]{stream: stream, buffer: DefaultSendBuffer, done: make(chan struct{}), failed: make(chan struct{})}
+	for _, opt := range opts {
+		opt(s)
+	}
+	s.queue = make(chan T, s.buffer)
+	go s.loop()
+	return s
+}
+
+// Send queues m, waiting for room while the queue is full. It returns ctx's
+// error as a gRPC status if ctx ends first, and the stream's error if
+// sending already failed.
+func (s *Sender[T]) Send(ctx context.Context, m T) error {
+	select {
+	case <-s.failed:
+		return s.err
+	default:
+	}
+	select {
+	case s.queue <- m:
+		return nil
+	case <-s.failed:
+		return s.err
+	case <-ctx.Done():
+		return status.FromContextError(ctx.Err()).Err()
+	}
+}
+
+// Pending returns the number of queued messages. A persistently full
+// queue means the client reads slower than the server produces.
+func (s *Sender[T]) Pending() int { return len(s.queue) }
+
+// Close sends the queued messages and stops the Sender. It returns the
+// error that stopped sending, if any, which the handler should return.
+func (s *Sender[T]) Close() error {
+	s.closeOnce.Do(func() { close(s.queue) })
+	select {
+	case <-s.done:
+	case <-s.failed:
+	}
+	select {
+	case <-s.failed:
+		return s.err
+	default:
+		return nil
+	}
+}
+
+func (s *Sender[T]) fail(err error) {
+	s.failOnce.Do(func() {
+		s.err = err
+		close(s.failed)
+	})
+}
+
+func (s *Sender[T]) loop() {
+	defer close(s.done)
+	var beats <-chan time.Time
+	var timer *time.Timer
+	if s.heartbeat > 0 && s.beat != nil {
+		timer = time.NewTimer(s.heartbeat)
+		defer timer.Stop()
+		beats = timer.C
+	}
+	ctx := s.stream.Context()
+	for {
+		var m T
+		select {
+		case v, ok := <-s.queue:
+			if !ok {
+				return
+			}
+			m = v
+		case <-beats:
+			m = s.beat()
+		case <-ctx.Done():
+			s.fail(status.FromContextError(ctx.Err()).Err())
+			return
+		}
+		if err := s.send(m); err != nil {
+			s.fail(err)
+			return
+		}
+		if timer != nil {
+			timer.Reset(s.heartbeat)
+		}
+	}
+}
+
+func (s *Sender[T]) send(m T) error {
+	if s.sendTimeout > 0 {
+		watchdog := time.AfterFunc(s.sendTimeout, func() {
+			method, _ := grpc.MethodFromServerStream(s.stream)
+			slowConsumers.WithLabelValues(method).Inc()
+			s.fail(status.Errorf(codes.ResourceExhausted, "client did not read for %s", s.sendTimeout))
+		})
+		defer watchdog.Stop()
+	}
+	return s.stream.SendMsg(m)
+}
@@ -0,0 +1,217 @@
+package streaming
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	shared "github.com/GoogleCloudPlatform/microservices-demo/src/shared"
+)
+
+// fakeServerStream records sent messages; while blocked is set, SendMsg
+// waits for it to clear or for the context to end, like a full flow-control
+// window.
+type fakeServerStream struct {
+	grpc.ServerStream
+	ctx     context.Context
+	mu      sync.Mutex
+	sent    []string
+	blocked chan struct{}
+}
+
+func (s *fakeServerStream) Context() context.Context { return s.ctx }
+
+func (s *fakeServerStream) SendMsg(m any) error {
+	if s.blocked != nil {
+		select {
+		case <-s.blocked:
+		case <-s.ctx.Done():
+			return s.ctx.Err()
+		}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sent = append(s.sent, m.(string))
+	return nil
+}
+
+func (s *fakeServerStream) messages() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.sent...)
+}
+
+func TestSenderHeartbeatsAndClose(t *testing.T) {
+	ss := &fakeServerStream{ctx: context.Background()}
+	s := NewSender(ss, WithHeartbeat(20*time.Millisecond, func() string { return "beat" }))
+	if err := s.Send(context.Background(), "a"); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(70 * time.Millisecond)
+	s.Send(context.Background(), "b")
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	sent := ss.messages()
+	if len(sent) < 3 || sent[0] != "a" || sent[1] != "beat" || sent[len(sent)-1] != "b" {
+		t.Errorf("sent %v, want a, heartbeats, b", sent)
+	}
+}
+
+func TestSenderBackpressure(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ss := &fakeServerStream{ctx: ctx, blocked: make(chan struct{})}
+	s := NewSender(ss, WithSendBuffer[string](1), WithSendTimeout[string](50*time.Millisecond))
+
+	s.Send(ctx, "in flight")
+	s.Send(ctx, "queued")
+	sctx, scancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer scancel()
+	if err := s.Send(sctx, "waits"); status.Code(err) != codes.DeadlineExceeded {
+		t.Errorf("Send on a full queue = %v, want DeadlineExceeded", err)
+	}
+	if s.Pending() != 1 {
+		t.Errorf("Pending = %d, want 1", s.Pending())
+	}
+
+	// The client never reads, so the watchdog gives up on it.
+	start := time.Now()
+	if err := s.Close(); status.Code(err) != codes.ResourceExhausted {
+		t.Errorf("Close = %v, want ResourceExhausted", err)
+	}
+	if time.Since(start) > time.Second {
+		t.Error("Close waited for the blocked send")
+	}
+	if err := s.Send(ctx, "after"); status.Code(err) != codes.ResourceExhausted {
+		t.Errorf("Send after failure = %v", err)
+	}
+}
+
+func TestResumeTokenMetadata(t *testing.T) {
+	ctx := WithResumeToken(context.Background(), "cursor-7")
+	md, _ := metadata.FromOutgoingContext(ctx)
+	in := metadata.NewIncomingContext(context.Background(), md)
+	if got := ResumeToken(in); got != "cursor-7" {
+		t.Errorf("ResumeToken = %q", got)
+	}
+	if ResumeToken(context.Background()) != "" || WithResumeToken(context.Background(), "") != context.Background() {
+		t.Error("empty token should not be sent")
+	}
+}
+
+// fakeClientStream yields msgs, then fails with err. If err is nil it
+// blocks until ctx ends.
+type fakeClientStream struct {
+	ctx  context.Context
+	msgs []string
+	err  error
+}
+
+func (s *fakeClientStream) Recv() (string, error) {
+	if len(s.msgs) > 0 {
+		m := s.msgs[0]
+		s.msgs = s.msgs[1:]
+		return m, nil
+	}
+	if s.err != nil {
+		return "", s.err
+	}
+	<-s.ctx.Done()
+	return "", status.FromContextError(s.ctx.Err()).Err()
+}
+
+// feed is a server with messages "0".."n-1" whose streams break after
+// every `every` messages, or go silent if silent is set.
+func feed(n, every int, silent bool, tokens *[]string) OpenFunc[string] {
+	return func(ctx context.Context, token string) (Stream[string], error) {
+		*tokens = append(*tokens, token)
+		start := 0
+		if token != "" {
+			start, _ = strconv.Atoi(token)
+			start++
+		}
+		s := &fakeClientStream{ctx: ctx}
+		end := min(start+every, n)
+		for i := start; i < end; i++ {
+			s.msgs = append(s.msgs, strconv.Itoa(i))
+			if i%3 == 2 {
+				s.msgs = append(s.msgs, "hb")
+			}
+		}
+		switch {
+		case end == n:
+			s.err = io.EOF
+		case !silent:
+			s.err = status.Error(codes.Unavailable, "connection reset")
+		}
+		return s, nil
+	}
+}
+
+func token(m string) string {
+	if m == "hb" {
+		return ""
+	}
+	return m
+}
+
+var quickPolicy = shared.RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond}
+
+func TestResumableReconnects(t *testing.T) {
+	for name, silent := range map[string]bool{"broken": false, "silent": true} {
+		var tokens []string
+		r := Resume(context.Background(), feed(10, 4, silent, &tokens), token,
+			WithResumePolicy(quickPolicy), WithIdleTimeout(30*time.Millisecond),
+			WithHeartbeatFilter(func(m string) bool { return m == "hb" }),
+			WithResumeLogger(slog.New(slog.NewTextHandler(io.Discard, nil))))
+		var got []string
+		for {
+			m, err := r.Recv()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatalf("%s: Recv: %v", name, err)
+			}
+			got = append(got, m)
+		}
+		if len(got) != 10 || got[0] != "0" || got[9] != "9" {
+			t.Errorf("%s: received %v, want 0..9 once each without heartbeats", name, got)
+		}
+		if want := []string{"", "3", "7"}; len(tokens) != 3 || tokens[1] != want[1] || tokens[2] != want[2] {
+			t.Errorf("%s: opened with tokens %q, want %q", name, tokens, want)
+		}
+		if r.Reconnects() != 2 || r.Token() != "9" {
+			t.Errorf("%s: reconnects %d token %q", name, r.Reconnects(), r.Token())
+		}
+	}
+}
+
+func TestResumableGivesUp(t *testing.T) {
+	opens := 0
+	r := Resume(context.Background(), func(ctx context.Context, _ string) (Stream[string], error) {
+		opens++
+		return &fakeClientStream{ctx: ctx, err: status.Error(codes.Unavailable, "down")}, nil
+	}, token, WithResumePolicy(quickPolicy), WithResumeLogger(slog.New(slog.NewTextHandler(io.Discard, nil))))
+	if _, err := r.Recv(); status.Code(err) != codes.Unavailable || opens != 3 {
+		t.Errorf("Recv = %v after %d opens, want Unavailable after 3", err, opens)
+	}
+
+	r = Resume(context.Background(), func(ctx context.Context, _ string) (Stream[string], error) {
+		return &fakeClientStream{ctx: ctx, msgs: []string{"0"}, err: status.Error(codes.PermissionDenied, "denied")}, nil
+	}, token, WithResumePolicy(quickPolicy))
+	r.Recv()
+	if _, err := r.Recv(); status.Code(err) != codes.PermissionDenied {
+		t.Errorf("Recv = %v, want PermissionDenied without reconnecting", err)
+	}
+}
@@ -0,0 +1,41 @@
+package tenancy
+
+import (
+	"context"
+	"strings"
+)
+
+// Separator joins the tenant and the key in Key.
+const Separator = ":"
+
+// Key prefixes key with ctx's tenant, e.g. "acme:cart:42", for Redis keys,
+// cache entries and object names. It returns ErrNoTenant rather than an
+// unprefixed key, so a missing interceptor cannot leak data between tenants.
+func Key(ctx context.Context, key string) (string, error) {
+	t, ok := FromContext(ctx)
+	if !ok {
+		return "", ErrNoTenant
+	}
+	return t + Separator + key, nil
+}
+
+// SplitKey undoes Key, returning the tenant and the original key, for
+// consumers that scan keys across tenants.
+func SplitKey(key string) (tenant, rest string, ok bool) {
+	tenant, rest, ok = strings.Cut(key, Separator)
+	if !ok || !Valid(tenant) {
+		return "", key, false
+	}
+	return tenant, rest, true
+}
+
+// Schema returns the database schema holding ctx's tenant's tables, e.g.
+// "tenant_acme_corp" for tenant acme-corp. Tenant IDs are validated, so the
+// name can be interpolated into SET search_path without further quoting.
+func Schema(ctx context.Context) (string, error) {
+	t, ok := FromContext(ctx)
+	if !ok {
+		return "", ErrNoTenant
+	}
+	return "tenant_" + strings.ReplaceAll(t, "-", "_"), nil
+}
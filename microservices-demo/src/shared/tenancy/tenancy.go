@@ -0,0 +1,257 @@
+// Package tenancy scopes requests to a tenant, so one deployment of the demo
+// can serve several isolated shops. The server interceptors and middleware
+// resolve the tenant of each request, store it in the context, and reject
+// requests without one; Key and Schema then namespace Redis keys and
+// database schemas by tenant so data never crosses between them.
+//
+// The tenant is taken, in order, from:
+//
+//  1. the token's tenant claim, when package auth authenticated the caller
+//     (a caller naming a different tenant in metadata is refused);
+//  2. the x-tenant-id gRPC metadata key or X-Tenant-Id HTTP header;
+//  3. the tenant.id baggage member set by an upstream service.
+//
+// The resolved tenant is written to baggage, so outgoing calls made with the
+// grpcclient and NewHTTPClient propagation carry it to the next service.
+//
+// Usage:
+//
+//	srv := grpcserver.New(grpcserver.WithUnaryInterceptors(
+//	    v.UnaryServerInterceptor(), // auth, if used
+//	    tenancy.UnaryServerInterceptor(tenancy.WithRequired())))
+//
+//	key, err := tenancy.Key(ctx, "cart:"+userID) // "acme:cart:<user id>"
+package tenancy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"github.com/GoogleCloudPlatform/microservices-demo/src/shared/auth"
+	"github.com/GoogleCloudPlatform/microservices-demo/src/shared/baggage"
+)
+
+const (
+	// MetadataKey is the gRPC metadata key naming the tenant.
+	MetadataKey = "x-tenant-id"
+	// Header is the HTTP header naming the tenant.
+	Header = "X-Tenant-Id"
+	// DefaultClaim is the token claim holding the tenant.
+	DefaultClaim = "tenant_id"
+)
+
+// healthPrefix is always exempt so probes keep working without a tenant.
+const healthPrefix = "/grpc.health.v1.Health/"
+
+// ErrNoTenant is returned by Key and Schema when ctx carries no tenant.
+var ErrNoTenant = errors.New("tenancy: no tenant in context")
+
+// Valid reports whether id can name a tenant: 1 to 63 lowercase letters,
+// digits and hyphens, not starting or ending with a hyphen, like a DNS
+// label. The restriction keeps IDs safe inside keys and schema names.
+func Valid(id string) bool {
+	if id == "" || len(id) > 63 || id[0] == '-' || id[len(id)-1] == '-' {
+		return false
+	}
+	for _, c := range id {
+		if !(c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '-') {
+			return false
+		}
+	}
+	return true
+}
+
+type ctxKey struct{}
+
+// NewContext returns a copy of ctx scoped to tenant, which is also set as
+// baggage for downstream services.
+func NewContext(ctx context.Context, tenant string) context.Context {
+	ctx = baggage.Set(ctx, baggage.Tenant, tenant)
+	return context.WithValue(ctx, ctxKey{}, tenant)
+}
+
+// FromContext returns the tenant stored by NewContext or the interceptors.
+func FromContext(ctx context.Context) (string, bool) {
+	t, ok := ctx.Value(ctxKey{}).(string)
+	return t, ok && t != ""
+}
+
+type config struct {
+	claim    string
+	required bool
+	fallback string
+	exempt   []string
+}
+
+// Option configures the interceptors and middleware.
+type Option func(*config)
+
+// WithClaim reads the tenant from the named token claim instead of
+// DefaultClaim.
+func WithClaim(name string) Option {
+	return func(c *config) { c.claim = name }
+}
+
+// WithRequired rejects requests that resolve no tenant, with
+// InvalidArgument or 400. Without it they proceed unscoped.
+func WithRequired() Option {
+	return func(c *config) { c.required = true }
+}
+
+// WithDefault scopes requests that name no tenant to tenant, for running
+// single-tenant clients against a multi-tenant deployment.
+func WithDefault(tenant string) Option {
+	return func(c *config) { c.fallback = tenant }
+}
+
+// WithExempt skips tenant resolution for the given full method names or
+// URL paths; entries ending in / match every method of a service or every
+// path below them. The gRPC health service is always exempt.
+func WithExempt(methods ...string) Option {
+	return func(c *config) { c.exempt = append(c.exempt, methods...) }
+}
+
+func newConfig(opts []Option) config {
+	cfg := config{claim: DefaultClaim}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	return cfg
+}
+
+func (c config) isExempt(method string) bool {
+	if strings.HasPrefix(method, healthPrefix) {
+		return true
+	}
+	for _, p := range c.exempt {
+		if p == method || (strings.HasSuffix(p, "/") && strings.HasPrefix(method, p)) {
+			return true
+		}
+	}
+	return false
+}
+
+// Resolution failures, mapped to status codes by the callers.
+var (
+	errMismatch = errors.New("tenant does not match the token's tenant")
+	errMissing  = errors.New("tenant is required")
+)
+
+// resolve returns the tenant of a request that named requested, or "" if
+// none applies.
+func (c config) resolve(ctx context.Context, requested string) (string, error) {
+	if requested == "" {
+		requested = baggage.Get(ctx, baggage.Tenant)
+	}
+	if claims, ok := auth.ClaimsFromContext(ctx); ok {
+		if t, _ := claims.Raw[c.claim].(string); t != "" {
+			if requested != "" && requested != t {
+				return "", errMismatch
+			}
+			requested = t
+		}
+	}
+	if requested == "" {
+		requested = c.fallback
+	}
+	if requested == "" {
+		if c.required {
+			return "", errMissing
+		}
+		return "", nil
+	}
+	if !Valid(requested) {
+		return "", fmt.Errorf("invalid tenant %q", requested)
+	}
+	return requested, nil
+}
+
+func (c config) incoming(ctx context.Context) (context.Context, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	var requested string
+	if v := md.Get(MetadataKey); len(v) > 0 {
+		requested = v[0]
+	}
+	t, err := c.resolve(ctx, requested)
+	switch {
+	case errors.Is(err, errMismatch):
+		return nil, status.Error(codes.PermissionDenied, err.Error())
+	case err != nil:
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	case t == "":
+		return ctx, nil
+	}
+	return NewContext(ctx, t), nil
+}
+
+// UnaryServerInterceptor resolves the tenant of each call and stores it in
+// the context. Place it after the auth interceptor so the token's claim is
+// honoured.
+func UnaryServerInterceptor(opts ...Option) grpc.UnaryServerInterceptor {
+	cfg := newConfig(opts)
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		if cfg.isExempt(info.FullMethod) {
+			return handler(ctx, req)
+		}
+		ctx, err := cfg.incoming(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+// StreamServerInterceptor is the streaming variant of UnaryServerInterceptor.
+func StreamServerInterceptor(opts ...Option) grpc.StreamServerInterceptor {
+	cfg := newConfig(opts)
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if cfg.isExempt(info.FullMethod) {
+			return handler(srv, ss)
+		}
+		ctx, err := cfg.incoming(ss.Context())
+		if err != nil {
+			return err
+		}
+		return handler(srv, &serverStream{ServerStream: ss, ctx: ctx})
+	}
+}
+
+type serverStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *serverStream) Context() context.Context { return s.ctx }
+
+// Middleware is the HTTP counterpart of UnaryServerInterceptor, reading the
+// X-Tenant-Id header. Place it after auth.Middleware and baggage.Middleware.
+// Refused requests get 403 or 400.
+func Middleware(next http.Handler, opts ...Option) http.Handler {
+	cfg := newConfig(opts)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if cfg.isExempt(r.URL.Path) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		t, err := cfg.resolve(r.Context(), r.Header.Get(Header))
+		switch {
+		case errors.Is(err, errMismatch):
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		case err != nil:
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		case t != "":
+			r = r.WithContext(NewContext(r.Context(), t))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,116 @@
+package tenancy
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"github.com/GoogleCloudPlatform/microservices-demo/src/shared/auth"
+	"github.com/GoogleCloudPlatform/microservices-demo/src/shared/baggage"
+)
+
+const method = "/hipstershop.CartService/GetCart"
+
+func call(intercept grpc.UnaryServerInterceptor, ctx context.Context, method string) (string, error) {
+	var got string
+	_, err := intercept(ctx, nil, &grpc.UnaryServerInfo{FullMethod: method}, func(ctx context.Context, req any) (any, error) {
+		got, _ = FromContext(ctx)
+		return nil, nil
+	})
+	return got, err
+}
+
+func withMetadata(tenant string) context.Context {
+	return metadata.NewIncomingContext(context.Background(), metadata.Pairs(MetadataKey, tenant))
+}
+
+func TestUnaryServerInterceptor(t *testing.T) {
+	intercept := UnaryServerInterceptor(WithRequired())
+	claims := &auth.Claims{Subject: "u1", Raw: map[string]any{DefaultClaim: "acme"}}
+
+	tests := []struct {
+		name string
+		ctx  context.Context
+		want string
+		code codes.Code
+	}{
+		{"metadata", withMetadata("acme"), "acme", codes.OK},
+		{"baggage", baggage.Set(context.Background(), baggage.Tenant, "globex"), "globex", codes.OK},
+		{"claim", auth.NewContext(context.Background(), claims), "acme", codes.OK},
+		{"claim mismatch", auth.NewContext(withMetadata("globex"), claims), "", codes.PermissionDenied},
+		{"invalid", withMetadata("Acme Corp"), "", codes.InvalidArgument},
+		{"missing", context.Background(), "", codes.InvalidArgument},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := call(intercept, tt.ctx, method)
+			if status.Code(err) != tt.code || got != tt.want {
+				t.Errorf("tenant %q, err %v; want %q, %v", got, err, tt.want, tt.code)
+			}
+		})
+	}
+
+	if _, err := call(intercept, context.Background(), "/grpc.health.v1.Health/Check"); err != nil {
+		t.Errorf("health check: %v, want exempt", err)
+	}
+	if got, err := call(UnaryServerInterceptor(WithDefault("demo")), context.Background(), method); err != nil || got != "demo" {
+		t.Errorf("WithDefault: tenant %q, err %v; want demo", got, err)
+	}
+}
+
+func TestNewContextSetsBaggage(t *testing.T) {
+	ctx := NewContext(context.Background(), "acme")
+	if got := baggage.Get(ctx, baggage.Tenant); got != "acme" {
+		t.Errorf("baggage tenant = %q, want acme", got)
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	var got string
+	h := Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got, _ = FromContext(r.Context())
+	}), WithRequired(), WithExempt("/healthz"))
+
+	req := httptest.NewRequest(http.MethodGet, "/cart", nil)
+	req.Header.Set(Header, "acme")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK || got != "acme" {
+		t.Errorf("status %d, tenant %q; want 200, acme", rec.Code, got)
+	}
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/cart", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("without tenant: status %d, want 400", rec.Code)
+	}
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("exempt path: status %d, want 200", rec.Code)
+	}
+}
+
+func TestKeys(t *testing.T) {
+	if _, err := Key(context.Background(), "cart:1"); !errors.Is(err, ErrNoTenant) {
+		t.Errorf("Key without tenant: %v, want ErrNoTenant", err)
+	}
+	ctx := NewContext(context.Background(), "acme-corp")
+	key, err := Key(ctx, "cart:1")
+	if err != nil || key != "acme-corp:cart:1" {
+		t.Errorf("Key = %q, %v", key, err)
+	}
+	if tenant, rest, ok := SplitKey(key); !ok || tenant != "acme-corp" || rest != "cart:1" {
+		t.Errorf("SplitKey(%q) = %q, %q, %v", key, tenant, rest, ok)
+	}
+	if s, err := Schema(ctx); err != nil || s != "tenant_acme_corp" {
+		t.Errorf("Schema = %q, %v", s, err)
+	}
+}
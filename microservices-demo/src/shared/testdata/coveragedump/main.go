@@ -0,0 +1,15 @@
+// Command coveragedump is run by TestDumpCoverageUploadsSubprocess to check
+// that DumpCoverage uploads its dump to the storage named by GOCOV_UPLOAD_URL.
+package main
+
+import (
+	"log"
+
+	shared "github.com/GoogleCloudPlatform/microservices-demo/src/shared"
+)
+
+func main() {
+	if err := shared.DumpCoverage(""); err != nil {
+		log.Fatal(err)
+	}
+}
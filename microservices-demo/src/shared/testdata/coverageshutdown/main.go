@@ -0,0 +1,19 @@
+// Command coverageshutdown is stopped with SIGTERM by
+// TestDumpOnShutdownSubprocess to check that WithDumpOnShutdown writes the
+// coverage counters before the process dies from the signal.
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	shared "github.com/GoogleCloudPlatform/microservices-demo/src/shared"
+)
+
+func main() {
+	shared.SetupCoverageSignalHandler(shared.WithDumpOnShutdown())
+	fmt.Println("ready")
+	time.Sleep(time.Minute)
+	os.Exit(3)
+}
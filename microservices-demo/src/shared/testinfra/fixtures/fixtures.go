@@ -0,0 +1,281 @@
+// Package fixtures generates deterministic fixtures shaped like the demo
+// protos (products, carts, orders, money values, addresses and cards) and
+// compares test output against golden files, so service tests share one set
+// of fixture builders instead of each writing its own.
+//
+// A Gen seeded with the same value produces the same fixtures on every run
+// and machine. Fixtures are plain structs whose JSON field names follow the
+// proto JSON mapping; ToProto turns one into a service's generated message:
+//
+//	g := fixtures.New(1)
+//	var cart pb.Cart
+//	if err := fixtures.ToProto(g.Cart(3), &cart); err != nil {
+//	    t.Fatal(err)
+//	}
+package fixtures
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math/rand/v2"
+	"strings"
+	"time"
+
+	shared "github.com/GoogleCloudPlatform/microservices-demo/src/shared"
+)
+
+// Epoch is the time of the generator's clock, which stamps generated IDs.
+var Epoch = time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// Product mirrors hipstershop.Product.
+type Product struct {
+	ID          string       `json:"id"`
+	Name        string       `json:"name"`
+	Description string       `json:"description"`
+	Picture     string       `json:"picture"`
+	PriceUSD    shared.Money `json:"priceUsd"`
+	Categories  []string     `json:"categories"`
+}
+
+// CartItem mirrors hipstershop.CartItem.
+type CartItem struct {
+	ProductID string `json:"productId"`
+	Quantity  int32  `json:"quantity"`
+}
+
+// Cart mirrors hipstershop.Cart.
+type Cart struct {
+	UserID string     `json:"userId"`
+	Items  []CartItem `json:"items"`
+}
+
+// Address mirrors hipstershop.Address.
+type Address struct {
+	StreetAddress string `json:"streetAddress"`
+	City          string `json:"city"`
+	State         string `json:"state"`
+	Country       string `json:"country"`
+	ZipCode       int32  `json:"zipCode"`
+}
+
+// CreditCard mirrors hipstershop.CreditCardInfo.
+type CreditCard struct {
+	Number          string `json:"creditCardNumber"`
+	CVV             int32  `json:"creditCardCvv"`
+	ExpirationYear  int32  `json:"creditCardExpirationYear"`
+	ExpirationMonth int32  `json:"creditCardExpirationMonth"`
+}
+
+// OrderItem mirrors hipstershop.OrderItem.
+type OrderItem struct {
+	Item CartItem     `json:"item"`
+	Cost shared.Money `json:"cost"`
+}
+
+// Order mirrors hipstershop.OrderResult.
+type Order struct {
+	OrderID            string       `json:"orderId"`
+	ShippingTrackingID string       `json:"shippingTrackingId"`
+	ShippingCost       shared.Money `json:"shippingCost"`
+	ShippingAddress    Address      `json:"shippingAddress"`
+	Items              []OrderItem  `json:"items"`
+}
+
+func usd(units int64, nanos int32) shared.Money {
+	return shared.Money{Currency: "USD", Units: units, Nanos: nanos}
+}
+
+// Catalog is the demo's product catalog, as in productcatalogservice's
+// products.json (descriptions shortened).
+var Catalog = []Product{
+	{ID: "OLJCESPC7Z", Name: "Sunglasses", Description: "Add a modern touch to your outfits with these sleek aviator sunglasses.", Picture: "/static/img/products/sunglasses.jpg", PriceUSD: usd(19, 990000000), Categories: []string{"accessories"}},
+	{ID: "66VCHSJNUP", Name: "Tank Top", Description: "Perfectly cropped cotton tank, with a scooped neckline.", Picture: "/static/img/products/tank-top.jpg", PriceUSD: usd(18, 990000000), Categories: []string{"clothing", "tops"}},
+	{ID: "1YMWWN1N4O", Name: "Watch", Description: "This gold-tone stainless steel watch will work with most of your outfits.", Picture: "/static/img/products/watch.jpg", PriceUSD: usd(109, 990000000), Categories: []string{"accessories"}},
+	{ID: "L9ECAV7KIM", Name: "Loafers", Description: "A neat addition to your summer wardrobe.", Picture: "/static/img/products/loafers.jpg", PriceUSD: usd(89, 990000000), Categories: []string{"footwear"}},
+	{ID: "2ZYFJ3GM2N", Name: "Hairdryer", Description: "This lightweight hairdryer has 3 heat and speed settings.", Picture: "/static/img/products/hairdryer.jpg", PriceUSD: usd(24, 990000000), Categories: []string{"hair", "beauty"}},
+	{ID: "0PUK6V6EV0", Name: "Candle Holder", Description: "This small but intricate candle holder is an excellent gift.", Picture: "/static/img/products/candle-holder.jpg", PriceUSD: usd(18, 990000000), Categories: []string{"decor", "home"}},
+	{ID: "LS4PSXUNUM", Name: "Salt & Pepper Shakers", Description: "Add some flavor to your kitchen.", Picture: "/static/img/products/salt-and-pepper-shakers.jpg", PriceUSD: usd(18, 490000000), Categories: []string{"kitchen"}},
+	{ID: "9SIQT8TOJO", Name: "Bamboo Glass Jar", Description: "This bamboo glass jar can hold 57 oz (1.7 l) and is perfect for any kitchen.", Picture: "/static/img/products/bamboo-glass-jar.jpg", PriceUSD: usd(5, 490000000), Categories: []string{"kitchen"}},
+	{ID: "6E92ZMYYFZ", Name: "Mug", Description: "A simple mug with a mustard interior.", Picture: "/static/img/products/mug.jpg", PriceUSD: usd(8, 990000000), Categories: []string{"kitchen"}},
+}
+
+// Currencies are the currencies the demo's frontend offers.
+var Currencies = []string{"USD", "EUR", "CAD", "JPY", "GBP", "TRY"}
+
+var (
+	adjectives = []string{"Vintage", "Classic", "Organic", "Compact", "Deluxe", "Handmade", "Minimal", "Rustic"}
+	nouns      = []string{"Lamp", "Backpack", "Teapot", "Scarf", "Notebook", "Planter", "Sneakers", "Headphones"}
+	categories = []string{"accessories", "clothing", "decor", "footwear", "home", "kitchen", "beauty"}
+	streets    = []string{"Amphitheatre Parkway", "Spear Street", "Market Street", "Broadway", "Main Street", "Elm Street"}
+	cities     = []struct{ city, state, country string }{
+		{"Mountain View", "CA", "United States"},
+		{"San Francisco", "CA", "United States"},
+		{"New York", "NY", "United States"},
+		{"Seattle", "WA", "United States"},
+		{"Toronto", "ON", "Canada"},
+	}
+	firstNames = []string{"alice", "bob", "carol", "dave", "erin", "frank", "grace", "heidi"}
+	// cardPrefixes are test card ranges: Visa, Mastercard and Amex.
+	cardPrefixes = []struct {
+		prefix string
+		length int
+	}{{"4111", 16}, {"5555", 16}, {"3782", 15}}
+)
+
+// Gen generates fixtures from a seed. It is not safe for concurrent use;
+// give each goroutine its own.
+type Gen struct {
+	r   *rand.Rand
+	ids *shared.IDGenerator
+}
+
+// New returns a generator seeded with seed.
+func New(seed uint64) *Gen {
+	var key [32]byte
+	binary.LittleEndian.PutUint64(key[:], seed)
+	src := rand.NewChaCha8(key)
+	entropy := rand.NewChaCha8(key)
+	entropy.Uint64() // split the ID stream from the value stream
+	return &Gen{
+		r:   rand.New(src),
+		ids: shared.NewIDGenerator(shared.WithIDClock(shared.NewFakeClock(Epoch)), shared.WithIDEntropy(entropy)),
+	}
+}
+
+// Intn returns a number in [0, n).
+func (g *Gen) Intn(n int) int { return g.r.IntN(n) }
+
+func pick[T any](g *Gen, s []T) T { return s[g.r.IntN(len(s))] }
+
+// ID returns a prefixed ID of kind, as shared.NewID would.
+func (g *Gen) ID(kind shared.IDKind) string { return g.ids.NewID(kind) }
+
+// ProductID returns a 10-character catalog-style ID.
+func (g *Gen) ProductID() string {
+	const alphabet = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZ"
+	b := make([]byte, 10)
+	for i := range b {
+		b[i] = alphabet[g.r.IntN(len(alphabet))]
+	}
+	return string(b)
+}
+
+// Money returns an amount in currency between 1 and 200 units, ending in a
+// .49 or .99 price point as the catalog's do.
+func (g *Gen) Money(currency string) shared.Money {
+	return shared.Money{Currency: currency, Units: 1 + g.r.Int64N(200), Nanos: pick(g, []int32{490000000, 990000000})}
+}
+
+// Product returns a product outside the catalog.
+func (g *Gen) Product() Product {
+	name := pick(g, adjectives) + " " + pick(g, nouns)
+	slug := strings.ToLower(strings.ReplaceAll(name, " ", "-"))
+	return Product{
+		ID:          g.ProductID(),
+		Name:        name,
+		Description: fmt.Sprintf("A %s for everyday use.", strings.ToLower(name)),
+		Picture:     "/static/img/products/" + slug + ".jpg",
+		PriceUSD:    g.Money("USD"),
+		Categories:  []string{pick(g, categories)},
+	}
+}
+
+// Products returns n products.
+func (g *Gen) Products(n int) []Product {
+	out := make([]Product, n)
+	for i := range out {
+		out[i] = g.Product()
+	}
+	return out
+}
+
+// CatalogProduct returns a product of Catalog.
+func (g *Gen) CatalogProduct() Product { return pick(g, Catalog) }
+
+// Cart returns a cart of a new user holding n distinct catalog products
+// (at most len(Catalog)), 1 to 5 of each.
+func (g *Gen) Cart(n int) Cart {
+	perm := g.r.Perm(len(Catalog))
+	c := Cart{UserID: g.ID(shared.IDUser)}
+	for _, i := range perm[:min(n, len(perm))] {
+		c.Items = append(c.Items, CartItem{ProductID: Catalog[i].ID, Quantity: 1 + g.r.Int32N(5)})
+	}
+	return c
+}
+
+// Address returns a street address.
+func (g *Gen) Address() Address {
+	c := pick(g, cities)
+	return Address{
+		StreetAddress: fmt.Sprintf("%d %s", 1+g.r.IntN(2000), pick(g, streets)),
+		City:          c.city,
+		State:         c.state,
+		Country:       c.country,
+		ZipCode:       10000 + g.r.Int32N(89999),
+	}
+}
+
+// Email returns an address at example.com, which is reserved for testing.
+func (g *Gen) Email() string {
+	return fmt.Sprintf("%s.%d@example.com", pick(g, firstNames), g.r.IntN(1000))
+}
+
+// CreditCard returns a card with a Luhn-valid test number that expires in
+// one to five years from Epoch.
+func (g *Gen) CreditCard() CreditCard {
+	p := pick(g, cardPrefixes)
+	digits := []byte(p.prefix)
+	for len(digits) < p.length-1 {
+		digits = append(digits, byte('0'+g.r.IntN(10)))
+	}
+	cvvDigits := int32(3)
+	if p.length == 15 {
+		cvvDigits = 4
+	}
+	cvvMax := int32(1)
+	for range cvvDigits {
+		cvvMax *= 10
+	}
+	return CreditCard{
+		Number:          string(append(digits, luhnDigit(digits))),
+		CVV:             cvvMax/10 + g.r.Int32N(cvvMax-cvvMax/10),
+		ExpirationYear:  int32(Epoch.Year()) + 1 + g.r.Int32N(5),
+		ExpirationMonth: 1 + g.r.Int32N(12),
+	}
+}
+
+// luhnDigit returns the check digit completing digits.
+func luhnDigit(digits []byte) byte {
+	sum := 0
+	for i := len(digits) - 1; i >= 0; i-- {
+		d := int(digits[i] - '0')
+		if (len(digits)-1-i)%2 == 0 {
+			if d *= 2; d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+	}
+	return byte('0' + (10-sum%10)%10)
+}
+
+// Order returns the order placing cart, with costs from the catalog and a
+// shipping cost, as checkoutservice would return it.
+func (g *Gen) Order(cart Cart) Order {
+	o := Order{
+		OrderID:            g.ID(shared.IDOrder),
+		ShippingTrackingID: g.ID(shared.IDShipment),
+		ShippingCost:       usd(int64(5+g.r.IntN(20)), 990000000),
+		ShippingAddress:    g.Address(),
+	}
+	for _, it := range cart.Items {
+		cost := usd(0, 0)
+		for _, p := range Catalog {
+			if p.ID == it.ProductID {
+				cost = p.PriceUSD
+			}
+		}
+		o.Items = append(o.Items, OrderItem{Item: it, Cost: cost})
+	}
+	return o
+}
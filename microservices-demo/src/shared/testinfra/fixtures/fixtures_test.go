@@ -0,0 +1,162 @@
+package fixtures
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+func TestDeterministic(t *testing.T) {
+	gen := func(seed uint64) Order {
+		g := New(seed)
+		return g.Order(g.Cart(4))
+	}
+	a, b := gen(7), gen(7)
+	if !reflect.DeepEqual(a, b) {
+		t.Fatalf("same seed produced different orders:\n%+v\n%+v", a, b)
+	}
+	if reflect.DeepEqual(a, gen(8)) {
+		t.Fatal("different seeds produced the same order")
+	}
+	if !strings.HasPrefix(a.OrderID, "ord_") || !strings.HasPrefix(a.ShippingTrackingID, "shp_") {
+		t.Errorf("ids = %q, %q", a.OrderID, a.ShippingTrackingID)
+	}
+}
+
+func TestValues(t *testing.T) {
+	g := New(1)
+	for range 200 {
+		if m := g.Money("EUR"); !m.Valid() || !m.IsPositive() {
+			t.Fatalf("Money = %v", m)
+		}
+		c := g.CreditCard()
+		if !luhnValid(c.Number) {
+			t.Fatalf("card number %s fails the Luhn check", c.Number)
+		}
+		if c.ExpirationMonth < 1 || c.ExpirationMonth > 12 || c.ExpirationYear <= int32(Epoch.Year()) {
+			t.Fatalf("expiry %d/%d", c.ExpirationMonth, c.ExpirationYear)
+		}
+		if z := g.Address().ZipCode; z < 10000 || z > 99999 {
+			t.Fatalf("zip code %d", z)
+		}
+	}
+	cart := g.Cart(len(Catalog) + 5)
+	seen := map[string]bool{}
+	for _, it := range cart.Items {
+		if seen[it.ProductID] || it.Quantity < 1 {
+			t.Fatalf("cart items %+v", cart.Items)
+		}
+		seen[it.ProductID] = true
+	}
+	if len(cart.Items) != len(Catalog) {
+		t.Errorf("cart holds %d products, want %d", len(cart.Items), len(Catalog))
+	}
+	for _, it := range g.Order(cart).Items {
+		if it.Cost.IsZero() {
+			t.Errorf("catalog product %s has no cost", it.Item.ProductID)
+		}
+	}
+}
+
+func luhnValid(n string) bool {
+	return luhnDigit([]byte(n[:len(n)-1])) == n[len(n)-1]
+}
+
+func TestLuhnDigit(t *testing.T) {
+	// 4111 1111 1111 1111 is the well-known Visa test number.
+	if d := luhnDigit([]byte("411111111111111")); d != '1' {
+		t.Errorf("luhnDigit = %c, want 1", d)
+	}
+}
+
+// orderMessage builds a message shaped like hipstershop.OrderResult, trimmed
+// to the fields the test needs.
+func orderMessage(t *testing.T) proto.Message {
+	t.Helper()
+	str, i32, i64, msg := descriptorpb.FieldDescriptorProto_TYPE_STRING.Enum(),
+		descriptorpb.FieldDescriptorProto_TYPE_INT32.Enum(),
+		descriptorpb.FieldDescriptorProto_TYPE_INT64.Enum(),
+		descriptorpb.FieldDescriptorProto_TYPE_MESSAGE.Enum()
+	opt, rep := descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(), descriptorpb.FieldDescriptorProto_LABEL_REPEATED.Enum()
+	field := func(name string, num int32, typ *descriptorpb.FieldDescriptorProto_Type, label *descriptorpb.FieldDescriptorProto_Label, msgType string) *descriptorpb.FieldDescriptorProto {
+		f := &descriptorpb.FieldDescriptorProto{Name: proto.String(name), Number: proto.Int32(num), Type: typ, Label: label}
+		if msgType != "" {
+			f.TypeName = proto.String(msgType)
+		}
+		return f
+	}
+	fd, err := protodesc.NewFile(&descriptorpb.FileDescriptorProto{
+		Name:    proto.String("test.proto"),
+		Package: proto.String("test"),
+		Syntax:  proto.String("proto3"),
+		MessageType: []*descriptorpb.DescriptorProto{
+			{Name: proto.String("Money"), Field: []*descriptorpb.FieldDescriptorProto{
+				field("currency_code", 1, str, opt, ""), field("units", 2, i64, opt, ""), field("nanos", 3, i32, opt, ""),
+			}},
+			{Name: proto.String("CartItem"), Field: []*descriptorpb.FieldDescriptorProto{
+				field("product_id", 1, str, opt, ""), field("quantity", 2, i32, opt, ""),
+			}},
+			{Name: proto.String("OrderItem"), Field: []*descriptorpb.FieldDescriptorProto{
+				field("item", 1, msg, opt, ".test.CartItem"), field("cost", 2, msg, opt, ".test.Money"),
+			}},
+			{Name: proto.String("OrderResult"), Field: []*descriptorpb.FieldDescriptorProto{
+				field("order_id", 1, str, opt, ""), field("shipping_cost", 3, msg, opt, ".test.Money"),
+				field("items", 5, msg, rep, ".test.OrderItem"),
+			}},
+		},
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dynamicpb.NewMessage(fd.Messages().ByName("OrderResult"))
+}
+
+func TestToProto(t *testing.T) {
+	g := New(3)
+	order := g.Order(g.Cart(2))
+	m := orderMessage(t)
+	if err := ToProto(order, m); err != nil {
+		t.Fatal(err)
+	}
+	r := m.ProtoReflect()
+	fields := r.Descriptor().Fields()
+	if got := r.Get(fields.ByName("order_id")).String(); got != order.OrderID {
+		t.Errorf("order_id = %q, want %q", got, order.OrderID)
+	}
+	cost := r.Get(fields.ByName("shipping_cost")).Message()
+	cf := cost.Descriptor().Fields()
+	if cost.Get(cf.ByName("currency_code")).String() != "USD" ||
+		cost.Get(cf.ByName("units")).Int() != order.ShippingCost.Units ||
+		int32(cost.Get(cf.ByName("nanos")).Int()) != order.ShippingCost.Nanos {
+		t.Errorf("shipping_cost = %v, want %v", cost, order.ShippingCost)
+	}
+	if n := r.Get(fields.ByName("items")).List().Len(); n != 2 {
+		t.Errorf("%d items, want 2", n)
+	}
+	GoldenProto(t, "order_proto", m)
+}
+
+func TestGolden(t *testing.T) {
+	g := New(42)
+	cart := g.Cart(3)
+	GoldenJSON(t, "fixtures", map[string]any{
+		"cart":    cart,
+		"order":   g.Order(cart),
+		"card":    g.CreditCard(),
+		"email":   g.Email(),
+		"product": g.Product(),
+	})
+}
+
+func TestLineDiff(t *testing.T) {
+	got := lineDiff("a\nb\nc\nd\n", "a\nb\nx\nd\n")
+	want := "  a\n  b\n- c\n+ x\n"
+	if got != want {
+		t.Errorf("lineDiff =\n%s\nwant\n%s", got, want)
+	}
+}
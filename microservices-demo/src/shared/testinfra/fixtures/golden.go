@@ -0,0 +1,111 @@
+package fixtures
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+// UpdateEnv names the variable that, set to 1, makes the golden helpers
+// rewrite the golden files with the output they are given instead of
+// comparing against them:
+//
+//	UPDATE_GOLDEN=1 go test ./...
+//
+// It is an environment variable rather than a flag so it reaches every
+// package's test binary without each declaring it.
+const UpdateEnv = "UPDATE_GOLDEN"
+
+// GoldenPath returns the file holding the golden output called name:
+// testdata/<name>.golden in the package directory the test runs in.
+func GoldenPath(name string) string {
+	return filepath.Join("testdata", name+".golden")
+}
+
+// Golden compares got with the golden file called name, failing t with a
+// line diff when they differ.
+func Golden(t testing.TB, name string, got []byte) {
+	t.Helper()
+	path := GoldenPath(name)
+	if os.Getenv(UpdateEnv) == "1" {
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, got, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("reading golden file: %v (run with %s=1 to create it)", err, UpdateEnv)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("output differs from %s (run with %s=1 to update it):\n%s", path, UpdateEnv, lineDiff(string(want), string(got)))
+	}
+}
+
+// GoldenJSON compares v, encoded as indented JSON, with the golden file
+// called name.
+func GoldenJSON(t testing.TB, name string, v any) {
+	t.Helper()
+	b, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		t.Fatalf("encoding %T: %v", v, err)
+	}
+	Golden(t, name, append(b, '\n'))
+}
+
+// GoldenProto compares m, encoded as proto JSON, with the golden file called
+// name. protojson deliberately varies its whitespace between runs, so the
+// output is re-indented to keep the file stable.
+func GoldenProto(t testing.TB, name string, m proto.Message) {
+	t.Helper()
+	b, err := protojson.Marshal(m)
+	if err != nil {
+		t.Fatalf("encoding %T: %v", m, err)
+	}
+	var compact, buf bytes.Buffer
+	if err := json.Compact(&compact, b); err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Indent(&buf, compact.Bytes(), "", "  "); err != nil {
+		t.Fatal(err)
+	}
+	buf.WriteByte('\n')
+	Golden(t, name, buf.Bytes())
+}
+
+// lineDiff returns the lines of want and got from the first that differs,
+// marked - and +, with a few lines of context before it.
+func lineDiff(want, got string) string {
+	w, g := strings.Split(want, "\n"), strings.Split(got, "\n")
+	i := 0
+	for i < len(w) && i < len(g) && w[i] == g[i] {
+		i++
+	}
+	var b strings.Builder
+	for _, l := range w[max(0, i-3):i] {
+		fmt.Fprintf(&b, "  %s\n", l)
+	}
+	// Trim the common tail so only the changed lines are marked.
+	we, ge := len(w), len(g)
+	for we > i && ge > i && w[we-1] == g[ge-1] {
+		we--
+		ge--
+	}
+	for _, l := range w[i:we] {
+		fmt.Fprintf(&b, "- %s\n", l)
+	}
+	for _, l := range g[i:ge] {
+		fmt.Fprintf(&b, "+ %s\n", l)
+	}
+	return b.String()
+}
@@ -0,0 +1,64 @@
+package fixtures
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+
+	shared "github.com/GoogleCloudPlatform/microservices-demo/src/shared"
+)
+
+var moneyType = reflect.TypeOf(shared.Money{})
+
+// ToProto fills m, a generated message of the demo protos, from fixture,
+// matching fields by their proto JSON names. shared.Money values become
+// google.type.Money-shaped messages; fields m lacks are ignored, so one
+// fixture serves services whose protos differ slightly.
+func ToProto(fixture any, m proto.Message) error {
+	b, err := json.Marshal(protoJSON(reflect.ValueOf(fixture)))
+	if err != nil {
+		return fmt.Errorf("fixtures: encoding %T: %w", fixture, err)
+	}
+	if err := (protojson.UnmarshalOptions{DiscardUnknown: true}).Unmarshal(b, m); err != nil {
+		return fmt.Errorf("fixtures: converting %T to %T: %w", fixture, m, err)
+	}
+	return nil
+}
+
+// protoJSON returns v as JSON values, with Money in its proto form rather
+// than the {"currency", "amount"} form of its MarshalJSON.
+func protoJSON(v reflect.Value) any {
+	switch v.Kind() {
+	case reflect.Pointer, reflect.Interface:
+		if v.IsNil() {
+			return nil
+		}
+		return protoJSON(v.Elem())
+	case reflect.Slice:
+		out := make([]any, v.Len())
+		for i := range out {
+			out[i] = protoJSON(v.Index(i))
+		}
+		return out
+	case reflect.Struct:
+		if v.Type() == moneyType {
+			m := v.Interface().(shared.Money)
+			// int64 fields are strings in proto JSON.
+			return map[string]any{"currencyCode": m.Currency, "units": fmt.Sprint(m.Units), "nanos": m.Nanos}
+		}
+		out := make(map[string]any, v.NumField())
+		for i := range v.NumField() {
+			f := v.Type().Field(i)
+			name := f.Tag.Get("json")
+			if !f.IsExported() || name == "" || name == "-" {
+				continue
+			}
+			out[name] = protoJSON(v.Field(i))
+		}
+		return out
+	}
+	return v.Interface()
+}
@@ -0,0 +1,140 @@
+package testinfra
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	kafkago "github.com/segmentio/kafka-go"
+)
+
+var kafkaContainer sharedContainer
+
+// KafkaCluster is a Kafka cluster as seen by one test, whose topics carry a
+// prefix of its own.
+type KafkaCluster struct {
+	// Brokers are the bootstrap brokers, for a kafka.Config.
+	Brokers []string
+	// Prefix starts the name of every topic of the test.
+	Prefix string
+
+	t      testing.TB
+	mu     sync.Mutex
+	topics []string
+}
+
+// Kafka returns the cluster for t. Topics made with Topic are deleted when
+// the test ends.
+func Kafka(t testing.TB) *KafkaCluster {
+	t.Helper()
+	cfg, err := loadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	brokers := cfg.KafkaBrokers
+	if len(brokers) == 0 {
+		addr, err := kafkaContainer.get(func() (string, error) { return startKafka(cfg) })
+		if err != nil {
+			unavailable(t, cfg, err)
+		}
+		brokers = []string{addr}
+	}
+	k := &KafkaCluster{Brokers: brokers, Prefix: uniqueName("test-") + ".", t: t}
+	t.Cleanup(k.deleteTopics)
+	return k
+}
+
+// Topic creates the test's topic called name, with partitions partitions
+// (at least 1), and returns its full name.
+func (k *KafkaCluster) Topic(name string, partitions int) string {
+	k.t.Helper()
+	topic := k.Prefix + name
+	err := k.withController(func(conn *kafkago.Conn) error {
+		return conn.CreateTopics(kafkago.TopicConfig{Topic: topic, NumPartitions: max(partitions, 1), ReplicationFactor: 1})
+	})
+	if err != nil && !errors.Is(err, kafkago.TopicAlreadyExists) {
+		k.t.Fatalf("testinfra: creating topic %s: %v", topic, err)
+	}
+	k.mu.Lock()
+	k.topics = append(k.topics, topic)
+	k.mu.Unlock()
+	return topic
+}
+
+func (k *KafkaCluster) deleteTopics() {
+	k.mu.Lock()
+	topics := k.topics
+	k.mu.Unlock()
+	if len(topics) == 0 {
+		return
+	}
+	if err := k.withController(func(conn *kafkago.Conn) error { return conn.DeleteTopics(topics...) }); err != nil {
+		k.t.Logf("testinfra: deleting topics %s: %v", strings.Join(topics, ", "), err)
+	}
+}
+
+// withController calls fn with a connection to the cluster's controller,
+// which topic changes must go to.
+func (k *KafkaCluster) withController(fn func(*kafkago.Conn) error) error {
+	return controllerDo(context.Background(), k.Brokers[0], fn)
+}
+
+func controllerDo(ctx context.Context, broker string, fn func(*kafkago.Conn) error) error {
+	conn, err := kafkago.DialContext(ctx, "tcp", broker)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	c, err := conn.Controller()
+	if err != nil {
+		return err
+	}
+	ctrl, err := kafkago.DialContext(ctx, "tcp", net.JoinHostPort(c.Host, strconv.Itoa(c.Port)))
+	if err != nil {
+		return err
+	}
+	defer ctrl.Close()
+	return fn(ctrl)
+}
+
+// startKafka runs a single-node KRaft broker. It must advertise the address
+// clients reach it on, so it gets a fixed host port rather than a random
+// one.
+func startKafka(cfg Config) (string, error) {
+	port, err := freePort()
+	if err != nil {
+		return "", err
+	}
+	addr, err := run(containerSpec{
+		image:    cfg.KafkaImage,
+		port:     "9092/tcp",
+		hostPort: port,
+		env: []string{
+			"KAFKA_NODE_ID=1",
+			"KAFKA_PROCESS_ROLES=broker,controller",
+			"KAFKA_LISTENERS=PLAINTEXT://:9092,CONTROLLER://:9093",
+			fmt.Sprintf("KAFKA_ADVERTISED_LISTENERS=PLAINTEXT://127.0.0.1:%d", port),
+			"KAFKA_CONTROLLER_LISTENER_NAMES=CONTROLLER",
+			"KAFKA_LISTENER_SECURITY_PROTOCOL_MAP=CONTROLLER:PLAINTEXT,PLAINTEXT:PLAINTEXT",
+			"KAFKA_CONTROLLER_QUORUM_VOTERS=1@localhost:9093",
+			"KAFKA_OFFSETS_TOPIC_REPLICATION_FACTOR=1",
+			"KAFKA_TRANSACTION_STATE_LOG_REPLICATION_FACTOR=1",
+			"KAFKA_TRANSACTION_STATE_LOG_MIN_ISR=1",
+			"KAFKA_GROUP_INITIAL_REBALANCE_DELAY_MS=0",
+		},
+	})
+	if err != nil {
+		return "", err
+	}
+	return addr, waitReady(cfg.StartupTimeout, func(ctx context.Context) error {
+		return controllerDo(ctx, addr, func(conn *kafkago.Conn) error {
+			_, err := conn.Brokers()
+			return err
+		})
+	})
+}
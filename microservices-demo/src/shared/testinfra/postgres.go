@@ -0,0 +1,109 @@
+package testinfra
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/url"
+	"testing"
+
+	"github.com/jackc/pgx/v5"
+
+	"github.com/GoogleCloudPlatform/microservices-demo/src/shared/postgres"
+)
+
+const postgresPassword = "testinfra"
+
+var postgresContainer sharedContainer
+
+// PostgresConfig creates a database for t alone and returns the
+// configuration to connect to it, for tests that open it themselves. The
+// database is dropped when the test ends.
+func PostgresConfig(t testing.TB) postgres.Config {
+	t.Helper()
+	cfg, err := loadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	admin := cfg.PostgresURL
+	if admin == "" {
+		admin, err = postgresContainer.get(func() (string, error) { return startPostgres(cfg) })
+		if err != nil {
+			unavailable(t, cfg, err)
+		}
+	}
+	name := uniqueName("test_")
+	if err := adminExec(admin, "CREATE DATABASE "+pgx.Identifier{name}.Sanitize()); err != nil {
+		t.Fatalf("testinfra: creating database %s: %v", name, err)
+	}
+	t.Cleanup(func() {
+		// FORCE ends the connections of a test that did not close its pool.
+		if err := adminExec(admin, "DROP DATABASE IF EXISTS "+pgx.Identifier{name}.Sanitize()+" WITH (FORCE)"); err != nil {
+			t.Logf("testinfra: dropping database %s: %v", name, err)
+		}
+	})
+	dsn, err := withDatabase(admin, name)
+	if err != nil {
+		t.Fatalf("testinfra: %v", err)
+	}
+	return postgres.Config{DSN: dsn, ConnectTimeout: cfg.StartupTimeout, MaxOpenConns: 5, MaxIdleConns: 2}
+}
+
+// Postgres opens a database for t alone, applying opts such as
+// postgres.WithMigrations. It is closed and dropped when the test
+// ends.
+func Postgres(t testing.TB, opts ...postgres.Option) *sql.DB {
+	t.Helper()
+	pcfg := PostgresConfig(t)
+	opts = append([]postgres.Option{postgres.WithoutTracing()}, opts...)
+	db, err := postgres.Open(context.Background(), pcfg, opts...)
+	if err != nil {
+		t.Fatalf("testinfra: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func startPostgres(cfg Config) (string, error) {
+	addr, err := run(containerSpec{
+		image: cfg.PostgresImage,
+		port:  "5432/tcp",
+		env:   []string{"POSTGRES_PASSWORD=" + postgresPassword},
+		// Durability only slows tests down.
+		args: []string{"-c", "fsync=off", "-c", "synchronous_commit=off", "-c", "full_page_writes=off"},
+	})
+	if err != nil {
+		return "", err
+	}
+	admin := (&url.URL{Scheme: "postgres", User: url.UserPassword("postgres", postgresPassword), Host: addr, Path: "/postgres",
+		RawQuery: "sslmode=disable"}).String()
+	return admin, waitReady(cfg.StartupTimeout, func(ctx context.Context) error {
+		conn, err := pgx.Connect(ctx, admin)
+		if err != nil {
+			return err
+		}
+		defer conn.Close(ctx)
+		return conn.Ping(ctx)
+	})
+}
+
+func adminExec(admin, stmt string) error {
+	ctx := context.Background()
+	conn, err := pgx.Connect(ctx, admin)
+	if err != nil {
+		return err
+	}
+	defer conn.Close(ctx)
+	_, err = conn.Exec(ctx, stmt)
+	return err
+}
+
+// withDatabase returns the URL dsn with its database replaced by name.
+func withDatabase(dsn, name string) (string, error) {
+	u, err := url.Parse(dsn)
+	if err != nil || (u.Scheme != "postgres" && u.Scheme != "postgresql") {
+		return "", fmt.Errorf("TESTINFRA_POSTGRES_URL must be a postgres:// URL")
+	}
+	u.Path = "/" + name
+	return u.String(), nil
+}
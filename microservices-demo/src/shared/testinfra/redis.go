@@ -0,0 +1,85 @@
+package testinfra
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/redis/go-redis/v9"
+
+	sharedredis "github.com/GoogleCloudPlatform/microservices-demo/src/shared/redis"
+)
+
+// redisDatabases is how many logical databases the Redis container has,
+// one per concurrently running test. An existing Redis has the default 16.
+const redisDatabases = 256
+
+var (
+	redisContainer sharedContainer
+	redisDBs       = newDBPool(redisDatabases)
+	externalDBs    = newDBPool(16)
+)
+
+// Redis returns a client of a Redis database that no other running test
+// uses. The database is flushed before and after the test.
+func Redis(t testing.TB) *redis.Client {
+	t.Helper()
+	cfg, err := loadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr, pool := cfg.RedisAddr, externalDBs
+	if addr == "" {
+		pool = redisDBs
+		addr, err = redisContainer.get(func() (string, error) { return startRedis(cfg) })
+		if err != nil {
+			unavailable(t, cfg, err)
+		}
+	}
+	db := pool.acquire()
+	t.Cleanup(func() { pool.release(db) })
+
+	rcfg := sharedredis.Config{Addr: addr, DB: db, PoolSize: 10, DialTimeout: cfg.StartupTimeout}
+	client, err := sharedredis.NewClient(sharedredis.WithConfig(rcfg), sharedredis.WithoutTracing(), sharedredis.WithPing())
+	if err != nil {
+		t.Fatalf("testinfra: %v", err)
+	}
+	if err := client.FlushDB(context.Background()).Err(); err != nil {
+		t.Fatalf("testinfra: flushing redis database %d: %v", db, err)
+	}
+	t.Cleanup(func() {
+		client.FlushDB(context.Background())
+		client.Close()
+	})
+	return client
+}
+
+func startRedis(cfg Config) (string, error) {
+	addr, err := run(containerSpec{
+		image: cfg.RedisImage,
+		port:  "6379/tcp",
+		args:  []string{"redis-server", "--databases", fmt.Sprint(redisDatabases), "--save", "", "--appendonly", "no"},
+	})
+	if err != nil {
+		return "", err
+	}
+	client := redis.NewClient(&redis.Options{Addr: addr})
+	defer client.Close()
+	return addr, waitReady(cfg.StartupTimeout, func(ctx context.Context) error { return client.Ping(ctx).Err() })
+}
+
+// dbPool hands out database numbers, blocking while all are taken.
+type dbPool struct {
+	free chan int
+}
+
+func newDBPool(n int) *dbPool {
+	p := &dbPool{free: make(chan int, n)}
+	for i := range n {
+		p.free <- i
+	}
+	return p
+}
+
+func (p *dbPool) acquire() int   { return <-p.free }
+func (p *dbPool) release(db int) { p.free <- db }
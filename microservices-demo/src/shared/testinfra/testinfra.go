@@ -0,0 +1,244 @@
+// Package testinfra starts the Redis, Postgres and Kafka instances that
+// integration tests need, in Docker containers shared by every test of the
+// package, and hands each test its own isolated slice of them: a Redis
+// database, a Postgres database or a Kafka topic prefix, emptied or dropped
+// when the test ends.
+//
+//	func TestMain(m *testing.M) { testinfra.Main(m) }
+//
+//	func TestCartStore(t *testing.T) {
+//	    rdb := testinfra.Redis(t)
+//	    store := newRedisCartStore(rdb)
+//	    ...
+//	}
+//
+// Containers start on first use, and Main removes them when the tests are
+// done; without it they are left running, labeled
+// org.microservices-demo.testinfra, for
+//
+//	docker rm -f $(docker ps -q -f label=org.microservices-demo.testinfra)
+//
+// Tests are skipped when Docker is not available, unless
+// TESTINFRA_REQUIRED is set, as it should be in CI. Pointing the variables
+// below at existing instances, such as CI service containers, uses those
+// instead of starting containers:
+//
+//	TESTINFRA_REQUIRED        fail rather than skip without the infrastructure
+//	TESTINFRA_REDIS_ADDR      host:port of a Redis to use
+//	TESTINFRA_POSTGRES_URL    URL of a Postgres superuser connection to use
+//	TESTINFRA_KAFKA_BROKERS   comma-separated Kafka brokers to use
+//	TESTINFRA_REDIS_IMAGE     image to start (default redis:7-alpine)
+//	TESTINFRA_POSTGRES_IMAGE  image to start (default postgres:16-alpine)
+//	TESTINFRA_KAFKA_IMAGE     image to start (default apache/kafka:3.8.0)
+//	TESTINFRA_STARTUP_TIMEOUT how long to wait for a container (default 90s)
+package testinfra
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	shared "github.com/GoogleCloudPlatform/microservices-demo/src/shared"
+)
+
+// Label marks the containers the package starts.
+const Label = "org.microservices-demo.testinfra"
+
+// Config is read from the environment on first use.
+type Config struct {
+	Required       bool          `env:"TESTINFRA_REQUIRED"`
+	RedisAddr      string        `env:"TESTINFRA_REDIS_ADDR"`
+	PostgresURL    string        `env:"TESTINFRA_POSTGRES_URL"`
+	KafkaBrokers   []string      `env:"TESTINFRA_KAFKA_BROKERS"`
+	RedisImage     string        `env:"TESTINFRA_REDIS_IMAGE" default:"redis:7-alpine"`
+	PostgresImage  string        `env:"TESTINFRA_POSTGRES_IMAGE" default:"postgres:16-alpine"`
+	KafkaImage     string        `env:"TESTINFRA_KAFKA_IMAGE" default:"apache/kafka:3.8.0"`
+	StartupTimeout time.Duration `env:"TESTINFRA_STARTUP_TIMEOUT" default:"90s"`
+}
+
+var (
+	configOnce sync.Once
+	config     Config
+	configErr  error
+
+	// session labels this process's containers, telling them apart from
+	// those of test binaries running in parallel.
+	session = uniqueName("")
+
+	mu         sync.Mutex
+	containers []string
+)
+
+func loadConfig() (Config, error) {
+	configOnce.Do(func() { configErr = shared.LoadConfig(&config) })
+	return config, configErr
+}
+
+// Main runs the tests and then removes the containers they started. Call it
+// from TestMain.
+func Main(m *testing.M) {
+	code := m.Run()
+	Cleanup()
+	os.Exit(code)
+}
+
+// Cleanup removes the containers started so far. Main calls it.
+func Cleanup() {
+	mu.Lock()
+	ids := containers
+	containers = nil
+	mu.Unlock()
+	if len(ids) > 0 {
+		exec.Command("docker", append([]string{"rm", "-f", "-v"}, ids...)...).Run()
+	}
+}
+
+// unavailable skips t, or fails it when the infrastructure is required.
+func unavailable(t testing.TB, cfg Config, err error) {
+	t.Helper()
+	if cfg.Required {
+		t.Fatalf("testinfra: %v", err)
+	}
+	t.Skipf("testinfra: %v (set TESTINFRA_REQUIRED=1 to fail instead)", err)
+}
+
+// sharedContainer is a lazily started container, reused by every test.
+type sharedContainer struct {
+	once sync.Once
+	addr string
+	err  error
+}
+
+// get starts the container on first use and returns its address.
+func (c *sharedContainer) get(start func() (string, error)) (string, error) {
+	c.once.Do(func() { c.addr, c.err = start() })
+	return c.addr, c.err
+}
+
+// containerSpec describes a container to run.
+type containerSpec struct {
+	image string
+	// port is the container port to publish, e.g. "6379/tcp".
+	port string
+	// hostPort publishes port on this host port instead of a random one,
+	// for servers that must advertise their address.
+	hostPort int
+	env      []string
+	args     []string
+}
+
+var (
+	dockerOnce sync.Once
+	dockerErr  error
+)
+
+// dockerAvailable reports whether the docker CLI can reach a daemon.
+func dockerAvailable() error {
+	dockerOnce.Do(func() {
+		if _, err := exec.LookPath("docker"); err != nil {
+			dockerErr = errors.New("docker is not installed")
+			return
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if out, err := exec.CommandContext(ctx, "docker", "version", "--format", "{{.Server.Version}}").CombinedOutput(); err != nil {
+			dockerErr = fmt.Errorf("docker daemon unreachable: %v: %s", err, bytes.TrimSpace(out))
+		}
+	})
+	return dockerErr
+}
+
+// run starts a container and returns the host address of its port.
+func run(spec containerSpec) (string, error) {
+	if err := dockerAvailable(); err != nil {
+		return "", err
+	}
+	publish := "127.0.0.1::" + spec.port
+	if spec.hostPort != 0 {
+		publish = fmt.Sprintf("127.0.0.1:%d:%s", spec.hostPort, spec.port)
+	}
+	args := []string{"run", "-d", "--rm", "--label", Label + "=" + session, "-p", publish}
+	for _, e := range spec.env {
+		args = append(args, "-e", e)
+	}
+	args = append(append(args, spec.image), spec.args...)
+	out, err := exec.Command("docker", args...).CombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("starting %s: %v: %s", spec.image, err, bytes.TrimSpace(out))
+	}
+	id := strings.TrimSpace(string(out))
+	// Pulling may precede the ID with progress lines.
+	id = id[strings.LastIndexByte(id, '\n')+1:]
+	mu.Lock()
+	containers = append(containers, id)
+	mu.Unlock()
+
+	out, err = exec.Command("docker", "port", id, spec.port).Output()
+	if err != nil {
+		return "", fmt.Errorf("finding the port of %s: %v", spec.image, err)
+	}
+	return parsePort(string(out))
+}
+
+// parsePort picks the IPv4 address out of docker port output such as
+// "127.0.0.1:49153\n[::1]:49153".
+func parsePort(out string) (string, error) {
+	for _, line := range strings.Fields(out) {
+		host, port, err := net.SplitHostPort(line)
+		if err != nil || strings.Contains(host, ":") {
+			continue
+		}
+		if host == "0.0.0.0" {
+			host = "127.0.0.1"
+		}
+		return net.JoinHostPort(host, port), nil
+	}
+	return "", fmt.Errorf("no IPv4 port in docker output %q", out)
+}
+
+// waitReady calls ready until it succeeds or timeout has passed.
+func waitReady(timeout time.Duration, ready func(ctx context.Context) error) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	for {
+		attempt, cancelAttempt := context.WithTimeout(ctx, 5*time.Second)
+		err := ready(attempt)
+		cancelAttempt()
+		if err == nil {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("not ready after %s: %w", timeout, err)
+		case <-time.After(250 * time.Millisecond):
+		}
+	}
+}
+
+// uniqueName returns prefix followed by random hex, valid as a database or
+// topic name.
+func uniqueName(prefix string) string {
+	b := make([]byte, 6)
+	rand.Read(b)
+	return prefix + hex.EncodeToString(b)
+}
+
+// freePort returns a host port that is free right now.
+func freePort() (int, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return 0, err
+	}
+	defer l.Close()
+	return l.Addr().(*net.TCPAddr).Port, nil
+}
@@ -0,0 +1,106 @@
+package testinfra
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	kafkago "github.com/segmentio/kafka-go"
+)
+
+func TestMain(m *testing.M) { Main(m) }
+
+func TestParsePort(t *testing.T) {
+	for out, want := range map[string]string{
+		"127.0.0.1:49153\n[::1]:49153\n": "127.0.0.1:49153",
+		"[::]:5000\n0.0.0.0:5000\n":      "127.0.0.1:5000",
+	} {
+		if got, err := parsePort(out); err != nil || got != want {
+			t.Errorf("parsePort(%q) = %q, %v, want %q", out, got, err, want)
+		}
+	}
+	if _, err := parsePort("[::1]:49153"); err == nil {
+		t.Error("parsePort without IPv4 succeeded")
+	}
+}
+
+func TestWithDatabase(t *testing.T) {
+	got, err := withDatabase("postgres://u:p@db:5432/postgres?sslmode=disable", "test_1")
+	if err != nil || got != "postgres://u:p@db:5432/test_1?sslmode=disable" {
+		t.Errorf("withDatabase = %q, %v", got, err)
+	}
+	if _, err := withDatabase("host=db user=u", "test_1"); err == nil {
+		t.Error("withDatabase accepted a keyword/value DSN")
+	}
+}
+
+func TestDBPool(t *testing.T) {
+	p := newDBPool(2)
+	a, b := p.acquire(), p.acquire()
+	if a == b {
+		t.Fatalf("acquired database %d twice", a)
+	}
+	got := make(chan int)
+	go func() { got <- p.acquire() }()
+	select {
+	case db := <-got:
+		t.Fatalf("acquired database %d from an exhausted pool", db)
+	case <-time.After(10 * time.Millisecond):
+	}
+	p.release(b)
+	if db := <-got; db != b {
+		t.Errorf("acquired %d, want released %d", db, b)
+	}
+}
+
+func TestUniqueName(t *testing.T) {
+	a, b := uniqueName("test_"), uniqueName("test_")
+	if a == b || !strings.HasPrefix(a, "test_") || len(a) != len("test_")+12 {
+		t.Errorf("uniqueName = %q, %q", a, b)
+	}
+}
+
+func TestRedisIsolation(t *testing.T) {
+	ctx := context.Background()
+	a, b := Redis(t), Redis(t)
+	if err := a.Set(ctx, "k", "a", 0).Err(); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := b.Exists(ctx, "k").Result(); err != nil || n != 0 {
+		t.Errorf("second database sees the first's key: %d, %v", n, err)
+	}
+}
+
+func TestPostgres(t *testing.T) {
+	db := Postgres(t)
+	if _, err := db.Exec("CREATE TABLE orders (id text PRIMARY KEY)"); err != nil {
+		t.Fatal(err)
+	}
+	// A second database does not see the table.
+	var n int
+	if err := Postgres(t).QueryRow("SELECT count(*) FROM pg_tables WHERE tablename = 'orders'").Scan(&n); err != nil || n != 0 {
+		t.Errorf("second database sees the table: %d, %v", n, err)
+	}
+}
+
+func TestKafka(t *testing.T) {
+	k := Kafka(t)
+	topic := k.Topic("orders", 1)
+	if !strings.HasPrefix(topic, k.Prefix) {
+		t.Fatalf("topic %q lacks prefix %q", topic, k.Prefix)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	w := &kafkago.Writer{Addr: kafkago.TCP(k.Brokers...), Topic: topic}
+	defer w.Close()
+	if err := w.WriteMessages(ctx, kafkago.Message{Value: []byte("hello")}); err != nil {
+		t.Fatal(err)
+	}
+	r := kafkago.NewReader(kafkago.ReaderConfig{Brokers: k.Brokers, Topic: topic})
+	defer r.Close()
+	m, err := r.ReadMessage(ctx)
+	if err != nil || string(m.Value) != "hello" {
+		t.Errorf("read %q, %v", m.Value, err)
+	}
+}
@@ -0,0 +1,360 @@
+package testing
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	stdtesting "testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+)
+
+// DefaultMaxPerMethod is how many calls of each method a Recorder keeps.
+const DefaultMaxPerMethod = 10
+
+// Interaction is one recorded unary call. Messages are kept as proto JSON
+// with every field emitted, so a field missing from a replayed response
+// means the field is gone rather than that it was empty.
+type Interaction struct {
+	Method       string          `json:"method"`
+	RequestType  string          `json:"requestType"`
+	Request      json.RawMessage `json:"request"`
+	ResponseType string          `json:"responseType,omitempty"`
+	Response     json.RawMessage `json:"response,omitempty"`
+	Code         string          `json:"code"`
+}
+
+// Contract is the recorded behavior of a service: what its callers sent and
+// got back. Checked in next to a service's tests, it lets a new version be
+// verified against what the deployed callers rely on.
+type Contract struct {
+	Service      string        `json:"service,omitempty"`
+	Interactions []Interaction `json:"interactions"`
+}
+
+// LoadContract reads a contract written by Recorder.Save.
+func LoadContract(path string) (*Contract, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var c Contract
+	if err := json.Unmarshal(b, &c); err != nil {
+		return nil, fmt.Errorf("sharedtesting: parsing contract %s: %w", path, err)
+	}
+	return &c, nil
+}
+
+var recordJSON = protojson.MarshalOptions{EmitUnpopulated: true}
+
+// Recorder records the unary calls passing through its interceptors during
+// an integration run, up to DefaultMaxPerMethod of each method unless set
+// otherwise:
+//
+//	rec := sharedtesting.NewRecorder("hipstershop.ShippingService")
+//	h := sharedtesting.NewServiceHarness(t, register,
+//	    sharedtesting.WithServerOptions(grpcserver.WithUnaryInterceptors(rec.UnaryServerInterceptor())))
+//	... exercise the service ...
+//	rec.Save("testdata/shipping.contract.json")
+//
+// A later version, possibly built from another repository, verifies it still
+// honors the contract with ReplayContract.
+type Recorder struct {
+	service      string
+	maxPerMethod int
+
+	mu       sync.Mutex
+	recorded []Interaction
+	counts   map[string]int
+}
+
+// NewRecorder returns a Recorder for service, recording only its methods;
+// an empty service records every method.
+func NewRecorder(service string) *Recorder {
+	return &Recorder{service: service, maxPerMethod: DefaultMaxPerMethod, counts: make(map[string]int)}
+}
+
+// SetMaxPerMethod changes how many calls of each method are kept.
+func (r *Recorder) SetMaxPerMethod(n int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.maxPerMethod = n
+}
+
+// UnaryServerInterceptor records the calls a server handles.
+func (r *Recorder) UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		resp, err := handler(ctx, req)
+		r.record(info.FullMethod, req, resp, err)
+		return resp, err
+	}
+}
+
+// UnaryClientInterceptor records the calls a client makes, e.g. to capture
+// what a caller relies on from its side.
+func (r *Recorder) UnaryClientInterceptor() grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		err := invoker(ctx, method, req, reply, cc, opts...)
+		var resp any
+		if err == nil {
+			resp = reply
+		}
+		r.record(method, req, resp, err)
+		return err
+	}
+}
+
+func (r *Recorder) record(method string, req, resp any, err error) {
+	if r.service != "" && !strings.HasPrefix(method, "/"+r.service+"/") {
+		return
+	}
+	reqMsg, ok := req.(proto.Message)
+	if !ok {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.counts[method] >= r.maxPerMethod {
+		return
+	}
+	in := Interaction{Method: method, RequestType: string(reqMsg.ProtoReflect().Descriptor().FullName()), Code: status.Code(err).String()}
+	var merr error
+	if in.Request, merr = recordJSON.Marshal(reqMsg); merr != nil {
+		return
+	}
+	if respMsg, ok := resp.(proto.Message); ok && err == nil {
+		in.ResponseType = string(respMsg.ProtoReflect().Descriptor().FullName())
+		if in.Response, merr = recordJSON.Marshal(respMsg); merr != nil {
+			return
+		}
+	}
+	r.counts[method]++
+	r.recorded = append(r.recorded, in)
+}
+
+// Contract returns what has been recorded so far.
+func (r *Recorder) Contract() *Contract {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return &Contract{Service: r.service, Interactions: slices.Clone(r.recorded)}
+}
+
+// Save writes the recorded contract to path as indented JSON, so changes to
+// it read well in review.
+func (r *Recorder) Save(path string) error {
+	// Marshaling compacts the raw messages before indenting, evening out
+	// protojson's deliberately varying spacing.
+	b, err := json.MarshalIndent(r.Contract(), "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(b, '\n'), 0o644)
+}
+
+// Incompatibility is a difference between a contract and the behavior of
+// the service verified against it.
+type Incompatibility struct {
+	Method string
+	// Kind is "request" (the request no longer parses), "code",
+	// "missing_field", "type_changed" or "value".
+	Kind string
+	// Path locates the field in the response, e.g. "items[2].cost.units".
+	Path      string
+	Want, Got string
+}
+
+func (i Incompatibility) String() string {
+	if i.Path != "" {
+		return fmt.Sprintf("%s: %s at %s: want %s, got %s", i.Method, i.Kind, i.Path, i.Want, i.Got)
+	}
+	return fmt.Sprintf("%s: %s: want %s, got %s", i.Method, i.Kind, i.Want, i.Got)
+}
+
+// VerifyOption configures Verify.
+type VerifyOption func(*verifyConfig)
+
+type verifyConfig struct {
+	values bool
+	ignore []string
+}
+
+// WithValues also compares the values of response fields, except at the
+// given paths, with array indices written as [], e.g. "orderId" or
+// "items[].cost.units". By default only the fields' presence and types are
+// checked, since IDs and timestamps differ between runs.
+func WithValues(ignore ...string) VerifyOption {
+	return func(c *verifyConfig) { c.values, c.ignore = true, append(c.ignore, ignore...) }
+}
+
+// Verify replays the requests of c on conn and reports how the responses
+// differ from the recorded ones: a status code that changed, a field that
+// was removed or whose JSON type changed, and, with WithValues, a value
+// that changed. New fields are compatible and not reported. The message
+// types must be linked into the test binary, as any generated package is.
+func Verify(ctx context.Context, conn grpc.ClientConnInterface, c *Contract, opts ...VerifyOption) []Incompatibility {
+	var cfg verifyConfig
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	var out []Incompatibility
+	for _, in := range c.Interactions {
+		out = append(out, verifyOne(ctx, conn, in, &cfg)...)
+	}
+	return out
+}
+
+func verifyOne(ctx context.Context, conn grpc.ClientConnInterface, in Interaction, cfg *verifyConfig) []Incompatibility {
+	fail := func(kind, want, got string) []Incompatibility {
+		return []Incompatibility{{Method: in.Method, Kind: kind, Want: want, Got: got}}
+	}
+	req, err := newMessage(in.RequestType)
+	if err != nil {
+		return fail("request", in.RequestType, err.Error())
+	}
+	// Strict parsing: a recorded field the request no longer has would be
+	// silently dropped for a real caller.
+	if err := protojson.Unmarshal(in.Request, req); err != nil {
+		return fail("request", "a valid "+in.RequestType, err.Error())
+	}
+	respType := in.ResponseType
+	if respType == "" {
+		respType = "google.protobuf.Empty"
+	}
+	resp, err := responseFor(in.Method, respType)
+	if err != nil {
+		return fail("request", in.Method, err.Error())
+	}
+	callErr := conn.Invoke(ctx, in.Method, req, resp)
+	if got := status.Code(callErr).String(); got != in.Code {
+		return fail("code", in.Code, fmt.Sprintf("%s (%v)", got, callErr))
+	}
+	if callErr != nil || in.Response == nil {
+		return nil
+	}
+	gotJSON, err := recordJSON.Marshal(resp)
+	if err != nil {
+		return fail("response", respType, err.Error())
+	}
+	var want, got any
+	if err := json.Unmarshal(in.Response, &want); err != nil {
+		return fail("response", "recorded JSON", err.Error())
+	}
+	if err := json.Unmarshal(gotJSON, &got); err != nil {
+		return fail("response", "JSON", err.Error())
+	}
+	var out []Incompatibility
+	compareJSON(in.Method, "", "", want, got, cfg, &out)
+	return out
+}
+
+// responseFor returns an empty response of the method, looked up in the
+// linked service descriptors, falling back to the recorded type.
+func responseFor(method, recorded string) (proto.Message, error) {
+	service, name, ok := strings.Cut(strings.TrimPrefix(method, "/"), "/")
+	if ok {
+		if d, err := protoregistry.GlobalFiles.FindDescriptorByName(protoreflect.FullName(service)); err == nil {
+			if sd, ok := d.(protoreflect.ServiceDescriptor); ok {
+				if md := sd.Methods().ByName(protoreflect.Name(name)); md != nil {
+					return newMessage(string(md.Output().FullName()))
+				}
+			}
+		}
+	}
+	return newMessage(recorded)
+}
+
+func newMessage(name string) (proto.Message, error) {
+	mt, err := protoregistry.GlobalTypes.FindMessageByName(protoreflect.FullName(name))
+	if err != nil {
+		return nil, fmt.Errorf("message type %s is not linked into the test: %w", name, err)
+	}
+	return mt.New().Interface(), nil
+}
+
+// compareJSON reports where got lacks or changes what want has. pattern is
+// path with indices replaced by [], for matching WithValues' ignores.
+func compareJSON(method, path, pattern string, want, got any, cfg *verifyConfig, out *[]Incompatibility) {
+	report := func(kind, w, g string) {
+		*out = append(*out, Incompatibility{Method: method, Kind: kind, Path: strings.TrimPrefix(path, "."), Want: w, Got: g})
+	}
+	if want != nil && got != nil && jsonKind(want) != jsonKind(got) {
+		report("type_changed", jsonKind(want), jsonKind(got))
+		return
+	}
+	switch w := want.(type) {
+	case map[string]any:
+		g := got.(map[string]any)
+		keys := make([]string, 0, len(w))
+		for k := range w {
+			keys = append(keys, k)
+		}
+		slices.Sort(keys)
+		for _, k := range keys {
+			gv, ok := g[k]
+			if !ok {
+				*out = append(*out, Incompatibility{Method: method, Kind: "missing_field",
+					Path: strings.TrimPrefix(path+"."+k, "."), Want: "present", Got: "absent"})
+				continue
+			}
+			compareJSON(method, path+"."+k, pattern+"."+k, w[k], gv, cfg, out)
+		}
+	case []any:
+		g := got.([]any)
+		for i := range min(len(w), len(g)) {
+			compareJSON(method, path+"["+strconv.Itoa(i)+"]", pattern+"[]", w[i], g[i], cfg, out)
+		}
+		if cfg.values && len(w) != len(g) && !slices.Contains(cfg.ignore, strings.TrimPrefix(pattern, ".")) {
+			report("value", fmt.Sprintf("%d elements", len(w)), fmt.Sprintf("%d elements", len(g)))
+		}
+	default:
+		if cfg.values && !reflect.DeepEqual(want, got) && !slices.Contains(cfg.ignore, strings.TrimPrefix(pattern, ".")) {
+			report("value", fmt.Sprint(want), fmt.Sprint(got))
+		}
+	}
+}
+
+func jsonKind(v any) string {
+	switch v.(type) {
+	case map[string]any:
+		return "object"
+	case []any:
+		return "array"
+	case string:
+		return "string"
+	case float64:
+		return "number"
+	case bool:
+		return "bool"
+	case nil:
+		return "null"
+	}
+	return fmt.Sprintf("%T", v)
+}
+
+// ReplayContract verifies conn against the contract at path, failing t with
+// every incompatibility found:
+//
+//	h := sharedtesting.NewServiceHarness(t, register)
+//	sharedtesting.ReplayContract(t, h.Conn, "testdata/shipping.contract.json",
+//	    sharedtesting.WithValues("trackingId"))
+func ReplayContract(t stdtesting.TB, conn grpc.ClientConnInterface, path string, opts ...VerifyOption) {
+	t.Helper()
+	c, err := LoadContract(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, inc := range Verify(context.Background(), conn, c, opts...) {
+		t.Errorf("contract %s: %s", path, inc)
+	}
+}
@@ -0,0 +1,104 @@
+package testing
+
+import (
+	"context"
+	"encoding/json"
+	"path/filepath"
+	"strings"
+	stdtesting "testing"
+
+	"google.golang.org/grpc"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+
+	"github.com/GoogleCloudPlatform/microservices-demo/src/shared/grpcserver"
+)
+
+// recordContract records a SERVING check and a NotFound one against a
+// service whose backend reports SERVING.
+func recordContract(t *stdtesting.T) string {
+	rec := NewRecorder(healthpb.Health_ServiceDesc.ServiceName)
+	h := newHarness(t, &fakeBackend{status: healthpb.HealthCheckResponse_SERVING},
+		WithServerOptions(grpcserver.WithUnaryInterceptors(rec.UnaryServerInterceptor())))
+	client := healthpb.NewHealthClient(h.Conn)
+	for _, svc := range []string{"", "", "missing"} {
+		client.Check(context.Background(), &healthpb.HealthCheckRequest{Service: svc})
+	}
+	rec.SetMaxPerMethod(0)
+	client.Check(context.Background(), &healthpb.HealthCheckRequest{})
+
+	c := rec.Contract()
+	if len(c.Interactions) != 3 {
+		t.Fatalf("recorded %d interactions, want 3", len(c.Interactions))
+	}
+	if c.Interactions[2].Code != "NotFound" || c.Interactions[2].Response != nil {
+		t.Errorf("failed call recorded as %+v", c.Interactions[2])
+	}
+	path := filepath.Join(t.TempDir(), "health.contract.json")
+	if err := rec.Save(path); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestContractRoundTrip(t *stdtesting.T) {
+	path := recordContract(t)
+	h := newHarness(t, &fakeBackend{status: healthpb.HealthCheckResponse_SERVING})
+	ReplayContract(t, h.Conn, path, WithValues())
+}
+
+func TestContractFlagsChanges(t *stdtesting.T) {
+	c, err := LoadContract(recordContract(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The new version reports NOT_SERVING and fails unknown services with
+	// Internal instead of NotFound.
+	h := NewServiceHarness(t, func(h *ServiceHarness, s *grpc.Server) {
+		healthpb.RegisterHealthServer(s, &changedService{})
+	})
+	got := Verify(context.Background(), h.Conn, c)
+	if len(got) != 1 || got[0].Kind != "code" || got[0].Want != "NotFound" {
+		t.Errorf("Verify = %v, want only the changed code", got)
+	}
+	got = Verify(context.Background(), h.Conn, c, WithValues())
+	if len(got) != 3 || got[0].Kind != "value" || got[0].Path != "status" {
+		t.Errorf("Verify with values = %v, want two status values and the code", got)
+	}
+	if got := Verify(context.Background(), h.Conn, c, WithValues("status")); len(got) != 1 {
+		t.Errorf("Verify ignoring status = %v, want only the code", got)
+	}
+}
+
+type changedService struct {
+	healthpb.UnimplementedHealthServer
+}
+
+func (changedService) Check(_ context.Context, req *healthpb.HealthCheckRequest) (*healthpb.HealthCheckResponse, error) {
+	if req.Service == "missing" {
+		panic("unknown service")
+	}
+	return &healthpb.HealthCheckResponse{Status: healthpb.HealthCheckResponse_NOT_SERVING}, nil
+}
+
+func TestContractSchemaChanges(t *stdtesting.T) {
+	h := newHarness(t, &fakeBackend{status: healthpb.HealthCheckResponse_SERVING})
+	check := "/" + healthpb.Health_ServiceDesc.ServiceName + "/Check"
+	c := &Contract{Interactions: []Interaction{
+		// A response field the service no longer has, and one whose type
+		// changed.
+		{Method: check, RequestType: "grpc.health.v1.HealthCheckRequest", Request: json.RawMessage(`{"service":""}`),
+			ResponseType: "grpc.health.v1.HealthCheckResponse", Response: json.RawMessage(`{"status":1,"zone":"a"}`), Code: "OK"},
+		// A request field the service no longer accepts.
+		{Method: check, RequestType: "grpc.health.v1.HealthCheckRequest", Request: json.RawMessage(`{"service":"","verbose":true}`), Code: "OK"},
+		{Method: check, RequestType: "hipstershop.Gone", Request: json.RawMessage(`{}`), Code: "OK"},
+	}}
+	got := Verify(context.Background(), h.Conn, c)
+	var kinds []string
+	for _, inc := range got {
+		kinds = append(kinds, inc.Kind+" "+inc.Path)
+	}
+	want := "type_changed status,missing_field zone,request ,request "
+	if strings.Join(kinds, ",") != want {
+		t.Errorf("Verify = %v, want kinds %q", got, want)
+	}
+}
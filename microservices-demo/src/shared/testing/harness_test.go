@@ -0,0 +1,114 @@
+package testing
+
+import (
+	"context"
+	"sync"
+	stdtesting "testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+
+	apperrors "github.com/GoogleCloudPlatform/microservices-demo/src/shared/errors"
+	"github.com/GoogleCloudPlatform/microservices-demo/src/shared/grpcclient"
+	"github.com/GoogleCloudPlatform/microservices-demo/src/shared/grpcserver"
+	"github.com/GoogleCloudPlatform/microservices-demo/src/shared/requestid"
+)
+
+// fakeBackend answers Check with a fixed status and records the request
+// IDs it receives.
+type fakeBackend struct {
+	healthpb.UnimplementedHealthServer
+	status healthpb.HealthCheckResponse_ServingStatus
+
+	mu  sync.Mutex
+	ids []string
+}
+
+func (f *fakeBackend) Check(ctx context.Context, _ *healthpb.HealthCheckRequest) (*healthpb.HealthCheckResponse, error) {
+	f.mu.Lock()
+	f.ids = append(f.ids, requestid.FromContext(ctx))
+	f.mu.Unlock()
+	return &healthpb.HealthCheckResponse{Status: f.status}, nil
+}
+
+// frontService reports its backend's status for the empty service name,
+// fails with a mapped error for "missing" and panics for "panic".
+type frontService struct {
+	healthpb.UnimplementedHealthServer
+	backend healthpb.HealthClient
+}
+
+func (s *frontService) Check(ctx context.Context, req *healthpb.HealthCheckRequest) (*healthpb.HealthCheckResponse, error) {
+	switch req.Service {
+	case "missing":
+		return nil, apperrors.NotFound("SERVICE_NOT_FOUND", "no service %q", req.Service)
+	case "panic":
+		panic("boom")
+	}
+	return s.backend.Check(ctx, req)
+}
+
+func newHarness(t *stdtesting.T, backend *fakeBackend, opts ...Option) *ServiceHarness {
+	opts = append(opts, WithFake("backend", func(s grpc.ServiceRegistrar) { healthpb.RegisterHealthServer(s, backend) }))
+	return NewServiceHarness(t, func(h *ServiceHarness, s *grpc.Server) {
+		healthpb.RegisterHealthServer(s, &frontService{backend: healthpb.NewHealthClient(h.Fake("backend"))})
+	}, opts...)
+}
+
+func TestHarnessCallsThroughFakes(t *stdtesting.T) {
+	backend := &fakeBackend{status: healthpb.HealthCheckResponse_NOT_SERVING}
+	h := newHarness(t, backend)
+
+	ctx := requestid.NewContext(context.Background(), "req-1")
+	resp, err := healthpb.NewHealthClient(h.Conn).Check(ctx, &healthpb.HealthCheckRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Status != healthpb.HealthCheckResponse_NOT_SERVING {
+		t.Errorf("status = %v, want the fake's NOT_SERVING", resp.Status)
+	}
+	// The request ID crossed both hops, so the interceptors ran on the
+	// clients and the servers.
+	if len(backend.ids) != 1 || backend.ids[0] != "req-1" {
+		t.Errorf("backend saw request IDs %q, want [req-1]", backend.ids)
+	}
+}
+
+func TestHarnessAppliesInterceptorStack(t *stdtesting.T) {
+	h := newHarness(t, &fakeBackend{})
+	client := healthpb.NewHealthClient(h.Conn)
+
+	_, err := client.Check(context.Background(), &healthpb.HealthCheckRequest{Service: "missing"})
+	if status.Code(err) != codes.NotFound {
+		t.Errorf("mapped error = %v, want NotFound", err)
+	}
+	_, err = client.Check(context.Background(), &healthpb.HealthCheckRequest{Service: "panic"})
+	if status.Code(err) != codes.Internal {
+		t.Errorf("panic = %v, want Internal", err)
+	}
+}
+
+func TestHarnessOptions(t *stdtesting.T) {
+	var server, client int
+	h := newHarness(t, &fakeBackend{},
+		WithServerOptions(grpcserver.WithUnaryInterceptors(func(ctx context.Context, req any, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+			server++
+			return handler(ctx, req)
+		})),
+		WithClientOptions(grpcclient.WithoutTracing()))
+
+	conn := h.Dial(grpcclient.WithUnaryInterceptors(func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		client++
+		return invoker(ctx, method, req, reply, cc, opts...)
+	}))
+	if _, err := healthpb.NewHealthClient(conn).Check(context.Background(), &healthpb.HealthCheckRequest{}); err != nil {
+		t.Fatal(err)
+	}
+	// Only the service under test gets the server options; the fake's call
+	// does not count.
+	if server != 1 || client != 1 {
+		t.Errorf("server interceptor ran %d times, client interceptor %d, want 1 each", server, client)
+	}
+}
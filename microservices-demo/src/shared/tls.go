@@ -0,0 +1,444 @@
+package shared
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+
+	"github.com/GoogleCloudPlatform/microservices-demo/src/shared/spiffe"
+)
+
+// tlsReloadInterval bounds how often the certificate files are checked for
+// changes; checks happen lazily during handshakes.
+var tlsReloadInterval = 10 * time.Second
+
+// tlsReloadGen counts SIGHUPs; a reloader seeing it change checks its files
+// on the next handshake regardless of tlsReloadInterval.
+var (
+	tlsReloadGen    atomic.Int64
+	tlsReloadSignal sync.Once
+)
+
+// TLSFiles locates a certificate, its private key and the CA bundle used to
+// verify peers. The default names match the keys of a cert-manager Secret.
+type TLSFiles struct {
+	CertFile string
+	KeyFile  string
+	// CAFile, if set, enables mutual TLS: servers require client
+	// certificates signed by it and clients verify servers against it
+	// instead of the system roots.
+	CAFile string
+}
+
+// TLSFilesFromEnv reads TLS_CERT_FILE, TLS_KEY_FILE and TLS_CA_FILE, falling
+// back to tls.crt, tls.key and ca.crt in TLS_DIR (default /etc/tls). The CA
+// file is only used if it exists.
+func TLSFilesFromEnv() TLSFiles {
+	dir := os.Getenv("TLS_DIR")
+	if dir == "" {
+		dir = "/etc/tls"
+	}
+	f := TLSFiles{
+		CertFile: os.Getenv("TLS_CERT_FILE"),
+		KeyFile:  os.Getenv("TLS_KEY_FILE"),
+		CAFile:   os.Getenv("TLS_CA_FILE"),
+	}
+	if f.CertFile == "" {
+		f.CertFile = filepath.Join(dir, "tls.crt")
+	}
+	if f.KeyFile == "" {
+		f.KeyFile = filepath.Join(dir, "tls.key")
+	}
+	if f.CAFile == "" {
+		if ca := filepath.Join(dir, "ca.crt"); fileExists(ca) {
+			f.CAFile = ca
+		}
+	}
+	return f
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+// TLSOption changes where the TLS helpers get certificates from or which
+// peers they accept.
+type TLSOption func(*tlsOptions)
+
+type tlsOptions struct {
+	svids spiffe.X509Source
+	peers []string
+}
+
+// WithSPIFFE takes the certificate and trust bundle from src, usually a
+// spiffe.Source following the SPIFFE Workload API, instead of the files,
+// which are then ignored. Peers are verified as SPIFFE SVIDs against the
+// bundle rather than by host name, and servers require client certificates.
+// Without WithPeerIDs, any workload the bundle vouches for is accepted.
+func WithSPIFFE(src spiffe.X509Source) TLSOption {
+	return func(o *tlsOptions) { o.svids = src }
+}
+
+// WithPeerIDs only accepts peers whose certificate carries one of the given
+// SPIFFE IDs, or any ID of a trust domain given as "spiffe://domain" (see
+// spiffe.Allowlist). Servers need a CA to request client certificates from
+// for the list to apply.
+func WithPeerIDs(ids ...string) TLSOption {
+	return func(o *tlsOptions) { o.peers = append(o.peers, ids...) }
+}
+
+// TLSOptionsFromEnv returns WithSPIFFE when SPIFFE_ENDPOINT_SOCKET is set,
+// waiting until ctx is done for the first SVID, and WithPeerIDs for the
+// comma-separated SPIFFE_ALLOWED_IDS. The Workload API source is kept for
+// the life of the process.
+func TLSOptionsFromEnv(ctx context.Context) ([]TLSOption, error) {
+	var opts []TLSOption
+	if os.Getenv(spiffe.EndpointSocketEnv) != "" {
+		src, err := spiffe.NewSource(ctx, spiffe.SourceConfig{})
+		if err != nil {
+			return nil, err
+		}
+		log.Printf("TLS: Using SPIFFE identity %s", src.ID())
+		opts = append(opts, WithSPIFFE(src))
+	}
+	if ids := os.Getenv("SPIFFE_ALLOWED_IDS"); ids != "" {
+		opts = append(opts, WithPeerIDs(strings.Split(ids, ",")...))
+	}
+	return opts, nil
+}
+
+// peerCheck returns the function checking a verified peer's leaf against
+// the allowlist, or nil without one.
+func (o *tlsOptions) peerCheck() (func(*x509.Certificate) error, error) {
+	if len(o.peers) == 0 {
+		return nil, nil
+	}
+	allow, err := spiffe.ParseAllowlist(o.peers...)
+	if err != nil {
+		return nil, err
+	}
+	return func(leaf *x509.Certificate) error {
+		id, err := spiffe.IDFromCert(leaf)
+		if err != nil {
+			return err
+		}
+		return allow.Allow(id)
+	}, nil
+}
+
+func newTLSOptions(opts []TLSOption) *tlsOptions {
+	o := &tlsOptions{}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// LoadServerTLS returns a server tls.Config serving the certificate in files
+// and, when a CA is given, requiring client certificates signed by it. The
+// files are re-read when they change, so certificates renewed by cert-manager
+// are served to new connections without a restart, at the latest
+// tlsReloadInterval after they change or on the first handshake after a
+// SIGHUP.
+func LoadServerTLS(files TLSFiles, opts ...TLSOption) (*tls.Config, error) {
+	o := newTLSOptions(opts)
+	check, err := o.peerCheck()
+	if err != nil {
+		return nil, err
+	}
+	if o.svids != nil {
+		return spiffeServerTLS(o.svids, check), nil
+	}
+	if check != nil && files.CAFile == "" {
+		return nil, errors.New("tls: peer ID allowlist needs a CA to verify client certificates")
+	}
+	r, err := newCertReloader(files)
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			cert, pool := r.current()
+			cfg := &tls.Config{
+				MinVersion:   tls.VersionTLS12,
+				Certificates: []tls.Certificate{*cert},
+			}
+			if pool != nil {
+				cfg.ClientCAs = pool
+				cfg.ClientAuth = tls.RequireAndVerifyClientCert
+			}
+			if check != nil {
+				cfg.VerifyPeerCertificate = func(_ [][]byte, chains [][]*x509.Certificate) error {
+					return check(chains[0][0])
+				}
+			}
+			return cfg, nil
+		},
+	}, nil
+}
+
+// spiffeServerTLS serves the current SVID and requires clients to present
+// SVIDs from the current bundle.
+func spiffeServerTLS(src spiffe.X509Source, check func(*x509.Certificate) error) *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			cert, err := src.X509SVID()
+			if err != nil {
+				return nil, err
+			}
+			bundle := src.X509Bundle()
+			return &tls.Config{
+				MinVersion:   tls.VersionTLS12,
+				Certificates: []tls.Certificate{*cert},
+				ClientAuth:   tls.RequireAnyClientCert,
+				VerifyPeerCertificate: func(raw [][]byte, _ [][]*x509.Certificate) error {
+					return verifySVID(raw, bundle, check)
+				},
+			}, nil
+		},
+	}
+}
+
+// verifySVID verifies a peer's chain as an SVID and checks its ID.
+func verifySVID(raw [][]byte, bundle *x509.CertPool, check func(*x509.Certificate) error) error {
+	certs := make([]*x509.Certificate, 0, len(raw))
+	for _, der := range raw {
+		c, err := x509.ParseCertificate(der)
+		if err != nil {
+			return fmt.Errorf("tls: parsing peer certificate: %w", err)
+		}
+		certs = append(certs, c)
+	}
+	if _, err := spiffe.Verify(certs, bundle); err != nil {
+		return err
+	}
+	if check != nil {
+		return check(certs[0])
+	}
+	return nil
+}
+
+// LoadClientTLS returns a client tls.Config presenting the certificate in
+// files and verifying the server, named serverName, against the CA in files
+// (or the system roots without one). Like LoadServerTLS it picks up renewed
+// files without a restart.
+//
+// The certificate and key may be left empty for server-only TLS.
+func LoadClientTLS(files TLSFiles, serverName string, opts ...TLSOption) (*tls.Config, error) {
+	o := newTLSOptions(opts)
+	check, err := o.peerCheck()
+	if err != nil {
+		return nil, err
+	}
+	cfg := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		ServerName: serverName,
+	}
+	if src := o.svids; src != nil {
+		cfg.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			return src.X509SVID()
+		}
+		cfg.InsecureSkipVerify = true // verified as an SVID below
+		cfg.VerifyConnection = func(cs tls.ConnectionState) error {
+			raw := make([][]byte, len(cs.PeerCertificates))
+			for i, c := range cs.PeerCertificates {
+				raw[i] = c.Raw
+			}
+			return verifySVID(raw, src.X509Bundle(), check)
+		}
+		return cfg, nil
+	}
+	if check != nil {
+		cfg.VerifyConnection = func(cs tls.ConnectionState) error {
+			return check(cs.PeerCertificates[0])
+		}
+	}
+	if files.CertFile == "" && files.CAFile == "" {
+		return cfg, nil
+	}
+	r, err := newCertReloader(files)
+	if err != nil {
+		return nil, err
+	}
+	if files.CertFile != "" {
+		cfg.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			cert, _ := r.current()
+			return cert, nil
+		}
+	}
+	if files.CAFile != "" {
+		// RootCAs cannot be swapped on a live config, so verification is
+		// done here against the current pool instead of by crypto/tls.
+		cfg.InsecureSkipVerify = true
+		cfg.VerifyConnection = func(cs tls.ConnectionState) error {
+			_, pool := r.current()
+			if err := verifyServerChain(cs, pool); err != nil {
+				return err
+			}
+			if check != nil {
+				return check(cs.PeerCertificates[0])
+			}
+			return nil
+		}
+	}
+	return cfg, nil
+}
+
+// verifyServerChain performs the verification crypto/tls skips when
+// InsecureSkipVerify is set.
+func verifyServerChain(cs tls.ConnectionState, roots *x509.CertPool) error {
+	if len(cs.PeerCertificates) == 0 {
+		return errors.New("tls: server presented no certificate")
+	}
+	inter := x509.NewCertPool()
+	for _, c := range cs.PeerCertificates[1:] {
+		inter.AddCert(c)
+	}
+	_, err := cs.PeerCertificates[0].Verify(x509.VerifyOptions{
+		DNSName:       cs.ServerName,
+		Roots:         roots,
+		Intermediates: inter,
+	})
+	return err
+}
+
+// GRPCServerTLS returns the grpc.ServerOption serving LoadServerTLS(files, opts...):
+//
+//	opts, err := shared.TLSOptionsFromEnv(ctx)
+//	...
+//	creds, err := shared.GRPCServerTLS(shared.TLSFilesFromEnv(), opts...)
+//	srv := grpcserver.New(grpcserver.WithServerOptions(creds))
+func GRPCServerTLS(files TLSFiles, opts ...TLSOption) (grpc.ServerOption, error) {
+	cfg, err := LoadServerTLS(files, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return grpc.Creds(credentials.NewTLS(cfg)), nil
+}
+
+// GRPCClientTLS returns the grpc.DialOption dialing with
+// LoadClientTLS(files, serverName, opts...).
+func GRPCClientTLS(files TLSFiles, serverName string, opts ...TLSOption) (grpc.DialOption, error) {
+	cfg, err := LoadClientTLS(files, serverName, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return grpc.WithTransportCredentials(credentials.NewTLS(cfg)), nil
+}
+
+// HTTPTransportTLS returns a clone of http.DefaultTransport using
+// LoadClientTLS(files, serverName, opts...).
+func HTTPTransportTLS(files TLSFiles, serverName string, opts ...TLSOption) (*http.Transport, error) {
+	cfg, err := LoadClientTLS(files, serverName, opts...)
+	if err != nil {
+		return nil, err
+	}
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.TLSClientConfig = cfg
+	return t, nil
+}
+
+// certReloader holds the parsed files and re-reads them when they change.
+type certReloader struct {
+	files TLSFiles
+
+	mu        sync.Mutex
+	cert      *tls.Certificate
+	pool      *x509.CertPool
+	stamp     string
+	lastCheck time.Time
+	gen       int64
+}
+
+func newCertReloader(files TLSFiles) (*certReloader, error) {
+	tlsReloadSignal.Do(func() {
+		DefaultSignalRouter().Handle("tls", func(os.Signal) { tlsReloadGen.Add(1) }, syscall.SIGHUP)
+	})
+	r := &certReloader{files: files, gen: tlsReloadGen.Load()}
+	if err := r.load(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// current returns the certificate and CA pool, reloading them first if the
+// files changed since the last check. A failed reload keeps the previous
+// certificate, since a half-written renewal must not break new connections.
+func (r *certReloader) current() (*tls.Certificate, *x509.CertPool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if gen := tlsReloadGen.Load(); gen != r.gen || time.Since(r.lastCheck) >= tlsReloadInterval {
+		r.lastCheck, r.gen = time.Now(), gen
+		if r.fileStamp() != r.stamp {
+			if err := r.loadLocked(); err != nil {
+				log.Printf("TLS: Reloading %s failed, keeping previous certificate: %v", r.files.CertFile, err)
+			} else {
+				log.Printf("TLS: Reloaded certificate from %s", r.files.CertFile)
+			}
+		}
+	}
+	return r.cert, r.pool
+}
+
+func (r *certReloader) load() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.lastCheck = time.Now()
+	return r.loadLocked()
+}
+
+func (r *certReloader) loadLocked() error {
+	stamp := r.fileStamp()
+	var cert *tls.Certificate
+	if r.files.CertFile != "" {
+		c, err := tls.LoadX509KeyPair(r.files.CertFile, r.files.KeyFile)
+		if err != nil {
+			return fmt.Errorf("tls: loading key pair: %w", err)
+		}
+		cert = &c
+	}
+	var pool *x509.CertPool
+	if r.files.CAFile != "" {
+		pem, err := os.ReadFile(r.files.CAFile)
+		if err != nil {
+			return fmt.Errorf("tls: reading CA: %w", err)
+		}
+		pool = x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("tls: no certificates found in %s", r.files.CAFile)
+		}
+	}
+	r.cert, r.pool, r.stamp = cert, pool, stamp
+	return nil
+}
+
+// fileStamp summarizes the modification times and sizes of the files.
+func (r *certReloader) fileStamp() string {
+	var stamp string
+	for _, p := range []string{r.files.CertFile, r.files.KeyFile, r.files.CAFile} {
+		if p == "" {
+			continue
+		}
+		if fi, err := os.Stat(p); err == nil {
+			stamp += fmt.Sprintf("%d:%d;", fi.ModTime().UnixNano(), fi.Size())
+		}
+	}
+	return stamp
+}
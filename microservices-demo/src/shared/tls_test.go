@@ -0,0 +1,287 @@
+package shared
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testCA issues certificates for TLS tests.
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  []byte
+}
+
+func newTestCA(t *testing.T) *testCA {
+	t.Helper()
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test-ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	return &testCA{cert: cert, key: key, pem: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})}
+}
+
+// issue writes a leaf certificate for name into dir and returns its files.
+func (ca *testCA) issue(t *testing.T, dir, name string, serial int64) TLSFiles {
+	t.Helper()
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: name},
+		DNSNames:     []string{name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, _ := x509.MarshalECPrivateKey(key)
+	f := TLSFiles{
+		CertFile: filepath.Join(dir, "tls.crt"),
+		KeyFile:  filepath.Join(dir, "tls.key"),
+		CAFile:   filepath.Join(dir, "ca.crt"),
+	}
+	os.WriteFile(f.CertFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600)
+	os.WriteFile(f.KeyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600)
+	os.WriteFile(f.CAFile, ca.pem, 0o600)
+	return f
+}
+
+// handshake connects a client to a server and returns the server's leaf
+// certificate serial number.
+func handshake(t *testing.T, server, client *tls.Config) (int64, error) {
+	t.Helper()
+	lis, err := tls.Listen("tcp", "127.0.0.1:0", server)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lis.Close()
+	go func() {
+		c, err := lis.Accept()
+		if err == nil {
+			c.(*tls.Conn).Handshake()
+			c.Close()
+		}
+	}()
+	conn, err := tls.Dial("tcp", lis.Addr().String(), client)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+	return conn.ConnectionState().PeerCertificates[0].SerialNumber.Int64(), nil
+}
+
+func TestMutualTLSWithReload(t *testing.T) {
+	old := tlsReloadInterval
+	tlsReloadInterval = 0
+	t.Cleanup(func() { tlsReloadInterval = old })
+
+	ca := newTestCA(t)
+	serverDir, clientDir := t.TempDir(), t.TempDir()
+	serverFiles := ca.issue(t, serverDir, "shippingservice", 10)
+	clientFiles := ca.issue(t, clientDir, "checkoutservice", 20)
+
+	server, err := LoadServerTLS(serverFiles)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err := LoadClientTLS(clientFiles, "shippingservice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if serial, err := handshake(t, server, client); err != nil || serial != 10 {
+		t.Fatalf("handshake = serial %d, %v; want 10, nil", serial, err)
+	}
+
+	// Simulate cert-manager renewing the server certificate.
+	time.Sleep(10 * time.Millisecond)
+	ca.issue(t, serverDir, "shippingservice", 11)
+	if serial, err := handshake(t, server, client); err != nil || serial != 11 {
+		t.Errorf("handshake after renewal = serial %d, %v; want 11, nil", serial, err)
+	}
+}
+
+func TestMutualTLSRejectsUnknownClient(t *testing.T) {
+	ca, other := newTestCA(t), newTestCA(t)
+	server, err := LoadServerTLS(ca.issue(t, t.TempDir(), "shippingservice", 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err := LoadClientTLS(TLSFiles{CAFile: ca.issue(t, t.TempDir(), "x", 2).CAFile}, "shippingservice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rogue := other.issue(t, t.TempDir(), "rogue", 3)
+	cert, _ := tls.LoadX509KeyPair(rogue.CertFile, rogue.KeyFile)
+	client.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) { return &cert, nil }
+
+	lis, err := tls.Listen("tcp", "127.0.0.1:0", server)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lis.Close()
+	errc := make(chan error, 1)
+	go func() {
+		c, err := lis.Accept()
+		if err != nil {
+			errc <- err
+			return
+		}
+		errc <- c.(*tls.Conn).Handshake()
+		c.Close()
+	}()
+	conn, err := net.Dial("tcp", lis.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	tc := tls.Client(conn, client)
+	tc.Handshake()
+	tc.Close()
+	if err := <-errc; err == nil {
+		t.Error("server accepted a client certificate from an unknown CA")
+	}
+}
+
+// staticSVIDs is a spiffe.X509Source with a fixed SVID.
+type staticSVIDs struct {
+	cert   tls.Certificate
+	bundle *x509.CertPool
+}
+
+func (s *staticSVIDs) X509SVID() (*tls.Certificate, error) { return &s.cert, nil }
+func (s *staticSVIDs) X509Bundle() *x509.CertPool          { return s.bundle }
+
+// svid issues an X.509 SVID for id.
+func (ca *testCA) svid(t *testing.T, id string, serial int64) *staticSVIDs {
+	t.Helper()
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	u, _ := url.Parse(id)
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		URIs:         []*url.URL{u},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bundle := x509.NewCertPool()
+	bundle.AddCert(ca.cert)
+	return &staticSVIDs{cert: tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, bundle: bundle}
+}
+
+// serverHandshake runs a handshake and returns the server's error; under
+// TLS 1.3 a rejected client certificate only shows there.
+func serverHandshake(t *testing.T, server, client *tls.Config) error {
+	t.Helper()
+	lis, err := tls.Listen("tcp", "127.0.0.1:0", server)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lis.Close()
+	errc := make(chan error, 1)
+	go func() {
+		c, err := lis.Accept()
+		if err != nil {
+			errc <- err
+			return
+		}
+		errc <- c.(*tls.Conn).Handshake()
+		c.Close()
+	}()
+	conn, err := tls.Dial("tcp", lis.Addr().String(), client)
+	if err == nil {
+		conn.Close()
+	}
+	return <-errc
+}
+
+func TestSPIFFEMutualTLS(t *testing.T) {
+	ca := newTestCA(t)
+	const (
+		frontend = "spiffe://cluster.local/ns/default/sa/frontend"
+		checkout = "spiffe://cluster.local/ns/default/sa/checkoutservice"
+	)
+	serverSVID, clientSVID := ca.svid(t, checkout, 10), ca.svid(t, frontend, 20)
+
+	server, err := LoadServerTLS(TLSFiles{}, WithSPIFFE(serverSVID), WithPeerIDs(frontend))
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err := LoadClientTLS(TLSFiles{}, "checkoutservice", WithSPIFFE(clientSVID), WithPeerIDs(checkout))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if serial, err := handshake(t, server, client); err != nil || serial != 10 {
+		t.Fatalf("handshake = %d, %v", serial, err)
+	}
+	if err := serverHandshake(t, server, client); err != nil {
+		t.Fatalf("server rejected an allowed peer: %v", err)
+	}
+
+	// The client refuses a server outside its allowlist.
+	picky, err := LoadClientTLS(TLSFiles{}, "checkoutservice", WithSPIFFE(clientSVID), WithPeerIDs("spiffe://cluster.local/ns/default/sa/cartservice"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := handshake(t, server, picky); err == nil {
+		t.Error("client accepted a server not on its allowlist")
+	}
+
+	// The server refuses a client outside its allowlist, and one whose SVID
+	// comes from another trust bundle.
+	stranger, err := LoadClientTLS(TLSFiles{}, "checkoutservice", WithSPIFFE(ca.svid(t, "spiffe://cluster.local/ns/default/sa/adservice", 30)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := serverHandshake(t, server, stranger); err == nil {
+		t.Error("server accepted a client not on its allowlist")
+	}
+	rogue, err := LoadClientTLS(TLSFiles{}, "checkoutservice", WithSPIFFE(newTestCA(t).svid(t, frontend, 40)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := serverHandshake(t, server, rogue); err == nil {
+		t.Error("server accepted an SVID from an unknown trust bundle")
+	}
+}
+
+func TestPeerIDsWithFiles(t *testing.T) {
+	ca := newTestCA(t)
+	files := ca.issue(t, t.TempDir(), "shippingservice", 1)
+	if _, err := LoadServerTLS(TLSFiles{CertFile: files.CertFile, KeyFile: files.KeyFile}, WithPeerIDs("spiffe://cluster.local")); err == nil {
+		t.Error("LoadServerTLS accepted a peer allowlist without a CA to verify clients")
+	}
+	if _, err := LoadServerTLS(files, WithPeerIDs("https://cluster.local")); err == nil {
+		t.Error("LoadServerTLS accepted an invalid SPIFFE ID")
+	}
+}
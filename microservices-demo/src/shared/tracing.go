@@ -0,0 +1,22 @@
+package shared
+
+import (
+	"context"
+
+	"github.com/GoogleCloudPlatform/microservices-demo/src/shared/tracing"
+)
+
+// InitTracing installs the OTLP trace exporter, resource and sampler for
+// serviceName, configured from the environment as described in package
+// tracing, and returns the func that flushes and stops them.
+//
+//	shutdown, err := shared.InitTracing("checkoutservice")
+//	if err != nil {
+//	    log.Warnf("tracing disabled: %v", err)
+//	}
+//	defer shutdown(context.Background())
+//
+// It is tracing.Init with a background context.
+func InitTracing(serviceName string) (tracing.ShutdownFunc, error) {
+	return tracing.Init(context.Background(), serviceName)
+}
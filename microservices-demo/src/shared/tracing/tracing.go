@@ -0,0 +1,238 @@
+// Package tracing bootstraps OpenTelemetry tracing the same way in every
+// service: an OTLP/gRPC exporter, Kubernetes-aware resource attributes, an
+// env-configured sampler and W3C trace-context propagation.
+package tracing
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+
+	"github.com/GoogleCloudPlatform/microservices-demo/src/shared/buildinfo"
+	"github.com/GoogleCloudPlatform/microservices-demo/src/shared/logging"
+	"github.com/GoogleCloudPlatform/microservices-demo/src/shared/metrics"
+	"github.com/GoogleCloudPlatform/microservices-demo/src/shared/podinfo"
+)
+
+// ShutdownFunc flushes buffered spans and releases the exporter.
+type ShutdownFunc func(ctx context.Context) error
+
+// Init configures the global tracer provider and propagator for serviceName
+// and returns a function that flushes and stops the exporter; call it from a
+// shutdown hook.
+// Latency histograms recorded with metrics.Observe then carry the sampled
+// trace of each observation as an exemplar, and with OTEL_LOGS_EXPORTER=otlp
+// the entries of the loggers from package logging are exported to the same
+// collector, tagged with their trace and span.
+//
+// Configuration is read from the environment:
+//
+//	DISABLE_TRACING             any value disables tracing (Init is a no-op)
+//	COLLECTOR_SERVICE_ADDR      OTLP collector host:port; falls back to the
+//	                            standard OTEL_EXPORTER_OTLP_ENDPOINT handling
+//	OTEL_TRACES_SAMPLER         always_on, always_off, traceidratio,
+//	                            parentbased_always_on, parentbased_always_off,
+//	                            parentbased_traceidratio (default parentbased_always_on)
+//	OTEL_TRACES_SAMPLER_ARG     ratio for the traceidratio samplers
+//	OTEL_LOGS_EXPORTER          otlp also exports logs; none (the default)
+//	                            does not
+//	POD_NAME, POD_NAMESPACE,    downward-API values recorded as k8s.* resource
+//	NODE_NAME                   attributes, with the pod labels (see package
+//	                            podinfo)
+//
+// Usage:
+//
+//	shutdown, err := tracing.Init(ctx, "checkoutservice")
+//	if err != nil {
+//	    log.Warnf("tracing disabled: %v", err)
+//	}
+//	defer shutdown(context.Background())
+func Init(ctx context.Context, serviceName string) (ShutdownFunc, error) {
+	noop := func(context.Context) error { return nil }
+	if os.Getenv("DISABLE_TRACING") != "" {
+		return noop, nil
+	}
+
+	sampler, err := samplerFromEnv()
+	if err != nil {
+		return noop, err
+	}
+
+	var opts []otlptracegrpc.Option
+	if addr := os.Getenv("COLLECTOR_SERVICE_ADDR"); addr != "" {
+		opts = append(opts, otlptracegrpc.WithEndpoint(addr), otlptracegrpc.WithInsecure())
+	}
+	exporter, err := otlptracegrpc.New(ctx, opts...)
+	if err != nil {
+		return noop, fmt.Errorf("tracing: creating OTLP exporter: %w", err)
+	}
+
+	res, err := Resource(ctx, serviceName)
+	if err != nil {
+		return noop, err
+	}
+
+	logs, err := logsExporter(res)
+	if err != nil {
+		return noop, err
+	}
+
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithSampler(sampler),
+		sdktrace.WithResource(res),
+	)
+	otel.SetTracerProvider(tp)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{}, propagation.Baggage{}))
+	metrics.SetExemplarFunc(Exemplar)
+	return func(ctx context.Context) error {
+		metrics.SetExemplarFunc(nil)
+		var logsErr error
+		if logs != nil {
+			logsErr = logs(ctx)
+		}
+		return errors.Join(logsErr, tp.Shutdown(ctx))
+	}, nil
+}
+
+// logsExporter installs a logging.Exporter if OTEL_LOGS_EXPORTER asks for
+// one, and returns the function stopping it, or nil. It sends to
+// COLLECTOR_SERVICE_ADDR, or else OTEL_EXPORTER_OTLP_LOGS_ENDPOINT or
+// OTEL_EXPORTER_OTLP_ENDPOINT, using TLS unless the endpoint is http:// or
+// OTEL_EXPORTER_OTLP_INSECURE is true, like the trace exporter.
+func logsExporter(res *resource.Resource) (ShutdownFunc, error) {
+	switch name := strings.ToLower(strings.TrimSpace(os.Getenv("OTEL_LOGS_EXPORTER"))); name {
+	case "", "none":
+		return nil, nil
+	case "otlp":
+	default:
+		return nil, fmt.Errorf("tracing: unknown OTEL_LOGS_EXPORTER %q", name)
+	}
+	target, secure := logsEndpoint()
+	creds := insecure.NewCredentials()
+	if secure {
+		creds = credentials.NewTLS(nil)
+	}
+	conn, err := grpc.NewClient(target, grpc.WithTransportCredentials(creds))
+	if err != nil {
+		return nil, fmt.Errorf("tracing: dialing OTLP logs endpoint %s: %w", target, err)
+	}
+	exp := logging.NewExporter(conn, logging.WithExporterResource(res.Attributes()...))
+	logging.SetExporter(exp)
+	return func(ctx context.Context) error {
+		logging.SetExporter(nil)
+		return errors.Join(exp.Shutdown(ctx), conn.Close())
+	}, nil
+}
+
+// logsEndpoint returns the host:port to export logs to and whether to use
+// TLS.
+func logsEndpoint() (string, bool) {
+	if addr := os.Getenv("COLLECTOR_SERVICE_ADDR"); addr != "" {
+		return addr, false
+	}
+	endpoint := os.Getenv("OTEL_EXPORTER_OTLP_LOGS_ENDPOINT")
+	if endpoint == "" {
+		endpoint = os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT")
+	}
+	secure := !strings.EqualFold(os.Getenv("OTEL_EXPORTER_OTLP_INSECURE"), "true")
+	if endpoint == "" {
+		return "localhost:4317", secure
+	}
+	if u, err := url.Parse(endpoint); err == nil && u.Host != "" {
+		return u.Host, secure && u.Scheme != "http"
+	}
+	return endpoint, secure
+}
+
+// Exemplar returns the trace_id and span_id exemplar labels of the span in
+// ctx, or nil if there is none or it is not sampled: an exemplar pointing
+// at a trace the backend never received would lead nowhere. Init installs
+// it as the metrics exemplar source.
+func Exemplar(ctx context.Context) prometheus.Labels {
+	sc := trace.SpanContextFromContext(ctx)
+	if !sc.IsValid() || !sc.IsSampled() {
+		return nil
+	}
+	return prometheus.Labels{"trace_id": sc.TraceID().String(), "span_id": sc.SpanID().String()}
+}
+
+// Resource describes the running service: service.name, service.version
+// from package buildinfo, plus the Kubernetes pod, namespace and node names
+// and the pod labels, as k8s.pod.label.<key>, when the downward API
+// provides them.
+func Resource(ctx context.Context, serviceName string) (*resource.Resource, error) {
+	attrs := []attribute.KeyValue{
+		semconv.ServiceName(serviceName),
+		semconv.ServiceVersion(buildinfo.GetInfo().Version),
+	}
+	pod := podinfo.Get()
+	for key, v := range map[attribute.Key]string{
+		semconv.K8SPodNameKey:       pod.Name,
+		semconv.K8SNamespaceNameKey: pod.Namespace,
+		semconv.K8SNodeNameKey:      pod.Node,
+	} {
+		if v != "" {
+			attrs = append(attrs, key.String(v))
+		}
+	}
+	for k, v := range pod.Labels {
+		attrs = append(attrs, attribute.String("k8s.pod.label."+k, v))
+	}
+	res, err := resource.New(ctx,
+		resource.WithFromEnv(),
+		resource.WithTelemetrySDK(),
+		resource.WithAttributes(attrs...),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("tracing: building resource: %w", err)
+	}
+	return res, nil
+}
+
+// samplerFromEnv builds a sampler from OTEL_TRACES_SAMPLER(_ARG).
+func samplerFromEnv() (sdktrace.Sampler, error) {
+	name := strings.ToLower(strings.TrimSpace(os.Getenv("OTEL_TRACES_SAMPLER")))
+	ratio := 1.0
+	if arg := os.Getenv("OTEL_TRACES_SAMPLER_ARG"); arg != "" {
+		r, err := strconv.ParseFloat(arg, 64)
+		if err != nil || r < 0 || r > 1 {
+			return nil, fmt.Errorf("tracing: invalid OTEL_TRACES_SAMPLER_ARG %q: must be a ratio in [0, 1]", arg)
+		}
+		ratio = r
+	}
+
+	switch name {
+	case "", "parentbased_always_on":
+		return sdktrace.ParentBased(sdktrace.AlwaysSample()), nil
+	case "always_on":
+		return sdktrace.AlwaysSample(), nil
+	case "always_off":
+		return sdktrace.NeverSample(), nil
+	case "traceidratio":
+		return sdktrace.TraceIDRatioBased(ratio), nil
+	case "parentbased_always_off":
+		return sdktrace.ParentBased(sdktrace.NeverSample()), nil
+	case "parentbased_traceidratio":
+		return sdktrace.ParentBased(sdktrace.TraceIDRatioBased(ratio)), nil
+	}
+	return nil, fmt.Errorf("tracing: unknown OTEL_TRACES_SAMPLER %q", name)
+}
@@ -0,0 +1,132 @@
+package tracing
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"go.opentelemetry.io/otel/attribute"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/GoogleCloudPlatform/microservices-demo/src/shared/buildinfo"
+	"github.com/GoogleCloudPlatform/microservices-demo/src/shared/podinfo"
+)
+
+func TestSamplerFromEnv(t *testing.T) {
+	tests := []struct {
+		sampler, arg string
+		wantPrefix   string
+		wantErr      bool
+	}{
+		{"", "", "ParentBased{root:AlwaysOnSampler", false},
+		{"always_off", "", "AlwaysOffSampler", false},
+		{"traceidratio", "0.25", "TraceIDRatioBased{0.25}", false},
+		{"parentbased_traceidratio", "0.5", "ParentBased{root:TraceIDRatioBased{0.5}", false},
+		{"traceidratio", "2", "", true},
+		{"sometimes", "", "", true},
+	}
+	for _, tt := range tests {
+		t.Setenv("OTEL_TRACES_SAMPLER", tt.sampler)
+		t.Setenv("OTEL_TRACES_SAMPLER_ARG", tt.arg)
+		s, err := samplerFromEnv()
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("%s/%s: expected error", tt.sampler, tt.arg)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s/%s: %v", tt.sampler, tt.arg, err)
+			continue
+		}
+		if !strings.HasPrefix(s.Description(), tt.wantPrefix) {
+			t.Errorf("%s/%s: sampler %q, want prefix %q", tt.sampler, tt.arg, s.Description(), tt.wantPrefix)
+		}
+	}
+}
+
+func TestResource(t *testing.T) {
+	t.Setenv("POD_NAME", "checkout-7d9f")
+	t.Setenv("POD_NAMESPACE", "boutique")
+	podinfo.Reload()
+	res, err := Resource(context.Background(), "checkoutservice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	set := res.Set()
+	for key, want := range map[string]string{
+		string(semconv.ServiceNameKey):      "checkoutservice",
+		string(semconv.ServiceVersionKey):   buildinfo.GetInfo().Version,
+		string(semconv.K8SPodNameKey):       "checkout-7d9f",
+		string(semconv.K8SNamespaceNameKey): "boutique",
+	} {
+		v, ok := set.Value(attribute.Key(key))
+		if !ok || v.AsString() != want {
+			t.Errorf("%s = %q, want %q", key, v.AsString(), want)
+		}
+	}
+}
+
+func TestInitDisabled(t *testing.T) {
+	t.Setenv("DISABLE_TRACING", "1")
+	shutdown, err := Init(context.Background(), "svc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := shutdown(context.Background()); err != nil {
+		t.Errorf("shutdown: %v", err)
+	}
+}
+
+func TestExemplar(t *testing.T) {
+	tid, _ := trace.TraceIDFromHex("4bf92f3577b34da6a3ce929d0e0e4736")
+	sid, _ := trace.SpanIDFromHex("00f067aa0ba902b7")
+	sampled := trace.NewSpanContext(trace.SpanContextConfig{TraceID: tid, SpanID: sid, TraceFlags: trace.FlagsSampled})
+	got := Exemplar(trace.ContextWithSpanContext(context.Background(), sampled))
+	if got["trace_id"] != tid.String() || got["span_id"] != sid.String() {
+		t.Errorf("Exemplar = %v", got)
+	}
+	unsampled := trace.NewSpanContext(trace.SpanContextConfig{TraceID: tid, SpanID: sid})
+	if got := Exemplar(trace.ContextWithSpanContext(context.Background(), unsampled)); got != nil {
+		t.Errorf("Exemplar of an unsampled span = %v", got)
+	}
+	if got := Exemplar(context.Background()); got != nil {
+		t.Errorf("Exemplar without a span = %v", got)
+	}
+}
+
+func TestLogsEndpoint(t *testing.T) {
+	tests := []struct {
+		collector, logs, otlp, insecure string
+		want                            string
+		wantSecure                      bool
+	}{
+		{"", "", "", "", "localhost:4317", true},
+		{"otelcol:4317", "", "https://ignored:4317", "", "otelcol:4317", false},
+		{"", "", "http://otelcol:4317", "", "otelcol:4317", false},
+		{"", "https://logs:4317", "http://otelcol:4317", "", "logs:4317", true},
+		{"", "", "otelcol:4317", "true", "otelcol:4317", false},
+	}
+	for _, tt := range tests {
+		t.Setenv("COLLECTOR_SERVICE_ADDR", tt.collector)
+		t.Setenv("OTEL_EXPORTER_OTLP_LOGS_ENDPOINT", tt.logs)
+		t.Setenv("OTEL_EXPORTER_OTLP_ENDPOINT", tt.otlp)
+		t.Setenv("OTEL_EXPORTER_OTLP_INSECURE", tt.insecure)
+		got, secure := logsEndpoint()
+		if got != tt.want || secure != tt.wantSecure {
+			t.Errorf("%+v: logsEndpoint() = %s, %v; want %s, %v", tt, got, secure, tt.want, tt.wantSecure)
+		}
+	}
+}
+
+func TestLogsExporterFromEnv(t *testing.T) {
+	t.Setenv("OTEL_LOGS_EXPORTER", "none")
+	if shutdown, err := logsExporter(nil); shutdown != nil || err != nil {
+		t.Errorf("OTEL_LOGS_EXPORTER=none: %v, %v", shutdown != nil, err)
+	}
+	t.Setenv("OTEL_LOGS_EXPORTER", "logfile")
+	if _, err := logsExporter(nil); err == nil {
+		t.Error("unknown OTEL_LOGS_EXPORTER should fail")
+	}
+}
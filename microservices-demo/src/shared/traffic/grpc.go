@@ -0,0 +1,38 @@
+package traffic
+
+import (
+	"context"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+// UnaryServerInterceptor records the unary calls a server handles. Streams
+// are not recorded.
+func (r *Recorder) UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		m, ok := req.(proto.Message)
+		if !ok || !r.sample(info.FullMethod) {
+			return handler(ctx, req)
+		}
+		start := time.Now()
+		// Redact before the handler runs: it may modify the request.
+		payload, merr := protojson.Marshal(r.cfg.redactor.Proto(m))
+		resp, err := handler(ctx, req)
+		if merr == nil {
+			r.add(Record{
+				Offset:   start.Sub(r.start),
+				Protocol: GRPC,
+				Method:   info.FullMethod,
+				Type:     string(m.ProtoReflect().Descriptor().FullName()),
+				Payload:  payload,
+				Code:     status.Code(err).String(),
+				Duration: time.Since(start),
+			})
+		}
+		return resp, err
+	}
+}
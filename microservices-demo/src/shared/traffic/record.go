@@ -0,0 +1,296 @@
+// Package traffic records production-like traffic and replays it: a
+// Recorder's gRPC interceptor and HTTP middleware write a sample of the
+// requests a service receives, anonymized by a shared.Redactor, to a file
+// of JSON lines, and a Replayer re-issues them against another environment
+// at the recorded pace or faster, reporting the calls whose status differs
+// from the recording. Replays make regression tests and coverage runs
+// exercise the request mix real users produce rather than a scripted one.
+//
+//	f, _ := os.Create("/tmp/checkout.traffic")
+//	rec := traffic.NewRecorder(f, traffic.WithSampleRate(0.1))
+//	defer rec.Close()
+//	srv := grpcserver.New(grpcserver.WithUnaryInterceptors(rec.UnaryServerInterceptor()))
+//
+// and later, with the services' generated packages linked in:
+//
+//	records, _ := traffic.ReadFile("/tmp/checkout.traffic")
+//	result, err := traffic.NewReplayer(traffic.Target{Conn: conn}, traffic.WithSpeed(4)).Replay(ctx, records)
+package traffic
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/rand/v2"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	shared "github.com/GoogleCloudPlatform/microservices-demo/src/shared"
+	"github.com/GoogleCloudPlatform/microservices-demo/src/shared/metrics"
+)
+
+var recordsTotal = metrics.NewCounterVec("traffic_records_total",
+	"Requests seen by traffic recorders, by result (recorded, dropped, skipped).", "result")
+
+// Protocols of a Record.
+const (
+	GRPC = "grpc"
+	HTTP = "http"
+)
+
+// Record is one recorded request.
+type Record struct {
+	// Offset is when the request arrived, from the start of the recording.
+	Offset time.Duration `json:"offset"`
+	// Protocol is GRPC or HTTP.
+	Protocol string `json:"protocol"`
+	// Method is the full gRPC method or the HTTP method.
+	Method string `json:"method"`
+	// Path is the HTTP path and query, redacted.
+	Path string `json:"path,omitempty"`
+	// Type is the full name of a gRPC request message.
+	Type string `json:"type,omitempty"`
+	// Payload is the redacted gRPC request as proto JSON.
+	Payload json.RawMessage `json:"payload,omitempty"`
+	// Body is the redacted HTTP request body.
+	Body        string `json:"body,omitempty"`
+	ContentType string `json:"contentType,omitempty"`
+	// Code is the gRPC code name or the HTTP status.
+	Code     string        `json:"code"`
+	Duration time.Duration `json:"duration"`
+}
+
+// ReadRecords reads records written by a Recorder.
+func ReadRecords(r io.Reader) ([]Record, error) {
+	var out []Record
+	sc := bufio.NewScanner(r)
+	sc.Buffer(nil, 16<<20)
+	for n := 1; sc.Scan(); n++ {
+		if len(bytes.TrimSpace(sc.Bytes())) == 0 {
+			continue
+		}
+		var rec Record
+		if err := json.Unmarshal(sc.Bytes(), &rec); err != nil {
+			return nil, fmt.Errorf("traffic: line %d: %w", n, err)
+		}
+		out = append(out, rec)
+	}
+	return out, sc.Err()
+}
+
+// ReadFile reads the records in the file at path.
+func ReadFile(path string) ([]Record, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ReadRecords(f)
+}
+
+// Option configures NewRecorder.
+type Option func(*config)
+
+type config struct {
+	rate     float64
+	redactor *shared.Redactor
+	methods  []string
+	buffer   int
+	maxBody  int
+	logger   *slog.Logger
+}
+
+// WithSampleRate records a fraction of the requests (default 1, all).
+func WithSampleRate(rate float64) Option {
+	return func(c *config) { c.rate = rate }
+}
+
+// WithRedactor anonymizes payloads with r instead of
+// shared.DefaultRedactor().
+func WithRedactor(r *shared.Redactor) Option {
+	return func(c *config) { c.redactor = r }
+}
+
+// WithMethods records only the gRPC methods or HTTP paths starting with one
+// of prefixes, e.g. "/hipstershop.CheckoutService/".
+func WithMethods(prefixes ...string) Option {
+	return func(c *config) { c.methods = append(c.methods, prefixes...) }
+}
+
+// WithBufferSize sets how many records may wait to be written (default
+// 1024). Requests arriving while the buffer is full are not recorded,
+// rather than waiting on the file.
+func WithBufferSize(n int) Option {
+	return func(c *config) {
+		if n > 0 {
+			c.buffer = n
+		}
+	}
+}
+
+// WithMaxBody sets how many bytes of an HTTP body are recorded (default
+// 64 KiB); larger bodies are recorded without it.
+func WithMaxBody(n int) Option {
+	return func(c *config) { c.maxBody = n }
+}
+
+// WithLogger sets the logger of write failures. The default is
+// slog.Default().
+func WithLogger(l *slog.Logger) Option {
+	return func(c *config) { c.logger = l }
+}
+
+// Recorder writes sampled, redacted requests to a writer. Its interceptor
+// and middleware never block requests on the writer.
+type Recorder struct {
+	cfg   config
+	start time.Time
+
+	records chan Record
+	done    chan struct{}
+	once    sync.Once
+	closeMu sync.RWMutex
+	closed  bool
+}
+
+// NewRecorder returns a Recorder writing to w as JSON lines. Close it to
+// flush the records.
+func NewRecorder(w io.Writer, opts ...Option) *Recorder {
+	cfg := config{rate: 1, buffer: 1024, maxBody: 64 << 10, logger: slog.Default()}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	if cfg.redactor == nil {
+		cfg.redactor = shared.DefaultRedactor()
+	}
+	r := &Recorder{cfg: cfg, start: time.Now(), records: make(chan Record, cfg.buffer), done: make(chan struct{})}
+	go r.write(w)
+	return r
+}
+
+// sample reports whether a request to method should be recorded.
+func (r *Recorder) sample(method string) bool {
+	if len(r.cfg.methods) > 0 && !hasAnyPrefix(method, r.cfg.methods) {
+		return false
+	}
+	if r.cfg.rate < 1 && rand.Float64() >= r.cfg.rate {
+		recordsTotal.WithLabelValues("skipped").Inc()
+		return false
+	}
+	return true
+}
+
+func hasAnyPrefix(s string, prefixes []string) bool {
+	for _, p := range prefixes {
+		if strings.HasPrefix(s, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// add queues rec for writing, dropping it if the buffer is full.
+func (r *Recorder) add(rec Record) {
+	r.closeMu.RLock()
+	defer r.closeMu.RUnlock()
+	if r.closed {
+		return
+	}
+	select {
+	case r.records <- rec:
+		recordsTotal.WithLabelValues("recorded").Inc()
+	default:
+		recordsTotal.WithLabelValues("dropped").Inc()
+	}
+}
+
+func (r *Recorder) write(w io.Writer) {
+	defer close(r.done)
+	bw := bufio.NewWriter(w)
+	enc := json.NewEncoder(bw)
+	failed := false
+	for rec := range r.records {
+		if err := enc.Encode(rec); err != nil && !failed {
+			failed = true
+			r.cfg.logger.Error("traffic recording failed", "error", err)
+		}
+		// Flush when idle so a crash loses little.
+		if len(r.records) == 0 {
+			bw.Flush()
+		}
+	}
+	if err := bw.Flush(); err != nil && !failed {
+		r.cfg.logger.Error("traffic recording failed", "error", err)
+	}
+}
+
+// Close stops recording and waits for the queued records to be written. It
+// does not close the writer.
+func (r *Recorder) Close() error {
+	r.once.Do(func() {
+		r.closeMu.Lock()
+		r.closed = true
+		close(r.records)
+		r.closeMu.Unlock()
+	})
+	<-r.done
+	return nil
+}
+
+// Middleware records the HTTP requests next serves.
+func (r *Recorder) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if !r.sample(req.URL.Path) {
+			next.ServeHTTP(w, req)
+			return
+		}
+		start := time.Now()
+		rec := Record{Offset: start.Sub(r.start), Protocol: HTTP, Method: req.Method,
+			Path: r.cfg.redactor.String(req.URL.Path), ContentType: req.Header.Get("Content-Type")}
+		if q := req.URL.Query(); len(q) > 0 {
+			rec.Path += "?" + r.cfg.redactor.Values(q).Encode()
+		}
+		if req.Body != nil && req.ContentLength != 0 {
+			body, err := io.ReadAll(io.LimitReader(req.Body, int64(r.cfg.maxBody)+1))
+			req.Body = struct {
+				io.Reader
+				io.Closer
+			}{io.MultiReader(bytes.NewReader(body), req.Body), req.Body}
+			if err == nil && len(body) <= r.cfg.maxBody {
+				rec.Body = r.cfg.redactor.Payload(rec.ContentType, body)
+			}
+		}
+		sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(sw, req)
+		rec.Code = fmt.Sprint(sw.status)
+		rec.Duration = time.Since(start)
+		r.add(rec)
+	})
+}
+
+// statusWriter captures the status of a response.
+type statusWriter struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+}
+
+func (w *statusWriter) WriteHeader(code int) {
+	if !w.wroteHeader {
+		w.status, w.wroteHeader = code, true
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *statusWriter) Write(b []byte) (int, error) {
+	w.wroteHeader = true
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *statusWriter) Unwrap() http.ResponseWriter { return w.ResponseWriter }
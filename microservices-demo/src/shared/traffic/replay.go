@@ -0,0 +1,266 @@
+package traffic
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/known/emptypb"
+
+	"github.com/GoogleCloudPlatform/microservices-demo/src/shared/loadgen"
+)
+
+// Target is where records are replayed: gRPC records on Conn, HTTP ones
+// against BaseURL. A record whose protocol has no target is skipped.
+type Target struct {
+	Conn grpc.ClientConnInterface
+	// BaseURL is the scheme and host HTTP paths are appended to, e.g.
+	// "http://frontend.staging:8080".
+	BaseURL string
+	// HTTPClient sends the HTTP records; nil uses http.DefaultClient.
+	HTTPClient *http.Client
+}
+
+// ReplayOption configures NewReplayer.
+type ReplayOption func(*Replayer)
+
+// WithSpeed replays speed times faster than recorded (default 1, the
+// recorded pace); zero sends every record as soon as a slot is free.
+func WithSpeed(speed float64) ReplayOption {
+	return func(r *Replayer) { r.speed = speed }
+}
+
+// WithConcurrency bounds the requests in flight (default 64). At the
+// recorded pace, a bound lower than the recording's concurrency delays
+// requests.
+func WithConcurrency(n int) ReplayOption {
+	return func(r *Replayer) {
+		if n > 0 {
+			r.concurrency = n
+		}
+	}
+}
+
+// WithRewrite calls fn on every gRPC request before it is sent, e.g. to
+// replace redacted emails and card numbers with valid synthetic ones the
+// target accepts.
+func WithRewrite(fn func(rec *Record, req proto.Message)) ReplayOption {
+	return func(r *Replayer) { r.rewrite = fn }
+}
+
+// Replayer re-issues recorded requests.
+type Replayer struct {
+	target      Target
+	speed       float64
+	concurrency int
+	rewrite     func(*Record, proto.Message)
+}
+
+// NewReplayer returns a Replayer sending to target.
+func NewReplayer(target Target, opts ...ReplayOption) *Replayer {
+	r := &Replayer{target: target, speed: 1, concurrency: 64}
+	for _, opt := range opts {
+		opt(r)
+	}
+	if r.target.HTTPClient == nil {
+		r.target.HTTPClient = http.DefaultClient
+	}
+	r.target.BaseURL = strings.TrimSuffix(r.target.BaseURL, "/")
+	return r
+}
+
+// Mismatch is a replayed request whose status differs from the recorded
+// one.
+type Mismatch struct {
+	Record Record
+	Got    string
+	Err    error
+}
+
+// maxMismatches bounds the mismatches kept in a Result.
+const maxMismatches = 100
+
+// Result summarizes a replay.
+type Result struct {
+	Sent, Skipped int
+	// Matched counts the requests that got their recorded status.
+	Matched int
+	// Mismatches are the first requests that did not; Mismatched counts
+	// them all.
+	Mismatches []Mismatch
+	Mismatched int
+
+	latency map[string]*loadgen.Histogram
+}
+
+// Latency returns the replayed latencies of method, a full gRPC method or
+// "HTTP-method path" without the query, or nil if none was sent.
+func (res *Result) Latency(method string) *loadgen.Histogram { return res.latency[method] }
+
+// Methods returns the methods replayed, sorted.
+func (res *Result) Methods() []string { return slices.Sorted(maps.Keys(res.latency)) }
+
+// Replay sends records in the order and, scaled by the speed, at the
+// offsets they were recorded at, and waits for the responses. It stops
+// early if ctx is done, returning what was replayed so far.
+func (r *Replayer) Replay(ctx context.Context, records []Record) (*Result, error) {
+	records = slices.Clone(records)
+	slices.SortStableFunc(records, func(a, b Record) int { return cmp.Compare(a.Offset, b.Offset) })
+	res := &Result{latency: make(map[string]*loadgen.Histogram)}
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	slots := make(chan struct{}, r.concurrency)
+	start := time.Now()
+	var err error
+replay:
+	for i := range records {
+		rec := &records[i]
+		if (rec.Protocol == GRPC && r.target.Conn == nil) || (rec.Protocol == HTTP && r.target.BaseURL == "") ||
+			(rec.Protocol != GRPC && rec.Protocol != HTTP) {
+			res.Skipped++
+			continue
+		}
+		if r.speed > 0 {
+			at := time.Duration(float64(rec.Offset) / r.speed)
+			if wait := at - time.Since(start); wait > 0 {
+				t := time.NewTimer(wait)
+				select {
+				case <-ctx.Done():
+					t.Stop()
+					err = ctx.Err()
+					break replay
+				case <-t.C:
+				}
+			}
+		}
+		select {
+		case slots <- struct{}{}:
+		case <-ctx.Done():
+			err = ctx.Err()
+			break replay
+		}
+		res.Sent++
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-slots }()
+			sent := time.Now()
+			got, callErr := r.send(ctx, rec)
+			d := time.Since(sent)
+
+			mu.Lock()
+			defer mu.Unlock()
+			key := latencyKey(rec)
+			h := res.latency[key]
+			if h == nil {
+				h = &loadgen.Histogram{}
+				res.latency[key] = h
+			}
+			h.Record(d)
+			if got == rec.Code {
+				res.Matched++
+				return
+			}
+			res.Mismatched++
+			if len(res.Mismatches) < maxMismatches {
+				res.Mismatches = append(res.Mismatches, Mismatch{Record: *rec, Got: got, Err: callErr})
+			}
+		}()
+	}
+	wg.Wait()
+	return res, err
+}
+
+func latencyKey(rec *Record) string {
+	if rec.Protocol == HTTP {
+		path, _, _ := strings.Cut(rec.Path, "?")
+		return rec.Method + " " + path
+	}
+	return rec.Method
+}
+
+// send replays one record, returning the status it got.
+func (r *Replayer) send(ctx context.Context, rec *Record) (string, error) {
+	if rec.Protocol == HTTP {
+		return r.sendHTTP(ctx, rec)
+	}
+	req, err := newMessage(rec.Type)
+	if err != nil {
+		return "", err
+	}
+	if err := (protojson.UnmarshalOptions{DiscardUnknown: true}).Unmarshal(rec.Payload, req); err != nil {
+		return "", fmt.Errorf("traffic: decoding %s: %w", rec.Type, err)
+	}
+	if r.rewrite != nil {
+		r.rewrite(rec, req)
+	}
+	resp, err := responseFor(rec.Method)
+	if err != nil {
+		return "", err
+	}
+	err = r.target.Conn.Invoke(ctx, rec.Method, req, resp)
+	return status.Code(err).String(), err
+}
+
+func (r *Replayer) sendHTTP(ctx context.Context, rec *Record) (string, error) {
+	var body io.Reader
+	if rec.Body != "" {
+		body = strings.NewReader(rec.Body)
+	}
+	req, err := http.NewRequestWithContext(ctx, rec.Method, r.target.BaseURL+rec.Path, body)
+	if err != nil {
+		return "", err
+	}
+	if rec.ContentType != "" {
+		req.Header.Set("Content-Type", rec.ContentType)
+	}
+	resp, err := r.target.HTTPClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	return fmt.Sprint(resp.StatusCode), nil
+}
+
+func newMessage(name string) (proto.Message, error) {
+	mt, err := protoregistry.GlobalTypes.FindMessageByName(protoreflect.FullName(name))
+	if err != nil {
+		return nil, fmt.Errorf("traffic: message type %s is not linked in: %w", name, err)
+	}
+	return mt.New().Interface(), nil
+}
+
+// responseFor returns an empty response of method, found in the linked
+// service descriptors. Methods not found there, such as those of services
+// registered without descriptors, get an Empty, which grpc decodes
+// leniently enough to still report the status.
+func responseFor(method string) (proto.Message, error) {
+	service, name, _ := strings.Cut(strings.TrimPrefix(method, "/"), "/")
+	d, err := protoregistry.GlobalFiles.FindDescriptorByName(protoreflect.FullName(service))
+	if err != nil {
+		return &emptypb.Empty{}, nil
+	}
+	sd, ok := d.(protoreflect.ServiceDescriptor)
+	if !ok {
+		return &emptypb.Empty{}, nil
+	}
+	md := sd.Methods().ByName(protoreflect.Name(name))
+	if md == nil {
+		return &emptypb.Empty{}, nil
+	}
+	return newMessage(string(md.Output().FullName()))
+}
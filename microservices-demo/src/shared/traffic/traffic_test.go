@@ -0,0 +1,159 @@
+package traffic
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// healthServer answers SERVING, or NotFound for services in missing.
+type healthServer struct {
+	healthpb.UnimplementedHealthServer
+	missing map[string]bool
+}
+
+func (h *healthServer) Check(_ context.Context, req *healthpb.HealthCheckRequest) (*healthpb.HealthCheckResponse, error) {
+	if h.missing[req.Service] {
+		return nil, status.Error(codes.NotFound, "unknown service")
+	}
+	return &healthpb.HealthCheckResponse{Status: healthpb.HealthCheckResponse_SERVING}, nil
+}
+
+func serve(t *testing.T, h *healthServer, opts ...grpc.ServerOption) *grpc.ClientConn {
+	t.Helper()
+	lis := bufconn.Listen(1 << 20)
+	srv := grpc.NewServer(opts...)
+	healthpb.RegisterHealthServer(srv, h)
+	go srv.Serve(lis)
+	t.Cleanup(srv.Stop)
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+var quiet = WithLogger(slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+func TestRecordAndReplayGRPC(t *testing.T) {
+	var buf bytes.Buffer
+	rec := NewRecorder(&buf, quiet)
+	conn := serve(t, &healthServer{missing: map[string]bool{"gone": true}}, grpc.UnaryInterceptor(rec.UnaryServerInterceptor()))
+	client := healthpb.NewHealthClient(conn)
+	for _, svc := range []string{"", "alice@example.com", "gone"} {
+		client.Check(context.Background(), &healthpb.HealthCheckRequest{Service: svc})
+	}
+	rec.Close()
+
+	records, err := ReadRecords(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 3 {
+		t.Fatalf("recorded %d requests, want 3:\n%s", len(records), buf.String())
+	}
+	if strings.Contains(string(records[1].Payload), "alice") {
+		t.Errorf("payload not anonymized: %s", records[1].Payload)
+	}
+	if records[2].Code != "NotFound" || records[0].Type != "grpc.health.v1.HealthCheckRequest" {
+		t.Errorf("records = %+v", records)
+	}
+
+	// The new version knows "gone" again.
+	target := serve(t, &healthServer{})
+	res, err := NewReplayer(Target{Conn: target}, WithSpeed(0)).Replay(context.Background(), records)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Sent != 3 || res.Matched != 2 || res.Mismatched != 1 || res.Mismatches[0].Got != "OK" {
+		t.Errorf("Replay = %+v", res)
+	}
+	if h := res.Latency("/grpc.health.v1.Health/Check"); h == nil || h.Count() != 3 {
+		t.Errorf("latency of %v", res.Methods())
+	}
+}
+
+func TestRecordHTTP(t *testing.T) {
+	var buf bytes.Buffer
+	rec := NewRecorder(&buf, quiet, WithMethods("/cart"))
+	var got string
+	h := rec.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if b, _ := io.ReadAll(r.Body); len(b) > 0 {
+			got = string(b)
+		}
+		w.WriteHeader(http.StatusCreated)
+	}))
+	body := "product_id=OLJCESPC7Z&email=bob%40example.com"
+	req := httptest.NewRequest(http.MethodPost, "/cart?ref=carol@example.com", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	h.ServeHTTP(httptest.NewRecorder(), req)
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	rec.Close()
+
+	if got != body {
+		t.Errorf("handler read %q, want the full body", got)
+	}
+	records, err := ReadRecords(&buf)
+	if err != nil || len(records) != 1 {
+		t.Fatalf("records = %+v, %v", records, err)
+	}
+	r := records[0]
+	if r.Code != "201" || strings.Contains(r.Body+r.Path, "example.com") || !strings.Contains(r.Body, "OLJCESPC7Z") {
+		t.Errorf("record = %+v", r)
+	}
+}
+
+func TestReplayHTTPAndPace(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/missing" {
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+	records := []Record{
+		{Offset: 100 * time.Millisecond, Protocol: HTTP, Method: "GET", Path: "/missing", Code: "200"},
+		{Offset: 0, Protocol: HTTP, Method: "GET", Path: "/", Code: "200"},
+		{Offset: 0, Protocol: GRPC, Method: "/x.Y/Z", Code: "OK"},
+	}
+	start := time.Now()
+	res, err := NewReplayer(Target{BaseURL: srv.URL + "/"}, WithSpeed(2)).Replay(context.Background(), records)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := time.Since(start); d < 50*time.Millisecond {
+		t.Errorf("replay at double speed took %s, want at least 50ms", d)
+	}
+	if res.Sent != 2 || res.Skipped != 1 || res.Matched != 1 || res.Mismatches[0].Got != "404" {
+		t.Errorf("Replay = %+v", res)
+	}
+}
+
+func TestSampling(t *testing.T) {
+	var buf bytes.Buffer
+	rec := NewRecorder(&buf, quiet, WithSampleRate(0))
+	h := rec.Middleware(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+	for range 10 {
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	}
+	rec.Close()
+	if buf.Len() != 0 {
+		t.Errorf("recorded with a zero sample rate:\n%s", buf.String())
+	}
+}
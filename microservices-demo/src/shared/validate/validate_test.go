@@ -0,0 +1,129 @@
+package validate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+// pgvError mimics the per-message error types protoc-gen-validate
+// generates.
+type pgvError struct {
+	field, reason string
+	cause         error
+}
+
+func (e pgvError) Error() string  { return fmt.Sprintf("invalid %s: %s", e.field, e.reason) }
+func (e pgvError) Field() string  { return e.field }
+func (e pgvError) Reason() string { return e.reason }
+func (e pgvError) Cause() error   { return e.cause }
+
+type pgvMultiError []error
+
+func (m pgvMultiError) Error() string      { return fmt.Sprintf("%d errors", len(m)) }
+func (m pgvMultiError) AllErrors() []error { return m }
+
+// order is a proto.Message with a generated-style ValidateAll.
+type order struct {
+	*wrapperspb.StringValue
+	err error
+}
+
+func (o order) ValidateAll() error { return o.err }
+
+func newOrder(err error) order { return order{StringValue: wrapperspb.String("o"), err: err} }
+
+func TestViolationsNested(t *testing.T) {
+	err := pgvMultiError{
+		pgvError{field: "email", reason: "value must be a valid email address"},
+		pgvError{field: "address", reason: "embedded message failed validation", cause: pgvMultiError{
+			pgvError{field: "zip_code", reason: "value must be greater than 0"},
+		}},
+	}
+	got := Violations(err)
+	want := map[string]string{
+		"email":            "value must be a valid email address",
+		"address.zip_code": "value must be greater than 0",
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %d violations, want %d: %v", len(got), len(want), got)
+	}
+	for _, v := range got {
+		if want[v.Field] != v.Description {
+			t.Errorf("violation %s: %q", v.Field, v.Description)
+		}
+	}
+}
+
+func TestUnaryServerInterceptor(t *testing.T) {
+	intercept := UnaryServerInterceptor()
+	info := &grpc.UnaryServerInfo{FullMethod: "/hipstershop.CheckoutService/PlaceOrder"}
+	called := false
+	handler := func(ctx context.Context, req any) (any, error) {
+		called = true
+		return req, nil
+	}
+
+	bad := newOrder(pgvMultiError{pgvError{field: "user_id", reason: "value length must be at least 1 runes"}})
+	_, err := intercept(context.Background(), bad, info, handler)
+	if called {
+		t.Error("handler called for an invalid request")
+	}
+	st := status.Convert(err)
+	if st.Code() != codes.InvalidArgument {
+		t.Fatalf("code = %v, want InvalidArgument", st.Code())
+	}
+	var br *errdetails.BadRequest
+	var reason string
+	for _, d := range st.Details() {
+		switch d := d.(type) {
+		case *errdetails.BadRequest:
+			br = d
+		case *errdetails.ErrorInfo:
+			reason = d.Reason
+		}
+	}
+	if reason != Reason {
+		t.Errorf("ErrorInfo reason = %q", reason)
+	}
+	if br == nil || len(br.FieldViolations) != 1 || br.FieldViolations[0].Field != "user_id" {
+		t.Errorf("BadRequest = %v", br)
+	}
+
+	if _, err := intercept(context.Background(), newOrder(nil), info, handler); err != nil || !called {
+		t.Errorf("valid request: err = %v, called = %v", err, called)
+	}
+}
+
+func TestWithValidatorAndResponses(t *testing.T) {
+	rejectEmpty := func(m proto.Message) error {
+		if s, ok := m.(*wrapperspb.StringValue); ok && s.Value == "" {
+			return errors.New("value is required")
+		}
+		return nil
+	}
+	intercept := UnaryServerInterceptor(WithValidator(rejectEmpty), WithResponseValidation())
+	info := &grpc.UnaryServerInfo{FullMethod: "/svc/M"}
+
+	_, err := intercept(context.Background(), wrapperspb.String(""), info, func(ctx context.Context, req any) (any, error) {
+		return req, nil
+	})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("custom validator: code = %v", status.Code(err))
+	}
+
+	_, err = intercept(context.Background(), wrapperspb.String("ok"), info, func(ctx context.Context, req any) (any, error) {
+		return wrapperspb.String(""), nil
+	})
+	if status.Code(err) != codes.Internal {
+		t.Errorf("invalid response: code = %v, want Internal", status.Code(err))
+	}
+}
@@ -0,0 +1,375 @@
+package websocket
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	shared "github.com/GoogleCloudPlatform/microservices-demo/src/shared"
+	"github.com/GoogleCloudPlatform/microservices-demo/src/shared/requestid"
+)
+
+// MessageType is the kind of a data message.
+type MessageType int
+
+// Data message types.
+const (
+	TextMessage   MessageType = opText
+	BinaryMessage MessageType = opBinary
+)
+
+// StatusCode is a close status code of RFC 6455 section 7.4.
+type StatusCode int
+
+// Close status codes.
+const (
+	CloseNormal          StatusCode = 1000
+	CloseGoingAway       StatusCode = 1001
+	CloseProtocolError   StatusCode = 1002
+	CloseUnsupportedData StatusCode = 1003
+	CloseNoStatus        StatusCode = 1005
+	CloseAbnormal        StatusCode = 1006
+	CloseInvalidPayload  StatusCode = 1007
+	ClosePolicyViolation StatusCode = 1008
+	CloseMessageTooBig   StatusCode = 1009
+	CloseInternalError   StatusCode = 1011
+)
+
+// CloseError is returned by ReadMessage once the connection closed, with
+// the code and reason the peer sent, or CloseAbnormal if it vanished.
+type CloseError struct {
+	Code   StatusCode
+	Reason string
+}
+
+func (e *CloseError) Error() string {
+	if e.Reason == "" {
+		return fmt.Sprintf("websocket: closed with %d", e.Code)
+	}
+	return fmt.Sprintf("websocket: closed with %d: %s", e.Code, e.Reason)
+}
+
+// ErrClosed is returned by Send once the connection is closing.
+var ErrClosed = errors.New("websocket: connection closed")
+
+type message struct {
+	typ  MessageType
+	data []byte
+}
+
+type outFrame struct {
+	op      byte
+	payload []byte
+}
+
+// Conn is an open WebSocket connection. Send, Close and ReadMessage may be
+// called concurrently with each other; ReadMessage by one goroutine at a
+// time.
+type Conn struct {
+	srv       *Server
+	nc        net.Conn
+	br        *bufio.Reader
+	bw        *bufio.Writer
+	log       *slog.Logger
+	requestID string
+
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	in      chan message
+	readErr error // set before in is closed
+	out     chan outFrame
+	ctrl    chan outFrame
+
+	closeOnce sync.Once
+	// readDone is closed when readLoop exits, after the peer's close
+	// frame or a read error.
+	readDone chan struct{}
+	done     chan struct{}
+}
+
+func newConn(s *Server, r *http.Request, nc net.Conn, br *bufio.Reader) *Conn {
+	ctx, cancel := context.WithCancel(context.WithoutCancel(r.Context()))
+	id := requestid.FromContext(r.Context())
+	return &Conn{
+		srv:       s,
+		nc:        nc,
+		br:        br,
+		bw:        bufio.NewWriter(nc),
+		log:       s.cfg.log.With("server", s.cfg.name, "request_id", id, "client_ip", shared.ClientIP(r), "path", r.URL.Path),
+		requestID: id,
+		ctx:       ctx,
+		cancel:    cancel,
+		in:        make(chan message, s.cfg.recvBuffer),
+		out:       make(chan outFrame, s.cfg.sendBuffer),
+		ctrl:      make(chan outFrame, 4),
+		readDone:  make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+}
+
+func (c *Conn) start() {
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		c.readLoop()
+	}()
+	go func() {
+		defer wg.Done()
+		c.writeLoop()
+	}()
+	go func() {
+		wg.Wait()
+		c.srv.remove(c)
+		close(c.done)
+	}()
+}
+
+// Context carries the values of the upgrade request, such as its request
+// ID, and is cancelled when the connection closes.
+func (c *Conn) Context() context.Context { return c.ctx }
+
+// RequestID returns the ID of the upgrade request.
+func (c *Conn) RequestID() string { return c.requestID }
+
+// ReadMessage returns the next data message from the peer. Once the
+// connection is closed it returns a *CloseError.
+func (c *Conn) ReadMessage() (MessageType, []byte, error) {
+	m, ok := <-c.in
+	if !ok {
+		return 0, nil, c.readErr
+	}
+	return m.typ, m.data, nil
+}
+
+// ReadJSON reads the next message into v.
+func (c *Conn) ReadJSON(v any) error {
+	_, data, err := c.ReadMessage()
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// Send queues a message for the write pump, waiting while the queue is
+// full until ctx is done. It returns ErrClosed once the connection is
+// closing.
+func (c *Conn) Send(ctx context.Context, typ MessageType, data []byte) error {
+	if c.ctx.Err() != nil {
+		return ErrClosed
+	}
+	select {
+	case c.out <- outFrame{op: byte(typ), payload: data}:
+		return nil
+	case <-c.ctx.Done():
+		return ErrClosed
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// SendJSON sends v encoded as a JSON text message.
+func (c *Conn) SendJSON(ctx context.Context, v any) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return c.Send(ctx, TextMessage, b)
+}
+
+// Close starts the closing handshake with code and reason and waits until
+// the connection is closed. Messages already queued are sent first.
+func (c *Conn) Close(code StatusCode, reason string) error {
+	c.closeOnce.Do(func() {
+		select {
+		case c.ctrl <- outFrame{op: opClose, payload: closePayload(code, reason)}:
+		case <-c.ctx.Done():
+		}
+	})
+	select {
+	case <-c.done:
+	case <-time.After(closeGrace + c.srv.cfg.writeTimeout):
+		c.nc.Close()
+		<-c.done
+	}
+	return nil
+}
+
+// readLoop decodes frames, answers control frames and hands data messages
+// to ReadMessage.
+func (c *Conn) readLoop() {
+	cfg := c.srv.cfg
+	defer close(c.readDone)
+	defer close(c.in)
+	var (
+		msgType MessageType
+		msg     []byte
+		inMsg   bool
+	)
+	for {
+		c.nc.SetReadDeadline(time.Now().Add(cfg.pingInterval + cfg.pongTimeout))
+		f, err := readFrame(c.br, cfg.readLimit-int64(len(msg)), true)
+		if err != nil {
+			c.readFailed(err)
+			return
+		}
+		switch f.op {
+		case opPing:
+			select {
+			case c.ctrl <- outFrame{op: opPong, payload: f.payload}:
+			default: // a pong is already pending
+			}
+			continue
+		case opPong:
+			continue
+		case opClose:
+			code, reason := parseClose(f.payload)
+			c.readErr = &CloseError{Code: code, Reason: reason}
+			reply := code
+			if reply == CloseNoStatus {
+				reply = CloseNormal
+			}
+			c.closeOnce.Do(func() {
+				select {
+				case c.ctrl <- outFrame{op: opClose, payload: closePayload(reply, "")}:
+				case <-c.ctx.Done():
+				}
+			})
+			return
+		case opText, opBinary:
+			if inMsg {
+				c.fail(CloseProtocolError, "new message inside a fragmented one")
+				return
+			}
+			msgType, msg, inMsg = MessageType(f.op), f.payload, true
+		case opContinuation:
+			if !inMsg {
+				c.fail(CloseProtocolError, "continuation without a message")
+				return
+			}
+			msg = append(msg, f.payload...)
+		}
+		if !f.fin {
+			continue
+		}
+		inMsg = false
+		if msgType == TextMessage && !utf8.Valid(msg) {
+			c.fail(CloseInvalidPayload, "invalid UTF-8")
+			return
+		}
+		wsMessages.WithLabelValues(cfg.name, "in").Inc()
+		select {
+		case c.in <- message{typ: msgType, data: msg}:
+		case <-c.ctx.Done():
+			c.readErr = &CloseError{Code: CloseAbnormal}
+			return
+		}
+		msg = nil
+	}
+}
+
+func (c *Conn) readFailed(err error) {
+	switch {
+	case errors.Is(err, errProtocol):
+		c.fail(CloseProtocolError, "protocol error")
+	case errors.Is(err, errTooBig):
+		c.fail(CloseMessageTooBig, "message too big")
+	default:
+		if c.ctx.Err() == nil {
+			c.log.Info("websocket connection lost", "error", err)
+		}
+		c.readErr = &CloseError{Code: CloseAbnormal, Reason: err.Error()}
+		c.cancel()
+		c.nc.Close()
+	}
+}
+
+// fail closes the connection for a peer error.
+func (c *Conn) fail(code StatusCode, reason string) {
+	c.log.Warn("closing websocket connection", "code", int(code), "reason", reason)
+	c.readErr = &CloseError{Code: code, Reason: reason}
+	c.closeOnce.Do(func() {
+		select {
+		case c.ctrl <- outFrame{op: opClose, payload: closePayload(code, reason)}:
+		case <-c.ctx.Done():
+		}
+	})
+}
+
+// writeLoop owns all writes: queued messages, control frames and pings.
+func (c *Conn) writeLoop() {
+	cfg := c.srv.cfg
+	defer c.cancel()
+	defer c.nc.Close()
+	ping := time.NewTicker(cfg.pingInterval)
+	defer ping.Stop()
+	for {
+		var f outFrame
+		// Control frames go ahead of queued messages, except that a
+		// close waits for them, so nothing already sent is lost.
+		select {
+		case f = <-c.ctrl:
+		default:
+			select {
+			case f = <-c.ctrl:
+			case f = <-c.out:
+			case <-ping.C:
+				f = outFrame{op: opPing}
+			case <-c.ctx.Done():
+				return
+			}
+		}
+		if f.op == opClose {
+			c.flushQueued()
+		}
+		if err := c.write(f); err != nil {
+			if c.ctx.Err() == nil {
+				c.log.Info("websocket write failed", "error", err)
+			}
+			return
+		}
+		if f.op == opClose {
+			// Stop sending, and give the peer a moment to answer.
+			c.cancel()
+			select {
+			case <-c.readDone:
+			case <-time.After(closeGrace):
+			}
+			return
+		}
+	}
+}
+
+// flushQueued writes the queued messages ahead of a close frame.
+func (c *Conn) flushQueued() {
+	for {
+		select {
+		case f := <-c.out:
+			if c.write(f) != nil {
+				return
+			}
+		default:
+			return
+		}
+	}
+}
+
+func (c *Conn) write(f outFrame) error {
+	c.nc.SetWriteDeadline(time.Now().Add(c.srv.cfg.writeTimeout))
+	if err := writeFrame(c.bw, f.op, f.payload, false, [4]byte{}); err != nil {
+		return err
+	}
+	if !isControl(f.op) {
+		wsMessages.WithLabelValues(c.srv.cfg.name, "out").Inc()
+	}
+	return nil
+}
@@ -0,0 +1,158 @@
+package websocket
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Frame opcodes of RFC 6455 section 5.2.
+const (
+	opContinuation = 0x0
+	opText         = 0x1
+	opBinary       = 0x2
+	opClose        = 0x8
+	opPing         = 0x9
+	opPong         = 0xA
+)
+
+// maxControlPayload is the largest payload a control frame may carry.
+const maxControlPayload = 125
+
+// frame is one decoded frame, its payload already unmasked.
+type frame struct {
+	fin     bool
+	op      byte
+	payload []byte
+}
+
+func isControl(op byte) bool { return op&0x8 != 0 }
+
+// errProtocol wraps frames that violate RFC 6455; the connection is then
+// closed with CloseProtocolError.
+var errProtocol = errors.New("websocket: protocol error")
+
+// errTooBig is returned for messages over the read limit.
+var errTooBig = errors.New("websocket: message too big")
+
+// readFrame reads one frame, failing with errTooBig if it is a data frame
+// of more than limit payload bytes. Frames from clients must be masked.
+func readFrame(r *bufio.Reader, limit int64, wantMasked bool) (frame, error) {
+	var hdr [2]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return frame{}, err
+	}
+	f := frame{fin: hdr[0]&0x80 != 0, op: hdr[0] & 0x0F}
+	if hdr[0]&0x70 != 0 {
+		return f, fmt.Errorf("%w: reserved bits set", errProtocol)
+	}
+	masked := hdr[1]&0x80 != 0
+	if masked != wantMasked {
+		return f, fmt.Errorf("%w: masking is %v, want %v", errProtocol, masked, wantMasked)
+	}
+	switch f.op {
+	case opContinuation, opText, opBinary, opClose, opPing, opPong:
+	default:
+		return f, fmt.Errorf("%w: unknown opcode %#x", errProtocol, f.op)
+	}
+
+	n := int64(hdr[1] & 0x7F)
+	switch n {
+	case 126:
+		var b [2]byte
+		if _, err := io.ReadFull(r, b[:]); err != nil {
+			return f, err
+		}
+		n = int64(binary.BigEndian.Uint16(b[:]))
+	case 127:
+		var b [8]byte
+		if _, err := io.ReadFull(r, b[:]); err != nil {
+			return f, err
+		}
+		n = int64(binary.BigEndian.Uint64(b[:]))
+		if n < 0 {
+			return f, fmt.Errorf("%w: invalid length", errProtocol)
+		}
+	}
+	if isControl(f.op) && (n > maxControlPayload || !f.fin) {
+		return f, fmt.Errorf("%w: invalid control frame", errProtocol)
+	}
+	if !isControl(f.op) && n > limit {
+		return f, errTooBig
+	}
+
+	var key [4]byte
+	if masked {
+		if _, err := io.ReadFull(r, key[:]); err != nil {
+			return f, err
+		}
+	}
+	f.payload = make([]byte, n)
+	if _, err := io.ReadFull(r, f.payload); err != nil {
+		return f, err
+	}
+	if masked {
+		maskBytes(key, f.payload)
+	}
+	return f, nil
+}
+
+// writeFrame writes a final frame, masked with key if mask is set, as
+// clients must.
+func writeFrame(w *bufio.Writer, op byte, payload []byte, mask bool, key [4]byte) error {
+	hdr := make([]byte, 0, 14)
+	hdr = append(hdr, 0x80|op)
+	var maskBit byte
+	if mask {
+		maskBit = 0x80
+	}
+	switch n := len(payload); {
+	case n <= 125:
+		hdr = append(hdr, maskBit|byte(n))
+	case n <= 0xFFFF:
+		hdr = append(hdr, maskBit|126)
+		hdr = binary.BigEndian.AppendUint16(hdr, uint16(n))
+	default:
+		hdr = append(hdr, maskBit|127)
+		hdr = binary.BigEndian.AppendUint64(hdr, uint64(n))
+	}
+	if mask {
+		hdr = append(hdr, key[:]...)
+		payload = append([]byte(nil), payload...)
+		maskBytes(key, payload)
+	}
+	if _, err := w.Write(hdr); err != nil {
+		return err
+	}
+	if _, err := w.Write(payload); err != nil {
+		return err
+	}
+	return w.Flush()
+}
+
+func maskBytes(key [4]byte, b []byte) {
+	for i := range b {
+		b[i] ^= key[i&3]
+	}
+}
+
+// closePayload encodes a close frame's status code and reason.
+func closePayload(code StatusCode, reason string) []byte {
+	if code == 0 {
+		return nil
+	}
+	if len(reason) > maxControlPayload-2 {
+		reason = reason[:maxControlPayload-2]
+	}
+	return append(binary.BigEndian.AppendUint16(nil, uint16(code)), reason...)
+}
+
+// parseClose decodes a close frame's payload.
+func parseClose(p []byte) (StatusCode, string) {
+	if len(p) < 2 {
+		return CloseNoStatus, ""
+	}
+	return StatusCode(binary.BigEndian.Uint16(p)), string(p[2:])
+}
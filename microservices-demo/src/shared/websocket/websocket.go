@@ -0,0 +1,326 @@
+// Package websocket serves WebSocket (RFC 6455) connections from HTTP
+// handlers, with the plumbing long-lived browser connections need: origin
+// checks on upgrade, ping/pong keepalive that drops dead peers, a write
+// pump per connection so slow clients cannot block the sender, and a
+// Shutdown that closes every connection with "going away" so clients
+// reconnect to another replica.
+//
+// A Server's handlers run behind the usual middleware: the request ID and
+// baggage of the upgrade request stay in the connection's Context, and the
+// access log records the upgrade as 101.
+//
+// Usage:
+//
+//	ws := websocket.NewServer(websocket.WithName("order-status"),
+//	    websocket.WithAllowedOrigins("https://shop.example.com"))
+//	sm.Register("websocket", ws.Shutdown)
+//	mux.Handle("/ws/orders", ws.Handler(func(c *websocket.Conn) {
+//	    sub := orderUpdates.Subscribe()
+//	    defer sub.Close()
+//	    for u := range sub.C() {
+//	        if err := c.SendJSON(c.Context(), u); err != nil {
+//	            return
+//	        }
+//	    }
+//	}))
+//
+// Handlers that only send need not worry about reading: the connection
+// reads in the background, answering pings and noticing when the peer
+// goes away, which cancels Context. Unread messages from the client are
+// buffered up to WithReceiveBuffer, after which reading stalls and the
+// keepalive eventually closes the connection.
+package websocket
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/GoogleCloudPlatform/microservices-demo/src/shared/metrics"
+	"github.com/GoogleCloudPlatform/microservices-demo/src/shared/requestid"
+)
+
+// Defaults applied unless overridden by an Option.
+const (
+	DefaultPingInterval  = 30 * time.Second
+	DefaultPongTimeout   = 10 * time.Second
+	DefaultWriteTimeout  = 10 * time.Second
+	DefaultReadLimit     = 64 << 10
+	DefaultSendBuffer    = 32
+	DefaultReceiveBuffer = 16
+)
+
+// closeGrace is how long a closing connection waits for the peer's close
+// frame before dropping the TCP connection.
+const closeGrace = 2 * time.Second
+
+// acceptGUID is appended to the client's key to compute
+// Sec-WebSocket-Accept.
+const acceptGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+var (
+	wsConnections = metrics.NewGaugeVec("websocket_connections",
+		"Open WebSocket connections.", "server")
+	wsMessages = metrics.NewCounterVec("websocket_messages_total",
+		"WebSocket data messages, by direction (in, out).", "server", "direction")
+	wsUpgrades = metrics.NewCounterVec("websocket_upgrades_total",
+		"WebSocket upgrade attempts, by result (ok, bad_request, forbidden, unavailable, error).", "server", "result")
+)
+
+// ErrShuttingDown is returned by Upgrade once Shutdown started.
+var ErrShuttingDown = errors.New("websocket: server shutting down")
+
+// Option configures NewServer.
+type Option func(*config)
+
+type config struct {
+	name         string
+	origins      []string
+	pingInterval time.Duration
+	pongTimeout  time.Duration
+	writeTimeout time.Duration
+	readLimit    int64
+	sendBuffer   int
+	recvBuffer   int
+	log          *slog.Logger
+}
+
+// WithName labels the server's metrics and logs (default "websocket").
+func WithName(name string) Option {
+	return func(c *config) { c.name = name }
+}
+
+// WithAllowedOrigins lists the origins, such as "https://shop.example.com",
+// whose pages may connect; "*" allows any. Pages served by the same host
+// are always allowed, and so are clients that send no Origin, which are not
+// browsers.
+func WithAllowedOrigins(origins ...string) Option {
+	return func(c *config) { c.origins = origins }
+}
+
+// WithPingInterval sets how often pings are sent (default
+// DefaultPingInterval).
+func WithPingInterval(d time.Duration) Option {
+	return func(c *config) { c.pingInterval = d }
+}
+
+// WithPongTimeout sets how long past a ping interval the peer may stay
+// silent before the connection is dropped (default DefaultPongTimeout).
+func WithPongTimeout(d time.Duration) Option {
+	return func(c *config) { c.pongTimeout = d }
+}
+
+// WithWriteTimeout bounds each frame write (default DefaultWriteTimeout).
+func WithWriteTimeout(d time.Duration) Option {
+	return func(c *config) { c.writeTimeout = d }
+}
+
+// WithReadLimit bounds the size of messages from clients (default
+// DefaultReadLimit). Larger ones close the connection with
+// CloseMessageTooBig.
+func WithReadLimit(n int64) Option {
+	return func(c *config) { c.readLimit = n }
+}
+
+// WithSendBuffer sets how many outgoing messages are queued per connection
+// before Send blocks (default DefaultSendBuffer).
+func WithSendBuffer(n int) Option {
+	return func(c *config) { c.sendBuffer = n }
+}
+
+// WithReceiveBuffer sets how many incoming messages are buffered for
+// ReadMessage (default DefaultReceiveBuffer).
+func WithReceiveBuffer(n int) Option {
+	return func(c *config) { c.recvBuffer = n }
+}
+
+// WithLogger sets the logger (default slog.Default()).
+func WithLogger(l *slog.Logger) Option {
+	return func(c *config) { c.log = l }
+}
+
+// Server upgrades HTTP requests to WebSocket connections and keeps track of
+// them for Shutdown.
+type Server struct {
+	cfg config
+
+	mu      sync.Mutex
+	conns   map[*Conn]struct{}
+	closing bool
+}
+
+// NewServer returns a Server without connections.
+func NewServer(opts ...Option) *Server {
+	cfg := config{
+		name:         "websocket",
+		pingInterval: DefaultPingInterval,
+		pongTimeout:  DefaultPongTimeout,
+		writeTimeout: DefaultWriteTimeout,
+		readLimit:    DefaultReadLimit,
+		sendBuffer:   DefaultSendBuffer,
+		recvBuffer:   DefaultReceiveBuffer,
+		log:          slog.Default(),
+	}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	return &Server{cfg: cfg, conns: make(map[*Conn]struct{})}
+}
+
+// Handler returns an http.Handler upgrading each request and calling fn
+// with the connection, which is closed normally when fn returns.
+func (s *Server) Handler(fn func(c *Conn)) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c, err := s.Upgrade(w, r)
+		if err != nil {
+			return
+		}
+		defer c.Close(CloseNormal, "")
+		fn(c)
+	})
+}
+
+// Upgrade completes the WebSocket handshake for r. On failure it has
+// already answered r with an HTTP error.
+func (s *Server) Upgrade(w http.ResponseWriter, r *http.Request) (*Conn, error) {
+	fail := func(result string, code int, err error) (*Conn, error) {
+		wsUpgrades.WithLabelValues(s.cfg.name, result).Inc()
+		if code == http.StatusUpgradeRequired {
+			w.Header().Set("Sec-WebSocket-Version", "13")
+		}
+		http.Error(w, err.Error(), code)
+		return nil, err
+	}
+	key := r.Header.Get("Sec-WebSocket-Key")
+	switch {
+	case r.Method != http.MethodGet:
+		return fail("bad_request", http.StatusMethodNotAllowed, errors.New("websocket: upgrade requires GET"))
+	case !headerHasToken(r.Header, "Connection", "upgrade") || !headerHasToken(r.Header, "Upgrade", "websocket"):
+		return fail("bad_request", http.StatusUpgradeRequired, errors.New("websocket: not a websocket upgrade"))
+	case r.Header.Get("Sec-WebSocket-Version") != "13":
+		return fail("bad_request", http.StatusUpgradeRequired, errors.New("websocket: unsupported version"))
+	case key == "":
+		return fail("bad_request", http.StatusBadRequest, errors.New("websocket: missing Sec-WebSocket-Key"))
+	case !s.originAllowed(r):
+		return fail("forbidden", http.StatusForbidden, fmt.Errorf("websocket: origin %q not allowed", r.Header.Get("Origin")))
+	}
+
+	s.mu.Lock()
+	closing := s.closing
+	s.mu.Unlock()
+	if closing {
+		return fail("unavailable", http.StatusServiceUnavailable, ErrShuttingDown)
+	}
+
+	nc, brw, err := http.NewResponseController(w).Hijack()
+	if err != nil {
+		return fail("error", http.StatusInternalServerError, fmt.Errorf("websocket: %w", err))
+	}
+	sum := sha1.Sum([]byte(key + acceptGUID))
+	brw.Writer.WriteString("HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Accept: " +
+		base64.StdEncoding.EncodeToString(sum[:]) + "\r\n")
+	if id := requestid.FromContext(r.Context()); id != "" {
+		brw.Writer.WriteString(requestid.Header + ": " + id + "\r\n")
+	}
+	brw.Writer.WriteString("\r\n")
+	nc.SetWriteDeadline(time.Now().Add(s.cfg.writeTimeout))
+	if err := brw.Writer.Flush(); err != nil {
+		nc.Close()
+		wsUpgrades.WithLabelValues(s.cfg.name, "error").Inc()
+		return nil, fmt.Errorf("websocket: %w", err)
+	}
+	wsUpgrades.WithLabelValues(s.cfg.name, "ok").Inc()
+
+	c := newConn(s, r, nc, brw.Reader)
+	s.mu.Lock()
+	if s.closing {
+		// Shutdown started during the handshake.
+		s.mu.Unlock()
+		c.start()
+		c.Close(CloseGoingAway, "server shutting down")
+		return nil, ErrShuttingDown
+	}
+	s.conns[c] = struct{}{}
+	s.mu.Unlock()
+	wsConnections.WithLabelValues(s.cfg.name).Inc()
+	c.start()
+	return c, nil
+}
+
+// Connections returns the number of open connections.
+func (s *Server) Connections() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.conns)
+}
+
+// Shutdown refuses new upgrades and closes every connection with
+// CloseGoingAway, waiting until they are closed or ctx is done, when the
+// rest are dropped. Its signature fits shared.ShutdownManager.Register.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.mu.Lock()
+	s.closing = true
+	conns := make([]*Conn, 0, len(s.conns))
+	for c := range s.conns {
+		conns = append(conns, c)
+	}
+	s.mu.Unlock()
+
+	for _, c := range conns {
+		go c.Close(CloseGoingAway, "server shutting down")
+	}
+	for _, c := range conns {
+		select {
+		case <-c.done:
+		case <-ctx.Done():
+			for _, c := range conns {
+				c.nc.Close()
+			}
+			return fmt.Errorf("websocket: %d connections not closed cleanly: %w", s.Connections(), ctx.Err())
+		}
+	}
+	return nil
+}
+
+func (s *Server) remove(c *Conn) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.conns[c]; ok {
+		delete(s.conns, c)
+		wsConnections.WithLabelValues(s.cfg.name).Dec()
+	}
+}
+
+// originAllowed applies the origin policy of WithAllowedOrigins.
+func (s *Server) originAllowed(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	if u, err := url.Parse(origin); err == nil && strings.EqualFold(u.Host, r.Host) {
+		return true
+	}
+	return slices.Contains(s.cfg.origins, "*") || slices.Contains(s.cfg.origins, origin)
+}
+
+// headerHasToken reports whether a comma-separated header of h contains
+// token, case-insensitively.
+func headerHasToken(h http.Header, name, token string) bool {
+	for _, v := range h.Values(name) {
+		for _, t := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,285 @@
+package websocket
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base64"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/GoogleCloudPlatform/microservices-demo/src/shared/requestid"
+)
+
+var quiet = WithLogger(slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+// testClient is the client side of a connection, masking its frames as
+// browsers do.
+type testClient struct {
+	t  *testing.T
+	nc net.Conn
+	br *bufio.Reader
+	bw *bufio.Writer
+}
+
+func dial(t *testing.T, srv *httptest.Server, header http.Header) (*testClient, *http.Response) {
+	t.Helper()
+	nc, err := net.Dial("tcp", srv.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { nc.Close() })
+	nc.SetDeadline(time.Now().Add(5 * time.Second))
+
+	var k [16]byte
+	rand.Read(k[:])
+	key := base64.StdEncoding.EncodeToString(k[:])
+	req, _ := http.NewRequest(http.MethodGet, srv.URL+"/ws", nil)
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Sec-WebSocket-Version", "13")
+	req.Header.Set("Sec-WebSocket-Key", key)
+	for name, vs := range header {
+		req.Header[name] = vs
+	}
+	if err := req.Write(nc); err != nil {
+		t.Fatal(err)
+	}
+	br := bufio.NewReader(nc)
+	resp, err := http.ReadResponse(br, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		return nil, resp
+	}
+	sum := sha1.Sum([]byte(key + acceptGUID))
+	if got, want := resp.Header.Get("Sec-WebSocket-Accept"), base64.StdEncoding.EncodeToString(sum[:]); got != want {
+		t.Fatalf("Sec-WebSocket-Accept = %q, want %q", got, want)
+	}
+	return &testClient{t: t, nc: nc, br: br, bw: bufio.NewWriter(nc)}, resp
+}
+
+func (c *testClient) send(op byte, payload []byte) {
+	c.t.Helper()
+	var key [4]byte
+	rand.Read(key[:])
+	if err := writeFrame(c.bw, op, payload, true, key); err != nil {
+		c.t.Fatal(err)
+	}
+}
+
+func (c *testClient) recv() frame {
+	c.t.Helper()
+	f, err := readFrame(c.br, 1<<20, false)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	return f
+}
+
+// expectClose reads until a close frame and returns its code.
+func (c *testClient) expectClose() StatusCode {
+	c.t.Helper()
+	for {
+		f := c.recv()
+		if f.op == opClose {
+			code, _ := parseClose(f.payload)
+			return code
+		}
+	}
+}
+
+func TestEcho(t *testing.T) {
+	ws := NewServer(quiet)
+	srv := httptest.NewServer(ws.Handler(func(c *Conn) {
+		for {
+			typ, data, err := c.ReadMessage()
+			if err != nil {
+				return
+			}
+			if err := c.Send(c.Context(), typ, data); err != nil {
+				return
+			}
+		}
+	}))
+	defer srv.Close()
+
+	c, _ := dial(t, srv, nil)
+	c.send(opText, []byte("hello"))
+	if f := c.recv(); f.op != opText || string(f.payload) != "hello" {
+		t.Fatalf("got op %d %q, want text hello", f.op, f.payload)
+	}
+	c.send(opBinary, []byte(strings.Repeat("x", 40000)))
+	if f := c.recv(); f.op != opBinary || len(f.payload) != 40000 {
+		t.Fatalf("got op %d with %d bytes, want 40000 binary", f.op, len(f.payload))
+	}
+
+	c.send(opClose, closePayload(CloseNormal, "bye"))
+	if code := c.expectClose(); code != CloseNormal {
+		t.Errorf("close code = %d, want %d", code, CloseNormal)
+	}
+}
+
+func TestSendJSONAndRequestID(t *testing.T) {
+	ws := NewServer(quiet)
+	ids := make(chan string, 1)
+	srv := httptest.NewServer(requestid.Middleware(ws.Handler(func(c *Conn) {
+		ids <- requestid.FromContext(c.Context())
+		c.SendJSON(c.Context(), map[string]string{"status": "shipped"})
+	})))
+	defer srv.Close()
+
+	c, resp := dial(t, srv, http.Header{requestid.Header: {"order-42"}})
+	if got := resp.Header.Get(requestid.Header); got != "order-42" {
+		t.Errorf("%s = %q, want order-42", requestid.Header, got)
+	}
+	if got := <-ids; got != "order-42" {
+		t.Errorf("request ID in connection context = %q, want order-42", got)
+	}
+	if f := c.recv(); string(f.payload) != `{"status":"shipped"}` {
+		t.Errorf("got %q", f.payload)
+	}
+	// The queued message goes out before the close frame.
+	if code := c.expectClose(); code != CloseNormal {
+		t.Errorf("close code = %d, want %d", code, CloseNormal)
+	}
+}
+
+func TestOriginCheck(t *testing.T) {
+	ws := NewServer(quiet, WithAllowedOrigins("https://shop.example.com"))
+	srv := httptest.NewServer(ws.Handler(func(c *Conn) {}))
+	defer srv.Close()
+
+	tests := []struct {
+		origin string
+		want   int
+	}{
+		{"", http.StatusSwitchingProtocols},
+		{"https://shop.example.com", http.StatusSwitchingProtocols},
+		{"http://" + srv.Listener.Addr().String(), http.StatusSwitchingProtocols},
+		{"https://evil.example.com", http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		h := http.Header{}
+		if tt.origin != "" {
+			h.Set("Origin", tt.origin)
+		}
+		if _, resp := dial(t, srv, h); resp.StatusCode != tt.want {
+			t.Errorf("Origin %q: status = %d, want %d", tt.origin, resp.StatusCode, tt.want)
+		}
+	}
+}
+
+func TestRejectsPlainRequest(t *testing.T) {
+	ws := NewServer(quiet)
+	srv := httptest.NewServer(ws.Handler(func(c *Conn) {}))
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUpgradeRequired || resp.Header.Get("Sec-WebSocket-Version") != "13" {
+		t.Errorf("status = %d, Sec-WebSocket-Version = %q", resp.StatusCode, resp.Header.Get("Sec-WebSocket-Version"))
+	}
+}
+
+func TestPingPong(t *testing.T) {
+	ws := NewServer(quiet, WithPingInterval(30*time.Millisecond))
+	srv := httptest.NewServer(ws.Handler(func(c *Conn) { <-c.Context().Done() }))
+	defer srv.Close()
+
+	c, _ := dial(t, srv, nil)
+	c.send(opPing, []byte("are you there"))
+	var sawPong, sawPing bool
+	for !sawPong || !sawPing {
+		switch f := c.recv(); f.op {
+		case opPong:
+			if string(f.payload) != "are you there" {
+				t.Errorf("pong payload = %q", f.payload)
+			}
+			sawPong = true
+		case opPing:
+			sawPing = true
+		}
+	}
+}
+
+func TestDropsSilentPeer(t *testing.T) {
+	ws := NewServer(quiet, WithPingInterval(20*time.Millisecond), WithPongTimeout(20*time.Millisecond))
+	done := make(chan error, 1)
+	srv := httptest.NewServer(ws.Handler(func(c *Conn) {
+		_, _, err := c.ReadMessage()
+		done <- err
+	}))
+	defer srv.Close()
+
+	dial(t, srv, nil) // never reads nor answers
+	select {
+	case err := <-done:
+		var ce *CloseError
+		if !errors.As(err, &ce) || ce.Code != CloseAbnormal {
+			t.Errorf("ReadMessage() error = %v, want CloseAbnormal", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("silent peer was not dropped")
+	}
+}
+
+func TestMessageTooBig(t *testing.T) {
+	ws := NewServer(quiet, WithReadLimit(16))
+	srv := httptest.NewServer(ws.Handler(func(c *Conn) {
+		for {
+			if _, _, err := c.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}))
+	defer srv.Close()
+
+	c, _ := dial(t, srv, nil)
+	c.send(opText, []byte(strings.Repeat("x", 17)))
+	if code := c.expectClose(); code != CloseMessageTooBig {
+		t.Errorf("close code = %d, want %d", code, CloseMessageTooBig)
+	}
+}
+
+func TestShutdown(t *testing.T) {
+	ws := NewServer(quiet)
+	srv := httptest.NewServer(ws.Handler(func(c *Conn) { <-c.Context().Done() }))
+	defer srv.Close()
+
+	c, _ := dial(t, srv, nil)
+	for ws.Connections() != 1 {
+		time.Sleep(time.Millisecond)
+	}
+	errc := make(chan error, 1)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		errc <- ws.Shutdown(ctx)
+	}()
+	if code := c.expectClose(); code != CloseGoingAway {
+		t.Errorf("close code = %d, want %d", code, CloseGoingAway)
+	}
+	c.send(opClose, closePayload(CloseGoingAway, ""))
+	if err := <-errc; err != nil {
+		t.Errorf("Shutdown() = %v", err)
+	}
+	if n := ws.Connections(); n != 0 {
+		t.Errorf("Connections() = %d after Shutdown", n)
+	}
+	if _, resp := dial(t, srv, nil); resp.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("upgrade after Shutdown: status = %d, want 503", resp.StatusCode)
+	}
+}
@@ -0,0 +1,232 @@
+package shared
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/GoogleCloudPlatform/microservices-demo/src/shared/metrics"
+	"github.com/GoogleCloudPlatform/microservices-demo/src/shared/recovery"
+)
+
+var (
+	workerQueueDepth = metrics.NewGaugeVec("worker_pool_queue_depth",
+		"Tasks waiting in a worker pool's queue.", "pool")
+	workerBusy = metrics.NewGaugeVec("worker_pool_busy_workers",
+		"Workers currently running a task.", "pool")
+	workerTasksTotal = metrics.NewCounterVec("worker_pool_tasks_total",
+		"Tasks processed by a worker pool, by result (ok, error, panic).", "pool", "result")
+	workerTaskSeconds = metrics.NewHistogramVec("worker_pool_task_seconds",
+		"Task processing time in seconds.", nil, "pool")
+)
+
+// Errors returned when submitting to a WorkerPool.
+var (
+	ErrPoolClosed = errors.New("worker pool is shut down")
+	ErrQueueFull  = errors.New("worker pool queue is full")
+)
+
+// DefaultWorkerPoolDrainTimeout bounds WorkerPool.RegisterShutdown's hook.
+const DefaultWorkerPoolDrainTimeout = 30 * time.Second
+
+type workerPoolConfig struct {
+	name         string
+	queueSize    int
+	drainTimeout time.Duration
+	onError      func(task any, err error)
+}
+
+// WorkerPoolOption configures a WorkerPool.
+type WorkerPoolOption func(*workerPoolConfig)
+
+// WithWorkerPoolName sets the pool label on metrics and logs (default
+// "default").
+func WithWorkerPoolName(name string) WorkerPoolOption {
+	return func(c *workerPoolConfig) { c.name = name }
+}
+
+// WithQueueSize bounds the number of queued tasks (default 10 per worker).
+// Submit blocks and TrySubmit fails while the queue is full.
+func WithQueueSize(n int) WorkerPoolOption {
+	return func(c *workerPoolConfig) { c.queueSize = n }
+}
+
+// WithWorkerPoolDrainTimeout sets the timeout of the hook added by
+// RegisterShutdown (default DefaultWorkerPoolDrainTimeout).
+func WithWorkerPoolDrainTimeout(d time.Duration) WorkerPoolOption {
+	return func(c *workerPoolConfig) { c.drainTimeout = d }
+}
+
+// WithTaskErrorHandler is called with each task whose handler failed or
+// panicked; by default failures are logged.
+func WithTaskErrorHandler(fn func(task any, err error)) WorkerPoolOption {
+	return func(c *workerPoolConfig) { c.onError = fn }
+}
+
+// WorkerPool runs tasks on a fixed number of goroutines fed by a bounded
+// queue. A panicking task is recovered and reported like a failed one, so
+// one bad job cannot take down the process. On shutdown the pool stops
+// accepting work and finishes everything already queued:
+//
+//	pool := shared.NewWorkerPool(4, func(ctx context.Context, o Order) error {
+//	    return sendConfirmation(ctx, o)
+//	}, shared.WithWorkerPoolName("email"), shared.WithQueueSize(100))
+//	pool.RegisterShutdown(sm)
+//	...
+//	if err := pool.Submit(ctx, order); err != nil { ... }
+type WorkerPool[T any] struct {
+	cfg     workerPoolConfig
+	handler func(ctx context.Context, task T) error
+	queue   chan T
+
+	// ctx is passed to handlers and cancelled when a drain times out.
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mu      sync.RWMutex // guards closed against concurrent sends
+	closed  bool
+	workers sync.WaitGroup
+	done    chan struct{}
+}
+
+// NewWorkerPool starts n workers calling handler for each submitted task.
+func NewWorkerPool[T any](n int, handler func(ctx context.Context, task T) error, opts ...WorkerPoolOption) *WorkerPool[T] {
+	if n < 1 {
+		n = 1
+	}
+	cfg := workerPoolConfig{name: "default", queueSize: 10 * n, drainTimeout: DefaultWorkerPoolDrainTimeout}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	if cfg.queueSize < 0 {
+		cfg.queueSize = 0
+	}
+	if cfg.onError == nil {
+		name := cfg.name
+		cfg.onError = func(task any, err error) {
+			log.Printf("Worker: Pool %q task failed: %v", name, err)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	p := &WorkerPool[T]{
+		cfg:     cfg,
+		handler: handler,
+		queue:   make(chan T, cfg.queueSize),
+		ctx:     ctx,
+		cancel:  cancel,
+		done:    make(chan struct{}),
+	}
+	p.workers.Add(n)
+	for range n {
+		go p.work()
+	}
+	go func() {
+		p.workers.Wait()
+		cancel()
+		close(p.done)
+	}()
+	return p
+}
+
+// Submit queues task, blocking while the queue is full until ctx is done.
+func (p *WorkerPool[T]) Submit(ctx context.Context, task T) error {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if p.closed {
+		return ErrPoolClosed
+	}
+	select {
+	case p.queue <- task:
+		workerQueueDepth.WithLabelValues(p.cfg.name).Set(float64(len(p.queue)))
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// TrySubmit queues task without blocking, failing with ErrQueueFull when
+// the queue has no room.
+func (p *WorkerPool[T]) TrySubmit(task T) error {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if p.closed {
+		return ErrPoolClosed
+	}
+	select {
+	case p.queue <- task:
+		workerQueueDepth.WithLabelValues(p.cfg.name).Set(float64(len(p.queue)))
+		return nil
+	default:
+		return ErrQueueFull
+	}
+}
+
+// QueueLen returns the number of tasks waiting for a worker.
+func (p *WorkerPool[T]) QueueLen() int { return len(p.queue) }
+
+func (p *WorkerPool[T]) work() {
+	defer p.workers.Done()
+	for task := range p.queue {
+		workerQueueDepth.WithLabelValues(p.cfg.name).Set(float64(len(p.queue)))
+		p.run(task)
+	}
+}
+
+// run executes one task, converting a panic into an error.
+func (p *WorkerPool[T]) run(task T) {
+	workerBusy.WithLabelValues(p.cfg.name).Inc()
+	defer workerBusy.WithLabelValues(p.cfg.name).Dec()
+	start := time.Now()
+	result := "ok"
+	defer func() {
+		if r := recover(); r != nil {
+			result = "panic"
+			frames := recovery.Stack(2)
+			where := ""
+			if len(frames) > 0 {
+				where = fmt.Sprintf(" at %s (%s:%d)", frames[0].Function, frames[0].File, frames[0].Line)
+			}
+			p.cfg.onError(task, fmt.Errorf("panic: %v%s", r, where))
+		}
+		metrics.Observe(p.ctx, workerTaskSeconds.WithLabelValues(p.cfg.name), time.Since(start).Seconds())
+		workerTasksTotal.WithLabelValues(p.cfg.name, result).Inc()
+	}()
+	if err := p.handler(p.ctx, task); err != nil {
+		result = "error"
+		p.cfg.onError(task, err)
+	}
+}
+
+// Shutdown stops accepting tasks and waits for the queued and running ones
+// to finish. If ctx ends first, the context passed to handlers is cancelled
+// and Shutdown returns ctx's error; tasks still queued are then run with a
+// cancelled context so they can fail fast. Calling Shutdown again waits for
+// the same drain.
+func (p *WorkerPool[T]) Shutdown(ctx context.Context) error {
+	p.mu.Lock()
+	if !p.closed {
+		p.closed = true
+		close(p.queue)
+	}
+	p.mu.Unlock()
+
+	select {
+	case <-p.done:
+		return nil
+	case <-ctx.Done():
+		p.cancel()
+		log.Printf("Worker: Pool %q drain interrupted with %d tasks queued", p.cfg.name, len(p.queue))
+		return fmt.Errorf("worker pool %q: %w", p.cfg.name, ctx.Err())
+	}
+}
+
+// RegisterShutdown registers Shutdown with m, bounded by the drain timeout.
+// Register the pool before the servers that submit to it: hooks run in LIFO
+// order, so the servers stop taking requests before the pool drains.
+func (p *WorkerPool[T]) RegisterShutdown(m *ShutdownManager) {
+	m.RegisterWithTimeout("worker-pool-"+p.cfg.name, p.cfg.drainTimeout, p.Shutdown)
+}
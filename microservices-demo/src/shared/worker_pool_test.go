@@ -0,0 +1,111 @@
+package shared
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestWorkerPoolProcessesAndDrains(t *testing.T) {
+	var processed atomic.Int32
+	p := NewWorkerPool(3, func(ctx context.Context, n int) error {
+		time.Sleep(time.Millisecond)
+		processed.Add(1)
+		return nil
+	}, WithWorkerPoolName("test-drain"), WithQueueSize(100))
+
+	for i := range 50 {
+		if err := p.Submit(context.Background(), i); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := p.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if n := processed.Load(); n != 50 {
+		t.Errorf("processed %d tasks before Shutdown returned, want 50", n)
+	}
+	if err := p.Submit(context.Background(), 1); !errors.Is(err, ErrPoolClosed) {
+		t.Errorf("Submit after Shutdown = %v, want ErrPoolClosed", err)
+	}
+}
+
+func TestWorkerPoolRecoversPanics(t *testing.T) {
+	var mu sync.Mutex
+	var errs []error
+	p := NewWorkerPool(1, func(ctx context.Context, n int) error {
+		if n == 0 {
+			panic("bad task")
+		}
+		if n == 1 {
+			return errors.New("failed")
+		}
+		return nil
+	}, WithWorkerPoolName("test-panic"), WithTaskErrorHandler(func(task any, err error) {
+		mu.Lock()
+		errs = append(errs, err)
+		mu.Unlock()
+	}))
+	for i := range 3 {
+		p.Submit(context.Background(), i)
+	}
+	p.Shutdown(context.Background())
+
+	if len(errs) != 2 {
+		t.Fatalf("errors = %v, want a panic and a failure", errs)
+	}
+	if !strings.Contains(errs[0].Error(), "panic: bad task") || !strings.Contains(errs[0].Error(), "TestWorkerPoolRecoversPanics") {
+		t.Errorf("panic error = %q, want the panic value and location", errs[0])
+	}
+}
+
+func TestWorkerPoolBoundedQueue(t *testing.T) {
+	release := make(chan struct{})
+	p := NewWorkerPool(1, func(ctx context.Context, n int) error {
+		<-release
+		return nil
+	}, WithWorkerPoolName("test-bounded"), WithQueueSize(1))
+	defer func() { close(release); p.Shutdown(context.Background()) }()
+
+	p.Submit(context.Background(), 1) // picked up by the worker
+	deadline := time.Now().Add(5 * time.Second)
+	for p.QueueLen() != 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if err := p.TrySubmit(2); err != nil {
+		t.Fatalf("TrySubmit into empty queue: %v", err)
+	}
+	if err := p.TrySubmit(3); !errors.Is(err, ErrQueueFull) {
+		t.Errorf("TrySubmit into full queue = %v, want ErrQueueFull", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := p.Submit(ctx, 4); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Submit into full queue = %v, want DeadlineExceeded", err)
+	}
+}
+
+func TestWorkerPoolShutdownTimeoutCancelsTasks(t *testing.T) {
+	cancelled := make(chan struct{})
+	p := NewWorkerPool(1, func(ctx context.Context, n int) error {
+		<-ctx.Done()
+		close(cancelled)
+		return ctx.Err()
+	}, WithWorkerPoolName("test-timeout"), WithTaskErrorHandler(func(any, error) {}))
+	p.Submit(context.Background(), 1)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := p.Shutdown(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Shutdown = %v, want DeadlineExceeded", err)
+	}
+	select {
+	case <-cancelled:
+	case <-time.After(5 * time.Second):
+		t.Fatal("task context not cancelled after drain timeout")
+	}
+}